@@ -1,28 +1,68 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/gob"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
 	"gfs"
+	"gfs/auth"
 	"gfs/chunkserver"
 	"gfs/client"
+	"gfs/clientfake"
+	"gfs/config"
+	"gfs/daemon"
+	gfsfuse "gfs/fuse"
+	"gfs/gateway/s3"
+	"gfs/gateway/webhdfs"
 	"gfs/master"
+	"gfs/pb"
+	"gfs/recordio"
+	gfstesting "gfs/testing"
 	"gfs/util"
 	"reflect"
+	"runtime"
 
+	"bazil.org/fuse"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"io"
 	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
 	//"math/rand"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 var (
+	ctx   = context.Background()
 	m     *master.Master
 	cs    []*chunkserver.ChunkServer
 	c     *client.Client
@@ -48,11 +88,11 @@ func errorAll(ch chan error, n int, t *testing.T) {
  *  TEST SUITE 1 - Basic File Operation
  */
 func TestCreateFile(t *testing.T) {
-	err := m.RPCCreateFile(gfs.CreateFileArg{"/test1.txt"}, &gfs.CreateFileReply{})
+	err := m.RPCCreateFile(gfs.CreateFileArg{"/test1.txt", 0, gfs.Credentials{}}, &gfs.CreateFileReply{})
 	if err != nil {
 		t.Error(err)
 	}
-	err = m.RPCCreateFile(gfs.CreateFileArg{"/test1.txt"}, &gfs.CreateFileReply{})
+	err = m.RPCCreateFile(gfs.CreateFileArg{"/test1.txt", 0, gfs.Credentials{}}, &gfs.CreateFileReply{})
 	if err == nil {
 		t.Error("the same file has been created twice")
 	}
@@ -60,20 +100,20 @@ func TestCreateFile(t *testing.T) {
 
 func TestMkdirDeleteList(t *testing.T) {
 	ch := make(chan error, 9)
-	ch <- m.RPCMkdir(gfs.MkdirArg{"/dir1"}, &gfs.MkdirReply{})
-	ch <- m.RPCMkdir(gfs.MkdirArg{"/dir2"}, &gfs.MkdirReply{})
-	ch <- m.RPCCreateFile(gfs.CreateFileArg{"/file1.txt"}, &gfs.CreateFileReply{})
-	ch <- m.RPCCreateFile(gfs.CreateFileArg{"/file2.txt"}, &gfs.CreateFileReply{})
-	ch <- m.RPCCreateFile(gfs.CreateFileArg{"/dir1/file3.txt"}, &gfs.CreateFileReply{})
-	ch <- m.RPCCreateFile(gfs.CreateFileArg{"/dir1/file4.txt"}, &gfs.CreateFileReply{})
-	ch <- m.RPCCreateFile(gfs.CreateFileArg{"/dir2/file5.txt"}, &gfs.CreateFileReply{})
-
-	err := m.RPCCreateFile(gfs.CreateFileArg{"/dir2/file5.txt"}, &gfs.CreateFileReply{})
+	ch <- m.RPCMkdir(gfs.MkdirArg{"/dir1", gfs.Credentials{}}, &gfs.MkdirReply{})
+	ch <- m.RPCMkdir(gfs.MkdirArg{"/dir2", gfs.Credentials{}}, &gfs.MkdirReply{})
+	ch <- m.RPCCreateFile(gfs.CreateFileArg{"/file1.txt", 0, gfs.Credentials{}}, &gfs.CreateFileReply{})
+	ch <- m.RPCCreateFile(gfs.CreateFileArg{"/file2.txt", 0, gfs.Credentials{}}, &gfs.CreateFileReply{})
+	ch <- m.RPCCreateFile(gfs.CreateFileArg{"/dir1/file3.txt", 0, gfs.Credentials{}}, &gfs.CreateFileReply{})
+	ch <- m.RPCCreateFile(gfs.CreateFileArg{"/dir1/file4.txt", 0, gfs.Credentials{}}, &gfs.CreateFileReply{})
+	ch <- m.RPCCreateFile(gfs.CreateFileArg{"/dir2/file5.txt", 0, gfs.Credentials{}}, &gfs.CreateFileReply{})
+
+	err := m.RPCCreateFile(gfs.CreateFileArg{"/dir2/file5.txt", 0, gfs.Credentials{}}, &gfs.CreateFileReply{})
 	if err == nil {
 		t.Error("the same file has been created twice")
 	}
 
-	err = m.RPCMkdir(gfs.MkdirArg{"/dir1"}, &gfs.MkdirReply{})
+	err = m.RPCMkdir(gfs.MkdirArg{"/dir1", gfs.Credentials{}}, &gfs.MkdirReply{})
 	if err == nil {
 		t.Error("the same dirctory has been created twice")
 	}
@@ -85,7 +125,7 @@ func TestMkdirDeleteList(t *testing.T) {
 	todelete["file2.txt"] = true
 
 	var l gfs.ListReply
-	ch <- m.RPCList(gfs.ListArg{"/"}, &l)
+	ch <- m.RPCList(gfs.ListArg{"/", "", 0, "", "", gfs.Credentials{}}, &l)
 	for _, v := range l.Files {
 		delete(todelete, v.Name)
 	}
@@ -95,7 +135,7 @@ func TestMkdirDeleteList(t *testing.T) {
 
 	todelete["file3.txt"] = true
 	todelete["file4.txt"] = true
-	ch <- m.RPCList(gfs.ListArg{"/dir1"}, &l)
+	ch <- m.RPCList(gfs.ListArg{"/dir1", "", 0, "", "", gfs.Credentials{}}, &l)
 	for _, v := range l.Files {
 		delete(todelete, v.Name)
 	}
@@ -109,11 +149,11 @@ func TestMkdirDeleteList(t *testing.T) {
 func TestRPCGetChunkHandle(t *testing.T) {
 	var r1, r2 gfs.GetChunkHandleReply
 	path := gfs.Path("/test1.txt")
-	err := m.RPCGetChunkHandle(gfs.GetChunkHandleArg{path, 0}, &r1)
+	err := m.RPCGetChunkHandle(gfs.GetChunkHandleArg{path, 0, false, gfs.Credentials{}}, &r1)
 	if err != nil {
 		t.Error(err)
 	}
-	err = m.RPCGetChunkHandle(gfs.GetChunkHandleArg{path, 0}, &r2)
+	err = m.RPCGetChunkHandle(gfs.GetChunkHandleArg{path, 0, false, gfs.Credentials{}}, &r2)
 	if err != nil {
 		t.Error(err)
 	}
@@ -121,7 +161,7 @@ func TestRPCGetChunkHandle(t *testing.T) {
 		t.Error("got different handle: %v and %v", r1.Handle, r2.Handle)
 	}
 
-	err = m.RPCGetChunkHandle(gfs.GetChunkHandleArg{path, 2}, &r2)
+	err = m.RPCGetChunkHandle(gfs.GetChunkHandleArg{path, 2, false, gfs.Credentials{}}, &r2)
 	if err == nil {
 		t.Error("discontinuous chunk should not be created")
 	}
@@ -131,11 +171,11 @@ func TestWriteChunk(t *testing.T) {
 	var r1 gfs.GetChunkHandleReply
 	p := gfs.Path("/TestWriteChunk.txt")
 	ch := make(chan error, N+2)
-	ch <- m.RPCCreateFile(gfs.CreateFileArg{p}, &gfs.CreateFileReply{})
-	ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0}, &r1)
+	ch <- m.RPCCreateFile(gfs.CreateFileArg{p, 0, gfs.Credentials{}}, &gfs.CreateFileReply{})
+	ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0, false, gfs.Credentials{}}, &r1)
 	for i := 0; i < N; i++ {
 		go func(x int) {
-			ch <- c.WriteChunk(r1.Handle, gfs.Offset(x*2), []byte(fmt.Sprintf("%2d", x)))
+			ch <- c.WriteChunk(ctx, r1.Handle, gfs.Offset(x*2), []byte(fmt.Sprintf("%2d", x)))
 		}(i)
 	}
 	errorAll(ch, N+2, t)
@@ -145,11 +185,11 @@ func TestReadChunk(t *testing.T) {
 	var r1 gfs.GetChunkHandleReply
 	p := gfs.Path("/TestWriteChunk.txt")
 	ch := make(chan error, N+1)
-	ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0}, &r1)
+	ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0, false, gfs.Credentials{}}, &r1)
 	for i := 0; i < N; i++ {
 		go func(x int) {
 			buf := make([]byte, 2)
-			n, err := c.ReadChunk(r1.Handle, gfs.Offset(x*2), buf)
+			n, err := c.ReadChunk(ctx, r1.Handle, gfs.Offset(x*2), buf)
 			ch <- err
 			expected := []byte(fmt.Sprintf("%2d", x))
 			if n != 2 {
@@ -162,22 +202,51 @@ func TestReadChunk(t *testing.T) {
 	errorAll(ch, N+1, t)
 }
 
+// reads are clamped to the committed length of the chunk
+func TestReadChunkEOF(t *testing.T) {
+	var r1 gfs.GetChunkHandleReply
+	var l gfs.GetReplicasReply
+	p := gfs.Path("/TestWriteChunk.txt")
+	ch := make(chan error, 2)
+	ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0, false, gfs.Credentials{}}, &r1)
+	ch <- m.RPCGetReplicas(gfs.GetReplicasArg{r1.Handle, gfs.Credentials{}}, &l)
+	errorAll(ch, 2, t)
+
+	for _, addr := range l.Locations {
+		var r gfs.ReadChunkReply
+		err := util.Call(ctx, addr, "ChunkServer.RPCReadChunk", gfs.ReadChunkArg{r1.Handle, 0, N*2 + 10, "", ""}, &r)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if r.Length != N*2 || r.ChunkLength != N*2 || r.ErrorCode != gfs.ReadEOF || r.Version <= 0 {
+			t.Error("expect", N*2, "bytes and read EOF, got", r.Length, r.ChunkLength, r.Version, r.ErrorCode)
+		}
+
+		r = gfs.ReadChunkReply{}
+		err = util.Call(ctx, addr, "ChunkServer.RPCReadChunk", gfs.ReadChunkArg{r1.Handle, N*2 + 5, 1, "", ""}, &r)
+		if err != nil || r.Length != 0 || r.ErrorCode != gfs.ReadEOF {
+			t.Error("expect read EOF past the committed length, got", r.Length, r.ErrorCode, err)
+		}
+	}
+}
+
 // check if the content of replicas are the same, returns the number of replicas
 func checkReplicas(handle gfs.ChunkHandle, length int, t *testing.T) int {
 	var data [][]byte
 
 	// get replicas location from master
 	var l gfs.GetReplicasReply
-	err := m.RPCGetReplicas(gfs.GetReplicasArg{handle}, &l)
+	err := m.RPCGetReplicas(gfs.GetReplicasArg{handle, gfs.Credentials{}}, &l)
 	if err != nil {
 		t.Error(err)
 	}
 
 	// read
-	args := gfs.ReadChunkArg{handle, 0, length}
+	args := gfs.ReadChunkArg{handle, 0, length, "", ""}
 	for _, addr := range l.Locations {
 		var r gfs.ReadChunkReply
-		err := util.Call(addr, "ChunkServer.RPCReadChunk", args, &r)
+		err := util.Call(ctx, addr, "ChunkServer.RPCReadChunk", args, &r)
 		if err == nil {
 			data = append(data, r.Data)
 			//fmt.Println("find in ", addr)
@@ -198,7 +267,7 @@ func TestReplicaEquality(t *testing.T) {
 	var r1 gfs.GetChunkHandleReply
 	var data [][]byte
 	p := gfs.Path("/TestWriteChunk.txt")
-	m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0}, &r1)
+	m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0, false, gfs.Credentials{}}, &r1)
 
 	n := checkReplicas(r1.Handle, N*2, t)
 	if n != gfs.DefaultNumReplicas {
@@ -210,8 +279,8 @@ func TestAppendChunk(t *testing.T) {
 	var r1 gfs.GetChunkHandleReply
 	p := gfs.Path("/TestAppendChunk.txt")
 	ch := make(chan error, 2*N+2)
-	ch <- m.RPCCreateFile(gfs.CreateFileArg{p}, &gfs.CreateFileReply{})
-	ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0}, &r1)
+	ch <- m.RPCCreateFile(gfs.CreateFileArg{p, 0, gfs.Credentials{}}, &gfs.CreateFileReply{})
+	ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0, false, gfs.Credentials{}}, &r1)
 	expected := make(map[int][]byte)
 	for i := 0; i < N; i++ {
 		expected[i] = []byte(fmt.Sprintf("%3d", i))
@@ -221,7 +290,7 @@ func TestAppendChunk(t *testing.T) {
 	wg.Add(N)
 	for i := 0; i < N; i++ {
 		go func(x int) {
-			_, err := c.AppendChunk(r1.Handle, expected[x])
+			_, err := c.AppendChunk(ctx, r1.Handle, expected[x])
 			ch <- err
 			wg.Done()
 		}(i)
@@ -232,7 +301,7 @@ func TestAppendChunk(t *testing.T) {
 
 	for x := 0; x < N; x++ {
 		buf := make([]byte, 3)
-		n, err := c.ReadChunk(r1.Handle, gfs.Offset(x*3), buf)
+		n, err := c.ReadChunk(ctx, r1.Handle, gfs.Offset(x*3), buf)
 		ch <- err
 		if n != 3 {
 			t.Error("should read exactly 2 bytes but", n, "instead")
@@ -258,38 +327,4438 @@ func TestAppendChunk(t *testing.T) {
 	errorAll(ch, 2*N+2, t)
 }
 
-/*
- *  TEST SUITE 2 - Client API
- */
+// the primary should be granted in the failure domain of the writers
+func TestLeaseFailureDomain(t *testing.T) {
+	for i, v := range cs {
+		v.SetFailureDomain(fmt.Sprintf("rack%v", i))
+	}
+	defer func() {
+		for _, v := range cs {
+			v.SetFailureDomain("")
+		}
+	}()
+	time.Sleep(2 * gfs.HeartbeatInterval)
+
+	var r1 gfs.GetChunkHandleReply
+	p := gfs.Path("/TestLeaseFailureDomain.txt")
+	ch := make(chan error, 4)
+	ch <- m.RPCCreateFile(gfs.CreateFileArg{p, 0, gfs.Credentials{}}, &gfs.CreateFileReply{})
+	ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0, false, gfs.Credentials{}}, &r1)
+
+	var l gfs.GetReplicasReply
+	ch <- m.RPCGetReplicas(gfs.GetReplicasArg{r1.Handle, gfs.Credentials{}}, &l)
+	if len(l.Locations) == 0 {
+		t.Fatal("no replica for", r1.Handle)
+	}
+	target := l.Locations[len(l.Locations)-1]
+	domain := ""
+	for i, v := range csAdd {
+		if v == target {
+			domain = fmt.Sprintf("rack%v", i)
+		}
+	}
+
+	var r2 gfs.GetPrimaryAndSecondariesReply
+	ch <- m.RPCGetPrimaryAndSecondaries(gfs.GetPrimaryAndSecondariesArg{r1.Handle, domain, gfs.Credentials{}, ""}, &r2)
+	if r2.Primary != target {
+		t.Error("expect primary", target, "in", domain, "got", r2.Primary)
+	}
+
+	errorAll(ch, 4, t)
+}
+
+// primaryRecorder chooses the last candidate as primary and keeps them
+type primaryRecorder struct {
+	sync.Mutex
+	candidates []master.PrimaryCandidate
+}
+
+func (r *primaryRecorder) ChoosePrimary(handle gfs.ChunkHandle, candidates []master.PrimaryCandidate) gfs.ServerAddress {
+	r.Lock()
+	defer r.Unlock()
+	r.candidates = append([]master.PrimaryCandidate(nil), candidates...)
+	return candidates[len(candidates)-1].Address
+}
+
+// the primaries of new leases are chosen by the primary policy of master
+func TestPrimaryPolicy(t *testing.T) {
+	r := &primaryRecorder{}
+	m.SetPrimaryPolicy(r)
+	defer m.SetPrimaryPolicy(nil)
+
+	p := gfs.Path("/TestPrimaryPolicy.txt")
+	var r1 gfs.GetChunkHandleReply
+	ch := make(chan error, 3)
+	ch <- m.RPCCreateFile(gfs.CreateFileArg{p, 0, gfs.Credentials{}}, &gfs.CreateFileReply{})
+	ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0, false, gfs.Credentials{}}, &r1)
+	var r2 gfs.GetPrimaryAndSecondariesReply
+	ch <- m.RPCGetPrimaryAndSecondaries(gfs.GetPrimaryAndSecondariesArg{r1.Handle, "", gfs.Credentials{}, ""}, &r2)
+	errorAll(ch, 3, t)
+
+	r.Lock()
+	candidates := r.candidates
+	r.Unlock()
+	if len(candidates) != gfs.DefaultNumReplicas {
+		t.Fatal("expect the replicas as candidates, got", candidates)
+	}
+	if want := candidates[len(candidates)-1].Address; r2.Primary != want {
+		t.Error("expect primary", want, "chosen by the policy, got", r2.Primary)
+	}
+	for _, v := range candidates {
+		if v.Draining || v.Length != 0 || v.Previous {
+			t.Error("expect an up-to-date empty replica, got", v)
+		}
+	}
+}
+
+// replicas are placed on chunkservers matching the placement constraints of the file
+func TestPlacementConstraint(t *testing.T) {
+	for i, v := range cs {
+		if i < 3 {
+			v.SetLabels(map[string]string{"disk": "ssd"})
+		} else {
+			v.SetLabels(map[string]string{"disk": "hdd"})
+		}
+	}
+	defer func() {
+		for _, v := range cs {
+			v.SetLabels(nil)
+		}
+	}()
+	time.Sleep(2 * gfs.HeartbeatInterval)
+
+	replicas := func(p gfs.Path, constraints ...gfs.PlacementConstraint) (map[gfs.ServerAddress]bool, error) {
+		if err := m.RPCCreateFile(gfs.CreateFileArg{p, 0, gfs.Credentials{}}, &gfs.CreateFileReply{}); err != nil {
+			return nil, err
+		}
+		if err := m.RPCSetPlacement(gfs.SetPlacementArg{p, constraints, gfs.Credentials{}}, &gfs.SetPlacementReply{}); err != nil {
+			return nil, err
+		}
+		var r1 gfs.GetChunkHandleReply
+		if err := m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0, false, gfs.Credentials{}}, &r1); err != nil {
+			return nil, err
+		}
+		var l gfs.GetReplicasReply
+		err := m.RPCGetReplicas(gfs.GetReplicasArg{r1.Handle, gfs.Credentials{}}, &l)
+		ret := make(map[gfs.ServerAddress]bool)
+		for _, v := range l.Locations {
+			ret[v] = true
+		}
+		return ret, err
+	}
+
+	ret, err := replicas("/TestPlacementMust.txt", gfs.PlacementConstraint{gfs.PlacementMust, "disk=ssd"})
+	if err != nil || len(ret) != 3 || !ret[csAdd[0]] || !ret[csAdd[1]] || !ret[csAdd[2]] {
+		t.Error("expect replicas on ssd servers", csAdd[:3], "got", ret, err)
+	}
+
+	ret, err = replicas("/TestPlacementPrefer.txt", gfs.PlacementConstraint{gfs.PlacementPrefer, "disk=hdd"})
+	if err != nil || len(ret) != 3 || !ret[csAdd[3]] || !ret[csAdd[4]] {
+		t.Error("expect replicas on hdd servers", csAdd[3:], "got", ret, err)
+	}
+
+	ret, err = replicas("/TestPlacementAvoid.txt", gfs.PlacementConstraint{gfs.PlacementAvoid, "disk"})
+	if err != nil || len(ret) != 3 {
+		t.Error("avoid constraint should not prevent placement, got", ret, err)
+	}
+
+	_, err = replicas("/TestPlacementNotEnough.txt", gfs.PlacementConstraint{gfs.PlacementMust, "disk=nvme"})
+	if !errors.Is(err, gfs.NotEnoughServers) {
+		t.Error("expect not enough servers, got", err)
+	}
+}
+
+// replicas are spread across racks when possible, topologies set on master override reported ones
+func TestTopology(t *testing.T) {
+	racks := []string{"r1", "r1", "r1", "r2", "r3"}
+	for i, v := range cs {
+		v.SetTopology(gfs.Topology{Zone: "a", Rack: racks[i]})
+	}
+	defer func() {
+		for i, v := range cs {
+			v.SetTopology(gfs.Topology{})
+			m.RPCSetTopology(gfs.SetTopologyArg{csAdd[i], gfs.Topology{}, gfs.Credentials{}}, &gfs.SetTopologyReply{})
+		}
+	}()
+	time.Sleep(2 * gfs.HeartbeatInterval)
+
+	rackOf := make(map[gfs.ServerAddress]string)
+	replicaRacks := func(p gfs.Path) map[string]int {
+		var r1 gfs.GetChunkHandleReply
+		ch := make(chan error, 3)
+		ch <- m.RPCCreateFile(gfs.CreateFileArg{p, 0, gfs.Credentials{}}, &gfs.CreateFileReply{})
+		ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0, false, gfs.Credentials{}}, &r1)
+		var l gfs.GetReplicasReply
+		ch <- m.RPCGetReplicas(gfs.GetReplicasArg{r1.Handle, gfs.Credentials{}}, &l)
+		errorAll(ch, 3, t)
+		ret := make(map[string]int)
+		for _, v := range l.Locations {
+			ret[rackOf[v]]++
+		}
+		return ret
+	}
+
+	for i, v := range csAdd {
+		rackOf[v] = racks[i]
+	}
+	ret := replicaRacks("/TestTopology1.txt")
+	if ret["r1"] != 1 || ret["r2"] != 1 || ret["r3"] != 1 {
+		t.Error("expect a replica on each rack, got", ret)
+	}
+
+	// move the server of r3 to r2 on master
+	topo, err := gfs.ParseTopology("rack=r2,zone=a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RPCSetTopology(gfs.SetTopologyArg{csAdd[4], topo, gfs.Credentials{}}, &gfs.SetTopologyReply{}); err != nil {
+		t.Fatal(err)
+	}
+	rackOf[csAdd[4]] = "r2"
+	var l gfs.ListServersReply
+	if err := m.RPCListServers(gfs.ListServersArg{}, &l); err != nil || l.Servers[4].Topology != topo {
+		t.Error("expect topology", topo, "of", csAdd[4], "got", l.Servers, err)
+	}
+	ret = replicaRacks("/TestTopology2.txt")
+	if ret["r1"] == 0 || ret["r2"] == 0 || ret["r1"]+ret["r2"] != 3 {
+		t.Error("expect replicas on both racks, got", ret)
+	}
+
+	// the structured topology document, by rpc and over http
+	var r gfs.GetTopologyReply
+	if err := m.RPCGetTopology(gfs.GetTopologyArg{}, &r); err != nil {
+		t.Fatal(err)
+	}
+	var doc gfs.ClusterTopology
+	w := httptest.NewRecorder()
+	m.HTTPHandler().ServeHTTP(w, httptest.NewRequest("GET", "/topology", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []gfs.ClusterTopology{r.Cluster, doc} {
+		if len(v.Zones) != 1 || v.Zones[0].Name != "a" || len(v.Zones[0].Racks) != 2 || v.Usage.Servers != csNum || v.Chunks == 0 {
+			t.Fatal("expect zone a of", csNum, "servers and 2 racks, got", v)
+		}
+		if r1, r2 := v.Zones[0].Racks[0], v.Zones[0].Racks[1]; r1.Name != "r1" || len(r1.Servers) != 3 || r2.Name != "r2" || len(r2.Servers) != 2 || r2.Servers[1].Address != csAdd[4] {
+			t.Error("expect 3 servers on r1 and 2 on r2, got", r1, r2)
+		}
+		if v.Usage.Healthy == 0 || v.Usage.Replicas == 0 || v.Zones[0].Usage.Servers != csNum {
+			t.Error("expect healthy servers holding chunks, got", v.Usage, v.Zones[0].Usage)
+		}
+		for _, rack := range v.Zones[0].Racks {
+			for _, sv := range rack.Servers {
+				if sv.Health == "" || sv.Health == gfs.ServerSuspect || sv.Health == gfs.ServerDraining {
+					t.Error("expect", sv.Address, "in service, got", sv.Health)
+				}
+			}
+		}
+	}
+}
+
+// rebalancing moves chunks from overloaded servers to underloaded ones without losing replicas
+func TestRebalance(t *testing.T) {
+	for i, v := range cs {
+		if i < 3 {
+			v.SetLabels(map[string]string{"disk": "ssd"})
+		}
+	}
+	time.Sleep(2 * gfs.HeartbeatInterval)
+
+	p := gfs.Path("/TestRebalance.txt")
+	ch := make(chan error, 4)
+	ch <- m.RPCCreateFile(gfs.CreateFileArg{p, 0, gfs.Credentials{}}, &gfs.CreateFileReply{})
+	ch <- m.RPCSetPlacement(gfs.SetPlacementArg{p, []gfs.PlacementConstraint{{gfs.PlacementMust, "disk=ssd"}}, gfs.Credentials{}}, &gfs.SetPlacementReply{})
+	ch <- m.RPCExtendFile(gfs.ExtendFileArg{p, 20 * gfs.MaxChunkSize, 0, gfs.Credentials{}}, &gfs.ExtendFileReply{})
+	ch <- m.RPCSetPlacement(gfs.SetPlacementArg{p, nil, gfs.Credentials{}}, &gfs.SetPlacementReply{})
+	errorAll(ch, 4, t)
+	for _, v := range cs {
+		v.SetLabels(nil)
+	}
+
+	spread := func() int {
+		var r gfs.ListServersReply
+		if err := m.RPCListServers(gfs.ListServersArg{}, &r); err != nil {
+			t.Fatal(err)
+		}
+		min, max := r.Servers[0].Chunks, r.Servers[0].Chunks
+		for _, v := range r.Servers {
+			if v.Chunks < min {
+				min = v.Chunks
+			}
+			if v.Chunks > max {
+				max = v.Chunks
+			}
+		}
+		return max - min
+	}
+
+	before := spread()
+	m.SetRebalanceMaxMoves(100)
+	defer m.SetRebalanceMaxMoves(gfs.RebalanceMaxMoves)
+	if err := m.RPCRebalance(gfs.RebalanceArg{}, &gfs.RebalanceReply{}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for spread() >= before && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if after := spread(); after >= before {
+		t.Error("expect chunk count spread to shrink from", before, "got", after)
+	}
+	time.Sleep(time.Second) // let the round finish
+
+	for i := gfs.ChunkIndex(0); i < 20; i++ {
+		var r1 gfs.GetChunkHandleReply
+		if err := m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, i, false, gfs.Credentials{}}, &r1); err != nil {
+			t.Fatal(err)
+		}
+		var l gfs.GetReplicasReply
+		if err := m.RPCGetReplicas(gfs.GetReplicasArg{r1.Handle, gfs.Credentials{}}, &l); err != nil || len(l.Locations) != gfs.DefaultNumReplicas {
+			t.Error("expect", gfs.DefaultNumReplicas, "replicas of chunk", i, "got", l.Locations, err)
+		}
+	}
+}
+
+func TestListServers(t *testing.T) {
+	var r gfs.ListServersReply
+	ch := make(chan error, 2)
+	ch <- util.Call(ctx, mAdd, "Master.RPCListServers", gfs.ListServersArg{}, &r)
+	ch <- util.Call(ctx, mAdd, "Master.RPCRebalance", gfs.RebalanceArg{}, &gfs.RebalanceReply{})
+	errorAll(ch, 2, t)
+
+	if len(r.Servers) != len(csAdd) {
+		t.Fatal("expect", len(csAdd), "servers, got", r.Servers)
+	}
+	for i := 1; i < len(r.Servers); i++ {
+		if r.Servers[i-1].Address >= r.Servers[i].Address {
+			t.Error("servers should be sorted by address, got", r.Servers)
+		}
+	}
+	for _, v := range r.Servers {
+		if v.DiskFree <= 0 || v.StoredChunks == 0 || v.IOLoad < 0 {
+			t.Error("expect disk free, chunks and io load reported by", v.Address, "got", v)
+		}
+	}
+}
+
+// a server reported dead by a primary is kept while it heartbeats
+func TestReportDeadServer(t *testing.T) {
+	var r gfs.ReportDeadServerReply
+	if err := m.RPCReportDeadServer(gfs.ReportDeadServerArg{csAdd[1], csAdd[0], ""}, &r); err != nil || r.Removed {
+		t.Error("expect live server", csAdd[1], "kept, got", r, err)
+	}
+	var l gfs.ListServersReply
+	if err := m.RPCListServers(gfs.ListServersArg{}, &l); err != nil || len(l.Servers) != len(csAdd) {
+		t.Error("expect", len(csAdd), "servers, got", l.Servers, err)
+	}
+}
+
+// heartbeats are only accepted from registered servers, which report their version and capacity
+func TestRegisterServer(t *testing.T) {
+	var r gfs.HeartbeatReply
+	err := m.RPCHeartbeat(gfs.HeartbeatArg{Address: ":7774"}, &r)
+	if !errors.Is(err, gfs.NotRegistered) {
+		t.Error("expect heartbeat of unregistered server rejected, got", err)
+	}
+
+	var l gfs.ListServersReply
+	if err := m.RPCListServers(gfs.ListServersArg{}, &l); err != nil || len(l.Servers) != len(csAdd) {
+		t.Fatal("expect", len(csAdd), "servers, got", l.Servers, err)
+	}
+	for _, v := range l.Servers {
+		if v.Version != gfs.Version || v.Capacity <= 0 {
+			t.Error("expect version", gfs.Version, "and capacity of", v.Address, "got", v.Version, v.Capacity)
+		}
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	var r gfs.BuildInfoReply
+	if err := m.RPCBuildInfo(gfs.Nouse{}, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Version != gfs.Version || r.Protocol != gfs.ProtocolLevel || len(r.Features) == 0 {
+		t.Error("expect build info of master, got", r)
+	}
+
+	r = gfs.BuildInfoReply{}
+	if err := util.Call(ctx, csAdd[0], "ChunkServer.RPCBuildInfo", gfs.Nouse{}, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Version != gfs.Version || r.GitCommit != gfs.GitCommit || r.Protocol != gfs.ProtocolLevel || len(r.Features) == 0 {
+		t.Error("expect build info of chunkserver, got", r)
+	}
+}
+
+// pushed data beyond the budget of the download buffer, or of a client, is rejected as busy without spilling
+func TestDownloadBufferLimit(t *testing.T) {
+	cs[0].SetDownloadBufferMax(3000, 1000)
+	cs[0].SetDownloadBufferSpill(0)
+	defer cs[0].SetDownloadBufferMax(gfs.DownloadBufferMaxBytes, gfs.DownloadBufferClientMaxBytes)
+	defer cs[0].SetDownloadBufferSpill(gfs.DownloadBufferSpillMaxBytes)
+
+	push := func(id int, client string) error {
+		var r gfs.ForwardDataReply
+		arg := gfs.ForwardDataArg{gfs.DataBufferID{1 << 40, id}, make([]byte, 800), nil, client, "", ""}
+		return util.Call(ctx, csAdd[0], "ChunkServer.RPCForwardData", arg, &r)
+	}
+	if err := push(1, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := push(2, "a"); !errors.Is(err, gfs.ServerBusy) {
+		t.Error("expect client over its budget busy, got", err)
+	}
+	if err := push(3, "b"); err != nil {
+		t.Error("expect another client accepted, got", err)
+	}
+	if err := push(4, "c"); err != nil {
+		t.Error("expect another client accepted, got", err)
+	}
+	if err := push(5, "d"); !errors.Is(err, gfs.ServerBusy) {
+		t.Error("expect full buffer busy, got", err)
+	}
+}
+
+// pushed data beyond the memory budget is spilled to disk and read back by mutations
+func TestDownloadBufferSpill(t *testing.T) {
+	for _, v := range cs {
+		v.SetDownloadBufferMax(1, 1)
+	}
+	defer func() {
+		for _, v := range cs {
+			v.SetDownloadBufferMax(gfs.DownloadBufferMaxBytes, gfs.DownloadBufferClientMaxBytes)
+			v.SetDownloadBufferSpill(gfs.DownloadBufferSpillMaxBytes)
+		}
+	}()
+
+	p := gfs.Path("/TestDownloadBufferSpill.txt")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 3*gfs.MaxAppendSize)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	if _, err := c.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Append(ctx, p, data[:100]); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(data)+100)
+	if n, err := c.Read(ctx, p, 0, buf); err != nil && err != io.EOF || n != len(buf) {
+		t.Fatal("expect", len(buf), "bytes read, got", n, err)
+	}
+	if !reflect.DeepEqual(buf[:len(data)], data) || !reflect.DeepEqual(buf[len(data):], data[:100]) {
+		t.Error("expect spilled data written")
+	}
+
+	cs[0].SetDownloadBufferSpill(1000)
+	var r gfs.ForwardDataReply
+	arg := gfs.ForwardDataArg{gfs.DataBufferID{1 << 41, 1}, make([]byte, 1200), nil, "a", "", ""}
+	if err := util.Call(ctx, csAdd[0], "ChunkServer.RPCForwardData", arg, &r); !errors.Is(err, gfs.ServerBusy) {
+		t.Error("expect data beyond the spill budget busy, got", err)
+	}
+}
+
+// creates under a throttled directory are limited by master, appends by the primary
+func TestThrottle(t *testing.T) {
+	dir := gfs.Path("/TestThrottle")
+	if err := c.Mkdir(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	policy := gfs.ThrottlePolicy{dir, 2, 1}
+	if err := m.RPCSetThrottle(gfs.SetThrottleArg{policy, gfs.Credentials{}}, &gfs.SetThrottleReply{}); err != nil {
+		t.Fatal(err)
+	}
+	var l gfs.ListThrottlesReply
+	if err := m.RPCListThrottles(gfs.ListThrottlesArg{}, &l); err != nil || len(l.Policies) != 1 || l.Policies[0] != policy {
+		t.Error("expect policy", policy, "got", l.Policies, err)
+	}
+
+	var created []gfs.Path
+	throttled := 0
+	for i := 0; i < 10; i++ {
+		p := gfs.Path(fmt.Sprintf("%v/%v.txt", dir, i))
+		err := m.RPCCreateFile(gfs.CreateFileArg{p, gfs.MaxChunkSize, gfs.Credentials{}}, &gfs.CreateFileReply{})
+		if errors.Is(err, gfs.Throttled) {
+			throttled++
+		} else if err != nil {
+			t.Fatal(err)
+		} else {
+			created = append(created, p)
+		}
+	}
+	if throttled == 0 || len(created) == 0 {
+		t.Fatal("expect some creates throttled, got", len(created), "created and", throttled, "throttled")
+	}
+
+	nc := client.NewClient(mAdd, client.WithRetryPolicy(client.NoRetry))
+	throttled = 0
+	for i := 0; i < 5; i++ {
+		_, err := nc.Append(ctx, created[0], []byte("x"))
+		if errors.Is(err, gfs.Throttled) {
+			throttled++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if throttled == 0 {
+		t.Error("expect some appends throttled")
+	}
+
+	if err := m.RPCSetThrottle(gfs.SetThrottleArg{gfs.ThrottlePolicy{Path: dir}, gfs.Credentials{}}, &gfs.SetThrottleReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RPCListThrottles(gfs.ListThrottlesArg{}, &l); err != nil || len(l.Policies) != 0 {
+		t.Error("expect no policy left, got", l.Policies, err)
+	}
+}
+
+// leases of a primary are extended while it is written to, and released once idle
+func TestLeaseRenewal(t *testing.T) {
+	p := gfs.Path("/TestLeaseRenewal.txt")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l1 gfs.GetPrimaryAndSecondariesReply
+	if err := m.RPCGetPrimaryAndSecondaries(gfs.GetPrimaryAndSecondariesArg{handle, "", gfs.Credentials{}, ""}, &l1); err != nil {
+		t.Fatal(err)
+	}
+
+	for time.Until(l1.Expire) > gfs.LeaseExpire/4 {
+		if _, err := c.Write(ctx, p, 0, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	time.Sleep(2 * gfs.HeartbeatInterval)
+	var l2 gfs.GetPrimaryAndSecondariesReply
+	if err := m.RPCGetPrimaryAndSecondaries(gfs.GetPrimaryAndSecondariesArg{handle, "", gfs.Credentials{}, ""}, &l2); err != nil {
+		t.Fatal(err)
+	}
+	if l2.Primary != l1.Primary || !l2.Expire.After(l1.Expire) {
+		t.Error("expect lease of", l1.Primary, "extended beyond", l1.Expire, "got", l2.Primary, l2.Expire)
+	}
+
+	leases := func() int {
+		var r gfs.ListServersReply
+		if err := m.RPCListServers(gfs.ListServersArg{}, &r); err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range r.Servers {
+			if v.Address == l1.Primary {
+				return v.Leases
+			}
+		}
+		return -1
+	}
+	for leases() != 0 && time.Until(l2.Expire) > 0 {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if time.Until(l2.Expire) <= 0 {
+		t.Error("expect idle lease released before it expires")
+	}
+
+	// the released primary refuses stale clients until a new lease is granted
+	data := []byte("after release")
+	if _, err := c.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(data))
+	if _, err := c.Read(ctx, p, 0, buf); err != nil && err != io.EOF || !reflect.DeepEqual(buf, data) {
+		t.Error("read wrong data after lease release", string(buf), err)
+	}
+}
+
+// dry runs of decommission and rebalance return plans without changing anything
+func TestDryRun(t *testing.T) {
+	if err := m.RPCDecommissionServer(gfs.DecommissionServerArg{":7775", false, true, gfs.Credentials{}}, &gfs.DecommissionServerReply{}); !errors.Is(err, gfs.ServerNotFound) {
+		t.Error("expect unknown server not found, got", err)
+	}
+	var r gfs.DecommissionServerReply
+	if err := m.RPCDecommissionServer(gfs.DecommissionServerArg{csAdd[0], false, true, gfs.Credentials{}}, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Chunks == 0 || len(r.Plan) == 0 {
+		t.Error("expect chunks of", csAdd[0], "to be copied, got", r)
+	}
+	for _, v := range r.Plan {
+		if v.To == csAdd[0] { // empty if the placement of the chunk cannot be satisfied
+			t.Error("expect a copy to another server, got", v)
+		}
+	}
+	var l gfs.ListServersReply
+	if err := m.RPCListServers(gfs.ListServersArg{}, &l); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range l.Servers {
+		if v.Draining {
+			t.Error("expect no server draining, got", v.Address)
+		}
+	}
+
+	var rb gfs.RebalanceReply
+	if err := m.RPCRebalance(gfs.RebalanceArg{true, gfs.Credentials{}}, &rb); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range rb.Moves {
+		if v.From == "" || v.To == "" || v.From == v.To {
+			t.Error("expect a move between servers, got", v)
+		}
+	}
+}
+
+func TestDecommission(t *testing.T) {
+	p := gfs.Path("/TestDecommission.txt")
+	addr := csAdd[csNum-1]
+	if err := m.RPCDecommissionServer(gfs.DecommissionServerArg{":7775", false, false, gfs.Credentials{}}, &gfs.DecommissionServerReply{}); !errors.Is(err, gfs.ServerNotFound) {
+		t.Error("expect server not found, got", err)
+	}
+	if err := m.RPCDecommissionServer(gfs.DecommissionServerArg{addr, false, false, gfs.Credentials{}}, &gfs.DecommissionServerReply{}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.RPCDecommissionServer(gfs.DecommissionServerArg{addr, true, false, gfs.Credentials{}}, &gfs.DecommissionServerReply{})
+
+	var r gfs.DecommissionStatusReply
+	for deadline := time.Now().Add(20 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		r = gfs.DecommissionStatusReply{}
+		if err := m.RPCDecommissionStatus(gfs.DecommissionStatusArg{addr, gfs.Credentials{}}, &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Done {
+			break
+		}
+	}
+	if !r.Draining || !r.Done {
+		t.Error("expect drained server, got", r)
+	}
+
+	// no new chunks on a draining server
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, []byte("not on a draining server")); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l gfs.GetReplicasReply
+	if err := m.RPCGetReplicas(gfs.GetReplicasArg{handle, gfs.Credentials{}}, &l); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range l.Locations {
+		if a == addr {
+			t.Error("new chunk placed on draining server", addr)
+		}
+	}
+}
+
+// master and chunkservers export metrics of served rpcs and their state
+// decommission revokes the leases of a server, writers move to another primary
+func TestRevokeLease(t *testing.T) {
+	p := gfs.Path("/TestRevokeLease.txt")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, []byte("written to the first primary")); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l gfs.GetPrimaryAndSecondariesReply
+	if err := m.RPCGetPrimaryAndSecondaries(gfs.GetPrimaryAndSecondariesArg{Handle: handle}, &l); err != nil || !l.Expire.After(time.Now()) {
+		t.Fatal("expect a lease of chunk", handle, "got", l, err)
+	}
+	primary := l.Primary
+
+	var r gfs.DecommissionServerReply
+	if err := m.RPCDecommissionServer(gfs.DecommissionServerArg{primary, false, false, gfs.Credentials{}}, &r); err != nil {
+		t.Fatal(err)
+	}
+	defer m.RPCDecommissionServer(gfs.DecommissionServerArg{primary, true, false, gfs.Credentials{}}, &gfs.DecommissionServerReply{})
+	if r.Leases < 1 {
+		t.Error("expect the lease of chunk", handle, "revoked from", primary, "got", r.Leases)
+	}
+
+	// the revoked primary refuses the write, the client asks master again
+	data := []byte("written to the next primary")
+	if _, err := c.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	l = gfs.GetPrimaryAndSecondariesReply{}
+	if err := m.RPCGetPrimaryAndSecondaries(gfs.GetPrimaryAndSecondariesArg{Handle: handle}, &l); err != nil || l.Primary == primary || !l.Expire.After(time.Now()) {
+		t.Error("expect a lease of chunk", handle, "on another primary than", primary, "got", l, err)
+	}
+	buf := make([]byte, len(data))
+	if n, err := c.Read(ctx, p, 0, buf); err != nil && !errors.Is(err, io.EOF) || string(buf[:n]) != string(data) {
+		t.Error("expect", string(data), "got", string(buf[:n]), err)
+	}
+}
+
+// a primary refuses mutations of a client holding a lease of an older epoch
+func TestLeaseEpoch(t *testing.T) {
+	p := gfs.Path("/TestLeaseEpoch.txt")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, []byte("granted a lease")); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l gfs.GetPrimaryAndSecondariesReply
+	if err := m.RPCGetPrimaryAndSecondaries(gfs.GetPrimaryAndSecondariesArg{Handle: handle}, &l); err != nil || l.Epoch <= 0 {
+		t.Fatal("expect a lease of chunk", handle, "at an epoch, got", l, err)
+	}
+	var primary *chunkserver.ChunkServer
+	for i, v := range csAdd {
+		if v == l.Primary {
+			primary = cs[i]
+		}
+	}
+
+	data := []byte("checked epoch")
+	for _, epoch := range []gfs.ChunkVersion{l.Epoch - 1, l.Epoch + 1, l.Epoch} {
+		id := chunkserver.NewDataID(handle)
+		if err := primary.RPCForwardData(gfs.ForwardDataArg{id, data, l.Secondaries, "", "", ""}, &gfs.ForwardDataReply{}); err != nil {
+			t.Fatal(err)
+		}
+		err := primary.RPCWriteChunk(gfs.WriteChunkArg{id, 0, l.Secondaries, epoch, gfs.RequestID{}, "", "", false}, &gfs.WriteChunkReply{})
+		if epoch != l.Epoch && !errors.Is(err, gfs.NotPrimary) {
+			t.Error("expect a write at epoch", epoch, "refused as not primary, got", err)
+		}
+		if epoch == l.Epoch && err != nil {
+			t.Error("expect a write at epoch", epoch, "applied, got", err)
+		}
+	}
+
+	buf := make([]byte, len(data))
+	if n, err := c.Read(ctx, p, 0, buf); err != nil && !errors.Is(err, io.EOF) || string(buf[:n]) != string(data) {
+		t.Error("expect", string(data), "got", string(buf[:n]), err)
+	}
+}
+
+func TestIdempotentAppend(t *testing.T) {
+	p := gfs.Path("/TestIdempotentAppend.txt")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Append(ctx, p, []byte("granted a lease")); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l gfs.GetPrimaryAndSecondariesReply
+	if err := m.RPCGetPrimaryAndSecondaries(gfs.GetPrimaryAndSecondariesArg{Handle: handle}, &l); err != nil {
+		t.Fatal(err)
+	}
+	var primary *chunkserver.ChunkServer
+	for i, v := range csAdd {
+		if v == l.Primary {
+			primary = cs[i]
+		}
+	}
+	length := func() gfs.Offset {
+		var r gfs.ReadChunkReply
+		if err := primary.RPCReadChunk(gfs.ReadChunkArg{handle, 0, 0, "", ""}, &r); err != nil {
+			t.Fatal(err)
+		}
+		return r.ChunkLength
+	}
+
+	// a retry of an append applied, with the data pushed again
+	data := []byte("appended once")
+	rid := gfs.RequestID{"TestIdempotentAppend", 1}
+	var offsets []gfs.Offset
+	for i := 0; i < 2; i++ {
+		id := chunkserver.NewDataID(handle)
+		if err := primary.RPCForwardData(gfs.ForwardDataArg{id, data, l.Secondaries, "", "", ""}, &gfs.ForwardDataReply{}); err != nil {
+			t.Fatal(err)
+		}
+		var r gfs.AppendChunkReply
+		if err := primary.RPCAppendChunk(gfs.AppendChunkArg{id, l.Secondaries, l.Epoch, rid, "", "", false}, &r); err != nil || r.ErrorCode != gfs.Success {
+			t.Fatal(err, r.ErrorCode)
+		}
+		offsets = append(offsets, r.Offset)
+		if i == 0 {
+			if want := r.Offset + gfs.Offset(len(data)); length() != want {
+				t.Fatal("expect chunk length", want, "got", length())
+			}
+		}
+	}
+	if offsets[0] != offsets[1] {
+		t.Error("expect a retried append at offset", offsets[0], "got", offsets[1])
+	}
+	if want := offsets[0] + gfs.Offset(len(data)); length() != want {
+		t.Error("expect a retried append applied once, chunk length", want, "got", length())
+	}
+
+	// a new request appends again
+	id := chunkserver.NewDataID(handle)
+	if err := primary.RPCForwardData(gfs.ForwardDataArg{id, data, l.Secondaries, "", "", ""}, &gfs.ForwardDataReply{}); err != nil {
+		t.Fatal(err)
+	}
+	var r gfs.AppendChunkReply
+	rid.Seq++
+	if err := primary.RPCAppendChunk(gfs.AppendChunkArg{id, l.Secondaries, l.Epoch, rid, "", "", false}, &r); err != nil || r.Offset == offsets[0] {
+		t.Error("expect a new append after offset", offsets[0], "got", r.Offset, err)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	scrape := func(h http.Handler) string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		return w.Body.String()
+	}
+
+	body := scrape(m.HTTPHandler())
+	for _, s := range []string{
+		`gfs_rpc_requests_total{method="Master.RPCHeartbeat",code="success"}`,
+		`gfs_rpc_duration_seconds_bucket{method="Master.RPCHeartbeat",le="+Inf"}`,
+		"# TYPE gfs_master_chunks gauge",
+		"gfs_master_chunkservers " + strconv.Itoa(len(csAdd)),
+		"gfs_master_heartbeat_lag_seconds{server=",
+	} {
+		if !strings.Contains(body, s) {
+			t.Error("master metrics do not contain", s)
+		}
+	}
+
+	body = ""
+	for _, v := range cs {
+		body += scrape(v.HTTPHandler())
+	}
+	for _, s := range []string{
+		`gfs_rpc_requests_total{method="ChunkServer.RPCReadChunk",code="success"}`,
+		`gfs_rpc_requests_total{method="ChunkServer.RPCForwardData",code="success"}`, // served on streams alone
+		`gfs_rpc_duration_seconds_bucket{method="ChunkServer.RPCForwardData",le="+Inf"}`,
+		"gfs_chunkserver_written_bytes_total ",
+		`gfs_chunkserver_heartbeat_duration_seconds_count{result="success"}`,
+	} {
+		if !strings.Contains(body, s) {
+			t.Error("chunkserver metrics do not contain", s)
+		}
+	}
+}
+
+// master and chunkservers serve status pages
+func TestStatusPages(t *testing.T) {
+	get := func(h http.Handler) string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+		if w.Code != http.StatusOK {
+			t.Error("status page returns", w.Code)
+		}
+		return w.Body.String()
+	}
+
+	body := get(m.HTTPHandler())
+	for _, s := range []string{
+		fmt.Sprintf("Chunkservers (%v)", len(csAdd)),
+		string(csAdd[0]),
+		"Under-replicated",
+		"<td>Files</td>",
+	} {
+		if !strings.Contains(body, s) {
+			t.Error("master status page does not contain", s)
+		}
+	}
+
+	var r gfs.GetChunkHandleReply
+	var l gfs.GetReplicasReply
+	p := gfs.Path("/TestWriteChunk.txt")
+	ch := make(chan error, 2)
+	ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0, false, gfs.Credentials{}}, &r)
+	ch <- m.RPCGetReplicas(gfs.GetReplicasArg{r.Handle, gfs.Credentials{}}, &l)
+	errorAll(ch, 2, t)
+	for i, addr := range csAdd {
+		if addr != l.Locations[0] {
+			continue
+		}
+		body = get(cs[i].HTTPHandler())
+		row := fmt.Sprintf("<tr><td>%v</td>", r.Handle)
+		if !strings.Contains(body, string(addr)) || !strings.Contains(body, row) {
+			t.Error("chunkserver status page does not contain chunk", r.Handle, "got", body)
+		}
+	}
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	m.SetSlowQueryThreshold(0)
+	defer m.SetSlowQueryThreshold(gfs.SlowQueryThreshold)
+
+	ch := make(chan error, 2)
+	ch <- util.Call(ctx, mAdd, "Master.RPCMkdir", gfs.MkdirArg{"/TestSlowQueryLog", gfs.Credentials{"", nil, "not-logged"}}, &gfs.MkdirReply{})
+	var r gfs.GetSlowQueriesReply
+	ch <- util.Call(ctx, mAdd, "Master.RPCGetSlowQueries", gfs.GetSlowQueriesArg{1, gfs.Credentials{}}, &r)
+	errorAll(ch, 2, t)
+
+	if len(r.Queries) != 1 {
+		t.Fatal("expect 1 slow query, got", r.Queries)
+	}
+	q := r.Queries[0]
+	if q.Method != "Master.RPCMkdir" || q.Caller == "" || !strings.Contains(q.Args, "/TestSlowQueryLog") {
+		t.Error("incorrect slow query", q)
+	}
+	if strings.Contains(q.Args, "not-logged") {
+		t.Error("expect the token of the slow query blanked, got", q.Args)
+	}
+}
+
+/*
+ *  TEST SUITE 2 - Client API
+ */
+
+// if the append would cause the chunk to exceed the maximum size
+// this chunk should be pad and the data should be appended to the next chunk
+func TestPadOver(t *testing.T) {
+	p := gfs.Path("/appendover.txt")
+
+	ch := make(chan error, 6)
+	ch <- c.Create(ctx, p)
+
+	bound := gfs.MaxAppendSize - 1
+	buf := make([]byte, bound)
+	for i := 0; i < bound; i++ {
+		buf[i] = byte(i%26 + 'a')
+	}
+
+	for i := 0; i < 4; i++ {
+		_, err := c.Append(ctx, p, buf)
+		ch <- err
+	}
+
+	buf = buf[:5]
+	// an append cause pad, and client should retry to next chunk
+	offset, err := c.Append(ctx, p, buf)
+	ch <- err
+	if offset != gfs.MaxChunkSize { // i.e. 0 at next chunk
+		t.Error("data should be appended to the beginning of next chunk")
+	}
+
+	errorAll(ch, 6, t)
+}
+
+// a canceled context aborts the operation instead of retrying forever
+func TestContextCancel(t *testing.T) {
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := c.Create(cctx, "/TestContextCancel.txt"); err != context.Canceled {
+		t.Error("expect", context.Canceled, "got", err)
+	}
+	if _, err := c.Append(cctx, "/appendover.txt", []byte("x")); err == nil {
+		t.Error("append should fail with a canceled context")
+	}
+}
+
+// a client gives up according to its retry policy instead of trying forever
+func TestRetryPolicy(t *testing.T) {
+	policy := client.RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond, Multiplier: 2}
+	nc := client.NewClient(":7776", client.WithRetryPolicy(policy)) // nobody listens
+	start := time.Now()
+	if err := nc.Create(ctx, "/TestRetryPolicy.txt"); err == nil {
+		t.Error("create should fail without master")
+	}
+	if d := time.Since(start); d < 30*time.Millisecond || d > gfs.ClientTryTimeout {
+		t.Error("expect 3 attempts with backoff, took", d)
+	}
+}
+
+// error codes are kept across rpcs and can be tested by errors.Is/As
+func TestErrorCode(t *testing.T) {
+	p := gfs.Path("/TestErrorCode.txt")
+	ch := make(chan error, 1)
+	ch <- c.Create(ctx, p)
+	errorAll(ch, 1, t)
+
+	if err := c.Create(ctx, p); !errors.Is(err, gfs.PathExists) {
+		t.Error("expect", gfs.PathExists, "got", err)
+	}
+
+	var e gfs.Error
+	if _, err := c.List(ctx, p); !errors.As(err, &e) || e.Code != gfs.NotDirectory {
+		t.Error("expect", gfs.NotDirectory, "got", err)
+	}
+
+	if _, err := c.ReadChunk(ctx, gfs.ChunkHandle(1<<40), 0, make([]byte, 1)); !errors.Is(err, gfs.Error{Code: gfs.ChunkNotFound}) {
+		t.Error("expect", gfs.ChunkNotFound, "got", err)
+	}
+}
+
+// a File is used through the io interfaces
+func TestFile(t *testing.T) {
+	p := gfs.Path("/TestFile.txt")
+	ch := make(chan error, 8)
+	ch <- c.Create(ctx, p)
+	f, err := c.Open(ctx, p)
+	ch <- err
+	errorAll(ch, 2, t)
+	if err != nil {
+		return
+	}
+	var _ io.ReadWriteSeeker = f
+	var _ io.ReaderAt = f
+	var _ io.WriterAt = f
+
+	_, err = io.WriteString(f, "hello ")
+	ch <- err
+	_, err = io.WriteString(f, "world")
+	ch <- err
+	_, err = f.Seek(0, io.SeekStart)
+	ch <- err
+	data, err := ioutil.ReadAll(f)
+	ch <- err
+	if string(data) != "hello world" {
+		t.Error("expect hello world, got", string(data))
+	}
+
+	// across the chunk boundary
+	cross := []byte("0123456789")
+	_, err = f.WriteAt(cross, gfs.MaxChunkSize-5)
+	ch <- err
+	end, err := f.Seek(0, io.SeekEnd)
+	ch <- err
+	if end != gfs.MaxChunkSize+5 {
+		t.Error("expect the end at", gfs.MaxChunkSize+5, "got", end)
+	}
+	buf := make([]byte, 20)
+	n, err := f.ReadAt(buf, gfs.MaxChunkSize-5)
+	if err != io.EOF || !reflect.DeepEqual(buf[:n], cross) {
+		t.Error("expect", cross, "and EOF, got", buf[:n], err)
+	}
+
+	ch <- f.Close()
+	if _, err := f.Read(buf); err != os.ErrClosed {
+		t.Error("expect", os.ErrClosed, "got", err)
+	}
+	errorAll(ch, 7, t)
+}
+
+// the context of Open bounds the open alone, a context given per operation
+// bounds that operation
+func TestFileContext(t *testing.T) {
+	p := gfs.Path("/TestFileContext.txt")
+	ch := make(chan error, 4)
+	ch <- c.Create(ctx, p)
+	openCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	f, err := c.Open(openCtx, p)
+	ch <- err
+	errorAll(ch, 2, t)
+	if err != nil {
+		return
+	}
+	cancel()
+
+	_, err = f.WriteAt([]byte("hello"), 0)
+	ch <- err
+	ch <- f.Sync()
+	buf := make([]byte, 5)
+	if n, err := f.ReadAt(buf, 0); err != nil || string(buf[:n]) != "hello" {
+		t.Error("expect hello after the open context is cancelled, got", string(buf[:n]), err)
+	}
+
+	if _, err := f.WriteAtContext(ctx, []byte(" world"), 5); err != nil {
+		t.Error("expect no error, got", err)
+	}
+	if err := f.SyncContext(openCtx); !errors.Is(err, context.Canceled) {
+		t.Error("expect", context.Canceled, "got", err)
+	}
+	if _, err := f.ReadAtContext(openCtx, buf, 0); !errors.Is(err, context.Canceled) {
+		t.Error("expect", context.Canceled, "got", err)
+	}
+	ch <- f.Close()
+	buf = make([]byte, 11)
+	if n, err := c.Read(ctx, p, 0, buf); err != nil || string(buf[:n]) != "hello world" {
+		t.Error("expect hello world, got", string(buf[:n]), err)
+	}
+	errorAll(ch, 3, t)
+}
+
+// concurrent OpenOrCreate calls create the file once and all succeed
+func TestOpenOrCreate(t *testing.T) {
+	p := gfs.Path("/TestOpenOrCreate.txt")
+	n := 10
+	ch := make(chan error, 2*n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(x int) {
+			defer wg.Done()
+			f, err := c.OpenOrCreate(ctx, p)
+			ch <- err
+			if err != nil {
+				ch <- err
+				return
+			}
+			_, err = f.WriteAt([]byte{byte('a' + x)}, int64(x))
+			if err == nil {
+				err = f.Close()
+			}
+			ch <- err
+		}(i)
+	}
+	wg.Wait()
+	errorAll(ch, 2*n, t)
+
+	buf := make([]byte, n)
+	if k, err := c.Read(ctx, p, 0, buf); err != nil || k != n || string(buf) != "abcdefghij" {
+		t.Error("expect abcdefghij, got", string(buf[:k]), err)
+	}
+
+	if _, err := c.OpenOrCreate(ctx, "/dir1"); !errors.Is(err, gfs.IsDirectory) {
+		t.Error("expect is directory, got", err)
+	}
+	if _, err := c.OpenOrCreate(ctx, "/nodir/TestOpenOrCreate.txt"); !errors.Is(err, gfs.PathNotFound) {
+		t.Error("expect path not found, got", err)
+	}
+}
+
+// writing beyond the end of file leaves a hole reading as zeros
+func TestSequentialWrite(t *testing.T) {
+	p := gfs.Path("/TestSequentialWrite.txt")
+	const chunkSize = 1 << 20
+	ch := make(chan error, 14)
+	ch <- c.CreateWithChunkSize(ctx, p, chunkSize)
+	f, err := c.Open(ctx, p)
+	ch <- err
+	ch <- f.SetSequential(true)
+
+	data := make([]byte, 5*chunkSize/2)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	for i := 0; i < len(data); i += chunkSize / 4 {
+		_, err := f.Write(data[i : i+chunkSize/4])
+		ch <- err
+		if err != nil {
+			break
+		}
+	}
+	ch <- f.Sync()
+	errorAll(ch, 3+10+1, t)
+
+	var info gfs.GetFileInfoReply
+	if err := m.RPCGetFileInfo(gfs.GetFileInfoArg{p, gfs.Credentials{}}, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Length != int64(len(data)) || info.Chunks != 4 {
+		t.Errorf("expect length %v and 4 chunks, one allocated ahead, got %v and %v", len(data), info.Length, info.Chunks)
+	}
+
+	if _, err := f.WriteAt([]byte("behind"), 0); !errors.Is(err, gfs.InvalidArgument) {
+		t.Error("expect a sequential write behind the end to be invalid, got", err)
+	}
+
+	// another writer moves the end
+	other := []byte("other writer")
+	if _, err := c.Write(ctx, p, gfs.Offset(len(data)), other); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("lost"))
+	if err := f.Sync(); !errors.Is(err, gfs.GenerationMismatch) {
+		t.Error("expect a generation mismatch after another writer extended the file, got", err)
+	}
+
+	// seeking to the end accepts it
+	last := []byte("after the others")
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	f.Write(last)
+	if err := f.Close(); err != nil {
+		t.Error(err)
+	}
+
+	expected := append(append(append([]byte(nil), data...), other...), last...)
+	buf := make([]byte, len(expected)+1)
+	n, err := c.Read(ctx, p, 0, buf)
+	if err != io.EOF || !reflect.DeepEqual(buf[:n], expected) {
+		t.Errorf("read %v bytes and %v, expect %v bytes and EOF", n, err, len(expected))
+	}
+}
+
+func TestWriteHole(t *testing.T) {
+	p := gfs.Path("/TestWriteHole.txt")
+	ch := make(chan error, 2)
+	ch <- c.Create(ctx, p)
+	length, err := c.Write(ctx, p, 2*gfs.MaxChunkSize+10, []byte("hole"))
+	ch <- err
+	errorAll(ch, 2, t)
+	if length != 2*gfs.MaxChunkSize+14 {
+		t.Error("expect length", 2*gfs.MaxChunkSize+14, "got", length)
+	}
+
+	buf := make([]byte, 10)
+	n, err := c.Read(ctx, p, gfs.MaxChunkSize+5, buf)
+	if err != nil || n != 10 || !reflect.DeepEqual(buf, make([]byte, 10)) {
+		t.Error("expect 10 zeros in the hole, got", n, buf, err)
+	}
+
+	buf = make([]byte, 20)
+	n, err = c.Read(ctx, p, 2*gfs.MaxChunkSize+5, buf)
+	expected := append(make([]byte, 5), "hole"...)
+	if err != io.EOF || !reflect.DeepEqual(buf[:n], expected) {
+		t.Error("expect", expected, "and EOF, got", buf[:n], err)
+	}
+}
+
+// chunkservers keep working when chunk files are evicted from the open file cache
+// files with small chunks split data, appends and holes at their own chunk size
+func TestChunkSize(t *testing.T) {
+	p := gfs.Path("/TestChunkSize.txt")
+	size := int64(1 << 20)
+	if err := c.CreateWithChunkSize(ctx, "/TestChunkSizeInvalid.txt", 3<<20); !errors.Is(err, gfs.InvalidArgument) {
+		t.Error("expect invalid argument for unsupported chunk size, got", err)
+	}
+	if err := c.CreateWithChunkSize(ctx, p, size); err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 2*size+size/2)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	if _, err := c.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	var info gfs.GetFileInfoReply
+	if err := m.RPCGetFileInfo(gfs.GetFileInfoArg{p, gfs.Credentials{}}, &info); err != nil || info.Chunks != 3 || info.ChunkSize != size {
+		t.Error("expect 3 chunks of", size, "bytes, got", info, err)
+	}
+
+	f, err := c.Open(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(f, buf); err != nil || !reflect.DeepEqual(buf, data) {
+		t.Error("read wrong data across small chunks", err)
+	}
+	f.Close()
+
+	if _, err := c.Append(ctx, p, make([]byte, size/4+1)); !errors.Is(err, gfs.InvalidArgument) {
+		t.Error("expect invalid argument for append over 1/4 chunk size, got", err)
+	}
+	for i := 0; i < 4; i++ {
+		offset, err := c.Append(ctx, p, make([]byte, size/4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(offset)%size+size/4 > size {
+			t.Error("append at", offset, "crosses a chunk boundary")
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	p := gfs.Path("/TestTruncate.txt")
+	size := int64(1 << 20)
+	if err := c.CreateWithChunkSize(ctx, p, size); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 2*size+size/2)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	if _, err := c.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(length int64) {
+		var info gfs.GetFileInfoReply
+		if err := m.RPCGetFileInfo(gfs.GetFileInfoArg{p, gfs.Credentials{}}, &info); err != nil || info.Length != length || info.Chunks != (length+size-1)/size {
+			t.Error("expect a file of", length, "bytes, got", info, err)
+		}
+		buf := make([]byte, len(data))
+		n, err := c.Read(ctx, p, 0, buf)
+		if err != io.EOF || int64(n) != length || !reflect.DeepEqual(buf[:n], data[:n]) {
+			t.Error("expect", length, "bytes read to EOF, got", n, err)
+		}
+	}
+
+	// the last chunk cut, the chunk past it released
+	var r gfs.TruncateReply
+	if err := m.RPCTruncate(gfs.TruncateArg{p, size + size/2, gfs.Credentials{}}, &r); err != nil || r.Chunks != 2 || r.Released != 1 {
+		t.Error("expect 2 chunks kept and 1 released, got", r, err)
+	}
+	check(size + size/2)
+
+	if err := c.Truncate(ctx, p, 100); err != nil {
+		t.Fatal(err)
+	}
+	check(100)
+	if offset, err := c.Append(ctx, p, []byte("appended")); err != nil || offset != 100 {
+		t.Error("expect an append after truncation at offset 100, got", offset, err)
+	}
+
+	if err := c.Truncate(ctx, p, 2*size); !errors.Is(err, gfs.InvalidArgument) {
+		t.Error("expect invalid argument for a length past the chunks, got", err)
+	}
+	if err := c.Truncate(ctx, "/TestTruncateNotFound.txt", 0); !errors.Is(err, gfs.PathNotFound) {
+		t.Error("expect path not found, got", err)
+	}
+}
+
+func TestStat(t *testing.T) {
+	p := gfs.Path("/TestStat/file.txt")
+	start := time.Now()
+	if err := c.Mkdir(ctx, "/TestStat"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	created, err := c.Stat(ctx, p)
+	if err != nil || created.Size != 0 || created.IsDir || created.ModTime.Before(start) || created.ChangeTime.Before(start) {
+		t.Fatal("expect an empty file created after", start, "got", created, err)
+	}
+
+	// appends are reported by the primary in heartbeats
+	if _, err := c.Write(ctx, p, 0, make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Append(ctx, p, make([]byte, 50)); err != nil {
+		t.Fatal(err)
+	}
+	var info gfs.FileInfo
+	for i := 0; i < 20; i++ {
+		if info, err = c.Stat(ctx, p); err != nil || info.Size == 150 {
+			break
+		}
+		time.Sleep(gfs.HeartbeatInterval)
+	}
+	if err != nil || info.Size != 150 || info.Chunks != 1 || info.Replication != gfs.DefaultNumReplicas || !info.ModTime.After(created.ModTime) {
+		t.Error("expect 150 bytes in a chunk modified after creation, got", info, err)
+	}
+
+	// metadata changes
+	time.Sleep(10 * time.Millisecond)
+	if err := c.SetReplication(ctx, p, 2); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := c.Stat(ctx, p)
+	if err != nil || changed.ModTime != info.ModTime || !changed.ChangeTime.After(info.ChangeTime) || changed.Replication != 2 {
+		t.Error("expect the change time alone to move on a metadata change, got", changed, "after", info, err)
+	}
+
+	if dir, err := c.Stat(ctx, "/TestStat"); err != nil || !dir.IsDir || dir.ModTime != created.ModTime {
+		t.Error("expect the directory modified by the creation, got", dir, err)
+	}
+}
+
+func TestListMetadata(t *testing.T) {
+	dir := gfs.Path("/TestListMetadata")
+	p := dir + "/file"
+	start := time.Now()
+	if err := c.Mkdir(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Mkdir(ctx, dir+"/sub"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetReplication(ctx, p, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// the length and the modification time follow the appends reported by
+	// the primary in heartbeats
+	time.Sleep(10 * time.Millisecond)
+	if _, err := c.Append(ctx, p, make([]byte, 50)); err != nil {
+		t.Fatal(err)
+	}
+	var list []gfs.PathInfo
+	var err error
+	for i := 0; i < 20; i++ {
+		if list, err = c.List(ctx, dir); err != nil || len(list) == 2 && list[0].Length == 50 {
+			break
+		}
+		time.Sleep(gfs.HeartbeatInterval)
+	}
+	if err != nil || len(list) != 2 {
+		t.Fatal("expect a file and a directory listed, got", list, err)
+	}
+	f, sub := list[0], list[1]
+	if f.Name != "file" || f.IsDir || f.Length != 50 || f.Chunks != 1 || f.Replication != 2 || f.Owner == "" {
+		t.Error("expect 50 bytes in a chunk replicated twice, got", f)
+	}
+	if f.CreateTime.Before(start) || !f.ModTime.After(f.CreateTime) || f.ChangeTime.Before(f.ModTime) {
+		t.Error("expect the file modified after its creation, got", f.CreateTime, f.ModTime, f.ChangeTime)
+	}
+	if sub.Name != "sub" || !sub.IsDir || sub.CreateTime.Before(f.CreateTime) || sub.ModTime != sub.CreateTime {
+		t.Error("expect a directory created after the file, got", sub)
+	}
+
+	info, err := c.Stat(ctx, p)
+	if err != nil || info.CreateTime != f.CreateTime || info.ModTime != f.ModTime {
+		t.Error("expect the times of Stat and List to agree, got", info, err)
+	}
+}
+
+// an imported namespace is created with its chunks at once, or not at all
+func TestRecursiveOps(t *testing.T) {
+	root := gfs.Path("/TestRecursiveOps")
+	ch := make(chan error, 3)
+	ch <- c.Mkdir(ctx, root)
+	ch <- c.Mkdir(ctx, root+"/a")
+	ch <- c.Mkdir(ctx, root+"/b")
+	errorAll(ch, 3, t)
+	for p, n := range map[gfs.Path]int{root + "/a/x": 10, root + "/a/y": 20, root + "/z": 30} {
+		if err := c.Create(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Write(ctx, p, 0, make([]byte, n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.Delete(ctx, root+"/a"); !errors.Is(err, gfs.DirectoryNotEmpty) {
+		t.Error("expect a non-empty directory not deleted without recursion, got", err)
+	}
+
+	// pages of two entries, in depth-first order
+	want := []gfs.Path{root + "/a", root + "/a/x", root + "/a/y", root + "/b", root + "/z"}
+	var got []gfs.Path
+	var after gfs.Path
+	for pages := 0; ; pages++ {
+		var r gfs.WalkReply
+		if err := m.RPCWalk(gfs.WalkArg{root, after, 2, gfs.Credentials{}}, &r); err != nil || len(r.Entries) > 2 || pages > len(want) {
+			t.Fatal("walk page", pages, "got", r, err)
+		}
+		for _, e := range r.Entries {
+			got = append(got, e.Path)
+		}
+		if r.Next == "" {
+			break
+		}
+		after = r.Next
+	}
+	var walked []gfs.Path
+	err := c.Walk(ctx, root, func(info gfs.FileInfo) error {
+		walked = append(walked, info.Path)
+		return nil
+	})
+	if err != nil || fmt.Sprint(got) != fmt.Sprint(want) || fmt.Sprint(walked) != fmt.Sprint(want) {
+		t.Error("expect", want, "got pages", got, "and walk", walked, err)
+	}
+
+	u, err := c.DiskUsage(ctx, root)
+	if err != nil || u != (gfs.DiskUsage{3, 2, 3, 60, 60 * gfs.DefaultNumReplicas}) {
+		t.Error("expect 3 files of 60 bytes in 2 directories, got", u, err)
+	}
+
+	files, dirs, err := c.DeleteAll(ctx, root)
+	if err != nil || files != 3 || dirs != 3 {
+		t.Error("expect 3 files and 3 directories deleted, got", files, dirs, err)
+	}
+	if _, err := c.List(ctx, root); !errors.Is(err, gfs.PathNotFound) {
+		t.Error("expect the tree gone, got", err)
+	}
+	if _, err := c.Stat(ctx, root+"/a/x"); !errors.Is(err, gfs.PathNotFound) {
+		t.Error("expect the files gone, got", err)
+	}
+}
+
+func TestListPages(t *testing.T) {
+	root := gfs.Path("/TestListPages")
+	if err := c.Mkdir(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for i := 0; i < 25; i++ {
+		name := fmt.Sprintf("part-%02d", i)
+		want = append(want, name)
+		if err := c.Create(ctx, root+"/"+gfs.Path(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Mkdir(ctx, root+"/logs"); err != nil {
+		t.Fatal(err)
+	}
+
+	// pages of ten, sorted by name
+	var got []string
+	var after string
+	for pages := 0; ; pages++ {
+		var r gfs.ListReply
+		if err := m.RPCList(gfs.ListArg{root, after, 10, "part-", "", gfs.Credentials{}}, &r); err != nil || len(r.Files) > 10 || pages > 3 {
+			t.Fatal("list page", pages, "got", r, err)
+		}
+		for _, v := range r.Files {
+			got = append(got, v.Name)
+		}
+		if r.Next == "" {
+			break
+		}
+		after = r.Next
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Error("expect", want, "got", got)
+	}
+
+	var matched []string
+	it := c.ListIter(ctx, root, "", "part-?5")
+	for {
+		v, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		matched = append(matched, v.Name)
+	}
+	if fmt.Sprint(matched) != "[part-05 part-15]" {
+		t.Error("expect part-05 and part-15 matched, got", matched)
+	}
+
+	ls, err := c.List(ctx, root)
+	if err != nil || len(ls) != 26 || ls[0].Name != "logs" || !ls[0].IsDir {
+		t.Error("expect 26 entries starting with logs/, got", ls, err)
+	}
+	var r gfs.ListReply
+	if err := m.RPCList(gfs.ListArg{root, "", 0, "", "[", gfs.Credentials{}}, &r); !errors.Is(err, gfs.InvalidArgument) {
+		t.Error("expect a bad pattern refused, got", err)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	dir, p := gfs.Path("/TestPermissions"), gfs.Path("/TestPermissions/a.txt")
+	ch := make(chan error, 3)
+	ch <- c.Mkdir(ctx, dir)
+	ch <- c.Chown(ctx, dir, "alice", "staff")
+	ch <- c.Chmod(ctx, dir, 0750)
+	errorAll(ch, 3, t)
+
+	alice := client.NewClient(mAdd, client.WithUser("alice", "staff"))
+	bob := client.NewClient(mAdd, client.WithUser("bob", "staff"))
+	eve := client.NewClient(mAdd, client.WithUser("eve"))
+	denied := func(what string, err error) {
+		if !errors.Is(err, gfs.PermissionDenied) {
+			t.Error("expect", what, "denied, got", err)
+		}
+	}
+
+	if err := alice.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.Write(ctx, p, 0, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := c.Stat(ctx, p)
+	if err != nil || info.Owner != "alice" || info.Group != "staff" || info.Mode != gfs.DefaultFileMode {
+		t.Error("expect a file of alice:staff with the default mode, got", info, err)
+	}
+
+	// the group may read but not write, nor create in the directory
+	buf := make([]byte, 5)
+	if n, err := bob.Read(ctx, p, 0, buf); (err != nil && err != io.EOF) || string(buf[:n]) != "hello" {
+		t.Error("expect bob to read hello, got", string(buf[:n]), err)
+	}
+	_, err = bob.Write(ctx, p, 0, []byte("world"))
+	denied("write by the group", err)
+	denied("create by the group", bob.Create(ctx, dir+"/b.txt"))
+	denied("delete by the group", bob.Delete(ctx, p))
+
+	// others may not search the directory
+	_, err = eve.List(ctx, dir)
+	denied("list by others", err)
+	_, err = eve.Read(ctx, p, 0, buf)
+	denied("read by others", err)
+
+	// only the owner changes the mode, the group to one of its own
+	denied("chmod by the group", bob.Chmod(ctx, p, 0666))
+	if err := alice.Chmod(ctx, p, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = bob.Read(ctx, p, 0, buf)
+	denied("read of a private file by the group", err)
+	denied("giving a file away", alice.Chown(ctx, p, "bob", ""))
+	denied("chown to a group the owner is not in", alice.Chown(ctx, p, "", "wheel"))
+	if err := c.Chown(ctx, p, "bob", "wheel"); err != nil {
+		t.Error(err)
+	}
+	if err := alice.Delete(ctx, p); err != nil {
+		t.Error("expect the owner of the directory to delete, got", err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	dir, p := gfs.Path("/TestAuth"), gfs.Path("/TestAuth/a.txt")
+	key := []byte("TestAuth secret")
+	m.SetAuthSecret(key)
+	for _, s := range cs {
+		s.SetAuthSecret(key)
+	}
+	defer func() {
+		m.SetAuthSecret(nil)
+		for _, s := range cs {
+			s.SetAuthSecret(nil)
+		}
+	}()
+
+	var secret, other auth.Secret
+	secret.Set(key)
+	other.Set([]byte("another secret"))
+	unauthenticated := func(what string, err error) {
+		if !errors.Is(err, gfs.Unauthenticated) {
+			t.Error("expect", what, "unauthenticated, got", err)
+		}
+	}
+	unauthenticated("create without a token", client.NewClient(mAdd).Create(ctx, p))
+	unauthenticated("create with a token of another secret", client.NewClient(mAdd, client.WithToken(other.ClientToken("alice", nil, time.Minute))).Create(ctx, p))
+	unauthenticated("create with an expired token", client.NewClient(mAdd, client.WithToken(secret.ClientToken("alice", nil, -time.Second))).Create(ctx, p))
+
+	rootCred := gfs.Credentials{Token: secret.ClientToken(gfs.SuperUser, nil, time.Minute)}
+	root := client.NewClient(mAdd, client.WithToken(rootCred.Token))
+	ch := make(chan error, 2)
+	ch <- root.Mkdir(ctx, dir)
+	ch <- root.Chown(ctx, dir, "alice", "")
+	errorAll(ch, 2, t)
+
+	aliceCred := gfs.Credentials{Token: secret.ClientToken("alice", []string{"staff"}, time.Minute)}
+	alice := client.NewClient(mAdd, client.WithUser("root"), client.WithToken(aliceCred.Token))
+	if err := alice.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.Write(ctx, p, 0, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if n, err := alice.Read(ctx, p, 0, buf); (err != nil && err != io.EOF) || string(buf[:n]) != "hello" {
+		t.Error("expect alice to read hello, got", string(buf[:n]), err)
+	}
+	if info, err := root.Stat(ctx, p); err != nil || info.Owner != "alice" {
+		t.Error("expect a file of the user of the token, not of WithUser, got", info, err)
+	}
+
+	// chunks are read with the token of their replicas, mutated with the one of their lease
+	handle, err := alice.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l gfs.GetReplicasReply
+	if err := m.RPCGetReplicas(gfs.GetReplicasArg{handle, aliceCred}, &l); err != nil || l.Token == "" || len(l.Locations) == 0 {
+		t.Fatal("expect replicas with a token, got", l, err)
+	}
+	read := func(token string) error {
+		var r gfs.ReadChunkReply
+		return util.Call(ctx, l.Locations[0], "ChunkServer.RPCReadChunk", gfs.ReadChunkArg{handle, 0, 5, token, ""}, &r)
+	}
+	unauthenticated("read without a token", read(""))
+	unauthenticated("read with a client token", read(aliceCred.Token))
+	unauthenticated("read with the token of another chunk", read(secret.ChunkToken("alice", handle+1, false)))
+	if err := read(l.Token); err != nil {
+		t.Error(err)
+	}
+	var lease gfs.GetPrimaryAndSecondariesReply
+	if err := m.RPCGetPrimaryAndSecondaries(gfs.GetPrimaryAndSecondariesArg{handle, "", aliceCred, ""}, &lease); err != nil || lease.Token == "" {
+		t.Fatal("expect a lease with a token, got", lease, err)
+	}
+	write := gfs.WriteChunkArg{chunkserver.NewDataID(handle), 0, lease.Secondaries, lease.Epoch, gfs.RequestID{}, l.Token, "", false}
+	unauthenticated("write with a read token", util.Call(ctx, lease.Primary, "ChunkServer.RPCWriteChunk", write, &gfs.WriteChunkReply{}))
+
+	// chunk tokens are issued to the users who may access the file
+	bobCred := gfs.Credentials{Token: secret.ClientToken("bob", nil, time.Minute)}
+	if err := m.RPCGetPrimaryAndSecondaries(gfs.GetPrimaryAndSecondariesArg{handle, "", bobCred, ""}, &lease); !errors.Is(err, gfs.PermissionDenied) {
+		t.Error("expect a lease denied to others, got", err)
+	}
+	var info gfs.GetFileInfoReply
+	unauthenticated("file info without a token", m.RPCGetFileInfo(gfs.GetFileInfoArg{p, gfs.Credentials{}}, &info))
+
+	// the rpcs between master and the chunkservers carry a server token
+	unauthenticated("register without a token", m.RPCRegisterServer(gfs.RegisterServerArg{Address: ":7799"}, &gfs.RegisterServerReply{}))
+	unauthenticated("heartbeat with a client token", m.RPCHeartbeat(gfs.HeartbeatArg{Address: csAdd[0], Token: aliceCred.Token}, &gfs.HeartbeatReply{}))
+	unauthenticated("chunk created without a token", cs[0].RPCCreateChunk(gfs.CreateChunkArg{Handle: handle + 1<<40}, &gfs.CreateChunkReply{}))
+	unauthenticated("mutation applied with a chunk token", cs[0].RPCApplyMutation(gfs.ApplyMutationArg{Mtype: gfs.MutationTruncate, DataID: gfs.DataBufferID{Handle: handle}, Token: lease.Token}, &gfs.ApplyMutationReply{}))
+	unauthenticated("data pushed without a token", cs[0].RPCForwardData(gfs.ForwardDataArg{DataID: chunkserver.NewDataID(handle), Data: []byte("x")}, &gfs.ForwardDataReply{}))
+	if err := cs[0].RPCRevokeLease(gfs.RevokeLeaseArg{handle + 1<<40, time.Now(), secret.ServerToken()}, &gfs.RevokeLeaseReply{}); err != nil {
+		t.Error("expect a lease revoked with a server token, got", err)
+	}
+	unauthenticated("lease extended without a token", m.RPCExtendLease(gfs.ExtendLeaseArg{handle, lease.Primary, ""}, &gfs.ExtendLeaseReply{}))
+	unauthenticated("divergence reported without a token", m.RPCReportDivergence(gfs.ReportDivergenceArg{handle, lease.Primary, 0, 0, gfs.DivergentData, ""}, &gfs.ReportDivergenceReply{}))
+
+	// only SuperUser administers the cluster
+	if err := alice.SetReplication(ctx, p, 2); !errors.Is(err, gfs.PermissionDenied) {
+		t.Error("expect the replication set by another user denied, got", err)
+	}
+	if err := root.SetReplication(ctx, p, gfs.DefaultNumReplicas); err != nil {
+		t.Error(err)
+	}
+	if err := m.RPCSetMode(gfs.SetModeArg{gfs.MasterReadOnly, aliceCred}, &gfs.SetModeReply{}); !errors.Is(err, gfs.PermissionDenied) {
+		t.Error("expect the mode set by another user denied, got", err)
+	}
+	for name, err := range map[string]error{
+		"dump":         m.RPCDumpNamespace(gfs.DumpNamespaceArg{aliceCred}, &gfs.DumpNamespaceReply{}),
+		"slow queries": m.RPCGetSlowQueries(gfs.GetSlowQueriesArg{0, aliceCred}, &gfs.GetSlowQueriesReply{}),
+		"servers":      m.RPCListServers(gfs.ListServersArg{aliceCred}, &gfs.ListServersReply{}),
+		"fsck":         m.RPCFsck(gfs.FsckArg{"", false, aliceCred}, &gfs.FsckReply{}),
+	} {
+		if !errors.Is(err, gfs.PermissionDenied) {
+			t.Error("expect the", name, "of another user denied, got", err)
+		}
+	}
+	if err := m.RPCListServers(gfs.ListServersArg{rootCred}, &gfs.ListServersReply{}); err != nil {
+		t.Error(err)
+	}
+	if err := alice.Delete(ctx, p); err != nil {
+		t.Error(err)
+	}
+}
+
+// writeTestCerts writes a CA and a certificate of localhost signed by it,
+// for servers and clients, to dir.
+func writeTestCerts(dir string, t *testing.T) (certFile, keyFile, caFile string) {
+	writePEM := func(name, kind string, der []byte) string {
+		filename := path.Join(dir, name)
+		if err := ioutil.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	caKey, err1 := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key, err2 := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err1 != nil || err2 != nil {
+		t.Fatal(err1, err2)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gfs test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM("cert.pem", "CERTIFICATE", der), writePEM("key.pem", "EC PRIVATE KEY", keyDER), writePEM("ca.pem", "CERTIFICATE", caDER)
+}
+
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gfs-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config, err := util.LoadTLSConfig(writeTestCerts(dir, t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setServers := func(config *tls.Config, plaintext bool) {
+		m.SetTLSConfig(config, plaintext)
+		for _, s := range cs {
+			s.SetTLSConfig(config, plaintext)
+		}
+	}
+	setServers(config, false)
+	util.SetTLSConfig(config)
+	defer func() {
+		setServers(nil, false)
+		util.SetTLSConfig(nil)
+	}()
+
+	// a call on a connection of its own, dialed by dial
+	call := func(dial func() (net.Conn, error)) error {
+		conn, err := dial()
+		if err != nil {
+			return err
+		}
+		rc := rpc.NewClient(conn)
+		defer rc.Close()
+		return rc.Call("Master.RPCListServers", gfs.ListServersArg{}, &gfs.ListServersReply{})
+	}
+	plain := func() (net.Conn, error) { return net.Dial("tcp", mAdd) }
+	if err := call(plain); err == nil {
+		t.Error("expect plaintext refused")
+	}
+	anonymous := &tls.Config{RootCAs: config.RootCAs, ServerName: "localhost"}
+	if err := call(func() (net.Conn, error) { return tls.Dial("tcp", mAdd, anonymous) }); err == nil {
+		t.Error("expect a client without a certificate refused")
+	}
+
+	p := gfs.Path("/TestTLS.txt")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if n, err := c.Read(ctx, p, 0, buf); (err != nil && err != io.EOF) || string(buf[:n]) != "hello" {
+		t.Error("expect to read hello over TLS, got", string(buf[:n]), err)
+	}
+
+	// dev clusters may accept plaintext besides TLS
+	setServers(config, true)
+	if err := call(plain); err != nil {
+		t.Error("expect plaintext accepted, got", err)
+	}
+	withName := config.Clone()
+	withName.ServerName = "localhost"
+	if err := call(func() (net.Conn, error) { return tls.Dial("tcp", mAdd, withName) }); err != nil {
+		t.Error("expect TLS accepted besides plaintext, got", err)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	kp, err := chunkserver.StaticKeys([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range cs {
+		s.SetKeyProvider(kp)
+	}
+	defer func() {
+		for _, s := range cs {
+			s.SetKeyProvider(nil)
+		}
+	}()
+
+	p := gfs.Path("/TestEncryption.txt")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte(strings.Repeat("top secret data ", 1000))
+	if _, err := c.Write(ctx, p, 0, want); err != nil {
+		t.Fatal(err)
+	}
+	check := func(when string) {
+		buf := make([]byte, len(want)+100)
+		n, err := c.Read(ctx, p, 0, buf)
+		if (err != nil && err != io.EOF) || !bytes.Equal(buf[:n], want) {
+			t.Errorf("expect %v bytes read back %v, got %v: %v", len(want), when, n, err)
+		}
+	}
+	check("after a write")
+
+	var r gfs.GetChunkHandleReply
+	if err := m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0, false, gfs.Credentials{}}, &r); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(path.Join(root, "cs*", chunkserver.ChunkDirName, "*", "*", fmt.Sprintf("chunk%v.chk", r.Handle)))
+	if len(files) != gfs.DefaultNumReplicas {
+		t.Fatal("expect a chunk file per replica, got", files)
+	}
+	for _, f := range files {
+		if b, err := ioutil.ReadFile(f); err != nil || bytes.Contains(b, []byte("top secret")) {
+			t.Error("expect the chunk file sealed, got", f, len(b), err)
+		}
+	}
+
+	// overwrites within and across blocks, a truncate and an append past a hole
+	for _, off := range []int{5, 4090, 9000} {
+		if _, err := c.Write(ctx, p, gfs.Offset(off), []byte("OVERWRITTEN")); err != nil {
+			t.Fatal(err)
+		}
+		copy(want[off:], "OVERWRITTEN")
+	}
+	check("after overwrites")
+	if err := c.Truncate(ctx, p, 6000); err != nil {
+		t.Fatal(err)
+	}
+	want = want[:6000]
+	check("after a truncate")
+	if _, err := c.Write(ctx, p, 10000, []byte("tail")); err != nil {
+		t.Fatal(err)
+	}
+	want = append(append(want, make([]byte, 4000)...), "tail"...)
+	check("after a write past the end")
+	if offset, err := c.Append(ctx, p, []byte("appended")); err != nil || offset != 10004 {
+		t.Error("expect an append at offset 10004, got", offset, err)
+	}
+	want = append(want, "appended"...)
+	check("after an append")
+}
+
+func TestTrash(t *testing.T) {
+	dir, p := gfs.Path("/TestTrash"), gfs.Path("/TestTrash/f.txt")
+	ch := make(chan error, 3)
+	ch <- c.Mkdir(ctx, dir)
+	ch <- c.Create(ctx, p)
+	_, err := c.Write(ctx, p, 0, []byte("hello"))
+	ch <- err
+	errorAll(ch, 3, t)
+
+	var r gfs.DeleteFileReply
+	if err := m.RPCDeleteFile(gfs.DeleteFileArg{p, false, gfs.Credentials{}}, &r); err != nil || !strings.HasPrefix(string(r.Trash), string(gfs.TrashDir)+"/") || !strings.HasSuffix(string(r.Trash), string(p)) {
+		t.Fatal("expect the file moved to the trash, got", r, err)
+	}
+	if _, err := c.Stat(ctx, p); !errors.Is(err, gfs.PathNotFound) {
+		t.Error("expect the file gone, got", err)
+	}
+	if info, err := c.Stat(ctx, r.Trash); err != nil || info.Size != 5 {
+		t.Error("expect the file kept in the trash, got", info, err)
+	}
+
+	restored, err := c.Undelete(ctx, r.Trash)
+	if err != nil || restored != p {
+		t.Fatal("expect the file restored to", p, "got", restored, err)
+	}
+	buf := make([]byte, 5)
+	if n, err := c.Read(ctx, p, 0, buf); (err != nil && err != io.EOF) || string(buf[:n]) != "hello" {
+		t.Error("expect hello read after undelete, got", string(buf[:n]), err)
+	}
+	if _, err := c.Undelete(ctx, r.Trash); err == nil {
+		t.Error("expect undeleting twice to fail")
+	}
+
+	// a directory is restored with its subtree, not over a new one
+	r = gfs.DeleteFileReply{}
+	if err := m.RPCDeleteFile(gfs.DeleteFileArg{dir, true, gfs.Credentials{}}, &r); err != nil || r.Files != 1 || r.Dirs != 1 {
+		t.Fatal("expect the directory moved to the trash, got", r, err)
+	}
+	if err := c.Mkdir(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Undelete(ctx, r.Trash); !errors.Is(err, gfs.PathExists) {
+		t.Error("expect path exists, got", err)
+	}
+	if err := c.Delete(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Undelete(ctx, r.Trash); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.Stat(ctx, p); err != nil {
+		t.Error("expect the file restored with its directory, got", err)
+	}
+	if err := c.Delete(ctx, gfs.TrashDir); !errors.Is(err, gfs.InvalidArgument) {
+		t.Error("expect the trash not deleted, got", err)
+	}
+
+	// deleted for good, the chunks released past the retention
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.DeleteAll(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	var rt gfs.ReclaimTrashReply
+	if err := m.RPCReclaimTrash(gfs.ReclaimTrashArg{time.Hour, false, gfs.Credentials{}}, &rt); err != nil || rt.Removed != 0 {
+		t.Error("expect nothing reclaimed within an hour, got", rt, err)
+	}
+	var dry gfs.ReclaimTrashReply
+	if err := m.RPCReclaimTrash(gfs.ReclaimTrashArg{0, true, gfs.Credentials{}}, &dry); err != nil || dry.Removed != 0 || len(dry.Dirs) == 0 {
+		t.Error("expect the trash to reclaim listed, got", dry, err)
+	}
+	chunks := 0
+	for _, d := range dry.Dirs {
+		chunks += d.Chunks
+	}
+	if chunks == 0 {
+		t.Error("expect the chunks to release counted, got", dry.Dirs)
+	}
+	if err := m.RPCGetReplicas(gfs.GetReplicasArg{handle, gfs.Credentials{}}, &gfs.GetReplicasReply{}); err != nil {
+		t.Error("expect the chunk kept by a dry run, got", err)
+	}
+	if err := m.RPCReclaimTrash(gfs.ReclaimTrashArg{0, false, gfs.Credentials{}}, &rt); err != nil || rt.Removed != len(dry.Dirs) {
+		t.Error("expect the trash reclaimed as listed", dry.Dirs, "got", rt, err)
+	}
+	if err := m.RPCGetReplicas(gfs.GetReplicasArg{handle, gfs.Credentials{}}, &gfs.GetReplicasReply{}); err == nil {
+		t.Error("expect the chunk released")
+	}
+	if ls, err := c.List(ctx, gfs.TrashDir); err != nil || len(ls) != 0 {
+		t.Error("expect the trash empty, got", ls, err)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	dir := gfs.Path("/TestQuota")
+	if err := c.Mkdir(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.NewClient(mAdd, client.WithUser("alice")).SetQuota(ctx, dir, 1, 1); !errors.Is(err, gfs.PermissionDenied) {
+		t.Error("expect only the superuser to set quotas, got", err)
+	}
+	if err := c.SetQuota(ctx, dir, 2, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan error, 2)
+	ch <- c.Create(ctx, dir+"/a")
+	ch <- c.Create(ctx, dir+"/b")
+	errorAll(ch, 2, t)
+	if err := c.Create(ctx, dir+"/c"); !errors.Is(err, gfs.QuotaExceeded) {
+		t.Error("expect the file quota exceeded, got", err)
+	}
+
+	if _, err := c.Write(ctx, dir+"/a", 0, make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	var u gfs.QuotaUsage
+	for i := 0; i < 20 && u.Bytes < 100; i++ {
+		time.Sleep(gfs.HeartbeatInterval)
+		var err error
+		if u, err = c.GetQuotaUsage(ctx, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if u != (gfs.QuotaUsage{2, 100, 2, 100}) {
+		t.Error("expect 2 files of 100 bytes used, got", u)
+	}
+	if _, err := c.Write(ctx, dir+"/b", 0, []byte("more")); !errors.Is(err, gfs.QuotaExceeded) {
+		t.Error("expect no chunk allocated past the byte quota, got", err)
+	}
+	handle, err := c.GetChunkHandle(ctx, dir+"/a", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l gfs.GetPrimaryAndSecondariesReply
+	if err := m.RPCGetPrimaryAndSecondaries(gfs.GetPrimaryAndSecondariesArg{handle, "", gfs.Credentials{}, ""}, &l); !errors.Is(err, gfs.QuotaExceeded) {
+		t.Error("expect no lease past the byte quota, got", err)
+	}
+
+	// the trash is not counted, but undeletes are
+	var r gfs.DeleteFileReply
+	if err := m.RPCDeleteFile(gfs.DeleteFileArg{dir + "/b", false, gfs.Credentials{}}, &r); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create(ctx, dir+"/c"); err != nil {
+		t.Error("expect a file created once another is deleted, got", err)
+	}
+	if _, err := c.Undelete(ctx, r.Trash); !errors.Is(err, gfs.QuotaExceeded) {
+		t.Error("expect the undelete past the quota refused, got", err)
+	}
+
+	if err := c.SetQuota(ctx, dir, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Undelete(ctx, r.Trash); err != nil {
+		t.Error("expect the undelete without quota, got", err)
+	}
+	if _, err := c.Write(ctx, dir+"/b", 0, []byte("more")); err != nil {
+		t.Error("expect writes without quota, got", err)
+	}
+}
+
+// copies over the background budget are throttled, without starving reads of other clients
+func TestRateLimits(t *testing.T) {
+	p := gfs.Path("/ratelimits.txt")
+	data := []byte("read and copied under limits")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l gfs.GetReplicasReply
+	if err := m.RPCGetReplicas(gfs.GetReplicasArg{handle, gfs.Credentials{}}, &l); err != nil || len(l.Locations) < 2 {
+		t.Fatal("expect replicas of chunk", handle, "got", l, err)
+	}
+	var s *chunkserver.ChunkServer
+	for i, v := range csAdd {
+		if v == l.Locations[0] {
+			s = cs[i]
+		}
+	}
+
+	defer s.SetRateLimits(0, 0, 0)
+	s.SetRateLimits(10, 0, 10)
+	read := func(client string) error {
+		var r gfs.ReadChunkReply
+		return s.RPCReadChunk(gfs.ReadChunkArg{handle, 0, len(data), "", client}, &r)
+	}
+	if err := read("a"); err != nil {
+		t.Error("expect a read larger than a burst served, got", err)
+	}
+	if err := read("a"); !errors.Is(err, gfs.Throttled) {
+		t.Error("expect the client over its budget throttled, got", err)
+	}
+	if err := s.RPCSendCopy(gfs.SendCopyArg{handle, l.Locations[1], false, ""}, &gfs.SendCopyReply{}); err != nil {
+		t.Error("expect the first copy served, got", err)
+	}
+	if err := s.RPCSendCopy(gfs.SendCopyArg{handle, l.Locations[1], false, ""}, &gfs.SendCopyReply{}); !errors.Is(err, gfs.Throttled) {
+		t.Error("expect copies over the background budget throttled, got", err)
+	}
+	if err := read("b"); err != nil {
+		t.Error("expect reads of another client served, got", err)
+	}
+
+	s.SetRateLimits(0, 0, 0)
+	if err := read("a"); err != nil {
+		t.Error("expect reads served without limits, got", err)
+	}
+}
+
+// copies are sent in paced pieces, and start over if the chunk is written meanwhile
+func TestCopyPieces(t *testing.T) {
+	p := gfs.Path("/copypieces.txt")
+	data := make([]byte, 400)
+	for i := range data {
+		data[i] = byte('a' + i%26)
+	}
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l gfs.GetReplicasReply
+	if err := m.RPCGetReplicas(gfs.GetReplicasArg{handle, gfs.Credentials{}}, &l); err != nil || len(l.Locations) < 2 {
+		t.Fatal("expect replicas of chunk", handle, "got", l, err)
+	}
+	var s *chunkserver.ChunkServer
+	for i, v := range csAdd {
+		if v == l.Locations[0] {
+			s = cs[i]
+		}
+	}
+
+	if err := util.Call(ctx, l.Locations[0], "ChunkServer.RPCSetCopyLimits", gfs.SetCopyLimitsArg{-1, 0, gfs.Credentials{}}, &gfs.SetCopyLimitsReply{}); !errors.Is(err, gfs.InvalidArgument) {
+		t.Error("expect negative limits refused, got", err)
+	}
+	if err := util.Call(ctx, l.Locations[0], "ChunkServer.RPCSetCopyLimits", gfs.SetCopyLimitsArg{50, 200, gfs.Credentials{}}, &gfs.SetCopyLimitsReply{}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.SetCopyLimits(0, 0)
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.RPCSendCopy(gfs.SendCopyArg{handle, l.Locations[1], false, ""}, &gfs.SendCopyReply{})
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := c.Write(ctx, p, 10, []byte("written during the copy")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 500*time.Millisecond {
+		t.Error("expect the copy paced at 200 bytes per second, took", d)
+	}
+
+	var want, got gfs.ReadChunkReply
+	if err := s.RPCReadChunk(gfs.ReadChunkArg{handle, 0, len(data), "", ""}, &want); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.Call(ctx, l.Locations[1], "ChunkServer.RPCReadChunk", gfs.ReadChunkArg{handle, 0, len(data), "", ""}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Data[:got.Length]) != string(want.Data[:want.Length]) || got.Version != want.Version {
+		t.Error("expect the copy identical to the chunk, got version", got.Version, "want", want.Version)
+	}
+}
+
+// a stale replica is repaired with the ranges it lacks, or copied whole if it diverged otherwise
+func TestRepairReplica(t *testing.T) {
+	s1 := chunkserver.NewAndServe(":7781", ":7775", path.Join(root, "repair-1")) // master is unreachable
+	defer s1.Shutdown()
+	s2 := chunkserver.NewAndServe(":7782", ":7775", path.Join(root, "repair-2"))
+	defer s2.Shutdown()
+
+	lease := func(s *chunkserver.ChunkServer, handle gfs.ChunkHandle, version gfs.ChunkVersion) bool {
+		var r gfs.CheckVersionReply
+		if err := s.RPCCheckVersion(gfs.CheckVersionArg{handle, version, gfs.ThrottlePolicy{}, ""}, &r); err != nil {
+			t.Fatal(err)
+		}
+		return !r.Stale
+	}
+	write := func(s *chunkserver.ChunkServer, handle gfs.ChunkHandle, offset gfs.Offset, data string) {
+		id := chunkserver.NewDataID(handle)
+		if err := s.RPCForwardData(gfs.ForwardDataArg{DataID: id, Data: []byte(data)}, &gfs.ForwardDataReply{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RPCApplyMutation(gfs.ApplyMutationArg{gfs.MutationWrite, id, offset, "", false, ""}, &gfs.ApplyMutationReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	truncate := func(s *chunkserver.ChunkServer, handle gfs.ChunkHandle, length gfs.Offset) {
+		if err := s.RPCApplyMutation(gfs.ApplyMutationArg{gfs.MutationTruncate, gfs.DataBufferID{Handle: handle}, length, "", false, ""}, &gfs.ApplyMutationReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(s *chunkserver.ChunkServer, handle gfs.ChunkHandle) (string, gfs.ChunkVersion) {
+		var r gfs.ReadChunkReply
+		if err := s.RPCReadChunk(gfs.ReadChunkArg{handle, 0, 1 << 10, "", ""}, &r); err != nil {
+			t.Fatal(err)
+		}
+		return string(r.Data[:r.Length]), r.Version
+	}
+
+	base := strings.Repeat("0123456789", 20)
+	for i, diverged := range []bool{false, true} {
+		handle := gfs.ChunkHandle(1<<41 + i)
+		for _, s := range []*chunkserver.ChunkServer{s1, s2} {
+			if err := s.RPCCreateChunk(gfs.CreateChunkArg{Handle: handle}, &gfs.CreateChunkReply{}); err != nil {
+				t.Fatal(err)
+			}
+			lease(s, handle, 1)
+			write(s, handle, 0, base)
+			lease(s, handle, 2)
+		}
+		if diverged {
+			write(s2, handle, 40, "applied on s2 only")
+		}
+
+		// s2 misses the next leases, and is found stale by the one after
+		lease(s1, handle, 3)
+		write(s1, handle, 20, "missed by s2")
+		truncate(s1, handle, 100)
+		lease(s1, handle, 4)
+		write(s1, handle, 120, "past a hole")
+		if !lease(s1, handle, 5) || lease(s2, handle, 5) {
+			t.Fatal("expect the replica on s2 stale")
+		}
+
+		var r gfs.SendCopyReply
+		if err := s1.RPCSendCopy(gfs.SendCopyArg{handle, ":7782", true, ""}, &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Delta == diverged {
+			t.Error("expect a repair by delta", !diverged, "got", r.Delta)
+		}
+		want, wantVersion := read(s1, handle)
+		if got, version := read(s2, handle); got != want || version != wantVersion {
+			t.Errorf("expect the repaired replica %q at version %v, got %q at %v", want, wantVersion, got, version)
+		}
+	}
+}
+
+func TestFsck(t *testing.T) {
+	p := gfs.Path("/TestFsck")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, []byte("checked")); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsck := func() (*gfs.FsckReply, []gfs.FsckProblem) {
+		var r gfs.FsckReply
+		if err := util.Call(ctx, mAdd, "Master.RPCFsck", gfs.FsckArg{p, true, gfs.Credentials{}}, &r); err != nil {
+			t.Fatal(err)
+		}
+		var problems []gfs.FsckProblem
+		for _, v := range r.Problems {
+			if v.Handle == handle || v.Path == p {
+				problems = append(problems, v)
+			}
+		}
+		return &r, problems
+	}
+	if r, problems := fsck(); r.Files != 1 || r.Chunks != 1 || r.Servers == 0 || len(problems) > 0 {
+		t.Errorf("expect a healthy file with 1 chunk, got %+v", r)
+	}
+
+	// a replica running ahead of master
+	var r gfs.GetReplicasReply
+	if err := util.Call(ctx, mAdd, "Master.RPCGetReplicas", gfs.GetReplicasArg{handle, gfs.Credentials{}}, &r); err != nil {
+		t.Fatal(err)
+	}
+	addr := r.Locations[0]
+	if err := util.Call(ctx, addr, "ChunkServer.RPCCheckVersion", gfs.CheckVersionArg{handle, r.Version + 1, gfs.ThrottlePolicy{}, ""}, &gfs.CheckVersionReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, problems := fsck(); len(problems) != 1 || problems[0].Kind != gfs.FsckVersionMismatch || problems[0].Server != addr {
+		t.Error("expect the replica on", addr, "at another version, got", problems)
+	}
+}
+
+func TestMasterModes(t *testing.T) {
+	p := gfs.Path("/TestMasterModes")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, []byte("kept")); err != nil {
+		t.Fatal(err)
+	}
+	setMode := func(mode gfs.MasterMode) {
+		if err := util.Call(ctx, mAdd, "Master.RPCSetMode", gfs.SetModeArg{mode, gfs.Credentials{}}, &gfs.SetModeReply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer setMode(gfs.MasterNormal)
+	nc := client.NewClient(mAdd, client.WithRetryPolicy(client.NoRetry))
+
+	setMode(gfs.MasterReadOnly)
+	if err := nc.Create(ctx, p+"-new"); !errors.Is(err, gfs.ReadOnly) {
+		t.Error("expect creates refused in read-only mode, got", err)
+	}
+	if _, err := nc.Write(ctx, p, 0, []byte("lost")); !errors.Is(err, gfs.ReadOnly) {
+		t.Error("expect writes refused in read-only mode, got", err)
+	}
+	buf := make([]byte, 4)
+	if n, err := nc.Read(ctx, p, 0, buf); err != nil && err != io.EOF || string(buf[:n]) != "kept" {
+		t.Errorf("expect reads served in read-only mode, got %q %v", buf[:n], err)
+	}
+
+	setMode(gfs.MasterMaintenance)
+	var r gfs.GetModeReply
+	if err := util.Call(ctx, mAdd, "Master.RPCGetMode", gfs.Nouse{}, &r); err != nil || r.Mode != gfs.MasterMaintenance {
+		t.Error("expect maintenance mode, got", r.Mode, err)
+	}
+	if _, err := nc.List(ctx, "/"); !errors.Is(err, gfs.InMaintenance) {
+		t.Error("expect reads refused in maintenance mode, got", err)
+	}
+
+	setMode(gfs.MasterNormal)
+	if err := nc.Create(ctx, p+"-new"); err != nil {
+		t.Error("expect creates served back in normal mode, got", err)
+	}
+}
+
+func TestDumpNamespace(t *testing.T) {
+	dir := gfs.Path("/TestDumpNamespace")
+	p := dir + "/a"
+	if err := c.Mkdir(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetQuota(ctx, dir, 10, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, []byte("dumped")); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r gfs.DumpNamespaceReply
+	if err := util.Call(ctx, mAdd, "Master.RPCDumpNamespace", gfs.DumpNamespaceArg{}, &r); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(r.Dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dump gfs.NamespaceDump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		t.Fatal(err)
+	}
+	mroot := path.Join(root, "dump-master")
+	os.Mkdir(mroot, 0755)
+	if err := master.RestoreDump(mroot, &dump); err != nil {
+		t.Fatal(err)
+	}
+	if err := master.RestoreDump(mroot, &dump); !errors.Is(err, gfs.PathExists) {
+		t.Error("expect no restore over existing metadata, got", err)
+	}
+
+	m2 := master.NewAndServe(":7779", mroot)
+	defer m2.Shutdown()
+	nc := client.NewClient(":7779", client.WithRetryPolicy(client.NoRetry))
+	if info, err := nc.Stat(ctx, p); err != nil || info.Chunks != 1 {
+		t.Error("expect the file restored with its chunk, got", info, err)
+	}
+	if h, err := nc.GetChunkHandle(ctx, p, 0); err != nil || h != handle {
+		t.Error("expect chunk", handle, "restored, got", h, err)
+	}
+	if u, err := nc.GetQuotaUsage(ctx, dir); err != nil || u.MaxFiles != 10 || u.Files != 1 {
+		t.Error("expect the quota restored, got", u, err)
+	}
+}
+
+func TestCopyConcat(t *testing.T) {
+	dir := gfs.Path("/TestCopyConcat")
+	a, b, cp := dir+"/a", dir+"/b", dir+"/copy"
+	if err := c.Mkdir(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<16) // a whole chunk of 1 MB
+	tail := []byte("tail of the concatenation")
+	for p, d := range map[gfs.Path][]byte{a: data, b: tail} {
+		if err := c.CreateWithChunkSize(ctx, p, 1<<20); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Write(ctx, p, 0, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.CopyFile(ctx, a, cp); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CopyFile(ctx, a, cp); !errors.Is(err, gfs.PathExists) {
+		t.Error("expect no copy over an existing file, got", err)
+	}
+	buf := make([]byte, len(data))
+	if n, err := c.Read(ctx, cp, 0, buf); err != nil && !errors.Is(err, io.EOF) || !bytes.Equal(buf[:n], data) {
+		t.Error("expect the copy to read as the source, got", n, err)
+	}
+	h1, err1 := c.GetChunkHandle(ctx, a, 0)
+	h2, err2 := c.GetChunkHandle(ctx, cp, 0)
+	if err1 != nil || err2 != nil || h1 == h2 {
+		t.Error("expect the chunk cloned, got", h1, h2, err1, err2)
+	}
+
+	if err := c.Concat(ctx, a, b); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := c.Stat(ctx, a); err != nil || info.Size != int64(len(data)+len(tail)) || info.Chunks != 2 {
+		t.Error("expect the chunks of b after a, got", info, err)
+	}
+	buf = make([]byte, len(data)+len(tail))
+	if n, err := c.Read(ctx, a, 0, buf); err != nil && !errors.Is(err, io.EOF) || !bytes.Equal(buf[:n], append(data, tail...)) {
+		t.Error("expect the concatenation to read as both files, got", n, err)
+	}
+	if _, err := c.Stat(ctx, b); !errors.Is(err, gfs.PathNotFound) {
+		t.Error("expect the source removed, got", err)
+	}
+	if err := c.Concat(ctx, a, cp); !errors.Is(err, gfs.InvalidArgument) {
+		t.Error("expect no concatenation after a partial chunk, got", err)
+	}
+
+	// the sources are read by whoever concatenates them
+	shared := dir + "/shared"
+	secret, target := shared+"/secret", shared+"/alice"
+	alice := client.NewClient(mAdd, client.WithUser("alice"))
+	ch := make(chan error, 5)
+	ch <- c.Mkdir(ctx, shared)
+	ch <- c.Chmod(ctx, shared, 0777)
+	ch <- c.CreateWithChunkSize(ctx, secret, 1<<20)
+	ch <- c.Chmod(ctx, secret, 0600)
+	ch <- alice.CreateWithChunkSize(ctx, target, 1<<20)
+	errorAll(ch, 5, t)
+	if _, err := c.Write(ctx, secret, 0, tail); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Concat(ctx, target, secret); !errors.Is(err, gfs.PermissionDenied) {
+		t.Error("expect the concatenation of an unreadable file denied, got", err)
+	}
+	if info, err := c.Stat(ctx, secret); err != nil || info.Size != int64(len(tail)) {
+		t.Error("expect the source left in place, got", info, err)
+	}
+}
+
+// countCalls counts the rpcs of a method dialed by clients.
+type countCalls struct {
+	method string
+	n      int32
+}
+
+func (f *countCalls) Fault(ctx context.Context, from, to gfs.ServerAddress, method string) error {
+	if from == "" && method == f.method {
+		atomic.AddInt32(&f.n, 1)
+	}
+	return nil
+}
+
+// the replica locations of a chunk are cached by the client until they
+// expire after a lease, and asked of master again afterwards
+func TestLocationBufferExpire(t *testing.T) {
+	p := gfs.Path("/TestLocationBufferExpire.txt")
+	data := []byte("located once a lease")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := &countCalls{method: "Master.RPCGetReplicas"}
+	util.SetFaultInjector(calls)
+	defer util.SetFaultInjector(nil)
+	nc := client.NewClient(mAdd)
+	read := func() int32 {
+		buf := make([]byte, len(data))
+		if n, err := nc.Read(ctx, p, 0, buf); err != nil && !errors.Is(err, io.EOF) || string(buf[:n]) != string(data) {
+			t.Error("expect", string(data), "got", string(buf[:n]), err)
+		}
+		return atomic.LoadInt32(&calls.n)
+	}
+
+	first := read()
+	if first == 0 {
+		t.Fatal("expect the locations asked of master")
+	}
+	if n := read(); n != first {
+		t.Error("expect the cached locations read with, got", n-first, "more calls")
+	}
+	time.Sleep(gfs.LocationBufferExpire)
+	if n := read(); n == first {
+		t.Error("expect the locations asked again once expired")
+	}
+}
+
+func TestReadFailover(t *testing.T) {
+	p := gfs.Path("/TestReadFailover.txt")
+	data := []byte("read from the replica left whole")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// all replicas but one lose their data
+	var files []string
+	filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err == nil && info.Name() == fmt.Sprintf("chunk%v.chk", handle) {
+			files = append(files, name)
+		}
+		return nil
+	})
+	if len(files) < 2 {
+		t.Fatal("expect replicas of chunk", handle, "got", files)
+	}
+	for _, name := range files[1:] {
+		if err := os.Truncate(name, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, nc := range []*client.Client{
+		client.NewClient(mAdd, client.WithRetryPolicy(client.NoRetry)),
+		client.NewClient(mAdd, client.WithRetryPolicy(client.NoRetry), client.WithHedgedReads(time.Millisecond)),
+	} {
+		for i := 0; i < 5; i++ {
+			buf := make([]byte, len(data))
+			if n, err := nc.Read(ctx, p, 0, buf); err != nil && !errors.Is(err, io.EOF) || string(buf[:n]) != string(data) {
+				t.Fatal("expect the read to fail over to the whole replica, got", n, err)
+			}
+		}
+	}
+}
+
+func TestReadCache(t *testing.T) {
+	p := gfs.Path("/TestReadCache.txt")
+	data := bytes.Repeat([]byte("cached"), gfs.ReadCacheBlockBytes/2) // 3 blocks
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	nc := client.NewClient(mAdd, client.WithRetryPolicy(client.NoRetry), client.WithReadCache(8<<20), client.WithReadAhead(1))
+	fetched := func(blocks int) {
+		for i := 0; nc.ReadCacheStats().Blocks != blocks; i++ {
+			if i == 100 {
+				t.Fatal("expect", blocks, "blocks cached, got", nc.ReadCacheStats())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	buf := make([]byte, 100)
+	if _, err := nc.Read(ctx, p, 0, buf); err != nil || !bytes.Equal(buf, data[:100]) {
+		t.Fatal("expect the first bytes, got", err)
+	}
+	fetched(2) // the block read and the next one
+
+	// a write of the client drops the blocks of the chunk
+	if _, err := nc.Write(ctx, p, 0, []byte("CACHED")); err != nil {
+		t.Fatal(err)
+	}
+	copy(data, "CACHED")
+	if _, err := nc.Read(ctx, p, 0, buf); err != nil || !bytes.Equal(buf, data[:100]) {
+		t.Error("expect the write read back, got", string(buf[:6]), err)
+	}
+	fetched(2)
+
+	// the replicas lose their data, the blocks cached are still read
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err == nil && info.Name() == fmt.Sprintf("chunk%v.chk", handle) {
+			os.Truncate(name, 0)
+		}
+		return nil
+	})
+	buf = make([]byte, 2*gfs.ReadCacheBlockBytes-100)
+	if n, err := nc.Read(ctx, p, 100, buf); err != nil || !bytes.Equal(buf[:n], data[100:2*gfs.ReadCacheBlockBytes]) {
+		t.Error("expect the blocks read ahead from the cache, got", n, err)
+	}
+	if st := nc.ReadCacheStats(); st.Hits == 0 || st.Prefetched == 0 {
+		t.Error("expect hits of blocks read ahead, got", st)
+	}
+}
+
+func TestParallelChunks(t *testing.T) {
+	p := gfs.Path("/TestParallelChunks.txt")
+	if err := c.CreateWithChunkSize(ctx, p, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 7<<19) // 3.5 chunks
+	for i := range data {
+		data[i] = byte(i * 7 / 1024)
+	}
+	nc := client.NewClient(mAdd, client.WithParallelism(8))
+	if n, err := nc.Write(ctx, p, 1<<20, data); err != nil || n != 1<<20+int64(len(data)) {
+		t.Fatal("expect the write after a hole chunk, got", n, err)
+	}
+
+	want := append(make([]byte, 1<<20), data...)
+	for _, rc := range []*client.Client{nc, client.NewClient(mAdd, client.WithParallelism(1))} {
+		buf := make([]byte, len(want)+100)
+		if n, err := rc.Read(ctx, p, 0, buf); err != io.EOF || !bytes.Equal(buf[:n], want) {
+			t.Error("expect the hole and the data up to EOF, got", n, err)
+		}
+		buf = make([]byte, 1<<20)
+		if n, err := rc.Read(ctx, p, 3<<19, buf); err != nil || !bytes.Equal(buf[:n], want[3<<19:5<<19]) {
+			t.Error("expect a read across chunks, got", n, err)
+		}
+	}
+}
+
+func TestStreamTransfers(t *testing.T) {
+	p := gfs.Path("/TestStreamTransfers.txt")
+	if err := c.CreateWithChunkSize(ctx, p, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("streamed in pieces "), 1<<17) // 2.4 chunks
+	if n, err := c.WriteFrom(ctx, p, 100, bytes.NewReader(data)); err != nil || n != int64(len(data)) {
+		t.Fatal("expect the reader written, got", n, err)
+	}
+	want := append(make([]byte, 100), data...)
+
+	var out bytes.Buffer
+	if n, err := c.ReadTo(ctx, p, 0, -1, &out); err != nil || n != int64(len(want)) || !bytes.Equal(out.Bytes(), want) {
+		t.Error("expect the file copied up to its end, got", n, err)
+	}
+	out.Reset()
+	if n, err := c.ReadTo(ctx, p, 1<<20-10, 1<<20, &out); err != nil || !bytes.Equal(out.Bytes(), want[1<<20-10:2<<20-10]) {
+		t.Error("expect length bytes across chunks, got", n, err)
+	}
+}
+
+func TestDataStreams(t *testing.T) {
+	p := gfs.Path("/TestDataStreams.txt")
+	data := bytes.Repeat([]byte("pushed and read on streams "), 1<<16) // 1.7 MB
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReplicas(handle, len(data), t)
+
+	// the data is the body of the stream, the rpc replies with the same
+	var l gfs.GetReplicasReply
+	if err := m.RPCGetReplicas(gfs.GetReplicasArg{handle, gfs.Credentials{}}, &l); err != nil {
+		t.Fatal(err)
+	}
+	args := gfs.ReadChunkArg{handle, 10, len(data), "", ""}
+	for _, addr := range l.Locations {
+		var r gfs.ReadChunkReply
+		buf := make([]byte, len(data))
+		n, err := util.CallStream(ctx, addr, "ChunkServer.RPCReadChunk", args, nil, &r, buf)
+		if err != nil || n != len(data)-10 || r.ErrorCode != gfs.ReadEOF || r.Data != nil || !bytes.Equal(buf[:n], data[10:]) {
+			t.Error("expect the chunk read on a stream from", addr, "got", n, r.ErrorCode, err)
+		}
+		var rr gfs.ReadChunkReply
+		if err := util.Call(ctx, addr, "ChunkServer.RPCReadChunk", args, &rr); err != nil || !bytes.Equal(rr.Data, data[10:]) {
+			t.Error("expect the chunk read by rpc from", addr, "got", err)
+		}
+	}
+
+	// errors keep the connection
+	_, err = util.CallStream(ctx, l.Locations[0], "ChunkServer.RPCReadChunk", gfs.ReadChunkArg{handle + 1000, 0, 1, "", ""}, nil, &gfs.ReadChunkReply{}, nil)
+	if !errors.Is(err, gfs.ChunkNotFound) {
+		t.Error("expect chunk not found, got", err)
+	}
+	if _, err := util.CallStream(ctx, l.Locations[0], "ChunkServer.NoSuchMethod", args, nil, &gfs.ReadChunkReply{}, nil); err == nil {
+		t.Error("expect an unknown stream method to fail")
+	}
+	var r gfs.ReadChunkReply
+	if n, err := util.CallStream(ctx, l.Locations[0], "ChunkServer.RPCReadChunk", gfs.ReadChunkArg{handle, 0, 5, "", ""}, nil, &r, make([]byte, 5)); err != nil || n != 5 {
+		t.Error("expect a call after errors, got", n, err)
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	dial := func(addr gfs.ServerAddress) *grpc.ClientConn {
+		conn, err := grpc.NewClient("passthrough:///"+string(addr), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	conn := dial(mAdd)
+	defer conn.Close()
+	mc := pb.NewMasterClient(conn)
+
+	if _, err := mc.Mkdir(ctx, &pb.MkdirArg{Path: "/TestGRPC"}); err != nil {
+		t.Fatal(err)
+	}
+	p := "/TestGRPC/a"
+	if _, err := mc.CreateFile(ctx, &pb.CreateFileArg{Path: p}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := mc.CreateFile(ctx, &pb.CreateFileArg{Path: p})
+	if status.Code(err) != codes.AlreadyExists || !errors.Is(pb.ToError(err), gfs.PathExists) {
+		t.Error("expect the file to exist, got", err)
+	}
+	list, err := mc.List(ctx, &pb.ListArg{Path: "/TestGRPC"})
+	if err != nil || len(list.Files) != 1 || list.Files[0].Name != "a" || list.Files[0].IsDir {
+		t.Error("expect the file listed, got", list, err)
+	}
+
+	// written by net/rpc, read by gRPC on the same ports
+	data := []byte("read over gRPC")
+	if _, err := c.Write(ctx, gfs.Path(p), 0, data); err != nil {
+		t.Fatal(err)
+	}
+	info, err := mc.GetFileInfo(ctx, &pb.GetFileInfoArg{Path: p})
+	if err != nil || info.Length != int64(len(data)) || info.Chunks != 1 || info.ModTime == nil {
+		t.Error("expect the info of the file, got", info, err)
+	}
+	h, err := mc.GetChunkHandle(ctx, &pb.GetChunkHandleArg{Path: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := mc.GetReplicas(ctx, &pb.GetReplicasArg{Handle: h.Handle})
+	if err != nil || len(l.Locations) == 0 {
+		t.Fatal("expect the replicas of the chunk, got", l, err)
+	}
+	for _, addr := range l.Locations {
+		conn := dial(gfs.ServerAddress(addr))
+		r, err := pb.NewChunkServerClient(conn).ReadChunk(ctx, &pb.ReadChunkArg{Handle: h.Handle, Offset: 5, Length: 100, Token: l.Token})
+		if err != nil || !bytes.Equal(r.Data, data[5:]) || r.ErrorCode != pb.ErrorCode_READ_EOF {
+			t.Error("expect the chunk read over gRPC from", addr, "got", r, err)
+		}
+		_, err = pb.NewChunkServerClient(conn).ReadChunk(ctx, &pb.ReadChunkArg{Handle: h.Handle + 1000, Length: 1})
+		if status.Code(err) != codes.NotFound || !errors.Is(pb.ToError(err), gfs.ChunkNotFound) {
+			t.Error("expect chunk not found, got", err)
+		}
+		conn.Close()
+	}
+
+	// net/rpc is still served
+	buf := make([]byte, len(data))
+	if n, err := c.Read(ctx, gfs.Path(p), 0, buf); n != len(data) || !bytes.Equal(buf, data) {
+		t.Error("expect the file read by rpc, got", n, err)
+	}
+}
+
+func TestS3Gateway(t *testing.T) {
+	if err := c.Mkdir(ctx, "/TestS3Gateway"); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s3.New(c, "/TestS3Gateway"))
+	defer srv.Close()
+	do := func(method, url string, body []byte, header ...string) (int, http.Header, string) {
+		req, err := http.NewRequest(method, srv.URL+url, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header, string(b)
+	}
+
+	if code, _, _ := do("PUT", "/bucket", nil); code != 200 {
+		t.Fatal("expect the bucket created, got", code)
+	}
+	if code, _, body := do("PUT", "/nobucket/a", []byte("x")); code != 404 || !strings.Contains(body, "NoSuchBucket") {
+		t.Error("expect no such bucket, got", code, body)
+	}
+	data := []byte("an object stored in gfs")
+	code, h, _ := do("PUT", "/bucket/dir/a.txt", data)
+	if sum := md5.Sum(data); code != 200 || h.Get("ETag") != fmt.Sprintf("\"%x\"", sum) {
+		t.Error("expect the object put with its md5, got", code, h.Get("ETag"))
+	}
+	do("PUT", "/bucket/dir/b.txt", []byte("b"))
+	do("PUT", "/bucket/c.txt", []byte("c"))
+	if code, _, body := do("GET", "/bucket/dir/a.txt", nil); code != 200 || body != string(data) {
+		t.Error("expect the object, got", code, body)
+	}
+	if code, _, body := do("GET", "/bucket/dir/a.txt", nil, "Range", "bytes=3-8"); code != 206 || body != string(data[3:9]) {
+		t.Error("expect a range of the object, got", code, body)
+	}
+	if code, _, body := do("GET", "/bucket/none", nil); code != 404 || !strings.Contains(body, "NoSuchKey") {
+		t.Error("expect no such key, got", code, body)
+	}
+
+	// an object put again is renamed over the old one, a directory is not
+	if code, _, _ := do("PUT", "/bucket/c.txt", []byte("c again")); code != 200 {
+		t.Error("expect the object put again, got", code)
+	}
+	if _, _, body := do("GET", "/bucket/c.txt", nil); body != "c again" {
+		t.Error("expect the object replaced, got", body)
+	}
+	if code, _, _ := do("PUT", "/bucket/dir", []byte("x")); code == 200 {
+		t.Error("expect no object put over a directory, got", code)
+	}
+	if _, _, body := do("GET", "/bucket/dir/a.txt", nil); body != string(data) {
+		t.Error("expect the objects of the directory left, got", body)
+	}
+	if list, err := c.List(ctx, "/TestS3Gateway/"+s3.UploadDir); err != nil || len(list) != 0 {
+		t.Error("expect no upload left, got", list, err)
+	}
+
+	// listings, with a delimiter and by pages
+	_, _, body := do("GET", "/bucket?list-type=2&delimiter=/", nil)
+	if !strings.Contains(body, "<Key>c.txt</Key>") || !strings.Contains(body, "<Prefix>dir/</Prefix>") || strings.Contains(body, "a.txt") {
+		t.Error("expect c.txt and the prefix dir/, got", body)
+	}
+	_, _, body = do("GET", "/bucket?list-type=2&prefix=dir/&max-keys=1", nil)
+	if !strings.Contains(body, "<Key>dir/a.txt</Key>") || !strings.Contains(body, "<IsTruncated>true</IsTruncated>") {
+		t.Fatal("expect the first page, got", body)
+	}
+	_, _, body = do("GET", "/bucket?list-type=2&prefix=dir/&continuation-token=dir/a.txt", nil)
+	if !strings.Contains(body, "<Key>dir/b.txt</Key>") || strings.Contains(body, "<Key>dir/a.txt</Key>") {
+		t.Error("expect the second page, got", body)
+	}
+
+	// multipart upload
+	_, _, body = do("POST", "/bucket/big?uploads", nil)
+	var init struct{ UploadId string }
+	if err := xml.Unmarshal([]byte(body), &init); err != nil || init.UploadId == "" {
+		t.Fatal("expect an upload id, got", body, err)
+	}
+	parts := [][]byte{bytes.Repeat([]byte("1"), 1<<20+5), []byte("second part")}
+	complete := "<CompleteMultipartUpload>"
+	for i, part := range parts {
+		code, h, _ := do("PUT", fmt.Sprintf("/bucket/big?partNumber=%v&uploadId=%v", i+1, init.UploadId), part)
+		if code != 200 {
+			t.Fatal("expect the part uploaded, got", code)
+		}
+		complete += fmt.Sprintf("<Part><PartNumber>%v</PartNumber><ETag>%v</ETag></Part>", i+1, h.Get("ETag"))
+	}
+	complete += "</CompleteMultipartUpload>"
+	wrong := "<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>\"" + strings.Repeat("0", 32) + "\"</ETag></Part></CompleteMultipartUpload>"
+	// the upload is of big alone
+	if code, _, body := do("PUT", "/bucket/other?partNumber=3&uploadId="+init.UploadId, []byte("x")); code != 404 || !strings.Contains(body, "NoSuchUpload") {
+		t.Error("expect no upload of another key, got", code, body)
+	}
+	if code, _, body := do("POST", "/bucket/other?uploadId="+init.UploadId, []byte(complete)); code != 404 || !strings.Contains(body, "NoSuchUpload") {
+		t.Error("expect no upload of another key completed, got", code, body)
+	}
+	if code, _, _ := do("DELETE", "/bucket/other?uploadId="+init.UploadId, nil); code != 404 {
+		t.Error("expect no upload of another key aborted, got", code)
+	}
+	if code, _, body := do("POST", "/bucket/big?uploadId="+init.UploadId, []byte(wrong)); code != 400 || !strings.Contains(body, "InvalidPart") {
+		t.Error("expect the ETag of a part checked, got", code, body)
+	}
+	if code, _, body := do("POST", "/bucket/big?uploadId="+init.UploadId, []byte(complete)); code != 200 || !strings.Contains(body, "-2") {
+		t.Error("expect the upload completed, got", code, body)
+	}
+	if _, _, body := do("GET", "/bucket/big", nil); body != string(parts[0])+string(parts[1]) {
+		t.Error("expect the parts appended, got", len(body), "bytes")
+	}
+	if code, _, _ := do("DELETE", "/bucket/big?uploadId="+init.UploadId, nil); code != 404 {
+		t.Error("expect the upload gone, got", code)
+	}
+
+	if code, _, body := do("DELETE", "/bucket", nil); code != 409 || !strings.Contains(body, "BucketNotEmpty") {
+		t.Error("expect the bucket not empty, got", code, body)
+	}
+	for _, key := range []string{"dir/a.txt", "dir/b.txt", "c.txt", "big"} {
+		if code, _, _ := do("DELETE", "/bucket/"+key, nil); code != 204 {
+			t.Error("expect", key, "deleted, got", code)
+		}
+	}
+	if code, _, _ := do("DELETE", "/bucket", nil); code != 204 {
+		t.Error("expect the bucket deleted, got", code)
+	}
+	if _, _, body := do("GET", "/", nil); strings.Contains(body, "<Name>bucket</Name>") {
+		t.Error("expect no bucket, got", body)
+	}
+}
+
+func TestWebHDFSGateway(t *testing.T) {
+	srv := httptest.NewServer(webhdfs.New(c))
+	defer srv.Close()
+	do := func(method, p, query string, body []byte) (int, string) {
+		req, err := http.NewRequest(method, srv.URL+webhdfs.Prefix+p+"?"+query, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	if code, body := do("PUT", "/TestWebHDFS/a/b", "op=MKDIRS", nil); code != 200 || !strings.Contains(body, "true") {
+		t.Fatal("expect the directories made, got", code, body)
+	}
+	// the redirect is followed with the data, as by Hadoop
+	if code, _ := do("PUT", "/TestWebHDFS/a/f.txt", "op=CREATE&blocksize=1048576&replication=2", []byte("created ")); code != 201 {
+		t.Fatal("expect the file created, got", code)
+	}
+	if code, body := do("PUT", "/TestWebHDFS/a/f.txt", "op=CREATE", []byte("x")); code != 403 || !strings.Contains(body, "FileAlreadyExistsException") {
+		t.Error("expect the file to exist, got", code, body)
+	}
+	if code, _ := do("POST", "/TestWebHDFS/a/f.txt", "op=APPEND", []byte("and appended")); code != 200 {
+		t.Error("expect the file appended, got", code)
+	}
+	if code, body := do("GET", "/TestWebHDFS/a/f.txt", "op=OPEN", nil); code != 200 || body != "created and appended" {
+		t.Error("expect the file read, got", code, body)
+	}
+	if _, body := do("GET", "/TestWebHDFS/a/f.txt", "op=OPEN&offset=8&length=3", nil); body != "and" {
+		t.Error("expect a range of the file read, got", body)
+	}
+
+	var ls struct {
+		FileStatuses struct{ FileStatus []webhdfs.FileStatus }
+	}
+	_, body := do("GET", "/TestWebHDFS/a", "op=LISTSTATUS", nil)
+	if err := json.Unmarshal([]byte(body), &ls); err != nil || len(ls.FileStatuses.FileStatus) != 2 {
+		t.Fatal("expect 2 entries, got", body, err)
+	}
+	b, f := ls.FileStatuses.FileStatus[0], ls.FileStatuses.FileStatus[1]
+	if b.PathSuffix != "b" || b.Type != "DIRECTORY" || f.PathSuffix != "f.txt" || f.Type != "FILE" || f.Length != 20 || f.BlockSize != 1<<20 || f.Replication != 2 {
+		t.Error("expect b and f.txt listed, got", ls)
+	}
+
+	// renamed into a directory, the chunks moving
+	if _, body := do("PUT", "/TestWebHDFS/a/f.txt", "op=RENAME&destination=/TestWebHDFS/a/b", nil); !strings.Contains(body, "true") {
+		t.Error("expect the file renamed, got", body)
+	}
+	if code, body := do("GET", "/TestWebHDFS/a/b/f.txt", "op=OPEN", nil); code != 200 || body != "created and appended" {
+		t.Error("expect the file renamed read, got", code, body)
+	}
+	if code, body := do("GET", "/TestWebHDFS/a/f.txt", "op=GETFILESTATUS", nil); code != 404 || !strings.Contains(body, "FileNotFoundException") {
+		t.Error("expect the source gone, got", code, body)
+	}
+	// directories are renamed too, into themselves they are not
+	if _, body := do("PUT", "/TestWebHDFS/a/b", "op=RENAME&destination=/TestWebHDFS/c", nil); !strings.Contains(body, "true") {
+		t.Error("expect the directory renamed, got", body)
+	}
+	if code, body := do("GET", "/TestWebHDFS/c/f.txt", "op=OPEN", nil); code != 200 || body != "created and appended" {
+		t.Error("expect the file of the renamed directory read, got", code, body)
+	}
+	if _, body := do("PUT", "/TestWebHDFS/c", "op=RENAME&destination=/TestWebHDFS/c/d", nil); !strings.Contains(body, "false") {
+		t.Error("expect no rename into itself, got", body)
+	}
+	if _, body := do("PUT", "/TestWebHDFS/c", "op=RENAME&destination=/TestWebHDFS/a/b", nil); !strings.Contains(body, "true") {
+		t.Error("expect the directory renamed back, got", body)
+	}
+
+	// concurrent appends are made one after the other
+	if code, _ := do("PUT", "/TestWebHDFS/a/appends.txt", "op=CREATE", nil); code != 201 {
+		t.Fatal("expect the file created, got", code)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			url := srv.URL + webhdfs.Prefix + "/TestWebHDFS/a/appends.txt?op=APPEND&data=true"
+			resp, err := http.Post(url, "application/octet-stream", bytes.NewReader(bytes.Repeat([]byte{byte('a' + i)}, 1000)))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != 200 {
+				t.Error("expect the file appended, got", resp.StatusCode)
+			}
+		}(i)
+	}
+	wg.Wait()
+	_, body = do("GET", "/TestWebHDFS/a/appends.txt", "op=OPEN", nil)
+	for i := 0; i < 8; i++ {
+		if n := strings.Count(body, string(rune('a'+i))); len(body) != 8000 || n != 1000 {
+			t.Error("expect 8 appends of 1000 bytes, got", len(body), "bytes,", n, "of append", i)
+			break
+		}
+	}
+
+	if code, body := do("DELETE", "/TestWebHDFS/a", "op=DELETE", nil); code != 403 || !strings.Contains(body, "PathIsNotEmptyDirectoryException") {
+		t.Error("expect the directory not empty, got", code, body)
+	}
+	if _, body := do("DELETE", "/TestWebHDFS/a", "op=DELETE&recursive=true", nil); !strings.Contains(body, "true") {
+		t.Error("expect the directory deleted, got", body)
+	}
+	if _, body := do("DELETE", "/TestWebHDFS/a", "op=DELETE", nil); !strings.Contains(body, "false") {
+		t.Error("expect nothing deleted, got", body)
+	}
+}
+
+func TestBlockLocations(t *testing.T) {
+	p := gfs.Path("/TestBlockLocations.txt")
+	if err := c.CreateWithChunkSize(ctx, p, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, make([]byte, 5<<19)); err != nil { // 2.5 chunks
+		t.Fatal(err)
+	}
+
+	blocks, err := c.GetFileBlockLocations(ctx, p, 1<<19, 1<<20)
+	if err != nil || len(blocks) != 2 {
+		t.Fatal("expect 2 blocks, got", blocks, err)
+	}
+	if blocks[0].Offset != 1<<19 || blocks[0].Length != 1<<19 || blocks[1].Offset != 1<<20 || blocks[1].Length != 1<<19 {
+		t.Error("expect the blocks cut at the chunk boundary, got", blocks)
+	}
+	for i, b := range blocks {
+		handle, _ := c.GetChunkHandle(ctx, p, gfs.ChunkIndex(i))
+		if b.Handle != handle || len(b.Hosts) == 0 {
+			t.Error("expect chunk", handle, "with its replicas, got", b)
+		}
+	}
+	if blocks, err := c.GetFileBlockLocations(ctx, p, 2<<20, 1<<30); err != nil || len(blocks) != 1 || blocks[0].Length != 1<<19 {
+		t.Error("expect the range clamped to the file, got", blocks, err)
+	}
+
+	splits, err := c.InputSplits(ctx, p, 2<<20)
+	if err != nil || len(splits) != 2 {
+		t.Fatal("expect 2 splits, got", splits, err)
+	}
+	if splits[0].Offset != 0 || splits[0].Length != 2<<20 || splits[1].Offset != 2<<20 || splits[1].Length != 1<<19 || len(splits[0].Hosts) == 0 {
+		t.Error("expect splits of two chunks, got", splits)
+	}
+}
+
+func TestLocalReads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gfs-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, s := range cs {
+		if err := s.SetLocalReads(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := gfs.Path("/TestLocalReads.txt")
+	data := bytes.Repeat([]byte("read from the chunk file "), 1000)
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l gfs.GetReplicasReply
+	if err := m.RPCGetReplicas(gfs.GetReplicasArg{handle, gfs.Credentials{}}, &l); err != nil {
+		t.Fatal(err)
+	}
+
+	// the chunkserver passes the file of the chunk
+	conn, err := net.Dial("unix", util.LocalReadSocket(dir, l.Locations[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gob.NewEncoder(conn).Encode(gfs.ReadChunkArg{handle, 0, 10, "", ""})
+	var r gfs.LocalReadReply
+	f, err := util.RecvFile(conn.(*net.UnixConn), &r)
+	conn.Close()
+	if err != nil || f == nil || r.Error != "" || r.ChunkLength != gfs.Offset(len(data)) {
+		t.Fatal("expect the chunk file passed, got", r, err)
+	}
+	buf := make([]byte, 10)
+	if _, err := f.ReadAt(buf, 0); err != nil || !bytes.Equal(buf, data[:10]) {
+		t.Error("expect the chunk read from its file, got", buf, err)
+	}
+	f.Close()
+
+	lc := client.NewClient(mAdd, client.WithLocalReads(dir))
+	buf = make([]byte, len(data)+10)
+	if n, err := lc.Read(ctx, p, 5, buf); err != io.EOF || n != len(data)-5 || !bytes.Equal(buf[:n], data[5:]) {
+		t.Error("expect the file read up to its end, got", n, err)
+	}
+}
+
+// a master and chunkservers on unix domain sockets serve clients of the host
+func TestUnixSockets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gfs-unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	addr := func(name string) gfs.ServerAddress {
+		return gfs.ServerAddress(gfs.UnixPrefix + path.Join(dir, name+".sock"))
+	}
+	mroot := path.Join(dir, "m")
+	os.Mkdir(mroot, 0755)
+	m2 := master.NewAndServe(addr("m"), mroot)
+	defer m2.Shutdown()
+	for i := 0; i < gfs.DefaultNumReplicas; i++ {
+		name := fmt.Sprintf("cs%v", i)
+		s := chunkserver.NewAndServe(addr(name), addr("m"), path.Join(dir, name))
+		defer s.Shutdown()
+	}
+
+	nc := client.NewClient(addr("m"))
+	p := gfs.Path("/TestUnixSockets")
+	if err := nc.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("over unix domain sockets")
+	for start := time.Now(); ; time.Sleep(100 * time.Millisecond) { // until the chunkservers register
+		if _, err = nc.Write(ctx, p, 0, data); err == nil || time.Since(start) > 10*time.Second {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(data))
+	if n, err := nc.Read(ctx, p, 0, buf); err != nil && !errors.Is(err, io.EOF) || !bytes.Equal(buf[:n], data) {
+		t.Errorf("expect %q read back, got %q, %v", data, buf[:n], err)
+	}
+
+	m2.Shutdown()
+	if _, err := os.Stat(path.Join(dir, "m.sock")); !os.IsNotExist(err) {
+		t.Error("expect the socket of master removed on shutdown, got", err)
+	}
+}
+
+// spanRecorder keeps the spans exported
+type spanRecorder struct {
+	sync.Mutex
+	spans []util.Span
+}
+
+func (r *spanRecorder) ExportSpan(s util.Span) {
+	r.Lock()
+	r.spans = append(r.spans, s)
+	r.Unlock()
+}
+
+// spans of an append share the trace of the client across the push, the
+// mutation of the primary, the ones of the secondaries and the lease
+func TestTracing(t *testing.T) {
+	r := &spanRecorder{}
+	util.SetSpanExporter(r)
+	defer util.SetSpanExporter(nil)
+
+	p := gfs.Path("/TestTracing.txt")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	trace := util.NewTraceID()
+	if _, err := c.Append(util.WithTrace(ctx, trace), p, []byte("traced")); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	names := make(map[string]int)
+	for _, s := range r.spans {
+		if s.Trace == trace {
+			names[s.Name]++
+		}
+	}
+	want := map[string]int{
+		"Client.Append":                      1,
+		"Master.RPCGetPrimaryAndSecondaries": 1,
+		"ChunkServer.RPCForwardData":         gfs.DefaultNumReplicas,
+		"ChunkServer.RPCAppendChunk":         1,
+		"ChunkServer.RPCApplyMutation":       gfs.DefaultNumReplicas - 1,
+	}
+	for name, n := range want {
+		if names[name] != n {
+			t.Errorf("expect %v spans %v of the trace, got %v", n, name, names[name])
+		}
+	}
+}
+
+// subsystems log at their own levels into a file rotated past its size cap
+func TestLogging(t *testing.T) {
+	if levels, err := util.ParseLogLevels("heartbeat=warning, replication=debug"); err != nil || levels[util.LogHeartbeat] != log.WarnLevel || levels[util.LogReplication] != log.DebugLevel {
+		t.Error("expect the levels parsed, got", levels, err)
+	}
+	if _, err := util.ParseLogLevels("heartbeat"); err == nil {
+		t.Error("expect no level parsed without a subsystem")
+	}
+	dir, err := ioutil.TempDir("", "gfs-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := path.Join(dir, "rotated.log")
+	f, err := util.OpenRotatingFile(name, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(f, "%039d\n", i)
+	}
+	f.Close()
+	for _, suffix := range []string{"", ".1", ".2"} {
+		if info, err := os.Stat(name + suffix); err != nil || info.Size() > 100 {
+			t.Error("expect a log file of 100 bytes at most", name+suffix, "got", info, err)
+		}
+	}
+	if _, err := os.Stat(name + ".3"); !os.IsNotExist(err) {
+		t.Error("expect no more than 2 rotated files, got", err)
+	}
+
+	name = path.Join(dir, "gfs.log")
+	logs, err := util.SetupLogging(util.LogConfig{Level: log.FatalLevel, Levels: map[string]log.Level{util.LogReplication: log.DebugLevel}, File: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	util.Subsystem(util.LogReplication).Debugf("verbose replication")
+	util.Subsystem(util.LogHeartbeat).Infof("quiet heartbeat")
+	log.Info("quiet default")
+	logs.Close()
+	if _, err := util.SetupLogging(util.LogConfig{Level: log.FatalLevel}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "verbose replication") || strings.Contains(string(data), "quiet") {
+		t.Errorf("expect the debug log of replication alone, got %q", data)
+	}
+}
+
+// the configuration of a file is overridden by the environment, then by flags
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gfs-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "gfs.toml")
+	data := `# a cluster of small chunks
+lease_expire = "5s"
+
+[master]
+replicas = 2 # of the files created
+
+[chunkserver]
+heartbeat_interval = "200ms"
+durability = "sync"
+
+[client]
+chunk_size = 1048576
+`
+	if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("GFS_MASTER_REPLICAS", "4")
+	defer os.Unsetenv("GFS_MASTER_REPLICAS")
+	conf, err := config.Load(name, []string{"chunkserver.heartbeat_interval=300ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.LeaseExpire != 5*time.Second || conf.Master.Replicas != 4 || conf.ChunkServer.HeartbeatInterval != 300*time.Millisecond ||
+		conf.ChunkServer.Durability != "sync" || conf.Client.ChunkSize != 1<<20 || conf.Master.TrashRetention != gfs.TrashRetention {
+		t.Errorf("expect the file, environment and flags applied in order, got %+v", conf)
+	}
+
+	for _, settings := range [][]string{{"master.bogus=1"}, {"master.replicas=many"}, {"lease_expire=100ms"}, {"client.chunk_size=1000"}} {
+		if _, err := config.Load(name, settings); err == nil {
+			t.Error("expect an error of the settings", settings)
+		}
+	}
+
+	c := client.NewClient(mAdd, conf.ClientOptions()...)
+	p := gfs.Path("/config.txt")
+	ctx := context.Background()
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := c.Stat(ctx, p); err != nil || info.ChunkSize != 1<<20 {
+		t.Error("expect a file of the configured chunk size, got", info, err)
+	}
+}
+
+// reloading a configuration changes the tunable keys alone
+func TestReloadConfig(t *testing.T) {
+	conf := config.Default()
+	next := config.Default()
+	next.LeaseExpire = 2 * gfs.LeaseExpire
+	next.Log.Level = "warning"
+	next.Master.RebalanceMaxMoves = 7
+	next.ChunkServer.FailureDomain = "rack-2"
+	next.ChunkServer.CopyMaxBytesPerSec = 1 << 20
+	conf, kept := conf.Reload(next)
+	if !reflect.DeepEqual(kept, []string{"lease_expire", "chunkserver.failure_domain"}) {
+		t.Error("expect lease_expire and chunkserver.failure_domain kept until a restart, got", kept)
+	}
+	if conf.LeaseExpire != gfs.LeaseExpire || conf.ChunkServer.FailureDomain != "" ||
+		conf.Log.Level != "warning" || conf.Master.RebalanceMaxMoves != 7 || conf.ChunkServer.CopyMaxBytesPerSec != 1<<20 {
+		t.Errorf("expect the tunable keys reloaded, got %+v", conf)
+	}
+
+	dir, err := ioutil.TempDir("", "gfs-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "gfs.log")
+	logs, err := util.SetupLogging(util.LogConfig{Level: log.FatalLevel, File: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	util.Subsystem(util.LogReplication).Debugf("quiet replication")
+	util.SetLogLevels(log.FatalLevel, map[string]log.Level{util.LogReplication: log.DebugLevel})
+	util.Subsystem(util.LogReplication).Debugf("verbose replication")
+	logs.Close()
+	if _, err := util.SetupLogging(util.LogConfig{Level: log.FatalLevel}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "verbose replication") || strings.Contains(string(data), "quiet") {
+		t.Errorf("expect the level of replication reloaded, got %q", data)
+	}
+}
+
+// a pidfile of a running process is not overwritten
+func TestPidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gfs-pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "gfs.pid")
+	if err := daemon.WritePidFile(name); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(name); err != nil || strings.TrimSpace(string(data)) != fmt.Sprint(os.Getpid()) {
+		t.Errorf("expect the pid written, got %q %v", data, err)
+	}
+	if err := ioutil.WriteFile(name, []byte(fmt.Sprint(os.Getppid())), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.WritePidFile(name); err == nil {
+		t.Error("expect the pidfile of a running process kept")
+	}
+	daemon.RemovePidFile(name)
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Error("expect the pidfile removed, got", err)
+	}
+}
+
+// servers drained close their idle connections, and chunkservers deregister
+func TestDrain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gfs-drain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	addr := func(name string) gfs.ServerAddress {
+		return gfs.ServerAddress(gfs.UnixPrefix + path.Join(dir, name+".sock"))
+	}
+	mroot := path.Join(dir, "m")
+	os.Mkdir(mroot, 0755)
+	m2 := master.NewAndServe(addr("m"), mroot)
+	defer m2.Shutdown()
+	var servers []*chunkserver.ChunkServer
+	for i := 0; i <= gfs.DefaultNumReplicas; i++ {
+		name := fmt.Sprintf("cs%v", i)
+		s := chunkserver.NewAndServe(addr(name), addr("m"), path.Join(dir, name))
+		defer s.Shutdown()
+		servers = append(servers, s)
+	}
+	listed := func() int {
+		var r gfs.ListServersReply
+		if err := m2.RPCListServers(gfs.ListServersArg{}, &r); err != nil {
+			t.Fatal(err)
+		}
+		return len(r.Servers)
+	}
+	for start := time.Now(); listed() <= gfs.DefaultNumReplicas && time.Since(start) < 10*time.Second; {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	nc := client.NewClient(addr("m"))
+	p := gfs.Path("/TestDrain")
+	if err := nc.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("answered before shutting down")
+	if _, err := nc.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	servers[0].Drain(gfs.ShutdownDrainTimeout)
+	if elapsed := time.Since(start); elapsed > gfs.ShutdownDrainTimeout {
+		t.Error("expect an idle chunkserver drained right away, took", elapsed)
+	}
+	if n := listed(); n != gfs.DefaultNumReplicas {
+		t.Errorf("expect the drained chunkserver deregistered, %v servers listed", n)
+	}
+	if _, err := os.Stat(path.Join(dir, "cs0.sock")); !os.IsNotExist(err) {
+		t.Error("expect the drained chunkserver to accept no more connections, got", err)
+	}
+	buf := make([]byte, len(data))
+	if n, err := nc.Read(ctx, p, 0, buf); err != nil && !errors.Is(err, io.EOF) || !bytes.Equal(buf[:n], data) {
+		t.Errorf("expect %q read from the other replicas, got %q, %v", data, buf[:n], err)
+	}
+
+	start = time.Now()
+	m2.Drain(gfs.ShutdownDrainTimeout)
+	if elapsed := time.Since(start); elapsed > gfs.ShutdownDrainTimeout {
+		t.Error("expect the idle connections of master closed right away, took", elapsed)
+	}
+	if _, err := os.Stat(path.Join(mroot, master.MetaFileName)); err != nil {
+		t.Error("expect the metadata of the drained master stored, got", err)
+	}
+}
+
+// a cluster of the process is killed, restarted, partitioned and corrupted
+func TestClusterHarness(t *testing.T) {
+	cl, err := gfstesting.NewCluster(gfstesting.Options{Servers: gfs.DefaultNumReplicas + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	nc := cl.NewClient(client.WithRetryPolicy(client.NoRetry))
+	p := gfs.Path("/TestClusterHarness")
+	data := []byte("in the process of the test")
+	if err := nc.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nc.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+
+	cl.Faults.Drop(gfstesting.Client, cl.MasterAddress(), "Master.RPCGetFileInfo", 1)
+	if _, err := nc.Stat(ctx, p); err == nil {
+		t.Error("expect the rpc dropped")
+	}
+	if _, err := nc.Stat(ctx, p); err != nil {
+		t.Error("expect a single rpc dropped, got", err)
+	}
+	cl.Faults.Delay(gfstesting.Client, cl.MasterAddress(), "Master.RPCGetFileInfo", 200*time.Millisecond, 1)
+	if start := time.Now(); func() error { _, err := nc.Stat(ctx, p); return err }() != nil || time.Since(start) < 200*time.Millisecond {
+		t.Error("expect the rpc delayed")
+	}
+	cl.Faults.Partition([]gfs.ServerAddress{gfstesting.Client}, []gfs.ServerAddress{cl.MasterAddress()})
+	if _, err := nc.Stat(ctx, p); err == nil {
+		t.Error("expect the client partitioned from master")
+	}
+	cl.Faults.Heal()
+
+	blocks, err := nc.GetFileBlockLocations(ctx, p, 0, int64(len(data)))
+	if err != nil || len(blocks) != 1 {
+		t.Fatal("expect the block of the file, got", blocks, err)
+	}
+	i := -1
+	for j, addr := range cl.Addresses() {
+		if addr == blocks[0].Hosts[0] {
+			i = j
+		}
+	}
+	if err := cl.CorruptChunk(i, blocks[0].Handle, 0, int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	var r gfs.ReadChunkReply
+	err = util.Call(ctx, cl.Address(i), "ChunkServer.RPCReadChunk", gfs.ReadChunkArg{blocks[0].Handle, 0, len(data), "", ""}, &r)
+	if err == nil && bytes.Equal(r.Data, data) {
+		t.Error("expect the replica corrupted")
+	}
+
+	cl.Kill(i)
+	cl.RestartMaster()
+	if err := cl.WaitForServers(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := nc.Stat(ctx, p); err != nil || info.Size != int64(len(data)) {
+		t.Error("expect the file kept by the restarted master, got", info, err)
+	}
+	cl.Restart(i)
+	if err := cl.WaitForServers(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHeartbeatCommands(t *testing.T) {
+	cl, err := gfstesting.NewCluster(gfstesting.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	nc := cl.NewClient()
+	abandoned := func(i int, handle gfs.ChunkHandle) bool {
+		for start := time.Now(); time.Since(start) < 2*time.Second; time.Sleep(50 * time.Millisecond) {
+			var r gfs.ReadChunkReply
+			err := util.Call(ctx, cl.Address(i), "ChunkServer.RPCReadChunk", gfs.ReadChunkArg{handle, 0, 1, "", ""}, &r)
+			if errors.Is(err, gfs.ChunkNotFound) {
+				return true
+			}
+		}
+		return false
+	}
+	handleOf := func(p gfs.Path) (gfs.ChunkHandle, []gfs.ServerAddress) {
+		blocks, err := nc.GetFileBlockLocations(ctx, p, 0, 1)
+		if err != nil || len(blocks) != 1 {
+			t.Fatal("expect the block of", p, "got", blocks, err)
+		}
+		return blocks[0].Handle, blocks[0].Hosts
+	}
+
+	// a replica missing the new version is marked stale
+	p := gfs.Path("/TestHeartbeatCommandsStale")
+	if err := nc.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	cl.Faults.Drop(cl.MasterAddress(), gfstesting.Any, "ChunkServer.RPCCheckVersion", 1)
+	if _, err := nc.Write(ctx, p, 0, []byte("stale")); err != nil {
+		t.Fatal(err)
+	}
+	handle, hosts := handleOf(p)
+	stale := -1
+	for i, addr := range cl.Addresses() {
+		if _, ok := cl.Servers[i].ChunkFile(handle); ok && !containsAddress(hosts, addr) {
+			stale = i
+		}
+	}
+	if stale < 0 {
+		t.Fatal("expect a replica left behind, got", hosts)
+	}
+	if !abandoned(stale, handle) {
+		t.Error("expect the stale replica abandoned with a heartbeat")
+	}
+
+	// a replica of an older version is discarded when its server registers
+	p = gfs.Path("/TestHeartbeatCommandsVersions")
+	if err := nc.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nc.Write(ctx, p, 0, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	handle, hosts = handleOf(p)
+	old := -1
+	for i, addr := range cl.Addresses() {
+		if containsAddress(hosts, addr) {
+			old = i
+		}
+	}
+	cl.Kill(old)
+	time.Sleep(gfs.LeaseExpire)
+	if _, err := nc.Write(ctx, p, 0, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	cl.Restart(old)
+	if !abandoned(old, handle) {
+		t.Error("expect the replica of the older version abandoned with a heartbeat")
+	}
+}
+
+func containsAddress(addrs []gfs.ServerAddress, addr gfs.ServerAddress) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDeadServerRecovery(t *testing.T) {
+	cl, err := gfstesting.NewCluster(gfstesting.Options{Servers: gfs.DefaultNumReplicas + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	nc := cl.NewClient()
+	p := gfs.Path("/TestDeadServerRecovery")
+	if err := nc.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nc.Write(ctx, p, 0, []byte("re-replicated")); err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := nc.GetFileBlockLocations(ctx, p, 0, 1)
+	if err != nil || len(blocks) != 1 || len(blocks[0].Hosts) != gfs.DefaultNumReplicas {
+		t.Fatal("expect the block and its replicas, got", blocks, err)
+	}
+	dead := -1
+	for i, addr := range cl.Addresses() {
+		if addr == blocks[0].Hosts[0] {
+			dead = i
+		}
+	}
+	cl.Kill(dead)
+
+	// above the minimum, the chunk is still brought back to its replication
+	for start := time.Now(); ; time.Sleep(100 * time.Millisecond) {
+		var r gfs.GetReplicasReply
+		err := cl.Master.RPCGetReplicas(gfs.GetReplicasArg{Handle: blocks[0].Handle}, &r)
+		if err == nil && len(r.Locations) == gfs.DefaultNumReplicas && !containsAddress(r.Locations, cl.Address(dead)) {
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("expect the chunk re-replicated off the dead server, got", r.Locations, err)
+		}
+	}
+	w := httptest.NewRecorder()
+	cl.Master.HTTPHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `gfs_master_removed_servers_total{reason="timeout"} 1`) {
+		t.Error("expect the dead server counted, got", w.Body.String())
+	}
+}
+
+func TestReplicationStatus(t *testing.T) {
+	cl, err := gfstesting.NewCluster(gfstesting.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	nc := cl.NewClient()
+	p := gfs.Path("/TestReplicationStatus")
+	if err := nc.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nc.Write(ctx, p, 0, []byte("safe")); err != nil {
+		t.Fatal(err)
+	}
+	status := func(p gfs.Path) gfs.GetReplicationStatusReply {
+		var r gfs.GetReplicationStatusReply
+		if err := cl.Master.RPCGetReplicationStatus(gfs.GetReplicationStatusArg{p, gfs.Credentials{}}, &r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	waitFor := func(what string, ok func(r gfs.GetReplicationStatusReply) bool) gfs.GetReplicationStatusReply {
+		for start := time.Now(); ; time.Sleep(100 * time.Millisecond) {
+			r := status(p)
+			if ok(r) {
+				return r
+			}
+			if time.Since(start) > 10*time.Second {
+				t.Fatal("expect", what, "got", r)
+			}
+		}
+	}
+	if r := status(""); r.Files != 1 || r.Chunks != 1 || r.Replicated != 1 || len(r.Under) != 0 {
+		t.Error("expect the chunk fully replicated, got", r)
+	}
+
+	// no server to take the replica of a dead one
+	cl.Kill(0)
+	r := waitFor("the chunk under-replicated", func(r gfs.GetReplicationStatusReply) bool { return r.UnderReplicated == 1 })
+	if len(r.Under) != 1 || r.Under[0].Live != gfs.DefaultNumReplicas-1 || r.Under[0].Path != p {
+		t.Error("expect the chunk listed, got", r)
+	}
+	cl.Kill(1)
+	cl.Kill(2)
+	waitFor("the chunk missing", func(r gfs.GetReplicationStatusReply) bool { return r.Missing == 1 && r.UnderReplicated == 0 })
+}
+
+func TestBatchNamespace(t *testing.T) {
+	if err := c.Mkdir(ctx, "/TestBatch"); err != nil {
+		t.Fatal(err)
+	}
+	paths := make([]gfs.Path, gfs.BatchMaxPaths+2) // two calls
+	for i := range paths {
+		paths[i] = gfs.Path(fmt.Sprintf("/TestBatch/%v", i))
+	}
+	paths[len(paths)-1] = "/TestBatch/missing/x"
+	errs, err := c.CreateFiles(ctx, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range errs[:len(paths)-1] {
+		if e != nil {
+			t.Fatal("expect", paths[i], "created, got", e)
+		}
+	}
+	if !errors.Is(errs[len(paths)-1], gfs.PathNotFound) {
+		t.Error("expect the file of a missing directory not created, got", errs[len(paths)-1])
+	}
+	if errs, err := c.CreateFiles(ctx, paths[:1]); err != nil || !errors.Is(errs[0], gfs.PathExists) {
+		t.Error("expect an existing file reported, got", errs, err)
+	}
+
+	handles, errs, err := c.GetChunkHandles(ctx, paths[:3], []gfs.ChunkIndex{0, 0, 1})
+	if err != nil || errs[0] != nil || errs[1] != nil || handles[0] == handles[1] || errs[2] == nil {
+		t.Error("expect the first chunks allocated and a chunk past the next refused, got", handles, errs, err)
+	}
+	infos, errs, err := c.StatFiles(ctx, []gfs.Path{paths[0], paths[2], "/TestBatch/none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs[0] != nil || infos[0].Path != paths[0] || infos[0].Chunks != 1 || errs[1] != nil || infos[1].Chunks != 0 {
+		t.Error("expect the files with their chunks, got", infos, errs)
+	}
+	if !errors.Is(errs[2], gfs.PathNotFound) {
+		t.Error("expect a missing file reported, got", errs[2])
+	}
+	var r gfs.BatchGetFileInfoReply
+	if err := m.RPCBatchGetFileInfo(gfs.BatchGetFileInfoArg{paths, gfs.Credentials{}}, &r); !errors.Is(err, gfs.InvalidArgument) {
+		t.Error("expect a batch too large refused, got", err)
+	}
+}
+
+func TestAppendBatch(t *testing.T) {
+	p := gfs.Path("/TestAppendBatch.txt")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	var records [][]byte
+	for i := 0; i < 100; i++ {
+		records = append(records, []byte(fmt.Sprintf("record %v;", i)))
+	}
+	records = append(records, make([]byte, gfs.MaxAppendSize)) // packed alone
+	offsets, err := c.AppendBatch(ctx, p, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != len(records) {
+		t.Fatal("expect an offset per record, got", len(offsets))
+	}
+	for i := 1; i < 100; i++ {
+		if offsets[i] != offsets[i-1]+gfs.Offset(len(records[i-1])) {
+			t.Fatal("expect the small records packed contiguously, got", offsets[:100])
+		}
+	}
+	for i, r := range records {
+		buf := make([]byte, len(r))
+		if n, err := c.Read(ctx, p, offsets[i], buf); (err != nil && err != io.EOF) || n != len(r) || !bytes.Equal(buf, r) {
+			t.Fatalf("expect record %v at %v, got %q, %v", i, offsets[i], buf[:n], err)
+		}
+	}
+	if _, err := c.AppendBatch(ctx, p, [][]byte{make([]byte, gfs.MaxAppendSize+1)}); !errors.Is(err, gfs.InvalidArgument) {
+		t.Error("expect a record over the max append size refused, got", err)
+	}
+}
+
+func TestAppender(t *testing.T) {
+	p := gfs.Path("/TestAppender.txt")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	a := c.NewAppender(ctx, p, client.AppenderOptions{Interval: 200 * time.Millisecond})
+	var want []byte
+	for i := 0; i < 10; i++ {
+		r := []byte(fmt.Sprintf("tiny %v;", i))
+		if _, err := a.Write(r); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, r...)
+	}
+	if info, err := c.Stat(ctx, p); err != nil || info.Size != 0 {
+		t.Error("expect the records buffered, got", info, err)
+	}
+	read := func(n int) []byte {
+		buf := make([]byte, n)
+		n, err := c.Read(ctx, p, 0, buf)
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		return buf[:n]
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !bytes.Equal(read(len(want)), want) {
+		if time.Now().After(deadline) {
+			t.Fatal("expect the records flushed after the interval, got", string(read(len(want))))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	a = c.NewAppender(ctx, p, client.AppenderOptions{MaxBytes: 16})
+	a.Write([]byte("0123456789"))
+	a.Write([]byte("abcdefghij")) // past MaxBytes
+	want = append(want, "0123456789abcdefghij"...)
+	if !bytes.Equal(read(len(want)+1), want) {
+		t.Error("expect the records flushed past MaxBytes, got", string(read(len(want)+1)))
+	}
+	a.Write([]byte("closed"))
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, "closed"...)
+	if !bytes.Equal(read(len(want)+1), want) {
+		t.Error("expect the records flushed by Close, got", string(read(len(want)+1)))
+	}
+	if _, err := a.Write([]byte("x")); err != os.ErrClosed {
+		t.Error("expect a write after Close refused, got", err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	cl, err := gfstesting.NewCluster(gfstesting.Options{Server: func(i int, cs *chunkserver.ChunkServer) {
+		cs.SetDurability(gfs.DurabilityAsync)
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	nc := cl.NewClient(client.WithRetryPolicy(client.NoRetry))
+	p := gfs.Path("/TestSync")
+	if err := nc.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Sync(ctx, p); err != nil {
+		t.Error("expect a file of no chunks synced, got", err)
+	}
+	durable := client.Durable(ctx)
+	if _, err := nc.Write(durable, p, 0, []byte("durable write;")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nc.Append(durable, p, []byte("durable append")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 28)
+	if n, err := nc.Read(ctx, p, 0, buf); (err != nil && err != io.EOF) || string(buf[:n]) != "durable write;durable append" {
+		t.Errorf("expect the durable mutations read back, got %q, %v", buf[:n], err)
+	}
+	if err := nc.Sync(ctx, p); err != nil {
+		t.Error("expect the chunks synced on all replicas, got", err)
+	}
+	if err := nc.Mkdir(ctx, "/TestSyncDir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Sync(ctx, "/TestSyncDir"); !errors.Is(err, gfs.IsDirectory) {
+		t.Error("expect a directory refused, got", err)
+	}
+	cl.Faults.Partition([]gfs.ServerAddress{gfstesting.Client}, []gfs.ServerAddress{cl.Address(0)})
+	if err := nc.Sync(ctx, p); err == nil {
+		t.Error("expect a replica out of reach to fail the sync")
+	}
+}
+
+func TestChunkHandleAllocation(t *testing.T) {
+	cl, err := gfstesting.NewCluster(gfstesting.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	nc := cl.NewClient()
+	p := gfs.Path("/TestChunkHandleAllocation")
+	if err := nc.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	first, err := nc.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a replica master does not know of, reported when its server registers
+	orphan := first + 100
+	if err := cl.Servers[0].RPCCreateChunk(gfs.CreateChunkArg{Handle: orphan}, &gfs.CreateChunkReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Servers[0].RPCCreateChunk(gfs.CreateChunkArg{orphan, 0, true, ""}, &gfs.CreateChunkReply{}); !errors.Is(err, gfs.ChunkExists) {
+		t.Error("expect a new chunk under an existing handle refused, got", err)
+	}
+	cl.Restart(0)
+	if err := cl.WaitForServers(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	second, err := nc.GetChunkHandle(ctx, p, 1)
+	if err != nil || second <= orphan {
+		t.Fatal("expect the handle of the orphan skipped, got", second, err)
+	}
+
+	// a replica of the next handle left behind, not reported
+	taken := second + 1
+	if err := cl.Servers[0].RPCCreateChunk(gfs.CreateChunkArg{Handle: taken}, &gfs.CreateChunkReply{}); err != nil {
+		t.Fatal(err)
+	}
+	third, err := nc.GetChunkHandle(ctx, p, 2)
+	if err != nil || third <= taken {
+		t.Fatal("expect a handle taken on a chunkserver given up, got", third, err)
+	}
+
+	if err := nc.Truncate(ctx, p, 0); err != nil {
+		t.Fatal(err)
+	}
+	cl.RestartMaster()
+	if err := cl.WaitForServers(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if h, err := nc.GetChunkHandle(ctx, p, 0); err != nil || h <= third {
+		t.Error("expect the handles cut by a truncation never given out again after a restart, got", h, err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	dir := gfs.Path("/rename")
+	p := dir + "/a/f"
+	for _, d := range []gfs.Path{dir, dir + "/a", dir + "/b"} {
+		if err := c.Mkdir(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("renamed")
+	if _, err := c.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file, then the directory above it
+	if err := c.Rename(ctx, p, dir+"/b/g"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rename(ctx, dir+"/b", dir+"/a/c"); err != nil {
+		t.Fatal(err)
+	}
+	q := dir + "/a/c/g"
+	buf := make([]byte, len(data))
+	if n, err := c.Read(ctx, q, 0, buf); err != nil && err != io.EOF || !bytes.Equal(buf[:n], data) {
+		t.Error("expect the file readable where it was moved, got", string(buf[:n]), err)
+	}
+	if _, err := c.Stat(ctx, p); !errors.Is(err, gfs.PathNotFound) {
+		t.Error("expect the file gone from where it was, got", err)
+	}
+
+	if err := c.Create(ctx, dir+"/h"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rename(ctx, dir+"/h", q); !errors.Is(err, gfs.PathExists) {
+		t.Error("expect an existing target refused, got", err)
+	}
+	if err := c.Rename(ctx, dir+"/a", dir+"/a/c/d"); !errors.Is(err, gfs.InvalidArgument) {
+		t.Error("expect a directory moved into itself refused, got", err)
+	}
+	if err := c.Rename(ctx, dir+"/x", dir+"/y"); !errors.Is(err, gfs.PathNotFound) {
+		t.Error("expect a missing source refused, got", err)
+	}
+
+	// renames back and forth between two directories, while files are
+	// created in both
+	const n = 20
+	errs := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			from, to := dir+"/a/c/g", dir+"/g"
+			if i%2 == 1 {
+				from, to = to, from
+			}
+			err := c.Rename(ctx, from, to)
+			if errors.Is(err, gfs.PathNotFound) || errors.Is(err, gfs.PathExists) {
+				err = nil
+			}
+			errs <- err
+		}(i)
+		go func(i int) {
+			errs <- c.Create(ctx, gfs.Path(fmt.Sprintf("%v/a/c/f%v", dir, i)))
+		}(i)
+	}
+	for i := 0; i < 2*n; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	list, err := c.List(ctx, dir+"/a/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) < n || len(list) > n+1 {
+		t.Error("expect", n, "files created next to the renames, got", len(list))
+	}
+}
+
+func TestXattr(t *testing.T) {
+	cl, err := gfstesting.NewCluster(gfstesting.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	nc := cl.NewClient()
+	dir, p := gfs.Path("/TestXattr"), gfs.Path("/TestXattr/file")
+	if err := nc.Mkdir(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, x := range []struct {
+		p     gfs.Path
+		name  string
+		value string
+	}{{p, "schema", "v1"}, {p, "checksum", "crc32c:1234"}, {p, "schema", "v2"}, {dir, "owner.team", "storage"}} {
+		if err := nc.SetXattr(ctx, x.p, x.name, []byte(x.value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if names, err := nc.ListXattrs(ctx, p); err != nil || len(names) != 2 || names[0] != "checksum" || names[1] != "schema" {
+		t.Error("expect the xattrs listed sorted, got", names, err)
+	}
+	if v, err := nc.GetXattr(ctx, p, "schema"); err != nil || string(v) != "v2" {
+		t.Error("expect the value set last, got", string(v), err)
+	}
+	if err := nc.SetXattr(ctx, p, "big", make([]byte, gfs.XattrMaxBytes)); !errors.Is(err, gfs.InvalidArgument) {
+		t.Error("expect xattrs over", gfs.XattrMaxBytes, "bytes refused, got", err)
+	}
+	if err := nc.RemoveXattr(ctx, p, "checksum"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nc.GetXattr(ctx, p, "checksum"); !errors.Is(err, gfs.XattrNotFound) {
+		t.Error("expect a removed xattr not found, got", err)
+	}
+	if err := nc.RemoveXattr(ctx, p, "checksum"); !errors.Is(err, gfs.XattrNotFound) {
+		t.Error("expect a missing xattr not removed, got", err)
+	}
+
+	// stored with the metadata, and kept by a rename
+	if err := nc.Rename(ctx, p, dir+"/moved"); err != nil {
+		t.Fatal(err)
+	}
+	cl.RestartMaster()
+	if err := cl.WaitForServers(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := nc.GetXattr(ctx, dir+"/moved", "schema"); err != nil || string(v) != "v2" {
+		t.Error("expect the xattr of the file kept, got", string(v), err)
+	}
+	if v, err := nc.GetXattr(ctx, dir, "owner.team"); err != nil || string(v) != "storage" {
+		t.Error("expect the xattr of the directory kept, got", string(v), err)
+	}
+}
+
+func TestFileHash(t *testing.T) {
+	const chunkSize = 1 << 20
+	p, q := gfs.Path("/TestFileHash.a"), gfs.Path("/TestFileHash.b")
+	data := make([]byte, chunkSize+1000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	for _, f := range []gfs.Path{p, q} {
+		if err := c.CreateWithChunkSize(ctx, f, chunkSize); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Write(ctx, f, 0, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := func() []byte {
+		sum := sha256.New()
+		for _, ck := range [][]byte{data[:chunkSize], data[chunkSize:]} {
+			s := sha256.Sum256(ck)
+			sum.Write(s[:])
+		}
+		return sum.Sum(nil)
+	}
+
+	h, err := c.GetFileHash(ctx, p)
+	if err != nil || !bytes.Equal(h.Sum, want()) || h.Chunks != 2 || h.Size != int64(len(data)) {
+		t.Fatal("expect the composite hash of two chunks, got", h, err)
+	}
+	if h.ETag() != fmt.Sprintf("\"%x-2\"", want()) {
+		t.Error("expect an ETag of two parts, got", h.ETag())
+	}
+	if other, err := c.GetFileHash(ctx, q); err != nil || !bytes.Equal(other.Sum, h.Sum) {
+		t.Error("expect files of the same data hashed the same, got", other, err)
+	}
+
+	// a write in place, the length kept, invalidates the hash cached
+	if _, err := c.Write(ctx, p, 10, []byte{0xff}); err != nil {
+		t.Fatal(err)
+	}
+	data[10] = 0xff
+	if h, err := c.GetFileHash(ctx, p); err != nil || !bytes.Equal(h.Sum, want()) {
+		t.Error("expect the hash of the data written, got", h, err)
+	}
+
+	if err := c.Mkdir(ctx, "/TestFileHash.dir"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetFileHash(ctx, "/TestFileHash.dir"); !errors.Is(err, gfs.IsDirectory) {
+		t.Error("expect a directory not hashed, got", err)
+	}
+}
+
+func TestImportNamespace(t *testing.T) {
+	entries := []gfs.ImportEntry{
+		{"/TestImport/a/empty.txt", 0, 0},
+		{"/TestImport/a/small.txt", 10, 0},
+		{"/TestImport/big.txt", 2*gfs.MaxChunkSize + 1, 0},
+	}
+	var r gfs.ImportNamespaceReply
+	if err := m.RPCImportNamespace(gfs.ImportNamespaceArg{entries, gfs.Credentials{}}, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Files != 3 || r.Dirs != 2 || r.Chunks != 4 {
+		t.Error("expect 3 files, 2 directories and 4 chunks imported, got", r)
+	}
+	for i, chunks := range []int64{0, 1, 3} {
+		var info gfs.GetFileInfoReply
+		err := m.RPCGetFileInfo(gfs.GetFileInfoArg{entries[i].Path, gfs.Credentials{}}, &info)
+		if err != nil || info.Length != entries[i].Size || info.Chunks != chunks {
+			t.Error("expect", entries[i], "with", chunks, "chunks, got", info, err)
+		}
+	}
+
+	// conflicts with an existing file
+	entries = []gfs.ImportEntry{{"/TestImport/new.txt", 10, 0}, {"/TestImport/big.txt", 10, 0}}
+	if err := m.RPCImportNamespace(gfs.ImportNamespaceArg{entries, gfs.Credentials{}}, &r); !errors.Is(err, gfs.PathExists) {
+		t.Error("expect path exists, got", err)
+	}
+	var info gfs.GetFileInfoReply
+	if err := m.RPCGetFileInfo(gfs.GetFileInfoArg{"/TestImport/new.txt", gfs.Credentials{}}, &info); !errors.Is(err, gfs.PathNotFound) {
+		t.Error("expect nothing imported on conflict, got", info, err)
+	}
+
+	// the chunk allocated for the conflicting import is released
+	entries = []gfs.ImportEntry{{"/TestImport/after.txt", 10, 0}}
+	if err := m.RPCImportNamespace(gfs.ImportNamespaceArg{entries, gfs.Credentials{}}, &r); err != nil {
+		t.Fatal(err)
+	}
+	var h gfs.GetChunkHandleReply
+	if err := m.RPCGetChunkHandle(gfs.GetChunkHandleArg{entries[0].Path, 0, false, gfs.Credentials{}}, &h); err != nil {
+		t.Fatal(err)
+	}
+	var replicas gfs.GetReplicasReply
+	if err := m.RPCGetReplicas(gfs.GetReplicasArg{h.Handle - 1, gfs.Credentials{}}, &replicas); !errors.Is(err, gfs.ChunkNotFound) {
+		t.Error("expect the chunk of the conflicting import released, got", replicas, err)
+	}
+}
+
+func TestReplicationFactor(t *testing.T) {
+	p := gfs.Path("/TestReplicationFactor.txt")
+	ch := make(chan error, 3)
+	ch <- c.Create(ctx, p)
+	data := []byte("replicated by file")
+	_, err := c.Write(ctx, p, 0, data)
+	ch <- err
+	ch <- c.SetReplication(ctx, p, gfs.MaxNumReplicas+1)
+	if err := <-ch; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-ch; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-ch; !errors.Is(err, gfs.InvalidArgument) {
+		t.Error("expect invalid argument for too many replicas, got", err)
+	}
+
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converge := func(n int) {
+		if err := c.SetReplication(ctx, p, n); err != nil {
+			t.Fatal(err)
+		}
+		var locations []gfs.ServerAddress
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+			var r gfs.GetReplicasReply
+			if err := m.RPCGetReplicas(gfs.GetReplicasArg{handle, gfs.Credentials{}}, &r); err == nil && len(r.Locations) == n {
+				return
+			}
+			locations = r.Locations
+		}
+		t.Errorf("expect %v replicas, got %v", n, locations)
+	}
+	converge(csNum)
+	converge(1)
+
+	var info gfs.GetFileInfoReply
+	if err := m.RPCGetFileInfo(gfs.GetFileInfoArg{p, gfs.Credentials{}}, &info); err != nil || info.Replicas != 1 {
+		t.Error("expect replication 1, got", info, err)
+	}
+	buf := make([]byte, len(data))
+	if _, err := c.Read(ctx, p, 0, buf); err != nil && err != io.EOF || !reflect.DeepEqual(buf, data) {
+		t.Error("read wrong data after dropping replicas", err)
+	}
+	converge(gfs.DefaultNumReplicas)
+}
+
+func TestFileCacheEviction(t *testing.T) {
+	for _, v := range cs {
+		v.SetMaxOpenFiles(1)
+	}
+	defer func() {
+		for _, v := range cs {
+			v.SetMaxOpenFiles(gfs.MaxOpenChunkFiles)
+		}
+	}()
+
+	ps := []gfs.Path{"/TestFileCacheEviction1.txt", "/TestFileCacheEviction2.txt"}
+	ch := make(chan error, 6)
+	for i := 0; i < 2; i++ {
+		for _, p := range ps {
+			if i == 0 {
+				ch <- c.Create(ctx, p)
+			}
+			_, err := c.Append(ctx, p, []byte(p))
+			ch <- err
+		}
+	}
+	errorAll(ch, 6, t)
+
+	for _, p := range ps {
+		buf := make([]byte, 2*len(p))
+		n, err := c.Read(ctx, p, 0, buf)
+		if n != len(buf) || string(buf) != string(p)+string(p) {
+			t.Error("expect", string(p)+string(p), "got", string(buf[:n]), err)
+		}
+	}
+}
+
+// empty directories are removed unless they are protected or not old enough
+// A chunkserver with two data dirs stripes new chunks across them. When the
+// disk of one fails only its chunks are lost, new ones go to the other.
+func TestDataDirs(t *testing.T) {
+	dirA, dirB := path.Join(root, "data-a"), path.Join(root, "data-b")
+	s := chunkserver.NewAndServe(":7773", ":7775", dirA, dirB) // master is unreachable
+	defer s.Shutdown()
+
+	create := func(from, to int) {
+		for i := from; i < to; i++ {
+			if err := s.RPCCreateChunk(gfs.CreateChunkArg{Handle: gfs.ChunkHandle(1<<40 + i)}, &gfs.CreateChunkReply{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	count := func(dir string) int {
+		files, _ := filepath.Glob(path.Join(dir, chunkserver.ChunkDirName, "*", "*", "chunk*.chk"))
+		return len(files)
+	}
+
+	create(0, 4)
+	if a, b := count(dirA), count(dirB); a != 2 || b != 2 {
+		t.Errorf("expect 2 chunks in each data dir, got %v and %v", a, b)
+	}
+
+	// the disk of dirB fails
+	os.RemoveAll(dirB)
+	if err := ioutil.WriteFile(dirB, []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	create(4, 6)
+
+	var r gfs.ReportSelfReply
+	if err := s.RPCReportSelf(gfs.ReportSelfArg{}, &r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Chunks) != 4 || count(dirA) != 4 {
+		t.Errorf("expect 4 chunks left, all in %v, got %v chunks and %v files", dirA, len(r.Chunks), count(dirA))
+	}
+
+	w := httptest.NewRecorder()
+	s.HTTPHandler().ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+	if !strings.Contains(w.Body.String(), "failed at") {
+		t.Error("status page does not show the failed data dir")
+	}
+}
+
+// a replica that missed a mutation is found by reads and dropped by master
+func TestReadDivergence(t *testing.T) {
+	p := gfs.Path("/divergence.txt")
+	data := []byte("written to every replica")
+	extra := []byte(", missed by some")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := make(map[gfs.ServerAddress]*chunkserver.ChunkServer)
+	for i, v := range csAdd {
+		server[v] = cs[i]
+	}
+	var l gfs.GetReplicasReply
+	if err := m.RPCGetReplicas(gfs.GetReplicasArg{handle, gfs.Credentials{}}, &l); err != nil || len(l.Locations) < 2 || l.Version <= 0 {
+		t.Fatal("expect replicas and version of chunk", handle, "got", l, err)
+	}
+
+	// only the first replica applies the mutation
+	s := server[l.Locations[0]]
+	id := chunkserver.NewDataID(handle)
+	if err := s.RPCForwardData(gfs.ForwardDataArg{DataID: id, Data: extra}, &gfs.ForwardDataReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RPCApplyMutation(gfs.ApplyMutationArg{gfs.MutationWrite, id, gfs.Offset(len(data)), "", false, ""}, &gfs.ApplyMutationReply{}); err != nil {
+		t.Fatal(err)
+	}
+
+	length := gfs.Offset(len(data) + len(extra))
+	reader := client.NewClient(mAdd)
+	buf := make([]byte, length)
+	for start := time.Now(); ; time.Sleep(200 * time.Millisecond) {
+		reader.ReadChunk(ctx, handle, 0, buf)
+
+		consistent := true
+		l = gfs.GetReplicasReply{}
+		if err := m.RPCGetReplicas(gfs.GetReplicasArg{handle, gfs.Credentials{}}, &l); err != nil || len(l.Locations) == 0 {
+			t.Fatal("expect replicas of chunk", handle, "got", l, err)
+		}
+		for _, v := range l.Locations {
+			var r gfs.ReadChunkReply
+			if err := server[v].RPCReadChunk(gfs.ReadChunkArg{handle, 0, 0, "", ""}, &r); err != nil || r.ChunkLength != length {
+				consistent = false
+			}
+		}
+		if consistent {
+			break
+		}
+		if time.Since(start) > 20*time.Second {
+			t.Fatal("divergent replicas of chunk", handle, "are not dropped:", l.Locations)
+		}
+	}
+}
+
+// past its budgets a chunkserver sheds hashing, copies and pushes, but keeps reading and applying mutations
+func TestLoadShedding(t *testing.T) {
+	p := gfs.Path("/shedding.txt")
+	data := []byte("pushed before the overload")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l gfs.GetReplicasReply
+	if err := m.RPCGetReplicas(gfs.GetReplicasArg{handle, gfs.Credentials{}}, &l); err != nil || len(l.Locations) < 2 {
+		t.Fatal("expect replicas of chunk", handle, "got", l, err)
+	}
+	var s *chunkserver.ChunkServer
+	for i, v := range csAdd {
+		if v == l.Locations[0] {
+			s = cs[i]
+		}
+	}
 
-// if the append would cause the chunk to exceed the maximum size
-// this chunk should be pad and the data should be appended to the next chunk
-func TestPadOver(t *testing.T) {
-	p := gfs.Path("/appendover.txt")
+	defer s.SetLoadLimits(gfs.LoadMaxMemoryBytes, gfs.LoadMaxGoroutines)
+	for _, limits := range []struct {
+		memory     int64
+		goroutines int
+	}{{1, 0}, {0, 1}} {
+		s.SetLoadLimits(0, 0)
+		pushed := chunkserver.NewDataID(handle)
+		if err := s.RPCForwardData(gfs.ForwardDataArg{DataID: pushed, Data: data}, &gfs.ForwardDataReply{}); err != nil {
+			t.Fatal(err)
+		}
 
-	ch := make(chan error, 6)
-	ch <- c.Create(p)
+		s.SetLoadLimits(limits.memory, limits.goroutines)
+		err := s.RPCForwardData(gfs.ForwardDataArg{DataID: gfs.DataBufferID{handle, pushed.TimeStamp + 1}, Data: data}, &gfs.ForwardDataReply{})
+		if !errors.Is(err, gfs.ServerBusy) {
+			t.Error("expect pushes shed with", limits, "got", err)
+		}
+		err = s.RPCSendCopy(gfs.SendCopyArg{Handle: handle, Address: l.Locations[1]}, &gfs.SendCopyReply{})
+		if !errors.Is(err, gfs.ServerBusy) {
+			t.Error("expect copies shed with", limits, "got", err)
+		}
+		err = s.RPCChunkHash(gfs.ChunkHashArg{handle, "", "", true}, &gfs.ChunkHashReply{})
+		if !errors.Is(err, gfs.ServerBusy) {
+			t.Error("expect hashing shed with", limits, "got", err)
+		}
 
-	bound := gfs.MaxAppendSize - 1
-	buf := make([]byte, bound)
-	for i := 0; i < bound; i++ {
-		buf[i] = byte(i%26 + 'a')
+		// the same data again, the replicas stay identical
+		if err := s.RPCApplyMutation(gfs.ApplyMutationArg{gfs.MutationWrite, pushed, 0, "", false, ""}, &gfs.ApplyMutationReply{}); err != nil {
+			t.Error("expect data pushed before applied with", limits, "got", err)
+		}
+		var r gfs.ReadChunkReply
+		if err := s.RPCReadChunk(gfs.ReadChunkArg{handle, 0, len(data), "", ""}, &r); err != nil || string(r.Data[:r.Length]) != string(data) {
+			t.Error("expect reads served with", limits, "got", r.Length, err)
+		}
 	}
+}
 
-	for i := 0; i < 4; i++ {
-		_, err := c.Append(p, buf)
-		ch <- err
+func TestCollectEmptyDirs(t *testing.T) {
+	ch := make(chan error, 7)
+	ch <- c.Mkdir(ctx, "/gc")
+	ch <- c.Mkdir(ctx, "/gc/a")
+	ch <- c.Mkdir(ctx, "/gc/a/b")
+	ch <- c.Mkdir(ctx, "/gc/keep")
+	ch <- c.Create(ctx, "/gc/a/f.txt")
+	ch <- c.Delete(ctx, "/gc/a/f.txt")
+	ch <- util.Call(ctx, mAdd, "Master.RPCSetDirProtected", gfs.SetDirProtectedArg{"/gc/keep", true, gfs.Credentials{}}, &gfs.SetDirProtectedReply{})
+	errorAll(ch, 7, t)
+
+	var r gfs.CollectEmptyDirsReply
+	if err := util.Call(ctx, mAdd, "Master.RPCCollectEmptyDirs", gfs.CollectEmptyDirsArg{time.Hour, false, gfs.Credentials{}}, &r); err != nil || len(r.Removed) != 0 {
+		t.Error("expect nothing removed, got", r.Removed, err)
 	}
 
-	buf = buf[:5]
-	// an append cause pad, and client should retry to next chunk
-	offset, err := c.Append(p, buf)
-	ch <- err
-	if offset != gfs.MaxChunkSize { // i.e. 0 at next chunk
-		t.Error("data should be appended to the beginning of next chunk")
+	var dry gfs.CollectEmptyDirsReply
+	if err := util.Call(ctx, mAdd, "Master.RPCCollectEmptyDirs", gfs.CollectEmptyDirsArg{0, true, gfs.Credentials{}}, &dry); err != nil {
+		t.Error(err)
+	}
+	r = gfs.CollectEmptyDirsReply{}
+	if err := util.Call(ctx, mAdd, "Master.RPCCollectEmptyDirs", gfs.CollectEmptyDirsArg{0, false, gfs.Credentials{}}, &r); err != nil {
+		t.Error(err)
+	}
+	sort.Slice(dry.Removed, func(i, j int) bool { return dry.Removed[i] < dry.Removed[j] })
+	sort.Slice(r.Removed, func(i, j int) bool { return r.Removed[i] < r.Removed[j] })
+	if !reflect.DeepEqual(dry.Removed, r.Removed) {
+		t.Error("expect dry run to plan", r.Removed, "got", dry.Removed)
+	}
+	for _, p := range []gfs.Path{"/gc/a/b", "/gc/a"} {
+		found := false
+		for _, v := range r.Removed {
+			found = found || v == p
+		}
+		if !found {
+			t.Error(p, "not removed, removed", r.Removed)
+		}
 	}
 
-	errorAll(ch, 6, t)
+	ls, err := c.List(ctx, "/gc")
+	if err != nil || len(ls) != 1 || ls[0].Name != "keep" {
+		t.Error("expect only keep left in /gc, got", ls, err)
+	}
 }
 
 // big data that invokes several chunks
@@ -297,7 +4766,7 @@ func TestWriteReadBigData(t *testing.T) {
 	p := gfs.Path("/bigData.txt")
 
 	ch := make(chan error, 4)
-	ch <- c.Create(p)
+	ch <- c.Create(ctx, p)
 
 	size := gfs.MaxChunkSize * 3
 	expected := make([]byte, size)
@@ -306,11 +4775,12 @@ func TestWriteReadBigData(t *testing.T) {
 	}
 
 	// write large data
-	ch <- c.Write(p, gfs.MaxChunkSize/2, expected)
+	_, err := c.Write(ctx, p, gfs.MaxChunkSize/2, expected)
+	ch <- err
 
 	// read
 	buf := make([]byte, size)
-	n, err := c.Read(p, gfs.MaxChunkSize/2, buf)
+	n, err := c.Read(ctx, p, gfs.MaxChunkSize/2, buf)
 	ch <- err
 
 	if n != size {
@@ -321,7 +4791,7 @@ func TestWriteReadBigData(t *testing.T) {
 	}
 
 	// test read at EOF
-	n, err = c.Read(p, gfs.MaxChunkSize/2+gfs.Offset(size), buf)
+	n, err = c.Read(ctx, p, gfs.MaxChunkSize/2+gfs.Offset(size), buf)
 	if err == nil {
 		t.Error("an error should be returned if read at EOF")
 	}
@@ -329,7 +4799,7 @@ func TestWriteReadBigData(t *testing.T) {
 	// test append offset
 	var offset gfs.Offset
 	buf = buf[:gfs.MaxAppendSize-1]
-	offset, err = c.Append(p, buf)
+	offset, err = c.Append(ctx, p, buf)
 	if offset != gfs.MaxChunkSize/2+gfs.Offset(size) {
 		t.Error("append in wrong offset")
 	}
@@ -404,7 +4874,7 @@ func TestComprehensiveOperation(t *testing.T) {
 					p := gfs.Path(fmt.Sprintf("/haha%v.txt", x))
 
 					//fmt.Println("create ", p)
-					err := c.Create(p)
+					err := c.Create(ctx, p)
 					if err != nil {
 						t.Error(err)
 					} else {
@@ -431,7 +4901,7 @@ func TestComprehensiveOperation(t *testing.T) {
 				x := sendCt.Next()
 
 				//fmt.Println("append ", p, "  ", tmp)
-				_, err := c.Append(p, []byte(fmt.Sprintf("%10d,", x)))
+				_, err := c.Append(ctx, p, []byte(fmt.Sprintf("%10d,", x)))
 				if err != nil {
 					t.Error(err)
 				}
@@ -461,7 +4931,7 @@ func TestComprehensiveOperation(t *testing.T) {
 				pos := fileOffset[p]
 				lock2.RUnlock()
 				buf := make([]byte, 10000) // large enough
-				n, err := c.Read(p, gfs.Offset(pos), buf)
+				n, err := c.Read(ctx, p, gfs.Offset(pos), buf)
 				if err != nil && err != io.EOF {
 					t.Error(err)
 				}
@@ -509,15 +4979,340 @@ func TestComprehensiveOperation(t *testing.T) {
 	fmt.Printf("##### You send %v numbers in total\n", total)
 }
 
+func TestMirror(t *testing.T) {
+	journal := path.Join(root, "mirror.journal")
+	p, q := gfs.Path("/TestMirror.txt"), gfs.Path("/TestMirror2.txt")
+	data := []byte("mirrored data")
+
+	// the secondary is not up yet, every mutation diverges
+	mc := client.NewClient(mAdd, client.WithRetryPolicy(client.NoRetry), client.WithMirror(":7778", journal))
+	if err := mc.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mc.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	offset, err := mc.Append(ctx, p, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc.FlushMirror()
+	if ds, err := client.ReadJournal(journal); err != nil || len(ds) != 3 {
+		t.Fatal("expect 3 divergences, got", ds, err)
+	}
+
+	os.Mkdir(path.Join(root, "m2"), 0755)
+	m2 := master.NewAndServe(":7778", path.Join(root, "m2"))
+	defer m2.Shutdown()
+	for i := 0; i < 3; i++ {
+		dir := path.Join(root, "m2cs"+strconv.Itoa(i))
+		os.Mkdir(dir, 0755)
+		s := chunkserver.NewAndServe(gfs.ServerAddress(fmt.Sprintf(":%v", 10100+i)), ":7778", dir)
+		defer s.Shutdown()
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	secondary := client.NewClient(":7778")
+	repaired, failed, err := client.Reconcile(ctx, c, secondary, journal)
+	if err != nil || repaired != 3 || failed != 0 {
+		t.Error("expect 3 repaired divergences, got", repaired, failed, err)
+	}
+	if ds, err := client.ReadJournal(journal); err != nil || len(ds) != 0 {
+		t.Error("expect an empty journal, got", ds, err)
+	}
+	buf := make([]byte, len(data))
+	if _, err := secondary.Read(ctx, p, offset, buf); err != nil && err != io.EOF || !reflect.DeepEqual(buf, data) {
+		t.Error("wrong appended data on secondary", string(buf), err)
+	}
+
+	// mirrored in the background once the secondary is up
+	mc = client.NewClient(mAdd, client.WithMirror(":7778", journal))
+	if err := mc.Create(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mc.Write(ctx, q, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	mc.FlushMirror()
+	buf = make([]byte, len(data))
+	if _, err := secondary.Read(ctx, q, 0, buf); err != nil && err != io.EOF || !reflect.DeepEqual(buf, data) {
+		t.Error("wrong mirrored data on secondary", string(buf), err)
+	}
+	if ds, _ := client.ReadJournal(journal); len(ds) != 0 {
+		t.Error("expect no divergence, got", ds)
+	}
+}
+
+// the in-memory fake keeps record append semantics and injects faults
+func TestClientFake(t *testing.T) {
+	fc := clientfake.NewClient(clientfake.Faults{DuplicateAppend: 1})
+	var api client.ClientAPI = fc
+	p := gfs.Path("/fake.txt")
+
+	ch := make(chan error, 5)
+	ch <- api.Mkdir(ctx, "/dir")
+	ch <- api.Create(ctx, p)
+	offset, err := api.Append(ctx, p, []byte("hello"))
+	ch <- err
+	if offset != 5 {
+		t.Error("the duplicated record should be returned at offset 5, got", offset)
+	}
+
+	fc.SetFaults(clientfake.Faults{PadAppend: 1})
+	offset, err = api.Append(ctx, p, []byte("world"))
+	ch <- err
+	if offset != gfs.MaxChunkSize {
+		t.Error("data should be appended to the beginning of next chunk, got", offset)
+	}
+
+	buf := make([]byte, 10)
+	n, err := api.Read(ctx, p, 0, buf)
+	if n != 10 || string(buf) != "hellohello" {
+		t.Error("expect hellohello, got", n, string(buf[:n]))
+	}
+	n, err = api.Read(ctx, p, gfs.MaxChunkSize, buf)
+	if err != io.EOF || string(buf[:n]) != "world" {
+		t.Error("expect world and EOF, got", string(buf[:n]), err)
+	}
+
+	ls, err := api.List(ctx, "/")
+	ch <- err
+	if len(ls) != 2 {
+		t.Error("expect 2 entries in root, got", ls)
+	}
+	errorAll(ch, 5, t)
+}
+
+// the nodes and handles of the filesystem serve the calls of the kernel
+func TestFuse(t *testing.T) {
+	dir := gfs.Path("/TestFuse")
+	if err := c.Mkdir(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	root, err := gfsfuse.New(c).Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := root.(*gfsfuse.Dir).Lookup(ctx, "TestFuse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, ok := node.(*gfsfuse.Dir)
+	if !ok {
+		t.Fatal("expect a directory looked up, got", node)
+	}
+	if _, err := d.Lookup(ctx, "none"); err != fuse.ENOENT {
+		t.Error("expect ENOENT, got", err)
+	}
+
+	var created fuse.CreateResponse
+	fnode, fh, err := d.Create(ctx, &fuse.CreateRequest{Name: "f.txt", Flags: fuse.OpenReadWrite}, &created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := fh.(*gfsfuse.Handle)
+	var wrote fuse.WriteResponse
+	if err := h.Write(ctx, &fuse.WriteRequest{Offset: 0, Data: []byte("hello fuse")}, &wrote); err != nil || wrote.Size != 10 {
+		t.Error("expect 10 bytes written, got", wrote.Size, err)
+	}
+	if err := h.Flush(ctx, &fuse.FlushRequest{}); err != nil {
+		t.Error(err)
+	}
+	var read fuse.ReadResponse
+	if err := h.Read(ctx, &fuse.ReadRequest{Offset: 6, Size: 100}, &read); err != nil || string(read.Data) != "fuse" {
+		t.Error("expect fuse read, got", string(read.Data), err)
+	}
+	if err := h.Release(ctx, &fuse.ReleaseRequest{}); err != nil {
+		t.Error(err)
+	}
+	var attr fuse.Attr
+	if err := fnode.(*gfsfuse.File).Attr(ctx, &attr); err != nil || attr.Size != 10 {
+		t.Error("expect 10 bytes, got", attr.Size, err)
+	}
+
+	// appended by record append
+	ah, err := fnode.(*gfsfuse.File).Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenAppend}, &fuse.OpenResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ah.(*gfsfuse.Handle).Write(ctx, &fuse.WriteRequest{Offset: 0, Data: []byte("!")}, &wrote); err != nil || wrote.Size != 1 {
+		t.Error("expect a byte appended, got", wrote.Size, err)
+	}
+	ah.(*gfsfuse.Handle).Release(ctx, &fuse.ReleaseRequest{})
+
+	sub, err := d.Mkdir(ctx, &fuse.MkdirRequest{Name: "sub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Rename(ctx, &fuse.RenameRequest{OldName: "f.txt", NewName: "g.txt"}, sub); err != nil {
+		t.Fatal(err)
+	}
+	ents, err := d.ReadDirAll(ctx)
+	if err != nil || len(ents) != 1 || ents[0].Name != "sub" || ents[0].Type != fuse.DT_Dir {
+		t.Error("expect sub alone, got", ents, err)
+	}
+	node, err = sub.(*gfsfuse.Dir).Lookup(ctx, "g.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh, err := node.(*gfsfuse.File).Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rh.(*gfsfuse.Handle).Read(ctx, &fuse.ReadRequest{Offset: 0, Size: 100}, &read); err != nil || string(read.Data) != "hello fuse!" {
+		t.Error("expect the file renamed read, got", string(read.Data), err)
+	}
+	rh.(*gfsfuse.Handle).Release(ctx, &fuse.ReleaseRequest{})
+
+	if err := d.Rename(ctx, &fuse.RenameRequest{OldName: "none", NewName: "x"}, d); err != fuse.ENOENT {
+		t.Error("expect ENOENT renaming nothing, got", err)
+	}
+}
+
+// the gateways and File are served by the fake as by the client
+func TestClientFakeConsumers(t *testing.T) {
+	fc := clientfake.NewClient(clientfake.Faults{})
+	do := func(h http.Handler, method, url string, body []byte) (int, string) {
+		req := httptest.NewRequest(method, url, bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code, w.Body.String()
+	}
+
+	hdfs := webhdfs.New(fc)
+	if code, body := do(hdfs, "PUT", webhdfs.Prefix+"/w/d?op=MKDIRS", nil); code != 200 || !strings.Contains(body, "true") {
+		t.Fatal("expect the directories made, got", code, body)
+	}
+	if code, body := do(hdfs, "PUT", webhdfs.Prefix+"/w/d/f.txt?op=CREATE&blocksize=1048576&data=true", []byte("hello")); code != 201 {
+		t.Fatal("expect the file created, got", code, body)
+	}
+	if code, body := do(hdfs, "GET", webhdfs.Prefix+"/w/d/f.txt?op=OPEN", nil); code != 200 || body != "hello" {
+		t.Error("expect the file read, got", code, body)
+	}
+	if _, body := do(hdfs, "PUT", webhdfs.Prefix+"/w/d?op=RENAME&destination=/w/e", nil); !strings.Contains(body, "true") {
+		t.Error("expect the directory renamed, got", body)
+	}
+	info, err := fc.Stat(ctx, "/w/e/f.txt")
+	if err != nil || info.Size != 5 || info.ChunkSize != 1<<20 {
+		t.Error("expect the file renamed with its chunk size, got", info, err)
+	}
+
+	if err := fc.Mkdir(ctx, "/buckets"); err != nil {
+		t.Fatal(err)
+	}
+	objects := s3.New(fc, "/buckets")
+	if code, body := do(objects, "PUT", "/bucket", nil); code != 200 {
+		t.Fatal("expect the bucket created, got", code, body)
+	}
+	if code, body := do(objects, "PUT", "/bucket/dir/a.txt", []byte("object")); code != 200 {
+		t.Fatal("expect the object put, got", code, body)
+	}
+	if code, body := do(objects, "GET", "/bucket/dir/a.txt", nil); code != 200 || body != "object" {
+		t.Error("expect the object read, got", code, body)
+	}
+	if code, body := do(objects, "GET", "/bucket?list-type=2", nil); code != 200 || !strings.Contains(body, "<Key>dir/a.txt</Key>") {
+		t.Error("expect the object listed, got", code, body)
+	}
+
+	f, err := fc.Open(ctx, "/w/e/f.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte(" world"), 5); err != nil {
+		t.Error(err)
+	}
+	buf := make([]byte, 20)
+	if n, err := f.ReadAt(buf, 0); err != io.EOF || string(buf[:n]) != "hello world" {
+		t.Error("expect hello world and EOF, got", string(buf[:n]), err)
+	}
+	if end, err := f.Seek(0, io.SeekEnd); err != nil || end != 11 {
+		t.Error("expect the end at 11, got", end, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err := fc.Open(ctx, "/w/e"); !errors.Is(err, gfs.IsDirectory) {
+		t.Error("expect a directory not opened, got", err)
+	}
+}
+
 /*
  *  TEST SUITE 3 - Fault Tolerance
  */
 
 // Shutdown two chunk servers during appending
+// records survive duplicates, padding and torn data
+func TestRecordIO(t *testing.T) {
+	read := func(api client.ClientAPI, p gfs.Path) ([]string, recordio.Stats) {
+		r := recordio.NewReader(ctx, api, p)
+		var got []string
+		for {
+			rec, err := r.Next()
+			if err == io.EOF {
+				return got, r.Stats()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, string(rec.Data))
+		}
+	}
+
+	fc := clientfake.NewClient(clientfake.Faults{DuplicateAppend: 1})
+	p := gfs.Path("/records")
+	if err := fc.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	w := recordio.NewWriter(fc, p)
+	var want []string
+	for i := 0; i < 6; i++ {
+		if i == 2 {
+			fc.SetFaults(clientfake.Faults{PadAppend: 1})
+		}
+		if i == 4 {
+			// a torn record, framed but cut short, and garbage
+			fc.SetFaults(clientfake.Faults{})
+			torn := []byte("gfsr\x05\x00\x00\x00short")
+			torn = append(torn, make([]byte, recordio.HeaderSize)...)
+			if _, err := fc.Append(ctx, p, append(torn, "garbage"...)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		data := fmt.Sprintf("record %v", i)
+		if _, err := w.Append(ctx, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, data)
+	}
+
+	got, st := read(fc, p)
+	if !reflect.DeepEqual(got, want) {
+		t.Error("expect records", want, "got", got)
+	}
+	if st.Records != 6 || st.Duplicates != 2 || st.Corrupt != 1 || st.Skipped == 0 {
+		t.Error("expect 6 records, 2 duplicates and a corrupt one skipped, got", st)
+	}
+
+	// on the cluster
+	q := gfs.Path("/TestRecordIO.txt")
+	if err := c.Create(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	w = recordio.NewWriter(c, q)
+	for _, data := range want {
+		if _, err := w.Append(ctx, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := read(c, q); !reflect.DeepEqual(got, want) {
+		t.Error("expect records", want, "got", got)
+	}
+}
+
 func TestShutdownInAppend(t *testing.T) {
 	p := gfs.Path("/shutdown.txt")
 	ch := make(chan error, N+3)
-	ch <- c.Create(p)
+	ch <- c.Create(ctx, p)
 
 	expected := make(map[int][]byte)
 	todelete := make(map[int][]byte)
@@ -528,13 +5323,13 @@ func TestShutdownInAppend(t *testing.T) {
 
 	// get two replica locations
 	var r1 gfs.GetChunkHandleReply
-	ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0}, &r1)
+	ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0, false, gfs.Credentials{}}, &r1)
 	var l gfs.GetReplicasReply
-	ch <- m.RPCGetReplicas(gfs.GetReplicasArg{r1.Handle}, &l)
+	ch <- m.RPCGetReplicas(gfs.GetReplicasArg{r1.Handle, gfs.Credentials{}}, &l)
 
 	for i := 0; i < N; i++ {
 		go func(x int) {
-			_, err := c.Append(p, expected[x])
+			_, err := c.Append(ctx, p, expected[x])
 			ch <- err
 		}(i)
 	}
@@ -553,7 +5348,7 @@ func TestShutdownInAppend(t *testing.T) {
 	// TODO : stricter - check replicas
 	for x := 0; x < gfs.MaxChunkSize/2 && len(todelete) > 0; x++ {
 		buf := make([]byte, 2)
-		n, err := c.Read(p, gfs.Offset(x*2), buf)
+		n, err := c.Read(ctx, p, gfs.Offset(x*2), buf)
 		if err != nil {
 			t.Error("read error ", err)
 		}
@@ -592,9 +5387,9 @@ func TestReReplication(t *testing.T) {
 	p := gfs.Path("/re-replication.txt")
 
 	ch := make(chan error, 2)
-	ch <- c.Create(p)
+	ch <- c.Create(ctx, p)
 
-	c.Append(p, []byte("Dangerous"))
+	c.Append(ctx, p, []byte("Dangerous"))
 
 	fmt.Println("###### Mr. Disaster is coming...")
 	time.Sleep(gfs.LeaseExpire)
@@ -624,7 +5419,7 @@ func TestReReplication(t *testing.T) {
 
 	// check equality and number of replicas
 	var r1 gfs.GetChunkHandleReply
-	ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0}, &r1)
+	ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0, false, gfs.Credentials{}}, &r1)
 	n := checkReplicas(r1.Handle, N*2, t)
 
 	if n < gfs.MinimumNumReplicas {
@@ -641,13 +5436,13 @@ func checkWork(p gfs.Path, msg []byte, t *testing.T) {
 	ch := make(chan error, 6)
 
 	// append again to confirm all information about this chunk has been reloaded properly
-	offset, err := c.Append(p, msg)
+	offset, err := c.Append(ctx, p, msg)
 	ch <- err
 
 	// read and check data
 	buf := make([]byte, len(msg)*2)
 	// read at defined region
-	_, err = c.Read(p, offset-gfs.Offset(len(msg)), buf)
+	_, err = c.Read(ctx, p, offset-gfs.Offset(len(msg)), buf)
 	ch <- err
 
 	msg = append(msg, msg...)
@@ -656,14 +5451,15 @@ func checkWork(p gfs.Path, msg []byte, t *testing.T) {
 	}
 
 	// other file operation
-	ch <- c.Mkdir(gfs.Path("/" + string(msg)))
+	ch <- c.Mkdir(ctx, gfs.Path("/"+string(msg)))
 	newfile := gfs.Path("/" + string(msg) + "/" + string(msg) + ".txt")
-	ch <- c.Create(newfile)
-	ch <- c.Write(newfile, 4, msg)
+	ch <- c.Create(ctx, newfile)
+	_, err = c.Write(ctx, newfile, 4, msg)
+	ch <- err
 
 	// read and check data again
 	buf = make([]byte, len(msg))
-	_, err = c.Read(p, 0, buf)
+	_, err = c.Read(ctx, p, 0, buf)
 	ch <- err
 	if !reflect.DeepEqual(buf, msg) {
 		t.Errorf("[check 2]read wrong data \"%v\", expect \"%v\"", string(buf), string(msg))
@@ -673,14 +5469,136 @@ func checkWork(p gfs.Path, msg []byte, t *testing.T) {
 }
 
 // Shutdown all chunk servers. You must store the meta data of chunkserver persistently
+func TestVerifyReplicas(t *testing.T) {
+	p := gfs.Path("/TestVerifyReplicas")
+	data := []byte("the same on every replica")
+	if err := c.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := c.GetChunkHandle(ctx, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds, err := c.VerifyFile(ctx, p); err != nil || len(ds) != 0 {
+		t.Fatal("expect the replicas to agree, got", ds, err)
+	}
+
+	server := make(map[gfs.ServerAddress]*chunkserver.ChunkServer)
+	for i, v := range csAdd {
+		server[v] = cs[i]
+	}
+	var l gfs.GetReplicasReply
+	if err := m.RPCGetReplicas(gfs.GetReplicasArg{handle, gfs.Credentials{}}, &l); err != nil || len(l.Locations) < 3 {
+		t.Fatal("expect 3 replicas of chunk", handle, "got", l, err)
+	}
+
+	// a replica overwritten alone, its version and length kept
+	bad := l.Locations[0]
+	id := chunkserver.NewDataID(handle)
+	if err := server[bad].RPCForwardData(gfs.ForwardDataArg{DataID: id, Data: []byte("THE")}, &gfs.ForwardDataReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server[bad].RPCApplyMutation(gfs.ApplyMutationArg{gfs.MutationWrite, id, 0, "", false, ""}, &gfs.ApplyMutationReply{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err := c.VerifyFile(ctx, p)
+	if err != nil || len(ds) != 1 || ds[0] != (gfs.ReplicaDivergence{0, handle, bad, gfs.DivergentData}) {
+		t.Fatal("expect", bad, "outvoted on its data, got", ds, err)
+	}
+
+	// retried until master drops the replica reported
+	verified := client.NewClient(mAdd, client.WithVerifiedReads())
+	buf := make([]byte, len(data))
+	if n, err := verified.Read(ctx, p, 0, buf); err != nil && err != io.EOF && !errors.Is(err, gfs.ChecksumMismatch) || err == nil && !bytes.Equal(buf[:n], data) {
+		t.Error("expect a verified read to find the replicas disagree, got", string(buf[:n]), err)
+	}
+
+	// dropped by master once the lease of the write has settled
+	for start := time.Now(); ; time.Sleep(200 * time.Millisecond) {
+		if ds, err = c.VerifyFile(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+		if len(ds) == 0 {
+			break
+		}
+		if time.Since(start) > 20*time.Second {
+			t.Fatal("divergent replica", bad, "of chunk", handle, "is not dropped:", ds)
+		}
+	}
+	if n, err := verified.Read(ctx, p, 0, buf); err != nil && err != io.EOF || !bytes.Equal(buf[:n], data) {
+		t.Error("expect a verified read of the data written, got", string(buf[:n]), err)
+	}
+}
+
+func TestBadReplicaRouting(t *testing.T) {
+	kp, err := chunkserver.StaticKeys([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sealed chunks fail their checksums once corrupted
+	cl, err := gfstesting.NewCluster(gfstesting.Options{Servers: 4, Server: func(i int, s *chunkserver.ChunkServer) { s.SetKeyProvider(kp) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	nc := cl.NewClient()
+	p := gfs.Path("/TestBadReplicaRouting")
+	data := []byte("read from the good replicas")
+	if err := nc.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nc.Write(ctx, p, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := nc.GetFileBlockLocations(ctx, p, 0, int64(len(data)))
+	if err != nil || len(blocks) != 1 || len(blocks[0].Hosts) != gfs.DefaultNumReplicas {
+		t.Fatal("expect the replicas of the file, got", blocks, err)
+	}
+	bad := blocks[0].Hosts[0]
+	for i, addr := range cl.Addresses() {
+		if addr == bad {
+			if err := cl.CorruptChunk(i, blocks[0].Handle, 0, int64(len(data))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// reads never fail while the bad replica is reported and dropped, the
+	// chunk being repaired once fewer than gfs.MinimumNumReplicas are left
+	buf := make([]byte, len(data))
+	for start := time.Now(); ; time.Sleep(100 * time.Millisecond) {
+		if n, err := nc.Read(ctx, p, 0, buf); err != nil && err != io.EOF || !bytes.Equal(buf[:n], data) {
+			t.Fatal("expect the data read from another replica, got", string(buf[:n]), err)
+		}
+		blocks, err := nc.GetFileBlockLocations(ctx, p, 0, int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dropped := len(blocks[0].Hosts) >= gfs.MinimumNumReplicas
+		for _, addr := range blocks[0].Hosts {
+			dropped = dropped && addr != bad
+		}
+		if dropped {
+			break
+		}
+		if time.Since(start) > 20*time.Second {
+			t.Fatal("bad replica", bad, "is not dropped:", blocks[0].Hosts)
+		}
+	}
+}
+
 func TestPersistentChunkServer(t *testing.T) {
 	p := gfs.Path("/persistent-chunkserver.txt")
 	msg := []byte("Don't Lose Me. ")
 
 	ch := make(chan error, 4)
-	ch <- c.Create(p)
+	ch <- c.Create(ctx, p)
 
-	_, err := c.Append(p, msg)
+	_, err := c.Append(ctx, p, msg)
 	ch <- err
 
 	// shut all down
@@ -705,16 +5623,121 @@ func TestPersistentChunkServer(t *testing.T) {
 	errorAll(ch, 2, t)
 }
 
+// Restart chunkservers with the metadata of their last checkpoint, as after
+// a crash. The mutations since are replayed from their journals.
+func TestJournalReplay(t *testing.T) {
+	p := gfs.Path("/journal-replay.txt")
+	msg := []byte("Journaled Before Acknowledged. ")
+
+	metas := make([][]byte, csNum)
+	for i := range metas {
+		var err error
+		metas[i], err = ioutil.ReadFile(path.Join(root, "cs"+strconv.Itoa(i), chunkserver.MetaFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ch := make(chan error, 2+csNum)
+	ch <- c.Create(ctx, p)
+	_, err := c.Append(ctx, p, msg)
+	ch <- err
+
+	fmt.Println("###### SHUT All DOWN")
+	for _, v := range cs {
+		v.Shutdown()
+	}
+	for i, meta := range metas {
+		ch <- ioutil.WriteFile(path.Join(root, "cs"+strconv.Itoa(i), chunkserver.MetaFileName), meta, chunkserver.FilePerm)
+	}
+
+	// a torn tail whose length is garbage is dropped, not allocated
+	journal := path.Join(root, "cs0", chunkserver.JournalFileName)
+	f, err := os.OpenFile(journal, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{1, 2, 3, 4, 0xf0, 0xff, 0xff, 0xff, 'g', 'a', 'r', 'b', 'a', 'g', 'e'}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	time.Sleep(2*gfs.ServerTimeout + gfs.LeaseExpire)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	cs[0] = chunkserver.NewAndServe(csAdd[0], mAdd, path.Join(root, "cs0"))
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<30 {
+		t.Error("expect no allocation of the garbage length, got", n, "bytes allocated")
+	}
+	for i := 1; i < csNum; i++ {
+		ii := strconv.Itoa(i)
+		cs[i] = chunkserver.NewAndServe(csAdd[i], mAdd, path.Join(root, "cs"+ii))
+	}
+	time.Sleep(2*gfs.ServerTimeout + gfs.LeaseExpire)
+
+	checkWork(p, msg, t)
+
+	errorAll(ch, 2+csNum, t)
+}
+
+// Restart chunkservers without their metadata and journals, the chunks are
+// rebuilt by scanning the chunk files. A file of the flat layout is moved
+// into its shard.
+func TestScanChunks(t *testing.T) {
+	p := gfs.Path("/scan-chunks.txt")
+	msg := []byte("Found On Disk. ")
+
+	ch := make(chan error, 3+2*csNum)
+	ch <- c.Create(ctx, p)
+	_, err := c.Append(ctx, p, msg)
+	ch <- err
+
+	fmt.Println("###### SHUT All DOWN")
+	for _, v := range cs {
+		v.Shutdown()
+	}
+	for i := 0; i < csNum; i++ {
+		dir := path.Join(root, "cs"+strconv.Itoa(i))
+		ch <- os.Remove(path.Join(dir, chunkserver.MetaFileName))
+		ch <- os.Remove(path.Join(dir, chunkserver.JournalFileName))
+	}
+	sharded, _ := filepath.Glob(path.Join(root, "cs0", chunkserver.ChunkDirName, "*", "*", "chunk*.chk"))
+	if len(sharded) == 0 {
+		t.Fatal("no sharded chunk file")
+	}
+	flat := path.Join(root, "cs0", path.Base(sharded[0]))
+	ch <- os.Rename(sharded[0], flat)
+	time.Sleep(2*gfs.ServerTimeout + gfs.LeaseExpire)
+
+	for i := 0; i < csNum; i++ {
+		ii := strconv.Itoa(i)
+		cs[i] = chunkserver.NewAndServe(csAdd[i], mAdd, path.Join(root, "cs"+ii))
+	}
+	time.Sleep(2*gfs.ServerTimeout + gfs.LeaseExpire)
+
+	if _, err := os.Stat(flat); !os.IsNotExist(err) {
+		t.Errorf("chunk file %v of the flat layout is not moved, stat: %v", flat, err)
+	}
+	if _, err := os.Stat(sharded[0]); err != nil {
+		t.Errorf("chunk file %v is not moved back into its shard: %v", sharded[0], err)
+	}
+
+	checkWork(p, msg, t)
+
+	errorAll(ch, 3+2*csNum, t)
+}
+
 // Shutdown master. You must store the meta data of master persistently
 func TestPersistentMaster(t *testing.T) {
 	p := gfs.Path("/persistent/master.txt")
 	msg := []byte("Don't Lose Yourself. ")
 
 	ch := make(chan error, 5)
-	ch <- c.Mkdir("/persistent")
-	ch <- c.Create(p)
+	ch <- c.Mkdir(ctx, "/persistent")
+	ch <- c.Create(ctx, p)
 
-	_, err := c.Append(p, msg)
+	_, err := c.Append(ctx, p, msg)
 	ch <- err
 
 	// shut master down
@@ -738,16 +5761,16 @@ func TestDiskError(t *testing.T) {
 	msg := []byte("fucking disk!")
 
 	ch := make(chan error, 5)
-	ch <- c.Create(p)
+	ch <- c.Create(ctx, p)
 
-	_, err := c.Append(p, msg)
+	_, err := c.Append(ctx, p, msg)
 	ch <- err
 
 	// get replica locations
 	var r1 gfs.GetChunkHandleReply
-	ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0}, &r1)
+	ch <- m.RPCGetChunkHandle(gfs.GetChunkHandleArg{p, 0, false, gfs.Credentials{}}, &r1)
 	var l gfs.GetReplicasReply
-	ch <- m.RPCGetReplicas(gfs.GetReplicasArg{r1.Handle}, &l)
+	ch <- m.RPCGetReplicas(gfs.GetReplicasArg{r1.Handle, gfs.Credentials{}}, &l)
 
 	fmt.Println("###### Destory two chunkserver's diskes")
 	// destory two server's disk
@@ -760,7 +5783,7 @@ func TestDiskError(t *testing.T) {
 	fmt.Println("###### Waiting for recovery")
 	time.Sleep(gfs.ServerTimeout + gfs.LeaseExpire)
 
-	_, err = c.Append(p, msg)
+	_, err = c.Append(ctx, p, msg)
 	ch <- err
 
 	time.Sleep(gfs.ServerTimeout + gfs.LeaseExpire)
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	//"math/rand"
@@ -8,52 +10,133 @@ import (
 
 	"gfs"
 	"gfs/chunkserver"
+	"gfs/config"
+	"gfs/daemon"
 	"gfs/master"
 	"os"
+	"strings"
+)
+
+var (
+	args []string      // the command and its arguments, after the flags
+	conf config.Config // loaded from -config, the environment and -set
+	load func() (config.Config, error)
 )
 
 func runMaster() {
-	if len(os.Args) < 4 {
+	if len(args) < 3 {
 		printUsage()
 		return
 	}
-	addr := gfs.ServerAddress(os.Args[2])
-	master.NewAndServe(addr, os.Args[3])
+	addr := gfs.ServerAddress(args[1])
+	tlsConf, plaintext := daemon.SetTLS()
+	daemon.ExportSpans()
+	m := master.NewAndServe(addr, args[2])
+	conf.ApplyMaster(m)
+	daemon.ReloadOnHangup(&conf, load, func(c *config.Config) { c.TuneMaster(m) })
+	m.SetTLSConfig(tlsConf, plaintext)
+	m.SetAuthSecret(daemon.AuthSecret())
+	if len(args) > 3 {
+		daemon.ServeHTTP(args[3], m.HTTPHandler())
+	}
 
-	ch := make(chan bool)
-	<-ch
+	log.Info("shutting down on ", daemon.WaitForShutdown())
+	m.Drain(gfs.ShutdownDrainTimeout)
+}
+
+// restoreMaster bootstraps the metadata of a master from a dump of
+// gfsctl dump-namespace, before the master is started.
+func restoreMaster() {
+	if len(args) < 3 {
+		printUsage()
+		return
+	}
+	data, err := os.ReadFile(args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	var dump gfs.NamespaceDump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		log.Fatal(err)
+	}
+	if err := master.RestoreDump(args[2], &dump); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func runChunkServer() {
-	if len(os.Args) < 5 {
+	if len(args) < 4 {
 		printUsage()
 		return
 	}
-	addr := gfs.ServerAddress(os.Args[2])
-	serverRoot := os.Args[3]
-	masterAddr := gfs.ServerAddress(os.Args[4])
-	chunkserver.NewAndServe(addr, masterAddr, serverRoot)
+	addr := gfs.ServerAddress(args[1])
+	serverRoots := strings.Split(args[2], ",") // one per disk, metadata in the first
+	masterAddr := gfs.ServerAddress(args[3])
+	tlsConf, plaintext := daemon.SetTLS()
+	daemon.ExportSpans()
+	if dir := os.Getenv("GFS_LOCAL_READ_DIR"); dir != "" && conf.ChunkServer.LocalReadDir == "" {
+		conf.ChunkServer.LocalReadDir = dir
+	}
+	cs := chunkserver.NewAndServe(addr, masterAddr, serverRoots[0], serverRoots[1:]...)
+	if err := conf.ApplyChunkServer(cs); err != nil {
+		log.Fatal(err)
+	}
+	daemon.ReloadOnHangup(&conf, load, func(c *config.Config) { c.TuneChunkServer(cs) })
+	cs.SetTLSConfig(tlsConf, plaintext)
+	cs.SetAuthSecret(daemon.AuthSecret())
+	if kp := daemon.KeyProvider(); kp != nil {
+		cs.SetKeyProvider(kp)
+	}
+	if len(args) > 4 {
+		daemon.ServeHTTP(args[4], cs.HTTPHandler())
+	}
 
-	ch := make(chan bool)
-	<-ch
+	log.Info("shutting down on ", daemon.WaitForShutdown())
+	cs.Drain(gfs.ShutdownDrainTimeout)
 }
 
 func printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println("  gfs master <addr> <root path>")
-	fmt.Println("  gfs chunkserver <addr> <root path> <master addr>")
+	fmt.Println("  gfs [-config file] [-set key=value]... master <addr> <root path> [http addr]")
+	fmt.Println("  gfs master-restore <dump> <root path>")
+	fmt.Println("  gfs [-config file] [-set key=value]... chunkserver <addr> <root path>[,<data path>...] <master addr> [http addr]")
 	fmt.Println()
+	fmt.Println("The configuration of the file, e.g. [chunkserver] heartbeat_interval = \"200ms\", is overridden by")
+	fmt.Println("$GFS_<KEY>, e.g. $GFS_CHUNKSERVER_HEARTBEAT_INTERVAL, then by -set chunkserver.heartbeat_interval=200ms.")
+	fmt.Println("SIGHUP reloads the levels of the logs, the throttles and the timeouts of a running server,")
+	fmt.Println("SIGINT and SIGTERM shut it down, storing its metadata.")
+	fmt.Println("Addresses are tcp host:port ones, or unix:///<socket path> ones for servers of a single host.")
+	fmt.Println("Tokens are required if $GFS_AUTH_SECRET_FILE names the secret shared by the servers.")
+	fmt.Println("Rpcs are served and dialed over mutual TLS with the files named by $GFS_TLS_CERT,")
+	fmt.Println("$GFS_TLS_KEY and $GFS_TLS_CA, plaintext is accepted too if $GFS_TLS_PLAINTEXT=1.")
+	fmt.Println("Chunks are encrypted with the hex key in $GFS_ENCRYPTION_KEY_FILE or $GFS_ENCRYPTION_KEY,")
+	fmt.Println("or with the key of each chunk printed by $GFS_ENCRYPTION_KEY_COMMAND <handle>.")
+	fmt.Println("Logs are configured by the [log] section, i.e. $GFS_LOG_LEVEL, $GFS_LOG_LEVELS (e.g. heartbeat=warning,replication=debug),")
+	fmt.Println("$GFS_LOG_FORMAT (text or json), $GFS_LOG_FILE, $GFS_LOG_MAX_BYTES and $GFS_LOG_MAX_BACKUPS.")
+	fmt.Println("Spans of the traces of client operations are appended as JSON lines to $GFS_TRACE_FILE.")
+	fmt.Println("Clients on the host of a chunkserver read its chunk files from the socket in $GFS_LOCAL_READ_DIR.")
 }
 
 func main() {
-	log.SetLevel(log.DebugLevel)
-	if len(os.Args) < 2 {
+	load = config.Flags(flag.CommandLine)
+	flag.Usage = printUsage
+	flag.Parse()
+	args = flag.Args()
+
+	var err error
+	if conf, err = load(); err != nil {
+		log.Fatal(err)
+	}
+	defer daemon.SetupLogging(&conf).Close()
+	if len(args) < 1 {
 		printUsage()
 		return
 	}
-	switch os.Args[1] {
+	switch args[0] {
 	case "master":
 		runMaster()
+	case "master-restore":
+		restoreMaster()
 	case "chunkserver":
 		runChunkServer()
 	default:
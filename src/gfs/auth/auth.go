@@ -0,0 +1,186 @@
+// Package auth signs and verifies the tokens of clients and of chunks with a
+// secret shared by the master and the chunkservers.
+//
+// A client token authenticates a user with its groups to the master. It is
+// issued out of band, e.g. by gfsctl issue-token, and sent in the
+// credentials of the rpcs of the client. A chunk token is issued by the
+// master with the replicas or the lease of a chunk, to the user allowed to
+// read or to write the file of the chunk, and sent to the chunkservers with
+// the reads and the mutations of the chunk. A server token authenticates the
+// master and the chunkservers to each other, in the rpcs they call on one
+// another.
+//
+// A token is the claims in JSON and their HMAC-SHA256, each in base64,
+// joined by a dot.
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gfs"
+)
+
+// Claims are what a token says about its holder.
+type Claims struct {
+	User   string
+	Groups []string
+	Chunk  bool            // a chunk token, otherwise a client token
+	Handle gfs.ChunkHandle // of a chunk token
+	Write  bool            // a chunk token allowing mutations, besides reads
+	Server bool            // a server token
+	Expire time.Time
+}
+
+// Secret is the key tokens are signed with. The zero value has no key, then
+// tokens are neither issued nor checked. It is safe for concurrent use.
+type Secret struct {
+	mu  sync.RWMutex
+	key []byte
+}
+
+// ReadSecretFile reads a key from filename, without the trailing newlines.
+func ReadSecretFile(filename string) ([]byte, error) {
+	key, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key = bytes.TrimRight(key, "\r\n")
+	if len(key) == 0 {
+		return nil, fmt.Errorf("secret file %v is empty", filename)
+	}
+	return key, nil
+}
+
+// Set sets the key, nil disables authentication.
+func (s *Secret) Set(key []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.key = append([]byte(nil), key...)
+	if len(key) == 0 {
+		s.key = nil
+	}
+}
+
+// Enabled returns whether a key is set.
+func (s *Secret) Enabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.key != nil
+}
+
+// Sign returns a token of c, empty if no key is set.
+func (s *Secret) Sign(c Claims) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.key == nil {
+		return ""
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		panic(err) // claims always marshal
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(s.mac(payload))
+}
+
+// Verify returns the claims of token, or gfs.Unauthenticated if it is not
+// signed with the key or it has expired.
+func (s *Secret) Verify(token string) (Claims, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var c Claims
+	enc := base64.RawURLEncoding
+	p, m, ok := strings.Cut(token, ".")
+	if !ok {
+		return c, gfs.Error{gfs.Unauthenticated, "no valid token"}
+	}
+	payload, err1 := enc.DecodeString(p)
+	mac, err2 := enc.DecodeString(m)
+	if err1 != nil || err2 != nil || s.key == nil || !hmac.Equal(mac, s.mac(payload)) {
+		return c, gfs.Error{gfs.Unauthenticated, "token not signed by the cluster"}
+	}
+	if err := json.NewDecoder(bytes.NewReader(payload)).Decode(&c); err != nil {
+		return c, gfs.Error{gfs.Unauthenticated, "malformed token"}
+	}
+	if !c.Expire.After(time.Now()) {
+		return c, gfs.Error{gfs.Unauthenticated, fmt.Sprintf("token of %v expired at %v", c.User, c.Expire.Format(time.RFC3339))}
+	}
+	return c, nil
+}
+
+// mac returns the HMAC-SHA256 of payload, s should be locked.
+func (s *Secret) mac(payload []byte) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// ClientToken returns a token authenticating user with groups for ttl.
+func (s *Secret) ClientToken(user string, groups []string, ttl time.Duration) string {
+	return s.Sign(Claims{User: user, Groups: groups, Expire: time.Now().Add(ttl)})
+}
+
+// ChunkToken returns a token allowing user to read the chunk handle, and to
+// mutate it if write is set, for gfs.ChunkTokenExpire.
+func (s *Secret) ChunkToken(user string, handle gfs.ChunkHandle, write bool) string {
+	return s.Sign(Claims{User: user, Chunk: true, Handle: handle, Write: write, Expire: time.Now().Add(gfs.ChunkTokenExpire)})
+}
+
+// Client returns the user and the groups of a client token.
+func (s *Secret) Client(token string) (Claims, error) {
+	c, err := s.Verify(token)
+	if err != nil {
+		return c, err
+	}
+	if c.Chunk || c.Server || c.User == "" {
+		return c, gfs.Error{gfs.Unauthenticated, "not a client token"}
+	}
+	return c, nil
+}
+
+// CheckChunk returns gfs.Unauthenticated unless token allows reading the
+// chunk handle, and mutating it if write is set. Without a key all is allowed.
+func (s *Secret) CheckChunk(token string, handle gfs.ChunkHandle, write bool) error {
+	if !s.Enabled() {
+		return nil
+	}
+	c, err := s.Verify(token)
+	if err != nil {
+		return err
+	}
+	if !c.Chunk || c.Handle != handle || (write && !c.Write) {
+		return gfs.Error{gfs.Unauthenticated, fmt.Sprintf("token of %v does not allow %v of chunk %v", c.User, map[bool]string{false: "reads", true: "mutations"}[write], handle)}
+	}
+	return nil
+}
+
+// ServerToken returns a token authenticating the master or a chunkserver to
+// the others, for gfs.ChunkTokenExpire.
+func (s *Secret) ServerToken() string {
+	return s.Sign(Claims{Server: true, Expire: time.Now().Add(gfs.ChunkTokenExpire)})
+}
+
+// CheckServer returns gfs.Unauthenticated unless token is a server token.
+// Without a key all is allowed.
+func (s *Secret) CheckServer(token string) error {
+	if !s.Enabled() {
+		return nil
+	}
+	c, err := s.Verify(token)
+	if err != nil {
+		return err
+	}
+	if !c.Server {
+		return gfs.Error{gfs.Unauthenticated, "not a server token"}
+	}
+	return nil
+}
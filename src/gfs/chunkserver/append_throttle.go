@@ -0,0 +1,54 @@
+package chunkserver
+
+import (
+	"fmt"
+	"sync"
+
+	"gfs"
+	"gfs/util"
+)
+
+// appendThrottle limits appends as primary by the throttle policies master
+// sends along with leases. Chunks under the directory of a policy share its
+// limiter, the limit holds for the appends this server serves as primary.
+type appendThrottle struct {
+	sync.Mutex
+	policies map[gfs.ChunkHandle]gfs.ThrottlePolicy
+	limiters map[gfs.Path]*util.RateLimiter // by policy path
+}
+
+func newAppendThrottle() *appendThrottle {
+	return &appendThrottle{
+		policies: make(map[gfs.ChunkHandle]gfs.ThrottlePolicy),
+		limiters: make(map[gfs.Path]*util.RateLimiter),
+	}
+}
+
+// set records the policy of handle, sent by master when granting a lease.
+func (t *appendThrottle) set(handle gfs.ChunkHandle, policy gfs.ThrottlePolicy) {
+	t.Lock()
+	defer t.Unlock()
+	if policy.AppendsPerSec <= 0 {
+		delete(t.policies, handle)
+		return
+	}
+	t.policies[handle] = policy
+	if l, ok := t.limiters[policy.Path]; ok {
+		l.SetRate(policy.AppendsPerSec)
+	} else {
+		t.limiters[policy.Path] = util.NewRateLimiter(policy.AppendsPerSec)
+	}
+}
+
+// allow takes an append of handle. It fails with gfs.Throttled if the
+// policy of the chunk allows no more for now.
+func (t *appendThrottle) allow(handle gfs.ChunkHandle) error {
+	t.Lock()
+	policy, ok := t.policies[handle]
+	l := t.limiters[policy.Path]
+	t.Unlock()
+	if !ok || l.Allow() {
+		return nil
+	}
+	return gfs.Error{gfs.Throttled, fmt.Sprintf("appends under %v are limited to %v per second", policy.Path, policy.AppendsPerSec)}
+}
@@ -0,0 +1,261 @@
+package chunkserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gfs"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Chunk files are sharded into ChunkDirName/<xx>/<yy>/chunk<handle>.chk by a
+// hash of their handle, so that no directory holds too many of them. The
+// version and chunk size of a chunk are kept beside, in chunk<handle>.ver, so
+// that the chunks can be rebuilt from disk alone.
+
+// dataDir is a directory chunk files are stored in, one per disk. New chunks
+// are striped across the healthy ones. A dir fails on an I/O error it cannot
+// be written after, its chunks are reported lost and the others are served on.
+type dataDir struct {
+	path     string
+	failed   int32 // accessed atomically
+	err      string
+	failedAt time.Time
+}
+
+func (d *dataDir) healthy() bool {
+	return atomic.LoadInt32(&d.failed) == 0
+}
+
+// probe checks that chunks can still be written in the dir.
+func (d *dataDir) probe() error {
+	dir := path.Join(d.path, ChunkDirName)
+	if err := os.MkdirAll(dir, FilePerm); err != nil {
+		return err
+	}
+	filename := path.Join(dir, ".probe")
+	if err := ioutil.WriteFile(filename, []byte("probe"), FilePerm); err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
+
+// chunkDir returns the directory of the files of a chunk.
+func (d *dataDir) chunkDir(handle gfs.ChunkHandle) string {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(handle))
+	h := fnv.New32a()
+	h.Write(b[:])
+	sum := h.Sum32()
+	return path.Join(d.path, ChunkDirName, fmt.Sprintf("%02x", sum&0xff), fmt.Sprintf("%02x", sum>>8&0xff))
+}
+
+// chunkFilename returns the file of a chunk.
+func (d *dataDir) chunkFilename(handle gfs.ChunkHandle) string {
+	return path.Join(d.chunkDir(handle), fmt.Sprintf("chunk%v.chk", handle))
+}
+
+func (d *dataDir) versionFilename(handle gfs.ChunkHandle) string {
+	return path.Join(d.chunkDir(handle), fmt.Sprintf("chunk%v.ver", handle))
+}
+
+// storeVersion keeps the version and chunk size of a chunk beside its file,
+// and whether it is sealed. It is not synced, the journal makes version
+// changes durable.
+func (d *dataDir) storeVersion(handle gfs.ChunkHandle, version gfs.ChunkVersion, chunkSize gfs.Offset, sealed bool) error {
+	s := fmt.Sprintf("%v %v\n", version, chunkSize)
+	if sealed {
+		s = fmt.Sprintf("%v %v sealed\n", version, chunkSize)
+	}
+	return ioutil.WriteFile(d.versionFilename(handle), []byte(s), FilePerm)
+}
+
+func (d *dataDir) loadVersion(handle gfs.ChunkHandle) (version gfs.ChunkVersion, chunkSize gfs.Offset, sealed bool, err error) {
+	b, err := ioutil.ReadFile(d.versionFilename(handle))
+	if err != nil {
+		return 0, 0, false, err
+	}
+	_, err = fmt.Sscan(string(b), &version, &chunkSize)
+	sealed = strings.HasSuffix(strings.TrimSpace(string(b)), " sealed")
+	return
+}
+
+// nextDir returns the healthy dir to place a new chunk in, round robin.
+// cs should be locked.
+func (cs *ChunkServer) nextDir() (*dataDir, error) {
+	for range cs.dirs {
+		d := cs.dirs[cs.stripe%len(cs.dirs)]
+		cs.stripe++
+		if d.healthy() {
+			return d, nil
+		}
+	}
+	return nil, gfs.Error{gfs.UnknownError, fmt.Sprintf("all %v data dirs of %v failed", len(cs.dirs), cs.address)}
+}
+
+// checkDir is called on an I/O error in d. If d cannot be written any more,
+// it fails and its chunks are dropped, to be reported lost to master.
+func (cs *ChunkServer) checkDir(d *dataDir, err error) {
+	if err == nil || errors.Is(err, io.EOF) || !d.healthy() {
+		return
+	}
+	if e := d.probe(); e == nil {
+		return // the chunk alone is broken
+	}
+	if !atomic.CompareAndSwapInt32(&d.failed, 0, 1) {
+		return
+	}
+
+	cs.lock.Lock()
+	d.err, d.failedAt = err.Error(), time.Now()
+	var lost []gfs.ChunkHandle
+	var used int64
+	for handle, ck := range cs.chunk {
+		if ck.dir == d {
+			delete(cs.chunk, handle)
+			lost = append(lost, handle)
+			used += int64(ck.size)
+		}
+	}
+	cs.lost = append(cs.lost, lost...)
+	cs.lock.Unlock()
+
+	atomic.AddInt64(&cs.diskUsed, -used)
+	for _, handle := range lost {
+		cs.files.invalidate(handle)
+	}
+	log.Errorf("Server %v : data dir %v failed, %v chunks lost: %v", cs.address, d.path, len(lost), err)
+}
+
+// diskStats returns the bytes in all and available on the disks of the
+// healthy dirs, -1 if unknown.
+func (cs *ChunkServer) diskStats() (capacity, free int64) {
+	capacity, free = -1, -1
+	for _, d := range cs.dirs {
+		if !d.healthy() {
+			continue
+		}
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(d.path, &st); err != nil {
+			log.Warningf("%v cannot stat disk of %v: %v", cs.address, d.path, err)
+			continue
+		}
+		if capacity < 0 {
+			capacity, free = 0, 0
+		}
+		capacity += int64(st.Blocks) * int64(st.Bsize)
+		free += int64(st.Bavail) * int64(st.Bsize)
+	}
+	return
+}
+
+// scanChunks rebuilds the chunks loaded from the metadata with the chunk
+// files in the healthy dirs. Chunks without a file are dropped, files without
+// metadata are added with the version kept beside and their size as length.
+// Chunk files of the flat layout, in the root, are moved into their shard.
+func (cs *ChunkServer) scanChunks() error {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	root := cs.dirs[0]
+	flat, err := filepath.Glob(path.Join(root.path, "chunk*.chk"))
+	if err != nil {
+		return err
+	}
+	for _, filename := range flat {
+		var handle gfs.ChunkHandle
+		if _, err := fmt.Sscanf(path.Base(filename), "chunk%d.chk", &handle); err != nil {
+			continue
+		}
+		if err := os.MkdirAll(root.chunkDir(handle), FilePerm); err != nil {
+			return err
+		}
+		if err := os.Rename(filename, root.chunkFilename(handle)); err != nil {
+			return err
+		}
+	}
+
+	type chunkFile struct {
+		dir  *dataDir
+		size gfs.Offset
+	}
+	found := make(map[gfs.ChunkHandle]chunkFile)
+	for _, d := range cs.dirs {
+		if !d.healthy() {
+			continue
+		}
+		err := filepath.Walk(path.Join(d.path, ChunkDirName), func(filename string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			var handle gfs.ChunkHandle
+			if info.IsDir() || path.Ext(filename) != ".chk" {
+				return nil
+			}
+			if _, err := fmt.Sscanf(info.Name(), "chunk%d.chk", &handle); err == nil {
+				if _, ok := found[handle]; !ok {
+					found[handle] = chunkFile{d, gfs.Offset(info.Size())}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			atomic.StoreInt32(&d.failed, 1)
+			d.err, d.failedAt = err.Error(), time.Now()
+			log.Errorf("Server %v : data dir %v failed: %v", cs.address, d.path, err)
+		}
+	}
+
+	for handle := range cs.chunk {
+		if f, ok := found[handle]; !ok || !f.dir.healthy() {
+			log.Warningf("Server %v : chunk %v has no file, dropped", cs.address, handle)
+			delete(cs.chunk, handle)
+		}
+	}
+
+	var used int64
+	for handle, f := range found {
+		if !f.dir.healthy() {
+			continue
+		}
+		version, chunkSize, sealed, err := f.dir.loadVersion(handle)
+		ck, ok := cs.chunk[handle]
+		switch {
+		case !ok && err != nil:
+			log.Warningf("Server %v : chunk %v has no version, ignored: %v", cs.address, handle, err)
+			continue
+		case !ok:
+			ck = &chunkInfo{length: f.size, version: version, chunkSize: chunkSize}
+			cs.chunk[handle] = ck
+		case err == nil && version > ck.version:
+			ck.version = version
+		}
+		if ck.chunkSize == 0 {
+			ck.chunkSize = gfs.MaxChunkSize
+		}
+		length := f.size
+		if ck.sealed = sealed; sealed {
+			length = plainSize(int64(f.size))
+		}
+		if ck.length > length {
+			ck.length = length
+		}
+		ck.dir = f.dir
+		ck.size = f.size
+		used += int64(f.size)
+	}
+	atomic.StoreInt64(&cs.diskUsed, used)
+	log.Infof("Server %v : scanned %v chunk files in %v data dirs", cs.address, len(found), len(cs.dirs))
+	return nil
+}
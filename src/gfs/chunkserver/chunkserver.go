@@ -1,37 +1,79 @@
 package chunkserver
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"google.golang.org/grpc"
 	//"math/rand"
 	"encoding/gob"
+	"errors"
 	"io"
 	"net"
+	"net/http"
 	"net/rpc"
 	"os"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 	//"strings"
 
 	"gfs"
+	"gfs/auth"
+	"gfs/pb"
 	"gfs/util"
 )
 
 // ChunkServer struct
 type ChunkServer struct {
-	lock     sync.RWMutex
-	address  gfs.ServerAddress // chunkserver address
-	master   gfs.ServerAddress // master address
-	rootDir  string            // path to data storage
-	l        net.Listener
-	shutdown chan struct{}
-
-	dl                     *downloadBuffer                // expiring download buffer
-	chunk                  map[gfs.ChunkHandle]*chunkInfo // chunk information
-	dead                   bool                           // set to ture if server is shuntdown
-	pendingLeaseExtensions *util.ArraySet                 // pending lease extension
-	garbage                []gfs.ChunkHandle              // garbages
+	lock      sync.RWMutex
+	address   gfs.ServerAddress // chunkserver address
+	master    gfs.ServerAddress // master address
+	rootDir   string            // path to metadata, journal and the first data dir
+	dirs      []*dataDir        // data dirs chunks are striped across, the root first
+	stripe    int               // next dir to place a chunk in
+	domain    string            // failure domain (rack/zone), reported in heartbeat
+	labels    map[string]string // placement labels, reported in heartbeat
+	topology  gfs.Topology      // zone and rack, reported in heartbeat
+	l         net.Listener
+	tlsConf   util.ServerTLS     // of the connections accepted
+	streams   *util.StreamServer // data streams, served with the rpcs
+	grpc      *grpc.Server
+	grpcConns *util.HandListener // the accepted gRPC connections, served by grpc
+	keys      KeyProvider        // of sealed chunks, new chunks are sealed if set
+	conns     *util.ArraySet     // accepted connections, closed on shutdown
+	shutdown  chan struct{}
+	leave     chan chan struct{} // asks the background loop to deregister and stop, see Drain
+	draining  int32              // set to 1 once Drain stops accepting connections
+	ctx       context.Context    // base context of outgoing rpcs, canceled on shutdown
+	cancel    context.CancelFunc
+
+	dl            *downloadBuffer                // expiring download buffer
+	files         *fileCache                     // open chunk files
+	health        *secondaryHealth               // failures to reach secondaries
+	metrics       *serverMetrics                 // exported on /metrics
+	chunk         map[gfs.ChunkHandle]*chunkInfo // chunk information
+	dead          bool                           // set to ture if server is shuntdown
+	leases        *leaseTracker                  // leases used as primary, renewed or released by heartbeats
+	throttle      *appendThrottle                // throttle policies of chunks, enforced as primary
+	dedup         *dedupTable                    // results of mutations applied as primary, by request id
+	load          *loadShedder                   // sheds work past the memory and goroutine budgets
+	rate          *rateLimiter                   // budgets of bytes per second, by class and by client
+	copyPiece     int64                          // bytes of the pieces of copies, accessed atomically
+	copyRate      *util.RateLimiter              // paces the copies sent, in bytes per second
+	journal       *mutationJournal               // write-ahead log of mutations, truncated by checkpoints
+	checkpoints   chan struct{}                  // asks the background goroutine for a checkpoint
+	garbage       []gfs.ChunkHandle              // garbages
+	diskUsed      int64                          // bytes of chunk files, accessed atomically
+	ioBytes       int64                          // bytes read and written since the last heartbeat, accessed atomically
+	mutationCount int64                          // mutations applied since the last heartbeat, accessed atomically
+	lastHeartbeat time.Time                      // when ioBytes and mutationCount were reset
+	hbInterval    int64                          // between heartbeats, accessed atomically
+	registered    bool                           // with master, accessed by the background goroutine only
+	lost          []gfs.ChunkHandle              // chunks of failed dirs, to be reported to master
+	secret        auth.Secret                    // shared with master, chunk tokens are checked if set
 }
 
 type Mutation struct {
@@ -45,33 +87,66 @@ type chunkInfo struct {
 	length    gfs.Offset
 	version   gfs.ChunkVersion // version number of the chunk in disk
 	checksum  gfs.Checksum
-	mutations map[gfs.ChunkVersion]*Mutation // mutation buffer
-	abandoned bool                           // unrecoverable error
+	size      gfs.Offset // end of the chunk file, counted in diskUsed
+	chunkSize gfs.Offset // max length of the chunk
+	abandoned bool       // unrecoverable error
+	stale     bool       // abandoned as stale, until it is repaired or copied
+	sealed    bool       // encrypted, see encryption.go
+	mutations uint64     // mutations applied, copies start over if it changes
+	changes   *changeLog // ranges mutated in recent versions, nil if unknown, see repair.go
+	dir       *dataDir   // the chunk file is in
+	hash      *chunkHash // cached by RPCChunkHash, see hash.go
 }
 
 const (
-	MetaFileName = "gfs-server.meta"
-	FilePerm     = 0755
+	MetaFileName    = "gfs-server.meta"
+	JournalFileName = "gfs-server.journal"
+	ChunkDirName    = "chunks"   // of the sharded chunk files, under the root
+	SpillDirName    = "download" // of the download buffer, under the root
+	FilePerm        = 0755
 )
 
-// NewAndServe starts a chunkserver and return the pointer to it.
-func NewAndServe(addr, masterAddr gfs.ServerAddress, rootDir string) *ChunkServer {
+// features of chunkservers reported by RPCBuildInfo
+var features = []string{"lease-renewal", "registration", "file-cache", "secondary-health", "throttle", "journal", "data-dirs", "load-shedding", "dedup", "truncate", "auth", "tls", "encryption", "rate-limits", "copy-pieces", "repair", "clone", "data-streams", "unix-sockets", "drain", "heartbeat-commands", "sync", "chunk-hash", "grpc"}
+
+// NewAndServe starts a chunkserver and return the pointer to it. Chunks are
+// striped across rootDir and dataDirs, which should be on different disks.
+func NewAndServe(addr, masterAddr gfs.ServerAddress, rootDir string, dataDirs ...string) *ChunkServer {
 	cs := &ChunkServer{
-		address:  addr,
-		shutdown: make(chan struct{}),
-		master:   masterAddr,
-		rootDir:  rootDir,
-		dl:       newDownloadBuffer(gfs.DownloadBufferExpire, gfs.DownloadBufferTick),
-		pendingLeaseExtensions: new(util.ArraySet),
-		chunk: make(map[gfs.ChunkHandle]*chunkInfo),
+		address:     addr,
+		conns:       new(util.ArraySet),
+		shutdown:    make(chan struct{}),
+		leave:       make(chan chan struct{}),
+		master:      masterAddr,
+		rootDir:     rootDir,
+		dl:          newDownloadBuffer(gfs.DownloadBufferExpire, gfs.DownloadBufferTick, gfs.DownloadBufferMaxBytes, gfs.DownloadBufferClientMaxBytes),
+		files:       newFileCache(gfs.MaxOpenChunkFiles),
+		health:      newSecondaryHealth(),
+		leases:      newLeaseTracker(),
+		throttle:    newAppendThrottle(),
+		dedup:       newDedupTable(gfs.DedupWindow, gfs.DedupTick),
+		load:        newLoadShedder(gfs.LoadMaxMemoryBytes, gfs.LoadMaxGoroutines),
+		rate:        newRateLimiter(),
+		copyPiece:   gfs.CopyPieceBytes,
+		hbInterval:  int64(gfs.HeartbeatInterval),
+		copyRate:    util.NewRateLimiter(gfs.CopyMaxBytesPerSec),
+		checkpoints: make(chan struct{}, 1),
+		chunk:       make(map[gfs.ChunkHandle]*chunkInfo),
 	}
+	cs.ctx, cs.cancel = context.WithCancel(util.WithCaller(context.Background(), addr))
+	cs.metrics = newServerMetrics(cs)
 	rpcs := rpc.NewServer()
 	rpcs.Register(cs)
-	l, e := net.Listen("tcp", string(cs.address))
+	cs.streams = cs.newStreamServer()
+	l, e := util.Listen(cs.address)
 	if e != nil {
 		log.Fatal("chunkserver listen error:", e)
 	}
 	cs.l = l
+	cs.grpc = util.NewGRPCServer()
+	pb.RegisterChunkServerServer(cs.grpc, grpcChunkServer{cs: cs})
+	cs.grpcConns = util.NewHandListener(l.Addr())
+	go cs.grpc.Serve(cs.grpcConns)
 
 	// Mkdir
 	_, err := os.Stat(rootDir)
@@ -81,11 +156,34 @@ func NewAndServe(addr, masterAddr gfs.ServerAddress, rootDir string) *ChunkServe
 			log.Fatal("error in mkdir ", err)
 		}
 	}
+	for _, dir := range append([]string{rootDir}, dataDirs...) {
+		d := &dataDir{path: dir}
+		if err := d.probe(); err != nil {
+			log.Errorf("data dir %v failed: %v", dir, err)
+			d.failed, d.err, d.failedAt = 1, err.Error(), time.Now()
+		}
+		cs.dirs = append(cs.dirs, d)
+	}
+
+	// data spilled before a restart is never fetched
+	spillDir := path.Join(rootDir, SpillDirName)
+	os.RemoveAll(spillDir)
+	if err := os.Mkdir(spillDir, FilePerm); err != nil {
+		log.Warning("error in mkdir, download buffer is not spilled: ", err)
+	} else {
+		cs.dl.setSpill(spillDir, gfs.DownloadBufferSpillMaxBytes)
+	}
 
 	err = cs.loadMeta()
 	if err != nil {
 		log.Warning("Error in load metadata: ", err)
 	}
+	if err := cs.scanChunks(); err != nil {
+		log.Fatal("error in scan chunk files ", err)
+	}
+	if err := cs.replayJournal(); err != nil {
+		log.Fatal("error in replay journal ", err)
+	}
 
 	// RPC Handler
 	go func() {
@@ -97,12 +195,26 @@ func NewAndServe(addr, masterAddr gfs.ServerAddress, rootDir string) *ChunkServe
 			}
 			conn, err := cs.l.Accept()
 			if err == nil {
+				cs.conns.Add(conn)
 				go func() {
-					rpcs.ServeConn(conn)
-					conn.Close()
+					tc, stream := util.IsStream(cs.tlsConf.Wrap(conn))
+					tc, isGRPC := util.IsGRPC(tc)
+					if isGRPC {
+						// drained and closed by grpc
+						cs.conns.Delete(conn)
+						cs.grpcConns.Hand(tc)
+						return
+					}
+					if stream {
+						cs.streams.ServeConn(tc)
+					} else {
+						rpcs.ServeCodec(cs.metrics.rpc.Wrap(util.NewGobServerCodec(tc)))
+					}
+					tc.Close()
+					cs.conns.Delete(conn)
 				}()
 			} else {
-				if !cs.dead {
+				if !cs.dead && atomic.LoadInt32(&cs.draining) == 0 {
 					log.Fatal("chunkserver accept error: ", err)
 				}
 			}
@@ -112,8 +224,9 @@ func NewAndServe(addr, masterAddr gfs.ServerAddress, rootDir string) *ChunkServe
 	// Background Activity
 	// heartbeat, store persistent meta, garbage collection ...
 	go func() {
-		heartbeatTicker := time.Tick(gfs.HeartbeatInterval)
+		heartbeatTimer := time.NewTimer(time.Duration(atomic.LoadInt64(&cs.hbInterval)))
 		storeTicker := time.Tick(gfs.ServerStoreInterval)
+		syncTicker := time.Tick(gfs.JournalSyncInterval)
 		garbageTicker := time.Tick(gfs.GarbageCollectionInt)
 		quickStart := make(chan bool, 1) // send first heartbeat right away..
 		quickStart <- true
@@ -123,21 +236,34 @@ func NewAndServe(addr, masterAddr gfs.ServerAddress, rootDir string) *ChunkServe
 			select {
 			case <-cs.shutdown:
 				return
+			case done := <-cs.leave:
+				cs.depart()
+				close(done)
+				return
 			case <-quickStart:
 				branch = "heartbeat"
 				err = cs.heartbeat()
-			case <-heartbeatTicker:
+			case <-heartbeatTimer.C:
+				heartbeatTimer.Reset(time.Duration(atomic.LoadInt64(&cs.hbInterval)))
 				branch = "heartbeat"
 				err = cs.heartbeat()
 			case <-storeTicker:
-				branch = "storemeta"
-				err = cs.storeMeta()
+				branch = "checkpoint"
+				err = cs.checkpoint()
+			case <-cs.checkpoints:
+				branch = "checkpoint"
+				err = cs.checkpoint()
+			case <-syncTicker:
+				branch = "journalsync"
+				err = cs.journal.sync()
 			case <-garbageTicker:
 				branch = "garbagecollecton"
 				err = cs.garbageCollection()
 			}
 
-			if err != nil {
+			if err != nil && branch == "heartbeat" {
+				util.Subsystem(util.LogHeartbeat).Errorf("%v background(%v) error %v", cs.address, branch, err)
+			} else if err != nil {
 				log.Errorf("%v background(%v) error %v", cs.address, branch, err)
 			}
 		}
@@ -148,27 +274,199 @@ func NewAndServe(addr, masterAddr gfs.ServerAddress, rootDir string) *ChunkServe
 	return cs
 }
 
-// heartbeat calls master regularly to report chunkserver's status
+// register tells master the capacity, version, topology and chunks of the
+// chunkserver. Master only accepts heartbeats of registered servers.
+func (cs *ChunkServer) register() error {
+	cs.lock.RLock()
+	domain, labels, topology := cs.domain, cs.labels, cs.topology
+	cs.lock.RUnlock()
+
+	capacity, _ := cs.diskStats()
+	args := gfs.RegisterServerArg{
+		Address:  cs.address,
+		Capacity: capacity,
+		Version:  gfs.Version,
+		Domain:   domain,
+		Labels:   labels,
+		Topology: topology,
+		Chunks:   cs.inventory(),
+		Token:    cs.secret.ServerToken(),
+	}
+	if err := util.Call(cs.ctx, cs.master, "Master.RPCRegisterServer", args, &gfs.RegisterServerReply{}); err != nil {
+		return err
+	}
+	util.Subsystem(util.LogHeartbeat).Infof("%v registered with master %v, %v chunks", cs.address, cs.master, len(args.Chunks))
+	cs.registered = true
+	return nil
+}
+
+// depart sends a last heartbeat, with the lengths of the chunks mutated as
+// primary and the leases to release, and deregisters from master.
+func (cs *ChunkServer) depart() {
+	if !cs.registered {
+		return
+	}
+	if err := cs.heartbeat(); err != nil {
+		util.Subsystem(util.LogHeartbeat).Warningf("%v last heartbeat error %v", cs.address, err)
+	}
+	if err := util.Call(cs.ctx, cs.master, "Master.RPCDeregisterServer", gfs.DeregisterServerArg{cs.address, cs.secret.ServerToken()}, &gfs.DeregisterServerReply{}); err != nil {
+		util.Subsystem(util.LogHeartbeat).Warningf("%v deregister error %v", cs.address, err)
+		return
+	}
+	cs.registered = false
+	util.Subsystem(util.LogHeartbeat).Infof("%v deregistered from master %v", cs.address, cs.master)
+}
+
+// heartbeat calls master regularly to report chunkserver's status.
+// The chunkserver registers first, and again if master does not know it anymore.
 func (cs *ChunkServer) heartbeat() error {
-	pe := cs.pendingLeaseExtensions.GetAllAndClear()
-	le := make([]gfs.ChunkHandle, len(pe))
-	for i, v := range pe {
-		le[i] = v.(gfs.ChunkHandle)
+	if !cs.registered {
+		return cs.register()
 	}
+	extend, release := cs.leases.due(time.Now())
+	lengths := cs.leases.report()
+	cs.lock.RLock()
+	domain, labels, topology, chunks, lost := cs.domain, cs.labels, cs.topology, len(cs.chunk), cs.lost
+	cs.lock.RUnlock()
+
+	now := time.Now()
+	var ioLoad, mutationLoad int64
+	if elapsed := now.Sub(cs.lastHeartbeat); !cs.lastHeartbeat.IsZero() && elapsed > 0 {
+		ioLoad = int64(float64(atomic.SwapInt64(&cs.ioBytes, 0)) / elapsed.Seconds())
+		mutationLoad = int64(float64(atomic.SwapInt64(&cs.mutationCount, 0)) / elapsed.Seconds())
+	}
+	cs.lastHeartbeat = now
+
 	args := &gfs.HeartbeatArg{
-		Address:         cs.address,
-		LeaseExtensions: le,
+		Address:          cs.address,
+		Domain:           domain,
+		Labels:           labels,
+		Topology:         topology,
+		DiskUsed:         atomic.LoadInt64(&cs.diskUsed),
+		DiskFree:         cs.diskFree(),
+		Chunks:           chunks,
+		IOLoad:           ioLoad,
+		MutationLoad:     mutationLoad,
+		LeaseExtensions:  extend,
+		LeaseReleases:    release,
+		AbandondedChunks: lost,
+		ChunkLengths:     lengths,
+		Token:            cs.secret.ServerToken(),
 	}
 	var r gfs.HeartbeatReply
-	err := util.Call(cs.master, "Master.RPCHeartbeat", args, &r)
+	start := time.Now()
+	err := util.Call(cs.ctx, cs.master, "Master.RPCHeartbeat", args, &r)
+	cs.metrics.observeHeartbeat(start, err)
 	if err != nil {
+		cs.leases.unreport(lengths)
+	}
+	if errors.Is(err, gfs.NotRegistered) {
+		cs.registered = false
+		return cs.register()
+	} else if err != nil {
 		return err
 	}
 
-	cs.garbage = append(cs.garbage, r.Garbage...)
+	util.Subsystem(util.LogHeartbeat).Debugf("%v heartbeat: %v chunks, %v lease extensions, %v garbage, %v revoked, %v stale", cs.address, chunks, len(extend), len(r.Garbage), len(r.Revoked), len(r.Stale)+len(r.Versions))
+	cs.obey(&r)
+	cs.lock.Lock()
+	cs.lost = cs.lost[len(lost):] // reported
+	cs.lock.Unlock()
 	return err
 }
 
+// obey acts on the commands of master in a heartbeat reply: the garbage is
+// collected later, the leases revoked are refused as primary and the stale
+// replicas are abandoned until they are repaired.
+func (cs *ChunkServer) obey(r *gfs.HeartbeatReply) {
+	cs.garbage = append(cs.garbage, r.Garbage...)
+	for _, l := range r.Revoked {
+		cs.revokeLease(l.Handle, l.Expire)
+	}
+	for _, handle := range r.Stale {
+		cs.abandonStale(handle, 0)
+	}
+	for _, v := range r.Versions {
+		cs.abandonStale(v.Handle, v.Version)
+	}
+}
+
+// abandonStale abandons a replica as stale if its version is older than
+// version, whatever it is if version is 0, after the mutation in flight.
+func (cs *ChunkServer) abandonStale(handle gfs.ChunkHandle, version gfs.ChunkVersion) {
+	cs.lock.RLock()
+	ck, ok := cs.chunk[handle]
+	cs.lock.RUnlock()
+	if !ok {
+		return
+	}
+	ck.Lock()
+	defer ck.Unlock()
+	if version != 0 && ck.version >= version || ck.abandoned {
+		return
+	}
+	log.Warningf("%v : stale chunk %v (version %v) abandoned by master", cs.address, handle, ck.version)
+	ck.abandoned, ck.stale = true, true
+}
+
+// diskFree returns the bytes available on the disk of the root directory, -1 if unknown.
+func (cs *ChunkServer) diskFree() int64 {
+	_, free := cs.diskStats()
+	return free
+}
+
+// SetFailureDomain sets the failure domain (rack/zone) the chunkserver lives in.
+// It is reported to master in the following heartbeats.
+func (cs *ChunkServer) SetFailureDomain(domain string) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.domain = domain
+}
+
+// HTTPHandler returns the handler of the chunkserver http endpoints, i.e.
+// /metrics and the /status page.
+func (cs *ChunkServer) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", cs.metrics)
+	mux.HandleFunc("/status", cs.serveStatus)
+	return mux
+}
+
+// SetLabels sets the labels (e.g. ssd, jurisdiction=eu) matched by the placement
+// constraints of files. They are reported to master in the following heartbeats.
+func (cs *ChunkServer) SetLabels(labels map[string]string) {
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.labels = copied
+}
+
+// SetAuthSecret sets the secret shared with master. With one, reads, data
+// pushes and mutations from clients need a chunk token issued by master, the
+// rpcs of master and of the other chunkservers a server token, and the rpcs
+// of this server are signed with one. nil disables authentication.
+func (cs *ChunkServer) SetAuthSecret(key []byte) {
+	cs.secret.Set(key)
+}
+
+// SetTLSConfig sets the TLS config of the rpc connections accepted, nil
+// accepts plaintext. If plaintext is set, connections not beginning a TLS
+// handshake are accepted in plaintext too.
+func (cs *ChunkServer) SetTLSConfig(config *tls.Config, plaintext bool) {
+	cs.tlsConf.Set(config, plaintext)
+}
+
+// SetTopology sets the zone and rack of the chunkserver, e.g. parsed from
+// rack=r1,zone=a by gfs.ParseTopology. Master spreads replicas across them.
+func (cs *ChunkServer) SetTopology(t gfs.Topology) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.topology = t
+}
+
 // garbage collection  Note: no lock are needed, since the background activities are single thread
 func (cs *ChunkServer) garbageCollection() error {
 	for _, v := range cs.garbage {
@@ -179,8 +477,23 @@ func (cs *ChunkServer) garbageCollection() error {
 	return nil
 }
 
+// RPCBuildInfo returns the version, commit, protocol level and features of the chunkserver.
+func (cs *ChunkServer) RPCBuildInfo(args gfs.Nouse, reply *gfs.BuildInfoReply) error {
+	reply.BuildInfo = gfs.Build(features...)
+	return nil
+}
+
 // RPCReportSelf reports all chunks the server holds
 func (cs *ChunkServer) RPCReportSelf(args gfs.ReportSelfArg, reply *gfs.ReportSelfReply) error {
+	if err := cs.secret.CheckServer(args.Token); err != nil {
+		return err
+	}
+	reply.Chunks = cs.inventory()
+	return nil
+}
+
+// inventory returns all chunks the server holds.
+func (cs *ChunkServer) inventory() []gfs.PersistentChunkInfo {
 	cs.lock.RLock()
 	defer cs.lock.RUnlock()
 
@@ -189,16 +502,15 @@ func (cs *ChunkServer) RPCReportSelf(args gfs.ReportSelfArg, reply *gfs.ReportSe
 	for handle, ck := range cs.chunk {
 		//log.Info(cs.address, " report ", handle)
 		ret = append(ret, gfs.PersistentChunkInfo{
-			Handle:   handle,
-			Version:  ck.version,
-			Length:   ck.length,
-			Checksum: ck.checksum,
+			Handle:    handle,
+			Version:   ck.version,
+			Length:    ck.length,
+			Checksum:  ck.checksum,
+			ChunkSize: ck.chunkSize,
 		})
 	}
-	reply.Chunks = ret
 	log.Debug(cs.address, " report collect end")
-
-	return nil
+	return ret
 }
 
 // loadMeta loads metadata from disk
@@ -225,10 +537,17 @@ func (cs *ChunkServer) loadMeta() error {
 	// load into memory
 	for _, ck := range metas {
 		//log.Infof("Server %v restore %v version: %v length: %v", cs.address, ck.Handle, ck.Version, ck.Length)
+		chunkSize := ck.ChunkSize
+		if chunkSize == 0 { // stored before chunk sizes were configurable
+			chunkSize = gfs.MaxChunkSize
+		}
 		cs.chunk[ck.Handle] = &chunkInfo{
-			length:  ck.Length,
-			version: ck.Version,
+			length:    ck.Length,
+			version:   ck.Version,
+			size:      ck.Length,
+			chunkSize: chunkSize,
 		}
+		atomic.AddInt64(&cs.diskUsed, int64(ck.Length))
 	}
 
 	return nil
@@ -240,7 +559,7 @@ func (cs *ChunkServer) storeMeta() error {
 	defer cs.lock.RUnlock()
 
 	filename := path.Join(cs.rootDir, MetaFileName)
-	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, FilePerm)
+	file, err := os.OpenFile(filename+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, FilePerm)
 	if err != nil {
 		return err
 	}
@@ -249,71 +568,264 @@ func (cs *ChunkServer) storeMeta() error {
 	var metas []gfs.PersistentChunkInfo
 	for handle, ck := range cs.chunk {
 		metas = append(metas, gfs.PersistentChunkInfo{
-			Handle: handle, Length: ck.length, Version: ck.version,
+			Handle: handle, Length: ck.length, Version: ck.version, ChunkSize: ck.chunkSize,
 		})
 	}
 
 	log.Infof("Server %v : store metadata len: %v", cs.address, len(metas))
 	enc := gob.NewEncoder(file)
-	err = enc.Encode(metas)
+	if err := enc.Encode(metas); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
 
-	return err
+// SetMaxOpenFiles sets the max number of chunk files kept open.
+func (cs *ChunkServer) SetMaxOpenFiles(max int) {
+	cs.files.setMax(max)
+}
+
+// SetDownloadBufferMax sets how many bytes of pushed data are held in memory
+// at most, in all and for a single client. 0 means unlimited. Data beyond is
+// spilled to disk, or rejected with gfs.ServerBusy until mutations consume
+// the data held if it does not fit in the spill budget.
+func (cs *ChunkServer) SetDownloadBufferMax(max, clientMax int64) {
+	cs.dl.setMax(max, clientMax)
+}
+
+// SetDownloadBufferExpire sets how long pushed data is held until the
+// mutation using it, gfs.DownloadBufferExpire at first.
+func (cs *ChunkServer) SetDownloadBufferExpire(expire time.Duration) {
+	cs.dl.setExpire(expire)
+}
+
+// SetHeartbeatInterval sets the interval of the heartbeats to master,
+// gfs.HeartbeatInterval at first, from the next heartbeat on. It should be
+// well below the server timeout of master.
+func (cs *ChunkServer) SetHeartbeatInterval(interval time.Duration) {
+	atomic.StoreInt64(&cs.hbInterval, int64(interval))
+}
+
+// SetLeaseExpire sets the duration of the leases granted by master,
+// gfs.LeaseExpire at first, see Master.SetLeaseExpire.
+func (cs *ChunkServer) SetLeaseExpire(expire time.Duration) {
+	cs.leases.Lock()
+	defer cs.leases.Unlock()
+	cs.leases.expire = expire
+}
+
+// SetDownloadBufferSpill sets how many bytes of pushed data are spilled to
+// disk at most. 0 disables spilling.
+func (cs *ChunkServer) SetDownloadBufferSpill(max int64) {
+	cs.dl.setSpill(path.Join(cs.rootDir, SpillDirName), max)
+}
+
+// Drain shuts the chunkserver down gracefully: it stops accepting
+// connections and reading rpcs and streams from the accepted ones, waits up
+// to timeout for the mutations in flight, deregisters from master, then
+// checkpoints, syncing the chunks and truncating the journal, and shuts
+// down. Data pushed for mutations that were not sent is dropped.
+func (cs *ChunkServer) Drain(timeout time.Duration) {
+	if cs.dead || !atomic.CompareAndSwapInt32(&cs.draining, 0, 1) {
+		return
+	}
+	log.Infof("%v draining", cs.address)
+	cs.l.Close()
+	grpcStopped := make(chan bool, 1)
+	go func() { grpcStopped <- util.StopGRPC(cs.grpc, timeout) }()
+	if !util.DrainConns(cs.conns, timeout) || !<-grpcStopped {
+		log.Warningf("%v rpcs still in flight after %v, shutting down anyway", cs.address, timeout)
+	}
+
+	done := make(chan struct{})
+	select {
+	case cs.leave <- done:
+		<-done
+	case <-time.After(timeout):
+		log.Warningf("%v busy in the background, shutting down without deregistering", cs.address)
+	}
+	if err := cs.checkpoint(); err != nil {
+		log.Warning("error in checkpoint on shutdown: ", err)
+	}
+	cs.Shutdown()
 }
 
 // Shutdown shuts the chunkserver down
-//func (cs *ChunkServer) Shutdown(args gfs.Nouse, reply *gfs.Nouse) error {
+// func (cs *ChunkServer) Shutdown(args gfs.Nouse, reply *gfs.Nouse) error {
 func (cs *ChunkServer) Shutdown() {
 	if !cs.dead {
 		log.Warning(cs.address, " Shutdown")
 		cs.dead = true
 		close(cs.shutdown)
+		cs.cancel()
 		cs.l.Close()
+		cs.grpc.Stop()
+		for _, v := range cs.conns.GetAllAndClear() {
+			v.(net.Conn).Close()
+		}
+		cs.files.closeAll()
 	}
 	err := cs.storeMeta()
 	if err != nil {
 		log.Warning("error in store metadeta: ", err)
 	}
+	cs.journal.close()
+}
+
+// ChunkFile returns the file a chunk is stored in, and whether the
+// chunkserver has the chunk, e.g. for tools inspecting chunks on disk.
+func (cs *ChunkServer) ChunkFile(handle gfs.ChunkHandle) (string, bool) {
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+	ck, ok := cs.chunk[handle]
+	if !ok {
+		return "", false
+	}
+	return ck.dir.chunkFilename(handle), true
 }
 
 // RPCCheckVersion is called by master to check version ande detect stale chunk
 func (cs *ChunkServer) RPCCheckVersion(args gfs.CheckVersionArg, reply *gfs.CheckVersionReply) error {
+	if err := cs.secret.CheckServer(args.Token); err != nil {
+		return err
+	}
 	cs.lock.RLock()
 	ck, ok := cs.chunk[args.Handle]
 	cs.lock.RUnlock()
 	if !ok || ck.abandoned {
-		return fmt.Errorf("Chunk %v does not exist or is abandoned", args.Handle)
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v does not exist or is abandoned", args.Handle)}
 	}
 
 	ck.Lock()
 	defer ck.Unlock()
 
 	if ck.version+gfs.ChunkVersion(1) == args.Version {
+		cs.journal.RLock()
+		defer cs.journal.RUnlock()
+		if err := cs.journal.append(journalRecord{journalVersion, args.Handle, args.Version, ck.chunkSize, ck.length, nil}); err != nil {
+			return err
+		}
 		ck.version++
-		reply.Stale = false
+		ck.dir.storeVersion(args.Handle, ck.version, ck.chunkSize, ck.sealed)
+		if ck.changes == nil {
+			ck.changes = &changeLog{from: ck.version}
+		}
+		reply.Stale, reply.Length = false, ck.length
+		cs.leases.granted(args.Handle)
+		cs.throttle.set(args.Handle, args.Throttle)
 	} else {
 		log.Warningf("%v : stale chunk %v", cs.address, args.Handle)
-		ck.abandoned = true
+		ck.abandoned, ck.stale = true, true
 		reply.Stale = true
 	}
 	return nil
 }
 
+// RPCRevokeLease is called by master to take back the lease of a chunk held
+// as primary. It returns once the mutation in flight is applied, the chunk
+// refuses mutations as primary until the lease would have expired.
+func (cs *ChunkServer) RPCRevokeLease(args gfs.RevokeLeaseArg, reply *gfs.RevokeLeaseReply) error {
+	if err := cs.secret.CheckServer(args.Token); err != nil {
+		return err
+	}
+	cs.revokeLease(args.Handle, args.Expire)
+	return nil
+}
+
+// revokeLease gives up the lease of a chunk as primary once the mutation in
+// flight is applied, refusing mutations until expire.
+func (cs *ChunkServer) revokeLease(handle gfs.ChunkHandle, expire time.Time) {
+	cs.lock.RLock()
+	ck, ok := cs.chunk[handle]
+	cs.lock.RUnlock()
+	if ok {
+		ck.Lock()
+		defer ck.Unlock()
+	}
+	cs.leases.revoke(handle, expire)
+	log.Infof("Server %v : lease of chunk %v revoked", cs.address, handle)
+}
+
+// RPCTruncateChunk is called by master to cut a chunk held as primary to
+// args.Length, on itself and the secondaries, ordered with the mutations.
+func (cs *ChunkServer) RPCTruncateChunk(args gfs.TruncateChunkArg, reply *gfs.TruncateChunkReply) error {
+	if err := cs.secret.CheckServer(args.Token); err != nil {
+		return err
+	}
+	handle := args.Handle
+	cs.lock.RLock()
+	ck, ok := cs.chunk[handle]
+	cs.lock.RUnlock()
+	if !ok || ck.abandoned {
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v does not exist or is abandoned", handle)}
+	}
+
+	ck.Lock()
+	defer ck.Unlock()
+	if err := checkEpoch(handle, ck, args.Epoch); err != nil {
+		return err
+	}
+	if err := cs.leases.use(handle); err != nil {
+		return err
+	}
+	mutation := &Mutation{gfs.MutationTruncate, nil, args.Length}
+
+	// apply to local
+	wait := make(chan error, 1)
+	go func() {
+		wait <- cs.doMutation(handle, mutation)
+	}()
+
+	// call secondaries
+	callArgs := gfs.ApplyMutationArg{gfs.MutationTruncate, gfs.DataBufferID{Handle: handle}, args.Length, "", false, cs.secret.ServerToken()}
+	if err := cs.applyToSecondaries(args.Secondaries, callArgs); err != nil {
+		return err
+	}
+	if err := <-wait; err != nil {
+		return err
+	}
+	cs.leases.mutated(handle, ck.length)
+	return nil
+}
+
 // RPCForwardData is called by client or another replica who sends data to the current memory buffer.
-func (cs *ChunkServer) RPCForwardData(args gfs.ForwardDataArg, reply *gfs.ForwardDataReply) error {
+func (cs *ChunkServer) RPCForwardData(args gfs.ForwardDataArg, reply *gfs.ForwardDataReply) (err error) {
+	end := util.StartSpan(args.Trace, cs.address, "ChunkServer.RPCForwardData")
+	defer func() { end(err) }()
+	if err := cs.secret.CheckChunk(args.Token, args.DataID.Handle, true); err != nil {
+		return err
+	}
+	if err := cs.shed(workPush); err != nil {
+		return err
+	}
+	if err := cs.limit(qosForeground, args.Client, len(args.Data)); err != nil {
+		return err
+	}
 	//log.Warning(cs.address, " data 1 ", args.DataID)
 	if _, ok := cs.dl.Get(args.DataID); ok {
-		return fmt.Errorf("Data %v already exists", args.DataID)
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("Data %v already exists", args.DataID)}
 	}
 
 	//log.Infof("Server %v : get data %v", cs.address, args.DataID)
 	//log.Warning(cs.address, "data 2 ", args.DataID)
-	cs.dl.Set(args.DataID, args.Data)
+	if err := cs.dl.Set(args.DataID, args.Data, args.Client); err != nil {
+		cs.metrics.busy.Inc()
+		return err
+	}
 	//log.Warning(cs.address, "data 3 ", args.DataID)
 
 	if len(args.ChainOrder) > 0 {
 		next := args.ChainOrder[0]
 		args.ChainOrder = args.ChainOrder[1:]
-		err := util.Call(next, "ChunkServer.RPCForwardData", args, reply)
+		data := args.Data
+		args.Data = nil
+		_, err := util.CallStream(cs.ctx, next, "ChunkServer.RPCForwardData", args, data, reply, nil)
+		if err != nil {
+			cs.dl.Delete(args.DataID) // the client pushes again, under a new id
+		}
 		return err
 	}
 	//log.Warning(cs.address, "data 4 ", args.DataID)
@@ -323,45 +835,115 @@ func (cs *ChunkServer) RPCForwardData(args gfs.ForwardDataArg, reply *gfs.Forwar
 
 // RPCCreateChunk is called by master to create a new chunk given the chunk handle.
 func (cs *ChunkServer) RPCCreateChunk(args gfs.CreateChunkArg, reply *gfs.CreateChunkReply) error {
+	if err := cs.secret.CheckServer(args.Token); err != nil {
+		return err
+	}
+	cs.journal.RLock()
+	defer cs.journal.RUnlock()
 	cs.lock.Lock()
 	defer cs.lock.Unlock()
 	log.Infof("Server %v : create chunk %v", cs.address, args.Handle)
 
 	if _, ok := cs.chunk[args.Handle]; ok {
+		if args.Fresh {
+			return gfs.Error{gfs.ChunkExists, fmt.Sprintf("chunk %v exists", args.Handle)}
+		}
 		log.Warning("[ignored] recreate a chunk in RPCCreateChunk")
 		return nil // TODO : error handle
 		//return fmt.Errorf("Chunk %v already exists", args.Handle)
 	}
 
+	chunkSize := args.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = gfs.MaxChunkSize
+	}
+	if chunkSize < 0 || chunkSize > gfs.MaxChunkSize {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("chunk size %v exceeds max chunk size %v", chunkSize, gfs.MaxChunkSize)}
+	}
+
+	sealed := cs.keys != nil // if chunks are encrypted now
+
+	// in the next healthy dir, the ones failing are skipped
+	var d *dataDir
+	for {
+		var err error
+		if d, err = cs.nextDir(); err != nil {
+			return err
+		}
+		if err = cs.createChunkFile(d, args.Handle, chunkSize, sealed); err == nil {
+			break
+		}
+		cs.lock.Unlock()
+		cs.checkDir(d, err)
+		cs.lock.Lock()
+		if d.healthy() {
+			return err
+		}
+	}
 	cs.chunk[args.Handle] = &chunkInfo{
-		length: 0,
+		length:    0,
+		chunkSize: chunkSize,
+		sealed:    sealed,
+		changes:   &changeLog{},
+		dir:       d,
+	}
+	return cs.journal.append(journalRecord{journalReset, args.Handle, 0, chunkSize, 0, resetData(sealed)})
+}
+
+func (cs *ChunkServer) createChunkFile(d *dataDir, handle gfs.ChunkHandle, chunkSize gfs.Offset, sealed bool) error {
+	if err := os.MkdirAll(d.chunkDir(handle), FilePerm); err != nil {
+		return err
 	}
-	filename := path.Join(cs.rootDir, fmt.Sprintf("chunk%v.chk", args.Handle))
-	_, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0644)
+	f, err := cs.files.get(handle, d.chunkFilename(handle), true)
 	if err != nil {
 		return err
 	}
-	return nil
+	cs.files.put(f)
+	return d.storeVersion(handle, 0, chunkSize, sealed)
 }
 
 // RPCReadChunk is called by client, read chunk data and return
 func (cs *ChunkServer) RPCReadChunk(args gfs.ReadChunkArg, reply *gfs.ReadChunkReply) error {
 	handle := args.Handle
+	if err := cs.secret.CheckChunk(args.Token, handle, false); err != nil {
+		return err
+	}
+	if err := cs.limit(qosForeground, args.Client, args.Length); err != nil {
+		return err
+	}
 	cs.lock.RLock()
 	ck, ok := cs.chunk[handle]
 	cs.lock.RUnlock()
 	if !ok || ck.abandoned {
-		return fmt.Errorf("Chunk %v does not exist or is abandoned", handle)
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v does not exist or is abandoned", handle)}
+	}
+
+	ck.RLock()
+	defer ck.RUnlock()
+	reply.ChunkLength = ck.length
+	reply.Version = ck.version
+
+	// clamp to the committed length
+	length := args.Length
+	if args.Offset+gfs.Offset(length) > ck.length {
+		length = int(ck.length - args.Offset)
+		if length < 0 {
+			length = 0
+		}
+		reply.ErrorCode = gfs.ReadEOF
+	}
+	if length == 0 {
+		return nil
 	}
 
 	// read from disk
 	var err error
-	reply.Data = make([]byte, args.Length)
-	ck.RLock()
+	reply.Data = make([]byte, length)
 	reply.Length, err = cs.readChunk(handle, args.Offset, reply.Data)
-	ck.RUnlock()
 	if err == io.EOF {
-		reply.ErrorCode = gfs.ReadEOF
+		// the chunk file is shorter than what has been committed to it
+		log.Warningf("%v: chunk %v ends at %v on disk, committed length %v", cs.address, handle, args.Offset+gfs.Offset(reply.Length), ck.length)
+		reply.ErrorCode = gfs.PhysicalEOF
 		return nil
 	}
 
@@ -373,7 +955,12 @@ func (cs *ChunkServer) RPCReadChunk(args gfs.ReadChunkArg, reply *gfs.ReadChunkR
 
 // RPCWriteChunk is called by client
 // applies chunk write to itself (primary) and asks secondaries to do the same.
-func (cs *ChunkServer) RPCWriteChunk(args gfs.WriteChunkArg, reply *gfs.WriteChunkReply) error {
+func (cs *ChunkServer) RPCWriteChunk(args gfs.WriteChunkArg, reply *gfs.WriteChunkReply) (err error) {
+	end := util.StartSpan(args.Trace, cs.address, "ChunkServer.RPCWriteChunk")
+	defer func() { end(err) }()
+	if err := cs.secret.CheckChunk(args.Token, args.DataID.Handle, true); err != nil {
+		return err
+	}
 	data, err := cs.dl.Fetch(args.DataID)
 	if err != nil {
 		return err
@@ -381,7 +968,7 @@ func (cs *ChunkServer) RPCWriteChunk(args gfs.WriteChunkArg, reply *gfs.WriteChu
 
 	newLen := args.Offset + gfs.Offset(len(data))
 	if newLen > gfs.MaxChunkSize {
-		return fmt.Errorf("writeChunk new length is too large. Size %v > MaxSize %v", len(data), gfs.MaxChunkSize)
+		return gfs.Error{gfs.WriteExceedChunkSize, fmt.Sprintf("writeChunk new length is too large. Size %v > MaxSize %v", len(data), gfs.MaxChunkSize)}
 	}
 
 	handle := args.DataID.Handle
@@ -389,12 +976,25 @@ func (cs *ChunkServer) RPCWriteChunk(args gfs.WriteChunkArg, reply *gfs.WriteChu
 	ck, ok := cs.chunk[handle]
 	cs.lock.RUnlock()
 	if !ok || ck.abandoned {
-		return fmt.Errorf("Chunk %v does not exist or is abandoned", handle)
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v does not exist or is abandoned", handle)}
 	}
 
 	if err = func() error {
 		ck.Lock()
 		defer ck.Unlock()
+		if _, ok := cs.dedup.get(handle, args.RequestID); ok {
+			cs.metrics.dedup.Inc()
+			return nil
+		}
+		if err := checkEpoch(handle, ck, args.Epoch); err != nil {
+			return err
+		}
+		if err := cs.leases.use(handle); err != nil {
+			return err
+		}
+		if newLen > ck.chunkSize {
+			return gfs.Error{gfs.WriteExceedChunkSize, fmt.Sprintf("writeChunk new length is too large. Size %v > chunk size %v", newLen, ck.chunkSize)}
+		}
 		mutation := &Mutation{gfs.MutationWrite, data, args.Offset}
 
 		// apply to local
@@ -404,24 +1004,25 @@ func (cs *ChunkServer) RPCWriteChunk(args gfs.WriteChunkArg, reply *gfs.WriteChu
 		}()
 
 		// call secondaries
-		callArgs := gfs.ApplyMutationArg{gfs.MutationWrite, args.DataID, args.Offset}
-		err = util.CallAll(args.Secondaries, "ChunkServer.RPCApplyMutation", callArgs)
+		callArgs := gfs.ApplyMutationArg{gfs.MutationWrite, args.DataID, args.Offset, args.Trace, args.Durable, cs.secret.ServerToken()}
+		err = cs.applyToSecondaries(args.Secondaries, callArgs)
 		if err != nil {
 			return err
 		}
 
 		err = <-wait
+		if err == nil && args.Durable {
+			err = cs.makeDurable(handle, ck)
+		}
 		if err != nil {
 			return err
 		}
+		cs.dedup.put(handle, args.RequestID, args.Offset, 0)
+		cs.leases.mutated(handle, ck.length)
 		return nil
 	}(); err != nil {
 		return err
 	}
-
-	// extend lease
-	//cs.pendingLeaseExtensions.Add(handle)
-
 	return nil
 }
 
@@ -429,14 +1030,19 @@ func (cs *ChunkServer) RPCWriteChunk(args gfs.WriteChunkArg, reply *gfs.WriteChu
 // The length of data should be within 1/4 chunk size.
 // If the chunk size after appending the data will excceed the limit,
 // pad current chunk and ask the client to retry on the next chunk.
-func (cs *ChunkServer) RPCAppendChunk(args gfs.AppendChunkArg, reply *gfs.AppendChunkReply) error {
+func (cs *ChunkServer) RPCAppendChunk(args gfs.AppendChunkArg, reply *gfs.AppendChunkReply) (err error) {
+	end := util.StartSpan(args.Trace, cs.address, "ChunkServer.RPCAppendChunk")
+	defer func() { end(err) }()
+	if err := cs.secret.CheckChunk(args.Token, args.DataID.Handle, true); err != nil {
+		return err
+	}
 	data, err := cs.dl.Fetch(args.DataID)
 	if err != nil {
 		return err
 	}
 
 	if len(data) > gfs.MaxAppendSize {
-		return fmt.Errorf("Append data size %v excceeds max append size %v", len(data), gfs.MaxAppendSize)
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("Append data size %v excceeds max append size %v", len(data), gfs.MaxAppendSize)}
 	}
 
 	handle := args.DataID.Handle
@@ -444,7 +1050,7 @@ func (cs *ChunkServer) RPCAppendChunk(args gfs.AppendChunkArg, reply *gfs.Append
 	ck, ok := cs.chunk[handle]
 	cs.lock.RUnlock()
 	if !ok || ck.abandoned {
-		return fmt.Errorf("Chunk %v does not exist or is abandoned", handle)
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v does not exist or is abandoned", handle)}
 	}
 
 	var mtype gfs.MutationType
@@ -452,11 +1058,29 @@ func (cs *ChunkServer) RPCAppendChunk(args gfs.AppendChunkArg, reply *gfs.Append
 	if err = func() error {
 		ck.Lock()
 		defer ck.Unlock()
+		if r, ok := cs.dedup.get(handle, args.RequestID); ok {
+			cs.metrics.dedup.Inc()
+			reply.Offset, reply.ErrorCode = r.offset, r.code
+			return nil
+		}
+		if err := checkEpoch(handle, ck, args.Epoch); err != nil {
+			return err
+		}
+		if err := cs.leases.use(handle); err != nil {
+			return err
+		}
+		if err := cs.throttle.allow(handle); err != nil {
+			cs.metrics.throttled.Inc()
+			return err
+		}
+		if gfs.Offset(len(data)) > ck.chunkSize/4 {
+			return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("Append data size %v excceeds 1/4 chunk size %v", len(data), ck.chunkSize)}
+		}
 		newLen := ck.length + gfs.Offset(len(data))
 		offset := ck.length
-		if newLen > gfs.MaxChunkSize {
+		if newLen > ck.chunkSize {
 			mtype = gfs.MutationPad
-			ck.length = gfs.MaxChunkSize
+			ck.length = ck.chunkSize
 			reply.ErrorCode = gfs.AppendExceedChunkSize
 		} else {
 			mtype = gfs.MutationAppend
@@ -475,40 +1099,49 @@ func (cs *ChunkServer) RPCAppendChunk(args gfs.AppendChunkArg, reply *gfs.Append
 		}()
 
 		// call secondaries
-		callArgs := gfs.ApplyMutationArg{mtype, args.DataID, offset}
-		err = util.CallAll(args.Secondaries, "ChunkServer.RPCApplyMutation", callArgs)
+		callArgs := gfs.ApplyMutationArg{mtype, args.DataID, offset, args.Trace, args.Durable, cs.secret.ServerToken()}
+		err = cs.applyToSecondaries(args.Secondaries, callArgs)
 		if err != nil {
 			return err
 		}
 
 		err = <-wait
+		if err == nil && args.Durable {
+			err = cs.makeDurable(handle, ck)
+		}
 		if err != nil {
 			return err
 		}
+		cs.dedup.put(handle, args.RequestID, offset, reply.ErrorCode)
+		cs.leases.mutated(handle, ck.length)
 		return nil
 	}(); err != nil {
 		return err
 	}
-
-	// extend lease
-	//cs.pendingLeaseExtensions.Add(handle)
-
 	return nil
 }
 
 // RPCApplyWriteChunk is called by primary to apply mutations
-func (cs *ChunkServer) RPCApplyMutation(args gfs.ApplyMutationArg, reply *gfs.ApplyMutationReply) error {
-	data, err := cs.dl.Fetch(args.DataID)
-	if err != nil {
+func (cs *ChunkServer) RPCApplyMutation(args gfs.ApplyMutationArg, reply *gfs.ApplyMutationReply) (err error) {
+	end := util.StartSpan(args.Trace, cs.address, "ChunkServer.RPCApplyMutation")
+	defer func() { end(err) }()
+	if err := cs.secret.CheckServer(args.Token); err != nil {
 		return err
 	}
+	var data []byte
+	if args.Mtype != gfs.MutationTruncate { // a truncation pushes no data
+		data, err = cs.dl.Fetch(args.DataID)
+		if err != nil {
+			return err
+		}
+	}
 
 	handle := args.DataID.Handle
 	cs.lock.RLock()
 	ck, ok := cs.chunk[handle]
 	cs.lock.RUnlock()
 	if !ok || ck.abandoned {
-		return fmt.Errorf("cannot find chunk %v", handle)
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("cannot find chunk %v", handle)}
 	}
 
 	//log.Infof("Server %v : get mutation to chunk %v version %v", cs.address, handle, args.Version)
@@ -518,6 +1151,9 @@ func (cs *ChunkServer) RPCApplyMutation(args gfs.ApplyMutationArg, reply *gfs.Ap
 		ck.Lock()
 		defer ck.Unlock()
 		err = cs.doMutation(handle, mutation)
+		if err == nil && args.Durable {
+			err = cs.makeDurable(handle, ck)
+		}
 		return err
 	}()
 
@@ -525,59 +1161,238 @@ func (cs *ChunkServer) RPCApplyMutation(args gfs.ApplyMutationArg, reply *gfs.Ap
 }
 
 // RPCSendCCopy is called by master, send the whole copy to given address
+// With args.Repair, a stale replica there is repaired with the ranges it
+// lacks if it can, see repair.go. Otherwise the copy is sent in pieces, paced by the copy bandwidth, and the chunk is
+// locked while a piece is read only, so that writers wait for a piece at
+// most. It starts over if the chunk is mutated. The last piece is sent under
+// the lock, the copy is then identical to the chunk.
 func (cs *ChunkServer) RPCSendCopy(args gfs.SendCopyArg, reply *gfs.SendCopyReply) error {
+	if err := cs.secret.CheckServer(args.Token); err != nil {
+		return err
+	}
+	if err := cs.shed(workCopy); err != nil {
+		return err
+	}
 	handle := args.Handle
 	cs.lock.RLock()
 	ck, ok := cs.chunk[handle]
 	cs.lock.RUnlock()
 	if !ok || ck.abandoned {
-		return fmt.Errorf("Chunk %v does not exist or is abandoned", handle)
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v does not exist or is abandoned", handle)}
 	}
-
 	ck.RLock()
-	defer ck.RUnlock()
-
-	log.Infof("Server %v : Send copy of %v to %v", cs.address, handle, args.Address)
-	data := make([]byte, ck.length)
-	_, err := cs.readChunk(handle, 0, data)
-	if err != nil {
+	length := ck.length
+	ck.RUnlock()
+	if err := cs.limit(qosBackground, "", int(length)); err != nil {
 		return err
 	}
 
-	var r gfs.ApplyCopyReply
-	err = util.Call(args.Address, "ChunkServer.RPCApplyCopy", gfs.ApplyCopyArg{handle, data, ck.version}, &r)
-	if err != nil {
-		return err
+	if args.Repair {
+		err := cs.sendRepair(handle, ck, args.Address)
+		if err == nil {
+			reply.Delta = true
+			return nil
+		}
+		util.Subsystem(util.LogReplication).Infof("Server %v : cannot repair %v on %v, copy sent: %v", cs.address, handle, args.Address, err)
 	}
 
-	return nil
+	util.Subsystem(util.LogReplication).Infof("Server %v : Send copy of %v to %v", cs.address, handle, args.Address)
+	piece := gfs.Offset(atomic.LoadInt64(&cs.copyPiece))
+	var version gfs.ChunkVersion
+	var mutations uint64
+	for offset, restarts := gfs.Offset(0), 0; ; {
+		ck.RLock()
+		if offset == 0 {
+			version, mutations = ck.version, ck.mutations
+		} else if ck.version != version || ck.mutations != mutations {
+			ck.RUnlock()
+			if restarts++; restarts > gfs.CopyMaxRestarts {
+				return gfs.Error{gfs.ServerBusy, fmt.Sprintf("chunk %v mutated during %v copies", handle, restarts)}
+			}
+			util.Subsystem(util.LogReplication).Infof("Server %v : chunk %v mutated, copy started over", cs.address, handle)
+			offset = 0
+			continue
+		}
+		if ck.abandoned {
+			ck.RUnlock()
+			return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v is abandoned", handle)}
+		}
+
+		n := ck.length - offset
+		if n > piece {
+			n = piece
+		}
+		last, chunkSize := offset+n >= ck.length, ck.chunkSize
+		data := make([]byte, n)
+		if n > 0 {
+			if _, err := cs.readChunk(handle, offset, data); err != nil {
+				ck.RUnlock()
+				return err
+			}
+		}
+		if !last {
+			ck.RUnlock()
+		}
+		var r gfs.ApplyCopyReply
+		_, err := util.CallStream(cs.ctx, args.Address, "ChunkServer.RPCApplyCopy", gfs.ApplyCopyArg{handle, nil, offset, version, chunkSize, last, cs.secret.ServerToken()}, data, &r, nil)
+		if last {
+			ck.RUnlock()
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		offset += n
+		if err := cs.copyRate.WaitN(cs.ctx, float64(n)); err != nil {
+			return err
+		}
+	}
 }
 
 // RPCSendCCopy is called by another replica
 // rewrite the local version to given copy data
+// Pieces are applied in order, the first one at offset 0 starts over and the
+// last one makes the copy durable with its version.
 func (cs *ChunkServer) RPCApplyCopy(args gfs.ApplyCopyArg, reply *gfs.ApplyCopyReply) error {
+	if err := cs.secret.CheckServer(args.Token); err != nil {
+		return err
+	}
+	if err := cs.shed(workCopy); err != nil {
+		return err
+	}
+	if err := cs.limit(qosBackground, "", len(args.Data)); err != nil {
+		return err
+	}
 	handle := args.Handle
 	cs.lock.RLock()
 	ck, ok := cs.chunk[handle]
 	cs.lock.RUnlock()
-	if !ok || ck.abandoned {
-		return fmt.Errorf("Chunk %v does not exist or is abandoned", handle)
+	if !ok || ck.abandoned && !ck.stale {
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v does not exist or is abandoned", handle)}
 	}
 
 	ck.Lock()
 	defer ck.Unlock()
 
-	log.Infof("Server %v : Apply copy of %v", cs.address, handle)
+	if args.Offset == 0 && ck.length > 0 {
+		if err := cs.cutChunk(handle, ck, 0); err != nil {
+			return err
+		}
+	}
+	if args.Offset != ck.length {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("piece of the copy of %v at %v, %v copied", handle, args.Offset, ck.length)}
+	}
+
+	// the copy replaces the chunk once synced
+	cs.journal.RLock()
+	defer cs.journal.RUnlock()
+	if args.ChunkSize != 0 {
+		ck.chunkSize = args.ChunkSize
+	}
+	if len(args.Data) > 0 {
+		if err := cs.writeChunk(handle, args.Data, args.Offset, true); err != nil {
+			return err
+		}
+	}
+	if !args.Last {
+		return nil
+	}
 
+	util.Subsystem(util.LogReplication).Infof("Server %v : Apply copy of %v", cs.address, handle)
 	ck.version = args.Version
-	err := cs.writeChunk(handle, args.Data, 0, true)
-	if err != nil {
+	if err := cs.syncChunk(handle, ck.dir); err != nil {
+		cs.checkDir(ck.dir, err)
 		return err
 	}
+	ck.dir.storeVersion(handle, ck.version, ck.chunkSize, ck.sealed)
+	if err := cs.journal.append(journalRecord{journalReset, handle, ck.version, ck.chunkSize, ck.length, resetData(ck.sealed)}); err != nil {
+		return err
+	}
+	if ck.stale {
+		cs.metrics.repairs.Inc("full")
+	}
+	ck.abandoned, ck.stale = false, false
+	ck.changes = &changeLog{from: ck.version + 1}
 	log.Infof("Server %v : Apply done", cs.address)
 	return nil
 }
 
+// RPCCloneChunk is called by master to clone a chunk of this server into a
+// new one, at the version master has of it. The chunk is read locked while
+// it is read, the clone is applied as a copy. The clone is deleted if it
+// fails.
+func (cs *ChunkServer) RPCCloneChunk(args gfs.CloneChunkArg, reply *gfs.CloneChunkReply) (err error) {
+	if err := cs.secret.CheckServer(args.Token); err != nil {
+		return err
+	}
+	cs.lock.RLock()
+	ck, ok := cs.chunk[args.Handle]
+	cs.lock.RUnlock()
+	if !ok {
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v does not exist", args.Handle)}
+	}
+	ck.RLock()
+	chunkSize := ck.chunkSize
+	ck.RUnlock()
+	if err := cs.RPCCreateChunk(gfs.CreateChunkArg{args.Clone, chunkSize, true, args.Token}, &gfs.CreateChunkReply{}); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			cs.deleteChunk(args.Clone)
+		}
+	}()
+
+	ck.RLock()
+	if ck.abandoned || ck.version != args.Version {
+		ck.RUnlock()
+		return gfs.Error{gfs.StaleVersion, fmt.Sprintf("Chunk %v is not at version %v to clone", args.Handle, args.Version)}
+	}
+	data := make([]byte, ck.length)
+	if len(data) > 0 {
+		_, err = cs.readChunk(args.Handle, 0, data)
+	}
+	ck.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Server %v : Clone %v into %v", cs.address, args.Handle, args.Clone)
+	reply.Length = gfs.Offset(len(data))
+	return cs.RPCApplyCopy(gfs.ApplyCopyArg{args.Clone, data, 0, args.Version, chunkSize, true, args.Token}, &gfs.ApplyCopyReply{})
+}
+
+// SetCopyLimits sets the pieces chunks are copied in by this server, 0 for
+// gfs.CopyPieceBytes, and the bytes per second the copies are paced at, 0
+// means unlimited.
+func (cs *ChunkServer) SetCopyLimits(pieceBytes int, bytesPerSec float64) {
+	if pieceBytes <= 0 {
+		pieceBytes = gfs.CopyPieceBytes
+	}
+	atomic.StoreInt64(&cs.copyPiece, int64(pieceBytes))
+	cs.copyRate.SetRate(bytesPerSec)
+}
+
+// RPCSetCopyLimits is called by an admin to set the copy limits of this server
+func (cs *ChunkServer) RPCSetCopyLimits(args gfs.SetCopyLimitsArg, reply *gfs.SetCopyLimitsReply) error {
+	cred := args.Cred
+	if cs.secret.Enabled() {
+		c, err := cs.secret.Client(cred.Token)
+		if err != nil {
+			return err
+		}
+		cred.User, cred.Groups = c.User, c.Groups
+	}
+	if !cred.IsSuperUser() {
+		return gfs.Error{gfs.PermissionDenied, fmt.Sprintf("user %v may not set the copy limits", cred.Owner())}
+	}
+	if args.PieceBytes < 0 || args.BytesPerSec < 0 {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("negative copy limits %v", args)}
+	}
+	cs.SetCopyLimits(args.PieceBytes, args.BytesPerSec)
+	return nil
+}
+
 // writeChunk writes data at offset to a chunk at disk
 func (cs *ChunkServer) writeChunk(handle gfs.ChunkHandle, data []byte, offset gfs.Offset, lock bool) error {
 	cs.lock.RLock()
@@ -595,47 +1410,118 @@ func (cs *ChunkServer) writeChunk(handle gfs.ChunkHandle, data []byte, offset gf
 	}
 
 	log.Infof("Server %v : write to chunk %v at %v len %v", cs.address, handle, offset, len(data))
-	filename := path.Join(cs.rootDir, fmt.Sprintf("chunk%v.chk", handle))
-	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, FilePerm)
+	if ck.sealed {
+		return cs.mutateSealed(handle, ck, data, offset, false, false)
+	}
+	file, err := cs.files.get(handle, ck.dir.chunkFilename(handle), true)
 	if err != nil {
+		cs.checkDir(ck.dir, err)
 		return err
 	}
-	defer file.Close()
-
-	_, err = file.WriteAt(data, int64(offset))
+	defer cs.files.put(file)
+
+	n, err := file.WriteAt(data, int64(offset))
+	cs.metrics.writtenBytes.Add(float64(n))
+	atomic.AddInt64(&cs.ioBytes, int64(n))
+	if end := offset + gfs.Offset(n); end > ck.size {
+		atomic.AddInt64(&cs.diskUsed, int64(end-ck.size))
+		ck.size = end
+	}
 	if err != nil {
+		cs.checkDir(ck.dir, err)
 		return err
 	}
 
 	return nil
 }
 
+// truncateChunk cuts a chunk at disk to length
+func (cs *ChunkServer) truncateChunk(handle gfs.ChunkHandle, length gfs.Offset) error {
+	cs.lock.RLock()
+	ck := cs.chunk[handle]
+	cs.lock.RUnlock()
+
+	// ck is already locked in top caller
+	log.Infof("Server %v : truncate chunk %v to %v", cs.address, handle, length)
+	if err := os.Truncate(ck.dir.chunkFilename(handle), int64(length)); err != nil {
+		cs.checkDir(ck.dir, err)
+		return err
+	}
+	ck.length = length
+	if ck.size > length {
+		atomic.AddInt64(&cs.diskUsed, -int64(ck.size-length))
+		ck.size = length
+	}
+	return nil
+}
+
+// cutChunk cuts a chunk at disk to length, without journaling it. ck should
+// be locked.
+func (cs *ChunkServer) cutChunk(handle gfs.ChunkHandle, ck *chunkInfo, length gfs.Offset) error {
+	if ck.sealed {
+		return cs.mutateSealed(handle, ck, nil, length, true, false)
+	}
+	return cs.truncateChunk(handle, length)
+}
+
 // readChunk reads data at offset from a chunk at dist
 func (cs *ChunkServer) readChunk(handle gfs.ChunkHandle, offset gfs.Offset, data []byte) (int, error) {
-	filename := path.Join(cs.rootDir, fmt.Sprintf("chunk%v.chk", handle))
-
-	f, err := os.Open(filename)
+	cs.lock.RLock()
+	ck, ok := cs.chunk[handle]
+	cs.lock.RUnlock()
+	if !ok {
+		return -1, gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v does not exist", handle)}
+	}
+	f, err := cs.files.get(handle, ck.dir.chunkFilename(handle), false)
 	if err != nil {
+		cs.checkDir(ck.dir, err)
 		return -1, err
 	}
-	defer f.Close()
+	defer cs.files.put(f)
 
 	log.Infof("Server %v : read chunk %v at %v len %v", cs.address, handle, offset, len(data))
-	return f.ReadAt(data, int64(offset))
+	var n int
+	if ck.sealed {
+		var s *sealed
+		if s, err = cs.openSealed(handle, ck, f); err == nil {
+			n, err = s.readAt(data, offset)
+		}
+	} else {
+		n, err = f.ReadAt(data, int64(offset))
+	}
+	cs.metrics.readBytes.Add(float64(n))
+	atomic.AddInt64(&cs.ioBytes, int64(n))
+	cs.checkDir(ck.dir, err)
+	return n, err
 }
 
 // deleteChunk deletes a chunk during garbage collection
 func (cs *ChunkServer) deleteChunk(handle gfs.ChunkHandle) error {
+	cs.journal.RLock()
+	defer cs.journal.RUnlock()
+	if err := cs.journal.append(journalRecord{journalDelete, handle, 0, 0, 0, nil}); err != nil {
+		return err
+	}
+
 	cs.lock.Lock()
+	ck, ok := cs.chunk[handle]
 	delete(cs.chunk, handle)
 	cs.lock.Unlock()
 
-	filename := path.Join(cs.rootDir, fmt.Sprintf("chunk%v.chk", handle))
-	err := os.Remove(filename)
+	if !ok {
+		return nil
+	}
+	ck.RLock()
+	atomic.AddInt64(&cs.diskUsed, -int64(ck.size))
+	ck.RUnlock()
+
+	cs.files.invalidate(handle)
+	os.Remove(ck.dir.versionFilename(handle))
+	err := os.Remove(ck.dir.chunkFilename(handle))
 	return err
 }
 
-// apply mutations (write, append, pad) in chunk buffer in proper order according to version number
+// apply mutations (write, append, pad, truncate) in chunk buffer in proper order according to version number
 func (cs *ChunkServer) doMutation(handle gfs.ChunkHandle, m *Mutation) error {
 	// already locked
 	var lock bool
@@ -645,22 +1531,52 @@ func (cs *ChunkServer) doMutation(handle gfs.ChunkHandle, m *Mutation) error {
 		lock = false
 	}
 
-	var err error
+	cs.lock.RLock()
+	ck := cs.chunk[handle]
+	cs.lock.RUnlock()
+
+	ck.mutations++
+	atomic.AddInt64(&cs.mutationCount, 1)
+	data, offset := m.data, m.offset
 	if m.mtype == gfs.MutationPad {
-		data := []byte{0}
-		err = cs.writeChunk(handle, data, gfs.MaxChunkSize-1, lock)
+		data, offset = []byte{0}, ck.chunkSize-1
+	}
+	if m.mtype == gfs.MutationTruncate && offset >= ck.length {
+		return nil
+	}
+	length := ck.length
+
+	// journaled before it is applied, and acknowledged once the journal is durable
+	cs.journal.RLock()
+	var err error
+	if ck.sealed {
+		err = cs.mutateSealed(handle, ck, data, offset, m.mtype == gfs.MutationTruncate, true)
+	} else if m.mtype == gfs.MutationTruncate {
+		err = cs.journal.append(journalRecord{journalTruncate, handle, ck.version, ck.chunkSize, offset, nil})
+		if err == nil {
+			err = cs.truncateChunk(handle, offset)
+		}
 	} else {
-		err = cs.writeChunk(handle, m.data, m.offset, lock)
+		err = cs.journal.append(journalRecord{journalWrite, handle, ck.version, ck.chunkSize, offset, data})
+		if err == nil {
+			err = cs.writeChunk(handle, data, offset, lock)
+		}
 	}
+	cs.journal.RUnlock()
+	cs.checkpointIfFull()
 
 	if err != nil {
-		cs.lock.RLock()
-		ck := cs.chunk[handle]
-		cs.lock.RUnlock()
 		log.Warningf("%v abandon chunk %v", cs.address, handle)
 		ck.abandoned = true
 		return err
 	}
+	if ck.changes != nil {
+		if m.mtype == gfs.MutationTruncate {
+			ck.changes.record(ck.version, offset, length-offset)
+		} else {
+			ck.changes.record(ck.version, offset, gfs.Offset(len(data)))
+		}
+	}
 
 	return nil
 }
@@ -674,43 +1590,3 @@ func (cs *ChunkServer) padChunk(handle gfs.ChunkHandle, version gfs.ChunkVersion
 	//ck.length = gfs.MaxChunkSize
 	return nil
 }
-
-// =================== DEBUG TOOLS ===================
-func getContents(filename string) (string, error) {
-	f, err := os.Open(filename)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close() // f.Close will run when we're finished.
-
-	var result []byte
-	buf := make([]byte, 100)
-	for {
-		n, err := f.Read(buf[0:])
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return "", err // f will be closed if we return here.
-		}
-		result = append(result, buf[0:n]...) // append is discussed later.
-	}
-	return string(result), nil // f will be closed if we return here.
-}
-
-func (cs *ChunkServer) PrintSelf(no1 gfs.Nouse, no2 *gfs.Nouse) error {
-	cs.lock.RLock()
-	cs.lock.RUnlock()
-	log.Info("============ ", cs.address, " ============")
-	if cs.dead {
-		log.Warning("DEAD")
-	} else {
-		for h, v := range cs.chunk {
-			filename := path.Join(cs.rootDir, fmt.Sprintf("chunk%v.chk", h))
-			log.Infof("chunk %v : version %v", h, v.version)
-			str, _ := getContents(filename)
-			log.Info(str)
-		}
-	}
-	return nil
-}
@@ -0,0 +1,86 @@
+package chunkserver
+
+import (
+	"sync"
+	"time"
+
+	"gfs"
+)
+
+type dedupKey struct {
+	handle gfs.ChunkHandle
+	id     gfs.RequestID
+}
+
+type dedupResult struct {
+	offset gfs.Offset
+	code   gfs.ErrorCode
+	expire time.Time
+}
+
+// dedupTable remembers the results of the mutations applied as primary by
+// the request IDs of clients, so that a client retrying a mutation which was
+// applied, e.g. after its rpc timed out, gets the result of the first attempt
+// instead of applying it twice. Results are kept for gfs.DedupWindow.
+type dedupTable struct {
+	sync.Mutex
+	results map[dedupKey]dedupResult
+	expire  time.Duration
+}
+
+// newDedupTable returns a dedupTable keeping results for expire.
+// The dedupTable will cleanup expired results every tick.
+func newDedupTable(expire, tick time.Duration) *dedupTable {
+	t := &dedupTable{
+		results: make(map[dedupKey]dedupResult),
+		expire:  expire,
+	}
+
+	// cleanup
+	go func() {
+		ticker := time.Tick(tick)
+		for {
+			<-ticker
+			now := time.Now()
+			t.Lock()
+			for k, v := range t.results {
+				if v.expire.Before(now) {
+					delete(t.results, k)
+				}
+			}
+			t.Unlock()
+		}
+	}()
+
+	return t
+}
+
+// get returns the result of the mutation id of a chunk, if it was applied.
+func (t *dedupTable) get(handle gfs.ChunkHandle, id gfs.RequestID) (dedupResult, bool) {
+	if id.Client == "" {
+		return dedupResult{}, false
+	}
+	t.Lock()
+	defer t.Unlock()
+	r, ok := t.results[dedupKey{handle, id}]
+	if ok && r.expire.Before(time.Now()) {
+		return dedupResult{}, false
+	}
+	return r, ok
+}
+
+// put records the result of the mutation id of a chunk.
+func (t *dedupTable) put(handle gfs.ChunkHandle, id gfs.RequestID, offset gfs.Offset, code gfs.ErrorCode) {
+	if id.Client == "" {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.results[dedupKey{handle, id}] = dedupResult{offset, code, time.Now().Add(t.expire)}
+}
+
+func (t *dedupTable) len() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.results)
+}
@@ -1,7 +1,11 @@
 package chunkserver
 
 import (
+	"crypto/cipher"
+	"crypto/rand"
 	"fmt"
+	"os"
+	"path"
 	"sync"
 	"time"
 
@@ -11,22 +15,43 @@ import (
 type downloadItem struct {
 	data   []byte
 	expire time.Time
+	client string      // the data is accounted to
+	file   string      // the data is spilled to, data is nil then
+	size   int64       // of the spilled data
+	seal   cipher.AEAD // the spill file is sealed with, if any
 }
 
+// downloadBuffer holds the data pushed by clients until it is mutated. It
+// holds at most max bytes in memory, and at most clientMax bytes of a single
+// client, so that a burst of clients cannot exhaust memory and one client
+// cannot take the whole budget. Data beyond is spilled to files in spillDir,
+// up to spillMax bytes, and read back when it is fetched for a mutation.
+// Data beyond that is rejected with gfs.ServerBusy.
 type downloadBuffer struct {
 	sync.RWMutex
-	buffer map[gfs.DataBufferID]downloadItem
-	expire time.Duration
-	tick   time.Duration
+	buffer    map[gfs.DataBufferID]downloadItem
+	expire    time.Duration
+	tick      time.Duration
+	max       int64            // bytes held at most, 0 means unlimited
+	clientMax int64            // bytes held for a client at most, 0 means unlimited
+	bytes     int64            // bytes held
+	clients   map[string]int64 // bytes held by client
+	spillDir  string           // empty if data is never spilled
+	spillMax  int64            // bytes spilled at most
+	spilled   int64            // bytes spilled, including the ones being written
+	seal      cipher.AEAD      // spill files are sealed with, nil leaves them in the clear
 }
 
 // newDownloadBuffer returns a downloadBuffer. Default expire time is expire.
 // The downloadBuffer will cleanup expired items every tick.
-func newDownloadBuffer(expire, tick time.Duration) *downloadBuffer {
+func newDownloadBuffer(expire, tick time.Duration, max, clientMax int64) *downloadBuffer {
 	buf := &downloadBuffer{
-		buffer: make(map[gfs.DataBufferID]downloadItem),
-		expire: expire,
-		tick:   tick,
+		buffer:    make(map[gfs.DataBufferID]downloadItem),
+		expire:    expire,
+		tick:      tick,
+		max:       max,
+		clientMax: clientMax,
+		clients:   make(map[string]int64),
 	}
 
 	// cleanup
@@ -38,7 +63,7 @@ func newDownloadBuffer(expire, tick time.Duration) *downloadBuffer {
 			buf.Lock()
 			for id, item := range buf.buffer {
 				if item.expire.Before(now) {
-					delete(buf.buffer, id)
+					buf.remove(id, item)
 				}
 			}
 			buf.Unlock()
@@ -55,12 +80,107 @@ func NewDataID(handle gfs.ChunkHandle) gfs.DataBufferID {
 	return gfs.DataBufferID{handle, timeStamp}
 }
 
-func (buf *downloadBuffer) Set(id gfs.DataBufferID, data []byte) {
+// Set stores the data of client under id. Data that does not fit in the
+// budget of the buffer or of the client is spilled, it fails with
+// gfs.ServerBusy if it does not fit in the spill budget either.
+func (buf *downloadBuffer) Set(id gfs.DataBufferID, data []byte, client string) error {
 	buf.Lock()
 	defer buf.Unlock()
-	buf.buffer[id] = downloadItem{data, time.Now().Add(buf.expire)}
+	if old, ok := buf.buffer[id]; ok {
+		buf.remove(id, old)
+	}
+
+	n := int64(len(data))
+	if (buf.max <= 0 || buf.bytes+n <= buf.max) && (buf.clientMax <= 0 || buf.clients[client]+n <= buf.clientMax) {
+		buf.buffer[id] = downloadItem{data: data, expire: time.Now().Add(buf.expire), client: client}
+		buf.bytes += n
+		buf.clients[client] += n
+		return nil
+	}
+	if buf.spillDir == "" || buf.spilled+n > buf.spillMax {
+		if buf.max > 0 && buf.bytes+n > buf.max {
+			return gfs.Error{gfs.ServerBusy, fmt.Sprintf("download buffer is full, %v of %v bytes held", buf.bytes, buf.max)}
+		}
+		return gfs.Error{gfs.ServerBusy, fmt.Sprintf("download buffer holds %v of %v bytes for client %v", buf.clients[client], buf.clientMax, client)}
+	}
+
+	// reserve the spill budget, don't hold the buffer while writing
+	buf.spilled += n
+	filename := path.Join(buf.spillDir, fmt.Sprintf("%v-%v.dl", id.Handle, id.TimeStamp))
+	seal := buf.seal
+	buf.Unlock()
+	spill := data
+	if seal != nil {
+		nonce := make([]byte, seal.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			panic(err)
+		}
+		spill = seal.Seal(nonce, nonce, data, nil)
+	}
+	err := os.WriteFile(filename, spill, FilePerm)
+	buf.Lock()
+	if err != nil {
+		os.Remove(filename)
+		buf.spilled -= n
+		return err
+	}
+	if old, ok := buf.buffer[id]; ok {
+		buf.remove(id, old)
+	}
+	buf.buffer[id] = downloadItem{expire: time.Now().Add(buf.expire), client: client, file: filename, size: n, seal: seal}
+	return nil
+}
+
+// remove deletes an item and releases its bytes, buf must be locked.
+func (buf *downloadBuffer) remove(id gfs.DataBufferID, item downloadItem) {
+	delete(buf.buffer, id)
+	if item.file != "" {
+		os.Remove(item.file)
+		buf.spilled -= item.size
+		return
+	}
+	n := int64(len(item.data))
+	buf.bytes -= n
+	if buf.clients[item.client] -= n; buf.clients[item.client] <= 0 {
+		delete(buf.clients, item.client)
+	}
+}
+
+// setMax sets the budgets of the buffer and of each client, 0 means unlimited.
+// Data held beyond is kept until it is fetched or expires.
+func (buf *downloadBuffer) setMax(max, clientMax int64) {
+	buf.Lock()
+	defer buf.Unlock()
+	buf.max, buf.clientMax = max, clientMax
+}
+
+// setExpire sets how long the data pushed afterwards is held unless fetched.
+func (buf *downloadBuffer) setExpire(expire time.Duration) {
+	buf.Lock()
+	defer buf.Unlock()
+	buf.expire = expire
+}
+
+// setSpill lets data beyond the budgets be spilled to files in dir, up to
+// max bytes. An empty dir or a max of 0 disables spilling.
+func (buf *downloadBuffer) setSpill(dir string, max int64) {
+	buf.Lock()
+	defer buf.Unlock()
+	if max <= 0 {
+		dir = ""
+	}
+	buf.spillDir, buf.spillMax = dir, max
+}
+
+// setSealer seals the data spilled from now on with aead, nil spills it in
+// the clear.
+func (buf *downloadBuffer) setSealer(aead cipher.AEAD) {
+	buf.Lock()
+	defer buf.Unlock()
+	buf.seal = aead
 }
 
+// Get returns whether id is held, and its data unless it is spilled.
 func (buf *downloadBuffer) Get(id gfs.DataBufferID) ([]byte, bool) {
 	buf.Lock()
 	defer buf.Unlock()
@@ -69,24 +189,57 @@ func (buf *downloadBuffer) Get(id gfs.DataBufferID) ([]byte, bool) {
 		return nil, ok
 	}
 	item.expire = time.Now().Add(buf.expire) // touch
+	buf.buffer[id] = item
 	return item.data, ok
 }
 
+// Fetch removes id from the buffer and returns its data, read back from
+// the spill file if it is spilled.
 func (buf *downloadBuffer) Fetch(id gfs.DataBufferID) ([]byte, error) {
 	buf.Lock()
-	defer buf.Unlock()
-
 	item, ok := buf.buffer[id]
 	if !ok {
-		return nil, fmt.Errorf("DataID %v not found in download buffer.", id)
+		buf.Unlock()
+		return nil, gfs.Error{gfs.DataNotFound, fmt.Sprintf("DataID %v not found in download buffer.", id)}
+	}
+	if item.file == "" {
+		buf.remove(id, item)
+		buf.Unlock()
+		return item.data, nil
 	}
 
+	// keep the spill budget until the file is read and removed
 	delete(buf.buffer, id)
-	return item.data, nil
+	buf.Unlock()
+	data, err := os.ReadFile(item.file)
+	os.Remove(item.file)
+	buf.Lock()
+	buf.spilled -= item.size
+	buf.Unlock()
+	if err == nil && item.seal != nil {
+		if size := item.seal.NonceSize(); len(data) < size {
+			err = fmt.Errorf("spill file of %v bytes is torn", len(data))
+		} else {
+			data, err = item.seal.Open(nil, data[:size], data[size:], nil)
+		}
+	}
+	if err != nil {
+		return nil, gfs.Error{gfs.DataNotFound, fmt.Sprintf("DataID %v spilled but cannot be read: %v", id, err)}
+	}
+	return data, nil
+}
+
+// Stats returns the number of items, the bytes held in memory and the bytes spilled.
+func (buf *downloadBuffer) Stats() (items int, bytes, spilled int64) {
+	buf.RLock()
+	defer buf.RUnlock()
+	return len(buf.buffer), buf.bytes, buf.spilled
 }
 
 func (buf *downloadBuffer) Delete(id gfs.DataBufferID) {
 	buf.Lock()
 	defer buf.Unlock()
-	delete(buf.buffer, id)
+	if item, ok := buf.buffer[id]; ok {
+		buf.remove(id, item)
+	}
 }
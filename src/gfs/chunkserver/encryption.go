@@ -0,0 +1,401 @@
+package chunkserver
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"gfs"
+)
+
+// Chunks created while a key provider is set are sealed: their files are
+// blocks of sealBlockSize bytes of data, each encrypted with AES-GCM under the
+// key of the chunk, behind a random nonce and followed by the tag. The handle
+// and the index of a block are authenticated with it, so that blocks cannot
+// be swapped. A block of zeros only, a hole left by a write past the end, is
+// read as zeros. Mutations of sealed chunks are journaled as the blocks they
+// rewrite, already sealed, so that neither the journal leaks data nor its
+// replay needs the keys.
+
+const (
+	sealBlockSize = 4 << 10 // bytes of data in a sealed block
+	sealNonceSize = 12
+	sealOverhead  = sealNonceSize + 16 // nonce and tag of a block
+	sealedBlock   = sealBlockSize + sealOverhead
+)
+
+// KeyProvider supplies the AES keys chunks are sealed with, of 16, 24 or 32
+// bytes. The key of a chunk should not change as long as the chunk lives.
+type KeyProvider interface {
+	ChunkKey(handle gfs.ChunkHandle) ([]byte, error)
+}
+
+// derivedKeys derives the key of each chunk from a master key by HMAC-SHA256.
+type derivedKeys []byte
+
+func (k derivedKeys) ChunkKey(handle gfs.ChunkHandle) ([]byte, error) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(handle))
+	h := hmac.New(sha256.New, k)
+	h.Write(b[:])
+	return h.Sum(nil)[:len(k)], nil
+}
+
+// StaticKeys returns a KeyProvider deriving the keys of chunks from key.
+func StaticKeys(key []byte) (KeyProvider, error) {
+	switch len(key) {
+	case 16, 24, 32:
+		return derivedKeys(append([]byte(nil), key...)), nil
+	}
+	return nil, fmt.Errorf("key of %v bytes, not 16, 24 or 32", len(key))
+}
+
+// KeysFromFile returns StaticKeys of the key in filename, in hex.
+func KeysFromFile(filename string) (KeyProvider, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil {
+		return nil, fmt.Errorf("key file %v: %v", filename, err)
+	}
+	return StaticKeys(key)
+}
+
+// KeysFromEnv returns StaticKeys of the key in the environment variable name, in hex.
+func KeysFromEnv(name string) (KeyProvider, error) {
+	key, err := hex.DecodeString(os.Getenv(name))
+	if err != nil {
+		return nil, fmt.Errorf("key in $%v: %v", name, err)
+	}
+	return StaticKeys(key)
+}
+
+// commandKeys asks an external program, e.g. the client of a KMS, for the
+// key of each chunk. Keys are cached.
+type commandKeys struct {
+	sync.Mutex
+	name string
+	args []string
+	keys map[gfs.ChunkHandle][]byte
+}
+
+// CommandKeys returns a KeyProvider running name with args and the handle of
+// a chunk, which prints the key of the chunk in hex.
+func CommandKeys(name string, args ...string) KeyProvider {
+	return &commandKeys{name: name, args: args, keys: make(map[gfs.ChunkHandle][]byte)}
+}
+
+func (k *commandKeys) ChunkKey(handle gfs.ChunkHandle) ([]byte, error) {
+	k.Lock()
+	defer k.Unlock()
+	if key, ok := k.keys[handle]; ok {
+		return key, nil
+	}
+	out, err := exec.Command(k.name, append(k.args, strconv.FormatInt(int64(handle), 10))...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("key command %v for chunk %v: %v", k.name, handle, err)
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(out)))
+	if err != nil {
+		return nil, fmt.Errorf("key command %v for chunk %v: %v", k.name, handle, err)
+	}
+	k.keys[handle] = key
+	return key, nil
+}
+
+// SetKeyProvider seals the chunks created from now on with the keys of kp.
+// The chunks sealed before are read and written with kp too, the others stay
+// in the clear. nil stops sealing new chunks, the sealed ones cannot be read
+// until a provider is set again. Data spilled by the download buffer is
+// sealed with a key of the process meanwhile.
+func (cs *ChunkServer) SetKeyProvider(kp KeyProvider) {
+	var spill cipher.AEAD
+	if kp != nil {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+		spill, _ = newGCM(key)
+	}
+	cs.lock.Lock()
+	cs.keys = kp
+	cs.lock.Unlock()
+	cs.dl.setSealer(spill)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealer returns the cipher of a sealed chunk.
+func (cs *ChunkServer) sealer(handle gfs.ChunkHandle) (cipher.AEAD, error) {
+	cs.lock.RLock()
+	kp := cs.keys
+	cs.lock.RUnlock()
+	if kp == nil {
+		return nil, gfs.Error{gfs.UnknownError, fmt.Sprintf("chunk %v is sealed but %v has no key provider", handle, cs.address)}
+	}
+	key, err := kp.ChunkKey(handle)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
+
+// resetData returns the data of the journalReset record of a chunk, which
+// says whether it is sealed.
+func resetData(sealed bool) []byte {
+	if sealed {
+		return []byte{1}
+	}
+	return nil
+}
+
+// sealedSize returns the size of the file of a sealed chunk of length bytes.
+func sealedSize(length gfs.Offset) int64 {
+	n := int64(length) / sealBlockSize * sealedBlock
+	if r := int64(length) % sealBlockSize; r > 0 {
+		n += r + sealOverhead
+	}
+	return n
+}
+
+// plainSize returns the bytes of data in the file of a sealed chunk of size.
+func plainSize(size int64) gfs.Offset {
+	n := size / sealedBlock * sealBlockSize
+	if r := size % sealedBlock; r > sealOverhead {
+		n += r - sealOverhead
+	}
+	return gfs.Offset(n)
+}
+
+// blockAD returns the data authenticated with block index of a chunk.
+func blockAD(handle gfs.ChunkHandle, index int64) []byte {
+	var ad [16]byte
+	binary.LittleEndian.PutUint64(ad[:], uint64(handle))
+	binary.LittleEndian.PutUint64(ad[8:], uint64(index))
+	return ad[:]
+}
+
+// sealBlock returns block index of a chunk holding data, sealed.
+func sealBlock(aead cipher.AEAD, handle gfs.ChunkHandle, index int64, data []byte) []byte {
+	buf := make([]byte, sealNonceSize, sealOverhead+len(data))
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return aead.Seal(buf, buf, data, blockAD(handle, index))
+}
+
+// sealed is a chunk file being read and rewritten in sealed blocks.
+type sealed struct {
+	aead   cipher.AEAD
+	handle gfs.ChunkHandle
+	file   io.ReaderAt
+	size   int64 // of the file
+}
+
+// block returns the data of block index, up to the end of the file.
+func (s *sealed) block(index int64) ([]byte, error) {
+	start := index * sealedBlock
+	if start >= s.size {
+		return nil, nil
+	}
+	buf := make([]byte, sealedBlock)
+	if start+sealedBlock > s.size {
+		buf = buf[:s.size-start]
+	}
+	if _, err := s.file.ReadAt(buf, start); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(buf) < sealOverhead {
+		return nil, gfs.Error{gfs.ChecksumMismatch, fmt.Sprintf("block %v of chunk %v is torn", index, s.handle)}
+	}
+	if bytes.Count(buf, []byte{0}) == len(buf) {
+		return make([]byte, len(buf)-sealOverhead), nil // a hole
+	}
+	data, err := s.aead.Open(nil, buf[:sealNonceSize], buf[sealNonceSize:], blockAD(s.handle, index))
+	if err != nil {
+		return nil, gfs.Error{gfs.ChecksumMismatch, fmt.Sprintf("block %v of chunk %v cannot be opened: %v", index, s.handle, err)}
+	}
+	return data, nil
+}
+
+// readAt reads data at offset as os.File.ReadAt does, io.EOF is returned
+// if the chunk ends before data is filled.
+func (s *sealed) readAt(data []byte, offset gfs.Offset) (int, error) {
+	length := plainSize(s.size)
+	n := 0
+	for n < len(data) {
+		at := offset + gfs.Offset(n)
+		if at >= length {
+			return n, io.EOF
+		}
+		index := int64(at) / sealBlockSize
+		block, err := s.block(index)
+		if err != nil {
+			return n, err
+		}
+		n += copy(data[n:], block[int64(at)-index*sealBlockSize:])
+	}
+	return n, nil
+}
+
+// extent is bytes to be written to a chunk file at offset.
+type extent struct {
+	offset int64
+	data   []byte
+}
+
+// write returns the blocks to be rewritten to put data at offset, the last
+// block of the file first if it has to be filled up.
+func (s *sealed) write(data []byte, offset gfs.Offset) ([]extent, error) {
+	length := plainSize(s.size)
+	end := offset + gfs.Offset(len(data))
+	if end < length {
+		end = length
+	}
+	first, last := int64(offset)/sealBlockSize, (int64(offset)+int64(len(data))-1)/sealBlockSize
+	var ret []extent
+	if tail := int64(length) / sealBlockSize; int64(length)%sealBlockSize != 0 && tail < first {
+		block, err := s.block(tail)
+		if err != nil {
+			return nil, err
+		}
+		full := make([]byte, sealBlockSize)
+		copy(full, block)
+		ret = append(ret, extent{tail * sealedBlock, sealBlock(s.aead, s.handle, tail, full)})
+	}
+	for i := first; i <= last; i++ {
+		start := gfs.Offset(i * sealBlockSize)
+		size := end - start
+		if size > sealBlockSize {
+			size = sealBlockSize
+		}
+		buf := make([]byte, size)
+		if start < length {
+			block, err := s.block(i)
+			if err != nil {
+				return nil, err
+			}
+			copy(buf, block)
+		}
+		if start < offset {
+			copy(buf[offset-start:], data)
+		} else {
+			copy(buf, data[start-offset:])
+		}
+		ret = append(ret, extent{i * sealedBlock, sealBlock(s.aead, s.handle, i, buf)})
+	}
+	return ret, nil
+}
+
+// truncate returns the last block to be rewritten, if any, and the size the
+// file is cut to, to cut the chunk to length.
+func (s *sealed) truncate(length gfs.Offset) ([]extent, int64, error) {
+	index, r := int64(length)/sealBlockSize, int64(length)%sealBlockSize
+	if r == 0 || index*sealedBlock >= s.size {
+		return nil, sealedSize(length), nil
+	}
+	block, err := s.block(index)
+	if err != nil {
+		return nil, 0, err
+	}
+	buf := make([]byte, r)
+	copy(buf, block)
+	return []extent{{index * sealedBlock, sealBlock(s.aead, s.handle, index, buf)}}, sealedSize(length), nil
+}
+
+// openSealed returns the sealed file of a chunk, ck should be locked.
+func (cs *ChunkServer) openSealed(handle gfs.ChunkHandle, ck *chunkInfo, file io.ReaderAt) (*sealed, error) {
+	aead, err := cs.sealer(handle)
+	if err != nil {
+		return nil, err
+	}
+	return &sealed{aead, handle, file, int64(ck.size)}, nil
+}
+
+// mutateSealed writes data at offset to a sealed chunk, or cuts it to offset
+// if cut is set. The blocks rewritten are journaled if journal is set, the
+// journal should be locked shared then. ck should be locked.
+func (cs *ChunkServer) mutateSealed(handle gfs.ChunkHandle, ck *chunkInfo, data []byte, offset gfs.Offset, cut, journal bool) error {
+	file, err := cs.files.get(handle, ck.dir.chunkFilename(handle), true)
+	if err != nil {
+		cs.checkDir(ck.dir, err)
+		return err
+	}
+	defer cs.files.put(file)
+	s, err := cs.openSealed(handle, ck, file)
+	if err != nil {
+		return err
+	}
+
+	var extents []extent
+	size := s.size
+	if cut {
+		extents, size, err = s.truncate(offset)
+	} else {
+		extents, err = s.write(data, offset)
+	}
+	if err != nil {
+		return err
+	}
+	if journal {
+		for _, e := range extents {
+			if err := cs.journal.append(journalRecord{journalSealedWrite, handle, ck.version, ck.chunkSize, gfs.Offset(e.offset), e.data}); err != nil {
+				return err
+			}
+		}
+		if cut {
+			if err := cs.journal.append(journalRecord{journalSealedTruncate, handle, ck.version, ck.chunkSize, gfs.Offset(size), nil}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, e := range extents {
+		n, err := file.WriteAt(e.data, e.offset)
+		atomic.AddInt64(&cs.ioBytes, int64(n))
+		if end := e.offset + int64(n); end > size {
+			size = end
+		}
+		if err != nil {
+			cs.checkDir(ck.dir, err)
+			return err
+		}
+	}
+	if cut {
+		if err := file.Truncate(size); err != nil {
+			cs.checkDir(ck.dir, err)
+			return err
+		}
+		if offset < ck.length {
+			ck.length = offset
+		}
+	} else {
+		cs.metrics.writtenBytes.Add(float64(len(data)))
+		if end := offset + gfs.Offset(len(data)); end > ck.length {
+			ck.length = end
+		}
+	}
+	atomic.AddInt64(&cs.diskUsed, size-int64(ck.size))
+	ck.size = gfs.Offset(size)
+	return nil
+}
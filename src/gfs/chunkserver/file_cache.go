@@ -0,0 +1,120 @@
+package chunkserver
+
+import (
+	"container/list"
+	"os"
+	"sync"
+
+	"gfs"
+)
+
+// fileCache is an LRU cache of open chunk files, so that hot chunks are
+// not opened and closed on every read and write. Files in use are reference
+// counted, an evicted or invalidated file is closed when its last user is done.
+type fileCache struct {
+	sync.Mutex
+	max   int
+	lru   *list.List // of *cachedFile, most recently used at front
+	files map[gfs.ChunkHandle]*list.Element
+}
+
+type cachedFile struct {
+	*os.File
+	handle  gfs.ChunkHandle
+	refs    int
+	removed bool // no more in the cache
+}
+
+func newFileCache(max int) *fileCache {
+	return &fileCache{
+		max:   max,
+		lru:   list.New(),
+		files: make(map[gfs.ChunkHandle]*list.Element),
+	}
+}
+
+// get returns the open file of a chunk, opening filename if it is not cached.
+// The file is created if create is set. put should be called when done.
+func (fc *fileCache) get(handle gfs.ChunkHandle, filename string, create bool) (*cachedFile, error) {
+	fc.Lock()
+	defer fc.Unlock()
+
+	if e, ok := fc.files[handle]; ok {
+		fc.lru.MoveToFront(e)
+		f := e.Value.(*cachedFile)
+		f.refs++
+		return f, nil
+	}
+
+	flag := os.O_RDWR
+	if create {
+		flag |= os.O_CREATE
+	}
+	file, err := os.OpenFile(filename, flag, FilePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &cachedFile{File: file, handle: handle, refs: 1}
+	fc.files[handle] = fc.lru.PushFront(f)
+	for fc.lru.Len() > fc.max && fc.lru.Len() > 0 {
+		fc.remove(fc.lru.Back())
+	}
+	return f, nil
+}
+
+// put releases a file returned by get.
+func (fc *fileCache) put(f *cachedFile) {
+	fc.Lock()
+	defer fc.Unlock()
+	f.refs--
+	if f.removed && f.refs == 0 {
+		f.Close()
+	}
+}
+
+// invalidate drops the file of a chunk, it should be called before the
+// chunk file is deleted or truncated.
+func (fc *fileCache) invalidate(handle gfs.ChunkHandle) {
+	fc.Lock()
+	defer fc.Unlock()
+	if e, ok := fc.files[handle]; ok {
+		fc.remove(e)
+	}
+}
+
+// setMax sets the max number of open files.
+func (fc *fileCache) setMax(max int) {
+	fc.Lock()
+	defer fc.Unlock()
+	fc.max = max
+	for fc.lru.Len() > fc.max && fc.lru.Len() > 0 {
+		fc.remove(fc.lru.Back())
+	}
+}
+
+// len returns the number of open files in the cache.
+func (fc *fileCache) len() int {
+	fc.Lock()
+	defer fc.Unlock()
+	return fc.lru.Len()
+}
+
+// closeAll drops all files.
+func (fc *fileCache) closeAll() {
+	fc.Lock()
+	defer fc.Unlock()
+	for fc.lru.Len() > 0 {
+		fc.remove(fc.lru.Back())
+	}
+}
+
+// remove removes e from the cache. fc should be locked.
+func (fc *fileCache) remove(e *list.Element) {
+	f := fc.lru.Remove(e).(*cachedFile)
+	delete(fc.files, f.handle)
+	f.removed = true
+	if f.refs == 0 {
+		f.Close()
+	}
+}
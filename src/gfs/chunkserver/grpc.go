@@ -0,0 +1,74 @@
+package chunkserver
+
+import (
+	"context"
+
+	"gfs"
+	"gfs/pb"
+)
+
+// The rpcs of gfs.proto are served by gRPC as well, on the port of the
+// net/rpc ones, for clients in other languages. Each method converts its
+// message to the args of the rpc of the same name and calls it. The data is
+// in the messages, gRPC has no data streams.
+
+// grpcChunkServer serves the ChunkServer service of gfs.proto.
+type grpcChunkServer struct {
+	pb.UnimplementedChunkServerServer
+	cs *ChunkServer
+}
+
+func (g grpcChunkServer) ForwardData(ctx context.Context, args *pb.ForwardDataArg) (*pb.ForwardDataReply, error) {
+	var reply gfs.ForwardDataReply
+	if err := g.cs.RPCForwardData(gfs.ForwardDataArg{args.GetDataId().Value(), args.Data, pb.Addresses(args.ChainOrder), args.Client, args.Trace, args.Token}, &reply); err != nil {
+		return nil, pb.Status(err)
+	}
+	return &pb.ForwardDataReply{ErrorCode: pb.ErrorCode(reply.ErrorCode)}, nil
+}
+
+func (g grpcChunkServer) WriteChunk(ctx context.Context, args *pb.WriteChunkArg) (*pb.WriteChunkReply, error) {
+	var reply gfs.WriteChunkReply
+	if err := g.cs.RPCWriteChunk(gfs.WriteChunkArg{
+		args.GetDataId().Value(),
+		gfs.Offset(args.Offset),
+		pb.Addresses(args.Secondaries),
+		gfs.ChunkVersion(args.Epoch),
+		args.GetRequestId().Value(),
+		args.Token,
+		args.Trace,
+		args.Durable,
+	}, &reply); err != nil {
+		return nil, pb.Status(err)
+	}
+	return &pb.WriteChunkReply{ErrorCode: pb.ErrorCode(reply.ErrorCode)}, nil
+}
+
+func (g grpcChunkServer) AppendChunk(ctx context.Context, args *pb.AppendChunkArg) (*pb.AppendChunkReply, error) {
+	var reply gfs.AppendChunkReply
+	if err := g.cs.RPCAppendChunk(gfs.AppendChunkArg{
+		args.GetDataId().Value(),
+		pb.Addresses(args.Secondaries),
+		gfs.ChunkVersion(args.Epoch),
+		args.GetRequestId().Value(),
+		args.Token,
+		args.Trace,
+		args.Durable,
+	}, &reply); err != nil {
+		return nil, pb.Status(err)
+	}
+	return &pb.AppendChunkReply{Offset: int64(reply.Offset), ErrorCode: pb.ErrorCode(reply.ErrorCode)}, nil
+}
+
+func (g grpcChunkServer) ReadChunk(ctx context.Context, args *pb.ReadChunkArg) (*pb.ReadChunkReply, error) {
+	var reply gfs.ReadChunkReply
+	if err := g.cs.RPCReadChunk(gfs.ReadChunkArg{gfs.ChunkHandle(args.Handle), gfs.Offset(args.Offset), int(args.Length), args.Token, args.Client}, &reply); err != nil {
+		return nil, pb.Status(err)
+	}
+	return &pb.ReadChunkReply{
+		Data:        reply.Data[:reply.Length],
+		Length:      int32(reply.Length),
+		ChunkLength: int64(reply.ChunkLength),
+		Version:     int64(reply.Version),
+		ErrorCode:   pb.ErrorCode(reply.ErrorCode),
+	}, nil
+}
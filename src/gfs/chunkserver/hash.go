@@ -0,0 +1,90 @@
+package chunkserver
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"gfs"
+)
+
+// The hash of a chunk is the SHA-256 of its committed data, computed when a
+// client asks for it and cached with the version, the mutations and the
+// length of the chunk it was computed at, so that the chunk mutated,
+// repaired or copied since is hashed again.
+
+// hashBufferSize is the size of the reads of a chunk being hashed.
+const hashBufferSize = 1 << 20
+
+// chunkHash is the hash of a chunk, valid as long as the chunk is as it was.
+type chunkHash struct {
+	version   gfs.ChunkVersion
+	mutations uint64
+	length    gfs.Offset
+	sum       []byte
+}
+
+// valid returns whether h is the hash of ck. ck should be locked.
+func (h *chunkHash) valid(ck *chunkInfo) bool {
+	return h != nil && h.version == ck.version && h.mutations == ck.mutations && h.length == ck.length
+}
+
+// RPCChunkHash is called by client to get the hash of the committed data of
+// a chunk, computed once until the chunk changes. Computing it is shed first
+// under overload, the cached hash is always returned.
+func (cs *ChunkServer) RPCChunkHash(args gfs.ChunkHashArg, reply *gfs.ChunkHashReply) error {
+	if err := cs.secret.CheckChunk(args.Token, args.Handle, false); err != nil {
+		return err
+	}
+	cs.lock.RLock()
+	ck, ok := cs.chunk[args.Handle]
+	cs.lock.RUnlock()
+	if !ok || ck.abandoned {
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v does not exist or is abandoned", args.Handle)}
+	}
+
+	ck.RLock()
+	if h := ck.hash; !args.Recompute && h.valid(ck) {
+		ck.RUnlock()
+		reply.Version, reply.Length, reply.Sum = h.version, h.length, h.sum
+		return nil
+	}
+	if err := cs.shed(workScrub); err != nil {
+		ck.RUnlock()
+		return err
+	}
+	if err := cs.limit(qosForeground, args.Client, int(ck.length)); err != nil {
+		ck.RUnlock()
+		return err
+	}
+	h := &chunkHash{version: ck.version, mutations: ck.mutations, length: ck.length}
+	sum := sha256.New()
+	buf := make([]byte, hashBufferSize)
+	for offset := gfs.Offset(0); offset < h.length; {
+		n := hashBufferSize
+		if h.length-offset < gfs.Offset(n) {
+			n = int(h.length - offset)
+		}
+		read, err := cs.readChunk(args.Handle, offset, buf[:n])
+		if err == io.EOF && read < n {
+			err = gfs.Error{gfs.PhysicalEOF, fmt.Sprintf("chunk %v ends at %v on disk, committed length %v", args.Handle, offset+gfs.Offset(read), h.length)}
+		}
+		if err != nil && err != io.EOF {
+			ck.RUnlock()
+			return err
+		}
+		sum.Write(buf[:read])
+		offset += gfs.Offset(read)
+	}
+	h.sum = sum.Sum(nil)
+	ck.RUnlock()
+
+	// cached unless the chunk changed meanwhile
+	ck.Lock()
+	if !ck.hash.valid(ck) && h.valid(ck) {
+		ck.hash = h
+	}
+	ck.Unlock()
+	reply.Version, reply.Length, reply.Sum = h.version, h.length, h.sum
+	return nil
+}
@@ -0,0 +1,424 @@
+package chunkserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+
+	"gfs"
+	log "github.com/Sirupsen/logrus"
+)
+
+// kinds of journal records
+const (
+	journalWrite          = iota // data written at offset
+	journalVersion               // version of the chunk changed
+	journalReset                 // chunk created, or replaced by a synced copy of length offset
+	journalDelete                // chunk deleted
+	journalTruncate              // chunk cut to offset
+	journalSealedWrite           // sealed blocks written at offset of the file of a sealed chunk
+	journalSealedTruncate        // file of a sealed chunk cut to offset
+)
+
+const journalHeaderSize = 8 // crc32 and length of the payload
+
+// maxJournalPayload is the longest payload of a record, the fields and a
+// whole chunk, sealed. A longer length is garbage left by a crash.
+const maxJournalPayload = 33 + gfs.MaxChunkSize/sealBlockSize*sealedBlock
+
+type journalRecord struct {
+	kind      byte
+	handle    gfs.ChunkHandle
+	version   gfs.ChunkVersion
+	chunkSize gfs.Offset
+	offset    gfs.Offset
+	data      []byte
+}
+
+// mutationJournal is a write-ahead log of the mutations of chunks. A mutation
+// is journaled before it is applied to the chunk file, and acknowledged once
+// the journal is synced, as the durability mode says. Checkpoints sync the
+// chunk files and the metadata, then truncate the journal.
+type mutationJournal struct {
+	sync.RWMutex // held shared from journaling a mutation to applying it, exclusively by checkpoints
+
+	mu      sync.Mutex // of the fields below
+	synced  *sync.Cond // on mu, broadcast when a sync is done
+	file    *os.File
+	mode    gfs.DurabilityMode
+	size    int64 // bytes in the file
+	written int64 // records written
+	flushed int64 // records synced
+	syncing bool  // a group commit is being synced
+}
+
+// openJournal opens the journal filename and returns the records in it. A
+// torn record at the end, left by a crash, is dropped with the ones behind,
+// as is one whose length is garbage.
+func openJournal(filename string) (*mutationJournal, []journalRecord, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, FilePerm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var records []journalRecord
+	var size int64
+	r := bufio.NewReader(file)
+	for {
+		rec, n, err := readJournalRecord(r)
+		if err != nil {
+			break
+		}
+		records = append(records, rec)
+		size += n
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	j := &mutationJournal{file: file, size: size}
+	j.synced = sync.NewCond(&j.mu)
+	return j, records, nil
+}
+
+func readJournalRecord(r io.Reader) (journalRecord, int64, error) {
+	var rec journalRecord
+	var header [journalHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return rec, 0, err
+	}
+	length := binary.LittleEndian.Uint32(header[4:])
+	if length > maxJournalPayload {
+		return rec, 0, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return rec, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[:4]) || len(payload) < 33 {
+		return rec, 0, io.ErrUnexpectedEOF
+	}
+	rec.kind = payload[0]
+	rec.handle = gfs.ChunkHandle(binary.LittleEndian.Uint64(payload[1:]))
+	rec.version = gfs.ChunkVersion(binary.LittleEndian.Uint64(payload[9:]))
+	rec.chunkSize = gfs.Offset(binary.LittleEndian.Uint64(payload[17:]))
+	rec.offset = gfs.Offset(binary.LittleEndian.Uint64(payload[25:]))
+	rec.data = payload[33:]
+	return rec, int64(journalHeaderSize + len(payload)), nil
+}
+
+func (rec journalRecord) encode() []byte {
+	buf := make([]byte, journalHeaderSize+33+len(rec.data))
+	payload := buf[journalHeaderSize:]
+	payload[0] = rec.kind
+	binary.LittleEndian.PutUint64(payload[1:], uint64(rec.handle))
+	binary.LittleEndian.PutUint64(payload[9:], uint64(rec.version))
+	binary.LittleEndian.PutUint64(payload[17:], uint64(rec.chunkSize))
+	binary.LittleEndian.PutUint64(payload[25:], uint64(rec.offset))
+	copy(payload[33:], rec.data)
+	binary.LittleEndian.PutUint32(buf[:4], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(payload)))
+	return buf
+}
+
+// append writes rec to the journal, and returns once it is durable as the
+// durability mode says. The journal should be locked shared.
+func (j *mutationJournal) append(rec journalRecord) error {
+	buf := rec.encode()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(buf); err != nil {
+		return err
+	}
+	j.size += int64(len(buf))
+	j.written++
+	n := j.written
+
+	switch j.mode {
+	case gfs.DurabilityAsync:
+		return nil
+	case gfs.DurabilitySync:
+		if err := j.file.Sync(); err != nil {
+			return err
+		}
+		j.flushed = n
+		return nil
+	}
+
+	// group commit, the first one waiting syncs the records of all
+	for j.flushed < n {
+		if j.syncing {
+			j.synced.Wait()
+			continue
+		}
+		j.syncing = true
+		target := j.written
+		j.mu.Unlock()
+		err := j.file.Sync()
+		j.mu.Lock()
+		j.syncing = false
+		if err == nil && target > j.flushed {
+			j.flushed = target
+		}
+		j.synced.Broadcast()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sync syncs the records written.
+func (j *mutationJournal) sync() error {
+	j.mu.Lock()
+	target := j.written
+	if target == j.flushed {
+		j.mu.Unlock()
+		return nil
+	}
+	j.mu.Unlock()
+
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if target > j.flushed {
+		j.flushed = target
+	}
+	return nil
+}
+
+// truncate drops all records, the journal should be locked.
+func (j *mutationJournal) truncate() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	j.size = 0
+	j.flushed = j.written
+	return j.file.Sync()
+}
+
+func (j *mutationJournal) setMode(mode gfs.DurabilityMode) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.mode = mode
+}
+
+// full returns whether the journal should be checkpointed.
+func (j *mutationJournal) full() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.size >= gfs.JournalCheckpointBytes
+}
+
+// bytes returns the size of the journal.
+func (j *mutationJournal) bytes() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.size
+}
+
+func (j *mutationJournal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// replayJournal opens the journal of the chunkserver and applies the records
+// in it over the metadata loaded, then checkpoints. Records before the last
+// reset or delete of a chunk are void.
+func (cs *ChunkServer) replayJournal() error {
+	j, records, err := openJournal(path.Join(cs.rootDir, JournalFileName))
+	if err != nil {
+		return err
+	}
+	cs.journal = j
+	if len(records) == 0 {
+		return nil
+	}
+	log.Infof("Server %v : replay %v journal records", cs.address, len(records))
+
+	start := make(map[gfs.ChunkHandle]int)
+	for i, rec := range records {
+		if rec.kind == journalReset || rec.kind == journalDelete {
+			start[rec.handle] = i
+		}
+	}
+
+	failed := make(map[*dataDir]error)
+	dropped := make(map[gfs.ChunkHandle]bool)
+	cs.lock.Lock()
+	for i, rec := range records {
+		if i < start[rec.handle] || dropped[rec.handle] {
+			continue
+		}
+		ck, ok := cs.chunk[rec.handle]
+		if rec.kind == journalDelete {
+			if ok {
+				delete(cs.chunk, rec.handle)
+				os.Remove(ck.dir.chunkFilename(rec.handle))
+				os.Remove(ck.dir.versionFilename(rec.handle))
+			}
+			continue
+		}
+		if !ok {
+			d, err := cs.nextDir()
+			if err != nil {
+				cs.lock.Unlock()
+				return err
+			}
+			ck = &chunkInfo{dir: d}
+			cs.chunk[rec.handle] = ck
+		}
+		filename := ck.dir.chunkFilename(rec.handle)
+		ck.version = rec.version
+		ck.chunkSize = rec.chunkSize
+		switch rec.kind {
+		case journalReset:
+			ck.length = rec.offset
+			ck.sealed = len(rec.data) > 0 && rec.data[0] == 1
+			err = replayWrite(filename, nil, 0)
+			ck.dir.storeVersion(rec.handle, ck.version, ck.chunkSize, ck.sealed)
+		case journalVersion:
+			ck.dir.storeVersion(rec.handle, ck.version, ck.chunkSize, ck.sealed)
+		case journalWrite:
+			if end := rec.offset + gfs.Offset(len(rec.data)); end > ck.length {
+				ck.length = end
+			}
+			err = replayWrite(filename, rec.data, rec.offset)
+		case journalTruncate:
+			if rec.offset < ck.length {
+				ck.length = rec.offset
+			}
+			err = replayTruncate(filename, rec.offset)
+		case journalSealedWrite:
+			if end := plainSize(int64(rec.offset) + int64(len(rec.data))); end > ck.length {
+				ck.length = end
+			}
+			err = replayWrite(filename, rec.data, rec.offset)
+		case journalSealedTruncate:
+			if end := plainSize(int64(rec.offset)); end < ck.length {
+				ck.length = end
+			}
+			err = replayTruncate(filename, rec.offset)
+		}
+		if err != nil {
+			log.Errorf("Server %v : cannot replay chunk %v: %v", cs.address, rec.handle, err)
+			delete(cs.chunk, rec.handle)
+			dropped[rec.handle] = true
+			failed[ck.dir] = err
+		}
+	}
+	for d, err := range failed {
+		cs.lock.Unlock()
+		cs.checkDir(d, err)
+		cs.lock.Lock()
+	}
+
+	var used int64
+	for handle, ck := range cs.chunk {
+		ck.size = 0
+		if info, err := os.Stat(ck.dir.chunkFilename(handle)); err == nil {
+			ck.size = gfs.Offset(info.Size())
+		}
+		used += int64(ck.size)
+	}
+	atomic.StoreInt64(&cs.diskUsed, used)
+	cs.lock.Unlock()
+
+	return cs.checkpoint()
+}
+
+func replayWrite(filename string, data []byte, offset gfs.Offset) error {
+	if err := os.MkdirAll(path.Dir(filename), FilePerm); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, FilePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(data, int64(offset)); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func replayTruncate(filename string, length gfs.Offset) error {
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil || gfs.Offset(info.Size()) <= length {
+		return err
+	}
+	return os.Truncate(filename, int64(length))
+}
+
+// checkpoint syncs the chunk files and the metadata, then truncates the journal.
+func (cs *ChunkServer) checkpoint() error {
+	cs.journal.Lock()
+	defer cs.journal.Unlock()
+
+	cs.lock.RLock()
+	dirs := make(map[gfs.ChunkHandle]*dataDir, len(cs.chunk))
+	for handle, ck := range cs.chunk {
+		dirs[handle] = ck.dir
+	}
+	cs.lock.RUnlock()
+
+	for handle, d := range dirs {
+		if err := cs.syncChunk(handle, d); err != nil && !os.IsNotExist(err) {
+			cs.checkDir(d, err)
+			if d.healthy() {
+				return err
+			}
+		}
+	}
+	if err := cs.storeMeta(); err != nil {
+		return err
+	}
+	return cs.journal.truncate()
+}
+
+// syncChunk syncs the file of a chunk in d to disk.
+func (cs *ChunkServer) syncChunk(handle gfs.ChunkHandle, d *dataDir) error {
+	file, err := os.Open(d.chunkFilename(handle))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// checkpointIfFull asks the background goroutine for a checkpoint if the
+// journal has grown past gfs.JournalCheckpointBytes.
+func (cs *ChunkServer) checkpointIfFull() {
+	if cs.journal.full() {
+		select {
+		case cs.checkpoints <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// SetDurability sets when mutations are synced before they are acknowledged.
+func (cs *ChunkServer) SetDurability(mode gfs.DurabilityMode) {
+	cs.journal.setMode(mode)
+}
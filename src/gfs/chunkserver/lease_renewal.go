@@ -0,0 +1,130 @@
+package chunkserver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gfs"
+)
+
+// leaseTracker records the chunks mutated as primary, so that their leases
+// are renewed by heartbeats while they are written to and released once idle,
+// and their lengths are reported to master, which keeps the length of files.
+// A released chunk refuses mutations as primary until master grants a new
+// lease, found by the version check, since clients may still cache the old one.
+// A revoked chunk refuses them until the revoked lease would have expired,
+// even if master grants a new one meanwhile.
+type leaseTracker struct {
+	sync.Mutex
+	used     map[gfs.ChunkHandle]time.Time  // last mutation as primary
+	released map[gfs.ChunkHandle]time.Time  // when the lease was released
+	revoked  map[gfs.ChunkHandle]time.Time  // when the revoked lease expires
+	lengths  map[gfs.ChunkHandle]gfs.Offset // after mutations as primary, to be reported
+	expire   time.Duration                  // of the leases granted by master
+}
+
+func newLeaseTracker() *leaseTracker {
+	return &leaseTracker{
+		used:     make(map[gfs.ChunkHandle]time.Time),
+		released: make(map[gfs.ChunkHandle]time.Time),
+		revoked:  make(map[gfs.ChunkHandle]time.Time),
+		lengths:  make(map[gfs.ChunkHandle]gfs.Offset),
+		expire:   gfs.LeaseExpire,
+	}
+}
+
+// checkEpoch checks that a mutation as primary carries the lease of the
+// current version of the chunk. Every new lease raises the version of the
+// replicas, a client holding a lease that moved is refused with
+// gfs.NotPrimary to ask master again. ck should be locked.
+func checkEpoch(handle gfs.ChunkHandle, ck *chunkInfo, epoch gfs.ChunkVersion) error {
+	if epoch != ck.version {
+		return gfs.Error{gfs.NotPrimary, fmt.Sprintf("lease of chunk %v at epoch %v is stale, the chunk is at version %v", handle, epoch, ck.version)}
+	}
+	return nil
+}
+
+// use records a mutation of handle as primary. It fails if the lease of the
+// chunk was released.
+func (t *leaseTracker) use(handle gfs.ChunkHandle) error {
+	t.Lock()
+	defer t.Unlock()
+	if expire, ok := t.revoked[handle]; ok {
+		if time.Now().Before(expire) {
+			return gfs.Error{gfs.NotPrimary, fmt.Sprintf("lease of chunk %v is revoked", handle)}
+		}
+		delete(t.revoked, handle)
+	}
+	if at, ok := t.released[handle]; ok {
+		if time.Since(at) < t.expire {
+			return gfs.Error{gfs.NotPrimary, fmt.Sprintf("lease of chunk %v is released", handle)}
+		}
+		delete(t.released, handle) // every lease granted before has expired
+	}
+	t.used[handle] = time.Now()
+	return nil
+}
+
+// mutated records the length of handle after a mutation as primary.
+func (t *leaseTracker) mutated(handle gfs.ChunkHandle, length gfs.Offset) {
+	t.Lock()
+	defer t.Unlock()
+	t.lengths[handle] = length
+}
+
+// report returns the lengths of the chunks mutated since the last report.
+func (t *leaseTracker) report() []gfs.ChunkLength {
+	t.Lock()
+	defer t.Unlock()
+	var ret []gfs.ChunkLength
+	for h, length := range t.lengths {
+		ret = append(ret, gfs.ChunkLength{h, length})
+	}
+	t.lengths = make(map[gfs.ChunkHandle]gfs.Offset)
+	return ret
+}
+
+// unreport puts back the lengths of a report that failed, unless the chunks
+// were mutated again since.
+func (t *leaseTracker) unreport(lengths []gfs.ChunkLength) {
+	t.Lock()
+	defer t.Unlock()
+	for _, l := range lengths {
+		if _, ok := t.lengths[l.Handle]; !ok {
+			t.lengths[l.Handle] = l.Length
+		}
+	}
+}
+
+// granted is called when master checks the version of handle to grant a new lease.
+func (t *leaseTracker) granted(handle gfs.ChunkHandle) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.released, handle)
+}
+
+// revoke is called when master revokes the lease of handle, which expires at expire.
+func (t *leaseTracker) revoke(handle gfs.ChunkHandle, expire time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.used, handle)
+	t.revoked[handle] = expire
+}
+
+// due returns the leases to be extended, used within gfs.LeaseIdleTimeout,
+// and the ones to be released, idle for longer.
+func (t *leaseTracker) due(now time.Time) (extend, release []gfs.ChunkHandle) {
+	t.Lock()
+	defer t.Unlock()
+	for h, at := range t.used {
+		if now.Sub(at) < gfs.LeaseIdleTimeout {
+			extend = append(extend, h)
+		} else {
+			release = append(release, h)
+			delete(t.used, h)
+			t.released[h] = now
+		}
+	}
+	return
+}
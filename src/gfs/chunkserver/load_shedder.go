@@ -0,0 +1,105 @@
+package chunkserver
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"gfs"
+	log "github.com/Sirupsen/logrus"
+)
+
+// workClass is a kind of work a chunkserver sheds under overload.
+type workClass int
+
+// classes of work, the lowest priority first
+const (
+	workScrub workClass = iota // hashes of chunks computed to verify their replicas
+	workCopy                   // copies of chunks for re-replication and rebalancing
+	workPush                   // data pushed by clients for new mutations
+)
+
+// shedAt is the share of a budget past which a class of work is shed.
+var shedAt = [...]float64{workScrub: 0.7, workCopy: 0.85, workPush: 1}
+
+var workClassNames = [...]string{workScrub: "scrub", workCopy: "copy", workPush: "push"}
+
+func (w workClass) String() string {
+	return workClassNames[w]
+}
+
+// loadShedder keeps a chunkserver within its memory and goroutine budgets.
+// Work past a share of a budget is shed with gfs.ServerBusy, the lowest
+// priority first. Reads and mutations of data already pushed are never shed,
+// so that committed writes are applied and data is served under overload.
+type loadShedder struct {
+	sync.Mutex
+	maxMemory     int64 // heap bytes, 0 means unlimited
+	maxGoroutines int   // 0 means unlimited
+	heap          int64 // heap bytes sampled at sampledAt
+	sampledAt     time.Time
+}
+
+func newLoadShedder(maxMemory int64, maxGoroutines int) *loadShedder {
+	return &loadShedder{maxMemory: maxMemory, maxGoroutines: maxGoroutines}
+}
+
+func (ls *loadShedder) setMax(maxMemory int64, maxGoroutines int) {
+	ls.Lock()
+	defer ls.Unlock()
+	ls.maxMemory, ls.maxGoroutines = maxMemory, maxGoroutines
+}
+
+// usage returns the highest share of a budget in use, and what it is. The
+// heap is sampled every gfs.LoadSampleInterval, reading it stops the world.
+func (ls *loadShedder) usage() (float64, string) {
+	ls.Lock()
+	defer ls.Unlock()
+
+	var share float64
+	var what string
+	if ls.maxMemory > 0 {
+		if now := time.Now(); now.Sub(ls.sampledAt) >= gfs.LoadSampleInterval {
+			var st runtime.MemStats
+			runtime.ReadMemStats(&st)
+			ls.heap, ls.sampledAt = int64(st.HeapAlloc), now
+		}
+		share = float64(ls.heap) / float64(ls.maxMemory)
+		what = fmt.Sprintf("heap of %v bytes, budget %v", ls.heap, ls.maxMemory)
+	}
+	if ls.maxGoroutines > 0 {
+		n := runtime.NumGoroutine()
+		if s := float64(n) / float64(ls.maxGoroutines); s > share {
+			share = s
+			what = fmt.Sprintf("%v goroutines, budget %v", n, ls.maxGoroutines)
+		}
+	}
+	return share, what
+}
+
+// admit returns gfs.ServerBusy if work of class is to be shed.
+func (ls *loadShedder) admit(class workClass) error {
+	share, what := ls.usage()
+	if share < shedAt[class] {
+		return nil
+	}
+	return gfs.Error{gfs.ServerBusy, fmt.Sprintf("overloaded, shedding %v: %v", class, what)}
+}
+
+// shed returns gfs.ServerBusy if work of class is to be shed.
+func (cs *ChunkServer) shed(class workClass) error {
+	err := cs.load.admit(class)
+	if err != nil {
+		cs.metrics.shed.Inc(class.String())
+		log.Debugf("Server %v : %v", cs.address, err)
+	}
+	return err
+}
+
+// SetLoadLimits sets the heap bytes and goroutines a chunkserver keeps
+// within, 0 means unlimited. Scrubbing is shed past 70% of a budget, copies
+// past 85% and data pushed by clients past the budget.
+func (cs *ChunkServer) SetLoadLimits(maxMemory int64, maxGoroutines int) {
+	cs.load.setMax(maxMemory, maxGoroutines)
+}
@@ -0,0 +1,101 @@
+package chunkserver
+
+import (
+	"encoding/gob"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"gfs"
+	"gfs/util"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Clients on the host of the chunkserver may read its replicas from their
+// chunk files, asking for them on a unix socket, see util.LocalReadSocket.
+// The chunk token and the rate limits are checked as for RPCReadChunk, then
+// the file is opened read-only and passed along with the committed length
+// of the chunk, which clamps the reads of the client. Sealed chunks are not
+// passed, they are read by rpc.
+
+// SetLocalReads serves short-circuit reads on a unix socket in dir, until
+// shutdown.
+func (cs *ChunkServer) SetLocalReads(dir string) error {
+	name := util.LocalReadSocket(dir, cs.address)
+	os.Remove(name) // left by a crash
+	l, err := net.ListenUnix("unix", &net.UnixAddr{name, "unix"})
+	if err != nil {
+		return err
+	}
+	log.Infof("Server %v : serve local reads on %v", cs.address, name)
+	go func() {
+		<-cs.shutdown
+		l.Close()
+	}()
+	go func() {
+		for {
+			conn, err := l.AcceptUnix()
+			if err != nil {
+				return
+			}
+			cs.conns.Add(conn)
+			go func() {
+				cs.serveLocalRead(conn)
+				conn.Close()
+				cs.conns.Delete(conn)
+			}()
+		}
+	}()
+	return nil
+}
+
+// serveLocalRead serves a short-circuit read on conn.
+func (cs *ChunkServer) serveLocalRead(conn *net.UnixConn) {
+	conn.SetDeadline(time.Now().Add(gfs.RPCTimeout))
+	var args gfs.ReadChunkArg
+	if err := gob.NewDecoder(conn).Decode(&args); err != nil {
+		return
+	}
+	reply, f, err := cs.openLocalRead(args)
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	if err := util.SendFile(conn, reply, f); err != nil {
+		log.Warningf("Server %v : cannot pass chunk %v: %v", cs.address, args.Handle, err)
+	}
+	if f != nil {
+		f.Close()
+	}
+}
+
+// openLocalRead opens the file of a chunk to be read by a client on the host.
+func (cs *ChunkServer) openLocalRead(args gfs.ReadChunkArg) (gfs.LocalReadReply, *os.File, error) {
+	var reply gfs.LocalReadReply
+	handle := args.Handle
+	if err := cs.secret.CheckChunk(args.Token, handle, false); err != nil {
+		return reply, nil, err
+	}
+	if err := cs.limit(qosForeground, args.Client, args.Length); err != nil {
+		return reply, nil, err
+	}
+	cs.lock.RLock()
+	ck, ok := cs.chunk[handle]
+	cs.lock.RUnlock()
+	if !ok || ck.abandoned {
+		return reply, nil, gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v does not exist or is abandoned", handle)}
+	}
+
+	ck.RLock()
+	defer ck.RUnlock()
+	if ck.sealed {
+		return reply, nil, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("chunk %v is sealed", handle)}
+	}
+	f, err := os.Open(ck.dir.chunkFilename(handle))
+	if err != nil {
+		cs.checkDir(ck.dir, err)
+		return reply, nil, err
+	}
+	reply.ChunkLength, reply.Version = ck.length, ck.version
+	return reply, f, nil
+}
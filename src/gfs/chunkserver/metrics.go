@@ -0,0 +1,97 @@
+package chunkserver
+
+import (
+	"sync/atomic"
+	"time"
+
+	"gfs/metrics"
+)
+
+// serverMetrics are the metrics exported by a chunkserver on /metrics.
+type serverMetrics struct {
+	*metrics.Registry
+	rpc          *metrics.RPC
+	readBytes    *metrics.Counter
+	writtenBytes *metrics.Counter
+	heartbeats   *metrics.Histogram
+	busy         *metrics.Counter
+	throttled    *metrics.Counter
+	shed         *metrics.Counter
+	rateLimited  *metrics.Counter
+	repairs      *metrics.Counter
+	dedup        *metrics.Counter
+
+	lastHeartbeat int64 // unix nano of the last successful heartbeat, accessed atomically
+}
+
+func newServerMetrics(cs *ChunkServer) *serverMetrics {
+	r := metrics.NewRegistry()
+	sm := &serverMetrics{
+		Registry:     r,
+		rpc:          r.NewRPC(),
+		readBytes:    r.NewCounter("gfs_chunkserver_read_bytes_total", "Bytes read from chunks."),
+		writtenBytes: r.NewCounter("gfs_chunkserver_written_bytes_total", "Bytes written to chunks."),
+		heartbeats:   r.NewHistogram("gfs_chunkserver_heartbeat_duration_seconds", "Latency of heartbeats to master, by result.", metrics.DefBuckets, "result"),
+		busy:         r.NewCounter("gfs_chunkserver_download_buffer_rejected_total", "Pushed data rejected because the download buffer and its spill files are full."),
+		throttled:    r.NewCounter("gfs_chunkserver_throttled_appends_total", "Appends rejected by throttle policies."),
+		dedup:        r.NewCounter("gfs_chunkserver_dedup_hits_total", "Retried mutations answered with the result of the first attempt."),
+		shed:         r.NewCounter("gfs_chunkserver_shed_total", "Work shed past the memory or goroutine budget, by class.", "work"),
+		rateLimited:  r.NewCounter("gfs_chunkserver_rate_limited_total", "Reads, pushes and copies rejected by the rate limits, by class.", "class"),
+		repairs:      r.NewCounter("gfs_chunkserver_repairs_total", "Stale replicas brought up to date, by the ranges they lacked or by a full copy.", "kind"),
+	}
+
+	r.NewGaugeFunc("gfs_chunkserver_chunks", "Chunks stored.", func() float64 {
+		cs.lock.RLock()
+		defer cs.lock.RUnlock()
+		return float64(len(cs.chunk))
+	})
+	r.NewGaugeFunc("gfs_chunkserver_heartbeat_lag_seconds", "Time since the last successful heartbeat.", func() float64 {
+		last := atomic.LoadInt64(&sm.lastHeartbeat)
+		if last == 0 {
+			return 0
+		}
+		return time.Since(time.Unix(0, last)).Seconds()
+	})
+	r.NewGaugeFunc("gfs_chunkserver_download_buffer_items", "Data items in the download buffer.", func() float64 {
+		items, _, _ := cs.dl.Stats()
+		return float64(items)
+	})
+	r.NewGaugeFunc("gfs_chunkserver_download_buffer_bytes", "Bytes held by the download buffer.", func() float64 {
+		_, bytes, _ := cs.dl.Stats()
+		return float64(bytes)
+	})
+	r.NewGaugeFunc("gfs_chunkserver_download_buffer_spilled_bytes", "Bytes of the download buffer spilled to disk.", func() float64 {
+		_, _, spilled := cs.dl.Stats()
+		return float64(spilled)
+	})
+	r.NewGaugeFunc("gfs_chunkserver_failed_data_dirs", "Data dirs failed by I/O errors.", func() float64 {
+		failed := 0
+		for _, d := range cs.dirs {
+			if !d.healthy() {
+				failed++
+			}
+		}
+		return float64(failed)
+	})
+	r.NewGaugeFunc("gfs_chunkserver_journal_bytes", "Bytes of the mutation journal since the last checkpoint.", func() float64 {
+		return float64(cs.journal.bytes())
+	})
+	r.NewGaugeFunc("gfs_chunkserver_dedup_results", "Results of mutations kept for retries.", func() float64 {
+		return float64(cs.dedup.len())
+	})
+	r.NewGaugeFunc("gfs_chunkserver_open_files", "Chunk files kept open.", func() float64 {
+		return float64(cs.files.len())
+	})
+	return sm
+}
+
+// observeHeartbeat records a heartbeat that started at start.
+func (sm *serverMetrics) observeHeartbeat(start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	} else {
+		atomic.StoreInt64(&sm.lastHeartbeat, time.Now().UnixNano())
+	}
+	sm.heartbeats.Observe(time.Since(start).Seconds(), result)
+}
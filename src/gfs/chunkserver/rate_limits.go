@@ -0,0 +1,115 @@
+package chunkserver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gfs"
+	"gfs/util"
+	log "github.com/Sirupsen/logrus"
+)
+
+// qosClass is a class of traffic served within its own budget of bytes per
+// second, so that background copies cannot starve the reads and the pushes
+// of clients.
+type qosClass int
+
+const (
+	qosForeground qosClass = iota // reads and data pushed by clients
+	qosBackground                 // copies of chunks for re-replication and rebalancing
+)
+
+var qosClassNames = [...]string{qosForeground: "foreground", qosBackground: "background"}
+
+func (c qosClass) String() string {
+	return qosClassNames[c]
+}
+
+// rateLimiter limits the bytes per second a chunkserver serves, in each
+// class and, in the foreground, for each client. Requests past a budget are
+// rejected with gfs.Throttled, a request larger than a burst is let through
+// and the following ones wait for it to be paid back.
+type rateLimiter struct {
+	sync.Mutex
+	clientRate float64
+	classes    [len(qosClassNames)]*util.RateLimiter
+	clients    map[string]*util.RateLimiter // by client id, the idle ones dropped
+	sweptAt    time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	rl := &rateLimiter{clients: make(map[string]*util.RateLimiter)}
+	for i := range rl.classes {
+		rl.classes[i] = util.NewRateLimiter(0)
+	}
+	return rl
+}
+
+func (rl *rateLimiter) set(clientRate, foregroundRate, backgroundRate float64) {
+	rl.Lock()
+	defer rl.Unlock()
+	rl.clientRate = clientRate
+	rl.classes[qosForeground].SetRate(foregroundRate)
+	rl.classes[qosBackground].SetRate(backgroundRate)
+	for _, l := range rl.clients {
+		l.SetRate(clientRate)
+	}
+}
+
+// client returns the limiter of client. Limiters with a full bucket are as
+// good as new ones, so they are dropped once in a while.
+func (rl *rateLimiter) client(client string) *util.RateLimiter {
+	rl.Lock()
+	defer rl.Unlock()
+	if l, ok := rl.clients[client]; ok {
+		return l
+	}
+	if now := time.Now(); now.Sub(rl.sweptAt) >= time.Second {
+		for id, l := range rl.clients {
+			if l.Full() {
+				delete(rl.clients, id)
+			}
+		}
+		rl.sweptAt = now
+	}
+	l := util.NewRateLimiter(rl.clientRate)
+	rl.clients[client] = l
+	return l
+}
+
+// allow takes bytes of class for client, "" if it is not a client. It
+// returns gfs.Throttled if the budget of the client or of the class allows
+// no more for now.
+func (rl *rateLimiter) allow(class qosClass, client string, bytes int) error {
+	if class == qosForeground && client != "" {
+		rl.Lock()
+		rate := rl.clientRate
+		rl.Unlock()
+		if rate > 0 && !rl.client(client).AllowN(float64(bytes)) {
+			return gfs.Error{gfs.Throttled, fmt.Sprintf("client %v is limited to %v bytes per second", client, rate)}
+		}
+	}
+	if !rl.classes[class].AllowN(float64(bytes)) {
+		return gfs.Error{gfs.Throttled, fmt.Sprintf("%v traffic is over its budget of bytes per second", class)}
+	}
+	return nil
+}
+
+// limit returns gfs.Throttled if bytes of class for client are over budget.
+func (cs *ChunkServer) limit(class qosClass, client string, bytes int) error {
+	err := cs.rate.allow(class, client, bytes)
+	if err != nil {
+		cs.metrics.rateLimited.Inc(class.String())
+		log.Debugf("Server %v : %v", cs.address, err)
+	}
+	return err
+}
+
+// SetRateLimits sets the bytes per second a chunkserver serves to each
+// client, to all clients in the foreground, reads and pushed data, and to
+// the copies of re-replication and rebalancing in the background. 0 means
+// unlimited.
+func (cs *ChunkServer) SetRateLimits(clientRate, foregroundRate, backgroundRate float64) {
+	cs.rate.set(clientRate, foregroundRate, backgroundRate)
+}
@@ -0,0 +1,247 @@
+package chunkserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	"gfs"
+	"gfs/util"
+	log "github.com/Sirupsen/logrus"
+)
+
+// A replica found stale by master, which missed the mutations of a few
+// leases, is repaired by a current one rather than copied whole. The current
+// replica keeps the byte ranges mutated in recent versions, and ships the
+// ranges mutated at the version of the stale one, which may have missed some
+// of them, and after, along with a digest of the chunk. The repair fails if
+// they are not known back to that version, or if the digests do not match
+// afterwards, and a full copy is sent instead.
+
+// changedRange is a byte range of a chunk mutated at a version, a truncation
+// changes the range it cuts.
+type changedRange struct {
+	version gfs.ChunkVersion
+	offset  gfs.Offset
+	length  gfs.Offset
+}
+
+// changeLog records the ranges mutated in a chunk. The mutations of the
+// versions from from on are all known, e.g. since the chunk was created or
+// copied, or since the first lease after a restart.
+type changeLog struct {
+	from   gfs.ChunkVersion
+	ranges []changedRange // in order of the mutations, adjacent ones merged
+}
+
+// record adds a range mutated at version, forgetting the ranges of the
+// oldest version past gfs.RepairMaxRanges.
+func (l *changeLog) record(version gfs.ChunkVersion, offset, length gfs.Offset) {
+	if n := len(l.ranges); n > 0 {
+		last := &l.ranges[n-1]
+		if last.version == version && offset <= last.offset+last.length && offset+length >= last.offset {
+			end := last.offset + last.length
+			if offset+length > end {
+				end = offset + length
+			}
+			if offset < last.offset {
+				last.offset = offset
+			}
+			last.length = end - last.offset
+			return
+		}
+	}
+	l.ranges = append(l.ranges, changedRange{version, offset, length})
+	for len(l.ranges) > gfs.RepairMaxRanges {
+		oldest := l.ranges[0].version
+		for len(l.ranges) > 0 && l.ranges[0].version == oldest {
+			l.ranges = l.ranges[1:]
+		}
+		l.from = oldest + 1
+	}
+}
+
+// since returns the ranges mutated at version and after, sorted and merged,
+// within length. It returns false if they are not all known.
+func (l *changeLog) since(version gfs.ChunkVersion, length gfs.Offset) ([]changedRange, bool) {
+	if l == nil || version < l.from {
+		return nil, false
+	}
+	var ranges []changedRange
+	for _, r := range l.ranges {
+		if r.version >= version {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].offset < ranges[j].offset })
+	var ret []changedRange
+	for _, r := range ranges {
+		if r.offset+r.length > length {
+			r.length = length - r.offset
+		}
+		if r.length <= 0 {
+			continue
+		}
+		if n := len(ret); n > 0 && r.offset <= ret[n-1].offset+ret[n-1].length {
+			if end := r.offset + r.length; end > ret[n-1].offset+ret[n-1].length {
+				ret[n-1].length = end - ret[n-1].offset
+			}
+			continue
+		}
+		ret = append(ret, r)
+	}
+	return ret, true
+}
+
+// digest returns the digest of the first length bytes of a chunk. ck should
+// be locked.
+func (cs *ChunkServer) digest(handle gfs.ChunkHandle, length gfs.Offset) ([]byte, error) {
+	h := sha256.New()
+	buf := make([]byte, gfs.CopyPieceBytes)
+	for offset := gfs.Offset(0); offset < length; {
+		n := length - offset
+		if n > gfs.Offset(len(buf)) {
+			n = gfs.Offset(len(buf))
+		}
+		if _, err := cs.readChunk(handle, offset, buf[:n]); err != nil {
+			return nil, err
+		}
+		h.Write(buf[:n])
+		offset += n
+	}
+	return h.Sum(nil), nil
+}
+
+// sendRepair ships to addr the ranges its stale replica of a chunk lacks.
+// It returns an error if the replica cannot be repaired, a full copy is sent
+// then. The chunk is read locked while the ranges are shipped.
+func (cs *ChunkServer) sendRepair(handle gfs.ChunkHandle, ck *chunkInfo, addr gfs.ServerAddress) error {
+	var st gfs.RepairStateReply
+	if err := util.Call(cs.ctx, addr, "ChunkServer.RPCRepairState", gfs.RepairStateArg{handle, cs.secret.ServerToken()}, &st); err != nil {
+		return err
+	}
+
+	ck.RLock()
+	defer ck.RUnlock()
+	if ck.abandoned {
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v is abandoned", handle)}
+	}
+	if st.Version > ck.version {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("replica of chunk %v on %v is at version %v, ahead of %v", handle, addr, st.Version, ck.version)}
+	}
+	ranges, ok := ck.changes.since(st.Version, ck.length)
+	if !ok {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("mutations of chunk %v since version %v are not known", handle, st.Version)}
+	}
+	var pieces []gfs.ChunkRange
+	var shipped gfs.Offset
+	for _, r := range ranges {
+		data := make([]byte, r.length)
+		if _, err := cs.readChunk(handle, r.offset, data); err != nil {
+			return err
+		}
+		pieces = append(pieces, gfs.ChunkRange{r.offset, data})
+		shipped += r.length
+	}
+	if shipped > ck.length/2 {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("%v of the %v bytes of chunk %v changed", shipped, ck.length, handle)}
+	}
+	digest, err := cs.digest(handle, ck.length)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Server %v : Repair %v on %v from version %v with %v bytes", cs.address, handle, addr, st.Version, shipped)
+	args := gfs.ApplyRepairArg{handle, st.Version, pieces, ck.length, ck.version, ck.chunkSize, digest, cs.secret.ServerToken()}
+	return util.Call(cs.ctx, addr, "ChunkServer.RPCApplyRepair", args, &gfs.ApplyRepairReply{})
+}
+
+// RPCRepairState is called by another replica to learn the version of a stale replica to repair
+func (cs *ChunkServer) RPCRepairState(args gfs.RepairStateArg, reply *gfs.RepairStateReply) error {
+	if err := cs.secret.CheckServer(args.Token); err != nil {
+		return err
+	}
+	cs.lock.RLock()
+	ck, ok := cs.chunk[args.Handle]
+	cs.lock.RUnlock()
+	if !ok {
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v does not exist", args.Handle)}
+	}
+	ck.RLock()
+	defer ck.RUnlock()
+	if ck.abandoned && !ck.stale {
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v is abandoned", args.Handle)}
+	}
+	reply.Version = ck.version
+	reply.Length = ck.length
+	return nil
+}
+
+// RPCApplyRepair is called by another replica to bring a stale replica up to
+// date with the ranges it lacks. The replica is current again only if it
+// matches the digest afterwards.
+func (cs *ChunkServer) RPCApplyRepair(args gfs.ApplyRepairArg, reply *gfs.ApplyRepairReply) error {
+	if err := cs.secret.CheckServer(args.Token); err != nil {
+		return err
+	}
+	handle := args.Handle
+	cs.lock.RLock()
+	ck, ok := cs.chunk[handle]
+	cs.lock.RUnlock()
+	if !ok {
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v does not exist", handle)}
+	}
+
+	ck.Lock()
+	defer ck.Unlock()
+	if ck.abandoned && !ck.stale {
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v is abandoned", handle)}
+	}
+	if ck.version != args.From {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("repair of chunk %v from version %v, at %v", handle, args.From, ck.version)}
+	}
+	// the replica is not current until it matches
+	ck.abandoned, ck.stale = true, true
+
+	cs.journal.RLock()
+	defer cs.journal.RUnlock()
+	if ck.length > args.Length {
+		if err := cs.cutChunk(handle, ck, args.Length); err != nil {
+			return err
+		}
+	}
+	for _, r := range args.Ranges {
+		if err := cs.writeChunk(handle, r.Data, r.Offset, true); err != nil {
+			return err
+		}
+	}
+	if ck.length != args.Length {
+		return gfs.Error{gfs.ChecksumMismatch, fmt.Sprintf("repaired chunk %v is %v bytes, not %v", handle, ck.length, args.Length)}
+	}
+	digest, err := cs.digest(handle, ck.length)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(digest, args.Digest) {
+		return gfs.Error{gfs.ChecksumMismatch, fmt.Sprintf("repaired chunk %v does not match its source", handle)}
+	}
+
+	if args.ChunkSize != 0 {
+		ck.chunkSize = args.ChunkSize
+	}
+	ck.version = args.Version
+	if err := cs.syncChunk(handle, ck.dir); err != nil {
+		cs.checkDir(ck.dir, err)
+		return err
+	}
+	ck.dir.storeVersion(handle, ck.version, ck.chunkSize, ck.sealed)
+	if err := cs.journal.append(journalRecord{journalReset, handle, ck.version, ck.chunkSize, ck.length, resetData(ck.sealed)}); err != nil {
+		return err
+	}
+	ck.abandoned, ck.stale = false, false
+	ck.changes = &changeLog{from: ck.version + 1}
+	cs.metrics.repairs.Inc("delta")
+	log.Infof("Server %v : Repaired %v to version %v", cs.address, handle, ck.version)
+	return nil
+}
@@ -0,0 +1,89 @@
+package chunkserver
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+	"sync"
+
+	"gfs"
+	"gfs/util"
+	log "github.com/Sirupsen/logrus"
+)
+
+// secondaryHealth counts consecutive failures to reach each secondary
+// while applying mutations as primary.
+type secondaryHealth struct {
+	sync.Mutex
+	failures map[gfs.ServerAddress]int
+}
+
+func newSecondaryHealth() *secondaryHealth {
+	return &secondaryHealth{failures: make(map[gfs.ServerAddress]int)}
+}
+
+// record records the result of a call to addr. It returns true when addr
+// reaches gfs.SecondaryFailureThreshold failures in a row, and restarts the count.
+func (h *secondaryHealth) record(addr gfs.ServerAddress, failed bool) bool {
+	h.Lock()
+	defer h.Unlock()
+	if !failed {
+		delete(h.failures, addr)
+		return false
+	}
+	h.failures[addr]++
+	if h.failures[addr] < gfs.SecondaryFailureThreshold {
+		return false
+	}
+	delete(h.failures, addr)
+	return true
+}
+
+// unreachable returns whether err means the server could not be reached,
+// rather than that it handled the call and failed it.
+func unreachable(err error) bool {
+	if err == nil || err == context.Canceled {
+		return false
+	}
+	if _, ok := err.(gfs.Error); ok {
+		return false
+	}
+	if _, ok := err.(rpc.ServerError); ok {
+		return false
+	}
+	return true
+}
+
+// applyToSecondaries asks secondaries to apply a mutation. A secondary that
+// cannot be reached several times in a row is reported to master right away,
+// instead of letting clients time out against it until its heartbeat expires.
+func (cs *ChunkServer) applyToSecondaries(secondaries []gfs.ServerAddress, args gfs.ApplyMutationArg) error {
+	ch := make(chan error)
+	for _, v := range secondaries {
+		go func(addr gfs.ServerAddress) {
+			err := util.Call(cs.ctx, addr, "ChunkServer.RPCApplyMutation", args, nil)
+			if cs.health.record(addr, unreachable(err)) {
+				go cs.reportDeadServer(addr)
+			}
+			ch <- err
+		}(v)
+	}
+	errList := ""
+	for range secondaries {
+		if err := <-ch; err != nil {
+			errList += err.Error() + ";"
+		}
+	}
+	if errList == "" {
+		return nil
+	}
+	return errors.New(errList)
+}
+
+func (cs *ChunkServer) reportDeadServer(addr gfs.ServerAddress) {
+	log.Warningf("%v: secondary %v is unreachable, report to master", cs.address, addr)
+	arg := gfs.ReportDeadServerArg{Address: addr, Reporter: cs.address, Token: cs.secret.ServerToken()}
+	if err := util.Call(cs.ctx, cs.master, "Master.RPCReportDeadServer", arg, &gfs.ReportDeadServerReply{}); err != nil {
+		log.Warningf("%v: cannot report dead server %v: %v", cs.address, addr, err)
+	}
+}
@@ -0,0 +1,120 @@
+package chunkserver
+
+import (
+	"html/template"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"gfs"
+	log "github.com/Sirupsen/logrus"
+)
+
+// serverStatus is shown on the /status page of a chunkserver.
+type serverStatus struct {
+	Address gfs.ServerAddress
+	Build   gfs.BuildInfo
+	Master  gfs.ServerAddress
+	RootDir string
+	Domain  string
+	Labels  string
+	Dead    bool
+	Time    time.Time
+	Dirs    []dirStatus
+	Chunks  []chunkStatus
+	Bytes   int64 // disk usage of chunk files
+}
+
+type dirStatus struct {
+	Path     string
+	Failed   bool
+	Error    string
+	FailedAt time.Time
+	Chunks   int
+}
+
+type chunkStatus struct {
+	Handle    gfs.ChunkHandle
+	Version   gfs.ChunkVersion
+	Length    gfs.Offset // committed length
+	Size      int64      // size of the chunk file, -1 if missing
+	Abandoned bool
+}
+
+var serverStatusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head><title>gfs chunkserver {{.Address}}</title></head>
+<body>
+<h1>gfs chunkserver {{.Address}}{{if .Dead}} (dead){{end}}</h1>
+<p>{{.Time.Format "2006-01-02 15:04:05"}}</p>
+<table border="1">
+<tr><td>Build</td><td>{{.Build}}</td></tr>
+<tr><td>Features</td><td>{{range .Build.Features}}{{.}} {{end}}</td></tr>
+<tr><td>Master</td><td>{{.Master}}</td></tr>
+<tr><td>Root</td><td>{{.RootDir}}</td></tr>
+<tr><td>Domain</td><td>{{.Domain}}</td></tr>
+<tr><td>Labels</td><td>{{.Labels}}</td></tr>
+<tr><td>Disk Usage</td><td>{{.Bytes}} bytes</td></tr>
+</table>
+
+<h2>Data Dirs</h2>
+<table border="1">
+<tr><th>Path</th><th>Chunks</th><th>State</th></tr>
+{{range .Dirs}}<tr><td>{{.Path}}</td><td>{{.Chunks}}</td><td>{{if .Failed}}failed at {{.FailedAt.Format "2006-01-02 15:04:05"}}: {{.Error}}{{else}}healthy{{end}}</td></tr>
+{{end}}</table>
+
+<h2>Chunks ({{len .Chunks}})</h2>
+<table border="1">
+<tr><th>Handle</th><th>Version</th><th>Length</th><th>File Size</th><th>Abandoned</th></tr>
+{{range .Chunks}}<tr><td>{{.Handle}}</td><td>{{.Version}}</td><td>{{.Length}}</td><td>{{.Size}}</td><td>{{.Abandoned}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// status collects the state shown on the status page.
+func (cs *ChunkServer) status() serverStatus {
+	cs.lock.RLock()
+	st := serverStatus{
+		Address: cs.address,
+		Build:   gfs.Build(features...),
+		Master:  cs.master,
+		RootDir: cs.rootDir,
+		Domain:  cs.domain,
+		Labels:  gfs.ServerInfo{Labels: cs.labels}.LabelString(),
+		Dead:    cs.dead,
+		Time:    time.Now(),
+	}
+	chunks := make(map[gfs.ChunkHandle]*chunkInfo, len(cs.chunk))
+	count := make(map[*dataDir]int)
+	for h, ck := range cs.chunk {
+		chunks[h] = ck
+		count[ck.dir]++
+	}
+	for _, d := range cs.dirs {
+		st.Dirs = append(st.Dirs, dirStatus{d.path, !d.healthy(), d.err, d.failedAt, count[d]})
+	}
+	cs.lock.RUnlock()
+
+	for h, ck := range chunks {
+		ck.RLock()
+		c := chunkStatus{Handle: h, Version: ck.version, Length: ck.length, Size: -1, Abandoned: ck.abandoned}
+		ck.RUnlock()
+
+		if fi, err := os.Stat(ck.dir.chunkFilename(h)); err == nil {
+			c.Size = fi.Size()
+			st.Bytes += c.Size
+		}
+		st.Chunks = append(st.Chunks, c)
+	}
+	sort.Slice(st.Chunks, func(i, j int) bool { return st.Chunks[i].Handle < st.Chunks[j].Handle })
+	return st
+}
+
+func (cs *ChunkServer) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := serverStatusTemplate.Execute(w, cs.status()); err != nil {
+		log.Warning("error in status page: ", err)
+	}
+}
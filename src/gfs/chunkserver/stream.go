@@ -0,0 +1,59 @@
+package chunkserver
+
+import (
+	"time"
+
+	"gfs"
+	"gfs/util"
+)
+
+// The data of the chunks pushed to a chunkserver, read from it or copied to
+// it is carried on data streams, see util.CallStream, served on the port of
+// the rpcs. Each stream method is the rpc of the same name with its data
+// moved from the args or the reply to the body. They are counted by the
+// metrics of the rpcs.
+
+// newStreamServer returns the stream server of cs.
+func (cs *ChunkServer) newStreamServer() *util.StreamServer {
+	s := util.NewStreamServer()
+	handle := func(method string, h util.StreamHandler) {
+		s.Handle(method, func(decode func(interface{}) error, body []byte) (interface{}, []byte, error) {
+			start := time.Now()
+			reply, replyBody, err := h(decode, body)
+			cs.metrics.rpc.Record(method, start, err)
+			return reply, replyBody, err
+		})
+	}
+	handle("ChunkServer.RPCForwardData", func(decode func(interface{}) error, body []byte) (interface{}, []byte, error) {
+		var args gfs.ForwardDataArg
+		if err := decode(&args); err != nil {
+			return nil, nil, err
+		}
+		args.Data = body
+		var reply gfs.ForwardDataReply
+		return &reply, nil, cs.RPCForwardData(args, &reply)
+	})
+	handle("ChunkServer.RPCReadChunk", func(decode func(interface{}) error, body []byte) (interface{}, []byte, error) {
+		var args gfs.ReadChunkArg
+		if err := decode(&args); err != nil {
+			return nil, nil, err
+		}
+		var reply gfs.ReadChunkReply
+		if err := cs.RPCReadChunk(args, &reply); err != nil {
+			return nil, nil, err
+		}
+		data := reply.Data[:reply.Length]
+		reply.Data = nil
+		return &reply, data, nil
+	})
+	handle("ChunkServer.RPCApplyCopy", func(decode func(interface{}) error, body []byte) (interface{}, []byte, error) {
+		var args gfs.ApplyCopyArg
+		if err := decode(&args); err != nil {
+			return nil, nil, err
+		}
+		args.Data = body
+		var reply gfs.ApplyCopyReply
+		return &reply, nil, cs.RPCApplyCopy(args, &reply)
+	})
+	return s
+}
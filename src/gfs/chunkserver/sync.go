@@ -0,0 +1,38 @@
+package chunkserver
+
+import (
+	"fmt"
+
+	"gfs"
+)
+
+// makeDurable makes the mutations applied to a chunk durable whatever the
+// durability mode: the journal is synced, so that the length of the chunk
+// survives a crash, and then the chunk file. ck should be locked.
+func (cs *ChunkServer) makeDurable(handle gfs.ChunkHandle, ck *chunkInfo) error {
+	if err := cs.journal.sync(); err != nil {
+		return err
+	}
+	if err := cs.syncChunk(handle, ck.dir); err != nil {
+		cs.checkDir(ck.dir, err)
+		return err
+	}
+	return nil
+}
+
+// RPCSyncChunk is called by client to sync the mutations applied to a chunk
+// to disk, ordered after the mutations in flight.
+func (cs *ChunkServer) RPCSyncChunk(args gfs.SyncChunkArg, reply *gfs.SyncChunkReply) error {
+	if err := cs.secret.CheckChunk(args.Token, args.Handle, false); err != nil {
+		return err
+	}
+	cs.lock.RLock()
+	ck, ok := cs.chunk[args.Handle]
+	cs.lock.RUnlock()
+	if !ok || ck.abandoned {
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("Chunk %v does not exist or is abandoned", args.Handle)}
+	}
+	ck.Lock()
+	defer ck.Unlock()
+	return cs.makeDurable(args.Handle, ck)
+}
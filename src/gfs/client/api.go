@@ -0,0 +1,41 @@
+package client
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"gfs"
+)
+
+// ClientAPI is the file system interface of the gfs client.
+// Applications should depend on it rather than on *Client, so that the client
+// can be wrapped (e.g. with metrics) or replaced by gfs/clientfake in tests.
+// Chunk level APIs are not part of it, they are specific to the real client.
+type ClientAPI interface {
+	// namespace
+	Create(ctx context.Context, path gfs.Path) error
+	CreateWithChunkSize(ctx context.Context, path gfs.Path, chunkSize int64) error
+	Delete(ctx context.Context, path gfs.Path) error
+	DeleteAll(ctx context.Context, path gfs.Path) (files, dirs int, err error)
+	Rename(ctx context.Context, source gfs.Path, target gfs.Path) error
+	Mkdir(ctx context.Context, path gfs.Path) error
+	List(ctx context.Context, path gfs.Path) ([]gfs.PathInfo, error)
+	Walk(ctx context.Context, path gfs.Path, fn func(gfs.FileInfo) error) error
+	Stat(ctx context.Context, path gfs.Path) (gfs.FileInfo, error)
+	Chmod(ctx context.Context, path gfs.Path, mode os.FileMode) error
+	SetReplication(ctx context.Context, path gfs.Path, replicas int) error
+	SetXattr(ctx context.Context, path gfs.Path, name string, value []byte) error
+	GetXattr(ctx context.Context, path gfs.Path, name string) ([]byte, error)
+
+	// data
+	Open(ctx context.Context, path gfs.Path) (*File, error)
+	Read(ctx context.Context, path gfs.Path, offset gfs.Offset, data []byte) (n int, err error)
+	Write(ctx context.Context, path gfs.Path, offset gfs.Offset, data []byte) (int64, error)
+	Append(ctx context.Context, path gfs.Path, data []byte) (offset gfs.Offset, err error)
+	ReadTo(ctx context.Context, path gfs.Path, offset gfs.Offset, length int64, w io.Writer) (int64, error)
+	WriteFrom(ctx context.Context, path gfs.Path, offset gfs.Offset, r io.Reader) (int64, error)
+	GetFileHash(ctx context.Context, path gfs.Path) (gfs.FileHash, error)
+}
+
+var _ ClientAPI = (*Client)(nil)
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gfs"
+)
+
+// AppenderOptions configures an Appender.
+type AppenderOptions struct {
+	MaxBytes int           // flush once this many bytes are buffered, gfs.MaxAppendSize if 0
+	Interval time.Duration // flush records buffered this long, only by size and Flush if 0
+}
+
+// Appender buffers records and appends them to a file a batch at a time, as
+// AppendBatch does, for producers of many small records. Each Write is a
+// record and is never split across appends. It implements io.WriteCloser
+// and is safe for concurrent use, all appends are bounded by the context
+// given to NewAppender.
+//
+// After an append fails, the records buffered are dropped and every call
+// returns the error, as with bufio.Writer.
+type Appender struct {
+	sync.Mutex
+	c    *Client
+	ctx  context.Context
+	path gfs.Path
+	opts AppenderOptions
+
+	records [][]byte
+	size    int
+	timer   *time.Timer // flushing the records after Interval
+	err     error
+	closed  bool
+}
+
+// NewAppender returns an Appender of the file path, which should exist.
+func (c *Client) NewAppender(ctx context.Context, path gfs.Path, opts AppenderOptions) *Appender {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = gfs.MaxAppendSize
+	}
+	return &Appender{c: c, ctx: ctx, path: path, opts: opts}
+}
+
+// Write buffers p as a record, flushing the records if they reach MaxBytes.
+// p is copied, so it may be reused once Write returns.
+func (a *Appender) Write(p []byte) (int, error) {
+	a.Lock()
+	defer a.Unlock()
+	if a.closed {
+		return 0, os.ErrClosed
+	}
+	if a.err != nil {
+		return 0, a.err
+	}
+	if len(p) > gfs.MaxAppendSize {
+		return 0, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("len(record) = %v > max append size %v", len(p), gfs.MaxAppendSize)}
+	}
+	a.records = append(a.records, append([]byte(nil), p...))
+	a.size += len(p)
+	if a.size >= a.opts.MaxBytes {
+		if err := a.flush(); err != nil {
+			return 0, err
+		}
+	} else if a.timer == nil && a.opts.Interval > 0 {
+		a.timer = time.AfterFunc(a.opts.Interval, a.flushAfter)
+	}
+	return len(p), nil
+}
+
+// flushAfter flushes the records once Interval has passed. Its error is
+// returned by the next call.
+func (a *Appender) flushAfter() {
+	a.Lock()
+	defer a.Unlock()
+	if !a.closed && a.err == nil {
+		a.flush()
+	}
+}
+
+// flush appends the records buffered. a should be locked.
+func (a *Appender) flush() error {
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	if len(a.records) == 0 {
+		return nil
+	}
+	_, err := a.c.AppendBatch(a.ctx, a.path, a.records)
+	a.records, a.size = nil, 0
+	if err != nil {
+		a.err = err
+	}
+	return err
+}
+
+// Flush appends the records buffered.
+func (a *Appender) Flush() error {
+	a.Lock()
+	defer a.Unlock()
+	if a.closed {
+		return os.ErrClosed
+	}
+	if a.err != nil {
+		return a.err
+	}
+	return a.flush()
+}
+
+// Sync appends the records buffered, which are on the replicas once it
+// returns. It is Flush, for symmetry with File.
+func (a *Appender) Sync() error {
+	return a.Flush()
+}
+
+// Close flushes the records buffered and closes a.
+func (a *Appender) Close() error {
+	a.Lock()
+	defer a.Unlock()
+	if a.closed {
+		return os.ErrClosed
+	}
+	a.closed = true
+	if a.err != nil {
+		return a.err
+	}
+	return a.flush()
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gfs"
+)
+
+// batchError restores the error of a path in a batch from its string form.
+func batchError(s string) error {
+	if s == "" {
+		return nil
+	}
+	if e, ok := gfs.ParseError(s); ok {
+		return e
+	}
+	return errors.New(s)
+}
+
+// batches calls fn on the ranges of n paths, gfs.BatchMaxPaths at most each.
+func batches(n int, fn func(from, to int) error) error {
+	for from := 0; from < n; from += gfs.BatchMaxPaths {
+		to := from + gfs.BatchMaxPaths
+		if to > n {
+			to = n
+		}
+		if err := fn(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateFiles creates files with the chunk size of the client, in a call to
+// master per gfs.BatchMaxPaths files rather than one per file. It returns
+// the error of each file, nil if it was created, or the error of a call,
+// the files of the calls before it being created.
+func (c *Client) CreateFiles(ctx context.Context, paths []gfs.Path) ([]error, error) {
+	errs := make([]error, len(paths))
+	err := batches(len(paths), func(from, to int) error {
+		var reply gfs.BatchCreateReply
+		err := c.call(ctx, c.master, "Master.RPCBatchCreate", gfs.BatchCreateArg{paths[from:to], c.chunkSize, c.cred}, &reply)
+		if err != nil {
+			return err
+		}
+		if len(reply.Errors) != to-from {
+			return fmt.Errorf("%v results of %v files created", len(reply.Errors), to-from)
+		}
+		for i, s := range reply.Errors {
+			if errs[from+i] = batchError(s); errs[from+i] == nil {
+				c.mirrorCreate(paths[from+i], c.chunkSize)
+			}
+		}
+		return nil
+	})
+	return errs, err
+}
+
+// StatFiles returns the information of files as Stat does, in a call to
+// master per gfs.BatchMaxPaths files. It returns the error of each file,
+// with its information zero, or the error of a call.
+func (c *Client) StatFiles(ctx context.Context, paths []gfs.Path) ([]gfs.FileInfo, []error, error) {
+	infos := make([]gfs.FileInfo, len(paths))
+	errs := make([]error, len(paths))
+	err := batches(len(paths), func(from, to int) error {
+		var reply gfs.BatchGetFileInfoReply
+		if err := c.call(ctx, c.master, "Master.RPCBatchGetFileInfo", gfs.BatchGetFileInfoArg{paths[from:to], c.cred}, &reply); err != nil {
+			return err
+		}
+		if len(reply.Files) != to-from || len(reply.Errors) != to-from {
+			return fmt.Errorf("%v results of %v files", len(reply.Files), to-from)
+		}
+		for i, f := range reply.Files {
+			if errs[from+i] = batchError(reply.Errors[i]); errs[from+i] == nil {
+				p := paths[from+i]
+				infos[from+i] = gfs.FileInfo{p, f.IsDir, f.Length, f.Chunks, f.ChunkSize, f.Replicas, f.CreateTime, f.ModTime, f.ChangeTime, f.Owner, f.Group, f.Mode}
+			}
+		}
+		return nil
+	})
+	return infos, errs, err
+}
+
+// GetChunkHandles returns the handles of chunks (paths[i], indexes[i]) as
+// GetChunkHandle does, creating the next chunk of a file, in a call to
+// master per gfs.BatchMaxPaths chunks. It returns the error of each chunk,
+// or the error of a call.
+func (c *Client) GetChunkHandles(ctx context.Context, paths []gfs.Path, indexes []gfs.ChunkIndex) ([]gfs.ChunkHandle, []error, error) {
+	if len(indexes) != len(paths) {
+		return nil, nil, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("%v chunk indexes of %v paths", len(indexes), len(paths))}
+	}
+	handles := make([]gfs.ChunkHandle, len(paths))
+	errs := make([]error, len(paths))
+	err := batches(len(paths), func(from, to int) error {
+		var reply gfs.BatchGetChunkHandleReply
+		args := gfs.BatchGetChunkHandleArg{paths[from:to], indexes[from:to], true, c.cred}
+		if err := c.call(ctx, c.master, "Master.RPCBatchGetChunkHandle", args, &reply); err != nil {
+			return err
+		}
+		if len(reply.Handles) != to-from || len(reply.Errors) != to-from {
+			return fmt.Errorf("%v results of %v chunks", len(reply.Handles), to-from)
+		}
+		copy(handles[from:], reply.Handles)
+		for i, s := range reply.Errors {
+			errs[from+i] = batchError(s)
+		}
+		return nil
+	})
+	return handles, errs, err
+}
@@ -1,9 +1,14 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"gfs"
@@ -12,44 +17,105 @@ import (
 	log "github.com/Sirupsen/logrus"
 )
 
-// Client struct is the GFS client-side driver
+// Client struct is the GFS client-side driver.
+// All APIs take a context, canceling it aborts the in-flight rpc.
 type Client struct {
-	master   gfs.ServerAddress
-	leaseBuf *leaseBuffer
+	master      gfs.ServerAddress
+	leaseBuf    *leaseBuffer
+	locBuf      *locationBuffer
+	retryPolicy RetryPolicy
+	mirror      *mirror         // nil unless WithMirror is given
+	id          string          // pushed data is accounted to by chunkservers
+	seq         uint64          // of the last request id, accessed atomically
+	cred        gfs.Credentials // the user acted as, set by WithUser and WithToken
+	hedgeDelay  time.Duration   // before a read is hedged, 0 if not, set by WithHedgedReads
+	cache       *readCache      // nil unless WithReadCache is given
+	readAhead   int             // blocks read ahead into cache
+	parallelism int             // chunks of a read or a write transferred at once
+	localDir    string          // of the sockets of short-circuit reads, set by WithLocalReads
+	chunkSize   int64           // of the files made by Create, set by WithChunkSize
+	verifyReads bool            // read every replica and compare, set by WithVerifiedReads
 }
 
-// NewClient returns a new gfs client.
-func NewClient(master gfs.ServerAddress) *Client {
-	return &Client{
-		master:   master,
-		leaseBuf: newLeaseBuffer(master, gfs.LeaseBufferTick),
-	}
+// NewClient returns a new gfs client. DefaultRetryPolicy is used unless
+// another one is given by WithRetryPolicy.
+func NewClient(master gfs.ServerAddress, opts ...Option) *Client {
+	c := &Client{
+		master:      master,
+		leaseBuf:    newLeaseBuffer(master, gfs.LeaseBufferTick),
+		locBuf:      newLocationBuffer(master, gfs.LocationBufferExpire, gfs.LeaseBufferTick),
+		retryPolicy: DefaultRetryPolicy,
+		parallelism: gfs.ClientParallelism,
+		chunkSize:   gfs.MaxChunkSize,
+		id:          fmt.Sprintf("%016x", rand.Uint64()),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.leaseBuf.cred = c.cred
+	c.locBuf.cred = c.cred
+	if c.mirror != nil {
+		c.mirror.start(c.retryPolicy)
+	}
+	return c
+}
+
+// SetFailureDomain sets the failure domain (rack/zone) of the client.
+// Master prefers granting leases to chunkservers in the same domain as the writers.
+func (c *Client) SetFailureDomain(domain string) {
+	c.leaseBuf.Lock()
+	defer c.leaseBuf.Unlock()
+	c.leaseBuf.domain = domain
 }
 
 // Create is a client API, creates a file
-func (c *Client) Create(path gfs.Path) error {
+func (c *Client) Create(ctx context.Context, path gfs.Path) error {
+	return c.CreateWithChunkSize(ctx, path, c.chunkSize)
+}
+
+// CreateWithChunkSize creates a file whose chunks are chunkSize bytes,
+// which should be one of gfs.ChunkSizes.
+func (c *Client) CreateWithChunkSize(ctx context.Context, path gfs.Path, chunkSize int64) error {
 	var reply gfs.CreateFileReply
-	err := util.Call(c.master, "Master.RPCCreateFile", gfs.CreateFileArg{path}, &reply)
+	err := c.call(ctx, c.master, "Master.RPCCreateFile", gfs.CreateFileArg{path, chunkSize, c.cred}, &reply)
 	if err != nil {
 		return err
 	}
+	c.mirrorCreate(path, chunkSize)
 	return nil
 }
 
 // Delete is a client API, deletes a file
-func (c *Client) Delete(path gfs.Path) error {
+func (c *Client) Delete(ctx context.Context, path gfs.Path) error {
 	var reply gfs.DeleteFileReply
-	err := util.Call(c.master, "Master.RPCDeleteFile", gfs.DeleteFileArg{path}, &reply)
+	err := c.call(ctx, c.master, "Master.RPCDeleteFile", gfs.DeleteFileArg{path, false, c.cred}, &reply)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// Rename is a client API, deletes a file
-func (c *Client) Rename(source gfs.Path, target gfs.Path) error {
+// DeleteAll deletes a file, or a directory with everything under it.
+// It returns the files and directories deleted.
+func (c *Client) DeleteAll(ctx context.Context, path gfs.Path) (files, dirs int, err error) {
+	var reply gfs.DeleteFileReply
+	err = c.call(ctx, c.master, "Master.RPCDeleteFile", gfs.DeleteFileArg{path, true, c.cred}, &reply)
+	return reply.Files, reply.Dirs, err
+}
+
+// Undelete moves path, deleted into gfs.TrashDir, back to where it was
+// deleted from, and returns that path.
+func (c *Client) Undelete(ctx context.Context, path gfs.Path) (gfs.Path, error) {
+	var reply gfs.UndeleteReply
+	err := c.call(ctx, c.master, "Master.RPCUndelete", gfs.UndeleteArg{path, c.cred}, &reply)
+	return reply.Path, err
+}
+
+// Rename is a client API, moves a file or a directory to target, which
+// should not exist
+func (c *Client) Rename(ctx context.Context, source gfs.Path, target gfs.Path) error {
 	var reply gfs.RenameFileReply
-	err := util.Call(c.master, "Master.RPCRenameFile", gfs.RenameFileArg{source, target}, &reply)
+	err := c.call(ctx, c.master, "Master.RPCRenameFile", gfs.RenameFileArg{source, target, c.cred}, &reply)
 
 	if err != nil {
 		return err
@@ -59,134 +125,322 @@ func (c *Client) Rename(source gfs.Path, target gfs.Path) error {
 }
 
 // Mkdir is a client API, makes a directory
-func (c *Client) Mkdir(path gfs.Path) error {
+func (c *Client) Mkdir(ctx context.Context, path gfs.Path) error {
 	var reply gfs.MkdirReply
-	err := util.Call(c.master, "Master.RPCMkdir", gfs.MkdirArg{path}, &reply)
+	err := c.call(ctx, c.master, "Master.RPCMkdir", gfs.MkdirArg{path, c.cred}, &reply)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// WithUser sets the user the client acts as, with its groups, for the
+// permissions of files and directories. Without it the client acts as
+// gfs.SuperUser.
+func WithUser(user string, groups ...string) Option {
+	return func(c *Client) {
+		c.cred.User, c.cred.Groups = user, groups
+	}
+}
+
+// WithToken sets the client token the client authenticates to master with,
+// needed if the cluster has a secret. The user and the groups of the token
+// are acted as, whatever WithUser sets.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.cred.Token = token
+	}
+}
+
+// WithParallelism sets the number of chunks a Read or a Write spanning
+// several transfers at once, gfs.ClientParallelism by default.
+func WithParallelism(n int) Option {
+	return func(c *Client) {
+		c.parallelism = n
+	}
+}
+
+// WithChunkSize sets the chunk size of the files made by Create, which
+// should be one of gfs.ChunkSizes, gfs.MaxChunkSize by default.
+func WithChunkSize(size int64) Option {
+	return func(c *Client) {
+		c.chunkSize = size
+	}
+}
+
+// Chmod sets the permission bits of a file or a directory, as os.Chmod.
+// Only the owner may.
+func (c *Client) Chmod(ctx context.Context, path gfs.Path, mode os.FileMode) error {
+	var reply gfs.ChmodReply
+	return c.call(ctx, c.master, "Master.RPCChmod", gfs.ChmodArg{path, mode, c.cred}, &reply)
+}
+
+// Chown sets the owner and the group of a file or a directory, empty ones
+// are kept. Only gfs.SuperUser may change the owner, the owner may set the
+// group to one of its own.
+func (c *Client) Chown(ctx context.Context, path gfs.Path, owner, group string) error {
+	var reply gfs.ChownReply
+	return c.call(ctx, c.master, "Master.RPCChown", gfs.ChownArg{path, owner, group, c.cred}, &reply)
+}
+
+// SetQuota sets the quotas of the files and of the bytes under a directory,
+// 0 is unlimited. Only the superuser may.
+func (c *Client) SetQuota(ctx context.Context, path gfs.Path, maxFiles, maxBytes int64) error {
+	var reply gfs.SetQuotaReply
+	return c.call(ctx, c.master, "Master.RPCSetQuota", gfs.SetQuotaArg{path, maxFiles, maxBytes, c.cred}, &reply)
+}
+
+// GetQuotaUsage returns the quotas of a directory with the files and the
+// bytes under it.
+func (c *Client) GetQuotaUsage(ctx context.Context, path gfs.Path) (gfs.QuotaUsage, error) {
+	var reply gfs.GetQuotaUsageReply
+	err := c.call(ctx, c.master, "Master.RPCGetQuotaUsage", gfs.GetQuotaUsageArg{path, c.cred}, &reply)
+	return reply.Usage, err
+}
+
+// SetReplication sets the number of replicas of the chunks of a file.
+// The master converges existing chunks to it in the background.
+func (c *Client) SetReplication(ctx context.Context, path gfs.Path, replicas int) error {
+	var reply gfs.SetReplicationReply
+	return c.call(ctx, c.master, "Master.RPCSetReplication", gfs.SetReplicationArg{path, replicas, c.cred}, &reply)
+}
+
+// Stat returns the size, times, chunks and replication of a file or a
+// directory. The size follows the writes and appends reported by primaries
+// in their heartbeats.
+func (c *Client) Stat(ctx context.Context, path gfs.Path) (gfs.FileInfo, error) {
+	var f gfs.GetFileInfoReply
+	if err := c.call(ctx, c.master, "Master.RPCGetFileInfo", gfs.GetFileInfoArg{path, c.cred}, &f); err != nil {
+		return gfs.FileInfo{}, err
+	}
+	return gfs.FileInfo{path, f.IsDir, f.Length, f.Chunks, f.ChunkSize, f.Replicas, f.CreateTime, f.ModTime, f.ChangeTime, f.Owner, f.Group, f.Mode}, nil
+}
+
+// Truncate shortens a file to length. The chunks past it are released,
+// a length past the data of the last chunk kept reads as zeros up to it.
+func (c *Client) Truncate(ctx context.Context, path gfs.Path, length int64) error {
+	var reply gfs.TruncateReply
+	defer c.cache.clear() // the chunk cut is not known
+	return c.call(ctx, c.master, "Master.RPCTruncate", gfs.TruncateArg{path, length, c.cred}, &reply)
+}
+
+// CopyFile creates the file target as a copy of source. The chunkservers
+// clone the chunks of source, no data goes through the client.
+func (c *Client) CopyFile(ctx context.Context, source, target gfs.Path) error {
+	var reply gfs.CopyFileReply
+	return c.call(ctx, c.master, "Master.RPCCopyFile", gfs.CopyFileArg{source, target, c.cred}, &reply)
+}
+
+// Concat moves the chunks of the files sources, in order, to the end of the
+// file target, and removes the sources. target and all sources but the last
+// should be whole chunks long.
+func (c *Client) Concat(ctx context.Context, target gfs.Path, sources ...gfs.Path) error {
+	var reply gfs.ConcatReply
+	return c.call(ctx, c.master, "Master.RPCConcat", gfs.ConcatArg{target, sources, c.cred}, &reply)
+}
+
 // List is a client API, lists all files in specific directory
-func (c *Client) List(path gfs.Path) ([]gfs.PathInfo, error) {
-	var reply gfs.ListReply
-	err := util.Call(c.master, "Master.RPCList", gfs.ListArg{path}, &reply)
-	if err != nil {
-		return nil, err
+func (c *Client) List(ctx context.Context, path gfs.Path) ([]gfs.PathInfo, error) {
+	var ls []gfs.PathInfo
+	it := c.ListIter(ctx, path, "", "")
+	for {
+		info, err := it.Next()
+		if err == io.EOF {
+			return ls, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		ls = append(ls, info)
+	}
+}
+
+// Walk calls fn on the files and directories under path in depth-first
+// order, the children of a directory sorted by name, or on path alone if it
+// is a file. The entries are fetched a page at a time, so a tree changed
+// during the walk may be seen partly before and partly after the change.
+// The walk stops at the first error of fn, which is returned.
+func (c *Client) Walk(ctx context.Context, path gfs.Path, fn func(gfs.FileInfo) error) error {
+	var after gfs.Path
+	for {
+		var reply gfs.WalkReply
+		if err := c.call(ctx, c.master, "Master.RPCWalk", gfs.WalkArg{path, after, 0, c.cred}, &reply); err != nil {
+			return err
+		}
+		for _, e := range reply.Entries {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if reply.Next == "" {
+			return nil
+		}
+		after = reply.Next
 	}
-	return reply.Files, nil
+}
+
+// DiskUsage sums the files and directories under path.
+func (c *Client) DiskUsage(ctx context.Context, path gfs.Path) (gfs.DiskUsage, error) {
+	var reply gfs.DiskUsageReply
+	err := c.call(ctx, c.master, "Master.RPCDiskUsage", gfs.DiskUsageArg{path, c.cred}, &reply)
+	return reply.Usage, err
 }
 
 // Read is a client API, read file at specific offset
 // it reads up to len(data) bytes form the File. it return the number of bytes and an error.
 // the error is set to io.EOF if stream meets the end of file
-func (c *Client) Read(path gfs.Path, offset gfs.Offset, data []byte) (n int, err error) {
+// The chunks spanned are read at once, see WithParallelism.
+func (c *Client) Read(ctx context.Context, path gfs.Path, offset gfs.Offset, data []byte) (n int, err error) {
 	var f gfs.GetFileInfoReply
-	err = util.Call(c.master, "Master.RPCGetFileInfo", gfs.GetFileInfoArg{path}, &f)
+	err = c.call(ctx, c.master, "Master.RPCGetFileInfo", gfs.GetFileInfoArg{path, c.cred}, &f)
 	if err != nil {
 		return -1, err
 	}
 
-	if int64(offset/gfs.MaxChunkSize) > f.Chunks {
-		return -1, fmt.Errorf("read offset exceeds file size")
+	size := gfs.Offset(f.ChunkSize)
+	if int64(offset/size) > f.Chunks {
+		return -1, gfs.Error{gfs.InvalidArgument, "read offset exceeds file size"}
 	}
 
-	pos := 0
-	for pos < len(data) {
-		index := gfs.ChunkIndex(offset / gfs.MaxChunkSize)
-		chunkOffset := offset % gfs.MaxChunkSize
-
+	// the chunks are read at once, then the holes filled in order
+	var pieces []chunkPiece
+	for pos := 0; pos < len(data); {
+		index := gfs.ChunkIndex((offset + gfs.Offset(pos)) / size)
 		if int64(index) >= f.Chunks {
-			err = gfs.Error{gfs.ReadEOF, "EOF over chunks"}
 			break
 		}
-
-		var handle gfs.ChunkHandle
-		handle, err = c.GetChunkHandle(path, index)
+		p := chunkPiece{index: index, chunkOffset: (offset + gfs.Offset(pos)) % size, begin: pos}
+		p.end = pos + int(size-p.chunkOffset)
+		if p.end > len(data) {
+			p.end = len(data)
+		}
+		pieces = append(pieces, p)
+		pos = p.end
+	}
+	read := make([]int, len(pieces))
+	errs := c.forChunks(len(pieces), func(i int) error {
+		p := pieces[i]
+		handle, err := c.getChunkHandle(ctx, path, p.index, false)
 		if err != nil {
-			return
+			return err
 		}
+		return c.retry(ctx, "Read", func() error {
+			var e error
+			read[i], e = c.readChunk(ctx, handle, size, p.chunkOffset, data[p.begin:p.end])
+			return e
+		})
+	})
 
-		var n int
-		//wait := time.NewTimer(gfs.ClientTryTimeout)
-		//loop:
-		for {
-			//select {
-			//case <-wait.C:
-			//    err = gfs.Error{gfs.Timeout, "Read Timeout"}
-			//    break loop
-			//default:
-			//}
-			n, err = c.ReadChunk(handle, chunkOffset, data[pos:])
-			if err == nil || err.(gfs.Error).Code == gfs.ReadEOF {
-				break
+	pos := 0
+	for i, p := range pieces {
+		pos = p.begin + read[i]
+		err := errs[i]
+		if errors.Is(err, gfs.ReadEOF) && int64(offset)+int64(pos) < f.Length {
+			// a hole up to the end of the chunk or the file
+			end := p.end
+			if int64(offset)+int64(end) > f.Length {
+				end = int(f.Length - int64(offset))
+			}
+			for j := range data[pos:end] {
+				data[pos+j] = 0
+			}
+			pos = end
+			if pos == p.end {
+				continue
 			}
-			log.Warning("Read ", handle, " connection error, try again: ", err)
 		}
-
-		offset += gfs.Offset(n)
-		pos += n
+		if errors.Is(err, gfs.ReadEOF) || err == nil && pos < p.end {
+			return pos, io.EOF
+		}
 		if err != nil {
-			break
+			return pos, err
 		}
 	}
-
-	if err != nil && err.(gfs.Error).Code == gfs.ReadEOF {
-		return pos, io.EOF
-	} else {
-		return pos, err
+	if pos < len(data) {
+		return pos, io.EOF // over chunks
 	}
+	return pos, nil
 }
 
-// Write is a client API. write data to file at specific offset
-func (c *Client) Write(path gfs.Path, offset gfs.Offset, data []byte) error {
+// chunkPiece is the part of a read or a write in a chunk, data[begin:end]
+// at chunkOffset.
+type chunkPiece struct {
+	index       gfs.ChunkIndex
+	handle      gfs.ChunkHandle
+	chunkOffset gfs.Offset
+	begin, end  int
+}
+
+// forChunks calls fn on each of n pieces of a read or a write, up to
+// c.parallelism at once, and returns their errors.
+func (c *Client) forChunks(n int, fn func(i int) error) []error {
+	errs := make([]error, n)
+	if n == 1 || c.parallelism <= 1 {
+		for i := range errs {
+			errs[i] = fn(i)
+		}
+		return errs
+	}
+	sem := make(chan struct{}, c.parallelism)
+	var wg sync.WaitGroup
+	for i := range errs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = fn(i)
+			<-sem
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// Write is a client API. write data to file at specific offset.
+// Writing beyond the end of file extends it, the gap is a hole reading as zeros.
+// The length of the file after the write is returned. The chunks spanned
+// are written at once, see WithParallelism.
+func (c *Client) Write(ctx context.Context, path gfs.Path, offset gfs.Offset, data []byte) (length int64, err error) {
+	ctx, end := c.trace(ctx, "Write")
+	defer func() { end(err) }()
+
 	var f gfs.GetFileInfoReply
-	err := util.Call(c.master, "Master.RPCGetFileInfo", gfs.GetFileInfoArg{path}, &f)
+	err = c.call(ctx, c.master, "Master.RPCGetFileInfo", gfs.GetFileInfoArg{path, c.cred}, &f)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	if int64(offset/gfs.MaxChunkSize) > f.Chunks {
-		return fmt.Errorf("write offset exceeds file size")
+	size := gfs.Offset(f.ChunkSize)
+	if int64(offset/size) > f.Chunks {
+		// allocate the chunks of the hole, only the next chunk is created by GetChunkHandle
+		_, err = c.extend(ctx, path, int64(offset))
+		if err != nil {
+			return 0, err
+		}
 	}
 
-	begin := 0
+	// the chunks are taken in order, only the next chunk is created by
+	// GetChunkHandle, then written at once
+	start, begin := offset, 0
+	id := c.requestID()
+	var pieces []chunkPiece
 	for {
-		index := gfs.ChunkIndex(offset / gfs.MaxChunkSize)
-		chunkOffset := offset % gfs.MaxChunkSize
+		index := gfs.ChunkIndex(offset / size)
+		chunkOffset := offset % size
 
-		handle, err := c.GetChunkHandle(path, index)
+		handle, err := c.GetChunkHandle(ctx, path, index)
 		if err != nil {
-			return err
+			return 0, err
 		}
 
-		writeMax := int(gfs.MaxChunkSize - chunkOffset)
+		writeMax := int(size - chunkOffset)
 		var writeLen int
 		if begin+writeMax > len(data) {
 			writeLen = len(data) - begin
 		} else {
 			writeLen = writeMax
 		}
-
-		//wait := time.NewTimer(gfs.ClientTryTimeout)
-		//loop:
-		for {
-			//select {
-			//case <-wait.C:
-			//    err = fmt.Errorf("Write Timeout")
-			//    break loop
-			//default:
-			//}
-			err = c.WriteChunk(handle, chunkOffset, data[begin:begin+writeLen])
-			if err == nil {
-				break
-			}
-			log.Warning("Write ", handle, "  connection error, try again ", err)
-		}
-		if err != nil {
-			return err
-		}
+		pieces = append(pieces, chunkPiece{index, handle, chunkOffset, begin, begin + writeLen})
 
 		offset += gfs.Offset(writeLen)
 		begin += writeLen
@@ -195,52 +449,81 @@ func (c *Client) Write(path gfs.Path, offset gfs.Offset, data []byte) error {
 			break
 		}
 	}
+	errs := c.forChunks(len(pieces), func(i int) error {
+		p := pieces[i]
+		return c.retry(ctx, "Write", func() error {
+			return c.writeChunk(ctx, p.handle, p.chunkOffset, data[p.begin:p.end], id)
+		})
+	})
+	for _, err := range errs {
+		if err != nil {
+			return 0, err
+		}
+	}
+	c.mirrorWrite(path, start, data)
 
-	return nil
+	if int64(offset) <= f.Length {
+		return f.Length, nil
+	}
+	return c.extend(ctx, path, int64(offset))
+}
+
+// extend raises the length of a file to at least length, allocating chunks
+// as needed. The new length is returned.
+func (c *Client) extend(ctx context.Context, path gfs.Path, length int64) (int64, error) {
+	var r gfs.ExtendFileReply
+	err := c.call(ctx, c.master, "Master.RPCExtendFile", gfs.ExtendFileArg{path, length, 0, c.cred}, &r)
+	if err != nil {
+		return 0, err
+	}
+	return r.Length, nil
 }
 
 // Append is a client API, append data to file
-func (c *Client) Append(path gfs.Path, data []byte) (offset gfs.Offset, err error) {
+func (c *Client) Append(ctx context.Context, path gfs.Path, data []byte) (offset gfs.Offset, err error) {
+	ctx, end := c.trace(ctx, "Append")
+	defer func() { end(err) }()
+
 	if len(data) > gfs.MaxAppendSize {
-		return 0, fmt.Errorf("len(data) = %v > max append size %v", len(data), gfs.MaxAppendSize)
+		return 0, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("len(data) = %v > max append size %v", len(data), gfs.MaxAppendSize)}
 	}
 
 	var f gfs.GetFileInfoReply
-	err = util.Call(c.master, "Master.RPCGetFileInfo", gfs.GetFileInfoArg{path}, &f)
+	err = c.call(ctx, c.master, "Master.RPCGetFileInfo", gfs.GetFileInfoArg{path, c.cred}, &f)
 	if err != nil {
 		return
 	}
+	if int64(len(data)) > f.ChunkSize/4 {
+		return 0, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("len(data) = %v > 1/4 chunk size %v", len(data), f.ChunkSize)}
+	}
 
 	start := gfs.ChunkIndex(f.Chunks - 1)
 	if start < 0 {
 		start = 0
 	}
+	offset, _, err = c.appendFrom(ctx, path, f.ChunkSize, start, data)
+	return
+}
 
+// appendFrom appends data to the file path of chunks of chunkSize bytes, in
+// the chunk start or the first after it with room for it. It returns the
+// offset of data in the file and the chunk it is in.
+func (c *Client) appendFrom(ctx context.Context, path gfs.Path, chunkSize int64, start gfs.ChunkIndex, data []byte) (offset gfs.Offset, index gfs.ChunkIndex, err error) {
 	var chunkOffset gfs.Offset
+	id := c.requestID()
 	for {
 		var handle gfs.ChunkHandle
-		handle, err = c.GetChunkHandle(path, start)
+		handle, err = c.GetChunkHandle(ctx, path, start)
 		if err != nil {
 			return
 		}
 
-		//wait := time.NewTimer(gfs.ClientTryTimeout)
-		//loop:
-		for {
-			//select {
-			//case <-wait.C:
-			//	err = gfs.Error{gfs.Timeout, "Append Timeout"}
-			//	break loop
-			//default:
-			//}
-			chunkOffset, err = c.AppendChunk(handle, data)
-			if err == nil || err.(gfs.Error).Code == gfs.AppendExceedChunkSize {
-				break
-			}
-			log.Warning("Append ", handle, " connection error, try again ", err)
-			time.Sleep(50 * time.Millisecond)
-		}
-		if err == nil || err.(gfs.Error).Code != gfs.AppendExceedChunkSize {
+		err = c.retry(ctx, "Append", func() error {
+			var e error
+			chunkOffset, e = c.appendChunk(ctx, handle, data, id)
+			return e
+		})
+		if !errors.Is(err, gfs.AppendExceedChunkSize) {
 			break
 		}
 
@@ -253,15 +536,100 @@ func (c *Client) Append(path gfs.Path, data []byte) (offset gfs.Offset, err erro
 		return
 	}
 
-	offset = gfs.Offset(start)*gfs.MaxChunkSize + chunkOffset
-	return
+	offset = gfs.Offset(start)*gfs.Offset(chunkSize) + chunkOffset
+	c.mirrorWrite(path, offset, data)
+	return offset, start, nil
+}
+
+// AppendBatch appends records to a file and returns the offset of each. The
+// records are packed into as few appends as the max append size allows,
+// each a single data push and a single append on the primary, so that the
+// records packed together are contiguous and in order. If an append fails,
+// the offsets of the records appended before are returned with the error.
+func (c *Client) AppendBatch(ctx context.Context, path gfs.Path, records [][]byte) (offsets []gfs.Offset, err error) {
+	ctx, end := c.trace(ctx, "AppendBatch")
+	defer func() { end(err) }()
+
+	var f gfs.GetFileInfoReply
+	err = c.call(ctx, c.master, "Master.RPCGetFileInfo", gfs.GetFileInfoArg{path, c.cred}, &f)
+	if err != nil {
+		return
+	}
+	max := f.ChunkSize / 4
+	if max > gfs.MaxAppendSize {
+		max = gfs.MaxAppendSize
+	}
+	for _, r := range records {
+		if int64(len(r)) > max {
+			return nil, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("len(record) = %v > max append size %v", len(r), max)}
+		}
+	}
+
+	start := gfs.ChunkIndex(f.Chunks - 1)
+	if start < 0 {
+		start = 0
+	}
+	for i := 0; i < len(records); {
+		// pack the records up to the max append size
+		j, size := i, int64(0)
+		for ; j < len(records) && size+int64(len(records[j])) <= max; j++ {
+			size += int64(len(records[j]))
+		}
+		data := make([]byte, 0, size)
+		for _, r := range records[i:j] {
+			data = append(data, r...)
+		}
+		var offset gfs.Offset
+		if offset, start, err = c.appendFrom(ctx, path, f.ChunkSize, start, data); err != nil {
+			return
+		}
+		for _, r := range records[i:j] {
+			offsets = append(offsets, offset)
+			offset += gfs.Offset(len(r))
+		}
+		i = j
+	}
+	return offsets, nil
+}
+
+// trace returns ctx carrying a new trace of the operation op, unless it
+// carries one already, see util.WithTrace, and the func ending the span of
+// the client.
+func (c *Client) trace(ctx context.Context, op string) (context.Context, func(error)) {
+	id := util.TraceID(ctx)
+	if id == "" {
+		id = util.NewTraceID()
+		ctx = util.WithTrace(ctx, id)
+	}
+	return ctx, util.StartSpan(id, "", "Client."+op)
+}
+
+// requestID returns a new id of a mutation, kept across its retries.
+func (c *Client) requestID() gfs.RequestID {
+	return gfs.RequestID{c.id, atomic.AddUint64(&c.seq, 1)}
+}
+
+// wrapError returns err as a gfs.Error. The code is kept if err has one,
+// otherwise it is gfs.UnknownError.
+func wrapError(err error) error {
+	var e gfs.Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return gfs.Error{gfs.UnknownError, err.Error()}
 }
 
 // GetChunkHandle returns the chunk handle of (path, index).
 // If the chunk doesn't exist, master will create one.
-func (c *Client) GetChunkHandle(path gfs.Path, index gfs.ChunkIndex) (gfs.ChunkHandle, error) {
+func (c *Client) GetChunkHandle(ctx context.Context, path gfs.Path, index gfs.ChunkIndex) (gfs.ChunkHandle, error) {
+	return c.getChunkHandle(ctx, path, index, true)
+}
+
+// getChunkHandle returns the chunk handle of (path, index), to be written if
+// write is set. The user of the client should be allowed to by the file.
+func (c *Client) getChunkHandle(ctx context.Context, path gfs.Path, index gfs.ChunkIndex, write bool) (gfs.ChunkHandle, error) {
 	var reply gfs.GetChunkHandleReply
-	err := util.Call(c.master, "Master.RPCGetChunkHandle", gfs.GetChunkHandleArg{path, index}, &reply)
+	err := c.call(ctx, c.master, "Master.RPCGetChunkHandle", gfs.GetChunkHandleArg{path, index, write, c.cred}, &reply)
 	if err != nil {
 		return 0, err
 	}
@@ -270,76 +638,187 @@ func (c *Client) GetChunkHandle(path gfs.Path, index gfs.ChunkIndex) (gfs.ChunkH
 
 // ReadChunk read data from the chunk at specific offset.
 // <code>len(data)+offset</data> should be within chunk size.
-func (c *Client) ReadChunk(handle gfs.ChunkHandle, offset gfs.Offset, data []byte) (int, error) {
+func (c *Client) ReadChunk(ctx context.Context, handle gfs.ChunkHandle, offset gfs.Offset, data []byte) (int, error) {
+	return c.readChunk(ctx, handle, gfs.MaxChunkSize, offset, data)
+}
+
+// readChunk reads a chunk of size bytes at most, through the cache if the
+// client has one.
+func (c *Client) readChunk(ctx context.Context, handle gfs.ChunkHandle, size, offset gfs.Offset, data []byte) (int, error) {
 	var readLen int
 
-	if gfs.MaxChunkSize-offset > gfs.Offset(len(data)) {
+	if size-offset > gfs.Offset(len(data)) {
 		readLen = len(data)
 	} else {
-		readLen = int(gfs.MaxChunkSize - offset)
+		readLen = int(size - offset)
+	}
+	if c.cache != nil {
+		return c.readCached(ctx, handle, size, offset, data[:readLen])
 	}
+	return c.readReplicas(ctx, handle, offset, data[:readLen])
+}
 
-	var l gfs.GetReplicasReply
-	err := util.Call(c.master, "Master.RPCGetReplicas", gfs.GetReplicasArg{handle}, &l)
+// readReplicas reads len(data) bytes of a chunk at offset. The replicas are
+// tried in a random order, the next one if one fails, or hedged if
+// WithHedgedReads is given, and the locations are refreshed once they have
+// all failed.
+func (c *Client) readReplicas(ctx context.Context, handle gfs.ChunkHandle, offset gfs.Offset, data []byte) (int, error) {
+	locations, token, err := c.locBuf.Get(ctx, handle)
 	if err != nil {
-		return 0, gfs.Error{gfs.UnknownError, err.Error()}
+		return 0, wrapError(err)
 	}
-	loc := l.Locations[rand.Intn(len(l.Locations))]
-	if len(l.Locations) == 0 {
-		return 0, gfs.Error{gfs.UnknownError, "no replica"}
+	if len(locations) == 0 {
+		return 0, gfs.Error{gfs.NoReplica, "no replica"}
+	}
+	if c.verifyReads {
+		return c.readVerified(ctx, handle, locations, offset, data, token)
+	}
+	for _, loc := range locations {
+		if !c.isLocal(loc) {
+			continue
+		}
+		n, err := c.readLocal(ctx, handle, loc, offset, data, token)
+		if err == nil || errors.Is(err, gfs.ReadEOF) || ctx.Err() != nil {
+			return n, err
+		}
+		log.Warningf("local read of chunk %v from %v failed, read by rpc: %v", handle, loc, err)
+	}
+	order := make([]gfs.ServerAddress, len(locations))
+	for i, j := range rand.Perm(len(locations)) {
+		order[i] = locations[j]
 	}
 
+	var n int
+	if c.hedgeDelay > 0 && len(order) > 1 {
+		n, err = c.hedgedRead(ctx, handle, order, offset, data, token)
+	} else {
+		for _, loc := range order {
+			n, err = c.readReplica(ctx, handle, loc, offset, data, token)
+			if err == nil || errors.Is(err, gfs.ReadEOF) || ctx.Err() != nil {
+				break
+			}
+			log.Warningf("read of chunk %v from %v failed, try another replica: %v", handle, loc, err)
+		}
+	}
+	if err != nil && !errors.Is(err, gfs.ReadEOF) && !errors.Is(err, gfs.Throttled) {
+		c.locBuf.Invalidate(handle)
+	}
+	return n, err
+}
+
+// readReplica reads len(data) bytes of a chunk at offset from the replica loc.
+func (c *Client) readReplica(ctx context.Context, handle gfs.ChunkHandle, loc gfs.ServerAddress, offset gfs.Offset, data []byte, token string) (int, error) {
 	var r gfs.ReadChunkReply
-	r.Data = data
-	err = util.Call(loc, "ChunkServer.RPCReadChunk", gfs.ReadChunkArg{handle, offset, readLen}, &r)
+	_, err := util.CallStream(ctx, loc, "ChunkServer.RPCReadChunk", gfs.ReadChunkArg{handle, offset, len(data), token, c.id}, nil, &r, data)
+	if errors.Is(err, gfs.ChecksumMismatch) {
+		go c.reportBadReplica(gfs.ReportBadReplicaArg{handle, loc, err.Error(), token})
+	}
 	if err != nil {
-		return 0, gfs.Error{gfs.UnknownError, err.Error()}
+		return 0, wrapError(err)
 	}
-	if r.ErrorCode == gfs.ReadEOF {
-		return r.Length, gfs.Error{gfs.ReadEOF, "read EOF"}
+	if d, ok := c.locBuf.Check(handle, loc, r.Version, r.ChunkLength); ok {
+		go c.reportDivergence(gfs.ReportDivergenceArg{handle, loc, r.Version, r.ChunkLength, d, token})
+	}
+	switch r.ErrorCode {
+	case gfs.ReadEOF:
+		return r.Length, gfs.Error{gfs.ReadEOF, fmt.Sprintf("read past committed length %v", r.ChunkLength)}
+	case gfs.PhysicalEOF:
+		// the replica lost data, try another one
+		return 0, gfs.Error{gfs.PhysicalEOF, fmt.Sprintf("replica %v ends before committed length %v", loc, r.ChunkLength)}
 	}
 	return r.Length, nil
 }
 
+// reportDivergence tells master a replica read disagrees, master checks the
+// replicas of the chunk and drops the divergent ones.
+func (c *Client) reportDivergence(arg gfs.ReportDivergenceArg) {
+	log.Warningf("replica %v of chunk %v diverges by %v: version %v, length %v", arg.Location, arg.Handle, arg.Divergence, arg.Version, arg.Length)
+	var r gfs.ReportDivergenceReply
+	if err := util.Call(context.Background(), c.master, "Master.RPCReportDivergence", arg, &r); err != nil {
+		log.Warningf("cannot report divergence of chunk %v: %v", arg.Handle, err)
+		return
+	}
+	if len(r.Dropped) > 0 {
+		c.locBuf.Invalidate(arg.Handle)
+	}
+}
+
+// reportBadReplica tells master a replica read fails its checksums, master
+// reads it again and drops it if it is bad. The reads go on with the other
+// replicas meanwhile.
+func (c *Client) reportBadReplica(arg gfs.ReportBadReplicaArg) {
+	log.Warningf("replica %v of chunk %v is bad: %v", arg.Location, arg.Handle, arg.Reason)
+	var r gfs.ReportBadReplicaReply
+	if err := util.Call(context.Background(), c.master, "Master.RPCReportBadReplica", arg, &r); err != nil {
+		log.Warningf("cannot report bad replica of chunk %v: %v", arg.Handle, err)
+		return
+	}
+	if r.Dropped {
+		c.locBuf.Invalidate(arg.Handle)
+	}
+}
+
 // WriteChunk writes data to the chunk at specific offset.
 // <code>len(data)+offset</data> should be within chunk size.
-func (c *Client) WriteChunk(handle gfs.ChunkHandle, offset gfs.Offset, data []byte) error {
+func (c *Client) WriteChunk(ctx context.Context, handle gfs.ChunkHandle, offset gfs.Offset, data []byte) error {
+	return c.writeChunk(ctx, handle, offset, data, c.requestID())
+}
+
+// writeChunk writes data to a chunk as the mutation id, which is applied
+// once however many times it is retried.
+func (c *Client) writeChunk(ctx context.Context, handle gfs.ChunkHandle, offset gfs.Offset, data []byte, id gfs.RequestID) error {
 	if len(data)+int(offset) > gfs.MaxChunkSize {
-		return fmt.Errorf("len(data)+offset = %v > max chunk size %v", len(data)+int(offset), gfs.MaxChunkSize)
+		return gfs.Error{gfs.WriteExceedChunkSize, fmt.Sprintf("len(data)+offset = %v > max chunk size %v", len(data)+int(offset), gfs.MaxChunkSize)}
 	}
+	defer c.cache.drop(handle)
 
-	l, err := c.leaseBuf.Get(handle)
+	l, err := c.leaseBuf.Get(ctx, handle)
 	if err != nil {
-		return err
+		return wrapError(err)
 	}
 
 	dataID := chunkserver.NewDataID(handle)
 	chain := append(l.Secondaries, l.Primary)
 
 	var d gfs.ForwardDataReply
-	err = util.Call(chain[0], "ChunkServer.RPCForwardData", gfs.ForwardDataArg{dataID, data, chain[1:]}, &d)
+	trace := util.TraceID(ctx)
+	_, err = util.CallStream(ctx, chain[0], "ChunkServer.RPCForwardData", gfs.ForwardDataArg{dataID, nil, chain[1:], c.id, trace, l.Token}, data, &d, nil)
 	if err != nil {
-		return err
+		if !errors.Is(err, gfs.ServerBusy) && !errors.Is(err, gfs.Throttled) {
+			c.leaseBuf.Invalidate(handle)
+		}
+		return wrapError(err)
 	}
 
-	wcargs := gfs.WriteChunkArg{dataID, offset, l.Secondaries}
-	err = util.Call(l.Primary, "ChunkServer.RPCWriteChunk", wcargs, &gfs.WriteChunkReply{})
-	return err
+	wcargs := gfs.WriteChunkArg{dataID, offset, l.Secondaries, l.Epoch, id, l.Token, trace, isDurable(ctx)}
+	err = util.Call(ctx, l.Primary, "ChunkServer.RPCWriteChunk", wcargs, &gfs.WriteChunkReply{})
+	if err != nil {
+		c.leaseBuf.Invalidate(handle)
+		return wrapError(err)
+	}
+	return nil
 }
 
 // AppendChunk appends data to a chunk.
 // Chunk offset of the start of data will be returned if success.
 // <code>len(data)</code> should be within 1/4 chunk size.
-func (c *Client) AppendChunk(handle gfs.ChunkHandle, data []byte) (offset gfs.Offset, err error) {
+func (c *Client) AppendChunk(ctx context.Context, handle gfs.ChunkHandle, data []byte) (offset gfs.Offset, err error) {
+	return c.appendChunk(ctx, handle, data, c.requestID())
+}
+
+// appendChunk appends data to a chunk as the mutation id, which is applied
+// once however many times it is retried.
+func (c *Client) appendChunk(ctx context.Context, handle gfs.ChunkHandle, data []byte, id gfs.RequestID) (offset gfs.Offset, err error) {
 	if len(data) > gfs.MaxAppendSize {
-		return 0, gfs.Error{gfs.UnknownError, fmt.Sprintf("len(data) = %v > max append size %v", len(data), gfs.MaxAppendSize)}
+		return 0, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("len(data) = %v > max append size %v", len(data), gfs.MaxAppendSize)}
 	}
+	defer c.cache.drop(handle)
 
 	//log.Infof("Client : get lease ")
 
-	l, err := c.leaseBuf.Get(handle)
+	l, err := c.leaseBuf.Get(ctx, handle)
 	if err != nil {
-		return -1, gfs.Error{gfs.UnknownError, err.Error()}
+		return -1, wrapError(err)
 	}
 
 	dataID := chunkserver.NewDataID(handle)
@@ -347,18 +826,25 @@ func (c *Client) AppendChunk(handle gfs.ChunkHandle, data []byte) (offset gfs.Of
 
 	//log.Warning("Client : get locations %v", chain)
 	var d gfs.ForwardDataReply
-	err = util.Call(chain[0], "ChunkServer.RPCForwardData", gfs.ForwardDataArg{dataID, data, chain[1:]}, &d)
+	trace := util.TraceID(ctx)
+	_, err = util.CallStream(ctx, chain[0], "ChunkServer.RPCForwardData", gfs.ForwardDataArg{dataID, nil, chain[1:], c.id, trace, l.Token}, data, &d, nil)
 	if err != nil {
-		return -1, gfs.Error{gfs.UnknownError, err.Error()}
+		if !errors.Is(err, gfs.ServerBusy) && !errors.Is(err, gfs.Throttled) {
+			c.leaseBuf.Invalidate(handle)
+		}
+		return -1, wrapError(err)
 	}
 
 	//log.Warning("Client : send append request to primary. data : %v", dataID)
 
 	var a gfs.AppendChunkReply
-	acargs := gfs.AppendChunkArg{dataID, l.Secondaries}
-	err = util.Call(l.Primary, "ChunkServer.RPCAppendChunk", acargs, &a)
+	acargs := gfs.AppendChunkArg{dataID, l.Secondaries, l.Epoch, id, l.Token, trace, isDurable(ctx)}
+	err = util.Call(ctx, l.Primary, "ChunkServer.RPCAppendChunk", acargs, &a)
 	if err != nil {
-		return -1, gfs.Error{gfs.UnknownError, err.Error()}
+		if !errors.Is(err, gfs.Throttled) {
+			c.leaseBuf.Invalidate(handle)
+		}
+		return -1, wrapError(err)
 	}
 	if a.ErrorCode == gfs.AppendExceedChunkSize {
 		return a.Offset, gfs.Error{a.ErrorCode, "append over chunks"}
@@ -0,0 +1,568 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"gfs"
+	"gfs/util"
+)
+
+// File is an open gfs file. It implements io.ReadWriteSeeker, io.ReaderAt,
+// io.WriterAt and io.Closer, so it can be used with the standard library.
+//
+// Writes are buffered until a chunk boundary, FileBufferSize bytes, a
+// non-contiguous write, a read, Sync or Close. Chunk handles are cached.
+// A File is safe for concurrent use. The context given to Open bounds the
+// open alone, later operations keep its values but not its cancellation, and
+// are bounded by the rpc timeouts, or by the context given to ReadAtContext,
+// WriteAtContext and SyncContext. See SetSequential for streaming by a single
+// writer.
+type File struct {
+	sync.Mutex
+	c    *Client
+	api  ClientAPI       // of OpenFile, nil if opened by c
+	ctx  context.Context // of Open, without its cancellation
+	path gfs.Path
+
+	pos       int64
+	length    int64 // length of the file known to the master
+	chunks    int64 // number of chunks known to exist
+	chunkSize int64
+	handles   map[gfs.ChunkIndex]gfs.ChunkHandle
+
+	buf    []byte // buffered writes starting at bufOff
+	bufOff int64
+	closed bool
+
+	sequential bool        // sequential write mode
+	generation int64       // of the file when last extended by f, in sequential mode
+	ahead      *chunkAhead // next chunk prepared in sequential mode
+}
+
+// chunkAhead is a chunk allocated ahead of the write frontier, with its lease.
+type chunkAhead struct {
+	index  gfs.ChunkIndex
+	handle gfs.ChunkHandle
+	err    error
+	done   chan struct{}
+}
+
+// Open opens a file for reading and writing. The file should exist.
+func (c *Client) Open(ctx context.Context, path gfs.Path) (*File, error) {
+	var info gfs.GetFileInfoReply
+	err := c.call(ctx, c.master, "Master.RPCGetFileInfo", gfs.GetFileInfoArg{path, c.cred}, &info)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir {
+		return nil, gfs.Error{gfs.IsDirectory, "cannot open directory " + string(path)}
+	}
+	return c.newFile(ctx, path, info.Length, info.Chunks, info.ChunkSize), nil
+}
+
+// OpenFile opens a file of any ClientAPI, e.g. a fake or a wrapped client,
+// as a File. If api is not a *Client, the reads and writes of the File are
+// those of api, unbuffered, Seek relative to the end stats the file, and
+// SetSequential is not supported.
+func OpenFile(ctx context.Context, api ClientAPI, path gfs.Path) (*File, error) {
+	if c, ok := api.(*Client); ok {
+		return c.Open(ctx, path)
+	}
+	info, err := api.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir {
+		return nil, gfs.Error{gfs.IsDirectory, "cannot open directory " + string(path)}
+	}
+	return &File{api: api, ctx: context.WithoutCancel(ctx), path: path, length: info.Size, chunks: info.Chunks, chunkSize: info.ChunkSize}, nil
+}
+
+// OpenOrCreate opens a file for reading and writing, creating it if it does
+// not exist. Both are done by a single master rpc, so it is safe to retry
+// and to race with other clients opening the same path.
+func (c *Client) OpenOrCreate(ctx context.Context, path gfs.Path) (*File, error) {
+	var r gfs.OpenFileReply
+	err := c.call(ctx, c.master, "Master.RPCOpenFile", gfs.OpenFileArg{path, true, c.cred}, &r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Created {
+		c.mirrorCreate(path, r.ChunkSize)
+	}
+	return c.newFile(ctx, path, r.Length, r.Chunks, r.ChunkSize), nil
+}
+
+func (c *Client) newFile(ctx context.Context, path gfs.Path, length, chunks, chunkSize int64) *File {
+	return &File{
+		c:         c,
+		ctx:       context.WithoutCancel(ctx),
+		path:      path,
+		length:    length,
+		chunks:    chunks,
+		chunkSize: chunkSize,
+		handles:   make(map[gfs.ChunkIndex]gfs.ChunkHandle),
+	}
+}
+
+// Name returns the path of the file.
+func (f *File) Name() gfs.Path {
+	return f.path
+}
+
+// Read reads up to len(p) bytes at the current position.
+func (f *File) Read(p []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	n, err := f.readAt(f.ctx, p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(p) bytes at off. It returns io.EOF if the file ends before p is filled.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.readAt(f.ctx, p, off)
+}
+
+// ReadAtContext is ReadAt bounded by ctx.
+func (f *File) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.readAt(ctx, p, off)
+}
+
+// Write writes p at the current position.
+func (f *File) Write(p []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	n, err := f.writeAt(f.ctx, p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
+
+// WriteAt writes p at off. The data may stay buffered until Sync or Close.
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.writeAt(f.ctx, p, off)
+}
+
+// WriteAtContext is WriteAt bounded by ctx.
+func (f *File) WriteAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.writeAt(ctx, p, off)
+}
+
+// Seek sets the position for the next Read or Write. io.SeekEnd is relative
+// to the committed length of the file.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	var base int64
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		base = f.pos
+	case io.SeekEnd:
+		if err := f.flush(f.ctx); err != nil {
+			return 0, err
+		}
+		size, err := f.size(f.ctx)
+		if err != nil {
+			return 0, err
+		}
+		base = size
+		if f.sequential {
+			f.length = size // the frontier
+		}
+	default:
+		return 0, gfs.Error{gfs.InvalidArgument, "invalid whence"}
+	}
+	if base+offset < 0 {
+		return 0, gfs.Error{gfs.InvalidArgument, "negative position"}
+	}
+	f.pos = base + offset
+	return f.pos, nil
+}
+
+// SetSequential switches the sequential write mode, for a single writer
+// streaming at the end of the file. Writes must continue at the end, the next
+// chunk is allocated and its lease acquired ahead of them, and the file is
+// extended before data is written to it. Extends fail with
+// gfs.GenerationMismatch once another writer has extended the file since it
+// was last extended by f, the buffered writes are dropped then. Seek relative
+// to the end accepts the writes of others.
+func (f *File) SetSequential(on bool) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	if err := f.flush(f.ctx); err != nil {
+		return err
+	}
+	f.ahead = nil
+	if !on {
+		f.sequential = false
+		return nil
+	}
+	if f.api != nil {
+		return gfs.Error{gfs.InvalidArgument, "sequential mode needs a *Client"}
+	}
+
+	var info gfs.GetFileInfoReply
+	err := f.c.call(f.ctx, f.c.master, "Master.RPCGetFileInfo", gfs.GetFileInfoArg{f.path, f.c.cred}, &info)
+	if err != nil {
+		return err
+	}
+	f.length, f.chunks, f.generation = info.Length, info.Chunks, info.Generation
+	f.sequential = true
+	return nil
+}
+
+// Sync sends buffered writes to chunkservers.
+func (f *File) Sync() error {
+	return f.SyncContext(f.ctx)
+}
+
+// SyncContext is Sync bounded by ctx.
+func (f *File) SyncContext(ctx context.Context) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	return f.flush(ctx)
+}
+
+// Close flushes buffered writes. The file cannot be used afterwards.
+func (f *File) Close() error {
+	f.Lock()
+	defer f.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	return f.flush(f.ctx)
+}
+
+// readAt reads from chunkservers after flushing buffered writes. f should be locked.
+func (f *File) readAt(ctx context.Context, p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if off < 0 {
+		return 0, gfs.Error{gfs.InvalidArgument, "negative offset"}
+	}
+	if f.api != nil {
+		n, err := f.api.Read(ctx, f.path, gfs.Offset(off), p)
+		if n < 0 {
+			n = 0
+		}
+		return n, err
+	}
+	if err := f.flush(ctx); err != nil {
+		return 0, err
+	}
+
+	pos := 0
+	for pos < len(p) {
+		index := gfs.ChunkIndex(off / f.chunkSize)
+		chunkOffset := gfs.Offset(off % f.chunkSize)
+
+		handle, err := f.handle(ctx, index, false)
+		if errors.Is(err, gfs.ReadEOF) {
+			return pos, io.EOF
+		} else if err != nil {
+			return pos, err
+		}
+
+		var n int
+		err = f.c.retry(ctx, "Read", func() error {
+			var e error
+			n, e = f.c.readChunk(ctx, handle, gfs.Offset(f.chunkSize), chunkOffset, p[pos:])
+			return e
+		})
+		pos += n
+		off += int64(n)
+		if errors.Is(err, gfs.ReadEOF) && off < f.length {
+			// a hole up to the end of the chunk or the file
+			end := (off/f.chunkSize + 1) * f.chunkSize
+			if end > f.length {
+				end = f.length
+			}
+			n = len(p) - pos
+			if int64(n) > end-off {
+				n = int(end - off)
+			}
+			for i := range p[pos : pos+n] {
+				p[pos+i] = 0
+			}
+			pos += n
+			off += int64(n)
+		} else if errors.Is(err, gfs.ReadEOF) {
+			return pos, io.EOF
+		} else if err != nil {
+			return pos, err
+		}
+		f.readAhead(ctx, index, off)
+	}
+	return pos, nil
+}
+
+// readAhead reads ahead the first blocks of the chunk after index if a read
+// ended at off within the blocks read ahead of its end. f should be locked.
+func (f *File) readAhead(ctx context.Context, index gfs.ChunkIndex, off int64) {
+	ahead := int64(f.c.readAhead) * gfs.ReadCacheBlockBytes
+	if f.c.cache == nil || ahead == 0 || int64(index)+1 >= f.chunks || off < (int64(index)+1)*f.chunkSize-ahead {
+		return
+	}
+	if handle, err := f.handle(ctx, index+1, false); err == nil {
+		f.c.prefetch(handle, gfs.Offset(f.chunkSize), 0)
+	}
+}
+
+// writeAt buffers p, flushing the buffer as needed. f should be locked.
+func (f *File) writeAt(ctx context.Context, p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if off < 0 {
+		return 0, gfs.Error{gfs.InvalidArgument, "negative offset"}
+	}
+	if f.api != nil {
+		length, err := f.api.Write(ctx, f.path, gfs.Offset(off), p)
+		if err != nil {
+			return 0, err
+		}
+		f.length = length
+		return len(p), nil
+	}
+	if f.sequential {
+		frontier := f.length
+		if len(f.buf) > 0 {
+			frontier = f.bufOff + int64(len(f.buf))
+		}
+		if off != frontier {
+			return 0, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("sequential write at %v, the end is at %v", off, frontier)}
+		}
+	}
+	if len(f.buf) > 0 && off != f.bufOff+int64(len(f.buf)) {
+		if err := f.flush(ctx); err != nil {
+			return 0, err
+		}
+	}
+	if len(f.buf) == 0 {
+		f.bufOff = off
+	}
+
+	written := 0
+	for written < len(p) {
+		// fill the buffer up to the chunk boundary or the buffer size
+		end := (f.bufOff/f.chunkSize + 1) * f.chunkSize
+		if end-f.bufOff > gfs.FileBufferSize {
+			end = f.bufOff + gfs.FileBufferSize
+		}
+		room := int(end - f.bufOff - int64(len(f.buf)))
+		if room > len(p)-written {
+			room = len(p) - written
+		}
+		f.buf = append(f.buf, p[written:written+room]...)
+		written += room
+
+		if f.bufOff+int64(len(f.buf)) == end {
+			if err := f.flush(ctx); err != nil {
+				f.buf = f.buf[:len(f.buf)-room]
+				return written - room, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush writes the buffer to the chunk it belongs to. The buffer never spans
+// chunks. f should be locked.
+func (f *File) flush(ctx context.Context) error {
+	if len(f.buf) == 0 {
+		return nil
+	}
+
+	index := gfs.ChunkIndex(f.bufOff / f.chunkSize)
+	chunkOffset := gfs.Offset(f.bufOff % f.chunkSize)
+	end := f.bufOff + int64(len(f.buf))
+	if f.sequential {
+		if err := f.reserve(ctx, end); err != nil {
+			if errors.Is(err, gfs.GenerationMismatch) {
+				f.buf = f.buf[:0] // the end has moved, they cannot be written there
+			}
+			return err
+		}
+	}
+	handle, err := f.handle(ctx, index, true)
+	if err != nil {
+		return err
+	}
+	if f.sequential && (f.ahead == nil || f.ahead.index <= index) {
+		f.prepare(index + 1)
+	}
+
+	id := f.c.requestID()
+	err = f.c.retry(ctx, "Write", func() error {
+		return f.c.writeChunk(ctx, handle, chunkOffset, f.buf, id)
+	})
+	if err != nil {
+		return err
+	}
+	f.c.mirrorWrite(f.path, gfs.Offset(f.bufOff), f.buf)
+
+	if end > f.length {
+		if f.length, err = f.c.extend(ctx, f.path, end); err != nil {
+			return err
+		}
+	}
+	f.bufOff = end
+	f.buf = f.buf[:0]
+	return nil
+}
+
+// handle returns the chunk handle of index, from the cache if possible.
+// If create is set, the chunk is created if it does not exist, chunks
+// before it are allocated as holes. Otherwise ReadEOF is returned for
+// chunks beyond the end of file.
+func (f *File) handle(ctx context.Context, index gfs.ChunkIndex, create bool) (gfs.ChunkHandle, error) {
+	if a := f.ahead; a != nil && a.index == index {
+		f.ahead = nil
+		select {
+		case <-a.done:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		if a.err == nil {
+			f.handles[index] = a.handle
+			if int64(index) >= f.chunks {
+				f.chunks = int64(index) + 1
+			}
+		}
+	}
+	if h, ok := f.handles[index]; ok {
+		return h, nil
+	}
+
+	if int64(index) >= f.chunks {
+		// the file may have been extended by others
+		var info gfs.GetFileInfoReply
+		err := f.c.call(ctx, f.c.master, "Master.RPCGetFileInfo", gfs.GetFileInfoArg{f.path, f.c.cred}, &info)
+		if err != nil {
+			return 0, err
+		}
+		f.length = info.Length
+		f.chunks = info.Chunks
+	}
+	if int64(index) >= f.chunks && !create {
+		return 0, gfs.Error{gfs.ReadEOF, "EOF over chunks"}
+	}
+	if int64(index) > f.chunks {
+		length, err := f.c.extend(ctx, f.path, int64(index)*f.chunkSize)
+		if err != nil {
+			return 0, err
+		}
+		f.length = length
+		f.chunks = int64(index)
+	}
+
+	h, err := f.c.getChunkHandle(ctx, f.path, index, create)
+	if err != nil {
+		return 0, err
+	}
+	f.handles[index] = h
+	if int64(index) >= f.chunks {
+		f.chunks = int64(index) + 1
+	}
+	return h, nil
+}
+
+// reserve extends the file to end before it is written in sequential mode,
+// provided no other writer has extended it. f should be locked.
+func (f *File) reserve(ctx context.Context, end int64) error {
+	var r gfs.ExtendFileReply
+	err := f.c.call(ctx, f.c.master, "Master.RPCExtendFile", gfs.ExtendFileArg{f.path, end, f.generation, f.c.cred}, &r)
+	if err != nil {
+		return err
+	}
+	f.length, f.chunks, f.generation = r.Length, r.Chunks, r.Generation
+	return nil
+}
+
+// prepare allocates the chunk of index and acquires its lease in the
+// background, for handle to pick up. f should be locked.
+func (f *File) prepare(index gfs.ChunkIndex) {
+	a := &chunkAhead{index: index, done: make(chan struct{})}
+	f.ahead = a
+	go func() {
+		defer close(a.done)
+		a.handle, a.err = f.c.GetChunkHandle(f.ctx, f.path, index)
+		if a.err == nil {
+			f.c.leaseBuf.Get(f.ctx, a.handle) // failures are met again by the write
+		}
+	}()
+}
+
+// size returns the length of the file, i.e. the length known to the master
+// or the committed end of its last chunk if it is beyond. f should be locked.
+func (f *File) size(ctx context.Context) (int64, error) {
+	if f.api != nil {
+		info, err := f.api.Stat(ctx, f.path)
+		f.length = info.Size
+		return info.Size, err
+	}
+	var info gfs.GetFileInfoReply
+	err := f.c.call(ctx, f.c.master, "Master.RPCGetFileInfo", gfs.GetFileInfoArg{f.path, f.c.cred}, &info)
+	if err != nil {
+		return 0, err
+	}
+	f.length = info.Length
+	f.chunks = info.Chunks
+	if f.sequential {
+		f.generation = info.Generation
+	}
+	if f.chunks == 0 {
+		return f.length, nil
+	}
+
+	last := gfs.ChunkIndex(f.chunks - 1)
+	handle, err := f.handle(ctx, last, false)
+	if err != nil {
+		return 0, err
+	}
+
+	var r gfs.ReadChunkReply
+	err = f.c.retry(ctx, "Seek", func() error {
+		locations, token, err := f.c.locBuf.Get(ctx, handle)
+		if err != nil {
+			return wrapError(err)
+		}
+		if len(locations) == 0 {
+			return gfs.Error{gfs.NoReplica, "no replica"}
+		}
+		return util.Call(ctx, locations[0], "ChunkServer.RPCReadChunk", gfs.ReadChunkArg{handle, 0, 0, token, f.c.id}, &r)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if end := int64(last)*f.chunkSize + int64(r.ChunkLength); r.ChunkLength > 0 && end > f.length { // empty if allocated ahead
+		return end, nil
+	}
+	return f.length, nil
+}
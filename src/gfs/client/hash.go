@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+
+	"gfs"
+	"gfs/util"
+	log "github.com/Sirupsen/logrus"
+)
+
+// GetFileHash returns the content hash of a file, a composite of the SHA-256
+// of each chunk, which the chunkservers compute and cache until the chunk
+// changes, so that hashing a file again reads only the chunks mutated
+// since. Files of the same data and chunk size have the same hash. The
+// chunks are hashed at once, see WithParallelism; a file mutated meanwhile
+// gets a hash of none of its versions.
+func (c *Client) GetFileHash(ctx context.Context, path gfs.Path) (h gfs.FileHash, err error) {
+	ctx, end := c.trace(ctx, "GetFileHash")
+	defer func() { end(err) }()
+
+	var f gfs.GetFileInfoReply
+	if err = c.call(ctx, c.master, "Master.RPCGetFileInfo", gfs.GetFileInfoArg{path, c.cred}, &f); err != nil {
+		return h, err
+	}
+	if f.IsDir {
+		return h, gfs.Error{gfs.IsDirectory, "cannot hash directory " + string(path)}
+	}
+	replies := make([]gfs.ChunkHashReply, f.Chunks)
+	for _, err := range c.forChunks(int(f.Chunks), func(i int) error {
+		return c.chunkHash(ctx, path, gfs.ChunkIndex(i), &replies[i])
+	}) {
+		if err != nil {
+			return h, err
+		}
+	}
+
+	sum := sha256.New()
+	for _, r := range replies {
+		sum.Write(r.Sum)
+		h.Size += int64(r.Length)
+	}
+	h.Sum, h.Chunks = sum.Sum(nil), f.Chunks
+	return h, nil
+}
+
+// chunkHash gets the hash of chunk index of a file from one of its
+// replicas, tried in a random order.
+func (c *Client) chunkHash(ctx context.Context, path gfs.Path, index gfs.ChunkIndex, reply *gfs.ChunkHashReply) error {
+	handle, err := c.getChunkHandle(ctx, path, index, false)
+	if err != nil {
+		return err
+	}
+	locations, token, err := c.locBuf.Get(ctx, handle)
+	if err != nil {
+		return wrapError(err)
+	}
+	if len(locations) == 0 {
+		return gfs.Error{gfs.NoReplica, fmt.Sprintf("no replica of chunk %v", handle)}
+	}
+	for _, i := range rand.Perm(len(locations)) {
+		err = util.Call(ctx, locations[i], "ChunkServer.RPCChunkHash", gfs.ChunkHashArg{handle, token, c.id, false}, reply)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		log.Warningf("hash of chunk %v from %v failed, try another replica: %v", handle, locations[i], err)
+	}
+	if err != nil {
+		c.locBuf.Invalidate(handle)
+		return wrapError(err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gfs"
+	log "github.com/Sirupsen/logrus"
+)
+
+// A hedged read sends the read of a chunk to another replica when the ones
+// in flight have not answered within a delay, rather than waiting on a slow
+// replica, and takes the first answer. The others are canceled then. It cuts
+// the tail latency of reads at the cost of reading some chunks twice.
+
+// WithHedgedReads makes the client hedge a read of a chunk once it has not
+// been answered within delay. Without it replicas are tried one at a time.
+func WithHedgedReads(delay time.Duration) Option {
+	return func(c *Client) {
+		c.hedgeDelay = delay
+	}
+}
+
+// hedgedRead reads len(data) bytes of a chunk at offset from the replicas
+// locs, in order, sending the read to the next one whenever c.hedgeDelay
+// passes or a replica fails. It returns the first answer of a replica that
+// does not fail, or the error of the last one.
+func (c *Client) hedgedRead(ctx context.Context, handle gfs.ChunkHandle, locs []gfs.ServerAddress, offset gfs.Offset, data []byte, token string) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type answer struct {
+		loc  gfs.ServerAddress
+		data []byte
+		n    int
+		err  error
+	}
+	answers := make(chan answer, len(locs)) // never blocking the losers
+	next, inflight := 0, 0
+	send := func() {
+		loc := locs[next]
+		next++
+		inflight++
+		go func() {
+			buf := make([]byte, len(data))
+			n, err := c.readReplica(ctx, handle, loc, offset, buf, token)
+			answers <- answer{loc, buf, n, err}
+		}()
+	}
+
+	send()
+	timer := time.NewTimer(c.hedgeDelay)
+	defer timer.Stop()
+	var err error
+	for inflight > 0 {
+		select {
+		case <-timer.C:
+			if next < len(locs) {
+				log.Infof("read of chunk %v not answered in %v, hedge it to %v", handle, c.hedgeDelay, locs[next])
+				send()
+				timer.Reset(c.hedgeDelay)
+			}
+		case a := <-answers:
+			inflight--
+			if a.err == nil || errors.Is(a.err, gfs.ReadEOF) {
+				return copy(data, a.data[:a.n]), a.err
+			}
+			err = a.err
+			if ctx.Err() != nil {
+				return 0, err
+			}
+			log.Warningf("read of chunk %v from %v failed, try another replica: %v", handle, a.loc, err)
+			if next < len(locs) {
+				send()
+			}
+		}
+	}
+	return 0, err
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"gfs"
 	"gfs/util"
 	"sync"
@@ -11,6 +12,8 @@ import (
 type leaseBuffer struct {
 	sync.RWMutex
 	master gfs.ServerAddress
+	domain string          // failure domain of the client, sent as a placement hint
+	cred   gfs.Credentials // master is asked with
 	buffer map[gfs.ChunkHandle]*gfs.Lease
 	tick   time.Duration
 }
@@ -43,19 +46,19 @@ func newLeaseBuffer(ms gfs.ServerAddress, tick time.Duration) *leaseBuffer {
 	return buf
 }
 
-func (buf *leaseBuffer) Get(handle gfs.ChunkHandle) (*gfs.Lease, error) {
+func (buf *leaseBuffer) Get(ctx context.Context, handle gfs.ChunkHandle) (*gfs.Lease, error) {
 	buf.Lock()
 	defer buf.Unlock()
 	lease, ok := buf.buffer[handle]
 
-	if !ok { // ask master to send one
+	if !ok || lease.Expire.Before(time.Now()) { // ask master to send one
 		var l gfs.GetPrimaryAndSecondariesReply
-		err := util.Call(buf.master, "Master.RPCGetPrimaryAndSecondaries", gfs.GetPrimaryAndSecondariesArg{handle}, &l)
+		err := util.Call(ctx, buf.master, "Master.RPCGetPrimaryAndSecondaries", gfs.GetPrimaryAndSecondariesArg{handle, buf.domain, buf.cred, util.TraceID(ctx)}, &l)
 		if err != nil {
 			return nil, err
 		}
 
-		lease = &gfs.Lease{l.Primary, l.Expire, l.Secondaries}
+		lease = &gfs.Lease{l.Primary, l.Expire, l.Secondaries, l.Epoch, l.Token}
 		buf.buffer[handle] = lease
 		return lease, nil
 	}
@@ -69,3 +72,10 @@ func (buf *leaseBuffer) Get(handle gfs.ChunkHandle) (*gfs.Lease, error) {
 	*/
 	return lease, nil
 }
+
+// Invalidate drops the cached lease of a chunk, called when it turns out to be stale.
+func (buf *leaseBuffer) Invalidate(handle gfs.ChunkHandle) {
+	buf.Lock()
+	defer buf.Unlock()
+	delete(buf.buffer, handle)
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"io"
+
+	"gfs"
+)
+
+// ListIterator lists a directory a page at a time, sorted by name.
+// A directory changed during the listing may be seen partly before and
+// partly after the change, but no entry kept throughout is missed.
+type ListIterator struct {
+	c    *Client
+	ctx  context.Context
+	arg  gfs.ListArg
+	page []gfs.PathInfo
+	done bool
+	err  error
+}
+
+// ListIter returns an iterator over the files and directories in path
+// whose names start with prefix and match the glob pattern, as path.Match.
+// Empty prefix and pattern list all.
+func (c *Client) ListIter(ctx context.Context, path gfs.Path, prefix, pattern string) *ListIterator {
+	return &ListIterator{c: c, ctx: ctx, arg: gfs.ListArg{path, "", 0, prefix, pattern, c.cred}}
+}
+
+// Next returns the next entry, or io.EOF after the last one.
+func (it *ListIterator) Next() (gfs.PathInfo, error) {
+	for len(it.page) == 0 {
+		if it.err != nil {
+			return gfs.PathInfo{}, it.err
+		}
+		if it.done {
+			return gfs.PathInfo{}, io.EOF
+		}
+		var reply gfs.ListReply
+		if err := it.c.call(it.ctx, it.c.master, "Master.RPCList", it.arg, &reply); err != nil {
+			it.err = err
+			continue
+		}
+		it.page = reply.Files
+		it.arg.After = reply.Next
+		it.done = reply.Next == ""
+	}
+	info := it.page[0]
+	it.page = it.page[1:]
+	return info, nil
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"sort"
+
+	"gfs"
+)
+
+// Compute frameworks place their tasks next to the data with the locations
+// of the chunks of a file, and split their input along chunk boundaries so
+// that a task reads from as few chunkservers as it can.
+
+// BlockLocation is the range of a file in a chunk, with the chunkservers
+// holding the chunk.
+type BlockLocation struct {
+	Offset gfs.Offset // in the file
+	Length int64
+	Handle gfs.ChunkHandle
+	Hosts  []gfs.ServerAddress // the replicas, empty if none is known
+}
+
+// GetFileBlockLocations returns the chunks of a file length bytes long from
+// offset on, clamped to its size, in order, with their replicas. The
+// locations are those cached by the client, see gfs.LocationBufferExpire.
+func (c *Client) GetFileBlockLocations(ctx context.Context, path gfs.Path, offset gfs.Offset, length int64) ([]BlockLocation, error) {
+	info, err := c.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir {
+		return nil, gfs.Error{gfs.IsDirectory, "no block of directory " + string(path)}
+	}
+	if offset < 0 || length < 0 {
+		return nil, gfs.Error{gfs.InvalidArgument, "negative offset or length"}
+	}
+	end := offset + gfs.Offset(length)
+	if end > gfs.Offset(info.Size) {
+		end = gfs.Offset(info.Size)
+	}
+	size := gfs.Offset(info.ChunkSize)
+
+	var blocks []BlockLocation
+	for pos := offset; pos < end; {
+		index := pos / size
+		next := (index + 1) * size
+		if next > end {
+			next = end
+		}
+		b := BlockLocation{Offset: pos, Length: int64(next - pos)}
+		if int64(index) < info.Chunks {
+			if b.Handle, err = c.getChunkHandle(ctx, path, gfs.ChunkIndex(index), false); err != nil {
+				return nil, err
+			}
+			if b.Hosts, _, err = c.locBuf.Get(ctx, b.Handle); err != nil {
+				return nil, wrapError(err)
+			}
+		}
+		blocks = append(blocks, b)
+		pos = next
+	}
+	return blocks, nil
+}
+
+// InputSplit is a range of a file made of whole chunks, but for the last
+// one, to be read by a task, with the chunkservers best placed to run it.
+type InputSplit struct {
+	Path   gfs.Path
+	Offset gfs.Offset
+	Length int64
+	Hosts  []gfs.ServerAddress // holding the most of its chunks first
+}
+
+// InputSplits partitions a file along chunk boundaries into splits of
+// splitSize bytes at most, rounded to whole chunks, one chunk each if it is
+// not above the chunk size.
+func (c *Client) InputSplits(ctx context.Context, path gfs.Path, splitSize int64) ([]InputSplit, error) {
+	info, err := c.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := c.GetFileBlockLocations(ctx, path, 0, info.Size)
+	if err != nil {
+		return nil, err
+	}
+	per := int(splitSize / info.ChunkSize)
+	if per < 1 {
+		per = 1
+	}
+
+	var splits []InputSplit
+	for i := 0; i < len(blocks); i += per {
+		group := blocks[i:]
+		if len(group) > per {
+			group = group[:per]
+		}
+		s := InputSplit{Path: path, Offset: group[0].Offset}
+		held := make(map[gfs.ServerAddress]int64)
+		for _, b := range group {
+			s.Length += b.Length
+			for _, h := range b.Hosts {
+				held[h] += b.Length
+			}
+		}
+		for h := range held {
+			s.Hosts = append(s.Hosts, h)
+		}
+		sort.Slice(s.Hosts, func(i, j int) bool {
+			a, b := s.Hosts[i], s.Hosts[j]
+			return held[a] > held[b] || held[a] == held[b] && a < b
+		})
+		splits = append(splits, s)
+	}
+	return splits, nil
+}
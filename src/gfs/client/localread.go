@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"gfs"
+	"gfs/util"
+)
+
+// A client on the host of a chunkserver serving short-circuit reads, see
+// ChunkServer.SetLocalReads, reads the replicas there from their chunk
+// files, handed over on a unix socket, rather than by rpc. It saves the
+// round trip over tcp and the copies of the data through the chunkserver.
+// The replicas whose socket is not found, sealed ones and those failing are
+// read by rpc.
+
+// WithLocalReads makes the client read the replicas on its host from the
+// sockets of their chunkservers in dir.
+func WithLocalReads(dir string) Option {
+	return func(c *Client) {
+		c.localDir = dir
+	}
+}
+
+// isLocal returns whether the chunkserver at loc serves short-circuit reads
+// on the host.
+func (c *Client) isLocal(loc gfs.ServerAddress) bool {
+	if c.localDir == "" {
+		return false
+	}
+	info, err := os.Stat(util.LocalReadSocket(c.localDir, loc))
+	return err == nil && info.Mode()&os.ModeSocket != 0
+}
+
+// readLocal reads len(data) bytes of a chunk at offset from its file on the
+// host, passed by the chunkserver at loc. It fails as readReplica does.
+func (c *Client) readLocal(ctx context.Context, handle gfs.ChunkHandle, loc gfs.ServerAddress, offset gfs.Offset, data []byte, token string) (int, error) {
+	d := net.Dialer{Timeout: gfs.RPCDialTimeout}
+	conn, err := d.DialContext(ctx, "unix", util.LocalReadSocket(c.localDir, loc))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(gfs.RPCTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	if err := gob.NewEncoder(conn).Encode(gfs.ReadChunkArg{handle, offset, len(data), token, c.id}); err != nil {
+		return 0, err
+	}
+	var r gfs.LocalReadReply
+	f, err := util.RecvFile(conn.(*net.UnixConn), &r)
+	if err != nil {
+		return 0, err
+	}
+	if r.Error != "" {
+		if e, ok := gfs.ParseError(r.Error); ok {
+			return 0, e
+		}
+		return 0, fmt.Errorf("local read of chunk %v: %v", handle, r.Error)
+	}
+	if f == nil {
+		return 0, fmt.Errorf("no file of chunk %v passed", handle)
+	}
+	defer f.Close()
+	if d, ok := c.locBuf.Check(handle, loc, r.Version, r.ChunkLength); ok {
+		go c.reportDivergence(gfs.ReportDivergenceArg{handle, loc, r.Version, r.ChunkLength, d, token})
+	}
+
+	// clamp to the committed length
+	length, clamped := len(data), false
+	if offset+gfs.Offset(length) > r.ChunkLength {
+		length, clamped = 0, true
+		if offset < r.ChunkLength {
+			length = int(r.ChunkLength - offset)
+		}
+	}
+	n, err := f.ReadAt(data[:length], int64(offset))
+	if err == io.EOF {
+		// the replica lost data, try another one
+		return 0, gfs.Error{gfs.PhysicalEOF, fmt.Sprintf("replica %v ends before committed length %v", loc, r.ChunkLength)}
+	}
+	if err != nil {
+		return 0, err
+	}
+	if clamped {
+		return n, gfs.Error{gfs.ReadEOF, fmt.Sprintf("read past committed length %v", r.ChunkLength)}
+	}
+	return n, nil
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"gfs"
+	"gfs/util"
+	"sync"
+	"time"
+)
+
+type locationItem struct {
+	locations []gfs.ServerAddress
+	version   gfs.ChunkVersion // of the chunk on master
+	token     string           // chunk token reads are sent with
+	expire    time.Time
+	longest   replicaRead // the longest replica read of the latest version
+	reported  bool        // a divergence, until the item expires
+}
+
+// replicaRead is the version and committed length of a replica, as read at.
+type replicaRead struct {
+	location gfs.ServerAddress
+	version  gfs.ChunkVersion
+	length   gfs.Offset
+	at       time.Time
+}
+
+// locationBuffer caches the replica locations of chunks so that reads
+// do not ask master every time.
+type locationBuffer struct {
+	sync.RWMutex
+	master gfs.ServerAddress
+	cred   gfs.Credentials // master is asked with
+	buffer map[gfs.ChunkHandle]locationItem
+	expire time.Duration
+	tick   time.Duration
+}
+
+// newLocationBuffer returns a locationBuffer. Locations are kept for expire.
+// The locationBuffer will cleanup expired items every tick.
+func newLocationBuffer(ms gfs.ServerAddress, expire, tick time.Duration) *locationBuffer {
+	buf := &locationBuffer{
+		buffer: make(map[gfs.ChunkHandle]locationItem),
+		expire: expire,
+		tick:   tick,
+		master: ms,
+	}
+
+	// cleanup
+	go func() {
+		ticker := time.Tick(tick)
+		for {
+			<-ticker
+			now := time.Now()
+			buf.Lock()
+			for id, item := range buf.buffer {
+				if item.expire.Before(now) {
+					delete(buf.buffer, id)
+				}
+			}
+			buf.Unlock()
+		}
+	}()
+
+	return buf
+}
+
+// Get returns the replica locations of a chunk, with the chunk token to read
+// them with, asking master if they are not cached.
+func (buf *locationBuffer) Get(ctx context.Context, handle gfs.ChunkHandle) ([]gfs.ServerAddress, string, error) {
+	buf.RLock()
+	item, ok := buf.buffer[handle]
+	buf.RUnlock()
+	if ok && item.expire.After(time.Now()) {
+		return item.locations, item.token, nil
+	}
+
+	var l gfs.GetReplicasReply
+	err := util.Call(ctx, buf.master, "Master.RPCGetReplicas", gfs.GetReplicasArg{handle, buf.cred}, &l)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(l.Locations) > 0 {
+		buf.Lock()
+		buf.buffer[handle] = locationItem{locations: l.Locations, version: l.Version, token: l.Token, expire: time.Now().Add(buf.expire)}
+		buf.Unlock()
+	}
+	return l.Locations, l.Token, nil
+}
+
+// Invalidate drops the cached locations of a chunk, called when they turn out to be stale.
+func (buf *locationBuffer) Invalidate(handle gfs.ChunkHandle) {
+	buf.Lock()
+	defer buf.Unlock()
+	delete(buf.buffer, handle)
+}
+
+// Check cross-checks the version and committed length read from a replica
+// with the version on master and with the other replicas read, and returns
+// whether the replica diverges. A replica is divergent if it is older than
+// the chunk on master, or shorter than another replica of its version read
+// more than gfs.DivergenceGrace ago. A divergence of a chunk is returned once
+// for as long as its locations are cached.
+func (buf *locationBuffer) Check(handle gfs.ChunkHandle, loc gfs.ServerAddress, version gfs.ChunkVersion, length gfs.Offset) (gfs.Divergence, bool) {
+	buf.Lock()
+	defer buf.Unlock()
+	item, ok := buf.buffer[handle]
+	if !ok || item.reported {
+		return 0, false
+	}
+
+	now := time.Now()
+	longest := item.longest
+	var d gfs.Divergence
+	switch {
+	case version < item.version:
+		d = gfs.DivergentVersion
+	case version == longest.version && loc != longest.location && length < longest.length && now.Sub(longest.at) >= gfs.DivergenceGrace:
+		d = gfs.DivergentLength
+	default:
+		if version > longest.version || version == longest.version && length > longest.length {
+			item.longest = replicaRead{loc, version, length, now}
+			buf.buffer[handle] = item
+		}
+		return 0, false
+	}
+	item.reported = true
+	buf.buffer[handle] = item
+	return d, true
+}
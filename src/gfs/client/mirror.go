@@ -0,0 +1,219 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"gfs"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Divergence is a mutation that could not be applied to the secondary
+// cluster. Divergences are journaled as lines of JSON and repaired by Reconcile.
+type Divergence struct {
+	Time      time.Time
+	Op        string // "create" or "write"
+	Path      gfs.Path
+	Offset    gfs.Offset `json:",omitempty"`
+	Length    int64      `json:",omitempty"`
+	ChunkSize int64      `json:",omitempty"`
+	Error     string
+}
+
+// mirror applies the mutations of a client to a secondary cluster in the
+// background, in the order they succeeded on the primary. Appends are
+// mirrored as writes at the offset chosen by the primary, so that the files
+// stay byte for byte the same.
+type mirror struct {
+	secondary gfs.ServerAddress
+	journal   string
+	c         *Client // of the secondary cluster
+	queue     chan mirrorOp
+	pending   sync.WaitGroup
+	sync.Mutex
+}
+
+type mirrorOp struct {
+	op        string
+	path      gfs.Path
+	offset    gfs.Offset
+	data      []byte
+	chunkSize int64
+}
+
+// WithMirror mirrors Create, Write and Append to the cluster of the master
+// secondary, asynchronously. Mutations that fail there, or do not fit in the
+// queue, are appended to the journal file for Reconcile.
+func WithMirror(secondary gfs.ServerAddress, journal string) Option {
+	return func(c *Client) {
+		c.mirror = &mirror{secondary: secondary, journal: journal}
+	}
+}
+
+// start runs the mirror with the retry policy of the primary client.
+func (mr *mirror) start(p RetryPolicy) {
+	mr.c = NewClient(mr.secondary, WithRetryPolicy(p))
+	mr.queue = make(chan mirrorOp, gfs.MirrorQueueSize)
+	go func() {
+		for op := range mr.queue {
+			if err := mr.apply(op); err != nil {
+				log.Warningf("mirror %v of %v to %v: %v", op.op, op.path, mr.secondary, err)
+				mr.diverge(op, err)
+			}
+			mr.pending.Done()
+		}
+	}()
+}
+
+func (mr *mirror) apply(op mirrorOp) error {
+	ctx := context.Background()
+	switch op.op {
+	case "create":
+		return mr.c.CreateWithChunkSize(ctx, op.path, op.chunkSize)
+	default:
+		_, err := mr.c.Write(ctx, op.path, op.offset, op.data)
+		return err
+	}
+}
+
+// add queues a mutation, it is journaled right away if the queue is full.
+func (mr *mirror) add(op mirrorOp) {
+	mr.pending.Add(1)
+	select {
+	case mr.queue <- op:
+	default:
+		mr.diverge(op, errors.New("mirror queue is full"))
+		mr.pending.Done()
+	}
+}
+
+func (mr *mirror) diverge(op mirrorOp, err error) {
+	d := Divergence{time.Now(), op.op, op.path, op.offset, int64(len(op.data)), op.chunkSize, err.Error()}
+	mr.Lock()
+	defer mr.Unlock()
+	if err := appendDivergences(mr.journal, []Divergence{d}); err != nil {
+		log.Errorf("cannot journal divergence of %v: %v", op.path, err)
+	}
+}
+
+// FlushMirror waits until the queued mutations are mirrored or journaled.
+// It does nothing if the client does not mirror.
+func (c *Client) FlushMirror() {
+	if c.mirror != nil {
+		c.mirror.pending.Wait()
+	}
+}
+
+func (c *Client) mirrorCreate(path gfs.Path, chunkSize int64) {
+	if c.mirror != nil {
+		c.mirror.add(mirrorOp{op: "create", path: path, chunkSize: chunkSize})
+	}
+}
+
+func (c *Client) mirrorWrite(path gfs.Path, offset gfs.Offset, data []byte) {
+	if c.mirror != nil {
+		c.mirror.add(mirrorOp{op: "write", path: path, offset: offset, data: append([]byte(nil), data...)})
+	}
+}
+
+func appendDivergences(journal string, ds []Divergence) error {
+	f, err := os.OpenFile(journal, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, d := range ds {
+		if err := enc.Encode(d); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// ReadJournal returns the divergences of a mirror journal.
+// A missing journal has none.
+func ReadJournal(journal string) ([]Divergence, error) {
+	f, err := os.Open(journal)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ds []Divergence
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var d Divergence
+		if err := dec.Decode(&d); err == io.EOF {
+			return ds, nil
+		} else if err != nil {
+			return nil, err
+		}
+		ds = append(ds, d)
+	}
+}
+
+// Reconcile repairs the divergences of a journal by copying the diverged
+// files and ranges from the primary to the secondary cluster. The journal is
+// rewritten with the divergences that still fail, their number is returned
+// along with the number repaired. No client should journal to it meanwhile.
+func Reconcile(ctx context.Context, primary, secondary *Client, journal string) (repaired, failed int, err error) {
+	ds, err := ReadJournal(journal)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var left []Divergence
+	for _, d := range ds {
+		if e := reconcile(ctx, primary, secondary, d); e != nil {
+			log.Warningf("reconcile %v of %v: %v", d.Op, d.Path, e)
+			d.Time, d.Error = time.Now(), e.Error()
+			left = append(left, d)
+		}
+	}
+
+	tmp := journal + ".tmp"
+	os.Remove(tmp)
+	if err := appendDivergences(tmp, left); err != nil {
+		return 0, 0, err
+	}
+	if err := os.Rename(tmp, journal); err != nil {
+		return 0, 0, err
+	}
+	return len(ds) - len(left), len(left), nil
+}
+
+// reconcile creates the file of d on the secondary if it is missing, with the
+// chunk size on the primary, and copies the range of d if it is a write.
+func reconcile(ctx context.Context, primary, secondary *Client, d Divergence) error {
+	var info gfs.GetFileInfoReply
+	if err := primary.call(ctx, primary.master, "Master.RPCGetFileInfo", gfs.GetFileInfoArg{d.Path, primary.cred}, &info); err != nil {
+		return err
+	}
+	err := secondary.CreateWithChunkSize(ctx, d.Path, info.ChunkSize)
+	if err != nil && !errors.Is(err, gfs.PathExists) {
+		return err
+	}
+	if d.Op != "write" {
+		return nil
+	}
+
+	data := make([]byte, d.Length)
+	n, err := primary.Read(ctx, d.Path, d.Offset, data)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if n == 0 {
+		return nil // not on the primary either
+	}
+	_, err = secondary.Write(ctx, d.Path, d.Offset, data[:n])
+	return err
+}
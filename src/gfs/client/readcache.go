@@ -0,0 +1,248 @@
+package client
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gfs"
+	log "github.com/Sirupsen/logrus"
+)
+
+// A client may cache the chunks it reads, in blocks of gfs.ReadCacheBlockBytes
+// evicted in LRU order, and read ahead the blocks following the ones read,
+// and the first ones of the next chunk when a File is read sequentially. A
+// block is read from the cache for gfs.ReadCacheExpire, so the mutations of
+// other clients may be seen that late. Those of the client drop the blocks
+// of the chunk they mutate.
+
+// WithReadCache makes the client cache up to maxBytes of the chunks it reads.
+func WithReadCache(maxBytes int64) Option {
+	return func(c *Client) {
+		c.cache = newReadCache(maxBytes, gfs.ReadCacheExpire)
+	}
+}
+
+// WithReadAhead makes the client read blocks ahead of the ones read into
+// its cache, given by WithReadCache, in the background.
+func WithReadAhead(blocks int) Option {
+	return func(c *Client) {
+		c.readAhead = blocks
+	}
+}
+
+// ReadCacheStats is the usage of the read cache of a client.
+type ReadCacheStats struct {
+	Blocks     int   // cached, not counting those being read
+	Bytes      int64 // of the blocks cached
+	Hits       int64 // reads of a block cached or being read
+	Misses     int64
+	Prefetched int64 // blocks read ahead
+}
+
+// ReadCacheStats returns the usage of the read cache, zero if it has none.
+func (c *Client) ReadCacheStats() ReadCacheStats {
+	if c.cache == nil {
+		return ReadCacheStats{}
+	}
+	c.cache.Lock()
+	defer c.cache.Unlock()
+	return c.cache.stats
+}
+
+// blockKey is a block of a chunk, by its index in the chunk.
+type blockKey struct {
+	handle gfs.ChunkHandle
+	index  gfs.Offset
+}
+
+// cachedBlock is a block of a chunk read, shorter than a block if eof is set.
+type cachedBlock struct {
+	key    blockKey
+	data   []byte
+	eof    bool // the committed data of the chunk ends in the block
+	err    error
+	expire time.Time
+	done   chan struct{} // closed once it is read
+}
+
+// readCache caches blocks of chunks in LRU order, up to max bytes.
+type readCache struct {
+	sync.Mutex
+	max    int64
+	expire time.Duration
+	lru    *list.List // of *cachedBlock, the most recent first
+	blocks map[blockKey]*list.Element
+	stats  ReadCacheStats
+}
+
+func newReadCache(max int64, expire time.Duration) *readCache {
+	return &readCache{max: max, expire: expire, lru: list.New(), blocks: make(map[blockKey]*list.Element)}
+}
+
+// fetched returns whether a block is read. rc should be locked.
+func (b *cachedBlock) fetched() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// remove removes the element of a block. rc should be locked.
+func (rc *readCache) remove(e *list.Element) {
+	b := e.Value.(*cachedBlock)
+	if b.fetched() {
+		rc.stats.Blocks--
+		rc.stats.Bytes -= int64(len(b.data))
+	}
+	rc.lru.Remove(e)
+	delete(rc.blocks, b.key)
+}
+
+// has returns whether the block key is cached or being read.
+func (rc *readCache) has(key blockKey) bool {
+	rc.Lock()
+	defer rc.Unlock()
+	e, ok := rc.blocks[key]
+	if !ok {
+		return false
+	}
+	b := e.Value.(*cachedBlock)
+	return !b.fetched() || time.Now().Before(b.expire)
+}
+
+// get returns the block key, cached, being read, or read by fetch. A block
+// fetch fails to read is not cached.
+func (rc *readCache) get(ctx context.Context, key blockKey, fetch func() ([]byte, bool, error)) (*cachedBlock, error) {
+	rc.Lock()
+	if e, ok := rc.blocks[key]; ok {
+		b := e.Value.(*cachedBlock)
+		if !b.fetched() || time.Now().Before(b.expire) {
+			rc.lru.MoveToFront(e)
+			rc.stats.Hits++
+			rc.Unlock()
+			select {
+			case <-b.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			return b, b.err
+		}
+		rc.remove(e)
+	}
+	rc.stats.Misses++
+	b := &cachedBlock{key: key, done: make(chan struct{})}
+	rc.blocks[key] = rc.lru.PushFront(b)
+	rc.Unlock()
+
+	b.data, b.eof, b.err = fetch()
+
+	rc.Lock()
+	defer rc.Unlock()
+	b.expire = time.Now().Add(rc.expire)
+	close(b.done)
+	if e, ok := rc.blocks[key]; ok && e.Value == b {
+		if b.err != nil {
+			rc.remove(e)
+		} else {
+			rc.stats.Blocks++
+			rc.stats.Bytes += int64(len(b.data))
+		}
+	}
+	for rc.stats.Bytes > rc.max && rc.lru.Len() > 0 {
+		rc.remove(rc.lru.Back())
+	}
+	return b, b.err
+}
+
+// drop removes the blocks of a chunk, after the client mutated it. rc may be nil.
+func (rc *readCache) drop(handle gfs.ChunkHandle) {
+	if rc == nil {
+		return
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	for key, e := range rc.blocks {
+		if key.handle == handle {
+			rc.remove(e)
+		}
+	}
+}
+
+// clear removes all blocks. rc may be nil.
+func (rc *readCache) clear() {
+	if rc == nil {
+		return
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	for _, e := range rc.blocks {
+		rc.remove(e)
+	}
+}
+
+// fetchBlock returns the function reading the block index of a chunk of size
+// bytes at most from its replicas.
+func (c *Client) fetchBlock(ctx context.Context, handle gfs.ChunkHandle, size, index gfs.Offset) func() ([]byte, bool, error) {
+	return func() ([]byte, bool, error) {
+		length := gfs.Offset(gfs.ReadCacheBlockBytes)
+		if start := index * length; start+length > size {
+			length = size - start
+		}
+		buf := make([]byte, length)
+		n, err := c.readReplicas(ctx, handle, index*gfs.ReadCacheBlockBytes, buf)
+		if errors.Is(err, gfs.ReadEOF) {
+			return buf[:n], true, nil
+		}
+		return buf[:n], false, err
+	}
+}
+
+// readCached reads len(data) bytes of a chunk of size bytes at most at
+// offset through the cache, and reads ahead the blocks after.
+func (c *Client) readCached(ctx context.Context, handle gfs.ChunkHandle, size, offset gfs.Offset, data []byte) (int, error) {
+	pos := 0
+	for pos < len(data) {
+		off := offset + gfs.Offset(pos)
+		index := off / gfs.ReadCacheBlockBytes
+		b, err := c.cache.get(ctx, blockKey{handle, index}, c.fetchBlock(ctx, handle, size, index))
+		if err != nil {
+			return pos, err
+		}
+		if !b.eof {
+			c.prefetch(handle, size, index+1)
+		}
+		if start := off - index*gfs.ReadCacheBlockBytes; start < gfs.Offset(len(b.data)) {
+			pos += copy(data[pos:], b.data[start:])
+		}
+		if pos < len(data) && b.eof {
+			return pos, gfs.Error{gfs.ReadEOF, "read past committed length of a cached block"}
+		}
+	}
+	return pos, nil
+}
+
+// prefetch reads ahead the c.readAhead blocks of a chunk of size bytes at
+// most from the block from on, those not cached, in the background.
+func (c *Client) prefetch(handle gfs.ChunkHandle, size, from gfs.Offset) {
+	for index := from; index < from+gfs.Offset(c.readAhead) && index*gfs.ReadCacheBlockBytes < size; index++ {
+		key := blockKey{handle, index}
+		if c.cache.has(key) {
+			continue
+		}
+		c.cache.Lock()
+		c.cache.stats.Prefetched++
+		c.cache.Unlock()
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), gfs.RPCTimeout)
+			defer cancel()
+			if _, err := c.cache.get(ctx, key, c.fetchBlock(ctx, handle, size, key.index)); err != nil {
+				log.Warningf("cannot read ahead block %v of chunk %v: %v", key.index, handle, err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/rpc"
+	"time"
+
+	"gfs"
+	"gfs/util"
+)
+
+// RetryPolicy describes how the client retries failed rpcs.
+// The n-th retry waits InitialBackoff * Multiplier^(n-1), capped by MaxBackoff,
+// and randomized by +/- Jitter of itself.
+type RetryPolicy struct {
+	MaxAttempts    int           // max number of attempts, 0 means no limit
+	MaxElapsed     time.Duration // give up after this long, 0 means no limit
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64          // randomization factor in [0, 1]
+	Retryable      func(error) bool // error classification, nil means DefaultRetryable
+}
+
+// DefaultRetryPolicy keeps trying for gfs.ClientTryTimeout, which is long
+// enough for the master to grant a new lease when a primary fails.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxElapsed:     gfs.ClientTryTimeout,
+	InitialBackoff: 50 * time.Millisecond,
+	MaxBackoff:     1 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// NoRetry makes every rpc be attempted exactly once.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// DefaultRetryable reports whether err is worth retrying. Context errors,
+// uncoded errors returned by the handlers and definite answers such as
+// EOF, chunk size exceeded or path not found are not retryable. Other errors
+// are, InMaintenance included, as master leaves maintenance after a while.
+func DefaultRetryable(err error) bool {
+	if err == nil || err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+	var e gfs.Error
+	if errors.As(err, &e) {
+		switch e.Code {
+		case gfs.ReadEOF, gfs.AppendExceedChunkSize, gfs.WriteExceedChunkSize,
+			gfs.PathNotFound, gfs.PathExists, gfs.NotDirectory, gfs.IsDirectory, gfs.InvalidArgument,
+			gfs.DirectoryNotEmpty, gfs.PermissionDenied, gfs.Unauthenticated, gfs.QuotaExceeded, gfs.ReadOnly:
+			return false
+		}
+		return true
+	}
+	var se rpc.ServerError
+	return !errors.As(err, &se)
+}
+
+// backoff returns the delay before the attempt-th retry (starting from 1).
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || d < float64(p.MaxBackoff)); i++ {
+		d *= p.Multiplier
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithRetryPolicy sets the retry policy of all client rpc paths.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) {
+		c.retryPolicy = p
+	}
+}
+
+// retry calls f until it succeeds, returns an error not retryable,
+// ctx is done or the retry policy gives up. The last error is returned.
+func (c *Client) retry(ctx context.Context, op string, f func() error) error {
+	p := c.retryPolicy
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = DefaultRetryable
+	}
+
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return err
+		}
+		wait := p.backoff(attempt)
+		if p.MaxElapsed > 0 && time.Since(start)+wait > p.MaxElapsed {
+			return err
+		}
+
+		util.TraceLog(util.TraceID(ctx)).Warning(op, " error, try again: ", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// call is util.Call with the retry policy of the client applied.
+func (c *Client) call(ctx context.Context, srv gfs.ServerAddress, rpcname string, args interface{}, reply interface{}) error {
+	return c.retry(ctx, rpcname, func() error {
+		return util.Call(ctx, srv, rpcname, args, reply)
+	})
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"io"
+
+	"gfs"
+)
+
+// WriteFrom writes the data of r to a file from offset on, a chunk at a
+// time, so that no more than a chunk of it is held in memory. It returns the
+// bytes written, those of the pieces written before an error included.
+func (c *Client) WriteFrom(ctx context.Context, path gfs.Path, offset gfs.Offset, r io.Reader) (int64, error) {
+	info, err := c.Stat(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	if info.IsDir {
+		return 0, gfs.Error{gfs.IsDirectory, "cannot write directory " + string(path)}
+	}
+	size := gfs.Offset(info.ChunkSize)
+	buf := make([]byte, size)
+
+	var written int64
+	for {
+		// pieces end on chunk boundaries
+		piece := buf[:size-offset%size]
+		n, err := io.ReadFull(r, piece)
+		if n > 0 {
+			if _, werr := c.Write(ctx, path, offset, piece[:n]); werr != nil {
+				return written, werr
+			}
+			offset += gfs.Offset(n)
+			written += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// ReadTo copies length bytes of a file from offset on to w, a chunk at a
+// time, so that no more than a chunk of it is held in memory. A negative
+// length copies up to the end of the file, which is not an error. It returns
+// the bytes copied.
+func (c *Client) ReadTo(ctx context.Context, path gfs.Path, offset gfs.Offset, length int64, w io.Writer) (int64, error) {
+	info, err := c.Stat(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	if info.IsDir {
+		return 0, gfs.Error{gfs.IsDirectory, "cannot read directory " + string(path)}
+	}
+	size := gfs.Offset(info.ChunkSize)
+	buf := make([]byte, size)
+
+	var copied int64
+	for length < 0 || copied < length {
+		piece := buf[:size-offset%size]
+		if length >= 0 && int64(len(piece)) > length-copied {
+			piece = piece[:length-copied]
+		}
+		n, err := c.Read(ctx, path, offset, piece)
+		if n > 0 {
+			if _, werr := w.Write(piece[:n]); werr != nil {
+				return copied, werr
+			}
+			offset += gfs.Offset(n)
+			copied += int64(n)
+		}
+		if err == io.EOF {
+			return copied, nil
+		}
+		if err != nil {
+			return copied, err
+		}
+	}
+	return copied, nil
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"gfs"
+	"gfs/util"
+)
+
+type durableKey struct{}
+
+// Durable returns ctx whose writes and appends are synced to disk on the
+// primary and the secondaries before they return, rather than when the
+// journal of the chunkservers is synced by their durability mode.
+func Durable(ctx context.Context) context.Context {
+	return context.WithValue(ctx, durableKey{}, true)
+}
+
+func isDurable(ctx context.Context) bool {
+	durable, _ := ctx.Value(durableKey{}).(bool)
+	return durable
+}
+
+// Sync syncs the chunks of a file to disk on all their replicas, so that the
+// writes and appends returned before survive a crash of the chunkservers.
+// The chunks are synced at once, see WithParallelism.
+func (c *Client) Sync(ctx context.Context, path gfs.Path) (err error) {
+	ctx, end := c.trace(ctx, "Sync")
+	defer func() { end(err) }()
+
+	var f gfs.GetFileInfoReply
+	if err = c.call(ctx, c.master, "Master.RPCGetFileInfo", gfs.GetFileInfoArg{path, c.cred}, &f); err != nil {
+		return err
+	}
+	if f.IsDir {
+		return gfs.Error{gfs.IsDirectory, "cannot sync directory " + string(path)}
+	}
+	for _, err := range c.forChunks(int(f.Chunks), func(i int) error {
+		return c.syncChunk(ctx, path, gfs.ChunkIndex(i))
+	}) {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncChunk syncs chunk index of a file on all its replicas.
+func (c *Client) syncChunk(ctx context.Context, path gfs.Path, index gfs.ChunkIndex) error {
+	handle, err := c.getChunkHandle(ctx, path, index, false)
+	if err != nil {
+		return err
+	}
+	locations, token, err := c.locBuf.Get(ctx, handle)
+	if err != nil {
+		return wrapError(err)
+	}
+	if len(locations) == 0 {
+		return gfs.Error{gfs.NoReplica, fmt.Sprintf("no replica of chunk %v", handle)}
+	}
+	errs := make(chan error, len(locations))
+	for _, loc := range locations {
+		go func(loc gfs.ServerAddress) {
+			errs <- util.Call(ctx, loc, "ChunkServer.RPCSyncChunk", gfs.SyncChunkArg{handle, token}, &gfs.SyncChunkReply{})
+		}(loc)
+	}
+	for range locations {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		c.locBuf.Invalidate(handle)
+		return wrapError(err)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+
+	"gfs"
+	"gfs/util"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Replicas of a chunk are verified against each other by comparing the
+// checksums of their data, either of each range read, see
+// WithVerifiedReads, or of whole chunks, see VerifyFile. The replicas
+// disagreeing with the others are reported to master, which checks the data
+// of the replicas itself and drops those outvoted once no mutation is in
+// flight. Replicas that cannot be read are left to the other checks.
+
+// WithVerifiedReads makes the client read each range of a chunk from all
+// its replicas and compare them, a read failing with gfs.ChecksumMismatch if
+// they disagree, retried by the retry policy until master has dropped the
+// replicas outvoted. It is meant for debugging consistency, at the cost of
+// reading every chunk from every replica; hedged and local reads are off.
+func WithVerifiedReads() Option {
+	return func(c *Client) {
+		c.verifyReads = true
+	}
+}
+
+// outvoted returns the indexes of the keys that differ from the one most of
+// them have, all of them if none has a majority, or none if they agree.
+func outvoted(keys []string) []int {
+	votes := make(map[string]int)
+	for _, k := range keys {
+		votes[k]++
+	}
+	if len(votes) <= 1 {
+		return nil
+	}
+	var winner string
+	found := false
+	for k, n := range votes {
+		if 2*n > len(keys) {
+			winner, found = k, true
+		}
+	}
+	var ret []int
+	for i, k := range keys {
+		if !found || k != winner {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+// readVerified reads len(data) bytes of a chunk at offset from all the
+// replicas locs and compares them, up to the shortest one read.
+func (c *Client) readVerified(ctx context.Context, handle gfs.ChunkHandle, locs []gfs.ServerAddress, offset gfs.Offset, data []byte, token string) (int, error) {
+	bufs := make([][]byte, len(locs))
+	ns := make([]int, len(locs))
+	errs := make([]error, len(locs))
+	var wg sync.WaitGroup
+	wg.Add(len(locs))
+	for i, loc := range locs {
+		go func(i int, loc gfs.ServerAddress) {
+			defer wg.Done()
+			bufs[i] = make([]byte, len(data))
+			ns[i], errs[i] = c.readReplica(ctx, handle, loc, offset, bufs[i], token)
+		}(i, loc)
+	}
+	wg.Wait()
+
+	var read []int // replicas read
+	shortest := len(data)
+	for i, err := range errs {
+		if err == nil || errors.Is(err, gfs.ReadEOF) {
+			read = append(read, i)
+			if ns[i] < shortest {
+				shortest = ns[i]
+			}
+		} else {
+			log.Warningf("verified read of chunk %v from %v failed: %v", handle, locs[i], err)
+		}
+	}
+	if len(read) == 0 {
+		c.locBuf.Invalidate(handle)
+		return 0, errs[len(errs)-1]
+	}
+	keys := make([]string, len(read))
+	for j, i := range read {
+		sum := sha256.Sum256(bufs[i][:shortest])
+		keys[j] = string(sum[:])
+	}
+	if bad := outvoted(keys); len(bad) > 0 {
+		var divergent []gfs.ServerAddress
+		for _, j := range bad {
+			divergent = append(divergent, locs[read[j]])
+			go c.reportDivergence(gfs.ReportDivergenceArg{handle, locs[read[j]], 0, 0, gfs.DivergentData, token})
+		}
+		return 0, gfs.Error{gfs.ChecksumMismatch, fmt.Sprintf("replicas %v of chunk %v disagree with the others on %v bytes at %v", divergent, handle, shortest, offset)}
+	}
+	i := read[0]
+	copy(data, bufs[i][:ns[i]])
+	return ns[i], errs[i]
+}
+
+// VerifyFile compares the replicas of each chunk of a file by the hash of
+// their data, see GetFileHash, and returns the replicas disagreeing with the
+// others, in order of the chunks: those of an older version, those shorter
+// than another of the current version, and those outvoted on their data,
+// all of these if no data has a majority. They are reported to master. The
+// chunks are verified at once, see WithParallelism; a chunk mutated
+// meanwhile may show divergences master does not confirm.
+func (c *Client) VerifyFile(ctx context.Context, path gfs.Path) (divergences []gfs.ReplicaDivergence, err error) {
+	ctx, end := c.trace(ctx, "VerifyFile")
+	defer func() { end(err) }()
+
+	var f gfs.GetFileInfoReply
+	if err = c.call(ctx, c.master, "Master.RPCGetFileInfo", gfs.GetFileInfoArg{path, c.cred}, &f); err != nil {
+		return nil, err
+	}
+	if f.IsDir {
+		return nil, gfs.Error{gfs.IsDirectory, "cannot verify directory " + string(path)}
+	}
+	found := make([][]gfs.ReplicaDivergence, f.Chunks)
+	for _, err := range c.forChunks(int(f.Chunks), func(i int) (err error) {
+		found[i], err = c.verifyChunk(ctx, path, gfs.ChunkIndex(i))
+		return err
+	}) {
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, ds := range found {
+		divergences = append(divergences, ds...)
+	}
+	return divergences, nil
+}
+
+// verifyChunk compares the replicas of chunk index of a file by their hash.
+func (c *Client) verifyChunk(ctx context.Context, path gfs.Path, index gfs.ChunkIndex) ([]gfs.ReplicaDivergence, error) {
+	handle, err := c.getChunkHandle(ctx, path, index, false)
+	if err != nil {
+		return nil, err
+	}
+	locations, token, err := c.locBuf.Get(ctx, handle)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	replies := make([]*gfs.ChunkHashReply, len(locations))
+	var wg sync.WaitGroup
+	wg.Add(len(locations))
+	for i, loc := range locations {
+		go func(i int, loc gfs.ServerAddress) {
+			defer wg.Done()
+			var r gfs.ChunkHashReply
+			if err := util.Call(ctx, loc, "ChunkServer.RPCChunkHash", gfs.ChunkHashArg{handle, token, c.id, false}, &r); err != nil {
+				log.Warningf("hash of chunk %v from %v failed: %v", handle, loc, err)
+				return
+			}
+			replies[i] = &r
+		}(i, loc)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var version gfs.ChunkVersion
+	var longest gfs.Offset
+	for _, r := range replies {
+		if r != nil && r.Version > version {
+			version, longest = r.Version, 0
+		}
+		if r != nil && r.Version == version && r.Length > longest {
+			longest = r.Length
+		}
+	}
+	divergence := make(map[int]gfs.Divergence)
+	var current []int // replicas of the current version and length
+	var keys []string
+	for i, r := range replies {
+		switch {
+		case r == nil:
+		case r.Version < version:
+			divergence[i] = gfs.DivergentVersion
+		case r.Length < longest:
+			divergence[i] = gfs.DivergentLength
+		default:
+			current = append(current, i)
+			keys = append(keys, string(r.Sum))
+		}
+	}
+	for _, j := range outvoted(keys) {
+		divergence[current[j]] = gfs.DivergentData
+	}
+
+	var ret []gfs.ReplicaDivergence
+	for i, r := range replies {
+		if d, ok := divergence[i]; ok {
+			ret = append(ret, gfs.ReplicaDivergence{index, handle, locations[i], d})
+			c.reportDivergence(gfs.ReportDivergenceArg{handle, locations[i], r.Version, r.Length, d, token})
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+
+	"gfs"
+)
+
+// SetXattr sets the extended attribute name of a file or a directory to
+// value, replacing the one set. It takes write permission on the path, and
+// the names and values of a path are gfs.XattrMaxBytes at most.
+func (c *Client) SetXattr(ctx context.Context, path gfs.Path, name string, value []byte) error {
+	var reply gfs.SetXattrReply
+	return c.call(ctx, c.master, "Master.RPCSetXattr", gfs.SetXattrArg{path, name, value, c.cred}, &reply)
+}
+
+// GetXattr returns the value of the extended attribute name of a file or a
+// directory, gfs.XattrNotFound if it is not set.
+func (c *Client) GetXattr(ctx context.Context, path gfs.Path, name string) ([]byte, error) {
+	var reply gfs.GetXattrReply
+	if err := c.call(ctx, c.master, "Master.RPCGetXattr", gfs.GetXattrArg{path, name, c.cred}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Value, nil
+}
+
+// ListXattrs returns the names of the extended attributes of a file or a
+// directory, sorted.
+func (c *Client) ListXattrs(ctx context.Context, path gfs.Path) ([]string, error) {
+	var reply gfs.ListXattrsReply
+	if err := c.call(ctx, c.master, "Master.RPCListXattrs", gfs.ListXattrsArg{path, c.cred}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Names, nil
+}
+
+// RemoveXattr removes the extended attribute name of a file or a
+// directory, gfs.XattrNotFound if it is not set.
+func (c *Client) RemoveXattr(ctx context.Context, path gfs.Path, name string) error {
+	var reply gfs.RemoveXattrReply
+	return c.call(ctx, c.master, "Master.RPCRemoveXattr", gfs.RemoveXattrArg{path, name, c.cred}, &reply)
+}
@@ -0,0 +1,804 @@
+// Package clientfake provides an in-memory fake of the gfs client.
+// It implements gfs/client.ClientAPI and the chunk level APIs without any master or
+// chunkserver, so applications built on gfs can be unit tested.
+// Record append semantics (padding, at-least-once) are kept, and can be
+// made adverse by fault injection.
+package clientfake
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gfs"
+	"gfs/client"
+)
+
+var _ client.ClientAPI = (*Client)(nil)
+
+// Faults configures fault injection. Probabilities are in [0, 1].
+type Faults struct {
+	// DuplicateAppend is the probability that a record is appended twice,
+	// as a real client does when it retries after a partial failure.
+	DuplicateAppend float64
+	// PadAppend is the probability that the last chunk is padded before a record append,
+	// so that the record lands at the beginning of a new chunk.
+	PadAppend float64
+	// ShortRead is the probability that a read stops at the next chunk boundary
+	// and returns io.EOF, as readers of a file being appended may see.
+	ShortRead float64
+	// Fail is the probability that an operation fails with gfs.UnknownError.
+	Fail float64
+	// Seed is the seed of the random source deciding faults.
+	Seed int64
+}
+
+type chunk struct {
+	handle gfs.ChunkHandle
+	data   []byte     // data[:len(data)] is written, data beyond is zero
+	length gfs.Offset // length of the chunk, including padding
+}
+
+type node struct {
+	isDir     bool
+	length    int64 // raised by writes, holes read as zeros
+	chunks    []*chunk
+	chunkSize int64 // reported by Stat, the chunks are gfs.MaxChunkSize
+	replicas  int
+	mode      os.FileMode
+	mtime     time.Time
+	xattrs    map[string][]byte
+}
+
+// Client is an in-memory fake of gfs/client.Client. It is safe for concurrent use.
+type Client struct {
+	sync.Mutex
+	faults     Faults
+	rand       *rand.Rand
+	nodes      map[gfs.Path]*node
+	chunk      map[gfs.ChunkHandle]*chunk
+	nextHandle gfs.ChunkHandle
+}
+
+// NewClient returns a fake client with an empty namespace.
+func NewClient(faults Faults) *Client {
+	return &Client{
+		faults: faults,
+		rand:   rand.New(rand.NewSource(faults.Seed)),
+		nodes:  map[gfs.Path]*node{"/": {isDir: true, mode: gfs.DefaultDirMode, mtime: time.Now()}},
+		chunk:  make(map[gfs.ChunkHandle]*chunk),
+	}
+}
+
+// SetFaults replaces the fault injection configuration, the random source is kept.
+func (c *Client) SetFaults(faults Faults) {
+	c.Lock()
+	defer c.Unlock()
+	c.faults = faults
+}
+
+// happen draws whether a fault of probability p happens. c should be locked.
+func (c *Client) happen(p float64) bool {
+	return p > 0 && c.rand.Float64() < p
+}
+
+// fail returns an injected error, or ctx.Err() if ctx is done. c should be locked.
+func (c *Client) fail(ctx context.Context, op string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.happen(c.faults.Fail) {
+		return gfs.Error{gfs.UnknownError, op + ": injected failure"}
+	}
+	return nil
+}
+
+// parent returns the directory containing p
+func parent(p gfs.Path) gfs.Path {
+	i := strings.LastIndex(string(p), "/")
+	if i <= 0 {
+		return "/"
+	}
+	return p[:i]
+}
+
+// newNode adds a file or directory at p. All parents should exist. c should be locked.
+func (c *Client) newNode(p gfs.Path, isDir bool) error {
+	if _, ok := c.nodes[p]; ok {
+		return gfs.Error{gfs.PathExists, fmt.Sprintf("path %s already exists", p)}
+	}
+	if dir, ok := c.nodes[parent(p)]; !ok || !dir.isDir {
+		return gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %s not found", parent(p))}
+	}
+	n := &node{isDir: isDir, mode: gfs.DefaultDirMode, mtime: time.Now()}
+	if !isDir {
+		n.chunkSize, n.replicas, n.mode = gfs.MaxChunkSize, gfs.DefaultNumReplicas, gfs.DefaultFileMode
+	}
+	c.nodes[p] = n
+	return nil
+}
+
+// file returns the file at p. c should be locked.
+func (c *Client) file(p gfs.Path) (*node, error) {
+	f, ok := c.nodes[p]
+	if !ok {
+		return nil, gfs.Error{gfs.PathNotFound, fmt.Sprintf("File %v does not exist", p)}
+	}
+	if f.isDir {
+		return nil, gfs.Error{gfs.IsDirectory, fmt.Sprintf("path %s is a directory, not a file", p)}
+	}
+	return f, nil
+}
+
+// Create creates a file
+func (c *Client) Create(ctx context.Context, path gfs.Path) error {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "Create"); err != nil {
+		return err
+	}
+	return c.newNode(path, false)
+}
+
+// CreateWithChunkSize creates a file whose chunk size, one of
+// gfs.ChunkSizes, is reported by Stat.
+func (c *Client) CreateWithChunkSize(ctx context.Context, path gfs.Path, chunkSize int64) error {
+	if !gfs.ValidChunkSize(chunkSize) {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("chunk size %v is not one of %v", chunkSize, gfs.ChunkSizes)}
+	}
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "Create"); err != nil {
+		return err
+	}
+	if err := c.newNode(path, false); err != nil {
+		return err
+	}
+	c.nodes[path].chunkSize = chunkSize
+	return nil
+}
+
+// Mkdir makes a directory
+func (c *Client) Mkdir(ctx context.Context, path gfs.Path) error {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "Mkdir"); err != nil {
+		return err
+	}
+	return c.newNode(path, true)
+}
+
+// Delete deletes a file or a directory with everything inside
+func (c *Client) Delete(ctx context.Context, path gfs.Path) error {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "Delete"); err != nil {
+		return err
+	}
+	_, _, err := c.remove(path)
+	return err
+}
+
+// DeleteAll deletes a file or a directory with everything inside, and
+// returns the files and directories deleted.
+func (c *Client) DeleteAll(ctx context.Context, path gfs.Path) (files, dirs int, err error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "DeleteAll"); err != nil {
+		return 0, 0, err
+	}
+	return c.remove(path)
+}
+
+// remove removes path and everything inside. c should be locked.
+func (c *Client) remove(path gfs.Path) (files, dirs int, err error) {
+	if path == "/" {
+		return 0, 0, gfs.Error{gfs.InvalidArgument, "cannot delete root"}
+	}
+	if _, ok := c.nodes[path]; !ok {
+		return 0, 0, gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %s not found", path)}
+	}
+	for p, n := range c.nodes {
+		if p == path || strings.HasPrefix(string(p), string(path)+"/") {
+			for _, ck := range n.chunks {
+				delete(c.chunk, ck.handle)
+			}
+			delete(c.nodes, p)
+			if n.isDir {
+				dirs++
+			} else {
+				files++
+			}
+		}
+	}
+	return files, dirs, nil
+}
+
+// Rename renames a file or a directory
+func (c *Client) Rename(ctx context.Context, source gfs.Path, target gfs.Path) error {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "Rename"); err != nil {
+		return err
+	}
+	if _, ok := c.nodes[source]; !ok || source == "/" {
+		return gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %s not found", source)}
+	}
+	if strings.HasPrefix(string(target), string(source)+"/") {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("cannot move %s into itself", source)}
+	}
+	if err := c.newNode(target, false); err != nil {
+		return err
+	}
+	moved := make(map[gfs.Path]*node)
+	for p, n := range c.nodes {
+		if p == source || strings.HasPrefix(string(p), string(source)+"/") {
+			delete(c.nodes, p)
+			moved[target+p[len(source):]] = n
+		}
+	}
+	for p, n := range moved {
+		c.nodes[p] = n
+	}
+	return nil
+}
+
+// List lists all files in specific directory
+func (c *Client) List(ctx context.Context, path gfs.Path) ([]gfs.PathInfo, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "List"); err != nil {
+		return nil, err
+	}
+	dir, ok := c.nodes[path]
+	if !ok {
+		return nil, gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %s not found", path)}
+	}
+	if !dir.isDir {
+		return nil, gfs.Error{gfs.NotDirectory, fmt.Sprintf("path %s is a file, not directory", path)}
+	}
+
+	ls := make([]gfs.PathInfo, 0)
+	for p, n := range c.nodes {
+		if p != "/" && parent(p) == path {
+			name := string(p[strings.LastIndex(string(p), "/")+1:])
+			info := gfs.PathInfo{Name: name, IsDir: n.isDir, Chunks: int64(len(n.chunks))}
+			for _, ck := range n.chunks {
+				info.Length += int64(ck.length)
+			}
+			ls = append(ls, info)
+		}
+	}
+	sort.Slice(ls, func(i, j int) bool { return ls[i].Name < ls[j].Name })
+	return ls, nil
+}
+
+// info returns the information of n at p. c should be locked.
+func (c *Client) info(p gfs.Path, n *node) gfs.FileInfo {
+	return gfs.FileInfo{p, n.isDir, n.length, int64(len(n.chunks)), n.chunkSize, n.replicas, n.mtime, n.mtime, n.mtime, "", "", n.mode}
+}
+
+// Stat returns the information of a file or a directory. The times are
+// those of the last write, there are no owners.
+func (c *Client) Stat(ctx context.Context, path gfs.Path) (gfs.FileInfo, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "Stat"); err != nil {
+		return gfs.FileInfo{}, err
+	}
+	n, ok := c.nodes[path]
+	if !ok {
+		return gfs.FileInfo{}, gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %s not found", path)}
+	}
+	return c.info(path, n), nil
+}
+
+// Walk calls fn on the files and directories under path in depth-first
+// order, the children of a directory sorted by name, or on path alone if it
+// is a file. The tree is taken at once, fn is called unlocked.
+func (c *Client) Walk(ctx context.Context, path gfs.Path, fn func(gfs.FileInfo) error) error {
+	c.Lock()
+	if err := c.fail(ctx, "Walk"); err != nil {
+		c.Unlock()
+		return err
+	}
+	n, ok := c.nodes[path]
+	if !ok {
+		c.Unlock()
+		return gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %s not found", path)}
+	}
+	var infos []gfs.FileInfo
+	if n.isDir {
+		infos = c.walk(path, infos)
+	} else {
+		infos = append(infos, c.info(path, n))
+	}
+	c.Unlock()
+
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walk appends the information of the entries inside the directory dir to
+// infos, in the order of Walk. c should be locked.
+func (c *Client) walk(dir gfs.Path, infos []gfs.FileInfo) []gfs.FileInfo {
+	var children []gfs.Path
+	for p := range c.nodes {
+		if p != "/" && parent(p) == dir {
+			children = append(children, p)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i] < children[j] })
+	for _, p := range children {
+		n := c.nodes[p]
+		infos = append(infos, c.info(p, n))
+		if n.isDir {
+			infos = c.walk(p, infos)
+		}
+	}
+	return infos
+}
+
+// Chmod sets the permission bits of a file or a directory. They are only
+// reported by Stat.
+func (c *Client) Chmod(ctx context.Context, path gfs.Path, mode os.FileMode) error {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "Chmod"); err != nil {
+		return err
+	}
+	n, ok := c.nodes[path]
+	if !ok {
+		return gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %s not found", path)}
+	}
+	n.mode = mode.Perm()
+	return nil
+}
+
+// SetReplication sets the number of replicas of a file, which is only
+// reported by Stat.
+func (c *Client) SetReplication(ctx context.Context, path gfs.Path, replicas int) error {
+	if replicas < 1 || replicas > gfs.MaxNumReplicas {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("replication %v is not within [1, %v]", replicas, gfs.MaxNumReplicas)}
+	}
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "SetReplication"); err != nil {
+		return err
+	}
+	f, err := c.file(path)
+	if err != nil {
+		return err
+	}
+	f.replicas = replicas
+	return nil
+}
+
+// SetXattr sets the extended attribute name of a file or a directory to
+// value, replacing the one set.
+func (c *Client) SetXattr(ctx context.Context, path gfs.Path, name string, value []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "SetXattr"); err != nil {
+		return err
+	}
+	n, ok := c.nodes[path]
+	if !ok {
+		return gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %s not found", path)}
+	}
+	size := len(name) + len(value)
+	for k, v := range n.xattrs {
+		if k != name {
+			size += len(k) + len(v)
+		}
+	}
+	if size > gfs.XattrMaxBytes {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("xattrs of %v would take %v bytes > %v", path, size, gfs.XattrMaxBytes)}
+	}
+	if n.xattrs == nil {
+		n.xattrs = make(map[string][]byte)
+	}
+	n.xattrs[name] = append([]byte{}, value...)
+	return nil
+}
+
+// GetXattr returns the value of the extended attribute name of a file or a
+// directory, gfs.XattrNotFound if it is not set.
+func (c *Client) GetXattr(ctx context.Context, path gfs.Path, name string) ([]byte, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "GetXattr"); err != nil {
+		return nil, err
+	}
+	n, ok := c.nodes[path]
+	if !ok {
+		return nil, gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %s not found", path)}
+	}
+	v, ok := n.xattrs[name]
+	if !ok {
+		return nil, gfs.Error{gfs.XattrNotFound, fmt.Sprintf("xattr %v of %v not found", name, path)}
+	}
+	return append([]byte{}, v...), nil
+}
+
+// Open opens a file for reading and writing, see client.OpenFile.
+func (c *Client) Open(ctx context.Context, path gfs.Path) (*client.File, error) {
+	return client.OpenFile(ctx, c, path)
+}
+
+// getChunk returns the index-th chunk of f, creating it if index is
+// the number of chunks of f. c should be locked.
+func (c *Client) getChunk(f *node, path gfs.Path, index gfs.ChunkIndex) (*chunk, error) {
+	if int(index) == len(f.chunks) {
+		ck := &chunk{handle: c.nextHandle}
+		c.nextHandle++
+		c.chunk[ck.handle] = ck
+		f.chunks = append(f.chunks, ck)
+	}
+	if index < 0 || int(index) >= len(f.chunks) {
+		return nil, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("Invalid index for %v[%v]", path, index)}
+	}
+	return f.chunks[index], nil
+}
+
+// read copies chunk data at offset into data. It returns gfs.ReadEOF if
+// the chunk ends before data is filled.
+func (ck *chunk) read(offset gfs.Offset, data []byte) (int, error) {
+	if gfs.Offset(len(data)) > gfs.MaxChunkSize-offset {
+		data = data[:gfs.MaxChunkSize-offset]
+	}
+	n := 0
+	if offset < ck.length {
+		n = int(ck.length - offset)
+		if n > len(data) {
+			n = len(data)
+		}
+		for i := range data[:n] {
+			data[i] = 0
+		}
+		if offset < gfs.Offset(len(ck.data)) {
+			copy(data[:n], ck.data[offset:])
+		}
+	}
+	if n < len(data) {
+		return n, gfs.Error{gfs.ReadEOF, "read EOF"}
+	}
+	return n, nil
+}
+
+// write writes data at offset of the chunk, extending it if needed.
+func (ck *chunk) write(offset gfs.Offset, data []byte) {
+	end := offset + gfs.Offset(len(data))
+	if end > gfs.Offset(len(ck.data)) {
+		ck.data = append(ck.data, make([]byte, int(end)-len(ck.data))...)
+	}
+	copy(ck.data[offset:], data)
+	if end > ck.length {
+		ck.length = end
+	}
+}
+
+// append appends data to the chunk. If it does not fit, the chunk is
+// padded to max chunk size and gfs.AppendExceedChunkSize is returned.
+func (ck *chunk) append(data []byte) (gfs.Offset, error) {
+	offset := ck.length
+	if offset+gfs.Offset(len(data)) > gfs.MaxChunkSize {
+		ck.length = gfs.MaxChunkSize
+		return offset, gfs.Error{gfs.AppendExceedChunkSize, "append over chunks"}
+	}
+	ck.write(offset, data)
+	return offset, nil
+}
+
+// Read reads file at specific offset.
+// it reads up to len(data) bytes form the File. it return the number of bytes and an error.
+// the error is set to io.EOF if stream meets the end of file
+func (c *Client) Read(ctx context.Context, path gfs.Path, offset gfs.Offset, data []byte) (n int, err error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "Read"); err != nil {
+		return -1, err
+	}
+	f, err := c.file(path)
+	if err != nil {
+		return -1, err
+	}
+	if int(offset/gfs.MaxChunkSize) > len(f.chunks) {
+		return -1, gfs.Error{gfs.InvalidArgument, "read offset exceeds file size"}
+	}
+
+	pos := 0
+	for pos < len(data) {
+		index := int(offset / gfs.MaxChunkSize)
+		if index >= len(f.chunks) {
+			return pos, io.EOF
+		}
+		if pos > 0 && offset%gfs.MaxChunkSize == 0 && c.happen(c.faults.ShortRead) {
+			return pos, io.EOF
+		}
+		n, err := f.chunks[index].read(offset%gfs.MaxChunkSize, data[pos:])
+		offset += gfs.Offset(n)
+		pos += n
+		if err != nil && int64(offset) < f.length {
+			// a hole up to the end of the chunk or the file
+			end := gfs.Offset(index+1) * gfs.MaxChunkSize
+			if end > gfs.Offset(f.length) {
+				end = gfs.Offset(f.length)
+			}
+			n = len(data) - pos
+			if gfs.Offset(n) > end-offset {
+				n = int(end - offset)
+			}
+			for i := range data[pos : pos+n] {
+				data[pos+i] = 0
+			}
+			offset += gfs.Offset(n)
+			pos += n
+		} else if err != nil {
+			return pos, io.EOF
+		}
+	}
+	return pos, nil
+}
+
+// Write writes data to file at specific offset. Writing beyond the end
+// of file leaves a hole. The length of the file is returned.
+func (c *Client) Write(ctx context.Context, path gfs.Path, offset gfs.Offset, data []byte) (int64, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "Write"); err != nil {
+		return 0, err
+	}
+	f, err := c.file(path)
+	if err != nil {
+		return 0, err
+	}
+
+	// allocate the chunks of the hole
+	for int(offset/gfs.MaxChunkSize) > len(f.chunks) {
+		c.getChunk(f, path, gfs.ChunkIndex(len(f.chunks)))
+	}
+
+	for begin := 0; begin < len(data); {
+		ck, err := c.getChunk(f, path, gfs.ChunkIndex(offset/gfs.MaxChunkSize))
+		if err != nil {
+			return 0, err
+		}
+		chunkOffset := offset % gfs.MaxChunkSize
+		writeLen := int(gfs.MaxChunkSize - chunkOffset)
+		if begin+writeLen > len(data) {
+			writeLen = len(data) - begin
+		}
+		ck.write(chunkOffset, data[begin:begin+writeLen])
+		offset += gfs.Offset(writeLen)
+		begin += writeLen
+	}
+	if int64(offset) > f.length {
+		f.length = int64(offset)
+	}
+	f.mtime = time.Now()
+	return f.length, nil
+}
+
+// Append appends data to file. Offset of the beginning of the data is returned.
+func (c *Client) Append(ctx context.Context, path gfs.Path, data []byte) (offset gfs.Offset, err error) {
+	if len(data) > gfs.MaxAppendSize {
+		return 0, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("len(data) = %v > max append size %v", len(data), gfs.MaxAppendSize)}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "Append"); err != nil {
+		return 0, err
+	}
+	f, err := c.file(path)
+	if err != nil {
+		return 0, err
+	}
+
+	index := gfs.ChunkIndex(len(f.chunks) - 1)
+	if index < 0 {
+		index = 0
+	}
+	times := 1
+	if c.happen(c.faults.DuplicateAppend) {
+		times = 2
+	}
+	for i := 0; i < times; i++ {
+		var chunkOffset gfs.Offset
+		for {
+			ck, err := c.getChunk(f, path, index)
+			if err != nil {
+				return 0, err
+			}
+			if ck.length > 0 && ck.length < gfs.MaxChunkSize && c.happen(c.faults.PadAppend) {
+				ck.length = gfs.MaxChunkSize
+			}
+			chunkOffset, err = ck.append(data)
+			if err == nil {
+				break
+			}
+			index++ // retry in next chunk
+		}
+		offset = gfs.Offset(index)*gfs.MaxChunkSize + chunkOffset
+	}
+	f.mtime = time.Now()
+	return offset, nil
+}
+
+// streamPiece is the piece of data held by ReadTo and WriteFrom.
+const streamPiece = 1 << 20
+
+// WriteFrom writes the data of r to a file from offset on, a piece at a
+// time. It returns the bytes written, those of the pieces written before an
+// error included.
+func (c *Client) WriteFrom(ctx context.Context, path gfs.Path, offset gfs.Offset, r io.Reader) (int64, error) {
+	info, err := c.Stat(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	if info.IsDir {
+		return 0, gfs.Error{gfs.IsDirectory, "cannot write directory " + string(path)}
+	}
+	buf := make([]byte, streamPiece)
+	var written int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if _, werr := c.Write(ctx, path, offset, buf[:n]); werr != nil {
+				return written, werr
+			}
+			offset += gfs.Offset(n)
+			written += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// ReadTo copies length bytes of a file from offset on to w, a piece at a
+// time. A negative length copies up to the end of the file, which is not an
+// error. It returns the bytes copied.
+func (c *Client) ReadTo(ctx context.Context, path gfs.Path, offset gfs.Offset, length int64, w io.Writer) (int64, error) {
+	buf := make([]byte, streamPiece)
+	var copied int64
+	for length < 0 || copied < length {
+		piece := buf
+		if length >= 0 && int64(len(piece)) > length-copied {
+			piece = piece[:length-copied]
+		}
+		n, err := c.Read(ctx, path, offset, piece)
+		if n > 0 {
+			if _, werr := w.Write(piece[:n]); werr != nil {
+				return copied, werr
+			}
+			offset += gfs.Offset(n)
+			copied += int64(n)
+		}
+		if err == io.EOF {
+			return copied, nil
+		}
+		if err != nil {
+			return copied, err
+		}
+	}
+	return copied, nil
+}
+
+// GetFileHash returns the content hash of a file as the real client does,
+// the SHA-256 of the SHA-256 of each chunk, though of chunks of
+// gfs.MaxChunkSize whatever the chunk size of the file.
+func (c *Client) GetFileHash(ctx context.Context, path gfs.Path) (h gfs.FileHash, err error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "GetFileHash"); err != nil {
+		return h, err
+	}
+	f, err := c.file(path)
+	if err != nil {
+		return h, err
+	}
+	sum := sha256.New()
+	for _, ck := range f.chunks {
+		data := make([]byte, ck.length)
+		copy(data, ck.data)
+		chunkSum := sha256.Sum256(data)
+		sum.Write(chunkSum[:])
+		h.Size += int64(ck.length)
+	}
+	h.Sum, h.Chunks = sum.Sum(nil), int64(len(f.chunks))
+	return h, nil
+}
+
+// GetChunkHandle returns the chunk handle of (path, index).
+// If the chunk doesn't exist, a new one is created.
+func (c *Client) GetChunkHandle(ctx context.Context, path gfs.Path, index gfs.ChunkIndex) (gfs.ChunkHandle, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "GetChunkHandle"); err != nil {
+		return 0, err
+	}
+	f, err := c.file(path)
+	if err != nil {
+		return 0, err
+	}
+	ck, err := c.getChunk(f, path, index)
+	if err != nil {
+		return 0, err
+	}
+	return ck.handle, nil
+}
+
+// getHandle returns the chunk of handle. c should be locked.
+func (c *Client) getHandle(handle gfs.ChunkHandle) (*chunk, error) {
+	ck, ok := c.chunk[handle]
+	if !ok {
+		return nil, gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("cannot find chunk %v", handle)}
+	}
+	return ck, nil
+}
+
+// ReadChunk reads data from the chunk at specific offset.
+func (c *Client) ReadChunk(ctx context.Context, handle gfs.ChunkHandle, offset gfs.Offset, data []byte) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "ReadChunk"); err != nil {
+		return 0, err
+	}
+	ck, err := c.getHandle(handle)
+	if err != nil {
+		return 0, err
+	}
+	return ck.read(offset, data)
+}
+
+// WriteChunk writes data to the chunk at specific offset.
+func (c *Client) WriteChunk(ctx context.Context, handle gfs.ChunkHandle, offset gfs.Offset, data []byte) error {
+	if len(data)+int(offset) > gfs.MaxChunkSize {
+		return gfs.Error{gfs.WriteExceedChunkSize, fmt.Sprintf("len(data)+offset = %v > max chunk size %v", len(data)+int(offset), gfs.MaxChunkSize)}
+	}
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "WriteChunk"); err != nil {
+		return err
+	}
+	ck, err := c.getHandle(handle)
+	if err != nil {
+		return err
+	}
+	ck.write(offset, data)
+	return nil
+}
+
+// AppendChunk appends data to a chunk.
+// Chunk offset of the start of data will be returned if success.
+func (c *Client) AppendChunk(ctx context.Context, handle gfs.ChunkHandle, data []byte) (offset gfs.Offset, err error) {
+	if len(data) > gfs.MaxAppendSize {
+		return 0, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("len(data) = %v > max append size %v", len(data), gfs.MaxAppendSize)}
+	}
+	c.Lock()
+	defer c.Unlock()
+	if err := c.fail(ctx, "AppendChunk"); err != nil {
+		return 0, err
+	}
+	ck, err := c.getHandle(handle)
+	if err != nil {
+		return 0, err
+	}
+	return ck.append(data)
+}
@@ -0,0 +1,82 @@
+// Command gfs-chunkserver runs a chunkserver of a gfs cluster.
+//
+// Usage:
+//
+//	gfs-chunkserver [-config file] [-set key=value]... [-http addr] [-pidfile file] [-daemon] <addr> <root path>[,<data path>...] <master addr>
+//
+// The chunkserver stores its metadata under root path and its chunks over
+// the data paths, one per disk, the root path alone if none is given. It
+// reloads its configuration on SIGHUP. On SIGINT or SIGTERM, it answers the
+// rpcs in flight, deregisters from master, syncs its chunks and exits.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"gfs"
+	"gfs/chunkserver"
+	"gfs/config"
+	"gfs/daemon"
+)
+
+var (
+	httpAddr   = flag.String("http", "", "address of the metrics and status pages, none if empty")
+	pidFile    = flag.String("pidfile", "", "file the pid is written to while the chunkserver runs")
+	background = flag.Bool("daemon", false, "run in the background, detached from the terminal, printing the pid")
+)
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  gfs-chunkserver [flags] <addr> <root path>[,<data path>...] <master addr>")
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "The keys of the configuration are overridden by $GFS_<KEY>, e.g. $GFS_CHUNKSERVER_HEARTBEAT_INTERVAL, then by -set.")
+	fmt.Fprintln(os.Stderr, "Chunks are encrypted with the key of $GFS_ENCRYPTION_KEY_FILE, $GFS_ENCRYPTION_KEY or $GFS_ENCRYPTION_KEY_COMMAND.")
+	fmt.Fprintln(os.Stderr, "SIGHUP reloads the configuration, SIGINT and SIGTERM shut the chunkserver down.")
+}
+
+func main() {
+	load := config.Flags(flag.CommandLine)
+	flag.Usage = printUsage
+	flag.Parse()
+	if flag.NArg() != 3 {
+		printUsage()
+		os.Exit(2)
+	}
+	conf, err := load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *background {
+		daemon.Background()
+	}
+	defer daemon.SetupLogging(&conf).Close()
+	if err := daemon.WritePidFile(*pidFile); err != nil {
+		log.Fatal(err)
+	}
+	defer daemon.RemovePidFile(*pidFile)
+
+	roots := strings.Split(flag.Arg(1), ",") // metadata in the first
+	tlsConf, plaintext := daemon.SetTLS()
+	daemon.ExportSpans()
+	cs := chunkserver.NewAndServe(gfs.ServerAddress(flag.Arg(0)), gfs.ServerAddress(flag.Arg(2)), roots[0], roots[1:]...)
+	if err := conf.ApplyChunkServer(cs); err != nil {
+		log.Fatal(err)
+	}
+	cs.SetTLSConfig(tlsConf, plaintext)
+	cs.SetAuthSecret(daemon.AuthSecret())
+	if kp := daemon.KeyProvider(); kp != nil {
+		cs.SetKeyProvider(kp)
+	}
+	daemon.ServeHTTP(*httpAddr, cs.HTTPHandler())
+	daemon.ReloadOnHangup(&conf, load, func(c *config.Config) { c.TuneChunkServer(cs) })
+
+	log.Info("shutting down on ", daemon.WaitForShutdown())
+	cs.Drain(gfs.ShutdownDrainTimeout)
+}
@@ -0,0 +1,204 @@
+// Command gfs-client moves files in and out of a gfs cluster, with the
+// client set up by the [client] section of the configuration. gfsctl
+// administrates the cluster.
+//
+// Usage:
+//
+//	gfs-client [-config file] [-set key=value]... [-master addr] <command> [args]
+//
+// SIGINT cancels the command.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"gfs"
+	"gfs/client"
+	"gfs/config"
+	"gfs/util"
+)
+
+type command struct {
+	name  string
+	args  string
+	nargs int
+	help  string
+	run   func(ctx context.Context, args []string) error
+}
+
+var (
+	master   = flag.String("master", os.Getenv("GFS_MASTER"), "master address, defaults to $GFS_MASTER")
+	token    = flag.String("token", os.Getenv("GFS_TOKEN"), "client token to authenticate with, defaults to $GFS_TOKEN")
+	c        *client.Client
+	commands []command
+)
+
+func init() {
+	commands = []command{
+		{"ls", "<path>", 1, "list a directory", ls},
+		{"stat", "<path>", 1, "show the size, chunks and owner of a file", stat},
+		{"mkdir", "<path>", 1, "make a directory", mkdir},
+		{"rm", "<path>", 1, "delete a file", rm},
+		{"cat", "<path>", 1, "print a file", cat},
+		{"put", "<local> <path>", 2, "copy a local file into gfs", put},
+		{"get", "<path> <local>", 2, "copy a gfs file to local", get},
+	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  gfs-client [flags] <command> [args]")
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %v %v\t%v\n", cmd.name, cmd.args, cmd.help)
+	}
+	w.Flush()
+}
+
+func main() {
+	load := config.Flags(flag.CommandLine)
+	flag.Usage = printUsage
+	flag.Parse()
+	if flag.NArg() < 1 || *master == "" {
+		printUsage()
+		os.Exit(2)
+	}
+	conf, err := load()
+	if err != nil {
+		fail(err)
+	}
+	tlsConf, _, err := util.TLSConfigFromEnv()
+	if err != nil {
+		fail(err)
+	}
+	util.SetTLSConfig(tlsConf)
+	opts := conf.ClientOptions()
+	if *token != "" {
+		opts = append(opts, client.WithToken(*token))
+	}
+	c = client.NewClient(gfs.ServerAddress(*master), opts...)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	name, args := flag.Arg(0), flag.Args()[1:]
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if len(args) != cmd.nargs {
+			printUsage()
+			os.Exit(2)
+		}
+		if err := cmd.run(ctx, args); err != nil {
+			fail(err)
+		}
+		return
+	}
+	printUsage()
+	os.Exit(2)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "gfs-client:", err)
+	os.Exit(1)
+}
+
+func ls(ctx context.Context, args []string) error {
+	list, err := c.List(ctx, gfs.Path(args[0]))
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, v := range list {
+		name := v.Name
+		if v.IsDir {
+			name += "/"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", v.Mode, v.Owner, v.Group, name, v.Length)
+	}
+	return w.Flush()
+}
+
+func stat(ctx context.Context, args []string) error {
+	info, err := c.Stat(ctx, gfs.Path(args[0]))
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "path\t%v\n", info.Path)
+	fmt.Fprintf(w, "dir\t%v\n", info.IsDir)
+	fmt.Fprintf(w, "size\t%v\n", info.Size)
+	fmt.Fprintf(w, "chunks\t%v\n", info.Chunks)
+	fmt.Fprintf(w, "chunk size\t%v\n", info.ChunkSize)
+	fmt.Fprintf(w, "owner\t%v:%v\n", info.Owner, info.Group)
+	fmt.Fprintf(w, "mode\t%v\n", info.Mode)
+	return w.Flush()
+}
+
+func mkdir(ctx context.Context, args []string) error {
+	return c.Mkdir(ctx, gfs.Path(args[0]))
+}
+
+func rm(ctx context.Context, args []string) error {
+	return c.Delete(ctx, gfs.Path(args[0]))
+}
+
+func cat(ctx context.Context, args []string) error {
+	f, err := c.Open(ctx, gfs.Path(args[0]))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(os.Stdout, f)
+	return err
+}
+
+// put creates the file with the chunk size of the configuration.
+func put(ctx context.Context, args []string) error {
+	in, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	p := gfs.Path(args[1])
+	if err := c.Create(ctx, p); err != nil {
+		return err
+	}
+	f, err := c.Open(ctx, p)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, in); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func get(ctx context.Context, args []string) error {
+	f, err := c.Open(ctx, gfs.Path(args[0]))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	out, err := os.Create(args[1])
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, f); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
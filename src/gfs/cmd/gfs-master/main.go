@@ -0,0 +1,73 @@
+// Command gfs-master runs the master of a gfs cluster.
+//
+// Usage:
+//
+//	gfs-master [-config file] [-set key=value]... [-http addr] [-pidfile file] [-daemon] <addr> <root path>
+//
+// The master stores its metadata under root path. It reloads its
+// configuration on SIGHUP, and stores its metadata and exits on SIGINT or
+// SIGTERM, once the rpcs in flight are answered.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+
+	"gfs"
+	"gfs/config"
+	"gfs/daemon"
+	"gfs/master"
+)
+
+var (
+	httpAddr   = flag.String("http", "", "address of the metrics and status pages, none if empty")
+	pidFile    = flag.String("pidfile", "", "file the pid is written to while the master runs")
+	background = flag.Bool("daemon", false, "run in the background, detached from the terminal, printing the pid")
+)
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  gfs-master [flags] <addr> <root path>")
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "The keys of the configuration are overridden by $GFS_<KEY>, e.g. $GFS_MASTER_REPLICAS, then by -set.")
+	fmt.Fprintln(os.Stderr, "SIGHUP reloads the configuration, SIGINT and SIGTERM shut the master down.")
+}
+
+func main() {
+	load := config.Flags(flag.CommandLine)
+	flag.Usage = printUsage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		printUsage()
+		os.Exit(2)
+	}
+	conf, err := load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *background {
+		daemon.Background()
+	}
+	defer daemon.SetupLogging(&conf).Close()
+	if err := daemon.WritePidFile(*pidFile); err != nil {
+		log.Fatal(err)
+	}
+	defer daemon.RemovePidFile(*pidFile)
+
+	tlsConf, plaintext := daemon.SetTLS()
+	daemon.ExportSpans()
+	m := master.NewAndServe(gfs.ServerAddress(flag.Arg(0)), flag.Arg(1))
+	conf.ApplyMaster(m)
+	m.SetTLSConfig(tlsConf, plaintext)
+	m.SetAuthSecret(daemon.AuthSecret())
+	daemon.ServeHTTP(*httpAddr, m.HTTPHandler())
+	daemon.ReloadOnHangup(&conf, load, func(c *config.Config) { c.TuneMaster(m) })
+
+	log.Info("shutting down on ", daemon.WaitForShutdown())
+	m.Drain(gfs.ShutdownDrainTimeout)
+}
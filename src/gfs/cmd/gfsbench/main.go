@@ -0,0 +1,337 @@
+// Command gfsbench drives a workload against a gfs cluster and reports its
+// throughput and the latency percentiles of its operations.
+//
+// Usage:
+//
+//	gfsbench [-config file] [-set key=value]... [-master addr] [-concurrency n] [-duration d] [flags] <workload>
+//
+// The workloads are write, seq-read, random-read, append and mixed. Every
+// worker has a file of -file-size bytes under -dir, written first by the
+// read workloads; appends of -record-size bytes go to a file shared by the
+// workers. The mixed workload draws its operations by the weights of -mix.
+// The client is set up by the [client] section of the configuration, e.g.
+// its read cache. SIGINT ends the run early, reporting what was done.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"gfs"
+	"gfs/client"
+	"gfs/config"
+	"gfs/util"
+)
+
+var (
+	master      = flag.String("master", os.Getenv("GFS_MASTER"), "master address, defaults to $GFS_MASTER")
+	token       = flag.String("token", os.Getenv("GFS_TOKEN"), "client token to authenticate with, defaults to $GFS_TOKEN")
+	dir         = flag.String("dir", "/gfsbench", "directory of the files of the benchmark")
+	concurrency = flag.Int("concurrency", 4, "workers running operations at once")
+	fileSize    = flag.Int64("file-size", 64<<20, "bytes of the file of each worker")
+	ioSize      = flag.Int("io-size", 1<<20, "bytes of each read and write")
+	recordSize  = flag.Int("record-size", 4<<10, "bytes of each record appended")
+	duration    = flag.Duration("duration", 10*time.Second, "how long the workload runs")
+	ops         = flag.Int("ops", 0, "operations of each worker, bounded by -duration, unlimited if 0")
+	mix         = flag.String("mix", "seq-read=40,random-read=30,write=20,append=10", "weights of the operations of the mixed workload")
+	seed        = flag.Int64("seed", 1, "seed of the random offsets and operations")
+	jsonOut     = flag.Bool("json", false, "print the report as JSON")
+	keep        = flag.Bool("keep", false, "keep the files of the benchmark")
+)
+
+// operations of the workloads
+const (
+	opWrite      = "write"
+	opSeqRead    = "seq-read"
+	opRandomRead = "random-read"
+	opAppend     = "append"
+)
+
+var workloads = []string{opWrite, opSeqRead, opRandomRead, opAppend, "mixed"}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintf(os.Stderr, "  gfsbench [flags] <%v>\n", strings.Join(workloads, "|"))
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+}
+
+// weight is an operation of the mixed workload and its share.
+type weight struct {
+	op     string
+	weight int
+}
+
+func parseMix(s string) ([]weight, error) {
+	var ws []weight
+	for _, kv := range strings.Split(s, ",") {
+		op, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		n, err := strconv.Atoi(v)
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("bad weight %q, op=weight expected", kv)
+		}
+		switch op {
+		case opWrite, opSeqRead, opRandomRead, opAppend:
+		default:
+			return nil, fmt.Errorf("unknown operation %q", op)
+		}
+		ws = append(ws, weight{op, n})
+	}
+	return ws, nil
+}
+
+// worker runs operations on its file.
+type worker struct {
+	c       *client.Client
+	file    gfs.Path
+	records gfs.Path // shared by the workers
+	rand    *rand.Rand
+	buf     []byte
+	record  []byte
+	seqOff  int64 // of the next sequential read or write
+	lat     map[string][]time.Duration
+	bytes   map[string]int64
+	errs    map[string]int
+}
+
+func (w *worker) run(ctx context.Context, op string) {
+	var n int
+	var err error
+	size := *fileSize / int64(*ioSize) * int64(*ioSize)
+	start := time.Now()
+	switch op {
+	case opWrite:
+		_, err = w.c.Write(ctx, w.file, gfs.Offset(w.seqOff), w.buf)
+		n = len(w.buf)
+		w.seqOff = (w.seqOff + int64(*ioSize)) % size
+	case opSeqRead:
+		n, err = w.c.Read(ctx, w.file, gfs.Offset(w.seqOff), w.buf)
+		w.seqOff = (w.seqOff + int64(*ioSize)) % size
+	case opRandomRead:
+		off := w.rand.Int63n(size/int64(*ioSize)) * int64(*ioSize)
+		n, err = w.c.Read(ctx, w.file, gfs.Offset(off), w.buf)
+	case opAppend:
+		_, err = w.c.Append(ctx, w.records, w.record)
+		n = len(w.record)
+	}
+	if ctx.Err() != nil {
+		return // cut short at the end of the run
+	}
+	if err != nil && !errors.Is(err, gfs.ReadEOF) {
+		w.errs[op]++
+		return
+	}
+	w.lat[op] = append(w.lat[op], time.Since(start))
+	w.bytes[op] += int64(n)
+}
+
+// Result is the report of an operation.
+type Result struct {
+	Op          string
+	Ops         int
+	Errors      int
+	Bytes       int64
+	OpsPerSec   float64
+	BytesPerSec float64
+	P50         time.Duration
+	P90         time.Duration
+	P99         time.Duration
+	Max         time.Duration
+}
+
+func percentile(lat []time.Duration, p float64) time.Duration {
+	if len(lat) == 0 {
+		return 0
+	}
+	return lat[int(float64(len(lat)-1)*p)]
+}
+
+func main() {
+	load := config.Flags(flag.CommandLine)
+	flag.Usage = printUsage
+	flag.Parse()
+	if flag.NArg() != 1 || *master == "" || *concurrency < 1 || *ioSize < 1 || *fileSize < int64(*ioSize) {
+		printUsage()
+		os.Exit(2)
+	}
+	workload := flag.Arg(0)
+	var mixed []weight
+	switch workload {
+	case opWrite, opSeqRead, opRandomRead, opAppend:
+		mixed = []weight{{workload, 1}}
+	case "mixed":
+		var err error
+		if mixed, err = parseMix(*mix); err != nil {
+			fail(err)
+		}
+	default:
+		printUsage()
+		os.Exit(2)
+	}
+	if *recordSize < 1 || *recordSize > gfs.MaxAppendSize {
+		fail(fmt.Errorf("-record-size should be within [1, %v]", gfs.MaxAppendSize))
+	}
+	conf, err := load()
+	if err != nil {
+		fail(err)
+	}
+	tlsConf, _, err := util.TLSConfigFromEnv()
+	if err != nil {
+		fail(err)
+	}
+	util.SetTLSConfig(tlsConf)
+	opts := conf.ClientOptions()
+	if *token != "" {
+		opts = append(opts, client.WithToken(*token))
+	}
+	c := client.NewClient(gfs.ServerAddress(*master), opts...)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := c.Mkdir(ctx, gfs.Path(*dir)); err != nil && !errors.Is(err, gfs.PathExists) {
+		fail(err)
+	}
+	records := gfs.Path(fmt.Sprintf("%v/records", *dir))
+	workers := make([]*worker, *concurrency)
+	for i := range workers {
+		w := &worker{
+			c:       c,
+			file:    gfs.Path(fmt.Sprintf("%v/file-%v", *dir, i)),
+			records: records,
+			rand:    rand.New(rand.NewSource(*seed + int64(i))),
+			buf:     make([]byte, *ioSize),
+			record:  make([]byte, *recordSize),
+			lat:     make(map[string][]time.Duration),
+			bytes:   make(map[string]int64),
+			errs:    make(map[string]int),
+		}
+		w.rand.Read(w.buf)
+		w.rand.Read(w.record)
+		workers[i] = w
+	}
+	if err := prepare(ctx, c, workers, records, mixed); err != nil {
+		fail(err)
+	}
+	if !*keep {
+		defer c.DeleteAll(context.Background(), gfs.Path(*dir))
+	}
+
+	// the workers run until the duration is over or their ops are done
+	total := 0
+	for _, w := range mixed {
+		total += w.weight
+	}
+	if total == 0 {
+		fail(fmt.Errorf("no operation in -mix"))
+	}
+	run, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			for i := 0; (*ops == 0 || i < *ops) && run.Err() == nil; i++ {
+				pick := w.rand.Intn(total)
+				for _, m := range mixed {
+					if pick < m.weight {
+						w.run(run, m.op)
+						break
+					}
+					pick -= m.weight
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	report(workers, mixed, time.Since(start))
+}
+
+// prepare creates the files of the workers, written up to -file-size for
+// the reads, and the file of the records.
+func prepare(ctx context.Context, c *client.Client, workers []*worker, records gfs.Path, mixed []weight) error {
+	reads, appends := false, false
+	for _, m := range mixed {
+		reads = reads || m.op == opSeqRead || m.op == opRandomRead
+		appends = appends || m.op == opAppend
+	}
+	if appends {
+		if err := c.Create(ctx, records); err != nil && !errors.Is(err, gfs.PathExists) {
+			return err
+		}
+	}
+	for _, w := range workers {
+		err := c.Create(ctx, w.file)
+		if errors.Is(err, gfs.PathExists) {
+			continue // written by a previous run kept with -keep
+		}
+		if err != nil {
+			return err
+		}
+		if !reads {
+			continue
+		}
+		for off := int64(0); off+int64(*ioSize) <= *fileSize; off += int64(*ioSize) {
+			if _, err := c.Write(ctx, w.file, gfs.Offset(off), w.buf); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func report(workers []*worker, mixed []weight, elapsed time.Duration) {
+	var results []Result
+	for _, m := range mixed {
+		r := Result{Op: m.op}
+		var lat []time.Duration
+		for _, w := range workers {
+			lat = append(lat, w.lat[m.op]...)
+			r.Bytes += w.bytes[m.op]
+			r.Errors += w.errs[m.op]
+		}
+		sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+		r.Ops = len(lat)
+		r.OpsPerSec = float64(r.Ops) / elapsed.Seconds()
+		r.BytesPerSec = float64(r.Bytes) / elapsed.Seconds()
+		r.P50, r.P90, r.P99 = percentile(lat, 0.5), percentile(lat, 0.9), percentile(lat, 0.99)
+		if len(lat) > 0 {
+			r.Max = lat[len(lat)-1]
+		}
+		results = append(results, r)
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return
+	}
+	fmt.Printf("%v workers for %v\n", len(workers), elapsed.Round(time.Millisecond))
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "op\tops\terrors\tops/s\tMB/s\tp50\tp90\tp99\tmax\t")
+	for _, r := range results {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%.1f\t%.2f\t%v\t%v\t%v\t%v\t\n", r.Op, r.Ops, r.Errors, r.OpsPerSec, r.BytesPerSec/(1<<20),
+			r.P50.Round(time.Microsecond), r.P90.Round(time.Microsecond), r.P99.Round(time.Microsecond), r.Max.Round(time.Microsecond))
+	}
+	w.Flush()
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "gfsbench:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,1002 @@
+// Command gfsctl administrates a gfs cluster and moves data in and out of it.
+//
+// Usage:
+//
+//	gfsctl [-master addr] [-json] [-dry-run] <command> [args]
+//
+// With -json, results are printed as JSON for scripting. With -dry-run,
+// decommission, rebalance and collect-empty-dirs print what they would do
+// without doing it.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gfs"
+	"gfs/auth"
+	"gfs/client"
+	"gfs/util"
+)
+
+type command struct {
+	name  string
+	args  string
+	nargs int
+	help  string
+	run   func(ctx context.Context, args []string) (interface{}, error)
+}
+
+var (
+	master    = flag.String("master", os.Getenv("GFS_MASTER"), "master address, defaults to $GFS_MASTER")
+	jsonOut   = flag.Bool("json", false, "print results as JSON")
+	chunkSize = flag.Int64("chunk-size", gfs.MaxChunkSize, "chunk size of files created by put")
+	dryRun    = flag.Bool("dry-run", false, "print the plan of decommission, rebalance, collect-empty-dirs and reclaim-trash without executing it")
+	user      = flag.String("user", os.Getenv("GFS_USER"), "user to act as, with -groups, defaults to $GFS_USER, empty is the superuser")
+	groups    = flag.String("groups", "", "comma separated groups of -user")
+	token     = flag.String("token", os.Getenv("GFS_TOKEN"), "client token to authenticate with, defaults to $GFS_TOKEN")
+	c         *client.Client
+	cred      gfs.Credentials // of c
+	commands  []command
+)
+
+func init() {
+	commands = []command{
+		{"ls", "<path>", 1, "list a directory", ls},
+		{"ls-match", "<path> <pattern>", 2, "list the names in a directory matching a glob, e.g. 'part-*'", lsMatch},
+		{"mkdir", "<path>", 1, "make a directory", mkdir},
+		{"rm", "<path>", 1, "delete a file", rm},
+		{"rmr", "<path>", 1, "delete a directory with everything under it", rmr},
+		{"undelete", "<trash path>", 1, "move a path deleted into /.trash back to where it was", undelete},
+		{"reclaim-trash", "<min-age>", 1, "remove for good the paths deleted at least min-age ago", reclaimTrash},
+		{"lsr", "<path>", 1, "list a directory recursively", lsr},
+		{"du", "<path>", 1, "sum the files and bytes under a directory", du},
+		{"truncate", "<path> <length>", 2, "shorten a file to length bytes", truncate},
+		{"cp", "<source> <target>", 2, "copy a file, its chunks cloned by the chunkservers", cp},
+		{"concat", "<target> <source,...>", 2, "move the chunks of files to the end of target in order, removing them", concat},
+		{"quota", "<dir> <max files> <max bytes>", 3, "set the quotas of a directory, 0 is unlimited", quota},
+		{"quota-usage", "<dir>", 1, "show the quotas of a directory with the files and bytes under it", quotaUsage},
+		{"chmod", "<path> <mode>", 2, "set the permission bits of a file or a directory, in octal", chmod},
+		{"chown", "<path> <owner[:group]|:group>", 2, "set the owner and the group of a file or a directory", chown},
+		{"setxattr", "<path> <name> <value>", 3, "set an extended attribute of a file or a directory", setXattr},
+		{"getxattr", "<path> <name>", 2, "print an extended attribute of a file or a directory", getXattr},
+		{"listxattrs", "<path>", 1, "list the extended attributes of a file or a directory", listXattrs},
+		{"rmxattr", "<path> <name>", 2, "remove an extended attribute of a file or a directory", removeXattr},
+		{"cat", "<path>", 1, "print a file", cat},
+		{"put", "<local> <path>", 2, "copy a local file into gfs", put},
+		{"get", "<path> <local>", 2, "copy a gfs file to local", get},
+		{"stat", "<path>", 1, "show file information", stat},
+		{"chunk-locations", "<path>", 1, "show chunk handles and replicas of a file", chunkLocations},
+		{"verify", "<path>", 1, "compare the replicas of the chunks of a file, reporting divergent ones to master", verify},
+		{"server-list", "", 0, "list chunkservers", serverList},
+		{"cluster-topology", "", 0, "show zones, racks and chunkservers with their usage and health", clusterTopology},
+		{"build-info", "", 0, "show version, commit, protocol level and features of master and chunkservers", buildInfo},
+		{"rebalance", "", 0, "trigger re-replication and rebalancing on master", rebalance},
+		{"decommission", "<addr>", 1, "drain a chunkserver, copying its chunks elsewhere", decommission},
+		{"recommission", "<addr>", 1, "put a draining chunkserver back in service", recommission},
+		{"decommission-status", "<addr>", 1, "show whether a draining chunkserver is safe to shut down", decommissionStatus},
+		{"mode", "<normal|read-only|maintenance>", 1, "set the mode master serves clients in, maintenance drains the operations in flight and stores the metadata", mode},
+		{"status", "<path>", 1, "show the chunks under a path, / for the cluster, replicated, under-replicated and missing, and the re-replication backlog", status},
+		{"fsck", "<path>", 1, "cross-check the files under a path with the chunks of master and of the chunkservers", fsck},
+		{"copy-limits", "<addr> <piece bytes> <bytes/s>", 3, "set the pieces and the bandwidth of the copies a chunkserver sends, 0 is the default and unlimited", copyLimits},
+		{"replication", "<path> <n>", 2, "set the number of replicas of a file", replication},
+		{"placement", "<path> <kind:label,...|none>", 2, "set placement constraints (must, prefer, avoid) of a file", placement},
+		{"throttle", "<dir> <creates/s> <appends/s>", 3, "limit creates and appends under a directory, 0 is unlimited", throttle},
+		{"throttle-list", "", 0, "list throttle policies", throttleList},
+		{"topology", "<addr> <rack=r1,zone=a|none>", 2, "set the topology of a chunkserver on master", topology},
+		{"topology-file", "<file>", 1, "set topologies from lines of <addr> <rack=r1,zone=a>", topologyFile},
+		{"dump-namespace", "<file>", 1, "write the namespace and the chunks of master to a JSON file, '-' for stdout, restored by gfs master-restore", dumpNamespace},
+		{"import", "<manifest>", 1, "create files and allocate chunks from lines of <path> <size> [chunk size]", importNamespace},
+		{"mirror-reconcile", "<secondary master> <journal>", 2, "copy the diverged files of a mirror journal to the secondary cluster", mirrorReconcile},
+		{"collect-empty-dirs", "<min-age>", 1, "remove directories empty for at least min-age", collectEmptyDirs},
+		{"protect", "<path> <true|false>", 2, "protect a directory from empty directory collection", protect},
+		{"issue-token", "<secret file> <user[:group,...]> <ttl>", 3, "sign a client token with the secret of the cluster", issueToken},
+	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  gfsctl [-master addr] [-user name] [-groups a,b] [-token t] [-json] [-dry-run] <command> [args]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %v %v\t%v\n", cmd.name, cmd.args, cmd.help)
+	}
+	w.Flush()
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Flags:")
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Rpcs are dialed over TLS with the files named by $GFS_TLS_CERT, $GFS_TLS_KEY and $GFS_TLS_CA.")
+}
+
+func main() {
+	flag.Usage = printUsage
+	flag.Parse()
+	if flag.NArg() < 1 || *master == "" {
+		printUsage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	config, _, err := util.TLSConfigFromEnv()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gfsctl:", err)
+		os.Exit(1)
+	}
+	util.SetTLSConfig(config)
+	var opts []client.Option
+	if *user != "" {
+		var gs []string
+		if *groups != "" {
+			gs = strings.Split(*groups, ",")
+		}
+		opts = append(opts, client.WithUser(*user, gs...))
+		cred.User, cred.Groups = *user, gs
+	}
+	if *token != "" {
+		opts = append(opts, client.WithToken(*token))
+		cred.Token = *token
+	}
+	c = client.NewClient(gfs.ServerAddress(*master), opts...)
+
+	name, args := flag.Arg(0), flag.Args()[1:]
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if len(args) != cmd.nargs {
+			printUsage()
+			os.Exit(2)
+		}
+		ret, err := cmd.run(ctx, args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "gfsctl:", err)
+			os.Exit(1)
+		}
+		if *jsonOut && ret != nil {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(ret)
+		}
+		return
+	}
+	printUsage()
+	os.Exit(2)
+}
+
+// table prints rows aligned unless the output is JSON.
+func table(rows [][]interface{}) {
+	if *jsonOut {
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, row := range rows {
+		for i, v := range row {
+			if i > 0 {
+				fmt.Fprint(w, "\t")
+			}
+			fmt.Fprint(w, v)
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+}
+
+func ls(ctx context.Context, args []string) (interface{}, error) {
+	list, err := c.List(ctx, gfs.Path(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return listTable(list), nil
+}
+
+func lsMatch(ctx context.Context, args []string) (interface{}, error) {
+	var list []gfs.PathInfo
+	it := c.ListIter(ctx, gfs.Path(args[0]), "", args[1])
+	for {
+		v, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return listTable(list), nil
+}
+
+func listTable(list []gfs.PathInfo) []gfs.PathInfo {
+	var rows [][]interface{}
+	for _, v := range list {
+		name := v.Name
+		if v.IsDir {
+			name += "/"
+		}
+		rows = append(rows, []interface{}{v.Mode, v.Replication, v.Owner, v.Group, v.Length, v.ModTime.Format(time.RFC3339), name, v.Chunks})
+	}
+	table(rows)
+	return list
+}
+
+func mkdir(ctx context.Context, args []string) (interface{}, error) {
+	return nil, c.Mkdir(ctx, gfs.Path(args[0]))
+}
+
+func rm(ctx context.Context, args []string) (interface{}, error) {
+	return nil, c.Delete(ctx, gfs.Path(args[0]))
+}
+
+type deleted struct {
+	Files int
+	Dirs  int
+}
+
+func rmr(ctx context.Context, args []string) (interface{}, error) {
+	files, dirs, err := c.DeleteAll(ctx, gfs.Path(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	table([][]interface{}{{"deleted", files, "files,", dirs, "directories"}})
+	return deleted{files, dirs}, nil
+}
+
+func undelete(ctx context.Context, args []string) (interface{}, error) {
+	p, err := c.Undelete(ctx, gfs.Path(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	table([][]interface{}{{"restored", p}})
+	return p, nil
+}
+
+func reclaimTrash(ctx context.Context, args []string) (interface{}, error) {
+	age, err := time.ParseDuration(args[0])
+	if err != nil {
+		return nil, err
+	}
+	var r gfs.ReclaimTrashReply
+	if err := util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCReclaimTrash", gfs.ReclaimTrashArg{age, *dryRun, cred}, &r); err != nil {
+		return nil, err
+	}
+	var rows [][]interface{}
+	for _, d := range r.Dirs {
+		rows = append(rows, []interface{}{d.Path, d.Chunks})
+	}
+	table(rows)
+	return r.Dirs, nil
+}
+
+func lsr(ctx context.Context, args []string) (interface{}, error) {
+	var list []gfs.FileInfo
+	err := c.Walk(ctx, gfs.Path(args[0]), func(info gfs.FileInfo) error {
+		list = append(list, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]interface{}
+	for _, v := range list {
+		name := string(v.Path)
+		if v.IsDir {
+			name += "/"
+		}
+		rows = append(rows, []interface{}{name, v.Size, v.Chunks})
+	}
+	table(rows)
+	return list, nil
+}
+
+func du(ctx context.Context, args []string) (interface{}, error) {
+	u, err := c.DiskUsage(ctx, gfs.Path(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	table([][]interface{}{
+		{"files", u.Files},
+		{"directories", u.Dirs},
+		{"chunks", u.Chunks},
+		{"bytes", u.Bytes},
+		{"replicated bytes", u.ReplicatedBytes},
+	})
+	return u, nil
+}
+
+func quota(ctx context.Context, args []string) (interface{}, error) {
+	maxFiles, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	maxBytes, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return nil, c.SetQuota(ctx, gfs.Path(args[0]), maxFiles, maxBytes)
+}
+
+func quotaUsage(ctx context.Context, args []string) (interface{}, error) {
+	u, err := c.GetQuotaUsage(ctx, gfs.Path(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	table([][]interface{}{
+		{"files", u.Files, "of", u.MaxFiles},
+		{"bytes", u.Bytes, "of", u.MaxBytes},
+	})
+	return u, nil
+}
+
+func chmod(ctx context.Context, args []string) (interface{}, error) {
+	mode, err := strconv.ParseUint(args[1], 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mode %q", args[1])
+	}
+	return nil, c.Chmod(ctx, gfs.Path(args[0]), os.FileMode(mode))
+}
+
+func chown(ctx context.Context, args []string) (interface{}, error) {
+	owner, group, _ := strings.Cut(args[1], ":")
+	return nil, c.Chown(ctx, gfs.Path(args[0]), owner, group)
+}
+
+func setXattr(ctx context.Context, args []string) (interface{}, error) {
+	return nil, c.SetXattr(ctx, gfs.Path(args[0]), args[1], []byte(args[2]))
+}
+
+func getXattr(ctx context.Context, args []string) (interface{}, error) {
+	value, err := c.GetXattr(ctx, gfs.Path(args[0]), args[1])
+	if err != nil {
+		return nil, err
+	}
+	table([][]interface{}{{string(value)}})
+	return string(value), nil
+}
+
+func listXattrs(ctx context.Context, args []string) (interface{}, error) {
+	names, err := c.ListXattrs(ctx, gfs.Path(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]interface{}
+	for _, name := range names {
+		rows = append(rows, []interface{}{name})
+	}
+	table(rows)
+	return names, nil
+}
+
+func removeXattr(ctx context.Context, args []string) (interface{}, error) {
+	return nil, c.RemoveXattr(ctx, gfs.Path(args[0]), args[1])
+}
+
+func verify(ctx context.Context, args []string) (interface{}, error) {
+	divergences, err := c.VerifyFile(ctx, gfs.Path(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]interface{}
+	for _, d := range divergences {
+		rows = append(rows, []interface{}{d.Index, d.Handle, d.Location, d.Divergence})
+	}
+	table(rows)
+	return divergences, nil
+}
+
+func issueToken(ctx context.Context, args []string) (interface{}, error) {
+	key, err := auth.ReadSecretFile(args[0])
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := time.ParseDuration(args[2])
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("invalid ttl %q", args[2])
+	}
+	name, gs, _ := strings.Cut(args[1], ":")
+	if name == "" {
+		return nil, fmt.Errorf("invalid user %q", args[1])
+	}
+	var groups []string
+	if gs != "" {
+		groups = strings.Split(gs, ",")
+	}
+	var secret auth.Secret
+	secret.Set(key)
+	t := secret.ClientToken(name, groups, ttl)
+	if !*jsonOut {
+		fmt.Println(t)
+		return nil, nil
+	}
+	return t, nil
+}
+
+func truncate(ctx context.Context, args []string) (interface{}, error) {
+	length, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid length %q", args[1])
+	}
+	return nil, c.Truncate(ctx, gfs.Path(args[0]), length)
+}
+
+func cp(ctx context.Context, args []string) (interface{}, error) {
+	return nil, c.CopyFile(ctx, gfs.Path(args[0]), gfs.Path(args[1]))
+}
+
+func concat(ctx context.Context, args []string) (interface{}, error) {
+	var sources []gfs.Path
+	for _, p := range strings.Split(args[1], ",") {
+		sources = append(sources, gfs.Path(p))
+	}
+	return nil, c.Concat(ctx, gfs.Path(args[0]), sources...)
+}
+
+func cat(ctx context.Context, args []string) (interface{}, error) {
+	f, err := c.Open(ctx, gfs.Path(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	_, err = io.Copy(os.Stdout, f)
+	return nil, err
+}
+
+type transfer struct {
+	Path  gfs.Path
+	Local string
+	Bytes int64
+}
+
+func put(ctx context.Context, args []string) (interface{}, error) {
+	local, p := args[0], gfs.Path(args[1])
+	in, err := os.Open(local)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	if err := c.CreateWithChunkSize(ctx, p, *chunkSize); err != nil {
+		return nil, err
+	}
+	f, err := c.Open(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(f, in)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	table([][]interface{}{{"put", n, "bytes to", p}})
+	return transfer{p, local, n}, nil
+}
+
+func get(ctx context.Context, args []string) (interface{}, error) {
+	p, local := gfs.Path(args[0]), args[1]
+	f, err := c.Open(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out, err := os.Create(local)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(out, f)
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	table([][]interface{}{{"got", n, "bytes to", local}})
+	return transfer{p, local, n}, nil
+}
+
+type fileStat struct {
+	Path       gfs.Path
+	IsDir      bool
+	Size       int64
+	Chunks     int64
+	ChunkSize  int64
+	Replicas   int
+	CreateTime time.Time
+	ModTime    time.Time
+	ChangeTime time.Time
+	Owner      string
+	Group      string
+	Mode       os.FileMode
+}
+
+func stat(ctx context.Context, args []string) (interface{}, error) {
+	info, err := c.Stat(ctx, gfs.Path(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	st := fileStat{info.Path, info.IsDir, info.Size, info.Chunks, info.ChunkSize, info.Replication, info.CreateTime, info.ModTime, info.ChangeTime, info.Owner, info.Group, info.Mode}
+	table([][]interface{}{
+		{"path", st.Path},
+		{"dir", st.IsDir},
+		{"size", st.Size},
+		{"chunks", st.Chunks},
+		{"chunk size", st.ChunkSize},
+		{"replication", st.Replicas},
+		{"created", st.CreateTime.Format(time.RFC3339)},
+		{"modified", st.ModTime.Format(time.RFC3339)},
+		{"changed", st.ChangeTime.Format(time.RFC3339)},
+		{"owner", st.Owner + ":" + st.Group},
+		{"mode", st.Mode},
+	})
+	return st, nil
+}
+
+type chunkLocation struct {
+	Index     gfs.ChunkIndex
+	Handle    gfs.ChunkHandle
+	Locations []gfs.ServerAddress
+}
+
+func chunkLocations(ctx context.Context, args []string) (interface{}, error) {
+	p := gfs.Path(args[0])
+	var info gfs.GetFileInfoReply
+	if err := util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCGetFileInfo", gfs.GetFileInfoArg{p, cred}, &info); err != nil {
+		return nil, err
+	}
+
+	ret := []chunkLocation{}
+	var rows [][]interface{}
+	for i := gfs.ChunkIndex(0); int64(i) < info.Chunks; i++ {
+		handle, err := c.GetChunkHandle(ctx, p, i)
+		if err != nil {
+			return nil, err
+		}
+		var r gfs.GetReplicasReply
+		if err := util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCGetReplicas", gfs.GetReplicasArg{handle, cred}, &r); err != nil {
+			return nil, err
+		}
+		ret = append(ret, chunkLocation{i, handle, r.Locations})
+		rows = append(rows, []interface{}{i, handle, r.Locations})
+	}
+	table(rows)
+	return ret, nil
+}
+
+func serverList(ctx context.Context, args []string) (interface{}, error) {
+	var r gfs.ListServersReply
+	if err := util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCListServers", gfs.ListServersArg{cred}, &r); err != nil {
+		return nil, err
+	}
+	rows := [][]interface{}{{"ADDRESS", "VERSION", "DOMAIN", "LABELS", "TOPOLOGY", "CHUNKS", "CAPACITY", "DISK USED", "DISK FREE", "IO LOAD", "LEASES", "HEALTH", "LAST HEARTBEAT"}}
+	for _, v := range r.Servers {
+		rows = append(rows, []interface{}{v.Address, v.Version, v.Domain, v.LabelString(), v.Topology, v.Chunks, v.Capacity, v.DiskUsed, v.DiskFree, v.IOLoad, v.Leases, v.Health, time.Since(v.LastHeartbeat).Round(time.Millisecond)})
+	}
+	table(rows)
+	return r.Servers, nil
+}
+
+func clusterTopology(ctx context.Context, args []string) (interface{}, error) {
+	var r gfs.GetTopologyReply
+	if err := util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCGetTopology", gfs.GetTopologyArg{cred}, &r); err != nil {
+		return nil, err
+	}
+	name := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	rows := [][]interface{}{{"ZONE", "RACK", "SERVER", "HEALTH", "CHUNKS", "CAPACITY", "DISK USED", "DISK FREE"}}
+	for _, z := range r.Cluster.Zones {
+		for _, rack := range z.Racks {
+			for _, v := range rack.Servers {
+				rows = append(rows, []interface{}{name(z.Name), name(rack.Name), v.Address, v.Health, v.Chunks, v.Capacity, v.DiskUsed, v.DiskFree})
+			}
+		}
+	}
+	u := r.Cluster.Usage
+	rows = append(rows, []interface{}{"total", "", fmt.Sprintf("%v/%v healthy", u.Healthy, u.Servers), "", u.Replicas, u.Capacity, u.DiskUsed, u.DiskFree})
+	table(rows)
+	return r.Cluster, nil
+}
+
+type daemonBuild struct {
+	Address gfs.ServerAddress
+	Role    string
+	gfs.BuildInfo
+	Error string `json:",omitempty"`
+}
+
+func buildInfo(ctx context.Context, args []string) (interface{}, error) {
+	var l gfs.ListServersReply
+	if err := util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCListServers", gfs.ListServersArg{cred}, &l); err != nil {
+		return nil, err
+	}
+	daemons := []daemonBuild{{Address: gfs.ServerAddress(*master), Role: "master"}}
+	for _, v := range l.Servers {
+		daemons = append(daemons, daemonBuild{Address: v.Address, Role: "chunkserver"})
+	}
+
+	rows := [][]interface{}{{"ADDRESS", "ROLE", "VERSION", "COMMIT", "PROTOCOL", "FEATURES"}}
+	for i := range daemons {
+		d := &daemons[i]
+		service := "ChunkServer"
+		if d.Role == "master" {
+			service = "Master"
+		}
+		var r gfs.BuildInfoReply
+		if err := util.Call(ctx, d.Address, service+".RPCBuildInfo", gfs.Nouse{}, &r); err != nil {
+			d.Error = err.Error()
+			rows = append(rows, []interface{}{d.Address, d.Role, "-", "-", "-", err})
+			continue
+		}
+		d.BuildInfo = r.BuildInfo
+		rows = append(rows, []interface{}{d.Address, d.Role, d.Version, d.GitCommit, d.Protocol, strings.Join(d.Features, ",")})
+	}
+	table(rows)
+	return daemons, nil
+}
+
+// moveTable prints the chunk moves of a plan.
+func moveTable(moves []gfs.ChunkMove) {
+	rows := [][]interface{}{{"HANDLE", "FROM", "TO"}}
+	for _, v := range moves {
+		to := string(v.To)
+		if to == "" {
+			to = "(no server)"
+		}
+		rows = append(rows, []interface{}{v.Handle, v.From, to})
+	}
+	table(rows)
+}
+
+func rebalance(ctx context.Context, args []string) (interface{}, error) {
+	var r gfs.RebalanceReply
+	if err := util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCRebalance", gfs.RebalanceArg{*dryRun, cred}, &r); err != nil {
+		return nil, err
+	}
+	if !*dryRun {
+		return nil, nil
+	}
+	moveTable(r.Moves)
+	return r.Moves, nil
+}
+
+func decommission(ctx context.Context, args []string) (interface{}, error) {
+	var r gfs.DecommissionServerReply
+	if err := util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCDecommissionServer", gfs.DecommissionServerArg{gfs.ServerAddress(args[0]), false, *dryRun, cred}, &r); err != nil {
+		return nil, err
+	}
+	if *dryRun {
+		table([][]interface{}{{"would drain", args[0] + ",", r.Chunks, "chunks,", len(r.Plan), "to copy"}})
+		moveTable(r.Plan)
+		return r, nil
+	}
+	table([][]interface{}{{"draining", args[0] + ",", r.Chunks, "chunks to copy,", r.Leases, "leases revoked"}})
+	return r, nil
+}
+
+func recommission(ctx context.Context, args []string) (interface{}, error) {
+	var r gfs.DecommissionServerReply
+	return nil, util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCDecommissionServer", gfs.DecommissionServerArg{gfs.ServerAddress(args[0]), true, *dryRun, cred}, &r)
+}
+
+func copyLimits(ctx context.Context, args []string) (interface{}, error) {
+	piece, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, err
+	}
+	rate, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return nil, err
+	}
+	return nil, util.Call(ctx, gfs.ServerAddress(args[0]), "ChunkServer.RPCSetCopyLimits", gfs.SetCopyLimitsArg{piece, rate, cred}, &gfs.SetCopyLimitsReply{})
+}
+
+func decommissionStatus(ctx context.Context, args []string) (interface{}, error) {
+	var r gfs.DecommissionStatusReply
+	if err := util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCDecommissionStatus", gfs.DecommissionStatusArg{gfs.ServerAddress(args[0]), cred}, &r); err != nil {
+		return nil, err
+	}
+	table([][]interface{}{
+		{"draining", r.Draining},
+		{"chunks", r.Chunks},
+		{"remaining", r.Remaining},
+		{"done", r.Done},
+	})
+	return r, nil
+}
+
+func dumpNamespace(ctx context.Context, args []string) (interface{}, error) {
+	var r gfs.DumpNamespaceReply
+	if err := util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCDumpNamespace", gfs.DumpNamespaceArg{cred}, &r); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(r.Dump, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if args[0] == "-" {
+		_, err = os.Stdout.Write(append(data, '\n'))
+		return nil, err
+	}
+	if err := os.WriteFile(args[0], data, 0600); err != nil {
+		return nil, err
+	}
+	var files, chunks int
+	for _, e := range r.Dump.Entries {
+		if !e.IsDir {
+			files++
+			chunks += len(e.ChunkInfo)
+		}
+	}
+	table([][]interface{}{{"entries", len(r.Dump.Entries)}, {"files", files}, {"chunks", chunks}, {"throttles", len(r.Dump.Throttles)}})
+	return nil, nil
+}
+
+func mode(ctx context.Context, args []string) (interface{}, error) {
+	var mode gfs.MasterMode
+	switch args[0] {
+	case "normal":
+		mode = gfs.MasterNormal
+	case "read-only":
+		mode = gfs.MasterReadOnly
+	case "maintenance":
+		mode = gfs.MasterMaintenance
+	default:
+		return nil, fmt.Errorf("invalid mode %q", args[0])
+	}
+	var r gfs.SetModeReply
+	if err := util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCSetMode", gfs.SetModeArg{mode, cred}, &r); err != nil {
+		return nil, err
+	}
+	table([][]interface{}{{"previous", r.Previous}, {"mode", mode}})
+	return r, nil
+}
+
+func fsck(ctx context.Context, args []string) (interface{}, error) {
+	var r gfs.FsckReply
+	if err := util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCFsck", gfs.FsckArg{gfs.Path(args[0]), true, cred}, &r); err != nil {
+		return nil, err
+	}
+	rows := [][]interface{}{{"KIND", "PATH", "HANDLE", "SERVER", "DETAIL"}}
+	for _, v := range r.Problems {
+		rows = append(rows, []interface{}{v.Kind, v.Path, v.Handle, v.Server, v.Detail})
+	}
+	if r.More > 0 {
+		rows = append(rows, []interface{}{fmt.Sprintf("%v more", r.More)})
+	}
+	for _, addr := range r.Unreachable {
+		rows = append(rows, []interface{}{"unreachable", "", "", addr})
+	}
+	rows = append(rows, []interface{}{"total", fmt.Sprintf("%v files", r.Files), fmt.Sprintf("%v chunks", r.Chunks), fmt.Sprintf("%v servers", r.Servers), fmt.Sprintf("%v problems", len(r.Problems)+r.More)})
+	table(rows)
+	return r, nil
+}
+
+func status(ctx context.Context, args []string) (interface{}, error) {
+	var r gfs.GetReplicationStatusReply
+	if err := util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCGetReplicationStatus", gfs.GetReplicationStatusArg{gfs.Path(args[0]), cred}, &r); err != nil {
+		return nil, err
+	}
+	if len(r.Under) > 0 {
+		rows := [][]interface{}{{"HANDLE", "PATH", "REPLICAS", "LIVE", "REPLICATION"}}
+		for _, v := range r.Under {
+			rows = append(rows, []interface{}{v.Handle, v.Path, v.Replicas, v.Live, v.Replication})
+		}
+		if more := r.UnderReplicated + r.Missing - len(r.Under); more > 0 {
+			rows = append(rows, []interface{}{fmt.Sprintf("%v more", more)})
+		}
+		rows = append(rows, nil)
+		table(rows)
+	}
+	table([][]interface{}{
+		{"files", r.Files},
+		{"chunks", r.Chunks},
+		{"replicated", r.Replicated},
+		{"under-replicated", r.UnderReplicated},
+		{"missing", r.Missing},
+		{"queued", r.Queued},
+		{"re-replicating", r.Running},
+	})
+	return r, nil
+}
+
+func collectEmptyDirs(ctx context.Context, args []string) (interface{}, error) {
+	age, err := time.ParseDuration(args[0])
+	if err != nil {
+		return nil, err
+	}
+	var r gfs.CollectEmptyDirsReply
+	if err := util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCCollectEmptyDirs", gfs.CollectEmptyDirsArg{age, *dryRun, cred}, &r); err != nil {
+		return nil, err
+	}
+	var rows [][]interface{}
+	for _, p := range r.Removed {
+		rows = append(rows, []interface{}{p})
+	}
+	table(rows)
+	return r.Removed, nil
+}
+
+func protect(ctx context.Context, args []string) (interface{}, error) {
+	protected, err := strconv.ParseBool(args[1])
+	if err != nil {
+		return nil, err
+	}
+	arg := gfs.SetDirProtectedArg{gfs.Path(args[0]), protected, cred}
+	return nil, util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCSetDirProtected", arg, &gfs.SetDirProtectedReply{})
+}
+
+func replication(ctx context.Context, args []string) (interface{}, error) {
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid replication %q", args[1])
+	}
+	arg := gfs.SetReplicationArg{gfs.Path(args[0]), n, cred}
+	return nil, util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCSetReplication", arg, &gfs.SetReplicationReply{})
+}
+
+var placementKinds = map[string]gfs.PlacementKind{
+	"must":   gfs.PlacementMust,
+	"prefer": gfs.PlacementPrefer,
+	"avoid":  gfs.PlacementAvoid,
+}
+
+func placement(ctx context.Context, args []string) (interface{}, error) {
+	var constraints []gfs.PlacementConstraint
+	if args[1] != "none" {
+		for _, v := range strings.Split(args[1], ",") {
+			i := strings.Index(v, ":")
+			if i < 0 {
+				return nil, fmt.Errorf("invalid constraint %q, expect kind:label", v)
+			}
+			kind, ok := placementKinds[v[:i]]
+			if !ok {
+				return nil, fmt.Errorf("invalid kind %q, expect must, prefer or avoid", v[:i])
+			}
+			constraints = append(constraints, gfs.PlacementConstraint{kind, v[i+1:]})
+		}
+	}
+	arg := gfs.SetPlacementArg{gfs.Path(args[0]), constraints, cred}
+	return nil, util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCSetPlacement", arg, &gfs.SetPlacementReply{})
+}
+
+func throttle(ctx context.Context, args []string) (interface{}, error) {
+	creates, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return nil, err
+	}
+	appends, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return nil, err
+	}
+	policy := gfs.ThrottlePolicy{gfs.Path(args[0]), creates, appends}
+	return nil, util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCSetThrottle", gfs.SetThrottleArg{policy, cred}, &gfs.SetThrottleReply{})
+}
+
+func throttleList(ctx context.Context, args []string) (interface{}, error) {
+	var r gfs.ListThrottlesReply
+	if err := util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCListThrottles", gfs.ListThrottlesArg{cred}, &r); err != nil {
+		return nil, err
+	}
+	rows := [][]interface{}{{"PATH", "CREATES/S", "APPENDS/S"}}
+	for _, v := range r.Policies {
+		rows = append(rows, []interface{}{v.Path, v.CreatesPerSec, v.AppendsPerSec})
+	}
+	table(rows)
+	return r.Policies, nil
+}
+
+func topology(ctx context.Context, args []string) (interface{}, error) {
+	var t gfs.Topology
+	if args[1] != "none" {
+		var err error
+		if t, err = gfs.ParseTopology(args[1]); err != nil {
+			return nil, err
+		}
+	}
+	arg := gfs.SetTopologyArg{gfs.ServerAddress(args[0]), t, cred}
+	return nil, util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCSetTopology", arg, &gfs.SetTopologyReply{})
+}
+
+func topologyFile(ctx context.Context, args []string) (interface{}, error) {
+	f, err := os.Open(args[0])
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	topologies, err := gfs.ParseTopologyFile(f)
+	if err != nil {
+		return nil, err
+	}
+	for addr, t := range topologies {
+		arg := gfs.SetTopologyArg{addr, t, cred}
+		if err := util.Call(ctx, gfs.ServerAddress(*master), "Master.RPCSetTopology", arg, &gfs.SetTopologyReply{}); err != nil {
+			return nil, err
+		}
+	}
+	return topologies, nil
+}
+
+func importNamespace(ctx context.Context, args []string) (interface{}, error) {
+	f, err := os.Open(args[0])
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []gfs.ImportEntry
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 3 || len(fields) < 2 {
+			return nil, fmt.Errorf("line %v: expect <path> <size> [chunk size]", n)
+		}
+		e := gfs.ImportEntry{Path: gfs.Path(fields[0])}
+		if e.Size, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("line %v: %v", n, err)
+		}
+		if len(fields) == 3 {
+			if e.ChunkSize, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+				return nil, fmt.Errorf("line %v: %v", n, err)
+			}
+		}
+		entries = append(entries, e)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	// the master allocates every chunk before it answers
+	var chunks int64
+	for _, e := range entries {
+		size := e.ChunkSize
+		if size <= 0 {
+			size = gfs.MaxChunkSize
+		}
+		chunks += (e.Size + size - 1) / size
+	}
+	timeout := gfs.RPCTimeout + time.Duration(chunks)*gfs.ImportChunkTimeout
+
+	var r gfs.ImportNamespaceReply
+	if err := util.CallTimeout(ctx, timeout, gfs.ServerAddress(*master), "Master.RPCImportNamespace", gfs.ImportNamespaceArg{entries, cred}, &r); err != nil {
+		return nil, err
+	}
+	table([][]interface{}{{"imported", r.Files, "files,", r.Dirs, "directories,", r.Chunks, "chunks"}})
+	return r, nil
+}
+
+type reconcileResult struct {
+	Repaired int
+	Failed   int
+}
+
+func mirrorReconcile(ctx context.Context, args []string) (interface{}, error) {
+	secondary := client.NewClient(gfs.ServerAddress(args[0]))
+	repaired, failed, err := client.Reconcile(ctx, c, secondary, args[1])
+	if err != nil {
+		return nil, err
+	}
+	table([][]interface{}{{"repaired", repaired, "divergences,", failed, "left in", args[1]}})
+	return reconcileResult{repaired, failed}, nil
+}
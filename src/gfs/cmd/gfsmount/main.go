@@ -0,0 +1,51 @@
+// Command gfsmount mounts a gfs namespace as a local filesystem.
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+
+	"bazil.org/fuse"
+	log "github.com/Sirupsen/logrus"
+
+	"gfs"
+	"gfs/client"
+	gfsfuse "gfs/fuse"
+	"gfs/util"
+)
+
+func printUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("  gfsmount <master addr> <mount point>")
+	fmt.Println()
+	fmt.Println("Rpcs are dialed over TLS with the files named by $GFS_TLS_CERT, $GFS_TLS_KEY and $GFS_TLS_CA.")
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		printUsage()
+		return
+	}
+	master := gfs.ServerAddress(os.Args[1])
+	dir := os.Args[2]
+	config, _, err := util.TLSConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	util.SetTLSConfig(config)
+
+	// unmount on interrupt, which makes Mount return
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+	go func() {
+		<-ch
+		if err := fuse.Unmount(dir); err != nil {
+			log.Error("unmount: ", err)
+		}
+	}()
+
+	if err := gfsfuse.Mount(client.NewClient(master), dir); err != nil {
+		log.Fatal(err)
+	}
+}
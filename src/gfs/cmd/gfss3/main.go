@@ -0,0 +1,43 @@
+// Command gfss3 serves the buckets of a gfs namespace over the S3 REST API.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+
+	"gfs"
+	"gfs/client"
+	"gfs/gateway/s3"
+	"gfs/util"
+)
+
+func printUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("  gfss3 <master addr> <listen addr> [root dir]")
+	fmt.Println()
+	fmt.Println("The directories under root dir, / by default, are served as buckets.")
+	fmt.Println("Rpcs are dialed over TLS with the files named by $GFS_TLS_CERT, $GFS_TLS_KEY and $GFS_TLS_CA.")
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		printUsage()
+		return
+	}
+	master := gfs.ServerAddress(os.Args[1])
+	root := gfs.Path("/")
+	if len(os.Args) > 3 {
+		root = gfs.Path(os.Args[3])
+	}
+	config, _, err := util.TLSConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	util.SetTLSConfig(config)
+
+	log.Infof("serving the buckets of %v under %v on %v", master, root, os.Args[2])
+	log.Fatal(http.ListenAndServe(os.Args[2], s3.New(client.NewClient(master), root)))
+}
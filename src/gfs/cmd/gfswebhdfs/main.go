@@ -0,0 +1,39 @@
+// Command gfswebhdfs serves a gfs namespace over the WebHDFS REST API.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+
+	"gfs"
+	"gfs/client"
+	"gfs/gateway/webhdfs"
+	"gfs/util"
+)
+
+func printUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("  gfswebhdfs <master addr> <listen addr>")
+	fmt.Println()
+	fmt.Println("Hadoop reaches the namespace as webhdfs://<listen addr>/<path>.")
+	fmt.Println("Rpcs are dialed over TLS with the files named by $GFS_TLS_CERT, $GFS_TLS_KEY and $GFS_TLS_CA.")
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		printUsage()
+		return
+	}
+	master := gfs.ServerAddress(os.Args[1])
+	config, _, err := util.TLSConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	util.SetTLSConfig(config)
+
+	log.Infof("serving the namespace of %v over WebHDFS on %v", master, os.Args[2])
+	log.Fatal(http.ListenAndServe(os.Args[2], webhdfs.New(client.NewClient(master))))
+}
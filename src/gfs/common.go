@@ -1,6 +1,14 @@
 package gfs
 
-import "time"
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
 
 type Path string
 type ServerAddress string
@@ -10,22 +18,130 @@ type ChunkHandle int64
 type ChunkVersion int64
 type Checksum int64
 
+// UnixPrefix begins the address of a server listening on a unix domain
+// socket, followed by the path of the socket, e.g. unix:///run/gfs/m.sock.
+// Other addresses are tcp host:port ones.
+const UnixPrefix = "unix://"
+
+// Network returns the network and the address to listen on or dial for a.
+func (a ServerAddress) Network() (network, address string) {
+	if strings.HasPrefix(string(a), UnixPrefix) {
+		return "unix", strings.TrimPrefix(string(a), UnixPrefix)
+	}
+	return "tcp", string(a)
+}
+
 type DataBufferID struct {
 	Handle    ChunkHandle
 	TimeStamp int
 }
 
+// RequestID identifies a mutation of a client across its retries, the
+// primary applies it once. The zero RequestID is never deduplicated.
+type RequestID struct {
+	Client string
+	Seq    uint64
+}
+
 type Lease struct {
 	Primary     ServerAddress
 	Expire      time.Time
 	Secondaries []ServerAddress
+	Epoch       ChunkVersion // version of the chunk the lease is granted at
+	Token       string       // allowing mutations of the chunk, when the cluster authenticates
 }
 
 type PersistentChunkInfo struct {
-	Handle   ChunkHandle
-	Length   Offset
-	Version  ChunkVersion
-	Checksum Checksum
+	Handle    ChunkHandle
+	Length    Offset
+	Version   ChunkVersion
+	Checksum  Checksum
+	ChunkSize Offset // 0 for MaxChunkSize
+}
+
+// ChunkLength is the committed length of a chunk after a mutation.
+type ChunkLength struct {
+	Handle ChunkHandle
+	Length Offset
+}
+
+// FileHash is the content hash of a file, as returned by Client.GetFileHash.
+type FileHash struct {
+	Sum    []byte // SHA-256 of the SHA-256 of each chunk, in order
+	Chunks int64
+	Size   int64 // bytes hashed
+}
+
+// ETag returns h in the form of the ETag of an S3 multipart upload, the
+// hash followed by the number of parts.
+func (h FileHash) ETag() string {
+	return fmt.Sprintf("\"%x-%v\"", h.Sum, h.Chunks)
+}
+
+// FileInfo describes a file or a directory, as returned by Client.Stat.
+type FileInfo struct {
+	Path        Path
+	IsDir       bool
+	Size        int64 // bytes written and appended, reported by primaries
+	Chunks      int64
+	ChunkSize   int64
+	Replication int // target number of replicas
+	CreateTime  time.Time
+	ModTime     time.Time // last change of the data, or of the entries of a directory
+	ChangeTime  time.Time // last change of the data or the metadata
+	Owner       string
+	Group       string
+	Mode        os.FileMode // permission bits
+}
+
+// Credentials are the user a client acts as, with the groups of the user.
+// They are supplied by the client, an empty user acts as SuperUser. When the
+// cluster authenticates, the user and the groups are those of Token instead.
+type Credentials struct {
+	User   string
+	Groups []string
+	Token  string // signed by gfs/auth
+}
+
+// IsSuperUser returns whether the permissions are not checked for c.
+func (c Credentials) IsSuperUser() bool {
+	return c.User == "" || c.User == SuperUser
+}
+
+// Owner returns the owner of the files c creates.
+func (c Credentials) Owner() string {
+	if c.User == "" {
+		return SuperUser
+	}
+	return c.User
+}
+
+// InGroup returns whether c is in group.
+func (c Credentials) InGroup(group string) bool {
+	for _, g := range c.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// DiskUsage sums the files under a directory, as returned by Client.DiskUsage.
+type DiskUsage struct {
+	Files           int64
+	Dirs            int64 // the directory itself excluded
+	Chunks          int64
+	Bytes           int64
+	ReplicatedBytes int64 // bytes times the replication of each file
+}
+
+// QuotaUsage is the quota of a directory with the files and the bytes under
+// it, as returned by Client.GetQuotaUsage. The trash is not counted.
+type QuotaUsage struct {
+	MaxFiles int64 // 0 is unlimited
+	MaxBytes int64 // 0 is unlimited
+	Files    int64
+	Bytes    int64
 }
 
 type PathInfo struct {
@@ -35,8 +151,392 @@ type PathInfo struct {
 	IsDir bool
 
 	// if it is a file
-	Length int64
-	Chunks int64
+	Length      int64 // bytes written and appended, reported by primaries
+	Chunks      int64
+	Replication int // target number of replicas
+
+	CreateTime time.Time
+	ModTime    time.Time // last change of the data, or of the entries of a directory
+	ChangeTime time.Time // last change of the data or the metadata
+	Owner      string
+	Group      string
+	Mode       os.FileMode // permission bits
+}
+
+// a chunkserver known to the master
+type ServerInfo struct {
+	Address       ServerAddress
+	Domain        string // failure domain
+	Labels        map[string]string
+	Topology      Topology
+	LastHeartbeat time.Time
+	Chunks        int
+	StoredChunks  int   // chunks stored as reported by the chunkserver
+	DiskUsed      int64 // bytes of chunk files
+	DiskFree      int64 // bytes available on the disk, -1 if unknown
+	IOLoad        int64 // bytes read and written per second
+	MutationLoad  int64 // mutations applied per second, as primary or secondary
+	Leases        int   // unexpired leases held as primary
+	Draining      bool  // being decommissioned
+	Capacity      int64 // bytes of the disk, -1 if unknown
+	Version       string
+	Health        ServerHealth
+}
+
+// ServerHealth is the state of a chunkserver as seen by master.
+type ServerHealth string
+
+const (
+	ServerHealthy    ServerHealth = "healthy"
+	ServerSuspect    ServerHealth = "suspect"    // missed heartbeats for longer than SuspectHeartbeatAge
+	ServerDraining   ServerHealth = "draining"   // being decommissioned
+	ServerFull       ServerHealth = "full"       // free disk below DiskFreeReserve, gets no new chunks
+	ServerOverloaded ServerHealth = "overloaded" // io load above OverloadFactor times the mean
+)
+
+// LabelString returns the labels as sorted key=value pairs separated by commas.
+func (s ServerInfo) LabelString() string {
+	var labels []string
+	for k, v := range s.Labels {
+		if v != "" {
+			k += "=" + v
+		}
+		labels = append(labels, k)
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ",")
+}
+
+// Topology locates a chunkserver. Replicas of a chunk are spread across
+// zones, then racks, when possible. An empty zone or rack is unknown.
+type Topology struct {
+	Zone string
+	Rack string
+}
+
+// ClusterTopology is the layout of a cluster, zones with their racks and the
+// chunkservers in them, for external schedulers to place work near the data.
+// Zones, racks and servers are sorted by name, an empty name is unknown.
+type ClusterTopology struct {
+	Zones        []ZoneTopology
+	Usage        TopologyUsage
+	Chunks       int       // chunks known to master
+	NeedReplicas int       // chunks waiting for re-replication
+	Time         time.Time // when master took it
+}
+
+type ZoneTopology struct {
+	Name  string
+	Usage TopologyUsage
+	Racks []RackTopology
+}
+
+type RackTopology struct {
+	Name    string
+	Usage   TopologyUsage
+	Servers []ServerInfo
+}
+
+// TopologyUsage sums the chunkservers of a rack, a zone or the cluster.
+// Capacity and DiskFree sum the servers that report them.
+type TopologyUsage struct {
+	Servers  int
+	Healthy  int // servers in ServerHealthy
+	Replicas int // chunks stored
+	Capacity int64
+	DiskUsed int64
+	DiskFree int64
+}
+
+// Add adds a chunkserver to u.
+func (u *TopologyUsage) Add(s ServerInfo) {
+	u.Servers++
+	if s.Health == ServerHealthy {
+		u.Healthy++
+	}
+	u.Replicas += s.Chunks
+	u.DiskUsed += s.DiskUsed
+	if s.Capacity > 0 {
+		u.Capacity += s.Capacity
+	}
+	if s.DiskFree > 0 {
+		u.DiskFree += s.DiskFree
+	}
+}
+
+// ParseTopology parses a topology in the form rack=r1,zone=a, either part may be omitted.
+func ParseTopology(s string) (Topology, error) {
+	var t Topology
+	if s == "" {
+		return t, nil
+	}
+	for _, v := range strings.Split(s, ",") {
+		i := strings.Index(v, "=")
+		if i < 0 {
+			return t, Error{InvalidArgument, fmt.Sprintf("invalid topology %q, expect rack=r1,zone=a", s)}
+		}
+		switch v[:i] {
+		case "zone":
+			t.Zone = v[i+1:]
+		case "rack":
+			t.Rack = v[i+1:]
+		default:
+			return t, Error{InvalidArgument, fmt.Sprintf("unknown topology key %q, expect rack or zone", v[:i])}
+		}
+	}
+	return t, nil
+}
+
+func (t Topology) String() string {
+	var parts []string
+	if t.Rack != "" {
+		parts = append(parts, "rack="+t.Rack)
+	}
+	if t.Zone != "" {
+		parts = append(parts, "zone="+t.Zone)
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseTopologyFile parses lines of a chunkserver address and its topology,
+// e.g. "10.0.0.1:7000 rack=r1,zone=a". Empty lines and lines starting with # are skipped.
+func ParseTopologyFile(r io.Reader) (map[ServerAddress]Topology, error) {
+	ret := make(map[ServerAddress]Topology)
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, Error{InvalidArgument, fmt.Sprintf("line %v: expect <address> <topology>", n)}
+		}
+		t, err := ParseTopology(fields[1])
+		if err != nil {
+			return nil, Error{InvalidArgument, fmt.Sprintf("line %v: %v", n, err)}
+		}
+		ret[ServerAddress(fields[0])] = t
+	}
+	return ret, s.Err()
+}
+
+// a file of an imported namespace, chunks are allocated to cover Size.
+type ImportEntry struct {
+	Path      Path
+	Size      int64
+	ChunkSize int64 // 0 for MaxChunkSize
+}
+
+// NamespaceDump is a portable dump of the metadata of master, the namespace
+// with the chunks of its files and the throttle policies, stored as JSON.
+type NamespaceDump struct {
+	Format    int // NamespaceDumpFormat
+	Time      time.Time
+	Entries   []DumpEntry // parents before their children, the root first
+	Throttles []ThrottlePolicy
+}
+
+// NamespaceDumpFormat is the version of NamespaceDump.
+const NamespaceDumpFormat = 1
+
+// DumpEntry is a file or a directory of a NamespaceDump.
+type DumpEntry struct {
+	Path       Path
+	IsDir      bool
+	Protected  bool                  `json:",omitempty"` // from empty directory collection
+	Length     int64                 `json:",omitempty"` // set by clients
+	Chunks     int64                 `json:",omitempty"`
+	ChunkSize  int64                 `json:",omitempty"`
+	Replicas   int                   `json:",omitempty"` // 0 for DefaultNumReplicas
+	Placement  []PlacementConstraint `json:",omitempty"`
+	Generation int64                 `json:",omitempty"`
+	ModTime    time.Time
+	ChangeTime time.Time
+	Owner      string
+	Group      string
+	Mode       os.FileMode
+	MaxFiles   int64                 `json:",omitempty"`
+	MaxBytes   int64                 `json:",omitempty"`
+	Xattrs     map[string][]byte     `json:",omitempty"` // extended attributes
+	ChunkInfo  []PersistentChunkInfo `json:",omitempty"` // of a file, in order
+}
+
+// ChunkSizes are the chunk sizes a file can be created with. Small chunks
+// suit latency sensitive files, big ones huge sequential files.
+var ChunkSizes = []int64{1 << 20, 4 << 20, 16 << 20, MaxChunkSize}
+
+// ValidChunkSize returns whether size is one of ChunkSizes.
+func ValidChunkSize(size int64) bool {
+	for _, v := range ChunkSizes {
+		if v == size {
+			return true
+		}
+	}
+	return false
+}
+
+// a master rpc recorded in the slow query log
+type SlowQuery struct {
+	Method   string
+	Args     string
+	Caller   string // remote address of the caller
+	Start    time.Time
+	Latency  time.Duration
+	LockWait time.Duration // time spent waiting for namespace locks
+	Error    string
+}
+
+// PlacementKind is how a placement constraint is enforced.
+type PlacementKind int
+
+const (
+	PlacementMust   PlacementKind = iota // replicas are only placed on matching servers
+	PlacementPrefer                      // matching servers are chosen first
+	PlacementAvoid                       // matching servers are chosen last
+)
+
+// a placement constraint of the chunks of a file on chunkserver labels.
+// Label is either a key, matching servers having it, or key=value.
+type PlacementConstraint struct {
+	Kind  PlacementKind
+	Label string
+}
+
+// Match returns whether a server with labels matches the constraint label.
+func (c PlacementConstraint) Match(labels map[string]string) bool {
+	if i := strings.Index(c.Label, "="); i >= 0 {
+		v, ok := labels[c.Label[:i]]
+		return ok && v == c.Label[i+1:]
+	}
+	_, ok := labels[c.Label]
+	return ok
+}
+
+// ThrottlePolicy limits the operations under a directory, including its
+// subdirectories. Creates are limited by master, appends to a chunk by its
+// primary. A rate of 0 is unlimited.
+type ThrottlePolicy struct {
+	Path          Path
+	CreatesPerSec float64
+	AppendsPerSec float64
+}
+
+// Unlimited returns whether the policy limits nothing.
+func (p ThrottlePolicy) Unlimited() bool {
+	return p.CreatesPerSec <= 0 && p.AppendsPerSec <= 0
+}
+
+// DurabilityMode tells when a chunkserver syncs its mutation journal to disk.
+type DurabilityMode int
+
+const (
+	DurabilityGroupCommit DurabilityMode = iota // mutations are acknowledged once synced, along with the concurrent ones
+	DurabilitySync                              // mutations are acknowledged once synced, one at a time
+	DurabilityAsync                             // the journal is synced every JournalSyncInterval, a crash loses the mutations since
+)
+
+// Divergence is how a replica read by a client disagrees with master or
+// with another replica of the chunk.
+type Divergence int
+
+const (
+	DivergentVersion Divergence = iota // the replica is older than the chunk on master
+	DivergentLength                    // the replica is shorter than another one of its version
+	DivergentData                      // the replica holds other data than the others of its version and length
+)
+
+func (d Divergence) String() string {
+	switch d {
+	case DivergentVersion:
+		return "version"
+	case DivergentLength:
+		return "length"
+	case DivergentData:
+		return "data"
+	}
+	return fmt.Sprintf("divergence %d", int(d))
+}
+
+// ReplicaDivergence is a replica disagreeing with the others of its chunk,
+// as found by Client.VerifyFile.
+type ReplicaDivergence struct {
+	Index      ChunkIndex
+	Handle     ChunkHandle
+	Location   ServerAddress
+	Divergence Divergence
+}
+
+// FsckKind is a kind of inconsistency found by fsck.
+type FsckKind int
+
+const (
+	FsckMissingChunk    FsckKind = iota // a chunk of a file is unknown to master or has no replica
+	FsckUnderReplicated                 // a chunk has fewer live replicas than the replication of its file
+	FsckOrphanedChunk                   // a chunk of no file, on master or on a chunkserver
+	FsckVersionMismatch                 // a replica on a chunkserver is at another version than on master
+	FsckMissingReplica                  // a replica master knows of is not held by its chunkserver
+	FsckBadMapping                      // the chunks of a file and the file of a chunk disagree
+)
+
+func (k FsckKind) String() string {
+	switch k {
+	case FsckMissingChunk:
+		return "missing"
+	case FsckUnderReplicated:
+		return "under-replicated"
+	case FsckOrphanedChunk:
+		return "orphaned"
+	case FsckVersionMismatch:
+		return "version-mismatch"
+	case FsckMissingReplica:
+		return "missing-replica"
+	case FsckBadMapping:
+		return "bad-mapping"
+	}
+	return fmt.Sprintf("fsck kind %d", int(k))
+}
+
+// FsckProblem is an inconsistency found by fsck, of a file, a chunk or a
+// replica on a server, the fields that do not apply are left empty.
+type FsckProblem struct {
+	Kind   FsckKind
+	Path   Path
+	Handle ChunkHandle
+	Server ServerAddress
+	Detail string
+}
+
+// UnderReplicatedChunk is a chunk with fewer live replicas, those not on
+// draining servers, than the replication of its file.
+type UnderReplicatedChunk struct {
+	Handle      ChunkHandle
+	Path        Path
+	Replicas    int // none if the chunk is missing
+	Live        int
+	Replication int
+}
+
+// MasterMode is how master serves the operations of clients, changed by an
+// admin for upgrades, backups and migrations.
+type MasterMode int
+
+const (
+	MasterNormal      MasterMode = iota
+	MasterReadOnly               // reads are served, mutations refused with ReadOnly
+	MasterMaintenance            // operations are refused with InMaintenance, the metadata is stored and left alone
+)
+
+func (m MasterMode) String() string {
+	switch m {
+	case MasterNormal:
+		return "normal"
+	case MasterReadOnly:
+		return "read-only"
+	case MasterMaintenance:
+		return "maintenance"
+	}
+	return fmt.Sprintf("master mode %d", int(m))
 }
 
 type MutationType int
@@ -45,48 +545,229 @@ const (
 	MutationWrite = iota
 	MutationAppend
 	MutationPad
+	MutationTruncate // cuts the chunk to the offset
 )
 
 type ErrorCode int
 
+// error codes are sent on the wire, new ones must be appended at the end
 const (
-	Success = iota
+	Success ErrorCode = iota
 	UnknownError
 	Timeout
 	AppendExceedChunkSize
 	WriteExceedChunkSize
-	ReadEOF
+	ReadEOF // read past the committed length of a chunk
 	NotAvailableForCopy
+
+	// chunk
+	ChunkNotFound
+	StaleVersion
+	LeaseExpired
+	NotPrimary
+	ChecksumMismatch
+	DataNotFound // forwarded data not in the download buffer
+	NoReplica
+	NotEnoughServers
+	ServerNotFound
+
+	// namespace
+	PathNotFound
+	PathExists
+	NotDirectory
+	IsDirectory
+
+	InvalidArgument
+
+	PhysicalEOF // chunk file ends before the committed length
+
+	NotRegistered // chunkserver heartbeats before registering with master
+
+	ServerBusy // retry later, e.g. the download buffer is full
+	Throttled  // rate limited by a throttle policy
+
+	GenerationMismatch // the file was extended by another writer
+
+	DirectoryNotEmpty // a directory with children is deleted without recursion
+	PermissionDenied  // the mode of a file or a directory does not allow the user
+	Unauthenticated   // no valid token, when the cluster authenticates
+	QuotaExceeded     // the quota of a parent directory is used up
+	ReadOnly          // master is in read-only mode
+	InMaintenance     // master is in maintenance mode
+	ChunkExists       // a new chunk is created under the handle of one a chunkserver has
+	XattrNotFound     // the extended attribute is not set on the path
 )
 
+var errorCodeNames = [...]string{
+	Success:               "success",
+	UnknownError:          "unknown error",
+	Timeout:               "timeout",
+	AppendExceedChunkSize: "append exceeds chunk size",
+	WriteExceedChunkSize:  "write exceeds chunk size",
+	ReadEOF:               "read EOF",
+	NotAvailableForCopy:   "not available for copy",
+	ChunkNotFound:         "chunk not found",
+	StaleVersion:          "stale version",
+	LeaseExpired:          "lease expired",
+	NotPrimary:            "not primary",
+	ChecksumMismatch:      "checksum mismatch",
+	DataNotFound:          "data not found",
+	NoReplica:             "no replica",
+	NotEnoughServers:      "not enough servers",
+	ServerNotFound:        "server not found",
+	PathNotFound:          "path not found",
+	PathExists:            "path exists",
+	NotDirectory:          "not a directory",
+	IsDirectory:           "is a directory",
+	InvalidArgument:       "invalid argument",
+	PhysicalEOF:           "physical EOF",
+	NotRegistered:         "not registered",
+	ServerBusy:            "server busy",
+	Throttled:             "throttled",
+	GenerationMismatch:    "generation mismatch",
+	DirectoryNotEmpty:     "directory not empty",
+	PermissionDenied:      "permission denied",
+	Unauthenticated:       "unauthenticated",
+	QuotaExceeded:         "quota exceeded",
+	ReadOnly:              "read only",
+	InMaintenance:         "in maintenance",
+	ChunkExists:           "chunk exists",
+	XattrNotFound:         "xattr not found",
+}
+
+func (c ErrorCode) String() string {
+	if c < 0 || int(c) >= len(errorCodeNames) {
+		return fmt.Sprintf("error code %d", int(c))
+	}
+	return errorCodeNames[c]
+}
+
+// Error makes a code usable as a target of errors.Is, e.g. errors.Is(err, gfs.ReadEOF).
+func (c ErrorCode) Error() string {
+	return c.String()
+}
+
 // extended error type with error code
 type Error struct {
 	Code ErrorCode
 	Err  string
 }
 
+// Error returns the code followed by the message. net/rpc sends errors
+// returned by handlers as strings, the code is restored by ParseError.
 func (e Error) Error() string {
-	return e.Err
+	return e.Code.String() + ": " + e.Err
+}
+
+// Is reports whether target is an Error or an ErrorCode with the same code.
+func (e Error) Is(target error) bool {
+	switch t := target.(type) {
+	case Error:
+		return t.Code == e.Code
+	case ErrorCode:
+		return t == e.Code
+	}
+	return false
+}
+
+// ParseError restores an Error from its string form.
+// ok is false if s is not the string of an Error.
+func ParseError(s string) (e Error, ok bool) {
+	i := strings.Index(s, ": ")
+	if i < 0 {
+		return Error{}, false
+	}
+	for c, name := range errorCodeNames {
+		if name == s[:i] {
+			return Error{ErrorCode(c), s[i+2:]}, true
+		}
+	}
+	return Error{}, false
 }
 
 var (
 	Debug int
 )
 
+// Version of the software, sent by chunkservers when they register.
+// It may be set at build time with -ldflags "-X gfs.Version=...".
+var Version = "dev"
+
+// GitCommit the software is built from, set like Version.
+var GitCommit = "unknown"
+
+// ProtocolLevel is raised on every change of the rpcs that older daemons
+// cannot work with, e.g. RPCRegisterServer at level 2, lease epochs in
+// mutations at level 3, data streams at level 4.
+const ProtocolLevel = 4
+
+// BuildInfo tells which software a daemon runs.
+type BuildInfo struct {
+	Version   string
+	GitCommit string
+	Protocol  int      // ProtocolLevel of the build
+	Features  []string // optional features supported by the daemon
+}
+
+// Build returns the BuildInfo of the running software with the given features.
+func Build(features ...string) BuildInfo {
+	return BuildInfo{Version, GitCommit, ProtocolLevel, features}
+}
+
+func (b BuildInfo) String() string {
+	return fmt.Sprintf("%v (%v), protocol %v", b.Version, b.GitCommit, b.Protocol)
+}
+
 // system config
 const (
 	// chunk
 	LeaseExpire        = 3 * time.Second //1 * time.Minute
+	LeaseIdleTimeout   = 1 * time.Second // leases not used by a primary for longer are released
 	DefaultNumReplicas = 3
 	MinimumNumReplicas = 2
-	MaxChunkSize       = 32 << 20 // 512KB DEBUG ONLY 64 << 20
+	MaxNumReplicas     = 8
+	MaxChunkSize       = 32 << 20 // 512KB DEBUG ONLY 64 << 20, the default and the largest chunk size
 	MaxAppendSize      = MaxChunkSize / 4
 	DeletedFilePrefix  = "__del__"
+	WalkPageSize       = 1000     // entries of a recursive listing returned at most by a call
+	FsckMaxProblems    = 1000     // problems returned at most by fsck, the others are counted
+	StatusMaxChunks    = 1000     // under-replicated chunks listed at most by the replication status
+	ListPageSize       = 1000     // entries of a directory returned at most by a call
+	BatchMaxPaths      = 1000     // paths of a batch of namespace operations at most, clients split larger ones
+	HandleReservation  = 1024     // chunk handles master reserves on disk at a time, those left are skipped after a crash
+	XattrMaxName       = 255      // bytes of the name of an extended attribute at most
+	XattrMaxBytes      = 64 << 10 // bytes of the names and values of the extended attributes of a path at most
+	SuperUser          = "root"
+	DefaultFileMode    = 0644            // of files created
+	DefaultDirMode     = 0755            // of directories created, and of the root
+	ChunkTokenExpire   = 5 * time.Minute // chunk tokens outlive the leases and the cached locations they come with
+	TrashDir           = Path("/.trash") // deleted paths are kept in, under the time of their deletion
 
 	// master
 	ServerCheckInterval = 400 * time.Millisecond //
 	MasterStoreInterval = 30 * time.Hour         // 30 * time.Minute
 	ServerTimeout       = 1 * time.Second
+	SlowQueryThreshold  = 100 * time.Millisecond
+	SlowQueryLogSize    = 128
+
+	EmptyDirExpire        = 0 // directories empty for longer are removed, 0 disables
+	EmptyDirCheckInterval = 10 * time.Second
+
+	TrashRetention     = 24 * time.Hour // deleted paths are kept in TrashDir for, 0 removes them right away
+	TrashCheckInterval = 10 * time.Second
+
+	ReReplicationConcurrency = 4 // copies running at a time
+	ReReplicationBackoff     = ServerCheckInterval
+	ReReplicationMaxBackoff  = 30 * time.Second
+
+	DiskFreeReserve = 4 * MaxChunkSize // servers with less free disk get no new chunks
+	OverloadFactor  = 2                // servers with io load above this times the mean are avoided
+
+	RebalanceInterval  = 10 * time.Minute
+	RebalanceMaxMoves  = 8   // chunks moved per round at most
+	RebalanceThreshold = 0.2 // utilization gap between servers tolerated, 2 is the mean
+
+	SuspectHeartbeatAge = 2 * HeartbeatInterval // a server reported dead is removed if silent for longer
 
 	// chunk server
 	HeartbeatInterval    = 200 * time.Millisecond
@@ -95,8 +776,55 @@ const (
 	GarbageCollectionInt = 30 * time.Hour // 1 * time.Day
 	DownloadBufferExpire = 2 * time.Minute
 	DownloadBufferTick   = 30 * time.Second
+	MaxOpenChunkFiles    = 256 // chunk files kept open by a chunkserver
+
+	DownloadBufferMaxBytes       = 512 << 20 // pushed data held by a chunkserver at most
+	DownloadBufferClientMaxBytes = 128 << 20 // pushed data of a single client held at most
+	DownloadBufferSpillMaxBytes  = 4 << 30   // pushed data spilled to disk at most
+
+	LoadMaxMemoryBytes = 0                      // heap of a chunkserver past which work is shed, 0 means unlimited
+	LoadMaxGoroutines  = 0                      // goroutines of a chunkserver past which work is shed, 0 means unlimited
+	LoadSampleInterval = 100 * time.Millisecond // of the heap of a chunkserver
+
+	JournalCheckpointBytes = 64 << 20        // mutation journal size that triggers a checkpoint
+	JournalSyncInterval    = 1 * time.Second // of the mutation journal in DurabilityAsync
+
+	CopyPieceBytes     = 1 << 20          // pieces chunks are copied in by re-replication and rebalancing
+	CopyMaxBytesPerSec = 0                // bandwidth of the copies sent by a chunkserver, 0 means unlimited
+	CopyMaxRestarts    = 3                // copies restarted when the chunk is mutated meanwhile, before giving up
+	CopyTimeout        = 10 * time.Minute // upper bound of a copy, waited by master
+	RepairMaxRanges    = 64               // ranges mutated kept per chunk to repair stale replicas
+
+	SecondaryFailureThreshold = 3 // failures in a row to reach a secondary before reporting it
+
+	DedupWindow = ClientTryTimeout // results of mutations are kept for retries as long as a client tries
+	DedupTick   = 1 * time.Second
+
+	// rpc
+	RPCTimeout           = 10 * time.Second // upper bound of a single rpc
+	RPCDialTimeout       = 1 * time.Second
+	RPCKeepAlive         = 30 * time.Second
+	RPCMaxIdleConns      = 8 // per server
+	RPCIdleConnTimeout   = 90 * time.Second
+	RPCPoolCheckInterval = 10 * time.Second
+	ImportChunkTimeout   = 1 * time.Second // allowed per chunk allocated by a namespace import, on top of RPCTimeout
 
 	// client
-	ClientTryTimeout = 2*LeaseExpire + 3*ServerTimeout
-	LeaseBufferTick  = 500 * time.Millisecond
+	ClientTryTimeout     = 2*LeaseExpire + 3*ServerTimeout
+	LeaseBufferTick      = 500 * time.Millisecond
+	LocationBufferExpire = LeaseExpire   // replica locations are cached as long as a lease
+	FileBufferSize       = 4 << 20       // writes buffered by a File
+	DivergenceGrace      = ServerTimeout // a replica may lag behind another for as long while a mutation is applied
+	ReadCacheBlockBytes  = 1 << 20       // clients cache and read ahead chunks in blocks of as many bytes
+	ReadCacheExpire      = LeaseExpire   // a cached range is read for as long as locations are cached, mutations of other clients are seen afterwards
+	ClientParallelism    = 4             // chunks of a read or a write transferred at once
+
+	MirrorQueueSize = 1024 // mutations waiting to be mirrored to a secondary cluster
+
+	TraceSlowSpan = 1 * time.Second // spans of a trace taking longer are logged as warnings
+
+	LogMaxBytes   = 100 << 20 // size of a log file past which it is rotated, see util.LogConfig
+	LogMaxBackups = 5         // rotated log files kept
+
+	ShutdownDrainTimeout = 10 * time.Second // servers shutting down gracefully wait as long for the rpcs in flight
 )
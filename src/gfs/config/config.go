@@ -0,0 +1,490 @@
+// Package config is the configuration of the daemons and the clients of
+// gfs, over the defaults of package gfs: from a file, in the subset of TOML
+// of Parse, overridden by environment variables, then by key=value settings,
+// e.g. given as flags. The keys are the ones of the fields of Config, by
+// section, e.g. chunkserver.heartbeat_interval, and lease_expire for the
+// ones outside the sections. The keys tagged reload are changed by Reload
+// while the servers run, the others once they restart.
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gfs"
+	"gfs/chunkserver"
+	"gfs/client"
+	"gfs/master"
+	"gfs/util"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Config is the configuration of the servers and the clients of a cluster.
+type Config struct {
+	LeaseExpire time.Duration `config:"lease_expire"` // of the leases granted by master, known to the chunkservers
+
+	Log         Log         `config:"log"`
+	Master      Master      `config:"master"`
+	ChunkServer ChunkServer `config:"chunkserver"`
+	Client      Client      `config:"client"`
+}
+
+// Log is the configuration of the logs, see util.LogConfig. Its keys are
+// the variables of util.LogConfigFromEnv.
+type Log struct {
+	Level      string `config:"level,reload"`
+	Levels     string `config:"levels,reload"` // e.g. heartbeat=warning,replication=debug
+	Format     string `config:"format"`
+	File       string `config:"file"`
+	MaxBytes   int64  `config:"max_bytes"`
+	MaxBackups int    `config:"max_backups"`
+}
+
+// Master is the configuration of master.
+type Master struct {
+	Replicas                 int           `config:"replicas,reload"` // of the files created
+	ServerTimeout            time.Duration `config:"server_timeout,reload"`
+	TrashRetention           time.Duration `config:"trash_retention,reload"`
+	EmptyDirExpire           time.Duration `config:"empty_dir_expire,reload"`
+	SlowQueryThreshold       time.Duration `config:"slow_query_threshold,reload"`
+	ReReplicationConcurrency int           `config:"rereplication_concurrency,reload"`
+	RebalanceMaxMoves        int           `config:"rebalance_max_moves,reload"`
+}
+
+// ChunkServer is the configuration of the chunkservers.
+type ChunkServer struct {
+	HeartbeatInterval            time.Duration `config:"heartbeat_interval,reload"`
+	DownloadBufferExpire         time.Duration `config:"download_buffer_expire,reload"`
+	DownloadBufferMaxBytes       int64         `config:"download_buffer_max_bytes,reload"`
+	DownloadBufferClientMaxBytes int64         `config:"download_buffer_client_max_bytes,reload"`
+	DownloadBufferSpillMaxBytes  int64         `config:"download_buffer_spill_max_bytes"`
+	MaxOpenFiles                 int           `config:"max_open_files,reload"`
+	Durability                   string        `config:"durability,reload"` // group-commit, sync or async
+	CopyPieceBytes               int           `config:"copy_piece_bytes,reload"`
+	CopyMaxBytesPerSec           float64       `config:"copy_max_bytes_per_sec,reload"`
+	LoadMaxMemoryBytes           int64         `config:"load_max_memory_bytes,reload"`
+	LoadMaxGoroutines            int           `config:"load_max_goroutines,reload"`
+	ClientBytesPerSec            float64       `config:"client_bytes_per_sec,reload"`
+	ForegroundBytesPerSec        float64       `config:"foreground_bytes_per_sec,reload"`
+	BackgroundBytesPerSec        float64       `config:"background_bytes_per_sec,reload"`
+	FailureDomain                string        `config:"failure_domain"`
+	LocalReadDir                 string        `config:"local_read_dir"` // of the sockets of short-circuit reads, none if empty
+}
+
+// Client is the configuration of the clients.
+type Client struct {
+	ChunkSize       int64         `config:"chunk_size"` // of the files created
+	Parallelism     int           `config:"parallelism"`
+	ReadCacheBytes  int64         `config:"read_cache_bytes"` // no cache if 0
+	ReadAhead       int           `config:"read_ahead"`
+	HedgedReadDelay time.Duration `config:"hedged_read_delay"` // no hedged reads if 0
+	LocalReadDir    string        `config:"local_read_dir"`
+}
+
+// durabilities are the names of the durability modes.
+var durabilities = map[string]gfs.DurabilityMode{
+	"group-commit": gfs.DurabilityGroupCommit,
+	"sync":         gfs.DurabilitySync,
+	"async":        gfs.DurabilityAsync,
+}
+
+// Default returns the configuration of the constants of package gfs.
+func Default() Config {
+	return Config{
+		LeaseExpire: gfs.LeaseExpire,
+		Log: Log{
+			Level:      util.DefaultLogConfig.Level.String(),
+			Format:     util.DefaultLogConfig.Format,
+			MaxBytes:   util.DefaultLogConfig.MaxBytes,
+			MaxBackups: util.DefaultLogConfig.MaxBackups,
+		},
+		Master: Master{
+			Replicas:                 gfs.DefaultNumReplicas,
+			ServerTimeout:            gfs.ServerTimeout,
+			TrashRetention:           gfs.TrashRetention,
+			EmptyDirExpire:           gfs.EmptyDirExpire,
+			SlowQueryThreshold:       gfs.SlowQueryThreshold,
+			ReReplicationConcurrency: gfs.ReReplicationConcurrency,
+			RebalanceMaxMoves:        gfs.RebalanceMaxMoves,
+		},
+		ChunkServer: ChunkServer{
+			HeartbeatInterval:            gfs.HeartbeatInterval,
+			DownloadBufferExpire:         gfs.DownloadBufferExpire,
+			DownloadBufferMaxBytes:       gfs.DownloadBufferMaxBytes,
+			DownloadBufferClientMaxBytes: gfs.DownloadBufferClientMaxBytes,
+			DownloadBufferSpillMaxBytes:  gfs.DownloadBufferSpillMaxBytes,
+			MaxOpenFiles:                 gfs.MaxOpenChunkFiles,
+			Durability:                   "group-commit",
+			CopyPieceBytes:               gfs.CopyPieceBytes,
+			CopyMaxBytesPerSec:           gfs.CopyMaxBytesPerSec,
+			LoadMaxMemoryBytes:           gfs.LoadMaxMemoryBytes,
+			LoadMaxGoroutines:            gfs.LoadMaxGoroutines,
+		},
+		Client: Client{
+			ChunkSize:   gfs.MaxChunkSize,
+			Parallelism: gfs.ClientParallelism,
+		},
+	}
+}
+
+// Load returns the default configuration overridden by the file, if it is
+// not empty, then by the environment, see FromEnv, then by the settings,
+// see Set. The result is validated.
+func Load(file string, settings []string) (Config, error) {
+	c := Default()
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return c, err
+		}
+		err = c.Parse(f)
+		f.Close()
+		if err != nil {
+			return c, fmt.Errorf("%v: %v", file, err)
+		}
+	}
+	if err := c.FromEnv(); err != nil {
+		return c, err
+	}
+	for _, s := range settings {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok {
+			return c, fmt.Errorf("bad setting %q, key=value expected", s)
+		}
+		if err := c.Set(key, value); err != nil {
+			return c, err
+		}
+	}
+	return c, c.Validate()
+}
+
+// Parse sets the keys of a file of lines key = value, under [section]
+// headers. Values are integers, floats, booleans, durations such as "30s",
+// and strings, quoted or not. Comments begin with #.
+func (c *Config) Parse(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	section := ""
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(s.Text()))
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if _, ok := c.field(section); !ok {
+				return fmt.Errorf("line %v: unknown section %q", n, section)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("line %v: key = value expected", n)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if strings.HasPrefix(value, "\"") {
+			var err error
+			if value, err = strconv.Unquote(value); err != nil {
+				return fmt.Errorf("line %v: bad string %v", n, value)
+			}
+		}
+		if section != "" {
+			key = section + "." + key
+		}
+		if err := c.Set(key, value); err != nil {
+			return fmt.Errorf("line %v: %v", n, err)
+		}
+	}
+	return s.Err()
+}
+
+// stripComment returns line up to a # that is not in a string.
+func stripComment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && quoted:
+			i++
+		case line[i] == '"':
+			quoted = !quoted
+		case line[i] == '#' && !quoted:
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// FromEnv sets the keys named by the environment as GFS_<KEY>, in upper case
+// with the dots as underscores, e.g. $GFS_CHUNKSERVER_HEARTBEAT_INTERVAL.
+func (c *Config) FromEnv() error {
+	for _, key := range c.Keys() {
+		name := "GFS_" + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+		if value, ok := os.LookupEnv(name); ok {
+			if err := c.Set(key, value); err != nil {
+				return fmt.Errorf("$%v: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Keys returns the keys of the configuration, in the order of the fields.
+func (c *Config) Keys() []string {
+	var keys []string
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			name, _ := tag(v.Type().Field(i))
+			name = prefix + name
+			if f := v.Field(i); f.Kind() == reflect.Struct {
+				walk(name+".", f)
+			} else {
+				keys = append(keys, name)
+			}
+		}
+	}
+	walk("", reflect.ValueOf(c).Elem())
+	return keys
+}
+
+// tag returns the name of the key of a field, and whether it is reloaded.
+func tag(f reflect.StructField) (name string, reload bool) {
+	name, opt, _ := strings.Cut(f.Tag.Get("config"), ",")
+	return name, opt == "reload"
+}
+
+// reloaded returns whether a key is changed by Reload.
+func (c *Config) reloaded(key string) bool {
+	v := reflect.ValueOf(c).Elem()
+	reload := false
+	for _, name := range strings.Split(key, ".") {
+		for i := 0; i < v.NumField(); i++ {
+			if n, r := tag(v.Type().Field(i)); n == name {
+				v, reload = v.Field(i), r
+				break
+			}
+		}
+	}
+	return reload
+}
+
+// field returns the field of a key, or of a section.
+func (c *Config) field(key string) (reflect.Value, bool) {
+	v := reflect.ValueOf(c).Elem()
+	for _, name := range strings.Split(key, ".") {
+		if v.Kind() != reflect.Struct {
+			return v, false
+		}
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			if n, _ := tag(v.Type().Field(i)); n == name {
+				v, found = v.Field(i), true
+				break
+			}
+		}
+		if !found {
+			return v, false
+		}
+	}
+	return v, true
+}
+
+// Set sets a key to a value given as text.
+func (c *Config) Set(key, value string) error {
+	f, ok := c.field(key)
+	if !ok || f.Kind() == reflect.Struct {
+		return fmt.Errorf("unknown key %q", key)
+	}
+	var err error
+	switch {
+	case f.Type() == reflect.TypeOf(time.Duration(0)):
+		var d time.Duration
+		d, err = time.ParseDuration(value)
+		f.SetInt(int64(d))
+	case f.Kind() == reflect.Int || f.Kind() == reflect.Int64:
+		var n int64
+		n, err = strconv.ParseInt(value, 0, 64)
+		f.SetInt(n)
+	case f.Kind() == reflect.Float64:
+		var x float64
+		x, err = strconv.ParseFloat(value, 64)
+		f.SetFloat(x)
+	case f.Kind() == reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(value)
+		f.SetBool(b)
+	default:
+		f.SetString(value)
+	}
+	if err != nil {
+		return fmt.Errorf("bad value %q of %v", value, key)
+	}
+	return nil
+}
+
+// Validate checks the values of the configuration and how they relate.
+func (c *Config) Validate() error {
+	cs := c.ChunkServer
+	switch {
+	case cs.HeartbeatInterval <= 0:
+		return fmt.Errorf("chunkserver.heartbeat_interval should be positive")
+	case c.LeaseExpire <= cs.HeartbeatInterval:
+		return fmt.Errorf("lease_expire %v should be above chunkserver.heartbeat_interval %v, leases are extended by heartbeats", c.LeaseExpire, cs.HeartbeatInterval)
+	case c.Master.ServerTimeout <= cs.HeartbeatInterval:
+		return fmt.Errorf("master.server_timeout %v should be above chunkserver.heartbeat_interval %v", c.Master.ServerTimeout, cs.HeartbeatInterval)
+	case c.Master.Replicas < gfs.MinimumNumReplicas || c.Master.Replicas > gfs.MaxNumReplicas:
+		return fmt.Errorf("master.replicas %v should be within [%v, %v]", c.Master.Replicas, gfs.MinimumNumReplicas, gfs.MaxNumReplicas)
+	case cs.DownloadBufferExpire <= 0:
+		return fmt.Errorf("chunkserver.download_buffer_expire should be positive")
+	case !gfs.ValidChunkSize(c.Client.ChunkSize):
+		return fmt.Errorf("client.chunk_size %v is not one of %v", c.Client.ChunkSize, gfs.ChunkSizes)
+	case c.Client.Parallelism < 1:
+		return fmt.Errorf("client.parallelism should be at least 1")
+	}
+	if _, err := c.LogConfig(); err != nil {
+		return err
+	}
+	if f := c.Log.Format; f != "text" && f != "json" {
+		return fmt.Errorf("unknown log.format %q, text or json expected", f)
+	}
+	if _, ok := durabilities[cs.Durability]; !ok {
+		return fmt.Errorf("unknown chunkserver.durability %q, group-commit, sync or async expected", cs.Durability)
+	}
+
+	// the others are counts, sizes, durations and rates, 0 if unlimited or disabled
+	for _, key := range c.Keys() {
+		f, _ := c.field(key)
+		if (f.Kind() == reflect.Int || f.Kind() == reflect.Int64) && f.Int() < 0 || f.Kind() == reflect.Float64 && f.Float() < 0 {
+			return fmt.Errorf("%v should not be negative", key)
+		}
+	}
+	return nil
+}
+
+// LogConfig returns the configuration of the logs, to be set up by
+// util.SetupLogging.
+func (c *Config) LogConfig() (util.LogConfig, error) {
+	lc := util.LogConfig{Format: c.Log.Format, File: c.Log.File, MaxBytes: c.Log.MaxBytes, MaxBackups: c.Log.MaxBackups}
+	var err error
+	if lc.Level, err = log.ParseLevel(c.Log.Level); err != nil {
+		return lc, fmt.Errorf("bad log.level: %v", err)
+	}
+	if c.Log.Levels != "" {
+		if lc.Levels, err = util.ParseLogLevels(c.Log.Levels); err != nil {
+			return lc, fmt.Errorf("bad log.levels: %v", err)
+		}
+	}
+	return lc, nil
+}
+
+// Reload returns the configuration with the keys tagged reload of next, and
+// the other keys whose value differs in next, which are kept until a
+// restart.
+func (c Config) Reload(next Config) (Config, []string) {
+	var kept []string
+	for _, key := range c.Keys() {
+		old, _ := c.field(key)
+		f, _ := next.field(key)
+		if reflect.DeepEqual(old.Interface(), f.Interface()) {
+			continue
+		}
+		if c.reloaded(key) {
+			old.Set(f)
+		} else {
+			kept = append(kept, key)
+		}
+	}
+	return c, kept
+}
+
+// ApplyMaster configures m.
+func (c *Config) ApplyMaster(m *master.Master) {
+	m.SetLeaseExpire(c.LeaseExpire)
+	c.TuneMaster(m)
+}
+
+// TuneMaster sets the keys of m changed by Reload, see ApplyMaster.
+func (c *Config) TuneMaster(m *master.Master) {
+	m.SetDefaultReplicas(c.Master.Replicas)
+	m.SetServerTimeout(c.Master.ServerTimeout)
+	m.SetTrashRetention(c.Master.TrashRetention)
+	m.SetEmptyDirExpire(c.Master.EmptyDirExpire)
+	m.SetSlowQueryThreshold(c.Master.SlowQueryThreshold)
+	m.SetReReplicationConcurrency(c.Master.ReReplicationConcurrency)
+	m.SetRebalanceMaxMoves(c.Master.RebalanceMaxMoves)
+}
+
+// ApplyChunkServer configures a chunkserver.
+func (c *Config) ApplyChunkServer(s *chunkserver.ChunkServer) error {
+	cs := c.ChunkServer
+	s.SetLeaseExpire(c.LeaseExpire)
+	s.SetDownloadBufferSpill(cs.DownloadBufferSpillMaxBytes)
+	c.TuneChunkServer(s)
+	if cs.FailureDomain != "" {
+		s.SetFailureDomain(cs.FailureDomain)
+	}
+	if cs.LocalReadDir != "" {
+		return s.SetLocalReads(cs.LocalReadDir)
+	}
+	return nil
+}
+
+// TuneChunkServer sets the keys of a chunkserver changed by Reload, see
+// ApplyChunkServer.
+func (c *Config) TuneChunkServer(s *chunkserver.ChunkServer) {
+	cs := c.ChunkServer
+	s.SetHeartbeatInterval(cs.HeartbeatInterval)
+	s.SetDownloadBufferExpire(cs.DownloadBufferExpire)
+	s.SetDownloadBufferMax(cs.DownloadBufferMaxBytes, cs.DownloadBufferClientMaxBytes)
+	s.SetMaxOpenFiles(cs.MaxOpenFiles)
+	s.SetDurability(durabilities[cs.Durability])
+	s.SetCopyLimits(cs.CopyPieceBytes, cs.CopyMaxBytesPerSec)
+	s.SetLoadLimits(cs.LoadMaxMemoryBytes, cs.LoadMaxGoroutines)
+	s.SetRateLimits(cs.ClientBytesPerSec, cs.ForegroundBytesPerSec, cs.BackgroundBytesPerSec)
+}
+
+// ClientOptions returns the options of the clients.
+func (c *Config) ClientOptions() []client.Option {
+	cc := c.Client
+	opts := []client.Option{client.WithChunkSize(cc.ChunkSize), client.WithParallelism(cc.Parallelism)}
+	if cc.ReadCacheBytes > 0 {
+		opts = append(opts, client.WithReadCache(cc.ReadCacheBytes), client.WithReadAhead(cc.ReadAhead))
+	}
+	if cc.HedgedReadDelay > 0 {
+		opts = append(opts, client.WithHedgedReads(cc.HedgedReadDelay))
+	}
+	if cc.LocalReadDir != "" {
+		opts = append(opts, client.WithLocalReads(cc.LocalReadDir))
+	}
+	return opts
+}
+
+// Settings are key=value settings given by repeated flags.
+type Settings []string
+
+func (s *Settings) String() string { return strings.Join(*s, ",") }
+
+// Set adds a setting.
+func (s *Settings) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// Flags registers the flags -config, the file of the configuration,
+// defaulting to $GFS_CONFIG, and -set key=value on fs, and returns the
+// func loading the configuration once fs is parsed.
+func Flags(fs *flag.FlagSet) func() (Config, error) {
+	file := fs.String("config", os.Getenv("GFS_CONFIG"), "configuration file, defaults to $GFS_CONFIG")
+	var settings Settings
+	fs.Var(&settings, "set", "key=value setting of the configuration over the file and the environment, repeated")
+	return func() (Config, error) {
+		return Load(*file, settings)
+	}
+}
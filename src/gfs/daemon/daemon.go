@@ -0,0 +1,194 @@
+// Package daemon sets up the processes of the gfs servers: their TLS, auth
+// and encryption from the environment, the reload of their configuration,
+// their pidfile, running in the background and shutting down on a signal.
+package daemon
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+
+	"gfs/auth"
+	"gfs/chunkserver"
+	"gfs/config"
+	"gfs/util"
+)
+
+// ServeHTTP serves the http endpoints of a server, e.g. /metrics, if addr is given.
+func ServeHTTP(addr string, h http.Handler) {
+	if addr == "" {
+		return
+	}
+	go func() {
+		log.Fatal(http.ListenAndServe(addr, h))
+	}()
+}
+
+// AuthSecret returns the secret shared by the servers, read from the file
+// $GFS_AUTH_SECRET_FILE, nil if it is unset.
+func AuthSecret() []byte {
+	filename := os.Getenv("GFS_AUTH_SECRET_FILE")
+	if filename == "" {
+		return nil
+	}
+	key, err := auth.ReadSecretFile(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return key
+}
+
+// KeyProvider returns the provider of the keys chunks are sealed with, from
+// the file $GFS_ENCRYPTION_KEY_FILE, the variable $GFS_ENCRYPTION_KEY or the
+// command $GFS_ENCRYPTION_KEY_COMMAND, nil if they are unset.
+func KeyProvider() chunkserver.KeyProvider {
+	var kp chunkserver.KeyProvider
+	var err error
+	switch {
+	case os.Getenv("GFS_ENCRYPTION_KEY_FILE") != "":
+		kp, err = chunkserver.KeysFromFile(os.Getenv("GFS_ENCRYPTION_KEY_FILE"))
+	case os.Getenv("GFS_ENCRYPTION_KEY") != "":
+		kp, err = chunkserver.KeysFromEnv("GFS_ENCRYPTION_KEY")
+	case os.Getenv("GFS_ENCRYPTION_KEY_COMMAND") != "":
+		args := strings.Fields(os.Getenv("GFS_ENCRYPTION_KEY_COMMAND"))
+		kp = chunkserver.CommandKeys(args[0], args[1:]...)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return kp
+}
+
+// SetTLS sets the TLS config of the rpcs of the process from the environment,
+// and returns it for the connections the server accepts.
+func SetTLS() (*tls.Config, bool) {
+	config, plaintext, err := util.TLSConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	util.SetTLSConfig(config)
+	return config, plaintext
+}
+
+// ExportSpans exports the spans of the traces of the process as JSON lines
+// appended to the file $GFS_TRACE_FILE, if it is set.
+func ExportSpans() {
+	filename := os.Getenv("GFS_TRACE_FILE")
+	if filename == "" {
+		return
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	util.SetSpanExporter(util.NewJSONSpanExporter(f))
+}
+
+// SetupLogging sets up the logs of the process from the configuration.
+// The file of the logs, if any, is returned to be closed.
+func SetupLogging(conf *config.Config) io.Closer {
+	lc, err := conf.LogConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	logs, err := util.SetupLogging(lc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return logs
+}
+
+// ReloadOnHangup reloads conf with load on SIGHUP, setting the levels of
+// the logs and the keys of the server changed by config.Reload with tune.
+func ReloadOnHangup(conf *config.Config, load func() (config.Config, error), tune func(*config.Config)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			next, err := load()
+			if err != nil {
+				log.Warning("configuration not reloaded: ", err)
+				continue
+			}
+			var kept []string
+			*conf, kept = conf.Reload(next)
+			if len(kept) > 0 {
+				log.Warningf("configuration reloaded but %v, changed once restarted", strings.Join(kept, ", "))
+			}
+			lc, _ := conf.LogConfig()
+			util.SetLogLevels(lc.Level, lc.Levels)
+			tune(conf)
+			log.Info("configuration reloaded")
+		}
+	}()
+}
+
+// WaitForShutdown blocks until the process is asked to stop by SIGINT or
+// SIGTERM, and returns the signal.
+func WaitForShutdown() os.Signal {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-stop
+	signal.Stop(stop)
+	return sig
+}
+
+// WritePidFile writes the pid of the process to a file, failing if the file
+// names another process still running. Nothing is written if name is empty.
+func WritePidFile(name string) error {
+	if name == "" {
+		return nil
+	}
+	if data, err := os.ReadFile(name); err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err == nil && pid != os.Getpid() && syscall.Kill(pid, 0) == nil {
+			return fmt.Errorf("pidfile %v names process %v, which is running", name, pid)
+		}
+	}
+	return os.WriteFile(name, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// RemovePidFile removes the pidfile of the process written by WritePidFile.
+func RemovePidFile(name string) {
+	if name == "" {
+		return
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		log.Warning("pidfile not removed: ", err)
+	}
+}
+
+// backgroundEnv tells a process it was restarted in the background.
+const backgroundEnv = "GFS_DAEMON_CHILD"
+
+// Background restarts the process in the background, in a session of its
+// own without the terminal, and exits once the new process has started,
+// printing its pid. The new process returns right away. Its standard
+// streams are /dev/null, the logs should be written to log.file.
+func Background() {
+	if os.Getenv(backgroundEnv) != "" {
+		os.Unsetenv(backgroundEnv)
+		return
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), backgroundEnv+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(cmd.Process.Pid)
+	os.Exit(0)
+}
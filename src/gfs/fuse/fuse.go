@@ -0,0 +1,257 @@
+// Package fuse exposes the gfs namespace as a local filesystem through FUSE.
+// VFS calls are translated into client operations: lookups stat the name
+// looked up, readdir uses List, reads and writes go
+// through a client.File per open handle, and files opened with O_APPEND are
+// written by record append.
+package fuse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+
+	"gfs"
+	"gfs/client"
+)
+
+// FS is a gfs filesystem served by fs.Serve.
+type FS struct {
+	c client.ClientAPI
+}
+
+// New returns a filesystem backed by the client.
+func New(c client.ClientAPI) *FS {
+	return &FS{c}
+}
+
+// Mount mounts the filesystem at dir and serves it until it is unmounted.
+// It returns the error of serving, or of the mount, which is known once the
+// kernel has answered it.
+func Mount(c client.ClientAPI, dir string) error {
+	conn, err := fuse.Mount(dir, fuse.FSName("gfs"), fuse.Subtype("gfs"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := fs.Serve(conn, New(c)); err != nil {
+		return err
+	}
+	<-conn.Ready
+	return conn.MountError
+}
+
+func (f *FS) Root() (fs.Node, error) {
+	return &Dir{f, "/"}, nil
+}
+
+// Dir is a directory node.
+type Dir struct {
+	fs   *FS
+	path gfs.Path
+}
+
+// File is a file node.
+type File struct {
+	fs   *FS
+	path gfs.Path
+}
+
+// Handle is an open file. Record appends are used if it is opened with O_APPEND.
+type Handle struct {
+	f      *client.File
+	path   gfs.Path
+	c      client.ClientAPI
+	append bool
+}
+
+func join(dir gfs.Path, name string) gfs.Path {
+	return gfs.Path(path.Join(string(dir), name))
+}
+
+// errno translates gfs error codes into errnos.
+func errno(err error) error {
+	var e gfs.Error
+	if err == nil || !errors.As(err, &e) {
+		return err
+	}
+	switch e.Code {
+	case gfs.PathNotFound:
+		return fuse.ENOENT
+	case gfs.PathExists:
+		return fuse.EEXIST
+	case gfs.NotDirectory:
+		return fuse.Errno(syscall.ENOTDIR)
+	case gfs.IsDirectory:
+		return fuse.Errno(syscall.EISDIR)
+	case gfs.DirectoryNotEmpty:
+		return fuse.Errno(syscall.ENOTEMPTY)
+	case gfs.PermissionDenied, gfs.Unauthenticated:
+		return fuse.Errno(syscall.EACCES)
+	case gfs.QuotaExceeded:
+		return fuse.Errno(syscall.EDQUOT)
+	case gfs.InvalidArgument, gfs.WriteExceedChunkSize, gfs.AppendExceedChunkSize:
+		return fuse.Errno(syscall.EINVAL)
+	}
+	return fuse.EIO
+}
+
+func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0755
+	return nil
+}
+
+func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	p := join(d.path, name)
+	info, err := d.fs.c.Stat(ctx, p)
+	if err != nil {
+		return nil, errno(err)
+	}
+	if info.IsDir {
+		return &Dir{d.fs, p}, nil
+	}
+	return &File{d.fs, p}, nil
+}
+
+func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	list, err := d.fs.c.List(ctx, d.path)
+	if err != nil {
+		return nil, errno(err)
+	}
+	ret := make([]fuse.Dirent, len(list))
+	for i, v := range list {
+		ret[i] = fuse.Dirent{Name: v.Name, Type: fuse.DT_File}
+		if v.IsDir {
+			ret[i].Type = fuse.DT_Dir
+		}
+	}
+	return ret, nil
+}
+
+func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
+	p := join(d.path, req.Name)
+	if err := d.fs.c.Mkdir(ctx, p); err != nil {
+		return nil, errno(err)
+	}
+	return &Dir{d.fs, p}, nil
+}
+
+func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	p := join(d.path, req.Name)
+	if err := d.fs.c.Create(ctx, p); err != nil {
+		return nil, nil, errno(err)
+	}
+	node := &File{d.fs, p}
+	h, err := node.open(req.Flags)
+	if err != nil {
+		return nil, nil, err
+	}
+	return node, h, nil
+}
+
+func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	return errno(d.fs.c.Delete(ctx, join(d.path, req.Name)))
+}
+
+func (d *Dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+	nd, ok := newDir.(*Dir)
+	if !ok {
+		return fuse.Errno(syscall.ENOTDIR)
+	}
+	return errno(d.fs.c.Rename(ctx, join(d.path, req.OldName), join(nd.path, req.NewName)))
+}
+
+func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
+	file, err := f.fs.c.Open(ctx, f.path)
+	if err != nil {
+		return errno(err)
+	}
+	defer file.Close()
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errno(err)
+	}
+	a.Mode = 0644
+	a.Size = uint64(size)
+	a.BlockSize = gfs.MaxChunkSize
+	return nil
+}
+
+func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	return f.open(req.Flags)
+}
+
+// open opens a handle, which lives beyond the request that opened it.
+func (f *File) open(flags fuse.OpenFlags) (*Handle, error) {
+	file, err := f.fs.c.Open(context.Background(), f.path)
+	if err != nil {
+		return nil, errno(err)
+	}
+	return &Handle{
+		f:      file,
+		path:   f.path,
+		c:      f.fs.c,
+		append: flags&fuse.OpenAppend != 0,
+	}, nil
+}
+
+func (h *Handle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	buf := make([]byte, req.Size)
+	n, err := h.f.ReadAt(buf, req.Offset)
+	if err != nil && err != io.EOF {
+		return errno(err)
+	}
+	resp.Data = buf[:n]
+	return nil
+}
+
+func (h *Handle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	if h.append {
+		// the offset is chosen by the primary, buffered writes go first
+		if err := h.f.Sync(); err != nil {
+			return errno(err)
+		}
+		if _, err := h.c.Append(ctx, h.path, req.Data); err != nil {
+			return errno(err)
+		}
+		resp.Size = len(req.Data)
+		return nil
+	}
+
+	n, err := h.f.WriteAt(req.Data, req.Offset)
+	resp.Size = n
+	return errno(err)
+}
+
+func (h *Handle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	return errno(h.f.Sync())
+}
+
+func (h *Handle) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	return errno(h.f.Sync())
+}
+
+func (h *Handle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	return errno(h.f.Close())
+}
+
+var (
+	_ fs.FS                 = (*FS)(nil)
+	_ fs.NodeStringLookuper = (*Dir)(nil)
+	_ fs.HandleReadDirAller = (*Dir)(nil)
+	_ fs.NodeMkdirer        = (*Dir)(nil)
+	_ fs.NodeCreater        = (*Dir)(nil)
+	_ fs.NodeRemover        = (*Dir)(nil)
+	_ fs.NodeRenamer        = (*Dir)(nil)
+	_ fs.NodeOpener         = (*File)(nil)
+	_ fs.HandleReader       = (*Handle)(nil)
+	_ fs.HandleWriter       = (*Handle)(nil)
+	_ fs.HandleFlusher      = (*Handle)(nil)
+	_ fs.HandleReleaser     = (*Handle)(nil)
+)
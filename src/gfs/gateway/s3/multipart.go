@@ -0,0 +1,240 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"gfs"
+)
+
+// The parts of a multipart upload are files under the directory of the
+// upload in UploadDir, by part number. Completing the upload copies the
+// parts, in order, each to the end of a new file, which then replaces the
+// object. They are written at the end rather than record appended, which
+// may pad chunks and duplicate records. The MD5 of a part is kept in an
+// extended attribute of its file, the ETags listed to complete the upload
+// are checked against them. The bucket and the key of the upload are kept
+// in an extended attribute of its directory, the requests of an upload are
+// only served for them.
+
+// MaxParts is the highest part number of a multipart upload.
+const MaxParts = 10000
+
+// partSumXattr is the extended attribute holding the MD5 of a part.
+const partSumXattr = "s3.md5"
+
+// uploadKeyXattr is the extended attribute holding the bucket and the key
+// of an upload.
+const uploadKeyXattr = "s3.key"
+
+type initiateResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string
+	Key      string
+	UploadId string
+}
+
+type completeRequest struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+type completeResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+func (g *Gateway) uploadPath(id string) gfs.Path {
+	return gfs.Path(path.Join(string(g.root), UploadDir, "multipart-"+id))
+}
+
+func partPath(dir gfs.Path, number int) gfs.Path {
+	return gfs.Path(fmt.Sprintf("%v/%05d", dir, number))
+}
+
+// serveUpload serves the requests of multipart uploads.
+func (g *Gateway) serveUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	q := r.URL.Query()
+	id := q.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPost && id == "":
+		if _, ok := q["uploads"]; !ok {
+			return errNotAllowed
+		}
+		return g.initiateUpload(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		return g.uploadPart(w, r, bucket, key, id, q.Get("partNumber"))
+	case r.Method == http.MethodPost:
+		return g.completeUpload(w, r, bucket, key, id)
+	case r.Method == http.MethodDelete:
+		if err := g.checkUpload(r.Context(), id, bucket, key); err != nil {
+			return err
+		}
+		if _, _, err := g.c.DeleteAll(r.Context(), g.uploadPath(id)); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return errNotAllowed
+}
+
+// checkUpload returns errNoSuchUpload if there is no upload id of key in
+// bucket.
+func (g *Gateway) checkUpload(ctx context.Context, id, bucket, key string) error {
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return errNoSuchUpload
+	}
+	info, err := g.c.Stat(ctx, g.uploadPath(id))
+	if err != nil {
+		return toAPIError(err, errNoSuchUpload)
+	}
+	if !info.IsDir {
+		return errNoSuchUpload
+	}
+	stored, err := g.c.GetXattr(ctx, g.uploadPath(id), uploadKeyXattr)
+	if err != nil {
+		return toAPIError(err, errNoSuchUpload)
+	}
+	if string(stored) != uploadKey(bucket, key) {
+		return errNoSuchUpload
+	}
+	return nil
+}
+
+// uploadKey is the value of uploadKeyXattr of an upload of key in bucket.
+func uploadKey(bucket, key string) string {
+	return bucket + "/" + key
+}
+
+func (g *Gateway) initiateUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	ctx := r.Context()
+	if err := g.checkBucket(ctx, bucket); err != nil {
+		return err
+	}
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	if err := g.mkdirAll(ctx, g.uploadPath(id)); err != nil {
+		return err
+	}
+	if err := g.c.SetXattr(ctx, g.uploadPath(id), uploadKeyXattr, []byte(uploadKey(bucket, key))); err != nil {
+		g.c.DeleteAll(ctx, g.uploadPath(id))
+		return err
+	}
+	writeXML(w, initiateResult{Bucket: bucket, Key: key, UploadId: id})
+	return nil
+}
+
+func (g *Gateway) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key, id, number string) error {
+	ctx := r.Context()
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 || n > MaxParts {
+		return apiError{http.StatusBadRequest, "InvalidArgument", "invalid part number " + number}
+	}
+	if err := g.checkUpload(ctx, id, bucket, key); err != nil {
+		return err
+	}
+
+	// parts uploaded again replace the old ones
+	dir := g.uploadPath(id)
+	tmp := gfs.Path(fmt.Sprintf("%v/tmp-%05d", dir, n))
+	g.c.Delete(ctx, tmp)
+	if err := g.c.Create(ctx, tmp); err != nil {
+		return err
+	}
+	sum := md5.New()
+	if _, err := g.c.WriteFrom(ctx, tmp, 0, io.TeeReader(r.Body, sum)); err != nil {
+		g.c.Delete(ctx, tmp)
+		return err
+	}
+	if err := g.move(ctx, tmp, partPath(dir, n)); err != nil {
+		return err
+	}
+	if err := g.c.SetXattr(ctx, partPath(dir, n), partSumXattr, sum.Sum(nil)); err != nil {
+		return err
+	}
+	w.Header().Set("ETag", md5ETag(sum.Sum(nil)))
+	return nil
+}
+
+// offsetWriter writes a file sequentially from off on.
+type offsetWriter struct {
+	g   *Gateway
+	ctx context.Context
+	p   gfs.Path
+	off gfs.Offset
+}
+
+func (ow *offsetWriter) Write(b []byte) (int, error) {
+	n, err := ow.g.c.Write(ow.ctx, ow.p, ow.off, b)
+	ow.off += gfs.Offset(n)
+	return int(n), err
+}
+
+func (g *Gateway) completeUpload(w http.ResponseWriter, r *http.Request, bucket, key, id string) error {
+	ctx := r.Context()
+	if err := g.checkUpload(ctx, id, bucket, key); err != nil {
+		return err
+	}
+	var req completeRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		return apiError{http.StatusBadRequest, "MalformedXML", err.Error()}
+	}
+	if len(req.Parts) == 0 {
+		return apiError{http.StatusBadRequest, "MalformedXML", "no part"}
+	}
+	// the parts listed should be those uploaded, by their ETags
+	dir := g.uploadPath(id)
+	sums := md5.New()
+	for i, part := range req.Parts {
+		if i > 0 && part.PartNumber <= req.Parts[i-1].PartNumber {
+			return errBadPartOrder
+		}
+		stored, err := g.c.GetXattr(ctx, partPath(dir, part.PartNumber), partSumXattr)
+		if err != nil {
+			return errBadPart
+		}
+		sum, err := hex.DecodeString(strings.Trim(part.ETag, "\""))
+		if err != nil || !bytes.Equal(sum, stored) {
+			return errBadPart
+		}
+		sums.Write(sum)
+	}
+
+	// the parts are written one after the other
+	object := gfs.Path(path.Join(string(dir), "object"))
+	g.c.Delete(ctx, object)
+	if err := g.c.Create(ctx, object); err != nil {
+		return err
+	}
+	ow := &offsetWriter{g, ctx, object, 0}
+	for _, part := range req.Parts {
+		if _, err := g.c.ReadTo(ctx, partPath(dir, part.PartNumber), 0, -1, ow); err != nil {
+			return err
+		}
+	}
+	if err := g.replace(ctx, object, bucket, key); err != nil {
+		return g.notFound(ctx, bucket, err)
+	}
+	g.c.DeleteAll(ctx, dir)
+
+	etag := fmt.Sprintf("\"%x-%v\"", sums.Sum(nil), len(req.Parts))
+	writeXML(w, completeResult{Location: "/" + bucket + "/" + key, Bucket: bucket, Key: key, ETag: etag})
+	return nil
+}
@@ -0,0 +1,605 @@
+// Package s3 serves the core of the S3 REST API on top of a gfs client, so
+// that S3 tooling can use the cluster as a backend. Buckets are the
+// directories under the root of the gateway, and objects are the files under
+// them, the slashes of their keys making directories. Requests are path
+// style, /bucket/key, and are not authenticated: the gateway acts with the
+// credentials of its client, see client.WithUser and client.WithToken.
+//
+// Objects are written to a temporary file, which is then renamed over the
+// old object, so a failed upload leaves the old object. The ETags are the
+// MD5 of the data where S3 tooling checks them, on PUT and multipart
+// uploads. The MD5 is not stored, so objects read get the content hash of
+// their file as ETag, see client.GetFileHash, and listed ones an ETag made
+// of the size and the modification time of the file.
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gfs"
+	"gfs/client"
+)
+
+// UploadDir is the directory under the root of a gateway holding the
+// objects being uploaded, it is not a bucket.
+const UploadDir = ".uploads"
+
+// MaxKeys is the default and the maximum number of keys of a listing.
+const MaxKeys = 1000
+
+// Gateway is an http.Handler serving buckets under root.
+type Gateway struct {
+	c    client.ClientAPI
+	root gfs.Path
+}
+
+// New returns a gateway serving the directories under root as buckets.
+func New(c client.ClientAPI, root gfs.Path) *Gateway {
+	return &Gateway{c, root}
+}
+
+// apiError is an S3 error response.
+type apiError struct {
+	status int
+	code   string
+	msg    string
+}
+
+func (e apiError) Error() string {
+	return e.code + ": " + e.msg
+}
+
+var (
+	errNoSuchBucket = apiError{http.StatusNotFound, "NoSuchBucket", "the bucket does not exist"}
+	errNoSuchKey    = apiError{http.StatusNotFound, "NoSuchKey", "the key does not exist"}
+	errNoSuchUpload = apiError{http.StatusNotFound, "NoSuchUpload", "the upload does not exist"}
+	errBucketExists = apiError{http.StatusConflict, "BucketAlreadyOwnedByYou", "the bucket exists"}
+	errNotEmpty     = apiError{http.StatusConflict, "BucketNotEmpty", "the bucket is not empty"}
+	errBadBucket    = apiError{http.StatusBadRequest, "InvalidBucketName", "invalid bucket name"}
+	errBadKey       = apiError{http.StatusBadRequest, "InvalidArgument", "invalid key"}
+	errBadRange     = apiError{http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "the range is not satisfiable"}
+	errBadPart      = apiError{http.StatusBadRequest, "InvalidPart", "a part is not uploaded or its ETag does not match"}
+	errBadPartOrder = apiError{http.StatusBadRequest, "InvalidPartOrder", "the parts are not in ascending order"}
+	errNotAllowed   = apiError{http.StatusMethodNotAllowed, "MethodNotAllowed", "the method is not allowed"}
+)
+
+// toAPIError translates gfs error codes into S3 errors, notFound is the one
+// of a path not found.
+func toAPIError(err error, notFound apiError) apiError {
+	var ae apiError
+	if errors.As(err, &ae) {
+		return ae
+	}
+	var e gfs.Error
+	if !errors.As(err, &e) {
+		return apiError{http.StatusInternalServerError, "InternalError", err.Error()}
+	}
+	switch e.Code {
+	case gfs.PathNotFound, gfs.NotDirectory:
+		return notFound
+	case gfs.PermissionDenied, gfs.Unauthenticated:
+		return apiError{http.StatusForbidden, "AccessDenied", e.Err}
+	case gfs.QuotaExceeded:
+		return apiError{http.StatusForbidden, "QuotaExceeded", e.Err}
+	case gfs.InvalidArgument, gfs.IsDirectory, gfs.PathExists:
+		return apiError{http.StatusBadRequest, "InvalidArgument", e.Err}
+	case gfs.ServerBusy, gfs.Throttled, gfs.InMaintenance:
+		return apiError{http.StatusServiceUnavailable, "SlowDown", e.Err}
+	case gfs.ReadOnly:
+		return apiError{http.StatusForbidden, "AccessDenied", e.Err}
+	}
+	return apiError{http.StatusInternalServerError, "InternalError", e.Err}
+}
+
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, e apiError) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(e.status)
+	if r.Method != http.MethodHead {
+		xml.NewEncoder(w).Encode(errorResponse{Code: e.code, Message: e.msg, Resource: r.URL.Path})
+	}
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+var bucketName = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// validKey returns whether a key maps to a path, with no empty, . or ..
+// segment.
+func validKey(key string) bool {
+	return key != "" && len(key) <= 1024 && path.Clean("/"+key) == "/"+key
+}
+
+func (g *Gateway) bucketPath(bucket string) gfs.Path {
+	return gfs.Path(path.Join(string(g.root), bucket))
+}
+
+func (g *Gateway) objectPath(bucket, key string) gfs.Path {
+	return gfs.Path(path.Join(string(g.root), bucket, key))
+}
+
+// ServeHTTP serves an S3 request.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key := strings.TrimPrefix(r.URL.Path, "/"), ""
+	if i := strings.IndexByte(bucket, '/'); i >= 0 {
+		bucket, key = bucket[:i], bucket[i+1:]
+	}
+	q := r.URL.Query()
+	var err error
+	switch {
+	case bucket == "":
+		if r.Method != http.MethodGet {
+			err = errNotAllowed
+			break
+		}
+		err = g.listBuckets(w, r)
+	case !bucketName.MatchString(bucket):
+		err = errBadBucket
+	case key == "":
+		err = g.serveBucket(w, r, bucket)
+	case !validKey(strings.TrimSuffix(key, "/")):
+		err = errBadKey
+	case q.Get("uploadId") != "" || r.Method == http.MethodPost:
+		err = g.serveUpload(w, r, bucket, key)
+	default:
+		err = g.serveObject(w, r, bucket, key)
+	}
+	if err != nil {
+		writeError(w, r, toAPIError(err, errNoSuchKey))
+	}
+}
+
+type bucketEntry struct {
+	Name         string
+	CreationDate time.Time
+}
+
+type listBucketsResult struct {
+	XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+func (g *Gateway) listBuckets(w http.ResponseWriter, r *http.Request) error {
+	ls, err := g.c.List(r.Context(), g.root)
+	if err != nil {
+		return err
+	}
+	var res listBucketsResult
+	for _, info := range ls {
+		if !info.IsDir || !bucketName.MatchString(info.Name) {
+			continue
+		}
+		st, err := g.c.Stat(r.Context(), g.bucketPath(info.Name))
+		if err != nil {
+			continue // deleted meanwhile
+		}
+		res.Buckets = append(res.Buckets, bucketEntry{info.Name, st.ChangeTime.UTC()})
+	}
+	writeXML(w, res)
+	return nil
+}
+
+func (g *Gateway) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	ctx, p := r.Context(), g.bucketPath(bucket)
+	switch r.Method {
+	case http.MethodPut:
+		if err := g.c.Mkdir(ctx, p); errors.Is(err, gfs.PathExists) {
+			return errBucketExists
+		} else if err != nil {
+			return err
+		}
+		w.Header().Set("Location", "/"+bucket)
+		return nil
+	case http.MethodHead:
+		return g.checkBucket(ctx, bucket)
+	case http.MethodDelete:
+		if err := g.checkBucket(ctx, bucket); err != nil {
+			return err
+		}
+		// the directories left by deleted objects do not count
+		errFile := errors.New("file found")
+		err := g.c.Walk(ctx, p, func(info gfs.FileInfo) error {
+			if !info.IsDir {
+				return errFile
+			}
+			return nil
+		})
+		if err == errFile {
+			return errNotEmpty
+		}
+		if err != nil {
+			return err
+		}
+		if _, _, err := g.c.DeleteAll(ctx, p); err != nil {
+			return toAPIError(err, errNoSuchBucket)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case http.MethodGet:
+		if err := g.checkBucket(ctx, bucket); err != nil {
+			return err
+		}
+		return g.listObjects(w, r, bucket)
+	}
+	return errNotAllowed
+}
+
+// checkBucket returns errNoSuchBucket if there is no bucket.
+func (g *Gateway) checkBucket(ctx context.Context, bucket string) error {
+	info, err := g.c.Stat(ctx, g.bucketPath(bucket))
+	if err != nil {
+		return toAPIError(err, errNoSuchBucket)
+	}
+	if !info.IsDir {
+		return errNoSuchBucket
+	}
+	return nil
+}
+
+// etag returns the ETag of a file whose MD5 is not known.
+func etag(info gfs.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.Size, info.ModTime.UnixNano())
+}
+
+func md5ETag(sum []byte) string {
+	return "\"" + hex.EncodeToString(sum) + "\""
+}
+
+func (g *Gateway) serveObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	switch r.Method {
+	case http.MethodPut:
+		if strings.HasSuffix(key, "/") {
+			// a folder marker of S3 tooling
+			if err := g.mkdirAll(r.Context(), g.objectPath(bucket, key)); err != nil {
+				return g.notFound(r.Context(), bucket, err)
+			}
+			w.Header().Set("ETag", md5ETag(md5.New().Sum(nil)))
+			return nil
+		}
+		return g.putObject(w, r, bucket, key)
+	case http.MethodGet, http.MethodHead:
+		return g.getObject(w, r, bucket, key)
+	case http.MethodDelete:
+		err := g.c.Delete(r.Context(), g.objectPath(bucket, key))
+		if err != nil && !errors.Is(err, gfs.PathNotFound) {
+			return err
+		}
+		if err != nil {
+			if err := g.checkBucket(r.Context(), bucket); err != nil {
+				return err
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return errNotAllowed
+}
+
+// notFound returns errNoSuchBucket for a path not found if the bucket is
+// missing, or the S3 error of err.
+func (g *Gateway) notFound(ctx context.Context, bucket string, err error) error {
+	if errors.Is(err, gfs.PathNotFound) {
+		if berr := g.checkBucket(ctx, bucket); berr != nil {
+			return berr
+		}
+	}
+	return toAPIError(err, errNoSuchKey)
+}
+
+// mkdirAll makes p and its parents under the root.
+func (g *Gateway) mkdirAll(ctx context.Context, p gfs.Path) error {
+	rel := strings.TrimPrefix(path.Clean(string(p)), strings.TrimSuffix(string(g.root), "/"))
+	dir := strings.TrimSuffix(string(g.root), "/")
+	if strings.Trim(rel, "/") == "" {
+		return nil
+	}
+	for _, name := range strings.Split(strings.Trim(rel, "/"), "/") {
+		dir += "/" + name
+		if err := g.c.Mkdir(ctx, gfs.Path(dir)); err != nil && !errors.Is(err, gfs.PathExists) {
+			return err
+		}
+	}
+	return nil
+}
+
+// tempPath returns a new path to upload into.
+func (g *Gateway) tempPath(name string) (gfs.Path, error) {
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+	return gfs.Path(path.Join(string(g.root), UploadDir, name+"-"+id)), nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// move renames the file source to target, replacing the file there. That
+// one is renamed aside first, and back if the rename fails, so a failed move
+// leaves it in place.
+func (g *Gateway) move(ctx context.Context, source, target gfs.Path) error {
+	err := g.c.Rename(ctx, source, target)
+	if !errors.Is(err, gfs.PathExists) {
+		return err
+	}
+	if info, err := g.c.Stat(ctx, target); err == nil && info.IsDir {
+		return gfs.Error{gfs.IsDirectory, fmt.Sprintf("path %v is a directory", target)}
+	}
+	old, err := g.tempPath("old")
+	if err != nil {
+		return err
+	}
+	if err := g.c.Rename(ctx, target, old); err != nil {
+		return err
+	}
+	if err := g.c.Rename(ctx, source, target); err != nil {
+		g.c.Rename(ctx, old, target)
+		return err
+	}
+	return g.c.Delete(ctx, old)
+}
+
+// replace moves the file tmp over the object key, making its parents.
+func (g *Gateway) replace(ctx context.Context, tmp gfs.Path, bucket, key string) error {
+	p := g.objectPath(bucket, key)
+	if err := g.mkdirAll(ctx, gfs.Path(path.Dir(string(p)))); err != nil {
+		return err
+	}
+	return g.move(ctx, tmp, p)
+}
+
+func (g *Gateway) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	ctx := r.Context()
+	if err := g.checkBucket(ctx, bucket); err != nil {
+		return err
+	}
+	tmp, err := g.upload(ctx, "put")
+	if err != nil {
+		return err
+	}
+	sum := md5.New()
+	if _, err := g.c.WriteFrom(ctx, tmp, 0, io.TeeReader(r.Body, sum)); err != nil {
+		g.c.Delete(ctx, tmp)
+		return err
+	}
+	if err := g.replace(ctx, tmp, bucket, key); err != nil {
+		g.c.Delete(ctx, tmp)
+		return g.notFound(ctx, bucket, err)
+	}
+	w.Header().Set("ETag", md5ETag(sum.Sum(nil)))
+	return nil
+}
+
+// upload creates a temporary file to upload into.
+func (g *Gateway) upload(ctx context.Context, name string) (gfs.Path, error) {
+	if err := g.mkdirAll(ctx, gfs.Path(path.Join(string(g.root), UploadDir))); err != nil {
+		return "", err
+	}
+	tmp, err := g.tempPath(name)
+	if err != nil {
+		return "", err
+	}
+	return tmp, g.c.Create(ctx, tmp)
+}
+
+// parseRange parses the single byte range of a Range header of a file of
+// size bytes, into its offset and length.
+func parseRange(header string, size int64) (offset, length int64, err error) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return 0, 0, errBadRange
+	}
+	i := strings.IndexByte(spec, '-')
+	if i < 0 {
+		return 0, 0, errBadRange
+	}
+	first, last := spec[:i], spec[i+1:]
+	if first == "" {
+		// the last bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, errBadRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, nil
+	}
+	offset, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || offset < 0 || offset >= size {
+		return 0, 0, errBadRange
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < offset {
+			return 0, 0, errBadRange
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return offset, end - offset + 1, nil
+}
+
+func (g *Gateway) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	ctx, p := r.Context(), g.objectPath(bucket, key)
+	info, err := g.c.Stat(ctx, p)
+	if err != nil {
+		return g.notFound(ctx, bucket, err)
+	}
+	if info.IsDir {
+		return errNoSuchKey
+	}
+
+	offset, length, status := int64(0), info.Size, http.StatusOK
+	if h := r.Header.Get("Range"); h != "" {
+		if offset, length, err = parseRange(h, info.Size); err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%v", info.Size))
+			return err
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %v-%v/%v", offset, offset+length-1, info.Size))
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	if h, err := g.c.GetFileHash(ctx, p); err == nil {
+		w.Header().Set("ETag", h.ETag())
+	} else {
+		w.Header().Set("ETag", etag(info))
+	}
+	w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	// the status is sent, a failure can only cut the body short
+	g.c.ReadTo(ctx, p, gfs.Offset(offset), length, w)
+	return nil
+}
+
+type objectEntry struct {
+	Key          string
+	LastModified time.Time
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+type listObjectsResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	MaxKeys               int
+	IsTruncated           bool
+	Marker                string `xml:",omitempty"`
+	NextMarker            string `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	KeyCount              int
+	Contents              []objectEntry  `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+// listObjects lists the keys of a bucket in order, as ListObjects, or
+// ListObjectsV2 with list-type=2. The files under the deepest directory of
+// the prefix are walked and sorted for every page.
+func (g *Gateway) listObjects(w http.ResponseWriter, r *http.Request, bucket string) error {
+	q := r.URL.Query()
+	res := listObjectsResult{Name: bucket, Prefix: q.Get("prefix"), Delimiter: q.Get("delimiter"), MaxKeys: MaxKeys}
+	if s := q.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return apiError{http.StatusBadRequest, "InvalidArgument", "invalid max-keys " + s}
+		}
+		if n < MaxKeys {
+			res.MaxKeys = n
+		}
+	}
+	v2 := q.Get("list-type") == "2"
+	after := q.Get("marker")
+	if v2 {
+		res.ContinuationToken, res.StartAfter = q.Get("continuation-token"), q.Get("start-after")
+		after = res.StartAfter
+		if res.ContinuationToken != "" {
+			after = res.ContinuationToken
+		}
+	} else {
+		res.Marker = after
+	}
+
+	bp := g.bucketPath(bucket)
+	dir := bp
+	if i := strings.LastIndexByte(res.Prefix, '/'); i >= 0 {
+		dir = gfs.Path(path.Join(string(bp), res.Prefix[:i]))
+	}
+	var files []gfs.FileInfo
+	err := g.c.Walk(r.Context(), dir, func(info gfs.FileInfo) error {
+		if !info.IsDir {
+			files = append(files, info)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, gfs.PathNotFound) && !errors.Is(err, gfs.NotDirectory) {
+		return err
+	}
+	for i := range files {
+		files[i].Path = gfs.Path(strings.TrimPrefix(string(files[i].Path), string(bp)+"/"))
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	last := ""
+	for _, info := range files {
+		key := string(info.Path)
+		if !strings.HasPrefix(key, res.Prefix) || key <= after {
+			continue
+		}
+		entry := key
+		if res.Delimiter != "" {
+			if i := strings.Index(key[len(res.Prefix):], res.Delimiter); i >= 0 {
+				entry = key[:len(res.Prefix)+i+len(res.Delimiter)]
+				if entry == last || entry <= after {
+					continue
+				}
+			}
+		}
+		if len(res.Contents)+len(res.CommonPrefixes) == res.MaxKeys {
+			res.IsTruncated = true
+			break
+		}
+		last = entry
+		if entry != key {
+			res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{entry})
+			continue
+		}
+		res.Contents = append(res.Contents, objectEntry{key, info.ModTime.UTC(), etag(info), info.Size, "STANDARD"})
+	}
+	if res.IsTruncated {
+		// the keys rolled up in a common prefix are skipped after it
+		if v2 {
+			res.NextContinuationToken = last
+		} else if res.Delimiter != "" {
+			res.NextMarker = last
+		}
+	}
+	if v2 {
+		res.KeyCount = len(res.Contents) + len(res.CommonPrefixes)
+	}
+	writeXML(w, res)
+	return nil
+}
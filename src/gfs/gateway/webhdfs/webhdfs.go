@@ -0,0 +1,437 @@
+// Package webhdfs serves the WebHDFS REST API on top of a gfs client, so
+// that Hadoop and Spark jobs read and write gfs paths through the gateway,
+// as webhdfs://<gateway addr>/<path>. Requests are /webhdfs/v1/<path>?op=...,
+// the paths being those of gfs, and are not authenticated: user.name is
+// ignored, the gateway acts with the credentials of its client, see
+// client.WithUser and client.WithToken.
+//
+// CREATE and APPEND are answered with a redirect to the gateway itself, as a
+// namenode redirects to a datanode, and the data is sent there. Appends
+// write at the end of the file. The appends to a file through a gateway are
+// made one at a time, as under the lease of a single writer in HDFS, but
+// writers of the file elsewhere are not held off.
+package webhdfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gfs"
+	"gfs/client"
+)
+
+// Prefix is the path prefix of the requests.
+const Prefix = "/webhdfs/v1"
+
+// Gateway is an http.Handler serving the gfs namespace over WebHDFS.
+type Gateway struct {
+	c client.ClientAPI
+
+	mu      sync.Mutex
+	appends map[gfs.Path]*appendLock // of the files being appended to
+}
+
+// appendLock is held by the append to a file, and counts the appends
+// holding or waiting for it.
+type appendLock struct {
+	sync.Mutex
+	refs int
+}
+
+// New returns a gateway serving the namespace of the client.
+func New(c client.ClientAPI) *Gateway {
+	return &Gateway{c: c, appends: make(map[gfs.Path]*appendLock)}
+}
+
+// lockAppend waits for the appends to p in progress and returns the
+// function ending the append.
+func (g *Gateway) lockAppend(p gfs.Path) func() {
+	g.mu.Lock()
+	l, ok := g.appends[p]
+	if !ok {
+		l = &appendLock{}
+		g.appends[p] = l
+	}
+	l.refs++
+	g.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(g.appends, p)
+		}
+	}
+}
+
+// FileStatus is the status of a file or a directory, in the JSON of WebHDFS.
+type FileStatus struct {
+	AccessTime       int64  `json:"accessTime"`
+	BlockSize        int64  `json:"blockSize"`
+	ChildrenNum      int64  `json:"childrenNum"`
+	FileID           int64  `json:"fileId"`
+	Group            string `json:"group"`
+	Length           int64  `json:"length"`
+	ModificationTime int64  `json:"modificationTime"` // in ms
+	Owner            string `json:"owner"`
+	PathSuffix       string `json:"pathSuffix"`
+	Permission       string `json:"permission"` // octal
+	Replication      int    `json:"replication"`
+	Type             string `json:"type"` // FILE or DIRECTORY
+}
+
+func fileStatus(info gfs.FileInfo, suffix string) FileStatus {
+	s := FileStatus{
+		Group:            info.Group,
+		ModificationTime: info.ModTime.UnixNano() / 1e6,
+		Owner:            info.Owner,
+		PathSuffix:       suffix,
+		Permission:       strconv.FormatUint(uint64(info.Mode.Perm()), 8),
+		Type:             "FILE",
+	}
+	if info.IsDir {
+		s.Type = "DIRECTORY"
+		return s
+	}
+	s.BlockSize, s.Length, s.Replication = info.ChunkSize, info.Size, info.Replication
+	return s
+}
+
+// remoteError is a RemoteException of WebHDFS.
+type remoteError struct {
+	status    int
+	exception string
+	class     string
+	msg       string
+}
+
+func (e remoteError) Error() string {
+	return e.exception + ": " + e.msg
+}
+
+func badRequest(msg string) remoteError {
+	return remoteError{http.StatusBadRequest, "IllegalArgumentException", "java.lang.IllegalArgumentException", msg}
+}
+
+// toRemoteError translates gfs error codes into the exceptions of Hadoop.
+func toRemoteError(err error) remoteError {
+	var re remoteError
+	if errors.As(err, &re) {
+		return re
+	}
+	var e gfs.Error
+	if !errors.As(err, &e) {
+		return remoteError{http.StatusInternalServerError, "IOException", "java.io.IOException", err.Error()}
+	}
+	switch e.Code {
+	case gfs.PathNotFound:
+		return remoteError{http.StatusNotFound, "FileNotFoundException", "java.io.FileNotFoundException", e.Err}
+	case gfs.PathExists:
+		return remoteError{http.StatusForbidden, "FileAlreadyExistsException", "org.apache.hadoop.fs.FileAlreadyExistsException", e.Err}
+	case gfs.PermissionDenied, gfs.Unauthenticated, gfs.ReadOnly:
+		return remoteError{http.StatusForbidden, "AccessControlException", "org.apache.hadoop.security.AccessControlException", e.Err}
+	case gfs.DirectoryNotEmpty:
+		return remoteError{http.StatusForbidden, "PathIsNotEmptyDirectoryException", "org.apache.hadoop.fs.PathIsNotEmptyDirectoryException", e.Err}
+	case gfs.QuotaExceeded:
+		return remoteError{http.StatusForbidden, "DSQuotaExceededException", "org.apache.hadoop.hdfs.protocol.DSQuotaExceededException", e.Err}
+	case gfs.InvalidArgument, gfs.IsDirectory, gfs.NotDirectory:
+		return badRequest(e.Err)
+	}
+	return remoteError{http.StatusInternalServerError, "IOException", "java.io.IOException", e.Err}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, e remoteError) {
+	writeJSON(w, e.status, map[string]interface{}{"RemoteException": map[string]string{
+		"exception":     e.exception,
+		"javaClassName": e.class,
+		"message":       e.msg,
+	}})
+}
+
+// ServeHTTP serves a WebHDFS request.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, Prefix+"/") && r.URL.Path != Prefix {
+		writeError(w, remoteError{http.StatusNotFound, "FileNotFoundException", "java.io.FileNotFoundException", "not a WebHDFS path " + r.URL.Path})
+		return
+	}
+	p := gfs.Path(path.Clean("/" + strings.TrimPrefix(r.URL.Path, Prefix)))
+	q := r.URL.Query()
+	op := strings.ToUpper(q.Get("op"))
+
+	var err error
+	switch {
+	case r.Method == http.MethodGet && op == "OPEN":
+		err = g.open(w, r, p)
+	case r.Method == http.MethodGet && op == "GETFILESTATUS":
+		err = g.getFileStatus(w, r, p)
+	case r.Method == http.MethodGet && op == "LISTSTATUS":
+		err = g.listStatus(w, r, p)
+	case r.Method == http.MethodPut && op == "CREATE":
+		err = g.create(w, r, p)
+	case r.Method == http.MethodPost && op == "APPEND":
+		err = g.append(w, r, p)
+	case r.Method == http.MethodPut && op == "MKDIRS":
+		err = g.mkdirs(r.Context(), p)
+		if err == nil {
+			writeJSON(w, http.StatusOK, map[string]bool{"boolean": true})
+		}
+	case r.Method == http.MethodPut && op == "RENAME":
+		err = g.rename(w, r, p)
+	case r.Method == http.MethodDelete && op == "DELETE":
+		err = g.delete(w, r, p)
+	default:
+		err = badRequest(fmt.Sprintf("invalid op %v for %v", q.Get("op"), r.Method))
+	}
+	if err != nil {
+		writeError(w, toRemoteError(err))
+	}
+}
+
+// mkdirs makes p and its parents.
+func (g *Gateway) mkdirs(ctx context.Context, p gfs.Path) error {
+	dir := ""
+	for _, name := range strings.Split(strings.Trim(string(p), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		dir += "/" + name
+		if err := g.c.Mkdir(ctx, gfs.Path(dir)); err != nil && !errors.Is(err, gfs.PathExists) {
+			return err
+		}
+	}
+	info, err := g.c.Stat(ctx, p)
+	if err == nil && !info.IsDir {
+		return gfs.Error{gfs.NotDirectory, fmt.Sprintf("path %v is a file", p)}
+	}
+	return err
+}
+
+func (g *Gateway) getFileStatus(w http.ResponseWriter, r *http.Request, p gfs.Path) error {
+	info, err := g.c.Stat(r.Context(), p)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, map[string]FileStatus{"FileStatus": fileStatus(info, "")})
+	return nil
+}
+
+func (g *Gateway) listStatus(w http.ResponseWriter, r *http.Request, p gfs.Path) error {
+	ctx := r.Context()
+	info, err := g.c.Stat(ctx, p)
+	if err != nil {
+		return err
+	}
+	statuses := []FileStatus{}
+	if !info.IsDir {
+		statuses = append(statuses, fileStatus(info, ""))
+	} else {
+		ls, err := g.c.List(ctx, p)
+		if err != nil {
+			return err
+		}
+		for _, e := range ls {
+			child, err := g.c.Stat(ctx, gfs.Path(path.Join(string(p), e.Name)))
+			if err != nil {
+				continue // deleted meanwhile
+			}
+			statuses = append(statuses, fileStatus(child, e.Name))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]map[string][]FileStatus{"FileStatuses": {"FileStatus": statuses}})
+	return nil
+}
+
+func (g *Gateway) open(w http.ResponseWriter, r *http.Request, p gfs.Path) error {
+	q := r.URL.Query()
+	offset, length := int64(0), int64(-1)
+	var err error
+	if s := q.Get("offset"); s != "" {
+		if offset, err = strconv.ParseInt(s, 10, 64); err != nil || offset < 0 {
+			return badRequest("invalid offset " + s)
+		}
+	}
+	if s := q.Get("length"); s != "" {
+		if length, err = strconv.ParseInt(s, 10, 64); err != nil || length < 0 {
+			return badRequest("invalid length " + s)
+		}
+	}
+	info, err := g.c.Stat(r.Context(), p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		return remoteError{http.StatusNotFound, "FileNotFoundException", "java.io.FileNotFoundException", fmt.Sprintf("path %v is a directory", p)}
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	// the status is sent, a failure can only cut the data short
+	g.c.ReadTo(r.Context(), p, gfs.Offset(offset), length, w)
+	return nil
+}
+
+// redirect answers the first request of CREATE or APPEND, sending the data
+// to the same URL with data=true. It returns whether it did.
+func redirect(w http.ResponseWriter, r *http.Request) bool {
+	q := r.URL.Query()
+	if q.Get("data") == "true" {
+		return false
+	}
+	q.Set("data", "true")
+	u := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawQuery: q.Encode()}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	if q.Get("noredirect") == "true" {
+		writeJSON(w, http.StatusOK, map[string]string{"Location": u.String()})
+		return true
+	}
+	w.Header().Set("Location", u.String())
+	w.WriteHeader(http.StatusTemporaryRedirect)
+	return true
+}
+
+func (g *Gateway) create(w http.ResponseWriter, r *http.Request, p gfs.Path) error {
+	ctx, q := r.Context(), r.URL.Query()
+	chunkSize := int64(gfs.MaxChunkSize)
+	if s := q.Get("blocksize"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || !gfs.ValidChunkSize(n) {
+			return badRequest(fmt.Sprintf("block size %v is not one of %v", s, gfs.ChunkSizes))
+		}
+		chunkSize = n
+	}
+	replication := 0
+	if s := q.Get("replication"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return badRequest("invalid replication " + s)
+		}
+		replication = n
+	}
+	var mode os.FileMode
+	if s := q.Get("permission"); s != "" {
+		n, err := strconv.ParseUint(s, 8, 32)
+		if err != nil || n > 0777 {
+			return badRequest("invalid permission " + s)
+		}
+		mode = os.FileMode(n)
+	}
+	if redirect(w, r) {
+		return nil
+	}
+
+	if err := g.mkdirs(ctx, gfs.Path(path.Dir(string(p)))); err != nil {
+		return err
+	}
+	err := g.c.CreateWithChunkSize(ctx, p, chunkSize)
+	if errors.Is(err, gfs.PathExists) && q.Get("overwrite") == "true" {
+		if err = g.c.Delete(ctx, p); err == nil {
+			err = g.c.CreateWithChunkSize(ctx, p, chunkSize)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if replication > 0 {
+		if err := g.c.SetReplication(ctx, p, replication); err != nil {
+			return err
+		}
+	}
+	if mode != 0 {
+		if err := g.c.Chmod(ctx, p, mode); err != nil {
+			return err
+		}
+	}
+	if _, err := g.c.WriteFrom(ctx, p, 0, r.Body); err != nil {
+		return err
+	}
+	w.Header().Set("Location", "webhdfs://"+r.Host+string(p))
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (g *Gateway) append(w http.ResponseWriter, r *http.Request, p gfs.Path) error {
+	if redirect(w, r) {
+		return nil
+	}
+	// the end is taken and written to by one append at a time
+	defer g.lockAppend(p)()
+	info, err := g.c.Stat(r.Context(), p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		return gfs.Error{gfs.IsDirectory, fmt.Sprintf("cannot append to directory %v", p)}
+	}
+	if _, err := g.c.WriteFrom(r.Context(), p, gfs.Offset(info.Size), r.Body); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// rename moves the file or the directory p to destination, or into it if
+// it is a directory, as HDFS does. It answers false if p does not exist, the
+// target does, or is inside p.
+func (g *Gateway) rename(w http.ResponseWriter, r *http.Request, p gfs.Path) error {
+	ctx := r.Context()
+	dest := r.URL.Query().Get("destination")
+	if !path.IsAbs(dest) {
+		return badRequest("invalid destination " + dest)
+	}
+	target := gfs.Path(path.Clean(dest))
+	if t, err := g.c.Stat(ctx, target); err == nil && t.IsDir {
+		target = gfs.Path(path.Join(string(target), path.Base(string(p))))
+	}
+	if target == p {
+		_, err := g.c.Stat(ctx, p)
+		writeJSON(w, http.StatusOK, map[string]bool{"boolean": err == nil})
+		return nil
+	}
+
+	err := g.c.Rename(ctx, p, target)
+	if errors.Is(err, gfs.PathExists) || errors.Is(err, gfs.PathNotFound) || errors.Is(err, gfs.InvalidArgument) {
+		writeJSON(w, http.StatusOK, map[string]bool{"boolean": false})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"boolean": true})
+	return nil
+}
+
+func (g *Gateway) delete(w http.ResponseWriter, r *http.Request, p gfs.Path) error {
+	var err error
+	if r.URL.Query().Get("recursive") == "true" {
+		_, _, err = g.c.DeleteAll(r.Context(), p)
+	} else {
+		err = g.c.Delete(r.Context(), p)
+	}
+	if errors.Is(err, gfs.PathNotFound) {
+		writeJSON(w, http.StatusOK, map[string]bool{"boolean": false})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"boolean": true})
+	return nil
+}
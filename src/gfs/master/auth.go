@@ -0,0 +1,95 @@
+package master
+
+import (
+	"fmt"
+	"time"
+
+	"gfs"
+)
+
+// SetAuthSecret sets the secret shared with the chunkservers. With one, the
+// rpcs of clients carry a client token, the user and the groups of which
+// replace the credentials supplied, and chunk tokens are issued with the
+// replicas and the leases of chunks. The rpcs of the chunkservers carry a
+// server token, and the rpcs to them are signed with one. nil disables
+// authentication.
+func (m *Master) SetAuthSecret(key []byte) {
+	m.secret.Set(key)
+}
+
+// authenticate replaces the user and the groups of cred with the ones of its
+// token, if the master has a secret.
+func (m *Master) authenticate(cred *gfs.Credentials) error {
+	if !m.secret.Enabled() {
+		return nil
+	}
+	c, err := m.secret.Client(cred.Token)
+	if err != nil {
+		m.metrics.authFailures.Inc()
+		return err
+	}
+	cred.User, cred.Groups = c.User, c.Groups
+	return nil
+}
+
+// checkAdmin authenticates cred and returns gfs.PermissionDenied unless it
+// is of SuperUser, who alone may administer the cluster.
+func (m *Master) checkAdmin(cred gfs.Credentials, action string) error {
+	if err := m.authenticate(&cred); err != nil {
+		return err
+	}
+	if !cred.IsSuperUser() {
+		return gfs.Error{gfs.PermissionDenied, fmt.Sprintf("user %v may not %v", cred.Owner(), action)}
+	}
+	return nil
+}
+
+// checkServer returns gfs.Unauthenticated unless token is a server token, for
+// the rpcs of the chunkservers.
+func (m *Master) checkServer(token string) error {
+	if err := m.secret.CheckServer(token); err != nil {
+		m.metrics.authFailures.Inc()
+		return err
+	}
+	return nil
+}
+
+// checkChunkToken returns gfs.Unauthenticated unless token is a chunk token
+// of handle, for the reports of clients on the replicas they read.
+func (m *Master) checkChunkToken(token string, handle gfs.ChunkHandle) error {
+	if err := m.secret.CheckChunk(token, handle, false); err != nil {
+		m.metrics.authFailures.Inc()
+		return err
+	}
+	return nil
+}
+
+// chunkToken authenticates cred and returns a chunk token for handle,
+// provided cred may read its file, or write it if write is set. The token is
+// empty if the master has no secret.
+func (m *Master) chunkToken(handle gfs.ChunkHandle, cred gfs.Credentials, write bool) (string, error) {
+	if err := m.authenticate(&cred); err != nil {
+		return "", err
+	}
+	if !cred.IsSuperUser() {
+		p, err := m.cm.ChunkPath(handle)
+		if err != nil {
+			return "", err
+		}
+		want := permRead
+		if write {
+			want = permWrite
+		}
+		var wait time.Duration
+		if err := m.nm.checkPath(p, cred, want, &wait); err != nil {
+			return "", err
+		}
+	}
+	return m.secret.ChunkToken(cred.Owner(), handle, write), nil
+}
+
+// masterToken returns a chunk token of SuperUser for the reads of the
+// master itself.
+func (m *Master) masterToken(handle gfs.ChunkHandle) string {
+	return m.secret.ChunkToken(gfs.SuperUser, handle, false)
+}
@@ -0,0 +1,105 @@
+package master
+
+import (
+	"fmt"
+	"time"
+
+	"gfs"
+)
+
+// checkBatch rejects batches of more than gfs.BatchMaxPaths paths.
+func checkBatch(paths int) error {
+	if paths > gfs.BatchMaxPaths {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("%v paths in a batch, %v at most", paths, gfs.BatchMaxPaths)}
+	}
+	return nil
+}
+
+// batchError is the string form of the error of a path in a batch.
+func batchError(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// RPCBatchCreate is called by client to create files in one call, each as
+// RPCCreateFile would. A file failing does not stop the others.
+func (m *Master) RPCBatchCreate(args gfs.BatchCreateArg, reply *gfs.BatchCreateReply) (err error) {
+	if err := checkBatch(len(args.Paths)); err != nil {
+		return err
+	}
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	reply.Errors = make([]string, len(args.Paths))
+	for i, p := range args.Paths {
+		err := m.throttleCreate(p)
+		if err == nil {
+			err = m.nm.Create(p, args.ChunkSize, args.Cred, &wait)
+		}
+		reply.Errors[i] = batchError(err)
+	}
+	return nil
+}
+
+// RPCBatchGetFileInfo is called by client to get the information of files
+// in one call, each as RPCGetFileInfo would.
+func (m *Master) RPCBatchGetFileInfo(args gfs.BatchGetFileInfoArg, reply *gfs.BatchGetFileInfoReply) (err error) {
+	if err := checkBatch(len(args.Paths)); err != nil {
+		return err
+	}
+	done, err := m.admit(false)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	reply.Files = make([]gfs.GetFileInfoReply, len(args.Paths))
+	reply.Errors = make([]string, len(args.Paths))
+	for i, p := range args.Paths {
+		reply.Errors[i] = batchError(m.getFileInfo(p, args.Cred, &reply.Files[i], &wait))
+	}
+	return nil
+}
+
+// RPCBatchGetChunkHandle is called by client to get the handles of chunks
+// of files in one call, each as RPCGetChunkHandle would, allocating the
+// next chunk of a file.
+func (m *Master) RPCBatchGetChunkHandle(args gfs.BatchGetChunkHandleArg, reply *gfs.BatchGetChunkHandleReply) (err error) {
+	if err := checkBatch(len(args.Paths)); err != nil {
+		return err
+	}
+	if len(args.Indexes) != len(args.Paths) {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("%v chunk indexes of %v paths", len(args.Indexes), len(args.Paths))}
+	}
+	done, err := m.admit(args.Write)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	reply.Handles = make([]gfs.ChunkHandle, len(args.Paths))
+	reply.Errors = make([]string, len(args.Paths))
+	for i, p := range args.Paths {
+		var r gfs.GetChunkHandleReply
+		err := m.getChunkHandle(gfs.GetChunkHandleArg{p, args.Indexes[i], args.Write, args.Cred}, &r, &wait)
+		reply.Handles[i], reply.Errors[i] = r.Handle, batchError(err)
+	}
+	return nil
+}
@@ -1,12 +1,16 @@
 package master
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gfs"
+	"gfs/auth"
 	"gfs/util"
 	log "github.com/Sirupsen/logrus"
 )
@@ -20,7 +24,9 @@ type chunkManager struct {
 
 	replicasNeedList []gfs.ChunkHandle // list of handles need a new replicas
 	// (happends when some servers are disconneted)
-	numChunkHandle gfs.ChunkHandle
+	handles     *handleAllocator
+	leaseExpire int64        // duration of the leases granted, accessed atomically
+	secret      *auth.Secret // of master, signing the rpcs to the chunkservers
 }
 
 type chunkInfo struct {
@@ -30,7 +36,15 @@ type chunkInfo struct {
 	expire   time.Time           // lease expire time
 	version  gfs.ChunkVersion
 	checksum gfs.Checksum
+	length   int64 // committed, reported by the primary, accessed atomically
 	path     gfs.Path
+	writers  map[string]int      // failure domains hinted by recent writers
+	stale    []gfs.ServerAddress // replicas found stale, repaired or collected by re-replication
+
+	released       gfs.ServerAddress // primary that released its lease early
+	releasedExpire time.Time         // until when clients may still cache its lease
+	revoked        gfs.ServerAddress // primary whose lease was revoked
+	revokedExpire  time.Time         // until when it refuses mutations as primary
 }
 
 type fileInfo struct {
@@ -55,12 +69,16 @@ func (cm *chunkManager) Deserialize(files []serialChunkInfo) error {
 			f.handles = append(f.handles, ck.Handle)
 			log.Info("Master restore chunk ", ck.Handle)
 			cm.chunk[ck.Handle] = &chunkInfo{
+				path:     v.Path,
 				expire:   now,
 				version:  ck.Version,
 				checksum: ck.Checksum,
+				length:   int64(ck.Length),
 			}
 		}
-		cm.numChunkHandle += gfs.ChunkHandle(len(v.Info))
+		for _, ck := range v.Info {
+			cm.handles.skip(ck.Handle) // handles released by truncations leave gaps
+		}
 		cm.file[v.Path] = f
 	}
 
@@ -77,7 +95,7 @@ func (cm *chunkManager) Serialize() []serialChunkInfo {
 		for _, handle := range v.handles {
 			chunks = append(chunks, gfs.PersistentChunkInfo{
 				Handle:   handle,
-				Length:   0,
+				Length:   gfs.Offset(atomic.LoadInt64(&cm.chunk[handle].length)),
 				Version:  cm.chunk[handle].version,
 				Checksum: 0,
 			})
@@ -89,10 +107,14 @@ func (cm *chunkManager) Serialize() []serialChunkInfo {
 	return ret
 }
 
-func newChunkManager() *chunkManager {
+func newChunkManager(secret *auth.Secret) *chunkManager {
 	cm := &chunkManager{
 		chunk: make(map[gfs.ChunkHandle]*chunkInfo),
 		file:  make(map[gfs.Path]*fileInfo),
+
+		handles:     &handleAllocator{},
+		leaseExpire: int64(gfs.LeaseExpire),
+		secret:      secret,
 	}
 	log.Info("-----------new chunk manager")
 	return cm
@@ -115,23 +137,39 @@ func (cm *chunkManager) RegisterReplica(handle gfs.ChunkHandle, addr gfs.ServerA
 	}
 
 	if !ok {
-		return fmt.Errorf("cannot find chunk %v", handle)
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("cannot find chunk %v", handle)}
 	}
 
 	ck.location = append(ck.location, addr)
 	return nil
 }
 
-// GetReplicas returns the replicas of a chunk
-func (cm *chunkManager) GetReplicas(handle gfs.ChunkHandle) ([]gfs.ServerAddress, error) {
+// AddStale records stale replicas of a chunk, to be repaired or collected as
+// garbage by re-replication.
+func (cm *chunkManager) AddStale(handle gfs.ChunkHandle, addrs []gfs.ServerAddress) {
+	cm.RLock()
+	ck, ok := cm.chunk[handle]
+	cm.RUnlock()
+	if !ok {
+		return
+	}
+	ck.Lock()
+	defer ck.Unlock()
+	ck.stale = append(ck.stale, addrs...)
+}
+
+// GetReplicas returns the replicas of a chunk and its version
+func (cm *chunkManager) GetReplicas(handle gfs.ChunkHandle) ([]gfs.ServerAddress, gfs.ChunkVersion, error) {
 	cm.RLock()
 	ck, ok := cm.chunk[handle]
 	cm.RUnlock()
 
 	if !ok {
-		return nil, fmt.Errorf("cannot find chunk %v", handle)
+		return nil, 0, gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("cannot find chunk %v", handle)}
 	}
-	return ck.location, nil
+	ck.RLock()
+	defer ck.RUnlock()
+	return ck.location, ck.version, nil
 }
 
 // GetChunk returns the chunk handle for (path, index).
@@ -141,41 +179,118 @@ func (cm *chunkManager) GetChunk(path gfs.Path, index gfs.ChunkIndex) (gfs.Chunk
 
 	fileinfo, ok := cm.file[path]
 	if !ok {
-		return -1, fmt.Errorf("cannot get handle for %v[%v]", path, index)
+		return -1, gfs.Error{gfs.PathNotFound, fmt.Sprintf("cannot get handle for %v[%v]", path, index)}
 	}
 
 	if index < 0 || int(index) >= len(fileinfo.handles) {
-		return -1, fmt.Errorf("Invalid index for %v[%v]", path, index)
+		return -1, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("Invalid index for %v[%v]", path, index)}
 	}
 
 	return fileinfo.handles[index], nil
 }
 
+// ChunkPath returns the file of a chunk.
+func (cm *chunkManager) ChunkPath(handle gfs.ChunkHandle) (gfs.Path, error) {
+	cm.RLock()
+	ck, ok := cm.chunk[handle]
+	cm.RUnlock()
+	if !ok {
+		return "", gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("cannot find chunk %v", handle)}
+	}
+	// renames and clones set the path with ck locked
+	ck.RLock()
+	defer ck.RUnlock()
+	return ck.path, nil
+}
+
+// SetLength sets the committed length of a chunk, as reported by its
+// primary after a mutation, and returns the file of the chunk. The length
+// is set atomically, the chunk is only read locked for its path.
+func (cm *chunkManager) SetLength(handle gfs.ChunkHandle, length gfs.Offset) (gfs.Path, error) {
+	cm.RLock()
+	ck, ok := cm.chunk[handle]
+	cm.RUnlock()
+	if !ok {
+		return "", gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("cannot find chunk %v", handle)}
+	}
+	atomic.StoreInt64(&ck.length, int64(length))
+	ck.RLock()
+	defer ck.RUnlock()
+	return ck.path, nil
+}
+
+// FileLength returns the end of the data in the chunks of path, by the
+// last chunk with a committed length. Holes written past it are not counted.
+func (cm *chunkManager) FileLength(path gfs.Path, chunkSize int64) int64 {
+	cm.RLock()
+	defer cm.RUnlock()
+	fileinfo, ok := cm.file[path]
+	if !ok {
+		return 0
+	}
+	for i := len(fileinfo.handles) - 1; i >= 0; i-- {
+		if ck, ok := cm.chunk[fileinfo.handles[i]]; ok {
+			if length := atomic.LoadInt64(&ck.length); length > 0 {
+				return int64(i)*chunkSize + length
+			}
+		}
+	}
+	return 0
+}
+
+// primaryChooser picks a primary among up-to-date replicas, given their
+// lengths, the previous primary, the failure domain of the majority of
+// writers (may be empty) and the lease expire time.
+type primaryChooser func(candidates []gfs.ServerAddress, lengths map[gfs.ServerAddress]gfs.Offset, previous gfs.ServerAddress, domain string, expire time.Time) gfs.ServerAddress
+
+// writerDomain returns the failure domain hinted by the majority of recent writers.
+// ck should be locked in advance.
+func (ck *chunkInfo) writerDomain() string {
+	var domain string
+	max := 0
+	for k, v := range ck.writers {
+		if v > max || (v == max && k < domain) {
+			domain, max = k, v
+		}
+	}
+	return domain
+}
+
 // GetLeaseHolder returns the chunkserver that hold the lease of a chunk
 // (i.e. primary) and expire time of the lease. If no one has a lease,
-// grants one to a replica it chooses.
-func (cm *chunkManager) GetLeaseHolder(handle gfs.ChunkHandle) (*gfs.Lease, []gfs.ServerAddress, error) {
+// grants one to a replica chosen by choose. domain is the failure domain
+// of the writer asking for the lease, used as a placement hint. The replicas
+// get the throttle policy of the file from throttle along with a new lease.
+func (cm *chunkManager) GetLeaseHolder(ctx context.Context, handle gfs.ChunkHandle, domain string, choose primaryChooser, throttle func(gfs.Path) gfs.ThrottlePolicy) (*gfs.Lease, []gfs.ServerAddress, error) {
 	cm.RLock()
 	ck, ok := cm.chunk[handle]
 	cm.RUnlock()
 
 	if !ok {
-		return nil, nil, fmt.Errorf("invalid chunk handle %v", handle)
+		return nil, nil, gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("invalid chunk handle %v", handle)}
 	}
 
 	ck.Lock()
 	defer ck.Unlock()
 
+	if domain != "" {
+		if ck.writers == nil {
+			ck.writers = make(map[string]int)
+		}
+		ck.writers[domain]++
+	}
+
 	var staleServers []gfs.ServerAddress
 
 	ret := &gfs.Lease{}
 	if ck.expire.Before(time.Now()) { // grants a new lease
 		// check version
 		ck.version++
-		arg := gfs.CheckVersionArg{handle, ck.version}
+		arg := gfs.CheckVersionArg{handle, ck.version, throttle(ck.path), cm.secret.ServerToken()}
 
 		var newlist []string
-		var lock sync.Mutex // lock for newlist
+		lengths := make(map[gfs.ServerAddress]gfs.Offset)
+		var lock sync.Mutex // lock for newlist and lengths
 
 		var wg sync.WaitGroup
 		wg.Add(len(ck.location))
@@ -184,14 +299,17 @@ func (cm *chunkManager) GetLeaseHolder(handle gfs.ChunkHandle) (*gfs.Lease, []gf
 				var r gfs.CheckVersionReply
 
 				// TODO distinguish call error and r.Stale
-				err := util.Call(addr, "ChunkServer.RPCCheckVersion", arg, &r)
+				err := util.Call(ctx, addr, "ChunkServer.RPCCheckVersion", arg, &r)
 				if err == nil && r.Stale == false {
 					lock.Lock()
 					newlist = append(newlist, string(addr))
+					lengths[addr] = r.Length
 					lock.Unlock()
-				} else { // add to garbage collection
+				} else { // repaired or collected as garbage by re-replication
 					log.Warningf("detect stale chunk %v in %v (err: %v)", handle, addr, err)
+					lock.Lock()
 					staleServers = append(staleServers, addr)
+					lock.Unlock()
 				}
 				wg.Done()
 			}(v)
@@ -213,17 +331,41 @@ func (cm *chunkManager) GetLeaseHolder(handle gfs.ChunkHandle) (*gfs.Lease, []gf
 			if len(ck.location) == 0 {
 				// !! ATTENTION !!
 				ck.version--
-				return nil, nil, fmt.Errorf("no replica of %v", handle)
+				return nil, nil, gfs.Error{gfs.NoReplica, fmt.Sprintf("no replica of %v", handle)}
 			}
 		}
 
-		// TODO choose primary, !!error handle no replicas!!
-		ck.primary = ck.location[0]
-		ck.expire = time.Now().Add(gfs.LeaseExpire)
+		ck.expire = time.Now().Add(time.Duration(atomic.LoadInt64(&cm.leaseExpire)))
+		candidates := ck.location
+		if ck.revokedExpire.After(time.Now()) && len(ck.location) > 1 {
+			// the revoked primary refuses mutations until its lease would have expired
+			candidates = nil
+			for _, v := range ck.location {
+				if v != ck.revoked {
+					candidates = append(candidates, v)
+				}
+			}
+		}
+		if ck.releasedExpire.After(time.Now()) && containsServer(ck.location, ck.released) {
+			// no other primary while clients may write to the released one
+			ck.primary = choose([]gfs.ServerAddress{ck.released}, lengths, ck.primary, "", ck.expire)
+		} else {
+			ck.primary = choose(candidates, lengths, ck.primary, ck.writerDomain(), ck.expire)
+		}
+
+		// age the hints so that only recent writers count
+		for k, v := range ck.writers {
+			if v/2 == 0 {
+				delete(ck.writers, k)
+			} else {
+				ck.writers[k] = v / 2
+			}
+		}
 	}
 
 	ret.Primary = ck.primary
 	ret.Expire = ck.expire
+	ret.Epoch = ck.version
 	for _, v := range ck.location {
 		if v != ck.primary {
 			ret.Secondaries = append(ret.Secondaries, v)
@@ -232,72 +374,533 @@ func (cm *chunkManager) GetLeaseHolder(handle gfs.ChunkHandle) (*gfs.Lease, []gf
 	return ret, staleServers, nil
 }
 
-// ExtendLease extends the lease of chunk if the lease holder is primary.
-func (cm *chunkManager) ExtendLease(handle gfs.ChunkHandle, primary gfs.ServerAddress) error {
-	return nil
-	log.Fatal("unsupported ExtendLease")
+// ExtendLease extends the lease of chunk if primary holds it, and returns the
+// new expire time. An expired lease is not extended, it is granted again
+// after checking the versions of replicas.
+func (cm *chunkManager) ExtendLease(handle gfs.ChunkHandle, primary gfs.ServerAddress) (time.Time, error) {
 	cm.RLock()
 	ck, ok := cm.chunk[handle]
 	cm.RUnlock()
+	if !ok {
+		return time.Time{}, gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("invalid chunk handle %v", handle)}
+	}
 
 	ck.Lock()
 	defer ck.Unlock()
 
+	now := time.Now()
+	if ck.primary != primary || !ck.expire.After(now) {
+		return time.Time{}, gfs.Error{gfs.LeaseExpired, fmt.Sprintf("%v does not hold the lease for chunk %v", primary, handle)}
+	}
+	ck.expire = now.Add(time.Duration(atomic.LoadInt64(&cm.leaseExpire)))
+	return ck.expire, nil
+}
+
+// ReleaseLease ends the lease of chunk early if primary holds it, so that
+// the chunk can be copied or moved. Until the lease would have expired, only
+// primary may get a new one.
+func (cm *chunkManager) ReleaseLease(handle gfs.ChunkHandle, primary gfs.ServerAddress) error {
+	cm.RLock()
+	ck, ok := cm.chunk[handle]
+	cm.RUnlock()
 	if !ok {
-		return fmt.Errorf("invalid chunk handle %v", handle)
+		return gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("invalid chunk handle %v", handle)}
 	}
 
+	ck.Lock()
+	defer ck.Unlock()
+
 	now := time.Now()
-	if ck.primary != primary && ck.expire.After(now) {
-		return fmt.Errorf("%v does not hold the lease for chunk %v", primary, handle)
+	if ck.primary != primary || !ck.expire.After(now) {
+		return nil
 	}
-	ck.primary = primary
-	ck.expire = now.Add(gfs.LeaseExpire)
+	ck.released, ck.releasedExpire = primary, ck.expire
+	ck.expire = now
 	return nil
 }
 
-// CreateChunk creates a new chunk for path. servers for the chunk are denoted by addrs
-// returns the handle of the new chunk, and the servers that create the chunk successfully
-func (cm *chunkManager) CreateChunk(path gfs.Path, addrs []gfs.ServerAddress) (gfs.ChunkHandle, []gfs.ServerAddress, error) {
+func containsServer(addrs []gfs.ServerAddress, addr gfs.ServerAddress) bool {
+	for _, v := range addrs {
+		if v == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// newHandle returns a handle of no chunk known. cm should be locked.
+func (cm *chunkManager) newHandle() (gfs.ChunkHandle, error) {
+	for {
+		handle, err := cm.handles.alloc()
+		if err != nil {
+			return 0, gfs.Error{gfs.UnknownError, fmt.Sprintf("cannot reserve chunk handles: %v", err)}
+		}
+		if _, ok := cm.chunk[handle]; !ok {
+			return handle, nil
+		}
+		log.Errorf("chunk handle %v given out again, skipped", handle)
+	}
+}
+
+// SkipHandles makes the handles up to the ones given never given out, e.g.
+// those of replicas reported by chunkservers but not known.
+func (cm *chunkManager) SkipHandles(handles ...gfs.ChunkHandle) {
 	cm.Lock()
 	defer cm.Unlock()
+	for _, handle := range handles {
+		cm.handles.skip(handle)
+	}
+}
+
+// NextHandle returns the handle given out next, stored with the metadata.
+func (cm *chunkManager) NextHandle() gfs.ChunkHandle {
+	cm.RLock()
+	defer cm.RUnlock()
+	return cm.handles.next
+}
 
-	handle := cm.numChunkHandle
-	cm.numChunkHandle++
+// CreateChunk creates a new chunk of size for path, or of no file until it is
+// attached if path is empty. servers for the chunk are denoted by addrs
+// returns the handle of the new chunk, and the servers that create the chunk successfully.
+// A handle a server has a chunk of already is given up for the next one,
+// the replicas made of it are returned to be collected as garbage. An error
+// is returned if no chunk is created, servers failing to create it are
+// logged and the chunk is re-replicated.
+func (cm *chunkManager) CreateChunk(ctx context.Context, path gfs.Path, size gfs.Offset, addrs []gfs.ServerAddress) (gfs.ChunkHandle, []gfs.ServerAddress, map[gfs.ChunkHandle][]gfs.ServerAddress, error) {
+	cm.Lock()
+	defer cm.Unlock()
 
-	// update file info
+	garbage := make(map[gfs.ChunkHandle][]gfs.ServerAddress)
+	for attempt := 1; ; attempt++ {
+		handle, err := cm.newHandle()
+		if err != nil {
+			return 0, nil, garbage, err
+		}
+
+		// update chunk info
+		ck := &chunkInfo{path: path}
+		cm.chunk[handle] = ck
+
+		var errList string
+		var success []gfs.ServerAddress
+		exists := false
+		for _, v := range addrs {
+			var r gfs.CreateChunkReply
+
+			err := util.Call(ctx, v, "ChunkServer.RPCCreateChunk", gfs.CreateChunkArg{handle, size, true, cm.secret.ServerToken()}, &r)
+			if err == nil { // register
+				ck.location = append(ck.location, v)
+				success = append(success, v)
+			} else {
+				exists = exists || errors.Is(err, gfs.ChunkExists)
+				errList += err.Error() + ";"
+			}
+		}
+
+		if exists {
+			delete(cm.chunk, handle)
+			if len(success) > 0 {
+				garbage[handle] = success
+			}
+			log.Errorf("chunk handle %v is taken on a chunkserver: %v", handle, errList)
+			if attempt == createChunkAttempts {
+				return 0, nil, garbage, gfs.Error{gfs.ChunkExists, fmt.Sprintf("%v chunk handles in a row taken on chunkservers", attempt)}
+			}
+			continue
+		}
+
+		// update file info, none for a chunk to be attached
+		if path != "" {
+			fileinfo, ok := cm.file[path]
+			if !ok {
+				fileinfo = new(fileInfo)
+				cm.file[path] = fileinfo
+			}
+			fileinfo.handles = append(fileinfo.handles, handle)
+		}
+
+		if errList != "" {
+			// replicas are no enough, add to need list
+			cm.replicasNeedList = append(cm.replicasNeedList, handle)
+			log.Warningf("chunk %v of %v created on %v of %v servers: %v", handle, path, len(success), len(addrs), errList)
+		}
+		return handle, success, garbage, nil
+	}
+}
+
+// ReleaseChunks removes the chunks of path from index on, cut off by a
+// truncation, and returns the replicas of each to be collected as garbage.
+func (cm *chunkManager) ReleaseChunks(path gfs.Path, index gfs.ChunkIndex) map[gfs.ChunkHandle][]gfs.ServerAddress {
+	cm.Lock()
 	fileinfo, ok := cm.file[path]
+	if !ok || int(index) >= len(fileinfo.handles) {
+		cm.Unlock()
+		return nil
+	}
+	chunks := make(map[gfs.ChunkHandle]*chunkInfo)
+	for _, handle := range fileinfo.handles[index:] {
+		if ck, ok := cm.chunk[handle]; ok {
+			chunks[handle] = ck
+		}
+		delete(cm.chunk, handle)
+	}
+	fileinfo.handles = append([]gfs.ChunkHandle(nil), fileinfo.handles[:index]...)
+	cm.Unlock()
+
+	// don't hold cm while locking ck, GetLeaseHolder locks them in the reverse order
+	ret := make(map[gfs.ChunkHandle][]gfs.ServerAddress, len(chunks))
+	for handle, ck := range chunks {
+		ck.RLock()
+		ret[handle] = append([]gfs.ServerAddress(nil), ck.location...)
+		ck.RUnlock()
+	}
+	return ret
+}
+
+// RemoveFile removes the chunks of path, removed for good from the
+// namespace, and returns the replicas of each to be collected as garbage.
+func (cm *chunkManager) RemoveFile(path gfs.Path) map[gfs.ChunkHandle][]gfs.ServerAddress {
+	ret := cm.ReleaseChunks(path, 0)
+	cm.Lock()
+	delete(cm.file, path)
+	cm.Unlock()
+	return ret
+}
+
+// RenameFile moves the chunks of the file from to the file to, moved in the
+// namespace.
+func (cm *chunkManager) RenameFile(from, to gfs.Path) {
+	cm.Lock()
+	fileinfo, ok := cm.file[from]
 	if !ok {
-		fileinfo = new(fileInfo)
-		cm.file[path] = fileinfo
+		cm.Unlock()
+		return
 	}
-	fileinfo.handles = append(fileinfo.handles, handle)
+	delete(cm.file, from)
+	cm.file[to] = fileinfo
+	var chunks []*chunkInfo
+	for _, handle := range fileinfo.handles {
+		if ck, ok := cm.chunk[handle]; ok {
+			chunks = append(chunks, ck)
+		}
+	}
+	cm.Unlock()
 
-	// update chunk info
-	ck := &chunkInfo{path: path}
-	cm.chunk[handle] = ck
+	// don't hold cm while locking ck, as in ReleaseChunks
+	for _, ck := range chunks {
+		ck.Lock()
+		ck.path = to
+		ck.Unlock()
+	}
+}
 
-	var errList string
-	var success []gfs.ServerAddress
-	for _, v := range addrs {
-		var r gfs.CreateChunkReply
+// CloneChunk makes each replica of the chunk src clone it into a new chunk,
+// and returns the clone with the servers holding it, and those failing to.
+// The lease of src is revoked first, and no other one granted until the
+// clones are made, so that they match. The clone is of no file until it is
+// attached to one.
+func (cm *chunkManager) CloneChunk(ctx context.Context, src gfs.ChunkHandle) (gfs.ChunkHandle, []gfs.ServerAddress, []gfs.ServerAddress, error) {
+	// the clone is known before it is made, so that its replicas reported
+	// meanwhile are not collected as garbage
+	clone := &chunkInfo{expire: time.Now()}
+	clone.Lock()
+	defer clone.Unlock()
+	cm.Lock()
+	ck, ok := cm.chunk[src]
+	if !ok {
+		cm.Unlock()
+		return 0, nil, nil, gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("cannot find chunk %v", src)}
+	}
+	handle, err := cm.newHandle()
+	if err != nil {
+		cm.Unlock()
+		return 0, nil, nil, err
+	}
+	cm.chunk[handle] = clone
+	cm.Unlock()
 
-		err := util.Call(v, "ChunkServer.RPCCreateChunk", gfs.CreateChunkArg{handle}, &r)
-		if err == nil { // register
-			ck.location = append(ck.location, v)
-			success = append(success, v)
+	ck.Lock()
+	holder, _, err := cm.revokeLease(ctx, src, ck)
+	if err != nil {
+		ck.Unlock()
+		cm.forget(handle)
+		return 0, nil, nil, gfs.Error{gfs.ServerBusy, fmt.Sprintf("cannot revoke the lease of chunk %v from %v: %v", src, holder, err)}
+	}
+	clone.version, clone.length = ck.version, atomic.LoadInt64(&ck.length)
+	var failed []gfs.ServerAddress
+	var errList string
+	for _, addr := range ck.location {
+		err := util.Call(ctx, addr, "ChunkServer.RPCCloneChunk", gfs.CloneChunkArg{src, handle, ck.version, cm.secret.ServerToken()}, &gfs.CloneChunkReply{})
+		if err == nil {
+			clone.location = append(clone.location, addr)
 		} else {
+			failed = append(failed, addr)
 			errList += err.Error() + ";"
 		}
 	}
+	ck.Unlock()
+	if len(clone.location) == 0 {
+		cm.forget(handle)
+		return handle, nil, failed, gfs.Error{gfs.NoReplica, fmt.Sprintf("no replica of chunk %v cloned: %v", src, errList)}
+	}
+	return handle, append([]gfs.ServerAddress(nil), clone.location...), failed, nil
+}
 
-	if errList == "" {
-		return handle, success, nil
-	} else {
-		// replicas are no enough, add to need list
-		cm.replicasNeedList = append(cm.replicasNeedList, handle)
-		return handle, success, fmt.Errorf(errList)
+// forget removes a chunk of no file.
+func (cm *chunkManager) forget(handle gfs.ChunkHandle) {
+	cm.Lock()
+	delete(cm.chunk, handle)
+	cm.Unlock()
+}
+
+// AttachChunks adds chunks made by CloneChunk to the end of the file path.
+func (cm *chunkManager) AttachChunks(path gfs.Path, handles []gfs.ChunkHandle) {
+	cm.Lock()
+	fileinfo, ok := cm.file[path]
+	if !ok {
+		fileinfo = new(fileInfo)
+		cm.file[path] = fileinfo
+	}
+	fileinfo.handles = append(fileinfo.handles, handles...)
+	var chunks []*chunkInfo
+	for _, handle := range handles {
+		if ck, ok := cm.chunk[handle]; ok {
+			chunks = append(chunks, ck)
+		}
+	}
+	cm.Unlock()
+
+	for _, ck := range chunks {
+		ck.Lock()
+		ck.path = path
+		ck.Unlock()
+	}
+}
+
+// ForgetChunks removes chunks of no file, as clones not attached, and returns
+// the servers holding each, to be collected as garbage.
+func (cm *chunkManager) ForgetChunks(handles []gfs.ChunkHandle) map[gfs.ChunkHandle][]gfs.ServerAddress {
+	cm.Lock()
+	defer cm.Unlock()
+	ret := make(map[gfs.ChunkHandle][]gfs.ServerAddress, len(handles))
+	for _, handle := range handles {
+		if ck, ok := cm.chunk[handle]; ok {
+			ret[handle] = ck.location
+			delete(cm.chunk, handle)
+		}
+	}
+	return ret
+}
+
+// FileChunks returns the chunks of the file path.
+func (cm *chunkManager) FileChunks(path gfs.Path) []gfs.ChunkHandle {
+	cm.RLock()
+	defer cm.RUnlock()
+	if fileinfo, ok := cm.file[path]; ok {
+		return append([]gfs.ChunkHandle(nil), fileinfo.handles...)
+	}
+	return nil
+}
+
+// ConcatFiles moves the chunks of the files sources, in order, to the end of
+// the file target, as the sources are removed from the namespace.
+func (cm *chunkManager) ConcatFiles(target gfs.Path, sources []gfs.Path) {
+	cm.Lock()
+	var handles []gfs.ChunkHandle
+	for _, p := range sources {
+		if fileinfo, ok := cm.file[p]; ok {
+			handles = append(handles, fileinfo.handles...)
+			delete(cm.file, p)
+		}
+	}
+	cm.Unlock()
+	cm.AttachChunks(target, handles)
+}
+
+// snapshot returns the replica locations, the version and the lease expire
+// time of a chunk, to be checked with it unlocked.
+func (ck *chunkInfo) snapshot() ([]gfs.ServerAddress, gfs.ChunkVersion, time.Time) {
+	ck.RLock()
+	defer ck.RUnlock()
+	return append([]gfs.ServerAddress(nil), ck.location...), ck.version, ck.expire
+}
+
+// regranted reports whether a lease was granted past version, which checked
+// the replicas itself.
+func (ck *chunkInfo) regranted(version gfs.ChunkVersion) bool {
+	ck.RLock()
+	defer ck.RUnlock()
+	return ck.version != version
+}
+
+// CheckReplicas reads the version and committed length of every replica of a
+// chunk, and returns the divergent ones: those missing the chunk or of a stale
+// version and, once its lease has settled, those shorter than another replica
+// of the current version. Unreachable replicas are left to heartbeats. The
+// replicas are read with the chunk unlocked, so that its leases and
+// heartbeats do not wait for slow servers; none is returned if a lease is
+// granted meanwhile, checking the versions itself. token is the chunk token
+// the replicas are read with.
+func (cm *chunkManager) CheckReplicas(ctx context.Context, handle gfs.ChunkHandle, token string) ([]gfs.ServerAddress, error) {
+	cm.RLock()
+	ck, ok := cm.chunk[handle]
+	cm.RUnlock()
+	if !ok {
+		return nil, gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("cannot find chunk %v", handle)}
+	}
+
+	locations, version, expire := ck.snapshot()
+	replies := make([]*gfs.ReadChunkReply, len(locations))
+	missing := make([]bool, len(locations))
+	var wg sync.WaitGroup
+	wg.Add(len(locations))
+	for i, v := range locations {
+		go func(i int, addr gfs.ServerAddress) {
+			defer wg.Done()
+			var r gfs.ReadChunkReply
+			err := util.Call(ctx, addr, "ChunkServer.RPCReadChunk", gfs.ReadChunkArg{handle, 0, 0, token, ""}, &r)
+			if err == nil {
+				replies[i] = &r
+			} else if errors.Is(err, gfs.ChunkNotFound) {
+				missing[i] = true
+			}
+		}(i, v)
+	}
+	wg.Wait()
+	if ck.regranted(version) {
+		return nil, nil
+	}
+
+	var longest gfs.Offset
+	for _, r := range replies {
+		if r != nil && r.Version == version && r.ChunkLength > longest {
+			longest = r.ChunkLength
+		}
+	}
+	settled := expire.Add(gfs.DivergenceGrace).Before(time.Now())
+
+	var divergent []gfs.ServerAddress
+	for i, r := range replies {
+		switch {
+		case missing[i]:
+			log.Warningf("chunk %v is missing in %v", handle, locations[i])
+		case r == nil:
+			continue
+		case r.Version < version:
+			log.Warningf("detect stale chunk %v in %v (version %v < %v)", handle, locations[i], r.Version, version)
+		case settled && r.Version == version && r.ChunkLength < longest:
+			log.Warningf("detect short chunk %v in %v (length %v < %v)", handle, locations[i], r.ChunkLength, longest)
+		default:
+			continue
+		}
+		divergent = append(divergent, locations[i])
 	}
+	if len(divergent) == len(locations) {
+		return nil, gfs.Error{gfs.NoReplica, fmt.Sprintf("all %v replicas of chunk %v diverge, none dropped", len(divergent), handle)}
+	}
+	return divergent, nil
+}
+
+// CheckReplicaData gets the hash of the data of every replica of a chunk,
+// once its lease has settled, and returns the replicas of the current
+// version whose length and hash are not those of the majority of them. If
+// there is no majority none is returned, master cannot tell the good
+// replicas. The chunk is not locked meanwhile, as by CheckReplicas.
+func (cm *chunkManager) CheckReplicaData(ctx context.Context, handle gfs.ChunkHandle, token string) ([]gfs.ServerAddress, error) {
+	cm.RLock()
+	ck, ok := cm.chunk[handle]
+	cm.RUnlock()
+	if !ok {
+		return nil, gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("cannot find chunk %v", handle)}
+	}
+
+	locations, version, expire := ck.snapshot()
+	if !expire.Add(gfs.DivergenceGrace).Before(time.Now()) {
+		return nil, nil // mutations may be in flight
+	}
+	replies := make([]*gfs.ChunkHashReply, len(locations))
+	var wg sync.WaitGroup
+	wg.Add(len(locations))
+	for i, v := range locations {
+		go func(i int, addr gfs.ServerAddress) {
+			defer wg.Done()
+			var r gfs.ChunkHashReply
+			if err := util.Call(ctx, addr, "ChunkServer.RPCChunkHash", gfs.ChunkHashArg{handle, token, "", false}, &r); err == nil && r.Version == version {
+				replies[i] = &r
+			}
+		}(i, v)
+	}
+	wg.Wait()
+	if ck.regranted(version) {
+		return nil, nil
+	}
+
+	votes := make(map[string]int)
+	answers := 0
+	for _, r := range replies {
+		if r != nil {
+			votes[fmt.Sprintf("%v:%x", r.Length, r.Sum)]++
+			answers++
+		}
+	}
+	for key, n := range votes {
+		if 2*n <= answers {
+			continue
+		}
+		var divergent []gfs.ServerAddress
+		for i, r := range replies {
+			if r != nil && fmt.Sprintf("%v:%x", r.Length, r.Sum) != key {
+				log.Warningf("detect divergent data of chunk %v in %v (length %v, hash %x)", handle, locations[i], r.Length, r.Sum)
+				divergent = append(divergent, locations[i])
+			}
+		}
+		return divergent, nil
+	}
+	if len(votes) > 1 {
+		log.Warningf("the %v replicas of chunk %v answering disagree on their data, none has a majority", answers, handle)
+	}
+	return nil, nil
+}
+
+// RevokeLease takes back the lease of a chunk from its primary, or from the
+// one that released it while clients may still cache it. It returns the
+// holder, empty if the chunk is not leased, and when the lease expires. If
+// the holder cannot be reached the lease stands until it expires. The next
+// lease goes to another replica.
+func (cm *chunkManager) RevokeLease(ctx context.Context, handle gfs.ChunkHandle) (gfs.ServerAddress, time.Time, error) {
+	cm.RLock()
+	ck, ok := cm.chunk[handle]
+	cm.RUnlock()
+	if !ok {
+		return "", time.Time{}, gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("invalid chunk handle %v", handle)}
+	}
+
+	ck.Lock()
+	defer ck.Unlock()
+	return cm.revokeLease(ctx, handle, ck)
+}
+
+// revokeLease revokes the lease of the chunk handle, ck should be locked.
+func (cm *chunkManager) revokeLease(ctx context.Context, handle gfs.ChunkHandle, ck *chunkInfo) (gfs.ServerAddress, time.Time, error) {
+	now := time.Now()
+	holder, expire := ck.primary, ck.expire
+	if !expire.After(now) {
+		holder, expire = ck.released, ck.releasedExpire
+	}
+	if holder == "" || !expire.After(now) {
+		return "", time.Time{}, nil
+	}
+
+	err := util.Call(ctx, holder, "ChunkServer.RPCRevokeLease", gfs.RevokeLeaseArg{handle, expire, cm.secret.ServerToken()}, &gfs.RevokeLeaseReply{})
+	if err != nil {
+		return holder, expire, err
+	}
+	ck.revoked, ck.revokedExpire = holder, expire
+	ck.released, ck.releasedExpire = "", time.Time{}
+	if ck.expire.After(now) {
+		ck.expire = now
+	}
+	return holder, expire, nil
 }
 
 // RemoveChunks removes disconnected chunks
@@ -342,6 +945,36 @@ func (cm *chunkManager) RemoveChunks(handles []gfs.ChunkHandle, server gfs.Serve
 	}
 }
 
+// underReplicatedChunk is a chunk with less than gfs.DefaultNumReplicas replicas.
+type underReplicatedChunk struct {
+	Handle   gfs.ChunkHandle
+	Path     gfs.Path
+	Replicas []gfs.ServerAddress
+}
+
+// UnderReplicated returns the chunks with less than gfs.DefaultNumReplicas replicas, sorted by handle.
+func (cm *chunkManager) UnderReplicated() []underReplicatedChunk {
+	// don't hold cm while locking ck, GetLeaseHolder locks them in the reverse order
+	cm.RLock()
+	chunks := make(map[gfs.ChunkHandle]*chunkInfo, len(cm.chunk))
+	for h, ck := range cm.chunk {
+		chunks[h] = ck
+	}
+	cm.RUnlock()
+
+	var ret []underReplicatedChunk
+	for h, ck := range chunks {
+		ck.RLock()
+		if len(ck.location) < gfs.DefaultNumReplicas {
+			replicas := append([]gfs.ServerAddress(nil), ck.location...)
+			ret = append(ret, underReplicatedChunk{h, ck.path, replicas})
+		}
+		ck.RUnlock()
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Handle < ret[j].Handle })
+	return ret
+}
+
 // GetNeedList clears the need list at first (removes the old handles that nolonger need replicas)
 // and then return all new handles
 func (cm *chunkManager) GetNeedlist() []gfs.ChunkHandle {
@@ -351,7 +984,7 @@ func (cm *chunkManager) GetNeedlist() []gfs.ChunkHandle {
 	// clear satisfied chunk
 	var newlist []int
 	for _, v := range cm.replicasNeedList {
-		if len(cm.chunk[v].location) < gfs.MinimumNumReplicas {
+		if ck, ok := cm.chunk[v]; ok && len(ck.location) < gfs.MinimumNumReplicas {
 			newlist = append(newlist, int(v))
 		}
 	}
@@ -3,7 +3,9 @@ package master
 import (
 	"fmt"
 	//"math/rand"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gfs"
@@ -14,12 +16,18 @@ import (
 // chunkServerManager manages chunkservers
 type chunkServerManager struct {
 	sync.RWMutex
-	servers map[gfs.ServerAddress]*chunkServerInfo
+	servers  map[gfs.ServerAddress]*chunkServerInfo
+	topology map[gfs.ServerAddress]gfs.Topology // set on master, overrides the reported one
+	timeout  int64                              // since the last heartbeat of a dead server, accessed atomically
+	policy   PrimaryPolicy                      // chooses the primaries of new leases
 }
 
 func newChunkServerManager() *chunkServerManager {
 	csm := &chunkServerManager{
-		servers: make(map[gfs.ServerAddress]*chunkServerInfo),
+		servers:  make(map[gfs.ServerAddress]*chunkServerInfo),
+		topology: make(map[gfs.ServerAddress]gfs.Topology),
+		timeout:  int64(gfs.ServerTimeout),
+		policy:   balancedPrimary{},
 	}
 	log.Info("-----------new chunk server manager")
 	return csm
@@ -29,24 +37,230 @@ type chunkServerInfo struct {
 	lastHeartbeat time.Time
 	chunks        map[gfs.ChunkHandle]bool // set of chunks that the chunkserver has
 	garbage       []gfs.ChunkHandle
+	revoked       []gfs.RevokeLeaseArg          // leases to take back, sent with the next heartbeat reply
+	stale         []gfs.ChunkHandle             // replicas to abandon as stale, sent likewise
+	versions      []gfs.RequiredVersion         // versions of replicas discarded, sent likewise
+	domain        string                        // failure domain (rack/zone)
+	labels        map[string]string             // matched by placement constraints
+	topology      gfs.Topology                  // reported by the chunkserver
+	diskUsed      int64                         // bytes of chunk files
+	diskFree      int64                         // bytes available on the disk, -1 if unknown
+	reported      int                           // chunks reported by the chunkserver
+	ioLoad        int64                         // bytes read and written per second
+	mutationLoad  int64                         // mutations applied per second
+	leases        map[gfs.ChunkHandle]time.Time // leases granted to the chunkserver as primary
+	draining      bool                          // being decommissioned, set on master
+	capacity      int64                         // bytes of the disk, -1 if unknown
+	version       string                        // of the chunkserver software
 }
 
-func (csm *chunkServerManager) Heartbeat(addr gfs.ServerAddress, reply *gfs.HeartbeatReply) bool {
+// Register adds a chunkserver, replacing the information of a server known
+// by the address. Chunks of the inventory are added separately.
+func (csm *chunkServerManager) Register(args gfs.RegisterServerArg) {
 	csm.Lock()
 	defer csm.Unlock()
 
+	log.Info("New chunk server" + args.Address)
+	csm.servers[args.Address] = &chunkServerInfo{
+		lastHeartbeat: time.Now(),
+		chunks:        make(map[gfs.ChunkHandle]bool),
+		domain:        args.Domain,
+		labels:        args.Labels,
+		topology:      args.Topology,
+		diskFree:      -1,
+		reported:      len(args.Chunks),
+		leases:        make(map[gfs.ChunkHandle]time.Time),
+		capacity:      args.Capacity,
+		version:       args.Version,
+	}
+}
+
+// Registered returns whether a chunkserver is known by the address.
+func (csm *chunkServerManager) Registered(addr gfs.ServerAddress) bool {
+	csm.RLock()
+	defer csm.RUnlock()
+	_, ok := csm.servers[addr]
+	return ok
+}
+
+// Heartbeat records the status of a registered chunkserver and returns the
+// commands pending for it in reply: the garbage, the leases revoked, the
+// stale replicas and the versions required. It fails with gfs.NotRegistered
+// for unknown servers.
+func (csm *chunkServerManager) Heartbeat(args gfs.HeartbeatArg, reply *gfs.HeartbeatReply) error {
+	csm.Lock()
+	defer csm.Unlock()
+
+	addr := args.Address
+
 	sv, ok := csm.servers[addr]
 	if !ok {
-		log.Info("New chunk server" + addr)
-		csm.servers[addr] = &chunkServerInfo{time.Now(), make(map[gfs.ChunkHandle]bool), nil}
-		return true
-	} else {
-		// send garbage
-		reply.Garbage = csm.servers[addr].garbage
-		csm.servers[addr].garbage = make([]gfs.ChunkHandle, 0)
-		sv.lastHeartbeat = time.Now()
+		return gfs.Error{gfs.NotRegistered, fmt.Sprintf("chunk server %v is not registered", addr)}
+	}
+	// send garbage and commands
+	reply.Garbage = sv.garbage
+	sv.garbage = make([]gfs.ChunkHandle, 0)
+	reply.Revoked, reply.Stale, reply.Versions = sv.revoked, sv.stale, sv.versions
+	sv.revoked, sv.stale, sv.versions = nil, nil, nil
+	sv.lastHeartbeat = time.Now()
+	sv.domain = args.Domain
+	sv.labels = args.Labels
+	sv.topology = args.Topology
+	sv.diskUsed = args.DiskUsed
+	sv.diskFree = args.DiskFree
+	sv.reported = args.Chunks
+	sv.ioLoad = args.IOLoad
+	sv.mutationLoad = args.MutationLoad
+	return nil
+}
+
+// List returns the information of all chunkservers, sorted by address.
+func (csm *chunkServerManager) List() []gfs.ServerInfo {
+	csm.RLock()
+	defer csm.RUnlock()
+
+	now := time.Now()
+	overloaded := csm.overloaded()
+	ret := make([]gfs.ServerInfo, 0, len(csm.servers))
+	for addr, sv := range csm.servers {
+		info := gfs.ServerInfo{
+			Address:       addr,
+			Domain:        sv.domain,
+			Labels:        sv.labels,
+			Topology:      csm.topologyOf(addr, sv),
+			LastHeartbeat: sv.lastHeartbeat,
+			Chunks:        len(sv.chunks),
+			DiskUsed:      sv.diskUsed,
+			StoredChunks:  sv.reported,
+			DiskFree:      sv.diskFree,
+			IOLoad:        sv.ioLoad,
+			MutationLoad:  sv.mutationLoad,
+			Draining:      sv.draining,
+			Capacity:      sv.capacity,
+			Version:       sv.version,
+			Health:        gfs.ServerHealthy,
+		}
+		switch {
+		case now.Sub(sv.lastHeartbeat) > gfs.SuspectHeartbeatAge:
+			info.Health = gfs.ServerSuspect
+		case sv.draining:
+			info.Health = gfs.ServerDraining
+		case sv.full():
+			info.Health = gfs.ServerFull
+		case overloaded[addr]:
+			info.Health = gfs.ServerOverloaded
+		}
+		for _, expire := range sv.leases {
+			if expire.After(now) {
+				info.Leases++
+			}
+		}
+		ret = append(ret, info)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Address < ret[j].Address })
+	return ret
+}
+
+// serverLoad is a snapshot of the chunks and disk usage of a chunkserver.
+type serverLoad struct {
+	addr     gfs.ServerAddress
+	chunks   map[gfs.ChunkHandle]bool
+	diskUsed int64
+}
+
+// Loads returns the loads of all chunkservers.
+func (csm *chunkServerManager) Loads() []*serverLoad {
+	csm.RLock()
+	defer csm.RUnlock()
+
+	ret := make([]*serverLoad, 0, len(csm.servers))
+	for addr, sv := range csm.servers {
+		l := &serverLoad{addr: addr, chunks: make(map[gfs.ChunkHandle]bool, len(sv.chunks)), diskUsed: sv.diskUsed}
+		for h, v := range sv.chunks {
+			if v {
+				l.chunks[h] = true
+			}
+		}
+		ret = append(ret, l)
+	}
+	return ret
+}
+
+// Accepts returns whether the server addr satisfies the must placement
+// constraints and accepts new chunks.
+func (csm *chunkServerManager) Accepts(addr gfs.ServerAddress, constraints []gfs.PlacementConstraint) bool {
+	csm.RLock()
+	defer csm.RUnlock()
+	sv, ok := csm.servers[addr]
+	if !ok || !sv.accepting() {
 		return false
 	}
+	_, ok = placementScore(sv.labels, constraints)
+	return ok
+}
+
+// DropChunk removes a replica of a chunk from a server, it is collected as garbage.
+func (csm *chunkServerManager) DropChunk(handle gfs.ChunkHandle, addr gfs.ServerAddress) {
+	csm.Lock()
+	defer csm.Unlock()
+	if sv, ok := csm.servers[addr]; ok {
+		delete(sv.chunks, handle)
+		sv.garbage = append(sv.garbage, handle)
+	}
+}
+
+// LoseChunks removes the replicas a server lost, they are not collected as garbage.
+func (csm *chunkServerManager) LoseChunks(addr gfs.ServerAddress, handles []gfs.ChunkHandle) {
+	csm.Lock()
+	defer csm.Unlock()
+	if sv, ok := csm.servers[addr]; ok {
+		for _, handle := range handles {
+			delete(sv.chunks, handle)
+		}
+	}
+}
+
+// ChooseExcess chooses a replica to drop among locations, on the rack with
+// the most replicas, then on the server with the most chunks.
+func (csm *chunkServerManager) ChooseExcess(locations []gfs.ServerAddress) gfs.ServerAddress {
+	csm.RLock()
+	defer csm.RUnlock()
+
+	perRack := make(map[string]int)
+	rack := make(map[gfs.ServerAddress]string)
+	chunks := make(map[gfs.ServerAddress]int)
+	for _, a := range locations {
+		if sv, ok := csm.servers[a]; ok {
+			t := csm.topologyOf(a, sv)
+			if t.Rack != "" {
+				rack[a] = t.Zone + "/" + t.Rack
+				perRack[rack[a]]++
+			}
+			chunks[a] = len(sv.chunks)
+		}
+	}
+	var ret gfs.ServerAddress
+	for _, a := range locations {
+		if ret == "" || perRack[rack[a]] > perRack[rack[ret]] ||
+			(perRack[rack[a]] == perRack[rack[ret]] && chunks[a] > chunks[ret]) {
+			ret = a
+		}
+	}
+	return ret
+}
+
+// MoveChunk moves a chunk from a server to another after it is copied,
+// the replica left on the server from is collected as garbage.
+func (csm *chunkServerManager) MoveChunk(handle gfs.ChunkHandle, from, to gfs.ServerAddress) {
+	csm.Lock()
+	defer csm.Unlock()
+	if sv, ok := csm.servers[from]; ok {
+		delete(sv.chunks, handle)
+		sv.garbage = append(sv.garbage, handle)
+	}
+	if sv, ok := csm.servers[to]; ok {
+		sv.chunks[handle] = true
+	}
 }
 
 // register a chunk to servers
@@ -59,6 +273,7 @@ func (csm *chunkServerManager) AddChunk(addrs []gfs.ServerAddress, handle gfs.Ch
 		sv, ok := csm.servers[v]
 		if ok {
 			sv.chunks[handle] = true
+			sv.stale = removeHandle(sv.stale, handle) // repaired or copied again
 		} else {
 			log.Warning("add chunk in removed server ", sv)
 		}
@@ -76,54 +291,432 @@ func (csm *chunkServerManager) AddGarbage(addr gfs.ServerAddress, handle gfs.Chu
 	}
 }
 
+// AddRevocation queues the revocation of a lease for the next heartbeat of
+// the server holding it, e.g. after the rpc revoking it failed. The server
+// refuses mutations as primary from then on, until expire.
+func (csm *chunkServerManager) AddRevocation(addr gfs.ServerAddress, handle gfs.ChunkHandle, expire time.Time) {
+	csm.Lock()
+	defer csm.Unlock()
+
+	if sv, ok := csm.servers[addr]; ok {
+		sv.revoked = append(sv.revoked, gfs.RevokeLeaseArg{handle, expire, ""})
+	}
+}
+
+// AddStale queues a stale replica of a chunk for the next heartbeat of the
+// server, which abandons it until it is repaired. It is dropped if the
+// replica is added again first.
+func (csm *chunkServerManager) AddStale(addr gfs.ServerAddress, handle gfs.ChunkHandle) {
+	csm.Lock()
+	defer csm.Unlock()
+
+	if sv, ok := csm.servers[addr]; ok {
+		sv.stale = append(removeHandle(sv.stale, handle), handle)
+	}
+}
+
+// RequireVersion queues the version of a chunk for the next heartbeat of the
+// server, which abandons its replica as stale if it is older.
+func (csm *chunkServerManager) RequireVersion(addr gfs.ServerAddress, handle gfs.ChunkHandle, version gfs.ChunkVersion) {
+	csm.Lock()
+	defer csm.Unlock()
+
+	if sv, ok := csm.servers[addr]; ok {
+		sv.versions = append(sv.versions, gfs.RequiredVersion{handle, version})
+	}
+}
+
+// removeHandle removes handle from handles, in place.
+func removeHandle(handles []gfs.ChunkHandle, handle gfs.ChunkHandle) []gfs.ChunkHandle {
+	ret := handles[:0]
+	for _, h := range handles {
+		if h != handle {
+			ret = append(ret, h)
+		}
+	}
+	return ret
+}
+
+// HasGarbage returns whether a replica of a chunk is to be collected from a server.
+func (csm *chunkServerManager) HasGarbage(addr gfs.ServerAddress, handle gfs.ChunkHandle) bool {
+	csm.RLock()
+	defer csm.RUnlock()
+
+	if sv, ok := csm.servers[addr]; ok {
+		for _, h := range sv.garbage {
+			if h == handle {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// placementScore returns whether a server with labels satisfies the must
+// constraints, and how much it is preferred, i.e. the number of matching
+// prefer constraints minus the number of matching avoid constraints.
+func placementScore(labels map[string]string, constraints []gfs.PlacementConstraint) (int, bool) {
+	score := 0
+	for _, c := range constraints {
+		match := c.Match(labels)
+		switch c.Kind {
+		case gfs.PlacementMust:
+			if !match {
+				return 0, false
+			}
+		case gfs.PlacementPrefer:
+			if match {
+				score++
+			}
+		case gfs.PlacementAvoid:
+			if match {
+				score--
+			}
+		}
+	}
+	return score, true
+}
+
+// SetTopology sets the topology of a server on master, overriding the one it
+// reports. The zero topology removes the override.
+func (csm *chunkServerManager) SetTopology(addr gfs.ServerAddress, t gfs.Topology) {
+	csm.Lock()
+	defer csm.Unlock()
+	if t == (gfs.Topology{}) {
+		delete(csm.topology, addr)
+	} else {
+		csm.topology[addr] = t
+	}
+}
+
+// topologyOf returns the topology of a server, the failure domain is its rack
+// if none is set. Call with csm locked.
+func (csm *chunkServerManager) topologyOf(addr gfs.ServerAddress, sv *chunkServerInfo) gfs.Topology {
+	t, ok := csm.topology[addr]
+	if !ok {
+		t = sv.topology
+	}
+	if t.Rack == "" {
+		t.Rack = sv.domain
+	}
+	return t
+}
+
+// domains are the zones and racks holding replicas of a chunk. Unknown ones
+// are never shared.
+type domains struct {
+	zones, racks map[string]bool
+}
+
+func newDomains() *domains {
+	return &domains{make(map[string]bool), make(map[string]bool)}
+}
+
+func (d *domains) add(t gfs.Topology) {
+	if t.Zone != "" {
+		d.zones[t.Zone] = true
+	}
+	if t.Rack != "" {
+		d.racks[t.Zone+"/"+t.Rack] = true
+	}
+}
+
+func (d *domains) hasZone(t gfs.Topology) bool { return t.Zone != "" && d.zones[t.Zone] }
+func (d *domains) hasRack(t gfs.Topology) bool { return t.Rack != "" && d.racks[t.Zone+"/"+t.Rack] }
+
+// full returns whether the disk of the server is nearly full.
+func (sv *chunkServerInfo) full() bool {
+	return sv.diskFree >= 0 && sv.diskFree < gfs.DiskFreeReserve
+}
+
+// accepting returns whether new chunks may be placed on the server.
+func (sv *chunkServerInfo) accepting() bool {
+	return !sv.draining && !sv.full()
+}
+
+// overloaded returns whether the io load of the server is above
+// gfs.OverloadFactor times mean. Call with csm locked.
+func (csm *chunkServerManager) overloaded() map[gfs.ServerAddress]bool {
+	var total int64
+	for _, v := range csm.servers {
+		total += v.ioLoad
+	}
+	ret := make(map[gfs.ServerAddress]bool)
+	if len(csm.servers) == 0 || total == 0 {
+		return ret
+	}
+	mean := float64(total) / float64(len(csm.servers))
+	for a, v := range csm.servers {
+		if float64(v.ioLoad) > gfs.OverloadFactor*mean {
+			ret[a] = true
+		}
+	}
+	return ret
+}
+
 // ChooseReReplication chooses servers to perfomr re-replication
 // called when the replicas number of a chunk is less than gfs.MinimumNumReplicas
-// returns two server address, the master will call 'from' to send a copy to 'to'
-func (csm *chunkServerManager) ChooseReReplication(handle gfs.ChunkHandle) (from, to gfs.ServerAddress, err error) {
+// returns two server address, the master will call 'from' to send a copy to 'to'.
+// 'to' satisfies the placement constraints of the chunk and is not nearly full.
+// It is in a zone, then a rack, without replicas if possible, then not
+// overloaded, then the most preferred. 'from' is not overloaded if possible.
+func (csm *chunkServerManager) ChooseReReplication(handle gfs.ChunkHandle, constraints []gfs.PlacementConstraint) (from, to gfs.ServerAddress, err error) {
 	csm.RLock()
 	defer csm.RUnlock()
 
 	from = ""
 	to = ""
 	err = nil
+	overloaded := csm.overloaded()
+	used := newDomains()
 	for a, v := range csm.servers {
 		if v.chunks[handle] {
-			from = a
-		} else {
-			to = a
+			used.add(csm.topologyOf(a, v))
+			if from == "" || overloaded[from] {
+				from = a
+			}
+		}
+	}
+
+	// rank compares candidates as tuples, bigger is better
+	rank := func(a gfs.ServerAddress, score int) [4]int {
+		t := csm.topologyOf(a, csm.servers[a])
+		var r [4]int
+		if !used.hasZone(t) {
+			r[0] = 1
+		}
+		if !used.hasRack(t) {
+			r[1] = 1
+		}
+		if !overloaded[a] {
+			r[2] = 1
+		}
+		r[3] = score
+		return r
+	}
+	better := func(x, y [4]int) bool {
+		for i := range x {
+			if x[i] != y[i] {
+				return x[i] > y[i]
+			}
+		}
+		return false
+	}
+	var best [4]int
+	for a, v := range csm.servers {
+		if v.chunks[handle] {
+			continue
 		}
-		if from != "" && to != "" {
-			return
+		if score, ok := placementScore(v.labels, constraints); ok && v.accepting() {
+			if r := rank(a, score); to == "" || better(r, best) {
+				to, best = a, r
+			}
 		}
 	}
-	err = fmt.Errorf("No enough server for replica %v", handle)
+	if from != "" && to != "" {
+		return
+	}
+	err = gfs.Error{gfs.NotEnoughServers, fmt.Sprintf("No enough server for replica %v", handle)}
 	return
 }
 
-// ChooseServers returns servers to store new chunk
-// called when a new chunk is create
-func (csm *chunkServerManager) ChooseServers(num int) ([]gfs.ServerAddress, error) {
+// ChoosePrimary chooses the primary of a new lease among up-to-date replicas
+// by the primary policy, given their lengths, the previous primary and the
+// writers' failure domain, and counts the lease for the primary.
+func (csm *chunkServerManager) ChoosePrimary(handle gfs.ChunkHandle, candidates []gfs.ServerAddress, lengths map[gfs.ServerAddress]gfs.Offset, previous gfs.ServerAddress, domain string, expire time.Time) gfs.ServerAddress {
+	csm.Lock()
+	defer csm.Unlock()
 
-	if num > len(csm.servers) {
-		return nil, fmt.Errorf("no enough servers for %v replicas", num)
+	now := time.Now()
+	info := make([]PrimaryCandidate, len(candidates))
+	for i, v := range candidates {
+		info[i] = PrimaryCandidate{Address: v, Length: lengths[v], Previous: v == previous}
+		if sv, ok := csm.servers[v]; ok {
+			for h, t := range sv.leases {
+				if t.Before(now) {
+					delete(sv.leases, h)
+				}
+			}
+			info[i].Local = domain != "" && sv.domain == domain
+			info[i].Draining = sv.draining
+			info[i].Leases = len(sv.leases)
+			info[i].MutationLoad = sv.mutationLoad
+			info[i].IOLoad = sv.ioLoad
+		}
+	}
+
+	var primary gfs.ServerAddress
+	if len(candidates) == 1 {
+		primary = candidates[0]
+	} else if len(candidates) > 1 {
+		primary = csm.policy.ChoosePrimary(handle, info)
+		if !containsServer(candidates, primary) {
+			primary = balancedPrimary{}.ChoosePrimary(handle, info)
+		}
+	}
+
+	if sv, ok := csm.servers[primary]; ok {
+		sv.leases[handle] = expire
+	}
+	return primary
+}
+
+// SetLease updates the expire time of a lease held by the server addr as
+// primary. The zero time removes it.
+func (csm *chunkServerManager) SetLease(addr gfs.ServerAddress, handle gfs.ChunkHandle, expire time.Time) {
+	csm.Lock()
+	defer csm.Unlock()
+	sv, ok := csm.servers[addr]
+	if !ok {
+		return
+	}
+	if expire.IsZero() {
+		delete(sv.leases, handle)
+	} else {
+		sv.leases[handle] = expire
+	}
+}
+
+// Leases returns the chunks whose unexpired leases the server addr holds as primary.
+func (csm *chunkServerManager) Leases(addr gfs.ServerAddress) []gfs.ChunkHandle {
+	csm.RLock()
+	defer csm.RUnlock()
+	sv, ok := csm.servers[addr]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	var ret []gfs.ChunkHandle
+	for h, expire := range sv.leases {
+		if expire.After(now) {
+			ret = append(ret, h)
+		}
 	}
+	return ret
+}
 
+// ChooseServers returns servers to store new chunk
+// called when a new chunk is create. Servers satisfying the placement
+// constraints and not nearly full are chosen randomly, the most preferred
+// ones first. Overloaded servers go after all the others. Replicas are spread
+// across zones, then racks, when possible.
+func (csm *chunkServerManager) ChooseServers(num int, constraints []gfs.PlacementConstraint) ([]gfs.ServerAddress, error) {
 	csm.RLock()
 	var all, ret []gfs.ServerAddress
-	for a, _ := range csm.servers {
-		all = append(all, a)
+	score := make(map[gfs.ServerAddress]int)
+	topology := make(map[gfs.ServerAddress]gfs.Topology)
+	overloaded := csm.overloaded()
+	for a, v := range csm.servers {
+		if s, ok := placementScore(v.labels, constraints); ok && v.accepting() {
+			all = append(all, a)
+			score[a] = s
+			topology[a] = csm.topologyOf(a, v)
+		}
 	}
 	csm.RUnlock()
 
-	choose, err := util.Sample(len(all), num)
+	if num > len(all) {
+		return nil, gfs.Error{gfs.NotEnoughServers, fmt.Sprintf("no enough servers for %v replicas", num)}
+	}
+
+	choose, err := util.Sample(len(all), len(all))
 	if err != nil {
 		return nil, err
 	}
 	for _, v := range choose {
 		ret = append(ret, all[v])
 	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		if overloaded[ret[i]] != overloaded[ret[j]] {
+			return !overloaded[ret[i]]
+		}
+		return score[ret[i]] > score[ret[j]]
+	})
+
+	// pass 0 takes new zones and racks only, pass 1 new racks, pass 2 any
+	var chosen []gfs.ServerAddress
+	picked := make(map[gfs.ServerAddress]bool)
+	used := newDomains()
+	for pass := 0; pass < 3 && len(chosen) < num; pass++ {
+		for _, a := range ret {
+			if len(chosen) == num {
+				break
+			}
+			t := topology[a]
+			if picked[a] || (pass < 2 && used.hasRack(t)) || (pass < 1 && used.hasZone(t)) {
+				continue
+			}
+			picked[a] = true
+			chosen = append(chosen, a)
+			used.add(t)
+		}
+	}
+	return chosen, nil
+}
+
+// SetDraining marks a server as being decommissioned, or not anymore. A
+// draining server gets no new chunks nor leases. It returns the chunks of the server.
+func (csm *chunkServerManager) SetDraining(addr gfs.ServerAddress, draining bool) ([]gfs.ChunkHandle, error) {
+	csm.Lock()
+	defer csm.Unlock()
+	sv, ok := csm.servers[addr]
+	if !ok {
+		return nil, gfs.Error{gfs.ServerNotFound, fmt.Sprintf("Cannot find chunk server %v", addr)}
+	}
+	sv.draining = draining
+	return sv.handles(), nil
+}
+
+// DrainingChunks returns the chunks of the draining servers.
+func (csm *chunkServerManager) DrainingChunks() []gfs.ChunkHandle {
+	csm.RLock()
+	defer csm.RUnlock()
+	var ret []gfs.ChunkHandle
+	for _, sv := range csm.servers {
+		if sv.draining {
+			ret = append(ret, sv.handles()...)
+		}
+	}
+	return ret
+}
 
-	return ret, nil
+// Draining returns whether a server is draining, and its chunks.
+func (csm *chunkServerManager) Draining(addr gfs.ServerAddress) (bool, []gfs.ChunkHandle, error) {
+	csm.RLock()
+	defer csm.RUnlock()
+	sv, ok := csm.servers[addr]
+	if !ok {
+		return false, nil, gfs.Error{gfs.ServerNotFound, fmt.Sprintf("Cannot find chunk server %v", addr)}
+	}
+	return sv.draining, sv.handles(), nil
+}
+
+func (sv *chunkServerInfo) handles() []gfs.ChunkHandle {
+	var ret []gfs.ChunkHandle
+	for h, v := range sv.chunks {
+		if v {
+			ret = append(ret, h)
+		}
+	}
+	return ret
+}
+
+// Live returns the locations not on draining servers.
+func (csm *chunkServerManager) Live(locations []gfs.ServerAddress) []gfs.ServerAddress {
+	csm.RLock()
+	defer csm.RUnlock()
+	return csm.live(locations)
+}
+
+// live is Live with csm locked.
+func (csm *chunkServerManager) live(locations []gfs.ServerAddress) []gfs.ServerAddress {
+	var ret []gfs.ServerAddress
+	for _, a := range locations {
+		if sv, ok := csm.servers[a]; !ok || !sv.draining {
+			ret = append(ret, a)
+		}
+	}
+	return ret
 }
 
 // DetectDeadServers detect disconnected servers according to last heartbeat time
@@ -133,8 +726,9 @@ func (csm *chunkServerManager) DetectDeadServers() []gfs.ServerAddress {
 
 	var ret []gfs.ServerAddress
 	now := time.Now()
+	timeout := time.Duration(atomic.LoadInt64(&csm.timeout))
 	for k, v := range csm.servers {
-		if v.lastHeartbeat.Add(gfs.ServerTimeout).Before(now) {
+		if v.lastHeartbeat.Add(timeout).Before(now) {
 			ret = append(ret, k)
 		}
 	}
@@ -142,6 +736,14 @@ func (csm *chunkServerManager) DetectDeadServers() []gfs.ServerAddress {
 	return ret
 }
 
+// Suspect returns whether addr is known and missed heartbeats for longer than age.
+func (csm *chunkServerManager) Suspect(addr gfs.ServerAddress, age time.Duration) bool {
+	csm.RLock()
+	defer csm.RUnlock()
+	sv, ok := csm.servers[addr]
+	return ok && time.Since(sv.lastHeartbeat) > age
+}
+
 // RemoveServers removes metedata of disconnected server
 // it returns the chunks that server holds
 func (csm *chunkServerManager) RemoveServer(addr gfs.ServerAddress) (handles []gfs.ChunkHandle, err error) {
@@ -151,7 +753,7 @@ func (csm *chunkServerManager) RemoveServer(addr gfs.ServerAddress) (handles []g
 	err = nil
 	sv, ok := csm.servers[addr]
 	if !ok {
-		err = fmt.Errorf("Cannot find chunk server %v", addr)
+		err = gfs.Error{gfs.ServerNotFound, fmt.Sprintf("Cannot find chunk server %v", addr)}
 		return
 	}
 	for h, v := range sv.chunks {
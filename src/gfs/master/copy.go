@@ -0,0 +1,303 @@
+package master
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gfs"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Files are copied and concatenated on master, without clients reading and
+// writing their data. A copy clones each chunk of the source on the servers
+// holding it, then creates the target with the clones. A concatenation moves
+// the chunks of the sources to the end of the target, so every file but the
+// last should be whole chunks long, leaving no hole in the middle of the
+// target.
+
+// createFile creates the file p as file, taking file.length bytes of the
+// quotas of its parents, and calls attach before it is seen. If attach is
+// nil, it only checks that the file can be created.
+func (nm *namespaceManager) createFile(p gfs.Path, file *nsTree, cred gfs.Credentials, size sizeFunc, attach func(), wait *time.Duration) error {
+	dir, filename := nm.PartionLastName(p)
+	ps, cwd, err := nm.lockParents(dir, true, wait)
+	defer nm.unlockParents(ps)
+	if err != nil {
+		return err
+	}
+	if err := nm.searchParents(ps, cred); err != nil {
+		return err
+	}
+
+	cwd.lock(wait)
+	defer cwd.Unlock()
+	if !cwd.isDir {
+		return gfs.Error{gfs.NotDirectory, fmt.Sprintf("parent of %v is a file", p)}
+	}
+	if _, ok := cwd.children[filename]; ok {
+		return gfs.Error{gfs.PathExists, fmt.Sprintf("path %v already exists", p)}
+	}
+	if err := cwd.checkAccess(dir, cred, permWrite|permExec); err != nil {
+		return err
+	}
+	if err := nm.checkQuota(dir, 1, file.length, size); err != nil {
+		return err
+	}
+	if attach == nil {
+		return nil
+	}
+	attach()
+	now := time.Now()
+	file.btime, file.mtime, file.ctime = now, now, now
+	cwd.children[filename] = file.own(cwd, cred)
+	nm.advance(file)
+	cwd.modified(now)
+	return nil
+}
+
+// copyFile creates the file target as a copy of source, with its chunk
+// size, replication and placement. The chunks are cloned one after the
+// other with no file locked, so a copy of a file mutated meanwhile may have
+// some of the mutations and not others.
+func (m *Master) copyFile(source, target gfs.Path, cred gfs.Credentials, wait *time.Duration) (*gfs.CopyFileReply, error) {
+	ps, cwd, err := m.nm.lockParents(source, false, wait)
+	if err == nil {
+		err = m.nm.searchParents(ps, cred)
+	}
+	var file *nsTree
+	if err == nil {
+		src, ok := cwd.children[ps[len(ps)-1]]
+		switch {
+		case !ok:
+			err = gfs.Error{gfs.PathNotFound, fmt.Sprintf("File %v does not exist", source)}
+		case src.isDir:
+			err = gfs.Error{gfs.IsDirectory, fmt.Sprintf("path %v is a directory", source)}
+		default:
+			src.rlock(wait)
+			if err = src.checkAccess(source, cred, permRead); err == nil {
+				file = &nsTree{chunkSize: src.chunkSize, replicas: src.replicas, placement: src.placement, length: m.fileSize(source, src)}
+			}
+			src.RUnlock()
+		}
+	}
+	m.nm.unlockParents(ps)
+	if err != nil {
+		return nil, err
+	}
+	// fail before cloning if the target cannot be created
+	if err := m.nm.createFile(target, file, cred, m.fileSize, nil, wait); err != nil {
+		return nil, err
+	}
+
+	var clones []gfs.ChunkHandle
+	release := func() {
+		for handle, addrs := range m.cm.ForgetChunks(clones) {
+			for _, addr := range addrs {
+				m.csm.AddGarbage(addr, handle)
+			}
+		}
+	}
+	for _, handle := range m.cm.FileChunks(source) {
+		clone, addrs, failed, err := m.cm.CloneChunk(m.ctx, handle)
+		for _, addr := range failed {
+			m.csm.AddGarbage(addr, clone)
+		}
+		if err != nil {
+			release()
+			return nil, err
+		}
+		clones = append(clones, clone)
+		m.csm.AddChunk(addrs, clone)
+		if live := len(m.csm.Live(addrs)); live < file.replication() {
+			m.rq.add(clone, live)
+		}
+	}
+
+	err = m.nm.createFile(target, file, cred, m.fileSize, func() {
+		file.chunks = int64(len(clones))
+		m.cm.AttachChunks(target, clones)
+	}, wait)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	log.Infof("copied %v to %v with %v chunks cloned", source, target, len(clones))
+	return &gfs.CopyFileReply{file.length, file.chunks}, nil
+}
+
+// lookup returns the node at p and its parent. The namespace should be
+// locked in advance.
+func (nm *namespaceManager) lookup(p gfs.Path) (parent, node *nsTree, err error) {
+	node = nm.root
+	for _, name := range splitPath(p) {
+		if !node.isDir {
+			return nil, nil, gfs.Error{gfs.NotDirectory, fmt.Sprintf("parent of %v is a file", p)}
+		}
+		child, ok := node.children[name]
+		if !ok {
+			return nil, nil, gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %v not found", p)}
+		}
+		parent, node = node, child
+	}
+	return parent, node, nil
+}
+
+// Concat moves the chunks of the files sources, in order, to the end of the
+// file target, and removes the sources, calling moved with the namespace
+// locked. All should have the chunk size of target, and target and all the
+// sources but the last should be whole chunks long. cred should be allowed to
+// write target, read the sources and remove them. The whole namespace is
+// locked meanwhile, as in Import. It returns the length, the number of
+// chunks and the generation of target afterwards.
+func (nm *namespaceManager) Concat(target gfs.Path, sources []gfs.Path, cred gfs.Credentials, size sizeFunc, moved func(), wait *time.Duration) (length, chunks, generation int64, err error) {
+	if len(sources) == 0 {
+		return 0, 0, 0, gfs.Error{gfs.InvalidArgument, "no file to concatenate"}
+	}
+	seen := map[gfs.Path]bool{target: true}
+	for _, p := range sources {
+		if seen[p] {
+			return 0, 0, 0, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("file %v is concatenated twice", p)}
+		}
+		seen[p] = true
+	}
+
+	nm.root.lock(wait)
+	defer nm.root.Unlock()
+
+	_, file, err := nm.lookup(target)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if file.isDir {
+		return 0, 0, 0, gfs.Error{gfs.IsDirectory, fmt.Sprintf("path %v is a directory", target)}
+	}
+	if err := nm.searchParents(strings.Split(string(target), "/")[1:], cred); err != nil {
+		return 0, 0, 0, err
+	}
+	if err := file.checkAccess(target, cred, permWrite); err != nil {
+		return 0, 0, 0, err
+	}
+
+	// check all files before changing anything
+	parents := make([]*nsTree, len(sources))
+	nodes := make([]*nsTree, len(sources))
+	lengths := make([]int64, len(sources))
+	length = size(target, file)
+	whole := func(p gfs.Path, node *nsTree, length int64) error {
+		if length != node.chunks*node.chunkSize {
+			return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("file %v of %v bytes is not whole chunks of %v bytes", p, length, node.chunkSize)}
+		}
+		return nil
+	}
+	if err := whole(target, file, length); err != nil {
+		return 0, 0, 0, err
+	}
+	for i, p := range sources {
+		parent, node, err := nm.lookup(p)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if node.isDir {
+			return 0, 0, 0, gfs.Error{gfs.IsDirectory, fmt.Sprintf("path %v is a directory", p)}
+		}
+		if node.chunkSize != file.chunkSize {
+			return 0, 0, 0, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("chunk size of %v is %v, not %v", p, node.chunkSize, file.chunkSize)}
+		}
+		if err := nm.searchParents(strings.Split(string(p), "/")[1:], cred); err != nil {
+			return 0, 0, 0, err
+		}
+		dir, _ := nm.PartionLastName(p)
+		if err := parent.checkAccess(dir, cred, permWrite|permExec); err != nil {
+			return 0, 0, 0, err
+		}
+		if err := node.checkAccess(p, cred, permRead); err != nil {
+			return 0, 0, 0, err
+		}
+		parents[i], nodes[i], lengths[i] = parent, node, size(p, node)
+		if i < len(sources)-1 {
+			if err := whole(p, node, lengths[i]); err != nil {
+				return 0, 0, 0, err
+			}
+		}
+	}
+
+	// the bytes of the sources move into the quotas of target they are not under
+	dir, _ := nm.PartionLastName(target)
+	quotas, err := nm.quotas(dir, size)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for _, q := range quotas {
+		var bytes int64
+		for i, p := range sources {
+			if !under(p, q.path) {
+				bytes += lengths[i]
+			}
+		}
+		if err := checkQuotas([]dirQuota{q}, 0, bytes); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	now := time.Now()
+	for i, p := range sources {
+		_, name := nm.PartionLastName(p)
+		delete(parents[i].children, name)
+		parents[i].modified(now)
+		if parents[i].isEmpty() {
+			parents[i].emptySince = now
+		}
+		file.chunks += nodes[i].chunks
+		length += lengths[i]
+	}
+	file.length = length
+	file.modified(now)
+	nm.advance(file)
+	moved()
+	return file.length, file.chunks, file.generation, nil
+}
+
+// RPCCopyFile is called by client to copy a file, the chunkservers cloning its chunks.
+func (m *Master) RPCCopyFile(args gfs.CopyFileArg, reply *gfs.CopyFileReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	if err := m.throttleCreate(args.Target); err != nil {
+		return err
+	}
+	ret, err := m.copyFile(args.Source, args.Target, args.Cred, &wait)
+	if err != nil {
+		return err
+	}
+	*reply = *ret
+	return nil
+}
+
+// RPCConcat is called by client to move the chunks of files to the end of another one, removing them.
+func (m *Master) RPCConcat(args gfs.ConcatArg, reply *gfs.ConcatReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	reply.Length, reply.Chunks, reply.Generation, err = m.nm.Concat(args.Target, args.Sources, args.Cred, m.fileSize, func() {
+		m.cm.ConcatFiles(args.Target, args.Sources)
+	}, &wait)
+	if err == nil {
+		log.Infof("concatenated %v into %v", args.Sources, args.Target)
+	}
+	return err
+}
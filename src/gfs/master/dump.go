@@ -0,0 +1,154 @@
+package master
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"gfs"
+	log "github.com/Sirupsen/logrus"
+)
+
+// A dump of the metadata of master lists the namespace by path, with the
+// chunks of each file, so that it does not depend on how master stores it.
+// A new master is bootstrapped from a dump by writing its metadata with
+// RestoreDump before it starts, so that the chunkservers registering with it
+// find their chunks known rather than collected as garbage.
+
+// Dump returns a dump of the metadata of master. The namespace and the
+// chunks are taken one after the other, as when the metadata is stored, so
+// a dump taken while clients mutate may not match itself, one taken in
+// read-only or maintenance mode does.
+func (m *Master) Dump() *gfs.NamespaceDump {
+	m.storing.Lock()
+	tree := m.nm.Serialize()
+	files := m.cm.Serialize()
+	m.storing.Unlock()
+	return dumpMeta(&PersistentBlock{tree, files, m.th.List(), m.cm.NextHandle()}, time.Now())
+}
+
+// dumpMeta returns the dump of stored metadata, the children of each
+// directory in order of their names.
+func dumpMeta(meta *PersistentBlock, now time.Time) *gfs.NamespaceDump {
+	chunks := make(map[gfs.Path][]gfs.PersistentChunkInfo, len(meta.ChunkInfo))
+	for _, f := range meta.ChunkInfo {
+		chunks[f.Path] = f.Info
+	}
+	dump := &gfs.NamespaceDump{Format: gfs.NamespaceDumpFormat, Time: now, Throttles: meta.Throttles}
+
+	var add func(p gfs.Path, id int)
+	add = func(p gfs.Path, id int) {
+		n := meta.NamespaceTree[id]
+		e := gfs.DumpEntry{Path: p, IsDir: n.IsDir, Protected: n.Protected, Length: n.Length, Chunks: n.Chunks, ChunkSize: n.ChunkSize, Replicas: n.Replicas, Placement: n.Placement, Generation: n.Generation, ModTime: n.Mtime, ChangeTime: n.Ctime, Owner: n.Owner, Group: n.Group, Mode: n.Mode, MaxFiles: n.MaxFiles, MaxBytes: n.MaxBytes, Xattrs: n.Xattrs}
+		if !n.IsDir {
+			e.ChunkInfo = chunks[p]
+		}
+		dump.Entries = append(dump.Entries, e)
+
+		names := make([]string, 0, len(n.Children))
+		for name := range n.Children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			add(gfs.Path(strings.TrimSuffix(string(p), "/")+"/"+name), n.Children[name])
+		}
+	}
+	if len(meta.NamespaceTree) > 0 {
+		add("/", len(meta.NamespaceTree)-1) // the root is stored last
+	}
+	return dump
+}
+
+// restoreMeta returns the metadata to store for a dump. It fails if the dump
+// is of another format, if it does not start with the root, if an entry does
+// not follow a directory holding it, or if a chunk is of two files.
+func restoreMeta(dump *gfs.NamespaceDump) (*PersistentBlock, error) {
+	if dump.Format != gfs.NamespaceDumpFormat {
+		return nil, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("dump of format %v, not %v", dump.Format, gfs.NamespaceDumpFormat)}
+	}
+	if len(dump.Entries) == 0 || dump.Entries[0].Path != "/" || !dump.Entries[0].IsDir {
+		return nil, gfs.Error{gfs.InvalidArgument, "dump does not start with the root directory"}
+	}
+
+	meta := &PersistentBlock{Throttles: dump.Throttles}
+	nodes := make([]serialTreeNode, len(dump.Entries))
+	ids := make(map[gfs.Path]int, len(dump.Entries))
+	files := make(map[gfs.ChunkHandle]gfs.Path)
+	for i, e := range dump.Entries {
+		if _, ok := ids[e.Path]; ok || path.Clean(string(e.Path)) != string(e.Path) || !path.IsAbs(string(e.Path)) {
+			return nil, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("invalid or repeated path %v in dump", e.Path)}
+		}
+		if i > 0 {
+			parent, ok := ids[gfs.Path(path.Dir(string(e.Path)))]
+			if !ok || !nodes[parent].IsDir {
+				return nil, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("path %v does not follow its directory in dump", e.Path)}
+			}
+			nodes[parent].Children[path.Base(string(e.Path))] = i
+		}
+		ids[e.Path] = i
+		nodes[i] = serialTreeNode{IsDir: e.IsDir, Protected: e.Protected, Length: e.Length, Chunks: e.Chunks, ChunkSize: e.ChunkSize, Replicas: e.Replicas, Placement: e.Placement, Generation: e.Generation, Mtime: e.ModTime, Ctime: e.ChangeTime, Owner: e.Owner, Group: e.Group, Mode: e.Mode, MaxFiles: e.MaxFiles, MaxBytes: e.MaxBytes, Xattrs: e.Xattrs}
+		if e.IsDir {
+			nodes[i].Children = make(map[string]int)
+			continue
+		}
+		for _, ck := range e.ChunkInfo {
+			if p, ok := files[ck.Handle]; ok {
+				return nil, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("chunk %v is of both %v and %v in dump", ck.Handle, p, e.Path)}
+			}
+			files[ck.Handle] = e.Path
+		}
+		if len(e.ChunkInfo) > 0 {
+			meta.ChunkInfo = append(meta.ChunkInfo, serialChunkInfo{e.Path, e.ChunkInfo})
+		}
+	}
+
+	// the root is stored last, and the children after their parents
+	n := len(nodes)
+	for i := range nodes {
+		for name, id := range nodes[i].Children {
+			nodes[i].Children[name] = n - 1 - id
+		}
+	}
+	for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	}
+	meta.NamespaceTree = nodes
+	return meta, nil
+}
+
+// RestoreDump writes the metadata of a dump into serverRoot, for a master
+// started there afterwards. It fails if serverRoot holds metadata already.
+func RestoreDump(serverRoot string, dump *gfs.NamespaceDump) error {
+	meta, err := restoreMeta(dump)
+	if err != nil {
+		return err
+	}
+	filename := path.Join(serverRoot, MetaFileName)
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, FilePerm)
+	if os.IsExist(err) {
+		return gfs.Error{gfs.PathExists, fmt.Sprintf("metadata %v exists", filename)}
+	}
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(meta); err != nil {
+		file.Close()
+		return err
+	}
+	log.Infof("restored %v entries and %v files with chunks of a dump of %v into %v", len(dump.Entries), len(meta.ChunkInfo), dump.Time, filename)
+	return file.Close()
+}
+
+// RPCDumpNamespace is called by an admin to dump the namespace and the chunks of master
+func (m *Master) RPCDumpNamespace(args gfs.DumpNamespaceArg, reply *gfs.DumpNamespaceReply) error {
+	if err := m.checkAdmin(args.Cred, "dump the namespace"); err != nil {
+		return err
+	}
+	reply.Dump = *m.Dump()
+	return nil
+}
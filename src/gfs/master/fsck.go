@@ -0,0 +1,203 @@
+package master
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gfs"
+	"gfs/util"
+)
+
+// fsckFile is a file of the namespace checked by fsck.
+type fsckFile struct {
+	path        gfs.Path
+	chunks      int64
+	replication int
+}
+
+// fsckChunk is a chunk checked by fsck, ck is nil if master does not know it.
+type fsckChunk struct {
+	handle gfs.ChunkHandle
+	ck     *chunkInfo
+}
+
+// fsckReport adds problems to a reply, up to gfs.FsckMaxProblems.
+type fsckReport struct {
+	*gfs.FsckReply
+}
+
+func (r fsckReport) add(kind gfs.FsckKind, p gfs.Path, handle gfs.ChunkHandle, server gfs.ServerAddress, format string, args ...interface{}) {
+	if len(r.Problems) == gfs.FsckMaxProblems {
+		r.More++
+		return
+	}
+	r.Problems = append(r.Problems, gfs.FsckProblem{kind, p, handle, server, fmt.Sprintf(format, args...)})
+}
+
+// under returns whether path is p or inside it.
+func under(path, p gfs.Path) bool {
+	return p == "/" || path == p || strings.HasPrefix(string(path), string(p)+"/")
+}
+
+// Fsck cross-checks the files under p in the namespace with the chunks of
+// master and, if inventories is set, with the chunks reported by the
+// chunkservers. Nothing is locked across the checks, so mutations, copies
+// and deletions going on meanwhile may be reported too.
+func (m *Master) Fsck(p gfs.Path, inventories bool) (*gfs.FsckReply, error) {
+	if p == "" {
+		p = "/"
+	}
+	var files []fsckFile
+	var wait time.Duration
+	err := m.nm.Walk(p, "", gfs.Credentials{}, &wait, func(path gfs.Path, node *nsTree) bool {
+		if !node.isDir {
+			files = append(files, fsckFile{path, node.chunks, node.replication()})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	reply := &gfs.FsckReply{Files: len(files)}
+	r := fsckReport{reply}
+
+	// the chunks are taken with cm locked and checked without, as in ReleaseChunks
+	chunks := make([][]fsckChunk, len(files))
+	known := make(map[gfs.Path]bool, len(files))
+	var rest []fsckChunk // of no file walked
+	m.cm.RLock()
+	for i, f := range files {
+		known[f.path] = true
+		fileinfo, ok := m.cm.file[f.path]
+		if !ok {
+			if f.chunks > 0 {
+				r.add(gfs.FsckMissingChunk, f.path, 0, "", "none of the %v chunks of the file are known", f.chunks)
+			}
+			continue
+		}
+		if int64(len(fileinfo.handles)) != f.chunks {
+			r.add(gfs.FsckBadMapping, f.path, 0, "", "%v chunks in the namespace, %v known", f.chunks, len(fileinfo.handles))
+		}
+		for _, handle := range fileinfo.handles {
+			chunks[i] = append(chunks[i], fsckChunk{handle, m.cm.chunk[handle]})
+		}
+	}
+	owned := make(map[gfs.ChunkHandle]bool)
+	for _, cs := range chunks {
+		for _, c := range cs {
+			owned[c.handle] = true
+		}
+	}
+	for handle, ck := range m.cm.chunk {
+		if !owned[handle] {
+			rest = append(rest, fsckChunk{handle, ck})
+		}
+	}
+	m.cm.RUnlock()
+
+	// replicas master knows of, to be checked with the inventories
+	versions := make(map[gfs.ChunkHandle]gfs.ChunkVersion)
+	located := make(map[gfs.ServerAddress][]gfs.ChunkHandle)
+	paths := make(map[gfs.ChunkHandle]gfs.Path)
+	for i, f := range files {
+		for _, c := range chunks[i] {
+			if c.ck == nil {
+				r.add(gfs.FsckMissingChunk, f.path, c.handle, "", "chunk of the file is not known")
+				continue
+			}
+			reply.Chunks++
+			c.ck.RLock()
+			path, version, location := c.ck.path, c.ck.version, append([]gfs.ServerAddress(nil), c.ck.location...)
+			c.ck.RUnlock()
+			versions[c.handle], paths[c.handle] = version, f.path
+			for _, addr := range location {
+				located[addr] = append(located[addr], c.handle)
+			}
+			if path != f.path {
+				r.add(gfs.FsckBadMapping, f.path, c.handle, "", "chunk of the file belongs to %v", path)
+			}
+			if len(location) == 0 {
+				r.add(gfs.FsckMissingChunk, f.path, c.handle, "", "no replica")
+			} else if live := len(m.csm.Live(location)); live < f.replication {
+				r.add(gfs.FsckUnderReplicated, f.path, c.handle, "", "%v live replicas of %v", live, f.replication)
+			}
+		}
+	}
+	for _, c := range rest {
+		c.ck.RLock()
+		path := c.ck.path
+		c.ck.RUnlock()
+		// files deleted lazily are left to the namespace collection
+		if under(path, p) && !known[path] && !strings.Contains(string(path), "/"+gfs.DeletedFilePrefix) {
+			r.add(gfs.FsckOrphanedChunk, path, c.handle, "", "chunk of a file not in the namespace")
+		}
+	}
+
+	if inventories {
+		m.fsckInventories(r, versions, located, paths)
+	}
+	return reply, nil
+}
+
+// fsckInventories checks the chunks reported by each chunkserver with the
+// versions of the chunks checked, and with the replicas master knows of.
+// Chunks master does not know of are orphaned, unless garbage is pending.
+func (m *Master) fsckInventories(r fsckReport, versions map[gfs.ChunkHandle]gfs.ChunkVersion, located map[gfs.ServerAddress][]gfs.ChunkHandle, paths map[gfs.ChunkHandle]gfs.Path) {
+	servers := m.csm.List()
+	replies := make([]*gfs.ReportSelfReply, len(servers))
+	var wg sync.WaitGroup
+	for i, v := range servers {
+		wg.Add(1)
+		go func(i int, addr gfs.ServerAddress) {
+			defer wg.Done()
+			var reply gfs.ReportSelfReply
+			if err := util.Call(m.ctx, addr, "ChunkServer.RPCReportSelf", gfs.ReportSelfArg{m.secret.ServerToken()}, &reply); err == nil {
+				replies[i] = &reply
+			}
+		}(i, v.Address)
+	}
+	wg.Wait()
+
+	for i, v := range servers {
+		addr := v.Address
+		if replies[i] == nil {
+			r.Unreachable = append(r.Unreachable, addr)
+			continue
+		}
+		r.Servers++
+		held := make(map[gfs.ChunkHandle]bool, len(replies[i].Chunks))
+		for _, info := range replies[i].Chunks {
+			held[info.Handle] = true
+			version, ok := versions[info.Handle]
+			if !ok {
+				if _, err := m.cm.ChunkPath(info.Handle); err != nil && !m.csm.HasGarbage(addr, info.Handle) {
+					r.add(gfs.FsckOrphanedChunk, "", info.Handle, addr, "replica of a chunk master does not know")
+				}
+				continue
+			}
+			if info.Version != version {
+				r.add(gfs.FsckVersionMismatch, paths[info.Handle], info.Handle, addr, "replica at version %v, master at %v", info.Version, version)
+			}
+		}
+		for _, handle := range located[addr] {
+			if !held[handle] {
+				r.add(gfs.FsckMissingReplica, paths[handle], handle, addr, "replica master knows of is not held")
+			}
+		}
+	}
+}
+
+// RPCFsck is called by an admin to cross-check the namespace, the chunks of master and the inventories of chunkservers
+func (m *Master) RPCFsck(args gfs.FsckArg, reply *gfs.FsckReply) error {
+	if err := m.checkAdmin(args.Cred, "check the namespace"); err != nil {
+		return err
+	}
+	ret, err := m.Fsck(args.Path, args.Inventories)
+	if err != nil {
+		return err
+	}
+	*reply = *ret
+	return nil
+}
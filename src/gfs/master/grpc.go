@@ -0,0 +1,120 @@
+package master
+
+import (
+	"context"
+
+	"gfs"
+	"gfs/pb"
+)
+
+// The rpcs of gfs.proto are served by gRPC as well, on the port of the
+// net/rpc ones, for clients in other languages. Each method converts its
+// message to the args of the rpc of the same name and calls it, so both
+// serve the same calls, checks and errors.
+
+// grpcMaster serves the Master service of gfs.proto.
+type grpcMaster struct {
+	pb.UnimplementedMasterServer
+	m *Master
+}
+
+func pathInfo(v gfs.PathInfo) *pb.PathInfo {
+	return &pb.PathInfo{
+		Name:   v.Name,
+		IsDir:  v.IsDir,
+		Length: v.Length,
+		Chunks: v.Chunks,
+		Owner:  v.Owner,
+		Group:  v.Group,
+		Mode:   uint32(v.Mode.Perm()),
+	}
+}
+
+func (g grpcMaster) CreateFile(ctx context.Context, args *pb.CreateFileArg) (*pb.CreateFileReply, error) {
+	var reply gfs.CreateFileReply
+	err := g.m.RPCCreateFile(gfs.CreateFileArg{gfs.Path(args.Path), args.ChunkSize, args.GetCred().Value()}, &reply)
+	return &pb.CreateFileReply{}, pb.Status(err)
+}
+
+func (g grpcMaster) Mkdir(ctx context.Context, args *pb.MkdirArg) (*pb.MkdirReply, error) {
+	var reply gfs.MkdirReply
+	err := g.m.RPCMkdir(gfs.MkdirArg{gfs.Path(args.Path), args.GetCred().Value()}, &reply)
+	return &pb.MkdirReply{}, pb.Status(err)
+}
+
+func (g grpcMaster) DeleteFile(ctx context.Context, args *pb.DeleteFileArg) (*pb.DeleteFileReply, error) {
+	var reply gfs.DeleteFileReply
+	if err := g.m.RPCDeleteFile(gfs.DeleteFileArg{gfs.Path(args.Path), args.Recursive, args.GetCred().Value()}, &reply); err != nil {
+		return nil, pb.Status(err)
+	}
+	return &pb.DeleteFileReply{Files: int32(reply.Files), Dirs: int32(reply.Dirs), Trash: string(reply.Trash)}, nil
+}
+
+func (g grpcMaster) RenameFile(ctx context.Context, args *pb.RenameFileArg) (*pb.RenameFileReply, error) {
+	var reply gfs.RenameFileReply
+	err := g.m.RPCRenameFile(gfs.RenameFileArg{gfs.Path(args.Source), gfs.Path(args.Target), args.GetCred().Value()}, &reply)
+	return &pb.RenameFileReply{}, pb.Status(err)
+}
+
+func (g grpcMaster) List(ctx context.Context, args *pb.ListArg) (*pb.ListReply, error) {
+	var reply gfs.ListReply
+	if err := g.m.RPCList(gfs.ListArg{gfs.Path(args.Path), args.After, int(args.Limit), args.Prefix, args.Pattern, args.GetCred().Value()}, &reply); err != nil {
+		return nil, pb.Status(err)
+	}
+	ret := &pb.ListReply{Files: make([]*pb.PathInfo, len(reply.Files)), Next: reply.Next}
+	for i, v := range reply.Files {
+		ret.Files[i] = pathInfo(v)
+	}
+	return ret, nil
+}
+
+func (g grpcMaster) GetFileInfo(ctx context.Context, args *pb.GetFileInfoArg) (*pb.GetFileInfoReply, error) {
+	var reply gfs.GetFileInfoReply
+	if err := g.m.RPCGetFileInfo(gfs.GetFileInfoArg{gfs.Path(args.Path), args.GetCred().Value()}, &reply); err != nil {
+		return nil, pb.Status(err)
+	}
+	return &pb.GetFileInfoReply{
+		IsDir:      reply.IsDir,
+		Length:     reply.Length,
+		Chunks:     reply.Chunks,
+		ChunkSize:  reply.ChunkSize,
+		Replicas:   int32(reply.Replicas),
+		Generation: reply.Generation,
+		ModTime:    pb.Timestamp(reply.ModTime),
+		ChangeTime: pb.Timestamp(reply.ChangeTime),
+		Owner:      reply.Owner,
+		Group:      reply.Group,
+		Mode:       uint32(reply.Mode.Perm()),
+		CreateTime: pb.Timestamp(reply.CreateTime),
+	}, nil
+}
+
+func (g grpcMaster) GetChunkHandle(ctx context.Context, args *pb.GetChunkHandleArg) (*pb.GetChunkHandleReply, error) {
+	var reply gfs.GetChunkHandleReply
+	if err := g.m.RPCGetChunkHandle(gfs.GetChunkHandleArg{gfs.Path(args.Path), gfs.ChunkIndex(args.Index), args.Write, args.GetCred().Value()}, &reply); err != nil {
+		return nil, pb.Status(err)
+	}
+	return &pb.GetChunkHandleReply{Handle: int64(reply.Handle)}, nil
+}
+
+func (g grpcMaster) GetReplicas(ctx context.Context, args *pb.GetReplicasArg) (*pb.GetReplicasReply, error) {
+	var reply gfs.GetReplicasReply
+	if err := g.m.RPCGetReplicas(gfs.GetReplicasArg{gfs.ChunkHandle(args.Handle), args.GetCred().Value()}, &reply); err != nil {
+		return nil, pb.Status(err)
+	}
+	return &pb.GetReplicasReply{Locations: pb.Strings(reply.Locations), Version: int64(reply.Version), Token: reply.Token}, nil
+}
+
+func (g grpcMaster) GetPrimaryAndSecondaries(ctx context.Context, args *pb.GetPrimaryAndSecondariesArg) (*pb.GetPrimaryAndSecondariesReply, error) {
+	var reply gfs.GetPrimaryAndSecondariesReply
+	if err := g.m.RPCGetPrimaryAndSecondaries(gfs.GetPrimaryAndSecondariesArg{gfs.ChunkHandle(args.Handle), args.WriterDomain, args.GetCred().Value(), args.Trace}, &reply); err != nil {
+		return nil, pb.Status(err)
+	}
+	return &pb.GetPrimaryAndSecondariesReply{
+		Primary:     string(reply.Primary),
+		Expire:      pb.Timestamp(reply.Expire),
+		Secondaries: pb.Strings(reply.Secondaries),
+		Epoch:       int64(reply.Epoch),
+		Token:       reply.Token,
+	}, nil
+}
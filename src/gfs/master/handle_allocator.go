@@ -0,0 +1,97 @@
+package master
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"gfs"
+)
+
+// Chunk handles are never given out twice: a chunkserver keeping a replica
+// of the former chunk of a handle would mix it up with the new one. Master
+// reserves handles on disk a batch at a time before giving them out, so that
+// a crash skips what is left of a batch rather than repeating it, and stores
+// the next handle with its metadata. Handles of replicas reported by
+// chunkservers but not known to master are skipped too, and chunkservers
+// refuse to create a new chunk under a handle they have.
+
+const HandleFileName = "gfs-master.handles"
+
+// createChunkAttempts is how many handles taken on chunkservers in a row a
+// new chunk gives up after.
+const createChunkAttempts = 3
+
+// handleAllocator gives out chunk handles, locked by the chunkManager.
+type handleAllocator struct {
+	filename string          // handles are reserved in, in memory only if ""
+	next     gfs.ChunkHandle // handle given out next
+	reserved gfs.ChunkHandle // handles below are reserved
+}
+
+// newHandleAllocator returns an allocator starting past the handles
+// reserved in filename.
+func newHandleAllocator(filename string) (*handleAllocator, error) {
+	a := &handleAllocator{filename: filename}
+	if filename == "" {
+		return a, nil
+	}
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	a.next, a.reserved = gfs.ChunkHandle(n), gfs.ChunkHandle(n)
+	return a, nil
+}
+
+// alloc returns a new handle, reserving the next batch on disk first if the
+// one reserved is used up.
+func (a *handleAllocator) alloc() (gfs.ChunkHandle, error) {
+	if a.next >= a.reserved {
+		if err := a.reserve(a.next + gfs.HandleReservation); err != nil {
+			return 0, err
+		}
+	}
+	handle := a.next
+	a.next++
+	return handle, nil
+}
+
+// skip makes the handles up to handle, included, never given out.
+func (a *handleAllocator) skip(handle gfs.ChunkHandle) {
+	if handle >= a.next {
+		a.next = handle + 1
+	}
+}
+
+// reserve stores that the handles below limit may be given out, synced
+// before it returns.
+func (a *handleAllocator) reserve(limit gfs.ChunkHandle) error {
+	if a.filename != "" {
+		file, err := os.OpenFile(a.filename+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, FilePerm)
+		if err != nil {
+			return err
+		}
+		_, err = file.WriteString(strconv.FormatInt(int64(limit), 10) + "\n")
+		if err == nil {
+			err = file.Sync()
+		}
+		file.Close()
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(a.filename+".tmp", a.filename); err != nil {
+			return err
+		}
+	}
+	a.reserved = limit
+	return nil
+}
@@ -1,16 +1,27 @@
 package master
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"google.golang.org/grpc"
 	"net"
+	"net/http"
 	"net/rpc"
 	"os"
 	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"gfs"
+	"gfs/auth"
+	"gfs/pb"
 	"gfs/util"
 )
 
@@ -19,12 +30,36 @@ type Master struct {
 	address    gfs.ServerAddress // master server address
 	serverRoot string
 	l          net.Listener
+	tlsConf    util.ServerTLS // of the connections accepted
+	conns      *util.ArraySet // accepted connections, closed on shutdown
+	grpc       *grpc.Server
+	grpcConns  *util.HandListener // the accepted gRPC connections, served by grpc
+	slowLog    *slowLog
+	metrics    *masterMetrics
 	shutdown   chan struct{}
-	dead       bool // set to ture if server is shuntdown
+	checkNow   chan struct{}   // runs a server check in the background right away
+	ctx        context.Context // base context of outgoing rpcs, canceled on shutdown
+	cancel     context.CancelFunc
+	dead       bool  // set to ture if server is shuntdown
+	draining   int32 // set to 1 once Drain stops accepting connections
+
+	emptyDirExpire int64 // time.Duration, accessed atomically
+	trashRetention int64 // time.Duration, accessed atomically
+	rebalanceMoves int64 // chunks moved per rebalancing round at most, accessed atomically
+	rebalancing    int32 // set to 1 while a rebalancing round runs
+
+	mode     int32        // gfs.MasterMode, accessed atomically
+	modeLock sync.Mutex   // held while the mode changes
+	ops      sync.RWMutex // read locked by the operations of clients in flight
+	storing  sync.Mutex   // held while the metadata is stored
 
 	nm  *namespaceManager
 	cm  *chunkManager
 	csm *chunkServerManager
+	rq  *reReplicationQueue
+	th  *throttler
+
+	secret auth.Secret // shared with the chunkservers, tokens are checked if set
 }
 
 const (
@@ -32,23 +67,40 @@ const (
 	FilePerm     = 0755
 )
 
+// features of master reported by RPCBuildInfo
+var features = []string{"placement", "replication", "topology", "rebalance", "decommission", "lease-renewal", "registration", "import", "empty-dir-collection", "throttle", "divergence-check", "topology-api", "truncate", "recursive-ops", "permissions", "auth", "tls", "trash", "quotas", "fsck", "modes", "dump", "copy-concat", "unix-sockets", "deregistration", "heartbeat-commands", "batch", "grpc"}
+
 // NewAndServe starts a master and returns the pointer to it.
 func NewAndServe(address gfs.ServerAddress, serverRoot string) *Master {
 	m := &Master{
 		address:    address,
 		serverRoot: serverRoot,
+		conns:      new(util.ArraySet),
+		slowLog:    newSlowLog(gfs.SlowQueryThreshold, gfs.SlowQueryLogSize),
 		shutdown:   make(chan struct{}),
+		checkNow:   make(chan struct{}, 1),
+		rq:         newReReplicationQueue(gfs.ReReplicationConcurrency),
+
+		emptyDirExpire: int64(gfs.EmptyDirExpire),
+		trashRetention: int64(gfs.TrashRetention),
+		rebalanceMoves: gfs.RebalanceMaxMoves,
 	}
+	m.ctx, m.cancel = context.WithCancel(util.WithCaller(context.Background(), address))
 
 	rpcs := rpc.NewServer()
 	rpcs.Register(m)
-	l, e := net.Listen("tcp", string(m.address))
+	l, e := util.Listen(m.address)
 	if e != nil {
 		log.Fatal("listen error:", e)
 	}
 	m.l = l
+	m.grpc = util.NewGRPCServer()
+	pb.RegisterMasterServer(m.grpc, grpcMaster{m: m})
+	m.grpcConns = util.NewHandListener(l.Addr())
+	go m.grpc.Serve(m.grpcConns)
 
 	m.initMetadata()
+	m.metrics = newMasterMetrics(m)
 
 	// RPC Handler
 	go func() {
@@ -60,12 +112,21 @@ func NewAndServe(address gfs.ServerAddress, serverRoot string) *Master {
 			}
 			conn, err := m.l.Accept()
 			if err == nil {
+				m.conns.Add(conn)
 				go func() {
-					rpcs.ServeConn(conn)
-					conn.Close()
+					tc, isGRPC := util.IsGRPC(m.tlsConf.Wrap(conn))
+					if isGRPC {
+						// drained and closed by grpc
+						m.conns.Delete(conn)
+						m.grpcConns.Hand(tc)
+						return
+					}
+					rpcs.ServeCodec(m.metrics.rpc.Wrap(m.slowLog.wrap(util.NewGobServerCodec(tc), conn.RemoteAddr().String())))
+					tc.Close()
+					m.conns.Delete(conn)
 				}()
 			} else {
-				if !m.dead {
+				if !m.dead && atomic.LoadInt32(&m.draining) == 0 {
 					log.Fatal("master accept error:", err)
 				}
 			}
@@ -78,6 +139,9 @@ func NewAndServe(address gfs.ServerAddress, serverRoot string) *Master {
 	go func() {
 		checkTicker := time.Tick(gfs.ServerCheckInterval)
 		storeTicker := time.Tick(gfs.MasterStoreInterval)
+		emptyDirTicker := time.Tick(gfs.EmptyDirCheckInterval)
+		rebalanceTicker := time.Tick(gfs.RebalanceInterval)
+		trashTicker := time.Tick(gfs.TrashCheckInterval)
 		for {
 			var err error
 			select {
@@ -85,8 +149,24 @@ func NewAndServe(address gfs.ServerAddress, serverRoot string) *Master {
 				return
 			case <-checkTicker:
 				err = m.serverCheck()
+			case <-m.checkNow:
+				err = m.serverCheck()
 			case <-storeTicker:
-				err = m.storeMeta()
+				if m.Mode() != gfs.MasterMaintenance {
+					err = m.storeMeta()
+				}
+			case <-emptyDirTicker:
+				if expire := time.Duration(atomic.LoadInt64(&m.emptyDirExpire)); expire > 0 && m.Mode() == gfs.MasterNormal {
+					m.nm.CollectEmptyDirs(expire)
+				}
+			case <-rebalanceTicker:
+				if m.Mode() != gfs.MasterMaintenance {
+					go m.rebalance()
+				}
+			case <-trashTicker:
+				if m.Mode() == gfs.MasterNormal {
+					m.nm.ReclaimTrash(time.Duration(atomic.LoadInt64(&m.trashRetention)), false, m.fileChunks, m.moveFile)
+				}
 			}
 			if err != nil {
 				log.Error("Background error ", err)
@@ -103,8 +183,15 @@ func NewAndServe(address gfs.ServerAddress, serverRoot string) *Master {
 // InitMetadata initiates meta data
 func (m *Master) initMetadata() {
 	m.nm = newNamespaceManager()
-	m.cm = newChunkManager()
+	m.cm = newChunkManager(&m.secret)
+	handles, err := newHandleAllocator(path.Join(m.serverRoot, HandleFileName))
+	if err != nil {
+		log.Errorf("cannot read the chunk handles reserved, given out past those known: %v", err)
+		handles = &handleAllocator{filename: path.Join(m.serverRoot, HandleFileName)}
+	}
+	m.cm.handles = handles
 	m.csm = newChunkServerManager()
+	m.th = newThrottler()
 	m.loadMeta()
 	return
 }
@@ -112,6 +199,8 @@ func (m *Master) initMetadata() {
 type PersistentBlock struct {
 	NamespaceTree []serialTreeNode
 	ChunkInfo     []serialChunkInfo
+	Throttles     []gfs.ThrottlePolicy
+	NextHandle    gfs.ChunkHandle // of the chunks, see handle_allocator.go
 }
 
 // loadMeta loads metadata from disk
@@ -132,12 +221,20 @@ func (m *Master) loadMeta() error {
 
 	m.nm.Deserialize(meta.NamespaceTree)
 	m.cm.Deserialize(meta.ChunkInfo)
+	if meta.NextHandle > 0 {
+		m.cm.SkipHandles(meta.NextHandle - 1)
+	}
+	for _, v := range meta.Throttles {
+		m.th.Set(v)
+	}
 
 	return nil
 }
 
 // storeMeta stores metadata to disk
 func (m *Master) storeMeta() error {
+	m.storing.Lock()
+	defer m.storing.Unlock()
 	filename := path.Join(m.serverRoot, MetaFileName)
 	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, FilePerm)
 	if err != nil {
@@ -149,6 +246,8 @@ func (m *Master) storeMeta() error {
 
 	meta.NamespaceTree = m.nm.Serialize()
 	meta.ChunkInfo = m.cm.Serialize()
+	meta.Throttles = m.th.List()
+	meta.NextHandle = m.cm.NextHandle()
 
 	log.Infof("Master : store metadata")
 	enc := gob.NewEncoder(file)
@@ -162,7 +261,12 @@ func (m *Master) Shutdown() {
 		log.Warning(m.address, " Shutdown")
 		m.dead = true
 		close(m.shutdown)
+		m.cancel()
 		m.l.Close()
+		m.grpc.Stop()
+		for _, v := range m.conns.GetAllAndClear() {
+			v.(net.Conn).Close()
+		}
 	}
 
 	err := m.storeMeta()
@@ -171,61 +275,236 @@ func (m *Master) Shutdown() {
 	}
 }
 
-// serverCheck checks all chunkserver according to last heartbeat time
-// then removes all the information of the disconnnected servers
-func (m *Master) serverCheck() error {
-	// detect dead servers
-	addrs := m.csm.DetectDeadServers()
-	for _, v := range addrs {
-		log.Warningf("remove server %v", v)
-		handles, err := m.csm.RemoveServer(v)
-		if err != nil {
-			return err
+// Drain shuts master down gracefully: it stops accepting connections and
+// reading rpcs from the accepted ones, waits up to timeout for the rpcs in
+// flight to be answered, then shuts down, storing its metadata.
+func (m *Master) Drain(timeout time.Duration) {
+	if m.dead || !atomic.CompareAndSwapInt32(&m.draining, 0, 1) {
+		return
+	}
+	log.Infof("%v draining", m.address)
+	m.l.Close()
+	grpcStopped := make(chan bool, 1)
+	go func() { grpcStopped <- util.StopGRPC(m.grpc, timeout) }()
+	if !util.DrainConns(m.conns, timeout) || !<-grpcStopped {
+		log.Warningf("%v rpcs still in flight after %v, shutting down anyway", m.address, timeout)
+	}
+	m.Shutdown()
+}
+
+// removeServer removes a dead server and its replicas, for reason (e.g.
+// timeout, reported), and queues its chunks for re-replication from the
+// replicas left. The chunks left without replicas are reported in the error.
+func (m *Master) removeServer(addr gfs.ServerAddress, reason string) error {
+	handles, err := m.csm.RemoveServer(addr)
+	if err != nil {
+		return err
+	}
+	err = m.cm.RemoveChunks(handles, addr)
+	queued := m.queueReReplication(handles)
+	m.metrics.removedServers.Inc(reason)
+	m.metrics.removedReplicas.Add(float64(len(handles)))
+	log.Warningf("removed server %v (%v): %v replicas lost, %v chunks queued for re-replication", addr, reason, len(handles), queued)
+	return err
+}
+
+// queueReReplication queues chunks for re-replication by the number of their
+// live replicas, and returns how many were queued. Chunks without replicas
+// are left out, there is nothing to copy.
+func (m *Master) queueReReplication(handles []gfs.ChunkHandle) int {
+	queued := 0
+	for _, h := range handles {
+		m.cm.RLock()
+		ck, ok := m.cm.chunk[h]
+		m.cm.RUnlock()
+		if !ok {
+			continue
 		}
-		err = m.cm.RemoveChunks(handles, v)
-		if err != nil {
-			return err
+		ck.RLock()
+		replicas := len(ck.location)
+		ck.RUnlock()
+		if replicas > 0 {
+			m.rq.add(h, replicas)
+			queued++
+		}
+	}
+	return queued
+}
+
+// serverCheck removes the chunkservers whose heartbeats timed out, queues
+// the chunks that need replicas and starts their re-replication.
+func (m *Master) serverCheck() error {
+	var errs []string
+	for _, addr := range m.csm.DetectDeadServers() {
+		if err := m.removeServer(addr, "timeout"); err != nil {
+			errs = append(errs, err.Error()) // the other servers are removed still
 		}
 	}
 
-	// add replicas for need request
+	// queue chunks that need replicas, by the number of live ones
 	handles := m.cm.GetNeedlist()
 	if handles != nil {
 		log.Info("Master Need ", handles)
-		m.cm.RLock()
-		for i := 0; i < len(handles); i++ {
-			ck := m.cm.chunk[handles[i]]
-
-			if ck.expire.Before(time.Now()) {
-				ck.Lock() // don't grant lease during copy
-				err := m.reReplication(handles[i])
-				log.Info(err)
-				ck.Unlock()
+	}
+	m.queueReReplication(handles)
+	m.queueDraining()
+	if n := m.rq.len(); n > 0 {
+		util.Subsystem(util.LogReplication).Debugf("%v chunks waiting for re-replication", n)
+	}
+	m.scheduleReReplication()
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// scheduleReReplication starts the most urgent re-replications the concurrency cap allows.
+func (m *Master) scheduleReReplication() {
+	select {
+	case <-m.shutdown:
+		return
+	default:
+	}
+	for _, h := range m.rq.pop(time.Now()) {
+		go func(handle gfs.ChunkHandle) {
+			retryAt, err := m.runReReplication(handle)
+			if err != nil {
+				util.Subsystem(util.LogReplication).Warningf("re-replication of chunk %v: %v", handle, err)
+				m.metrics.reReplications.Inc("error")
+			} else if retryAt.IsZero() {
+				m.metrics.reReplications.Inc("success")
 			}
+			m.rq.done(handle, err, retryAt)
+			m.scheduleReReplication()
+		}(h)
+	}
+}
+
+// runReReplication adds a replica to a chunk if it has fewer than the
+// replication of its file, repairing a stale one first, or drops one if it
+// has more. If the replication of the file cannot be read, the chunk gets
+// gfs.MinimumNumReplicas at least and none is dropped. If the chunk is
+// leased, or still not at the replication, it returns when to retry instead.
+func (m *Master) runReReplication(handle gfs.ChunkHandle) (retryAt time.Time, err error) {
+	// don't hold cm while locking ck, GetLeaseHolder locks them in the reverse order
+	m.cm.RLock()
+	ck, ok := m.cm.chunk[handle]
+	m.cm.RUnlock()
+	if !ok {
+		return
+	}
+
+	// namespace is locked before chunks elsewhere
+	ck.RLock()
+	p := ck.path
+	ck.RUnlock()
+	constraints, target, e := m.nm.Replication(p)
+	known := e == nil
+	if !known {
+		util.Subsystem(util.LogReplication).Infof("no replication of chunk %v of %v: %v", handle, p, e)
+		target = gfs.MinimumNumReplicas
+	}
+
+	ck.Lock() // don't grant lease during copy
+	defer ck.Unlock()
+	live := m.csm.Live(ck.location) // replicas on draining servers are not counted
+	if len(live) == target && len(ck.stale) == 0 {
+		return
+	}
+	if ck.expire.After(time.Now()) {
+		return ck.expire, nil
+	}
+	repair := target
+	if !known {
+		repair = len(live) + len(ck.stale) // repaired rather than collected
+	}
+	if live = m.repairReplicas(handle, ck, live, repair); len(live) == target {
+		return
+	}
+
+	if len(live) > target {
+		if !known {
+			return // the excess ones may be of the replication of the file
 		}
-		m.cm.RUnlock()
+		m.dropReplica(handle, ck, live)
+	} else if err = m.reReplication(handle, constraints); err != nil {
+		if len(live) >= gfs.MinimumNumReplicas {
+			util.Subsystem(util.LogReplication).Infof("cannot add replica of chunk %v beyond %v: %v", handle, len(live), err)
+			err = nil // enough to be safe, no more servers to replicate to
+		}
+		return
 	}
-	return nil
+	if len(m.csm.Live(ck.location)) != target {
+		return time.Now(), nil
+	}
+	return
+}
+
+// repairReplicas brings stale replicas of a chunk up to date, shipping only
+// the ranges they lack if the source can, while it has fewer live replicas
+// than target, and collects the others as garbage. It returns the live
+// replicas then. ck should be locked and not leased.
+func (m *Master) repairReplicas(handle gfs.ChunkHandle, ck *chunkInfo, live []gfs.ServerAddress, target int) []gfs.ServerAddress {
+	if len(live) == 0 {
+		return live // nothing to repair from, the stale ones are kept
+	}
+	stale := ck.stale
+	ck.stale = nil
+	for _, addr := range stale {
+		if containsServer(ck.location, addr) {
+			continue // registered again since
+		}
+		if len(live) >= target || len(m.csm.Live([]gfs.ServerAddress{addr})) == 0 {
+			m.csm.AddGarbage(addr, handle)
+			continue
+		}
+		var sr gfs.SendCopyReply
+		if err := util.CallTimeout(m.ctx, gfs.CopyTimeout, live[0], "ChunkServer.RPCSendCopy", gfs.SendCopyArg{handle, addr, true, m.secret.ServerToken()}, &sr); err != nil {
+			util.Subsystem(util.LogReplication).Warningf("cannot repair stale replica of chunk %v on %v: %v", handle, addr, err)
+			m.csm.AddGarbage(addr, handle)
+			continue
+		}
+		util.Subsystem(util.LogReplication).Infof("repaired stale replica of chunk %v on %v (delta %v)", handle, addr, sr.Delta)
+		m.cm.RegisterReplica(handle, addr, false)
+		m.csm.AddChunk([]gfs.ServerAddress{addr}, handle)
+		live = m.csm.Live(ck.location)
+	}
+	return live
+}
+
+// dropReplica removes an excess replica of a chunk among live, the locations
+// not on draining servers. ck should be locked and not leased.
+func (m *Master) dropReplica(handle gfs.ChunkHandle, ck *chunkInfo, live []gfs.ServerAddress) {
+	addr := m.csm.ChooseExcess(live)
+	var newlist []gfs.ServerAddress
+	for _, v := range ck.location {
+		if v != addr {
+			newlist = append(newlist, v)
+		}
+	}
+	ck.location = newlist // a new slice, GetReplicas returns the old one without lock
+	m.csm.DropChunk(handle, addr)
+	util.Subsystem(util.LogReplication).Infof("drop excess replica of chunk %v on %v", handle, addr)
 }
 
 // reReplication performs re-replication, ck should be locked in top caller
 // new lease will not be granted during copy
-func (m *Master) reReplication(handle gfs.ChunkHandle) error {
+func (m *Master) reReplication(handle gfs.ChunkHandle, constraints []gfs.PlacementConstraint) error {
 	// chunk are locked, so master will not grant lease during copy time
-	from, to, err := m.csm.ChooseReReplication(handle)
+	from, to, err := m.csm.ChooseReReplication(handle, constraints)
 	if err != nil {
 		return err
 	}
 	log.Warningf("allocate new chunk %v from %v to %v", handle, from, to)
 
 	var cr gfs.CreateChunkReply
-	err = util.Call(to, "ChunkServer.RPCCreateChunk", gfs.CreateChunkArg{handle}, &cr)
+	err = util.Call(m.ctx, to, "ChunkServer.RPCCreateChunk", gfs.CreateChunkArg{Handle: handle, Token: m.secret.ServerToken()}, &cr) // the chunk size comes with the copy
 	if err != nil {
 		return err
 	}
 
 	var sr gfs.SendCopyReply
-	err = util.Call(from, "ChunkServer.RPCSendCopy", gfs.SendCopyArg{handle, to}, &sr)
+	err = util.CallTimeout(m.ctx, gfs.CopyTimeout, from, "ChunkServer.RPCSendCopy", gfs.SendCopyArg{handle, to, false, m.secret.ServerToken()}, &sr)
 	if err != nil {
 		return err
 	}
@@ -235,40 +514,191 @@ func (m *Master) reReplication(handle gfs.ChunkHandle) error {
 	return nil
 }
 
-// RPCHeartbeat is called by chunkserver to let the master know that a chunkserver is alive
+// RPCRegisterServer is called by a chunkserver when it starts, or when its
+// heartbeat is rejected because master does not know it, e.g. after master
+// restarts. Replicas of the inventory with the current version are registered,
+// the others are abandoned as stale by the server with its next heartbeat.
+// A server registering again is removed first, as if it had died.
+func (m *Master) RPCRegisterServer(args gfs.RegisterServerArg, reply *gfs.RegisterServerReply) error {
+	if err := m.checkServer(args.Token); err != nil {
+		return err
+	}
+	if m.csm.Registered(args.Address) {
+		if err := m.removeServer(args.Address, "registered again"); err != nil {
+			return err
+		}
+	}
+	m.csm.Register(args)
+	m.rq.resetBackoff() // a new place for replicas
+	log.Infof("register %v, version %v, capacity %v, %v chunks", args.Address, args.Version, args.Capacity, len(args.Chunks))
+
+	var unknown []gfs.ChunkHandle
+	for _, v := range args.Chunks {
+		m.cm.RLock()
+		ck, ok := m.cm.chunk[v.Handle]
+		m.cm.RUnlock()
+		if !ok {
+			unknown = append(unknown, v.Handle)
+			continue
+		}
+		ck.RLock()
+		version := ck.version
+		ck.RUnlock()
+
+		if v.Version == version {
+			log.Infof("Master receive chunk %v from %v", v.Handle, args.Address)
+			m.cm.RegisterReplica(v.Handle, args.Address, true)
+			m.csm.AddChunk([]gfs.ServerAddress{args.Address}, v.Handle)
+		} else {
+			log.Infof("Master discard %v", v.Handle)
+			m.csm.RequireVersion(args.Address, v.Handle, version)
+		}
+	}
+	m.cm.SkipHandles(unknown...) // never given to a new chunk
+	return nil
+}
+
+// RPCHeartbeat is called by chunkserver to let the master know that a chunkserver is alive.
+// Heartbeats of servers not registered are rejected with gfs.NotRegistered.
 func (m *Master) RPCHeartbeat(args gfs.HeartbeatArg, reply *gfs.HeartbeatReply) error {
-	isFirst := m.csm.Heartbeat(args.Address, reply)
+	if err := m.checkServer(args.Token); err != nil {
+		return err
+	}
+	if err := m.csm.Heartbeat(args, reply); err != nil {
+		return err
+	}
 
-	for _, handle := range args.LeaseExtensions {
-		continue
-		// ATTENTION !! dead lock
-		m.cm.ExtendLease(handle, args.Address)
+	if len(args.LeaseExtensions) > 0 || len(args.LeaseReleases) > 0 {
+		// chunks may be locked for a while, e.g. by a copy, don't delay the heartbeat
+		go m.renewLeases(args.Address, args.LeaseExtensions, args.LeaseReleases)
+	}
+	if len(args.AbandondedChunks) > 0 {
+		go m.loseReplicas(args.Address, args.AbandondedChunks)
+	}
+	if len(args.ChunkLengths) > 0 {
+		// in order of the heartbeats, a report before a truncation is overridden by the next
+		files := make(map[gfs.Path]bool)
+		for _, l := range args.ChunkLengths {
+			if path, err := m.cm.SetLength(l.Handle, l.Length); err == nil {
+				files[path] = true
+			}
+		}
+		go m.modified(files)
 	}
+	return nil
+}
 
-	if isFirst { // if is first heartbeat, let chunkserver report itself
-		var r gfs.ReportSelfReply
-		err := util.Call(args.Address, "ChunkServer.RPCReportSelf", gfs.ReportSelfArg{}, &r)
-		if err != nil {
-			return err
+// modified records a change of the data of files, mutated by a primary.
+func (m *Master) modified(files map[gfs.Path]bool) {
+	now := time.Now()
+	for p := range files {
+		if err := m.nm.Modified(p, now); err != nil {
+			log.Debugf("cannot record the modification of %v: %v", p, err)
 		}
+	}
+}
 
-		for _, v := range r.Chunks {
-			m.cm.RLock()
-			ck, ok := m.cm.chunk[v.Handle]
-			if !ok {
-				continue
-			}
-			version := ck.version
-			m.cm.RUnlock()
+// loseReplicas removes the replicas a server lost, e.g. on a failed disk,
+// they are re-replicated.
+func (m *Master) loseReplicas(addr gfs.ServerAddress, handles []gfs.ChunkHandle) {
+	log.Warningf("%v lost %v replicas", addr, len(handles))
+	m.csm.LoseChunks(addr, handles)
+	if err := m.cm.RemoveChunks(handles, addr); err != nil {
+		log.Warning(err)
+	}
+	select {
+	case m.checkNow <- struct{}{}:
+	default: // already pending
+	}
+}
 
-			if v.Version == version {
-				log.Infof("Master receive chunk %v from %v", v.Handle, args.Address)
-				m.cm.RegisterReplica(v.Handle, args.Address, true)
-				m.csm.AddChunk([]gfs.ServerAddress{args.Address}, v.Handle)
-			} else {
-				log.Infof("Master discard %v", v.Handle)
+// revokeLeases revokes the leases held by a server as primary, and returns
+// how many were revoked. The chunks get new leases on other replicas.
+func (m *Master) revokeLeases(addr gfs.ServerAddress) int {
+	handles := m.csm.Leases(addr)
+	var revoked int32
+	var wg sync.WaitGroup
+	wg.Add(len(handles))
+	for _, h := range handles {
+		go func(handle gfs.ChunkHandle) {
+			defer wg.Done()
+			holder, expire, err := m.cm.RevokeLease(m.ctx, handle)
+			if err != nil {
+				// it stands on master, the holder drops it with its next heartbeat
+				log.Warningf("cannot revoke lease of chunk %v from %v, it stands until it expires: %v", handle, holder, err)
+				m.csm.AddRevocation(holder, handle, expire)
+				return
+			}
+			if holder != "" {
+				m.csm.SetLease(holder, handle, time.Time{})
+				atomic.AddInt32(&revoked, 1)
 			}
+		}(h)
+	}
+	wg.Wait()
+	if len(handles) > 0 {
+		log.Infof("revoked %v of %v leases held by %v", revoked, len(handles), addr)
+	}
+	return int(revoked)
+}
+
+// renewLeases extends the leases a primary used recently and releases the idle ones.
+func (m *Master) renewLeases(primary gfs.ServerAddress, extend, release []gfs.ChunkHandle) {
+	for _, handle := range extend {
+		expire, err := m.cm.ExtendLease(handle, primary)
+		if err != nil {
+			log.Debugf("extend lease of chunk %v for %v: %v", handle, primary, err)
+			continue
+		}
+		m.csm.SetLease(primary, handle, expire)
+	}
+	for _, handle := range release {
+		if err := m.cm.ReleaseLease(handle, primary); err != nil {
+			log.Debugf("release lease of chunk %v for %v: %v", handle, primary, err)
 		}
+		m.csm.SetLease(primary, handle, time.Time{})
+	}
+}
+
+// RPCReportDeadServer is called by a primary that repeatedly fails to reach
+// a secondary. The server is removed right away if master has not heard from
+// it for gfs.SuspectHeartbeatAge either, a partition between the two servers
+// alone is left to heartbeats. Chunks of a removed server are re-replicated.
+func (m *Master) RPCReportDeadServer(args gfs.ReportDeadServerArg, reply *gfs.ReportDeadServerReply) error {
+	if err := m.checkServer(args.Token); err != nil {
+		return err
+	}
+	if !m.csm.Suspect(args.Address, gfs.SuspectHeartbeatAge) {
+		log.Infof("%v reports %v dead, but it is alive", args.Reporter, args.Address)
+		return nil
+	}
+	log.Warningf("%v reports %v dead", args.Reporter, args.Address)
+	if err := m.removeServer(args.Address, "reported"); err != nil {
+		return err
+	}
+	reply.Removed = true
+	select {
+	case m.checkNow <- struct{}{}:
+	default: // already pending
+	}
+	return nil
+}
+
+// RPCDeregisterServer is called by a chunkserver shutting down gracefully.
+// It is removed right away rather than once its heartbeats time out: its
+// leases expire and its chunks are re-replicated. It registers again when
+// it restarts.
+func (m *Master) RPCDeregisterServer(args gfs.DeregisterServerArg, reply *gfs.DeregisterServerReply) error {
+	if err := m.checkServer(args.Token); err != nil {
+		return err
+	}
+	util.Subsystem(util.LogHeartbeat).Infof("%v deregisters", args.Address)
+	if err := m.removeServer(args.Address, "deregistered"); err != nil {
+		return err
+	}
+	select {
+	case m.checkNow <- struct{}{}:
+	default: // already pending
 	}
 	return nil
 }
@@ -276,129 +706,1135 @@ func (m *Master) RPCHeartbeat(args gfs.HeartbeatArg, reply *gfs.HeartbeatReply)
 // RPCGetPrimaryAndSecondaries returns lease holder and secondaries of a chunk.
 // If no one holds the lease currently, grant one.
 // Master will communicate with all replicas holder to check version, if stale replica is detected, add it to garbage collection
-func (m *Master) RPCGetPrimaryAndSecondaries(args gfs.GetPrimaryAndSecondariesArg, reply *gfs.GetPrimaryAndSecondariesReply) error {
-	lease, staleServers, err := m.cm.GetLeaseHolder(args.Handle)
+func (m *Master) RPCGetPrimaryAndSecondaries(args gfs.GetPrimaryAndSecondariesArg, reply *gfs.GetPrimaryAndSecondariesReply) (err error) {
+	end := util.StartSpan(args.Trace, m.address, "Master.RPCGetPrimaryAndSecondaries")
+	defer func() { end(err) }()
+	done, err := m.admit(true)
 	if err != nil {
 		return err
 	}
-
-	for _, v := range staleServers {
-		m.csm.AddGarbage(v, args.Handle)
+	defer done()
+	token, err := m.chunkToken(args.Handle, args.Cred, true)
+	if err != nil {
+		return err
+	}
+	if p, err := m.cm.ChunkPath(args.Handle); err == nil {
+		if err := m.nm.CheckWriteQuota(p, m.fileSize); err != nil {
+			return err
+		}
+	}
+	m.rq.boost(args.Handle)
+	lease, err := m.leaseHolder(args.Handle, args.WriterDomain)
+	if err != nil {
+		return err
 	}
 
 	reply.Primary = lease.Primary
 	reply.Expire = lease.Expire
 	reply.Secondaries = lease.Secondaries
+	reply.Epoch = lease.Epoch
+	reply.Token = token
 	return nil
 }
 
-// RPCExtendLease extends the lease of chunk if the lessee is nobody or requester.
+// leaseHolder returns the lease of a chunk, granting one if there is none.
+// The stale replicas found are queued for re-replication, which repairs them
+// or collects them as garbage once the lease expires, and abandoned by their
+// servers with the next heartbeats meanwhile.
+func (m *Master) leaseHolder(handle gfs.ChunkHandle, domain string) (*gfs.Lease, error) {
+	choose := func(candidates []gfs.ServerAddress, lengths map[gfs.ServerAddress]gfs.Offset, previous gfs.ServerAddress, domain string, expire time.Time) gfs.ServerAddress {
+		return m.csm.ChoosePrimary(handle, candidates, lengths, previous, domain, expire)
+	}
+	lease, staleServers, err := m.cm.GetLeaseHolder(m.ctx, handle, domain, choose, m.th.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(staleServers) > 0 {
+		m.cm.AddStale(handle, staleServers)
+		for _, addr := range staleServers {
+			m.csm.AddStale(addr, handle)
+		}
+		m.rq.add(handle, len(lease.Secondaries)+1)
+	}
+	return lease, nil
+}
+
+// RPCExtendLease extends the lease of chunk if the requester holds it.
 func (m *Master) RPCExtendLease(args gfs.ExtendLeaseArg, reply *gfs.ExtendLeaseReply) error {
-	//t, err := m.cm.ExtendLease(args.Handle, args.Address)
-	//if err != nil { return err }
-	//reply.Expire = *t
+	if err := m.checkServer(args.Token); err != nil {
+		return err
+	}
+	if err := m.checkMode(true); err != nil {
+		return err
+	}
+	expire, err := m.cm.ExtendLease(args.Handle, args.Address)
+	if err != nil {
+		return err
+	}
+	m.csm.SetLease(args.Address, args.Handle, expire)
+	reply.Expire = expire
 	return nil
 }
 
 // RPCGetReplicas is called by client to find all chunkserver that holds the chunk.
 func (m *Master) RPCGetReplicas(args gfs.GetReplicasArg, reply *gfs.GetReplicasReply) error {
-	servers, err := m.cm.GetReplicas(args.Handle)
+	done, err := m.admit(false)
+	if err != nil {
+		return err
+	}
+	defer done()
+	token, err := m.chunkToken(args.Handle, args.Cred, false)
+	if err != nil {
+		return err
+	}
+	m.rq.boost(args.Handle)
+	servers, version, err := m.cm.GetReplicas(args.Handle)
 	if err != nil {
 		return err
 	}
+	reply.Version = version
+	reply.Token = token
 	for _, v := range servers {
 		reply.Locations = append(reply.Locations, v)
 	}
 	return nil
 }
 
+// RPCReportDivergence is called by client when a replica it read disagrees
+// with master or with another replica. Master checks the replicas itself, by
+// their data if that is what the replica is reported for, the divergent ones
+// are dropped as garbage and the chunk is re-replicated.
+func (m *Master) RPCReportDivergence(args gfs.ReportDivergenceArg, reply *gfs.ReportDivergenceReply) error {
+	done, err := m.admit(false)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := m.checkChunkToken(args.Token, args.Handle); err != nil {
+		return err
+	}
+	m.metrics.divergences.Inc(args.Divergence.String())
+	log.Warningf("replica %v of chunk %v reported divergent by %v (version %v, length %v)", args.Location, args.Handle, args.Divergence, args.Version, args.Length)
+	check := m.cm.CheckReplicas
+	if args.Divergence == gfs.DivergentData {
+		check = m.cm.CheckReplicaData
+	}
+	divergent, err := check(m.ctx, args.Handle, m.masterToken(args.Handle))
+	if err != nil {
+		return err
+	}
+	for _, addr := range divergent {
+		m.loseReplicas(addr, []gfs.ChunkHandle{args.Handle})
+		m.csm.AddGarbage(addr, args.Handle)
+	}
+	reply.Dropped = divergent
+	return nil
+}
+
+// RPCReportBadReplica is called by client when a replica fails the checksums
+// of its data on a read. Master reads the data of the replica again itself,
+// and drops it as garbage if it fails too, unless it is the last replica of
+// the chunk, so that the chunk is re-replicated from a good one.
+func (m *Master) RPCReportBadReplica(args gfs.ReportBadReplicaArg, reply *gfs.ReportBadReplicaReply) error {
+	done, err := m.admit(false)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := m.checkChunkToken(args.Token, args.Handle); err != nil {
+		return err
+	}
+	log.Warningf("replica %v of chunk %v reported bad: %v", args.Location, args.Handle, args.Reason)
+	locations, _, err := m.cm.GetReplicas(args.Handle)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, addr := range locations {
+		found = found || addr == args.Location
+	}
+	if !found {
+		return nil // dropped already
+	}
+
+	var r gfs.ChunkHashReply
+	err = util.Call(m.ctx, args.Location, "ChunkServer.RPCChunkHash", gfs.ChunkHashArg{args.Handle, m.masterToken(args.Handle), "", true}, &r)
+	if !errors.Is(err, gfs.ChecksumMismatch) && !errors.Is(err, gfs.PhysicalEOF) {
+		m.metrics.badReplicas.Inc("unconfirmed")
+		return nil
+	}
+	m.metrics.badReplicas.Inc("confirmed")
+	if len(locations) == 1 {
+		log.Warningf("replica %v of chunk %v is bad, but the last one, kept: %v", args.Location, args.Handle, err)
+		return nil
+	}
+	m.loseReplicas(args.Location, []gfs.ChunkHandle{args.Handle})
+	m.csm.AddGarbage(args.Location, args.Handle)
+	reply.Dropped = true
+	return nil
+}
+
 // RPCCreateFile is called by client to create a new file
-func (m *Master) RPCCreateFile(args gfs.CreateFileArg, reply *gfs.CreateFileReply) error {
-	err := m.nm.Create(args.Path)
+func (m *Master) RPCCreateFile(args gfs.CreateFileArg, reply *gfs.CreateFileReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	if err := m.throttleCreate(args.Path); err != nil {
+		return err
+	}
+	err = m.nm.Create(args.Path, args.ChunkSize, args.Cred, &wait)
 	return err
 }
 
-// RPCDelete is called by client to delete a file
-func (m *Master) RPCDeleteFile(args gfs.DeleteFileArg, reply *gfs.DeleteFileReply) error {
-	err := m.nm.Delete(args.Path)
+// RPCDelete is called by client to delete a file, or a directory with its subtree if args.Recursive is set.
+// The path is moved to the trash unless trash retention is 0.
+func (m *Master) RPCDeleteFile(args gfs.DeleteFileArg, reply *gfs.DeleteFileReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	trash := atomic.LoadInt64(&m.trashRetention) > 0
+	reply.Files, reply.Dirs, reply.Trash, err = m.nm.Delete(args.Path, args.Recursive, trash, args.Cred, m.moveFile, &wait)
 	return err
 }
 
-// RPCRename is called by client to rename a file
-func (m *Master) RPCRenameFile(args gfs.RenameFileArg, reply *gfs.RenameFileReply) error {
-	err := m.nm.Rename(args.Source, args.Target)
-	return err
+// RPCRenameFile is called by client to move a file or a directory to another path
+func (m *Master) RPCRenameFile(args gfs.RenameFileArg, reply *gfs.RenameFileReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	return m.nm.Rename(args.Source, args.Target, args.Cred, m.moveFile, m.fileSize, &wait)
 }
 
 // RPCMkdir is called by client to make a new directory
-func (m *Master) RPCMkdir(args gfs.MkdirArg, reply *gfs.MkdirReply) error {
-	err := m.nm.Mkdir(args.Path)
+func (m *Master) RPCMkdir(args gfs.MkdirArg, reply *gfs.MkdirReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	if err := m.throttleCreate(args.Path); err != nil {
+		return err
+	}
+	err = m.nm.Mkdir(args.Path, args.Cred, &wait)
 	return err
 }
 
-// RPCList is called by client to list all files in specific directory
-func (m *Master) RPCList(args gfs.ListArg, reply *gfs.ListReply) error {
-	var err error
-	reply.Files, err = m.nm.List(args.Path)
+// RPCList is called by client to list files in specific directory, a page at a time
+func (m *Master) RPCList(args gfs.ListArg, reply *gfs.ListReply) (err error) {
+	done, err := m.admit(false)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	limit := args.Limit
+	if limit <= 0 || limit > gfs.ListPageSize {
+		limit = gfs.ListPageSize
+	}
+	reply.Files, reply.Next, err = m.nm.List(args.Path, args.After, limit, args.Prefix, args.Pattern, args.Cred, m.fileSize, &wait)
 	return err
 }
 
-// RPCGetFileInfo is called by client to get file information
-func (m *Master) RPCGetFileInfo(args gfs.GetFileInfoArg, reply *gfs.GetFileInfoReply) error {
-	ps, cwd, err := m.nm.lockParents(args.Path, false)
-	defer m.nm.unlockParents(ps)
+// RPCWalk is called by client to list the subtree of a directory, a page at a time
+func (m *Master) RPCWalk(args gfs.WalkArg, reply *gfs.WalkReply) (err error) {
+	done, err := m.admit(false)
 	if err != nil {
 		return err
 	}
-
-	file, ok := cwd.children[ps[len(ps)-1]]
-	if !ok {
-		return fmt.Errorf("File %v does not exist", args.Path)
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
 	}
-	file.Lock()
-	defer file.Unlock()
-
-	reply.IsDir = file.isDir
-	reply.Length = file.length
-	reply.Chunks = file.chunks
-	return nil
+	limit := args.Limit
+	if limit <= 0 || limit > gfs.WalkPageSize {
+		limit = gfs.WalkPageSize
+	}
+	return m.nm.Walk(args.Path, args.After, args.Cred, &wait, func(p gfs.Path, node *nsTree) bool {
+		if len(reply.Entries) == limit {
+			reply.Next = reply.Entries[limit-1].Path
+			return false
+		}
+		reply.Entries = append(reply.Entries, gfs.FileInfo{p, node.isDir, m.fileSize(p, node), node.chunks, node.chunkSize, node.replication(), node.btime, node.mtime, node.ctime, node.owner, node.group, node.mode})
+		return true
+	})
 }
 
-// RPCGetChunkHandle returns the chunk handle of (path, index).
-// If the requested index is bigger than the number of chunks of this path by one, create one.
-func (m *Master) RPCGetChunkHandle(args gfs.GetChunkHandleArg, reply *gfs.GetChunkHandleReply) error {
-	ps, cwd, err := m.nm.lockParents(args.Path, false)
-	defer m.nm.unlockParents(ps)
+// RPCDiskUsage is called by client to sum the files under a directory
+func (m *Master) RPCDiskUsage(args gfs.DiskUsageArg, reply *gfs.DiskUsageReply) (err error) {
+	done, err := m.admit(false)
 	if err != nil {
 		return err
 	}
-
-	// append new chunks
-	file, ok := cwd.children[ps[len(ps)-1]]
-	if !ok {
-		return fmt.Errorf("File %v does not exist", args.Path)
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
 	}
-	file.Lock()
-	defer file.Unlock()
-
-	if int(args.Index) == int(file.chunks) {
-		file.chunks++
-
-		addrs, err := m.csm.ChooseServers(gfs.DefaultNumReplicas)
-		if err != nil {
-			return err
+	u := &reply.Usage
+	return m.nm.Walk(args.Path, "", args.Cred, &wait, func(p gfs.Path, node *nsTree) bool {
+		if node.isDir {
+			u.Dirs++
+			return true
 		}
+		size := m.fileSize(p, node)
+		u.Files++
+		u.Chunks += node.chunks
+		u.Bytes += size
+		u.ReplicatedBytes += size * int64(node.replication())
+		return true
+	})
+}
 
-		reply.Handle, addrs, err = m.cm.CreateChunk(args.Path, addrs)
-		if err != nil {
-			// WARNING
-			log.Warning("[ignored] An ignored error in RPCGetChunkHandle when create ", err, " in create chunk ", reply.Handle)
+// RPCChmod is called by client to set the permission bits of a file or a directory
+func (m *Master) RPCChmod(args gfs.ChmodArg, reply *gfs.ChmodReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	return m.nm.Chmod(args.Path, args.Mode, args.Cred, &wait)
+}
+
+// RPCChown is called by client to set the owner and the group of a file or a directory
+func (m *Master) RPCChown(args gfs.ChownArg, reply *gfs.ChownReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	return m.nm.Chown(args.Path, args.Owner, args.Group, args.Cred, &wait)
+}
+
+// fileSize returns the length of the file node at p, the larger of the one
+// set by clients and the one reported by primaries. node should be locked.
+func (m *Master) fileSize(p gfs.Path, node *nsTree) int64 {
+	if node.isDir {
+		return node.length
+	}
+	if length := m.cm.FileLength(p, node.chunkSize); length > node.length {
+		return length // appended, or written without extending
+	}
+	return node.length
+}
+
+// RPCGetFileInfo is called by client to get file information
+func (m *Master) RPCGetFileInfo(args gfs.GetFileInfoArg, reply *gfs.GetFileInfoReply) (err error) {
+	done, err := m.admit(false)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	return m.getFileInfo(args.Path, args.Cred, reply, &wait)
+}
+
+// getFileInfo fills reply with the information of the file or directory p,
+// for RPCGetFileInfo and RPCBatchGetFileInfo, provided cred may search its
+// parents. cred should be authenticated.
+func (m *Master) getFileInfo(p gfs.Path, cred gfs.Credentials, reply *gfs.GetFileInfoReply, wait *time.Duration) error {
+	ps, cwd, err := m.nm.lockParents(p, false, wait)
+	defer m.nm.unlockParents(ps)
+	if err != nil {
+		return err
+	}
+	if err := m.nm.searchParents(ps, cred); err != nil {
+		return err
+	}
+
+	file, ok := cwd.children[ps[len(ps)-1]]
+	if !ok {
+		return gfs.Error{gfs.PathNotFound, fmt.Sprintf("File %v does not exist", p)}
+	}
+	file.lock(wait)
+	defer file.Unlock()
+
+	reply.IsDir = file.isDir
+	reply.Length = m.fileSize(p, file)
+	reply.Chunks = file.chunks
+	reply.ChunkSize = file.chunkSize
+	reply.Replicas = file.replication()
+	reply.Generation = file.generation
+	reply.CreateTime = file.btime
+	reply.ModTime = file.mtime
+	reply.ChangeTime = file.ctime
+	reply.Owner = file.owner
+	reply.Group = file.group
+	reply.Mode = file.mode
+	return nil
+}
+
+// RPCOpenFile returns the length, chunks and chunk size of a file. If args.Create is set,
+// the file is created if it does not exist, in the same call.
+func (m *Master) RPCOpenFile(args gfs.OpenFileArg, reply *gfs.OpenFileReply) (err error) {
+	done, err := m.admit(args.Create)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	if args.Create {
+		if err := m.throttleCreate(args.Path); err != nil {
+			return err
 		}
+	}
+	reply.Length, reply.Chunks, reply.ChunkSize, reply.Created, err = m.nm.Open(args.Path, args.Create, args.Cred, &wait)
+	return err
+}
+
+// RPCGetChunkHandle returns the chunk handle of (path, index).
+// If the requested index is bigger than the number of chunks of this path by one, create one.
+func (m *Master) RPCGetChunkHandle(args gfs.GetChunkHandleArg, reply *gfs.GetChunkHandleReply) (err error) {
+	done, err := m.admit(args.Write)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	return m.getChunkHandle(args, reply, &wait)
+}
+
+// getChunkHandle fills reply with the handle of a chunk, allocating it if it
+// is the next one, for RPCGetChunkHandle and RPCBatchGetChunkHandle. The
+// credentials of args should be authenticated.
+func (m *Master) getChunkHandle(args gfs.GetChunkHandleArg, reply *gfs.GetChunkHandleReply, wait *time.Duration) error {
+	ps, cwd, err := m.nm.lockParents(args.Path, false, wait)
+	defer m.nm.unlockParents(ps)
+	if err != nil {
+		return err
+	}
+
+	if err := m.nm.searchParents(ps, args.Cred); err != nil {
+		return err
+	}
 
-		m.csm.AddChunk(addrs, reply.Handle)
+	// append new chunks
+	file, ok := cwd.children[ps[len(ps)-1]]
+	if !ok {
+		return gfs.Error{gfs.PathNotFound, fmt.Sprintf("File %v does not exist", args.Path)}
+	}
+	file.lock(wait)
+	defer file.Unlock()
+
+	want := permRead
+	if args.Write || int(args.Index) == int(file.chunks) {
+		want = permWrite
+	}
+	if err := file.checkAccess(args.Path, args.Cred, want); err != nil {
+		return err
+	}
+	if int(args.Index) == int(file.chunks) {
+		if err := m.checkMode(true); err != nil {
+			return err
+		}
+		dir, _ := m.nm.PartionLastName(args.Path)
+		if err := m.nm.checkQuota(dir, 0, 1, m.fileSize, file); err != nil {
+			return err
+		}
+		reply.Handle, err = m.addChunk(args.Path, file)
 	} else {
 		reply.Handle, err = m.cm.GetChunk(args.Path, args.Index)
 	}
 
 	return err
 }
+
+// addChunk creates a new chunk at the end of file, which should be locked.
+func (m *Master) addChunk(path gfs.Path, file *nsTree) (gfs.ChunkHandle, error) {
+	addrs, err := m.csm.ChooseServers(file.replication(), file.placement)
+	if err != nil {
+		return 0, err
+	}
+	handle, addrs, garbage, err := m.cm.CreateChunk(m.ctx, path, gfs.Offset(file.chunkSize), addrs)
+	for h, replicas := range garbage {
+		for _, addr := range replicas {
+			m.csm.AddGarbage(addr, h)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	file.chunks++
+
+	m.csm.AddChunk(addrs, handle)
+	return handle, nil
+}
+
+// RPCExtendFile is called by client after writing beyond the end of a file,
+// and before writing to a chunk beyond the next one. Chunks are allocated to
+// cover args.Length, unwritten ones are holes reading as zeros, and the length
+// of the file is raised to args.Length.
+func (m *Master) RPCExtendFile(args gfs.ExtendFileArg, reply *gfs.ExtendFileReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	ps, cwd, err := m.nm.lockParents(args.Path, false, &wait)
+	defer m.nm.unlockParents(ps)
+	if err != nil {
+		return err
+	}
+	if err := m.nm.searchParents(ps, args.Cred); err != nil {
+		return err
+	}
+
+	file, ok := cwd.children[ps[len(ps)-1]]
+	if !ok {
+		return gfs.Error{gfs.PathNotFound, fmt.Sprintf("File %v does not exist", args.Path)}
+	}
+	file.lock(&wait)
+	defer file.Unlock()
+	if file.isDir {
+		return gfs.Error{gfs.IsDirectory, fmt.Sprintf("path %v is a directory", args.Path)}
+	}
+	if err := file.checkAccess(args.Path, args.Cred, permWrite); err != nil {
+		return err
+	}
+	if args.Generation != 0 && args.Generation != file.generation {
+		return gfs.Error{gfs.GenerationMismatch, fmt.Sprintf("file %v is at generation %v, not %v", args.Path, file.generation, args.Generation)}
+	}
+
+	for file.chunks*file.chunkSize < args.Length {
+		if _, err := m.addChunk(args.Path, file); err != nil {
+			return err
+		}
+	}
+	if args.Length > file.length {
+		file.length = args.Length
+		m.nm.advance(file)
+		file.modified(time.Now())
+	}
+
+	reply.Length = file.length
+	reply.Chunks = file.chunks
+	reply.Generation = file.generation
+	return nil
+}
+
+// RPCTruncate is called by client to shorten a file to args.Length. The last
+// chunk kept is cut through its primary, ordered with the mutations of the
+// chunk, and the chunks past it are released and collected as garbage. A
+// length past the data of the last chunk reads as zeros up to it.
+func (m *Master) RPCTruncate(args gfs.TruncateArg, reply *gfs.TruncateReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	ps, cwd, err := m.nm.lockParents(args.Path, false, &wait)
+	defer m.nm.unlockParents(ps)
+	if err != nil {
+		return err
+	}
+	if err := m.nm.searchParents(ps, args.Cred); err != nil {
+		return err
+	}
+
+	file, ok := cwd.children[ps[len(ps)-1]]
+	if !ok {
+		return gfs.Error{gfs.PathNotFound, fmt.Sprintf("File %v does not exist", args.Path)}
+	}
+	file.lock(&wait)
+	defer file.Unlock()
+	if file.isDir {
+		return gfs.Error{gfs.IsDirectory, fmt.Sprintf("path %v is a directory", args.Path)}
+	}
+	if err := file.checkAccess(args.Path, args.Cred, permWrite); err != nil {
+		return err
+	}
+	if args.Length < 0 || args.Length > file.chunks*file.chunkSize {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("cannot truncate file %v of %v chunks to %v", args.Path, file.chunks, args.Length)}
+	}
+
+	chunks := (args.Length + file.chunkSize - 1) / file.chunkSize
+	if cut := args.Length - (chunks-1)*file.chunkSize; chunks > 0 && cut < file.chunkSize {
+		handle, err := m.cm.GetChunk(args.Path, gfs.ChunkIndex(chunks-1))
+		if err != nil {
+			return err
+		}
+		lease, err := m.leaseHolder(handle, "")
+		if err != nil {
+			return err
+		}
+		arg := gfs.TruncateChunkArg{handle, gfs.Offset(cut), lease.Secondaries, lease.Epoch, m.secret.ServerToken()}
+		if err := util.Call(m.ctx, lease.Primary, "ChunkServer.RPCTruncateChunk", arg, &gfs.TruncateChunkReply{}); err != nil {
+			return err
+		}
+		m.cm.SetLength(handle, gfs.Offset(cut))
+	}
+
+	released := m.cm.ReleaseChunks(args.Path, gfs.ChunkIndex(chunks))
+	for handle, locations := range released {
+		for _, addr := range locations {
+			m.csm.DropChunk(handle, addr)
+		}
+	}
+	log.Infof("truncate %v to %v, %v chunks released", args.Path, args.Length, len(released))
+
+	file.chunks = chunks
+	file.length = args.Length
+	m.nm.advance(file)
+	file.modified(time.Now())
+
+	reply.Chunks = file.chunks
+	reply.Released = len(released)
+	reply.Generation = file.generation
+	return nil
+}
+
+// HTTPHandler returns the handler of the master http endpoints, i.e.
+// /metrics, the /status page and the /topology document in JSON.
+func (m *Master) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.metrics)
+	mux.HandleFunc("/status", m.serveStatus)
+	mux.HandleFunc("/topology", m.serveTopology)
+	return mux
+}
+
+// SetTLSConfig sets the TLS config of the rpc connections accepted, e.g.
+// loaded by util.LoadTLSConfig, nil accepts plaintext. If plaintext is set,
+// connections not beginning a TLS handshake are accepted in plaintext too.
+// The config rpcs are dialed with is set by util.SetTLSConfig.
+func (m *Master) SetTLSConfig(config *tls.Config, plaintext bool) {
+	m.tlsConf.Set(config, plaintext)
+}
+
+// SetSlowQueryThreshold sets the latency above which master rpcs are recorded in the slow query log.
+func (m *Master) SetSlowQueryThreshold(threshold time.Duration) {
+	m.slowLog.setThreshold(threshold)
+}
+
+// RPCGetSlowQueries returns the latest rpcs recorded in the slow query log, newest first.
+func (m *Master) RPCGetSlowQueries(args gfs.GetSlowQueriesArg, reply *gfs.GetSlowQueriesReply) error {
+	if err := m.checkAdmin(args.Cred, "read the slow query log"); err != nil {
+		return err
+	}
+	reply.Queries = m.slowLog.get(args.Limit)
+	return nil
+}
+
+// SetReReplicationConcurrency sets how many re-replications may run at a time.
+func (m *Master) SetReReplicationConcurrency(max int) {
+	m.rq.setMax(max)
+}
+
+// SetRebalanceMaxMoves sets how many chunks a rebalancing round moves at most. 0 pauses rebalancing.
+func (m *Master) SetRebalanceMaxMoves(max int) {
+	atomic.StoreInt64(&m.rebalanceMoves, int64(max))
+}
+
+// SetEmptyDirExpire sets how long a directory stays empty before it is
+// removed in the background. 0 disables the collection.
+func (m *Master) SetEmptyDirExpire(expire time.Duration) {
+	atomic.StoreInt64(&m.emptyDirExpire, int64(expire))
+}
+
+// SetDefaultReplicas sets the target number of replicas of the files created
+// afterwards, gfs.DefaultNumReplicas at first. Files keep the number they
+// were created with until it is set by RPCSetReplication.
+func (m *Master) SetDefaultReplicas(n int) {
+	if n == gfs.DefaultNumReplicas {
+		n = 0
+	}
+	atomic.StoreInt64(&m.nm.replicas, int64(n))
+}
+
+// SetLeaseExpire sets the duration of the leases granted and extended
+// afterwards, gfs.LeaseExpire at first. Chunkservers should be set the same,
+// see ChunkServer.SetLeaseExpire.
+func (m *Master) SetLeaseExpire(expire time.Duration) {
+	atomic.StoreInt64(&m.cm.leaseExpire, int64(expire))
+}
+
+// SetServerTimeout sets how long after its last heartbeat a chunkserver is
+// taken for dead, gfs.ServerTimeout at first.
+func (m *Master) SetServerTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&m.csm.timeout, int64(timeout))
+}
+
+// RPCCollectEmptyDirs removes the unprotected directories that have been empty for at least args.MinAge.
+func (m *Master) RPCCollectEmptyDirs(args gfs.CollectEmptyDirsArg, reply *gfs.CollectEmptyDirsReply) error {
+	done, err := m.admit(!args.DryRun)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := m.checkAdmin(args.Cred, "collect empty directories"); err != nil {
+		return err
+	}
+	if args.DryRun {
+		reply.Removed = m.nm.CollectableDirs(args.MinAge)
+		return nil
+	}
+	reply.Removed = m.nm.CollectEmptyDirs(args.MinAge)
+	return nil
+}
+
+// RPCSetDirProtected protects a directory from empty directory collection, or unprotects it.
+func (m *Master) RPCSetDirProtected(args gfs.SetDirProtectedArg, reply *gfs.SetDirProtectedReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.checkAdmin(args.Cred, "protect directories"); err != nil {
+		return err
+	}
+	return m.nm.SetProtected(args.Path, args.Protected, &wait)
+}
+
+// RPCSetPlacement sets the placement constraints of a file on chunkserver
+// labels. They apply to chunks created or re-replicated afterwards.
+func (m *Master) RPCSetPlacement(args gfs.SetPlacementArg, reply *gfs.SetPlacementReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.checkAdmin(args.Cred, "set the placement of files"); err != nil {
+		return err
+	}
+	return m.nm.SetPlacement(args.Path, args.Constraints, &wait)
+}
+
+// RPCSetReplication sets the number of replicas of a file. Its chunks are
+// re-replicated or have their excess replicas collected in the background.
+func (m *Master) RPCSetReplication(args gfs.SetReplicationArg, reply *gfs.SetReplicationReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.checkAdmin(args.Cred, "set the replication of files"); err != nil {
+		return err
+	}
+	chunks, err := m.nm.SetReplication(args.Path, args.Replicas, &wait)
+	if err != nil {
+		return err
+	}
+
+	for i := int64(0); i < chunks; i++ {
+		handle, err := m.cm.GetChunk(args.Path, gfs.ChunkIndex(i))
+		if err != nil {
+			continue // not allocated yet
+		}
+		replicas, _, err := m.cm.GetReplicas(handle)
+		if err != nil {
+			continue
+		}
+		m.rq.add(handle, len(replicas))
+	}
+	m.scheduleReReplication()
+	return nil
+}
+
+// RPCDecommissionServer marks a chunkserver as draining, or puts it back in
+// service if args.Cancel is set. A draining server gets no new chunks nor
+// leases, the leases it holds are revoked, and its chunks are copied to other
+// servers in the background.
+// It is safe to shut down once RPCDecommissionStatus reports done.
+// Draining is not persisted, it is lost when master restarts.
+func (m *Master) RPCDecommissionServer(args gfs.DecommissionServerArg, reply *gfs.DecommissionServerReply) error {
+	if err := m.checkAdmin(args.Cred, "decommission chunkservers"); err != nil {
+		return err
+	}
+	if args.DryRun {
+		return m.planDecommission(args, reply)
+	}
+	handles, err := m.csm.SetDraining(args.Address, !args.Cancel)
+	if err != nil {
+		return err
+	}
+	log.Infof("decommission %v: draining %v, %v chunks", args.Address, !args.Cancel, len(handles))
+	if !args.Cancel {
+		reply.Leases = m.revokeLeases(args.Address)
+	}
+
+	for _, h := range handles {
+		replicas, _, err := m.cm.GetReplicas(h)
+		if err != nil {
+			continue
+		}
+		m.rq.add(h, len(m.csm.Live(replicas)))
+	}
+	m.scheduleReReplication()
+	reply.Chunks = len(handles)
+	return nil
+}
+
+// planDecommission fills the reply of RPCDecommissionServer without
+// changing anything. Each chunk of the server without enough replicas
+// elsewhere gets the copy re-replication would make now, further copies of
+// a chunk are chosen once it is done.
+func (m *Master) planDecommission(args gfs.DecommissionServerArg, reply *gfs.DecommissionServerReply) error {
+	_, handles, err := m.csm.Draining(args.Address)
+	if err != nil {
+		return err
+	}
+	reply.Chunks = len(handles)
+	if args.Cancel {
+		return nil
+	}
+
+	for _, h := range handles {
+		m.cm.RLock()
+		ck, ok := m.cm.chunk[h]
+		m.cm.RUnlock()
+		if !ok {
+			continue
+		}
+		ck.RLock()
+		p, location := ck.path, ck.location
+		ck.RUnlock()
+		constraints, target, err := m.nm.Replication(p)
+		if err != nil {
+			continue // deleted
+		}
+
+		live := 0
+		for _, v := range m.csm.Live(location) {
+			if v != args.Address {
+				live++
+			}
+		}
+		if live >= target {
+			continue
+		}
+		from, to, err := m.csm.ChooseReReplication(h, constraints)
+		if err != nil {
+			from, to = args.Address, ""
+		}
+		reply.Plan = append(reply.Plan, gfs.ChunkMove{h, from, to})
+	}
+	return nil
+}
+
+// RPCDecommissionStatus returns the progress of decommissioning a chunkserver.
+// It changes nothing, serverCheck queues the chunks still missing replicas.
+func (m *Master) RPCDecommissionStatus(args gfs.DecommissionStatusArg, reply *gfs.DecommissionStatusReply) error {
+	if err := m.checkAdmin(args.Cred, "get the decommission status"); err != nil {
+		return err
+	}
+	var handles []gfs.ChunkHandle
+	var err error
+	reply.Draining, handles, err = m.csm.Draining(args.Address)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range handles {
+		_, missing, ok := m.missingReplicas(h)
+		if !ok {
+			continue // deleted
+		}
+		reply.Chunks++
+		if missing {
+			reply.Remaining++
+		}
+	}
+	reply.Done = reply.Draining && reply.Remaining == 0
+	return nil
+}
+
+// missingReplicas returns the replicas of a chunk not on draining servers,
+// and whether they are fewer than the replication of its file. ok is false
+// if the chunk or its file is deleted.
+func (m *Master) missingReplicas(handle gfs.ChunkHandle) (live int, missing, ok bool) {
+	m.cm.RLock()
+	ck, ok := m.cm.chunk[handle]
+	m.cm.RUnlock()
+	if !ok {
+		return 0, false, false
+	}
+	ck.RLock()
+	p := ck.path
+	ck.RUnlock()
+	_, target, err := m.nm.Replication(p)
+	if err != nil {
+		return 0, false, false
+	}
+	ck.RLock()
+	live = len(m.csm.Live(ck.location))
+	ck.RUnlock()
+	return live, live < target, true
+}
+
+// queueDraining queues the chunks of draining servers still missing replicas
+// elsewhere, those moved there after draining started included.
+func (m *Master) queueDraining() {
+	for _, h := range m.csm.DrainingChunks() {
+		if live, missing, ok := m.missingReplicas(h); ok && missing {
+			m.rq.add(h, live)
+		}
+	}
+}
+
+// RPCImportNamespace creates the files of a manifest, with their missing
+// parents, and allocates their chunks in one step, for migrations where the
+// data is written to the chunks separately. Either all files are created or
+// none. The chunks are allocated before the namespace is locked, and collected
+// as garbage if the files are not created. Metadata is stored once the import
+// is done.
+func (m *Master) RPCImportNamespace(args gfs.ImportNamespaceArg, reply *gfs.ImportNamespaceReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.checkAdmin(args.Cred, "import a namespace"); err != nil {
+		return err
+	}
+
+	if _, err := checkImport(args.Entries); err != nil {
+		return err
+	}
+	handles := make([][]gfs.ChunkHandle, len(args.Entries))
+	var all []gfs.ChunkHandle
+	for i, e := range args.Entries {
+		size := importChunkSize(e)
+		for n := (e.Size + size - 1) / size; n > 0; n-- {
+			handle, err := m.createChunk(size, gfs.DefaultNumReplicas)
+			if err != nil {
+				m.releaseChunks(all)
+				return err
+			}
+			handles[i] = append(handles[i], handle)
+			all = append(all, handle)
+		}
+	}
+
+	dirs, err := m.nm.Import(args.Entries, func(i int, p gfs.Path, file *nsTree) {
+		file.chunks = int64(len(handles[i]))
+		m.cm.AttachChunks(p, handles[i])
+	}, &wait)
+	if err != nil {
+		m.releaseChunks(all)
+		return err
+	}
+	reply.Files, reply.Dirs, reply.Chunks = len(args.Entries), dirs, len(all)
+
+	if err := m.storeMeta(); err != nil {
+		log.Warning("error in store metadata after import: ", err)
+	}
+	return nil
+}
+
+// createChunk creates a chunk of no file, to be attached to one.
+func (m *Master) createChunk(size int64, replicas int) (gfs.ChunkHandle, error) {
+	addrs, err := m.csm.ChooseServers(replicas, nil)
+	if err != nil {
+		return 0, err
+	}
+	handle, addrs, garbage, err := m.cm.CreateChunk(m.ctx, "", gfs.Offset(size), addrs)
+	for h, replicas := range garbage {
+		for _, addr := range replicas {
+			m.csm.AddGarbage(addr, h)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	m.csm.AddChunk(addrs, handle)
+	return handle, nil
+}
+
+// releaseChunks forgets chunks of no file, their replicas are collected as
+// garbage.
+func (m *Master) releaseChunks(handles []gfs.ChunkHandle) {
+	for handle, addrs := range m.cm.ForgetChunks(handles) {
+		for _, addr := range addrs {
+			m.csm.DropChunk(handle, addr)
+		}
+	}
+}
+
+// RPCSetTopology sets the zone and rack of a chunkserver on master,
+// overriding the ones it reports. It applies to replicas placed afterwards.
+func (m *Master) RPCSetTopology(args gfs.SetTopologyArg, reply *gfs.SetTopologyReply) error {
+	if err := m.checkAdmin(args.Cred, "set the topology of chunkservers"); err != nil {
+		return err
+	}
+	m.csm.SetTopology(args.Address, args.Topology)
+	return nil
+}
+
+// RPCListServers returns the chunkservers known to the master, sorted by address.
+func (m *Master) RPCListServers(args gfs.ListServersArg, reply *gfs.ListServersReply) error {
+	if err := m.checkAdmin(args.Cred, "list the servers"); err != nil {
+		return err
+	}
+	reply.Servers = m.csm.List()
+	return nil
+}
+
+// RPCGetTopology returns the zones, racks and chunkservers of the cluster
+// with their usage and health, for external schedulers.
+func (m *Master) RPCGetTopology(args gfs.GetTopologyArg, reply *gfs.GetTopologyReply) error {
+	if err := m.checkAdmin(args.Cred, "get the topology"); err != nil {
+		return err
+	}
+	reply.Cluster = m.topology()
+	return nil
+}
+
+// topology groups the chunkservers by zone, then by rack.
+func (m *Master) topology() gfs.ClusterTopology {
+	t := gfs.ClusterTopology{NeedReplicas: m.rq.len(), Time: time.Now()}
+	m.cm.RLock()
+	t.Chunks = len(m.cm.chunk)
+	m.cm.RUnlock()
+
+	zones := make(map[string]map[string][]gfs.ServerInfo)
+	for _, v := range m.csm.List() {
+		racks, ok := zones[v.Topology.Zone]
+		if !ok {
+			racks = make(map[string][]gfs.ServerInfo)
+			zones[v.Topology.Zone] = racks
+		}
+		racks[v.Topology.Rack] = append(racks[v.Topology.Rack], v) // sorted by address
+	}
+	for zone, racks := range zones {
+		z := gfs.ZoneTopology{Name: zone}
+		for rack, servers := range racks {
+			r := gfs.RackTopology{Name: rack, Servers: servers}
+			for _, v := range servers {
+				r.Usage.Add(v)
+				z.Usage.Add(v)
+				t.Usage.Add(v)
+			}
+			z.Racks = append(z.Racks, r)
+		}
+		sort.Slice(z.Racks, func(i, j int) bool { return z.Racks[i].Name < z.Racks[j].Name })
+		t.Zones = append(t.Zones, z)
+	}
+	sort.Slice(t.Zones, func(i, j int) bool { return t.Zones[i].Name < t.Zones[j].Name })
+	return t
+}
+
+// throttleCreate takes a create of p from the throttle policy applying to it.
+func (m *Master) throttleCreate(p gfs.Path) error {
+	err := m.th.AllowCreate(p)
+	if err != nil {
+		m.metrics.throttled.Inc("create")
+	}
+	return err
+}
+
+// RPCSetThrottle sets the throttle policy of a directory and its subtree.
+// Policies of appends reach the primaries of chunks along with new leases.
+func (m *Master) RPCSetThrottle(args gfs.SetThrottleArg, reply *gfs.SetThrottleReply) error {
+	if err := m.checkAdmin(args.Cred, "set throttles"); err != nil {
+		return err
+	}
+	return m.th.Set(args.Policy)
+}
+
+// RPCListThrottles returns the throttle policies, sorted by path.
+func (m *Master) RPCListThrottles(args gfs.ListThrottlesArg, reply *gfs.ListThrottlesReply) error {
+	if err := m.checkAdmin(args.Cred, "list the throttles"); err != nil {
+		return err
+	}
+	reply.Policies = m.th.List()
+	return nil
+}
+
+// RPCBuildInfo returns the version, commit, protocol level and features of master.
+func (m *Master) RPCBuildInfo(args gfs.Nouse, reply *gfs.BuildInfoReply) error {
+	reply.BuildInfo = gfs.Build(features...)
+	return nil
+}
+
+// RPCRebalance triggers a server check right away instead of waiting for
+// the next tick, so that dead servers are removed and chunks without enough
+// replicas are re-replicated, and starts a rebalancing round moving chunks
+// off the most utilized servers. It returns without waiting for either.
+func (m *Master) RPCRebalance(args gfs.RebalanceArg, reply *gfs.RebalanceReply) error {
+	if err := m.checkAdmin(args.Cred, "rebalance chunks"); err != nil {
+		return err
+	}
+	if args.DryRun {
+		reply.Moves = m.planRebalance()
+		return nil
+	}
+	select {
+	case m.checkNow <- struct{}{}:
+	default: // already pending
+	}
+	go m.rebalance()
+	return nil
+}
@@ -0,0 +1,69 @@
+package master
+
+import (
+	"time"
+
+	"gfs/metrics"
+)
+
+// masterMetrics are the metrics exported by master on /metrics.
+type masterMetrics struct {
+	*metrics.Registry
+	rpc             *metrics.RPC
+	reReplications  *metrics.Counter
+	rebalanceMoves  *metrics.Counter
+	throttled       *metrics.Counter
+	divergences     *metrics.Counter
+	badReplicas     *metrics.Counter
+	authFailures    *metrics.Counter
+	removedServers  *metrics.Counter
+	removedReplicas *metrics.Counter
+}
+
+func newMasterMetrics(m *Master) *masterMetrics {
+	r := metrics.NewRegistry()
+	mm := &masterMetrics{
+		Registry:        r,
+		rpc:             r.NewRPC(),
+		reReplications:  r.NewCounter("gfs_master_rereplications_total", "Re-replications started, by result.", "result"),
+		rebalanceMoves:  r.NewCounter("gfs_master_rebalance_moves_total", "Chunks moved by rebalancing, by result.", "result"),
+		throttled:       r.NewCounter("gfs_master_throttled_total", "Operations rejected by throttle policies, by operation.", "op"),
+		divergences:     r.NewCounter("gfs_master_divergences_total", "Replica divergences reported by clients, by kind.", "kind"),
+		badReplicas:     r.NewCounter("gfs_master_bad_replicas_total", "Replicas reported failing their checksums by clients, by whether master confirmed them.", "result"),
+		authFailures:    r.NewCounter("gfs_master_auth_failures_total", "Rpcs rejected for a missing or invalid client token."),
+		removedServers:  r.NewCounter("gfs_master_removed_servers_total", "Chunkservers removed, by reason: heartbeat timeout, reported dead, deregistered or registered again.", "reason"),
+		removedReplicas: r.NewCounter("gfs_master_removed_replicas_total", "Replicas lost with the chunkservers removed, their chunks queued for re-replication."),
+	}
+
+	r.NewGaugeFunc("gfs_master_chunks", "Chunks known to master.", func() float64 {
+		m.cm.RLock()
+		defer m.cm.RUnlock()
+		return float64(len(m.cm.chunk))
+	})
+	r.NewGaugeFunc("gfs_master_files", "Files with chunks known to master.", func() float64 {
+		m.cm.RLock()
+		defer m.cm.RUnlock()
+		return float64(len(m.cm.file))
+	})
+	r.NewGaugeFunc("gfs_master_rereplication_queue", "Chunks waiting for re-replication.", func() float64 {
+		return float64(m.rq.len())
+	})
+	r.NewGaugeFunc("gfs_master_chunkservers", "Live chunkservers.", func() float64 {
+		return float64(len(m.csm.List()))
+	})
+	r.NewGaugeVecFunc("gfs_master_heartbeat_lag_seconds", "Time since the last heartbeat of a chunkserver.", "server", func() map[string]float64 {
+		ret := make(map[string]float64)
+		for _, v := range m.csm.List() {
+			ret[string(v.Address)] = time.Since(v.LastHeartbeat).Seconds()
+		}
+		return ret
+	})
+	r.NewGaugeVecFunc("gfs_master_chunkserver_chunks", "Chunks stored on a chunkserver.", "server", func() map[string]float64 {
+		ret := make(map[string]float64)
+		for _, v := range m.csm.List() {
+			ret[string(v.Address)] = float64(v.Chunks)
+		}
+		return ret
+	})
+	return mm
+}
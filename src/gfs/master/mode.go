@@ -0,0 +1,87 @@
+package master
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"gfs"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Master serves clients in a mode set by an admin. In read-only mode the
+// mutations of the namespace and the leases for mutating chunks are refused
+// with gfs.ReadOnly, and leases are not extended, so files stay as they are
+// once the leases granted before expire. In maintenance mode every operation
+// of clients is refused with gfs.InMaintenance. Entering it waits for the
+// operations in flight, then stores the metadata, which is not stored again
+// until master leaves the mode so that it can be copied. Chunkservers are
+// served in every mode, the background tasks changing the namespace pause
+// outside the normal mode.
+
+// Mode returns the mode master serves clients in.
+func (m *Master) Mode() gfs.MasterMode {
+	return gfs.MasterMode(atomic.LoadInt32(&m.mode))
+}
+
+// checkMode returns an error if an operation of a client, a mutation if
+// write is set, is refused in the mode of master.
+func (m *Master) checkMode(write bool) error {
+	switch mode := m.Mode(); {
+	case mode == gfs.MasterMaintenance:
+		return gfs.Error{gfs.InMaintenance, fmt.Sprintf("master %v is in maintenance", m.address)}
+	case mode == gfs.MasterReadOnly && write:
+		return gfs.Error{gfs.ReadOnly, fmt.Sprintf("master %v is read-only", m.address)}
+	}
+	return nil
+}
+
+// admit admits an operation of a client, a mutation if write is set, in the
+// mode of master. The operation is in flight until done is called.
+func (m *Master) admit(write bool) (done func(), err error) {
+	m.ops.RLock()
+	if err := m.checkMode(write); err != nil {
+		m.ops.RUnlock()
+		return nil, err
+	}
+	return m.ops.RUnlock, nil
+}
+
+// SetMode sets the mode of master and returns the one before. Entering
+// maintenance returns once the operations in flight are done and the
+// metadata is stored.
+func (m *Master) SetMode(mode gfs.MasterMode) (gfs.MasterMode, error) {
+	if mode < gfs.MasterNormal || mode > gfs.MasterMaintenance {
+		return 0, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("unknown master mode %v", int(mode))}
+	}
+	m.modeLock.Lock()
+	defer m.modeLock.Unlock()
+
+	prev := gfs.MasterMode(atomic.SwapInt32(&m.mode, int32(mode)))
+	if prev == mode {
+		return prev, nil
+	}
+	log.Warningf("master %v leaves %v mode for %v", m.address, prev, mode)
+	if mode == gfs.MasterMaintenance {
+		// the operations admitted before are drained, the later ones see the mode
+		m.ops.Lock()
+		m.ops.Unlock()
+		return prev, m.storeMeta()
+	}
+	return prev, nil
+}
+
+// RPCSetMode is called by an admin to put master in read-only or maintenance mode, or back to normal
+func (m *Master) RPCSetMode(args gfs.SetModeArg, reply *gfs.SetModeReply) error {
+	if err := m.checkAdmin(args.Cred, "set the mode of master"); err != nil {
+		return err
+	}
+	var err error
+	reply.Previous, err = m.SetMode(args.Mode)
+	return err
+}
+
+// RPCGetMode returns the mode master serves clients in
+func (m *Master) RPCGetMode(args gfs.Nouse, reply *gfs.GetModeReply) error {
+	reply.Mode = m.Mode()
+	return nil
+}
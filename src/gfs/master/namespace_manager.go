@@ -2,40 +2,86 @@ package master
 
 import (
 	"fmt"
-	//"path"
+	"os"
+	"path"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"gfs"
 	log "github.com/Sirupsen/logrus"
 )
 
+// The namespace is locked as in GFS, a lock per node: an operation read
+// locks the directories on the way to the path it works on and write locks
+// the last one it changes, the parent of a path created or deleted, or the
+// node itself whose metadata it sets. Creates in different directories, and
+// reads anywhere, go on at once, while an operation write locking a
+// directory excludes the ones under it, which read lock it on their way.
+// Locks are taken from the root down, and the operations locking several
+// paths, such as Rename, take them in the order of comparePaths, so that no
+// two operations wait for each other.
 type namespaceManager struct {
-	root     *nsTree
-	serialCt int
+	root       *nsTree
+	serialCt   int
+	generation int64 // last generation given to a file, accessed atomically
+	replicas   int64 // of the files created, 0 for gfs.DefaultNumReplicas, accessed atomically
 }
 
 type nsTree struct {
 	sync.RWMutex
+	btime  time.Time // creation, zero for the root
+	mtime  time.Time // last change of the data, or of the entries of a directory
+	ctime  time.Time // last change of the data or the metadata
+	owner  string
+	group  string
+	mode   os.FileMode       // permission bits
+	xattrs map[string][]byte // extended attributes, see xattr.go
 
 	// if it is a directory
-	isDir    bool
-	children map[string]*nsTree
+	isDir      bool
+	children   map[string]*nsTree
+	protected  bool      // never removed by empty directory collection
+	emptySince time.Time // when it became empty, zero if unknown
+	trash      bool      // gfs.TrashDir, see trash.go
+	maxFiles   int64     // quota of files in the subtree, 0 is unlimited, see quota.go
+	maxBytes   int64     // quota of bytes in the subtree, 0 is unlimited
 
 	// if it is a file
-	length int64
-	chunks int64
+	length     int64
+	chunks     int64
+	chunkSize  int64
+	replicas   int                       // target number of replicas, 0 for gfs.DefaultNumReplicas
+	placement  []gfs.PlacementConstraint // of chunks allocated afterwards
+	generation int64                     // changed whenever clients change the length, unique among files
 }
 
 type serialTreeNode struct {
-	IsDir    bool
-	Children map[string]int
-	Chunks   int64
+	IsDir      bool
+	Children   map[string]int
+	Protected  bool
+	Length     int64
+	Chunks     int64
+	ChunkSize  int64
+	Replicas   int
+	Placement  []gfs.PlacementConstraint
+	Generation int64
+	Btime      time.Time
+	Mtime      time.Time
+	Ctime      time.Time
+	Owner      string
+	Group      string
+	Mode       os.FileMode
+	MaxFiles   int64
+	MaxBytes   int64
+	Xattrs     map[string][]byte
 }
 
 // tree2array transforms the namespace tree into an array for serialization
 func (nm *namespaceManager) tree2array(array *[]serialTreeNode, node *nsTree) int {
-	n := serialTreeNode{IsDir: node.isDir, Chunks: node.chunks}
+	n := serialTreeNode{IsDir: node.isDir, Protected: node.protected, Length: node.length, Chunks: node.chunks, ChunkSize: node.chunkSize, Replicas: node.replicas, Placement: node.placement, Generation: node.generation, Btime: node.btime, Mtime: node.mtime, Ctime: node.ctime, Owner: node.owner, Group: node.group, Mode: node.mode, MaxFiles: node.maxFiles, MaxBytes: node.maxBytes, Xattrs: node.xattrs}
 	if node.isDir {
 		n.Children = make(map[string]int)
 		for k, v := range node.children {
@@ -63,8 +109,35 @@ func (nm *namespaceManager) Serialize() []serialTreeNode {
 // array2tree transforms the an serialized array to namespace tree
 func (nm *namespaceManager) array2tree(array []serialTreeNode, id int) *nsTree {
 	n := &nsTree{
-		isDir:  array[id].IsDir,
-		chunks: array[id].Chunks,
+		isDir:      array[id].IsDir,
+		protected:  array[id].Protected,
+		length:     array[id].Length,
+		chunks:     array[id].Chunks,
+		chunkSize:  array[id].ChunkSize,
+		replicas:   array[id].Replicas,
+		placement:  array[id].Placement,
+		generation: array[id].Generation,
+		btime:      array[id].Btime,
+		mtime:      array[id].Mtime,
+		ctime:      array[id].Ctime,
+		owner:      array[id].Owner,
+		group:      array[id].Group,
+		mode:       array[id].Mode,
+		maxFiles:   array[id].MaxFiles,
+		maxBytes:   array[id].MaxBytes,
+		xattrs:     array[id].Xattrs,
+	}
+	if !n.isDir && n.chunkSize == 0 { // stored before chunk sizes were configurable
+		n.chunkSize = gfs.MaxChunkSize
+	}
+	if !n.isDir && n.generation == 0 { // stored before files had generations
+		nm.advance(n)
+	}
+	if n.btime.IsZero() { // stored before creation times
+		n.btime = n.ctime
+	}
+	if n.owner == "" { // stored before files had owners
+		n.own(&nsTree{group: gfs.SuperUser}, gfs.Credentials{})
 	}
 
 	if array[id].IsDir {
@@ -81,34 +154,76 @@ func (nm *namespaceManager) array2tree(array []serialTreeNode, id int) *nsTree {
 func (nm *namespaceManager) Deserialize(array []serialTreeNode) error {
 	nm.root.Lock()
 	defer nm.root.Unlock()
+	for _, n := range array {
+		if n.Generation > nm.generation {
+			nm.generation = n.Generation
+		}
+	}
 	nm.root = nm.array2tree(array, len(array)-1)
+	nm.ensureTrash()
 	return nil
 }
 
 func newNamespaceManager() *namespaceManager {
 	nm := &namespaceManager{
 		root: &nsTree{isDir: true,
-			children: make(map[string]*nsTree)},
+			children: make(map[string]*nsTree),
+			owner:    gfs.SuperUser,
+			group:    gfs.SuperUser,
+			mode:     gfs.DefaultDirMode},
 	}
+	nm.ensureTrash()
 	log.Info("-----------new namespace manager")
 	return nm
 }
 
+// modified records a change of the data of node at now, or of its entries
+// if it is a directory. node should be locked in advance.
+func (node *nsTree) modified(now time.Time) {
+	node.mtime, node.ctime = now, now
+}
+
+// advance gives file a new generation, when it is created or its length changes.
+func (nm *namespaceManager) advance(file *nsTree) {
+	file.generation = atomic.AddInt64(&nm.generation, 1)
+}
+
+// addWait adds the time elapsed since start to wait if it is not nil.
+func addWait(wait *time.Duration, start time.Time) {
+	if wait != nil {
+		*wait += time.Since(start)
+	}
+}
+
+// rlock places a read lock on node, the time waited is added to wait.
+func (node *nsTree) rlock(wait *time.Duration) {
+	start := time.Now()
+	node.RLock()
+	addWait(wait, start)
+}
+
+// lock places a write lock on node, the time waited is added to wait.
+func (node *nsTree) lock(wait *time.Duration) {
+	start := time.Now()
+	node.Lock()
+	addWait(wait, start)
+}
+
 // lockParents place read lock on all parents of p. It returns the list of
 // parents' name, the direct parent nsTree. If a parent does not exist,
-// an error is also returned.
-func (nm *namespaceManager) lockParents(p gfs.Path, goDown bool) ([]string, *nsTree, error) {
+// an error is also returned. The time waited for locks is added to wait.
+func (nm *namespaceManager) lockParents(p gfs.Path, goDown bool, wait *time.Duration) ([]string, *nsTree, error) {
 	ps := strings.Split(string(p), "/")[1:]
 	cwd := nm.root
 	//log.Info("ps ", ps, " len: ", len(ps))
 	if len(ps) > 0 {
-		cwd.RLock()
+		cwd.rlock(wait)
 		//log.Info("lock root")
 		for i, name := range ps[:len(ps)] {
 			// TODO : check path name
 			c, ok := cwd.children[name]
 			if !ok {
-				return ps, cwd, fmt.Errorf("path %s not found", p)
+				return ps, cwd, gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %s not found", p)}
 			}
 			if i == len(ps)-1 {
 				if goDown { // go down deeper?
@@ -117,7 +232,7 @@ func (nm *namespaceManager) lockParents(p gfs.Path, goDown bool) ([]string, *nsT
 			} else {
 				cwd = c
 				//log.Info("lock ", name)
-				cwd.RLock()
+				cwd.rlock(wait)
 			}
 		}
 	}
@@ -133,8 +248,7 @@ func (nm *namespaceManager) unlockParents(ps []string) {
 		for _, name := range ps[:len(ps)-1] {
 			c, ok := cwd.children[name]
 			if !ok {
-				log.Fatal("error in unlock")
-				return
+				return // lockParents stopped here too
 			}
 			cwd = c
 			cwd.RUnlock()
@@ -142,6 +256,64 @@ func (nm *namespaceManager) unlockParents(ps []string) {
 	}
 }
 
+// lockDirs write locks the directories dirs and read locks their parents,
+// each once, in the order of comparePaths. It returns the nodes of dirs, all
+// the nodes locked and a func to unlock them. If a directory does not exist, nothing is left
+// locked and an error is returned. The time waited is added to wait.
+func (nm *namespaceManager) lockDirs(dirs []gfs.Path, wait *time.Duration) ([]*nsTree, []*nsTree, func(), error) {
+	write := make(map[string]bool)
+	var paths [][]string
+	for _, dir := range dirs {
+		names := splitPath(gfs.Path(strings.TrimSuffix(string(dir), "/")))
+		for i := 0; i <= len(names); i++ {
+			key := strings.Join(names[:i], "/")
+			if _, ok := write[key]; !ok {
+				paths = append(paths, names[:i])
+			}
+			write[key] = write[key] || i == len(names)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool { return comparePaths(paths[i], paths[j]) < 0 })
+
+	locked := make(map[string]*nsTree, len(paths))
+	var order []*nsTree
+	unlock := func() {
+		for i := len(order) - 1; i >= 0; i-- {
+			if write[strings.Join(paths[i], "/")] {
+				order[i].Unlock()
+			} else {
+				order[i].RUnlock()
+			}
+		}
+	}
+	for _, names := range paths {
+		key := strings.Join(names, "/")
+		node := nm.root
+		if len(names) > 0 {
+			// the parent is locked before, it goes first
+			var ok bool
+			node, ok = locked[strings.Join(names[:len(names)-1], "/")].children[names[len(names)-1]]
+			if !ok || !node.isDir {
+				unlock()
+				return nil, nil, nil, gfs.Error{gfs.PathNotFound, fmt.Sprintf("directory /%v not found", key)}
+			}
+		}
+		if write[key] {
+			node.lock(wait)
+		} else {
+			node.rlock(wait)
+		}
+		locked[key] = node
+		order = append(order, node)
+	}
+
+	ret := make([]*nsTree, len(dirs))
+	for i, dir := range dirs {
+		ret[i] = locked[strings.Join(splitPath(gfs.Path(strings.TrimSuffix(string(dir), "/"))), "/")]
+	}
+	return ret, order, unlock, nil
+}
+
 // PartionLastName partions the last filename from p
 // e.g. /foo/bar/haha.txt -> /foo/bar , haha.txt
 func (nm *namespaceManager) PartionLastName(p gfs.Path) (gfs.Path, string) {
@@ -154,108 +326,882 @@ func (nm *namespaceManager) PartionLastName(p gfs.Path) (gfs.Path, string) {
 }
 
 // Create creates an empty file on path p. All parents should exist.
-func (nm *namespaceManager) Create(p gfs.Path) error {
+// A chunkSize of 0 means gfs.MaxChunkSize, others should be one of gfs.ChunkSizes.
+func (nm *namespaceManager) Create(p gfs.Path, chunkSize int64, cred gfs.Credentials, wait *time.Duration) error {
+	if chunkSize == 0 {
+		chunkSize = gfs.MaxChunkSize
+	}
+	if !gfs.ValidChunkSize(chunkSize) {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("chunk size %v is not one of %v", chunkSize, gfs.ChunkSizes)}
+	}
+
 	var filename string
 	p, filename = nm.PartionLastName(p)
 
 	log.Info("create file ", p, "/", filename)
 
-	ps, cwd, err := nm.lockParents(p, true)
+	ps, cwd, err := nm.lockParents(p, true, wait)
 	defer nm.unlockParents(ps)
 	if err != nil {
 		return err
 	}
 
-	cwd.Lock()
+	if err := nm.searchParents(ps, cred); err != nil {
+		return err
+	}
+
+	cwd.lock(wait)
 	defer cwd.Unlock()
 
 	if _, ok := cwd.children[filename]; ok {
-		return fmt.Errorf("path %s already exists", p)
+		return gfs.Error{gfs.PathExists, fmt.Sprintf("path %s already exists", p)}
+	}
+	if err := cwd.checkAccess(p, cred, permWrite|permExec); err != nil {
+		return err
+	}
+	if err := nm.checkQuota(p, 1, 0, nil); err != nil {
+		return err
 	}
-	cwd.children[filename] = new(nsTree)
+	now := time.Now()
+	cwd.children[filename] = (&nsTree{chunkSize: chunkSize, replicas: nm.newReplicas(), btime: now, mtime: now, ctime: now}).own(cwd, cred)
+	nm.advance(cwd.children[filename])
+	cwd.modified(now)
 	return nil
 }
 
-// Delete deletes an file on path p.
-func (nm *namespaceManager) Delete(p gfs.Path) error {
-	ps, cwd, err := nm.lockParents(p, false)
+// Open returns the length, the number of chunks and the chunk size of the
+// file on path p. If create is set, the file is created with gfs.MaxChunkSize
+// if it does not exist, in which case created is set. All parents should exist.
+func (nm *namespaceManager) Open(p gfs.Path, create bool, cred gfs.Credentials, wait *time.Duration) (length, chunks, chunkSize int64, created bool, err error) {
+	dir, filename := nm.PartionLastName(p)
+
+	ps, cwd, err := nm.lockParents(dir, true, wait)
 	defer nm.unlockParents(ps)
 	if err != nil {
-		return err
+		return 0, 0, 0, false, err
+	}
+	if err := nm.searchParents(ps, cred); err != nil {
+		return 0, 0, 0, false, err
 	}
 
-	filename := ps[len(ps)-1]
+	cwd.lock(wait)
+	defer cwd.Unlock()
 
-	cwd.Lock()
+	file, ok := cwd.children[filename]
+	want := permExec
+	if !ok && create {
+		want |= permWrite
+	}
+	if err := cwd.checkAccess(dir, cred, want); err != nil {
+		return 0, 0, 0, false, err
+	}
+	if !ok {
+		if !create {
+			return 0, 0, 0, false, gfs.Error{gfs.PathNotFound, fmt.Sprintf("File %v does not exist", p)}
+		}
+		if err := nm.checkQuota(dir, 1, 0, nil); err != nil {
+			return 0, 0, 0, false, err
+		}
+		log.Info("create file ", dir, "/", filename)
+		now := time.Now()
+		cwd.children[filename] = (&nsTree{chunkSize: gfs.MaxChunkSize, replicas: nm.newReplicas(), btime: now, mtime: now, ctime: now}).own(cwd, cred)
+		nm.advance(cwd.children[filename])
+		cwd.modified(now)
+		return 0, 0, gfs.MaxChunkSize, true, nil
+	}
+
+	file.rlock(wait)
+	defer file.RUnlock()
+	if file.isDir {
+		return 0, 0, 0, false, gfs.Error{gfs.IsDirectory, fmt.Sprintf("path %v is a directory", p)}
+	}
+	return file.length, file.chunks, file.chunkSize, false, nil
+}
+
+// Delete deletes an file on path p. A directory with children is deleted
+// only if recursive is set, with its subtree. The parent is write locked
+// throughout, so no path underneath can be looked up, e.g. to create a file,
+// until the subtree is gone. If trash is set, the path is moved to the trash,
+// unless it is in the trash already, otherwise it is removed for good. moved
+// is called on each file of the path. It returns the files and directories
+// deleted, and the path in the trash.
+func (nm *namespaceManager) Delete(p gfs.Path, recursive, trash bool, cred gfs.Credentials, moved moveFunc, wait *time.Duration) (files, dirs int, trashed gfs.Path, err error) {
+	if p == gfs.TrashDir {
+		return 0, 0, "", gfs.Error{gfs.InvalidArgument, fmt.Sprintf("%v cannot be deleted, its entries can", p)}
+	}
+	full := p
+	var filename string
+	p, filename = nm.PartionLastName(p)
+
+	ps, cwd, err := nm.lockParents(p, true, wait)
+	defer nm.unlockParents(ps)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if err := nm.searchParents(ps, cred); err != nil {
+		return 0, 0, "", err
+	}
+
+	cwd.lock(wait)
 	defer cwd.Unlock()
 
-	// rename, laze delete
-	node := cwd.children[filename]
+	node, ok := cwd.children[filename]
+	if !ok {
+		return 0, 0, "", gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %s/%s not found", p, filename)}
+	}
+	if err := cwd.checkAccess(p, cred, permWrite|permExec); err != nil {
+		return 0, 0, "", err
+	}
+
+	node.rlock(wait)
+	if node.isDir {
+		if !recursive && !node.isEmpty() {
+			node.RUnlock()
+			return 0, 0, "", gfs.Error{gfs.DirectoryNotEmpty, fmt.Sprintf("directory %s/%s is not empty", p, filename)}
+		}
+		dirs = 1
+		err = node.count(p+"/"+gfs.Path(filename), cred, &files, &dirs)
+	} else {
+		files = 1
+	}
+	node.RUnlock()
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	now := time.Now()
 	delete(cwd.children, filename)
-	cwd.children[gfs.DeletedFilePrefix+filename] = node
+	cwd.modified(now)
+	if cwd.isEmpty() {
+		cwd.emptySince = now
+	}
+	if !trash || inTrash(full) {
+		node.files(full, func(f gfs.Path) { moved(f, "") })
+		return files, dirs, "", nil
+	}
+	return files, dirs, nm.toTrash(full, node, cred, now, moved, wait), nil
+}
+
+// count adds the files and directories inside the directory node at p to
+// files and dirs. It returns gfs.PermissionDenied if cred may not remove the
+// children of a directory in the subtree. node should be read locked in advance.
+func (node *nsTree) count(p gfs.Path, cred gfs.Credentials, files, dirs *int) error {
+	if !node.isEmpty() {
+		if err := node.checkAccess(p, cred, permWrite|permExec); err != nil {
+			return err
+		}
+	}
+	for name, child := range node.children {
+		if strings.HasPrefix(name, gfs.DeletedFilePrefix) {
+			continue
+		}
+		child.RLock()
+		var err error
+		if child.isDir {
+			*dirs++
+			err = child.count(p+"/"+gfs.Path(name), cred, files, dirs)
+		} else {
+			*files++
+		}
+		child.RUnlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Modified records a change of the data of the file p at now, reported by
+// the primary of one of its chunks.
+func (nm *namespaceManager) Modified(p gfs.Path, now time.Time) error {
+	ps, cwd, err := nm.lockParents(p, false, nil)
+	defer nm.unlockParents(ps)
+	if err != nil {
+		return err
+	}
+
+	file, ok := cwd.children[ps[len(ps)-1]]
+	if !ok || file.isDir {
+		return gfs.Error{gfs.PathNotFound, fmt.Sprintf("File %v does not exist", p)}
+	}
+	file.Lock()
+	defer file.Unlock()
+	if now.After(file.mtime) {
+		file.modified(now)
+	}
 	return nil
 }
 
-// Rename rename an file on path p.
-func (nm *namespaceManager) Rename(source, target gfs.Path) error {
-	log.Fatal("Unsupported Rename")
+// Rename moves the file or the directory source to target, whose parent
+// should exist and which should not. The parents of both are write locked,
+// so that the operations under source and target, which read lock them, are
+// excluded. cred should be allowed to remove source from its parent and to
+// create target in its own, whose quotas should leave room for source, sized
+// by size, if it moves to another directory.
+func (nm *namespaceManager) Rename(source, target gfs.Path, cred gfs.Credentials, moved moveFunc, size sizeFunc, wait *time.Duration) error {
+	for _, p := range []gfs.Path{source, target} {
+		if p == "" || p == "/" || p == gfs.TrashDir || inTrash(p) {
+			return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("path %v cannot be renamed", p)}
+		}
+	}
+	if isPrefix(splitPath(source), splitPath(target)) || source == target {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("%v cannot be moved to %v, inside itself", source, target)}
+	}
+	srcDir, srcName := nm.PartionLastName(source)
+	dstDir, dstName := nm.PartionLastName(target)
+
+	dirs, held, unlock, err := nm.lockDirs([]gfs.Path{srcDir, dstDir}, wait)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	src, dst := dirs[0], dirs[1]
+	for _, dir := range []gfs.Path{srcDir, dstDir} {
+		if err := nm.searchParents(splitPath(dir), cred); err != nil {
+			return err
+		}
+	}
+	node, ok := src.children[srcName]
+	if !ok {
+		return gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %v not found", source)}
+	}
+	if _, ok := dst.children[dstName]; ok {
+		return gfs.Error{gfs.PathExists, fmt.Sprintf("path %v already exists", target)}
+	}
+	if err := src.checkAccess(srcDir, cred, permWrite|permExec); err != nil {
+		return err
+	}
+	if err := dst.checkAccess(dstDir, cred, permWrite|permExec); err != nil {
+		return err
+	}
+
+	if src != dst {
+		// only the quotas source is not under already are charged
+		quotas, err := nm.quotas(dstDir, size, held...)
+		if err != nil {
+			return err
+		}
+		var charged []dirQuota
+		for _, q := range quotas {
+			if !isPrefix(splitPath(gfs.Path(strings.TrimSuffix(string(q.path), "/"))), splitPath(source)) {
+				charged = append(charged, q)
+			}
+		}
+		files, bytes := int64(1), size(source, node)
+		if node.isDir {
+			files, bytes = 0, 0
+			node.usage(source, nil, size, &files, &bytes)
+		}
+		if err := checkQuotas(charged, files, bytes); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	delete(src.children, srcName)
+	src.modified(now)
+	if src.isEmpty() {
+		src.emptySince = now
+	}
+	dst.children[dstName] = node
+	dst.modified(now)
+	node.ctime = now
+	node.files(source, func(f gfs.Path) { moved(f, target+f[len(source):]) })
+	log.Infof("rename %v to %v", source, target)
 	return nil
 }
 
 // Mkdir creates a directory on path p. All parents should exist.
-func (nm *namespaceManager) Mkdir(p gfs.Path) error {
+func (nm *namespaceManager) Mkdir(p gfs.Path, cred gfs.Credentials, wait *time.Duration) error {
 	var filename string
 	p, filename = nm.PartionLastName(p)
 
 	log.Info("mkdir ", p, "/", filename)
 
-	ps, cwd, err := nm.lockParents(p, true)
+	ps, cwd, err := nm.lockParents(p, true, wait)
 	defer nm.unlockParents(ps)
 	if err != nil {
 		return err
 	}
 
-	cwd.Lock()
+	if err := nm.searchParents(ps, cred); err != nil {
+		return err
+	}
+
+	cwd.lock(wait)
 	defer cwd.Unlock()
 
 	if _, ok := cwd.children[filename]; ok {
-		return fmt.Errorf("path %s already exists", p)
+		return gfs.Error{gfs.PathExists, fmt.Sprintf("path %s already exists", p)}
+	}
+	if err := cwd.checkAccess(p, cred, permWrite|permExec); err != nil {
+		return err
+	}
+	now := time.Now()
+	cwd.children[filename] = (&nsTree{isDir: true,
+		children:   make(map[string]*nsTree),
+		emptySince: now,
+		btime:      now,
+		mtime:      now,
+		ctime:      now}).own(cwd, cred)
+	cwd.modified(now)
+	return nil
+}
+
+// newReplicas returns the target number of replicas of a new file.
+func (nm *namespaceManager) newReplicas() int {
+	return int(atomic.LoadInt64(&nm.replicas))
+}
+
+// replication returns the target number of replicas of a file.
+func (node *nsTree) replication() int {
+	if node.replicas == 0 {
+		return gfs.DefaultNumReplicas
+	}
+	return node.replicas
+}
+
+// isEmpty returns whether a directory has no children but deleted ones.
+// node should be locked in advance.
+func (node *nsTree) isEmpty() bool {
+	for name := range node.children {
+		if !strings.HasPrefix(name, gfs.DeletedFilePrefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkImport checks the entries of a manifest by themselves, before their
+// chunks are allocated.
+func checkImport(entries []gfs.ImportEntry) (map[gfs.Path]bool, error) {
+	files := make(map[gfs.Path]bool)
+	for _, e := range entries {
+		if !strings.HasPrefix(string(e.Path), "/") || strings.HasSuffix(string(e.Path), "/") {
+			return nil, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("invalid path %q", e.Path)}
+		}
+		if e.Size < 0 {
+			return nil, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("negative size of %v", e.Path)}
+		}
+		if e.ChunkSize != 0 && !gfs.ValidChunkSize(e.ChunkSize) {
+			return nil, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("chunk size %v of %v is not one of %v", e.ChunkSize, e.Path, gfs.ChunkSizes)}
+		}
+		if files[e.Path] {
+			return nil, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("duplicated path %v", e.Path)}
+		}
+		files[e.Path] = true
+	}
+	return files, nil
+}
+
+// importChunkSize returns the chunk size of an imported file.
+func importChunkSize(e gfs.ImportEntry) int64 {
+	if e.ChunkSize == 0 {
+		return gfs.MaxChunkSize
+	}
+	return e.ChunkSize
+}
+
+// Import creates the files of entries with their missing parent directories,
+// and calls attach on each new file, as a single step. The whole namespace is
+// locked during the import, and nothing is created if an entry conflicts with
+// the namespace or another entry. It returns the number of directories
+// created.
+func (nm *namespaceManager) Import(entries []gfs.ImportEntry, attach func(i int, p gfs.Path, file *nsTree), wait *time.Duration) (int, error) {
+	files, err := checkImport(entries)
+	if err != nil {
+		return 0, err
+	}
+
+	nm.root.lock(wait)
+	defer nm.root.Unlock()
+
+	// check all entries before changing anything
+	for _, e := range entries {
+		ps := strings.Split(string(e.Path), "/")[1:]
+		cwd := nm.root
+		for i, name := range ps {
+			if i < len(ps)-1 && files[gfs.Path("/"+strings.Join(ps[:i+1], "/"))] {
+				return 0, gfs.Error{gfs.NotDirectory, fmt.Sprintf("parent of %v is imported as a file", e.Path)}
+			}
+			if cwd == nil {
+				continue // to be created
+			}
+			c, ok := cwd.children[name]
+			switch {
+			case !ok:
+				cwd = nil
+			case i == len(ps)-1:
+				return 0, gfs.Error{gfs.PathExists, fmt.Sprintf("path %v already exists", e.Path)}
+			case !c.isDir:
+				return 0, gfs.Error{gfs.NotDirectory, fmt.Sprintf("parent of %v is a file", e.Path)}
+			default:
+				cwd = c
+			}
+		}
+	}
+
+	dirs := 0
+	now := time.Now()
+	for i, e := range entries {
+		ps := strings.Split(string(e.Path), "/")[1:]
+		cwd := nm.root
+		for _, name := range ps[:len(ps)-1] {
+			c, ok := cwd.children[name]
+			if !ok {
+				c = (&nsTree{isDir: true, children: make(map[string]*nsTree), btime: now, mtime: now, ctime: now}).own(cwd, gfs.Credentials{})
+				cwd.children[name] = c
+				dirs++
+			}
+			cwd = c
+		}
+
+		file := (&nsTree{length: e.Size, chunkSize: importChunkSize(e), btime: now, mtime: now, ctime: now}).own(cwd, gfs.Credentials{})
+		nm.advance(file)
+		cwd.children[ps[len(ps)-1]] = file
+		attach(i, e.Path, file)
+	}
+	return dirs, nil
+}
+
+// SetReplication sets the target number of replicas of the file p.
+// It returns the number of chunks of the file.
+func (nm *namespaceManager) SetReplication(p gfs.Path, replicas int, wait *time.Duration) (int64, error) {
+	if replicas < 1 || replicas > gfs.MaxNumReplicas {
+		return 0, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("replication %v is not within [1, %v]", replicas, gfs.MaxNumReplicas)}
+	}
+	ps, cwd, err := nm.lockParents(p, false, wait)
+	defer nm.unlockParents(ps)
+	if err != nil {
+		return 0, err
+	}
+
+	file, ok := cwd.children[ps[len(ps)-1]]
+	if !ok {
+		return 0, gfs.Error{gfs.PathNotFound, fmt.Sprintf("File %v does not exist", p)}
+	}
+	file.lock(wait)
+	defer file.Unlock()
+	if file.isDir {
+		return 0, gfs.Error{gfs.IsDirectory, fmt.Sprintf("path %v is a directory", p)}
+	}
+	file.replicas = replicas
+	file.ctime = time.Now()
+	return file.chunks, nil
+}
+
+// Replication returns the placement constraints and the target number of replicas of the file p.
+func (nm *namespaceManager) Replication(p gfs.Path) ([]gfs.PlacementConstraint, int, error) {
+	ps, cwd, err := nm.lockParents(p, false, nil)
+	defer nm.unlockParents(ps)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	file, ok := cwd.children[ps[len(ps)-1]]
+	if !ok {
+		return nil, 0, gfs.Error{gfs.PathNotFound, fmt.Sprintf("File %v does not exist", p)}
+	}
+	file.RLock()
+	defer file.RUnlock()
+	return file.placement, file.replication(), nil
+}
+
+// SetPlacement sets the placement constraints of the file p.
+func (nm *namespaceManager) SetPlacement(p gfs.Path, constraints []gfs.PlacementConstraint, wait *time.Duration) error {
+	for _, c := range constraints {
+		if c.Label == "" || c.Kind < gfs.PlacementMust || c.Kind > gfs.PlacementAvoid {
+			return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("invalid placement constraint %+v", c)}
+		}
+	}
+
+	ps, cwd, err := nm.lockParents(p, false, wait)
+	defer nm.unlockParents(ps)
+	if err != nil {
+		return err
+	}
+
+	file, ok := cwd.children[ps[len(ps)-1]]
+	if !ok {
+		return gfs.Error{gfs.PathNotFound, fmt.Sprintf("File %v does not exist", p)}
+	}
+	file.lock(wait)
+	defer file.Unlock()
+	if file.isDir {
+		return gfs.Error{gfs.IsDirectory, fmt.Sprintf("path %v is a directory", p)}
 	}
-	cwd.children[filename] = &nsTree{isDir: true,
-		children: make(map[string]*nsTree)}
+	file.placement = constraints
+	file.ctime = time.Now()
 	return nil
 }
 
-// List returns information of all files and directories inside p.
-func (nm *namespaceManager) List(p gfs.Path) ([]gfs.PathInfo, error) {
+// Placement returns the placement constraints of the file p.
+func (nm *namespaceManager) Placement(p gfs.Path) ([]gfs.PlacementConstraint, error) {
+	ps, cwd, err := nm.lockParents(p, false, nil)
+	defer nm.unlockParents(ps)
+	if err != nil {
+		return nil, err
+	}
+
+	file, ok := cwd.children[ps[len(ps)-1]]
+	if !ok {
+		return nil, gfs.Error{gfs.PathNotFound, fmt.Sprintf("File %v does not exist", p)}
+	}
+	file.RLock()
+	defer file.RUnlock()
+	return file.placement, nil
+}
+
+// SetProtected sets whether the directory p is protected from empty directory collection.
+func (nm *namespaceManager) SetProtected(p gfs.Path, protected bool, wait *time.Duration) error {
+	if p == "/" {
+		return nil // root is never collected
+	}
+	ps, cwd, err := nm.lockParents(p, true, wait)
+	defer nm.unlockParents(ps)
+	if err != nil {
+		return err
+	}
+
+	cwd.lock(wait)
+	defer cwd.Unlock()
+	if !cwd.isDir {
+		return gfs.Error{gfs.NotDirectory, fmt.Sprintf("path %s is a file, not directory", p)}
+	}
+	cwd.protected = protected
+	cwd.ctime = time.Now()
+	return nil
+}
+
+// emptyDirs appends the paths of the empty directories inside node, deepest first.
+// node should be read locked in advance.
+func (node *nsTree) emptyDirs(p gfs.Path, list *[]gfs.Path) {
+	for name, child := range node.children {
+		if !child.isDir || child.trash || strings.HasPrefix(name, gfs.DeletedFilePrefix) {
+			continue
+		}
+		childPath := gfs.Path(strings.TrimSuffix(string(p), "/") + "/" + name)
+		child.RLock()
+		child.emptyDirs(childPath, list)
+		if !child.protected && child.isEmpty() {
+			*list = append(*list, childPath)
+		}
+		child.RUnlock()
+	}
+}
+
+// CollectEmptyDirs removes the directories that have been empty for at least
+// age, except protected ones. Deleted files inside them are dropped as well.
+// Directories emptied by the collection are collected in the same call only if
+// age is 0. The removed paths are returned.
+func (nm *namespaceManager) CollectEmptyDirs(age time.Duration) []gfs.Path {
+	var removed []gfs.Path
+	for {
+		var candidates []gfs.Path
+		nm.root.RLock()
+		nm.root.emptyDirs("/", &candidates)
+		nm.root.RUnlock()
+
+		n := len(removed)
+		for _, p := range candidates {
+			if nm.removeEmptyDir(p, age) {
+				removed = append(removed, p)
+			}
+		}
+		if age > 0 || len(removed) == n {
+			return removed
+		}
+	}
+}
+
+// CollectableDirs returns the directories CollectEmptyDirs(age) would remove
+// now, without removing them.
+func (nm *namespaceManager) CollectableDirs(age time.Duration) []gfs.Path {
+	var list []gfs.Path
+	nm.root.RLock()
+	for name, child := range nm.root.children {
+		if child.isDir && !child.trash && !strings.HasPrefix(name, gfs.DeletedFilePrefix) {
+			child.RLock()
+			child.collectable(gfs.Path("/"+name), age, time.Now(), &list)
+			child.RUnlock()
+		}
+	}
+	nm.root.RUnlock()
+	return list
+}
+
+// collectable returns whether the directory node at p would be removed by
+// CollectEmptyDirs(age), appending the directories removed under it and
+// itself to list. Directories emptied by the collection count as removed
+// only if age is 0. node should be locked in advance.
+func (node *nsTree) collectable(p gfs.Path, age time.Duration, now time.Time, list *[]gfs.Path) bool {
+	empty := true
+	for name, child := range node.children {
+		if strings.HasPrefix(name, gfs.DeletedFilePrefix) {
+			continue
+		}
+		if !child.isDir {
+			empty = false
+			continue
+		}
+		child.RLock()
+		if !child.collectable(p+"/"+gfs.Path(name), age, now, list) {
+			empty = false
+		}
+		child.RUnlock()
+	}
+	if node.protected {
+		return false
+	}
+	ok := empty
+	if age > 0 {
+		ok = node.isEmpty() && !node.emptySince.IsZero() && now.Sub(node.emptySince) >= age
+	}
+	if ok {
+		*list = append(*list, p)
+	}
+	return ok
+}
+
+// removeEmptyDir removes the directory p if it has been empty for at least age.
+// The time a directory is found empty is recorded if it is unknown.
+func (nm *namespaceManager) removeEmptyDir(p gfs.Path, age time.Duration) bool {
+	dirPath, name := nm.PartionLastName(p)
+	ps, cwd, err := nm.lockParents(dirPath, true, nil)
+	defer nm.unlockParents(ps)
+	if err != nil {
+		return false
+	}
+
+	cwd.Lock()
+	defer cwd.Unlock()
+	dir, ok := cwd.children[name]
+	if !ok || !dir.isDir {
+		return false
+	}
+
+	dir.Lock()
+	defer dir.Unlock()
+	if dir.protected || !dir.isEmpty() {
+		return false
+	}
+	now := time.Now()
+	if dir.emptySince.IsZero() {
+		dir.emptySince = now // e.g. loaded from disk
+	}
+	if now.Sub(dir.emptySince) < age {
+		return false
+	}
+
+	log.Infof("remove empty directory %v", p)
+	delete(cwd.children, name)
+	if cwd.isEmpty() {
+		cwd.emptySince = now
+	}
+	return true
+}
+
+// namespaceStats are counts over the whole namespace.
+type namespaceStats struct {
+	Dirs    int
+	Files   int
+	Deleted int   // lazily deleted files and directories, and files in the trash
+	Bytes   int64 // total length of files
+}
+
+// Stats walks the namespace and counts its entries.
+func (nm *namespaceManager) Stats() namespaceStats {
+	var st namespaceStats
+	nm.root.RLock()
+	nm.root.stats(&st)
+	nm.root.RUnlock()
+	return st
+}
+
+// stats adds the entries inside node to st. node should be read locked in advance.
+func (node *nsTree) stats(st *namespaceStats) {
+	for name, child := range node.children {
+		if strings.HasPrefix(name, gfs.DeletedFilePrefix) {
+			st.Deleted++
+			continue
+		}
+		child.RLock()
+		if child.trash {
+			child.files("", func(gfs.Path) { st.Deleted++ })
+		} else if child.isDir {
+			st.Dirs++
+			child.stats(st)
+		} else {
+			st.Files++
+			st.Bytes += child.length
+		}
+		child.RUnlock()
+	}
+}
+
+// List returns information of the files and directories inside p with
+// names after after, starting with prefix and matching pattern, sorted by
+// name, limit entries at most. It also returns the name to list after for
+// the next entries, empty if there are none. All children are scanned on
+// each call, but only the ones matching are sorted.
+func (nm *namespaceManager) List(p gfs.Path, after string, limit int, prefix, pattern string, cred gfs.Credentials, size sizeFunc, wait *time.Duration) ([]gfs.PathInfo, string, error) {
 	log.Info("list ", p)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, "", gfs.Error{gfs.InvalidArgument, fmt.Sprintf("bad pattern %q", pattern)}
+	}
 
 	var dir *nsTree
 	if p == gfs.Path("/") {
 		dir = nm.root
 	} else {
-		ps, cwd, err := nm.lockParents(p, true)
+		ps, cwd, err := nm.lockParents(p, true, wait)
 		defer nm.unlockParents(ps)
 		if err != nil {
-			return nil, err
+			return nil, "", err
+		}
+		if err := nm.searchParents(ps, cred); err != nil {
+			return nil, "", err
 		}
 		dir = cwd
 	}
-	dir.RLock()
+	dir.rlock(wait)
 	defer dir.RUnlock()
 
 	if !dir.isDir {
-		return nil, fmt.Errorf("path %s is a file, not directory", p)
+		return nil, "", gfs.Error{gfs.NotDirectory, fmt.Sprintf("path %s is a file, not directory", p)}
+	}
+	if err := dir.checkAccess(p, cred, permRead); err != nil {
+		return nil, "", err
+	}
+
+	var names []string
+	for name := range dir.children {
+		if name <= after || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); pattern != "" && !ok {
+			continue
+		}
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	ls := make([]gfs.PathInfo, 0, len(dir.children))
-	for name, v := range dir.children {
+	var next string
+	if len(names) > limit {
+		names = names[:limit]
+		next = names[limit-1]
+	}
+	ls := make([]gfs.PathInfo, 0, len(names))
+	for _, name := range names {
+		v := dir.children[name]
 		ls = append(ls, gfs.PathInfo{
-			Name:   name,
-			IsDir:  v.isDir,
-			Length: v.length,
-			Chunks: v.chunks,
+			Name:        name,
+			IsDir:       v.isDir,
+			Length:      size(gfs.Path(strings.TrimSuffix(string(p), "/")+"/"+name), v),
+			Chunks:      v.chunks,
+			Replication: v.replication(),
+			CreateTime:  v.btime,
+			ModTime:     v.mtime,
+			ChangeTime:  v.ctime,
+			Owner:       v.owner,
+			Group:       v.group,
+			Mode:        v.mode,
 		})
 	}
-	return ls, nil
+	return ls, next, nil
+}
+
+// Walk calls fn on the entries under p in depth-first order, the children
+// of a directory sorted by name, or on p alone if it is a file. Entries up
+// to after, in that order, are skipped, as are the children of directories
+// cred may not read and search. Each entry and its parents are read locked
+// while fn runs, the walk stops once fn returns false.
+func (nm *namespaceManager) Walk(p, after gfs.Path, cred gfs.Credentials, wait *time.Duration, fn func(p gfs.Path, node *nsTree) bool) error {
+	var node *nsTree
+	if p == gfs.Path("/") {
+		node = nm.root
+	} else {
+		ps, cwd, err := nm.lockParents(p, true, wait)
+		defer nm.unlockParents(ps)
+		if err != nil {
+			return err
+		}
+		if err := nm.searchParents(ps, cred); err != nil {
+			return err
+		}
+		node = cwd
+	}
+	node.rlock(wait)
+	defer node.RUnlock()
+
+	if !node.isDir {
+		if after == "" {
+			fn(p, node)
+		}
+		return nil
+	}
+	if err := node.checkAccess(p, cred, permRead|permExec); err != nil {
+		return err
+	}
+	node.walk(p, splitPath(after), cred, wait, fn)
+	return nil
+}
+
+// walk calls fn on the entries inside node at p, skipping the ones up to
+// after, and returns false once fn does. node should be read locked in advance.
+func (node *nsTree) walk(p gfs.Path, after []string, cred gfs.Credentials, wait *time.Duration, fn func(p gfs.Path, node *nsTree) bool) bool {
+	names := make([]string, 0, len(node.children))
+	for name := range node.children {
+		if !strings.HasPrefix(name, gfs.DeletedFilePrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		childPath := gfs.Path(strings.TrimSuffix(string(p), "/") + "/" + name)
+		child := node.children[name]
+		c := splitPath(childPath)
+		if after != nil && comparePaths(c, after) < 0 && !isPrefix(c, after) {
+			continue // the whole subtree goes before after
+		}
+
+		child.rlock(wait)
+		ok := true
+		if after == nil || comparePaths(c, after) > 0 {
+			ok = fn(childPath, child)
+		}
+		if ok && child.isDir && child.may(cred, permRead|permExec) {
+			ok = child.walk(childPath, after, cred, wait, fn)
+		}
+		child.RUnlock()
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// splitPath returns the names in p, nil for an empty path.
+func splitPath(p gfs.Path) []string {
+	if p == "" {
+		return nil
+	}
+	return strings.Split(strings.Trim(string(p), "/"), "/")
+}
+
+// comparePaths compares paths by their names, so that a directory goes
+// before its subtree and the subtree before the next sibling.
+func comparePaths(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return strings.Compare(a[i], b[i])
+		}
+	}
+	return len(a) - len(b)
+}
+
+// isPrefix returns whether the path a is a parent of b.
+func isPrefix(a, b []string) bool {
+	return len(a) < len(b) && comparePaths(a, b[:len(a)]) == 0
 }
@@ -0,0 +1,197 @@
+package master
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gfs"
+)
+
+// access bits of a mode, those of the group are shifted by 3, of the owner by 6
+const (
+	permExec  os.FileMode = 1 // of a directory, to look up its children
+	permWrite os.FileMode = 2
+	permRead  os.FileMode = 4
+)
+
+// own sets the owner of node, created in dir, to the user of cred, its group
+// to the one of dir and its mode to the default one. It returns node.
+func (node *nsTree) own(dir *nsTree, cred gfs.Credentials) *nsTree {
+	node.owner = cred.Owner()
+	node.group = dir.group
+	node.mode = gfs.DefaultFileMode
+	if node.isDir {
+		node.mode = gfs.DefaultDirMode
+	}
+	return node
+}
+
+// may returns whether cred is allowed the access bits want on node, by the
+// bits of its owner, of its group or of the others, whichever cred is first.
+// node should be locked in advance.
+func (node *nsTree) may(cred gfs.Credentials, want os.FileMode) bool {
+	if cred.IsSuperUser() {
+		return true
+	}
+	mode := node.mode.Perm()
+	switch {
+	case cred.User == node.owner:
+		mode >>= 6
+	case cred.InGroup(node.group):
+		mode >>= 3
+	}
+	return mode&want == want
+}
+
+// checkAccess returns gfs.PermissionDenied unless cred may want on node at p.
+func (node *nsTree) checkAccess(p gfs.Path, cred gfs.Credentials, want os.FileMode) error {
+	if node.may(cred, want) {
+		return nil
+	}
+	var names []string
+	for _, a := range []struct {
+		bit  os.FileMode
+		name string
+	}{{permRead, "read"}, {permWrite, "write"}, {permExec, "search"}} {
+		if want&a.bit != 0 {
+			names = append(names, a.name)
+		}
+	}
+	if p == "" {
+		p = "/"
+	}
+	return gfs.Error{gfs.PermissionDenied, fmt.Sprintf("user %v may not %v %v", cred.Owner(), strings.Join(names, " and "), p)}
+}
+
+// searchParents returns gfs.PermissionDenied unless cred may search the
+// directories locked by lockParents for ps, from the root down.
+func (nm *namespaceManager) searchParents(ps []string, cred gfs.Credentials) error {
+	if len(ps) == 0 || cred.IsSuperUser() {
+		return nil
+	}
+	cwd := nm.root
+	var dir gfs.Path
+	for i := 0; ; i++ {
+		if err := cwd.checkAccess(dir, cred, permExec); err != nil {
+			return err
+		}
+		if i == len(ps)-1 {
+			return nil
+		}
+		cwd = cwd.children[ps[i]]
+		dir += "/" + gfs.Path(ps[i])
+	}
+}
+
+// lockNode write locks the node at p, its parents read locked, and calls fn
+// on it, provided cred may search the parents.
+func (nm *namespaceManager) lockNode(p gfs.Path, cred gfs.Credentials, wait *time.Duration, fn func(node *nsTree) error) error {
+	node := nm.root
+	if p != gfs.Path("/") {
+		ps, cwd, err := nm.lockParents(p, false, wait)
+		defer nm.unlockParents(ps)
+		if err != nil {
+			return err
+		}
+		if err := nm.searchParents(ps, cred); err != nil {
+			return err
+		}
+		var ok bool
+		if node, ok = cwd.children[ps[len(ps)-1]]; !ok {
+			return gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %s not found", p)}
+		}
+	}
+	node.lock(wait)
+	defer node.Unlock()
+	return fn(node)
+}
+
+// rlockNode read locks the node at p, its parents read locked, and calls
+// fn on it, provided cred may search the parents.
+func (nm *namespaceManager) rlockNode(p gfs.Path, cred gfs.Credentials, wait *time.Duration, fn func(node *nsTree) error) error {
+	node := nm.root
+	if p != gfs.Path("/") {
+		ps, cwd, err := nm.lockParents(p, false, wait)
+		defer nm.unlockParents(ps)
+		if err != nil {
+			return err
+		}
+		if err := nm.searchParents(ps, cred); err != nil {
+			return err
+		}
+		var ok bool
+		if node, ok = cwd.children[ps[len(ps)-1]]; !ok {
+			return gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %s not found", p)}
+		}
+	}
+	node.rlock(wait)
+	defer node.RUnlock()
+	return fn(node)
+}
+
+// Chmod sets the permission bits of p. Only the owner and SuperUser may.
+func (nm *namespaceManager) Chmod(p gfs.Path, mode os.FileMode, cred gfs.Credentials, wait *time.Duration) error {
+	if mode&^os.ModePerm != 0 {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("mode %o has bits other than permissions", mode)}
+	}
+	return nm.lockNode(p, cred, wait, func(node *nsTree) error {
+		if !cred.IsSuperUser() && cred.User != node.owner {
+			return gfs.Error{gfs.PermissionDenied, fmt.Sprintf("user %v does not own %v", cred.Owner(), p)}
+		}
+		node.mode = mode
+		node.ctime = time.Now()
+		return nil
+	})
+}
+
+// Chown sets the owner and the group of p, empty ones are kept. Only
+// SuperUser may give a file away, the owner may set the group to one of
+// its own.
+func (nm *namespaceManager) Chown(p gfs.Path, owner, group string, cred gfs.Credentials, wait *time.Duration) error {
+	return nm.lockNode(p, cred, wait, func(node *nsTree) error {
+		if !cred.IsSuperUser() {
+			if owner != "" && owner != node.owner {
+				return gfs.Error{gfs.PermissionDenied, fmt.Sprintf("user %v may not give %v away", cred.Owner(), p)}
+			}
+			if cred.User != node.owner || (group != "" && !cred.InGroup(group)) {
+				return gfs.Error{gfs.PermissionDenied, fmt.Sprintf("user %v may not set the group of %v to %v", cred.Owner(), p, group)}
+			}
+		}
+		if owner != "" {
+			node.owner = owner
+		}
+		if group != "" {
+			node.group = group
+		}
+		node.ctime = time.Now()
+		return nil
+	})
+}
+
+// checkPath returns gfs.PermissionDenied unless cred may search the parents
+// of p and want on the node at p.
+func (nm *namespaceManager) checkPath(p gfs.Path, cred gfs.Credentials, want os.FileMode, wait *time.Duration) error {
+	if cred.IsSuperUser() {
+		return nil
+	}
+	node := nm.root
+	if p != gfs.Path("/") {
+		ps, cwd, err := nm.lockParents(p, false, wait)
+		defer nm.unlockParents(ps)
+		if err != nil {
+			return err
+		}
+		if err := nm.searchParents(ps, cred); err != nil {
+			return err
+		}
+		var ok bool
+		if node, ok = cwd.children[ps[len(ps)-1]]; !ok {
+			return gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %s not found", p)}
+		}
+	}
+	node.rlock(wait)
+	defer node.RUnlock()
+	return node.checkAccess(p, cred, want)
+}
@@ -0,0 +1,84 @@
+package master
+
+import (
+	"gfs"
+)
+
+// PrimaryCandidate is a replica found up to date when a lease is granted, as
+// seen by a PrimaryPolicy.
+type PrimaryCandidate struct {
+	Address      gfs.ServerAddress
+	Length       gfs.Offset // of the replica, the longest are the most up to date
+	Local        bool       // in the failure domain of the majority of writers
+	Draining     bool       // being decommissioned
+	Leases       int        // unexpired leases held as primary
+	MutationLoad int64      // mutations applied per second, as primary or secondary
+	IOLoad       int64      // bytes read and written per second
+	Previous     bool       // held the previous lease of the chunk
+}
+
+// PrimaryPolicy chooses the primary of a chunk granted a new lease among
+// two candidates at least. It is called with the chunkservers locked, so it
+// should be quick and not call master. A choice which is not a candidate
+// is made by the default policy instead.
+type PrimaryPolicy interface {
+	ChoosePrimary(handle gfs.ChunkHandle, candidates []PrimaryCandidate) gfs.ServerAddress
+}
+
+// balancedPrimary is the default PrimaryPolicy. It prefers, in order, the
+// servers not draining, the longest replicas, the servers in the writers'
+// failure domain, the servers whose mutation load is not above
+// gfs.OverloadFactor times the mean of the candidates, the fewest leases held,
+// the lowest mutation load and the previous primary.
+type balancedPrimary struct{}
+
+func (balancedPrimary) ChoosePrimary(handle gfs.ChunkHandle, candidates []PrimaryCandidate) gfs.ServerAddress {
+	var longest gfs.Offset
+	var total int64
+	for _, v := range candidates {
+		if v.Length > longest {
+			longest = v.Length
+		}
+		total += v.MutationLoad
+	}
+	mean := float64(total) / float64(len(candidates))
+	busy := func(v PrimaryCandidate) bool {
+		return float64(v.MutationLoad) > gfs.OverloadFactor*mean
+	}
+	// better reports whether a is preferred to b
+	better := func(a, b PrimaryCandidate) bool {
+		switch {
+		case a.Draining != b.Draining:
+			return !a.Draining
+		case (a.Length == longest) != (b.Length == longest):
+			return a.Length == longest
+		case a.Local != b.Local:
+			return a.Local
+		case busy(a) != busy(b):
+			return !busy(a)
+		case a.Leases != b.Leases:
+			return a.Leases < b.Leases
+		case a.MutationLoad != b.MutationLoad:
+			return a.MutationLoad < b.MutationLoad
+		}
+		return a.Previous && !b.Previous
+	}
+	best := candidates[0]
+	for _, v := range candidates[1:] {
+		if better(v, best) {
+			best = v
+		}
+	}
+	return best.Address
+}
+
+// SetPrimaryPolicy sets the policy choosing the primaries of new leases, the
+// default one if p is nil.
+func (m *Master) SetPrimaryPolicy(p PrimaryPolicy) {
+	m.csm.Lock()
+	defer m.csm.Unlock()
+	if p == nil {
+		p = balancedPrimary{}
+	}
+	m.csm.policy = p
+}
@@ -0,0 +1,183 @@
+package master
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gfs"
+)
+
+// Directories may have quotas of the files and of the bytes under them,
+// counted as by RPCDiskUsage but without the trash. Creates, and undeletes,
+// are refused past the file quota of a parent with gfs.QuotaExceeded, and so
+// are chunk allocations and leases for writes and appends once the bytes of
+// a parent have reached its quota. The bytes are known from the lengths
+// primaries report, so mutations granted before may overrun a quota.
+
+// sizeFunc returns the size of the file node at p.
+type sizeFunc func(p gfs.Path, node *nsTree) int64
+
+// usage adds the files and the bytes under the directory node at p to files
+// and bytes, the bytes only if size is set. The nodes in held are locked by
+// the caller, the others are read locked on the way.
+func (node *nsTree) usage(p gfs.Path, held []*nsTree, size sizeFunc, files, bytes *int64) {
+	for name, child := range node.children {
+		if child.trash || strings.HasPrefix(name, gfs.DeletedFilePrefix) {
+			continue
+		}
+		locked := true
+		for _, h := range held {
+			locked = locked && h != child
+		}
+		if locked {
+			child.RLock()
+		}
+		childPath := gfs.Path(strings.TrimSuffix(string(p), "/") + "/" + name)
+		if child.isDir {
+			child.usage(childPath, held, size, files, bytes)
+		} else {
+			*files++
+			if size != nil {
+				*bytes += size(childPath, child)
+			}
+		}
+		if locked {
+			child.RUnlock()
+		}
+	}
+}
+
+// dirQuota is the quota of a directory with its usage.
+type dirQuota struct {
+	path gfs.Path
+	gfs.QuotaUsage
+}
+
+// quotas returns the quotas of the directory p and of its parents, those
+// with one, with their usage. p and its parents should be locked in advance,
+// as well as the nodes in held.
+func (nm *namespaceManager) quotas(p gfs.Path, size sizeFunc, held ...*nsTree) ([]dirQuota, error) {
+	names := splitPath(gfs.Path(strings.TrimSuffix(string(p), "/")))
+	dirs := []*nsTree{nm.root}
+	for _, name := range names {
+		dir, ok := dirs[len(dirs)-1].children[name]
+		if !ok {
+			return nil, gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %v not found", p)}
+		}
+		dirs = append(dirs, dir)
+	}
+	held = append(held, dirs...)
+
+	var ret []dirQuota
+	for i, dir := range dirs {
+		if dir.maxFiles == 0 && dir.maxBytes == 0 {
+			continue
+		}
+		q := dirQuota{gfs.Path("/" + strings.Join(names[:i], "/")), gfs.QuotaUsage{MaxFiles: dir.maxFiles, MaxBytes: dir.maxBytes}}
+		dir.usage(q.path, held, size, &q.Files, &q.Bytes)
+		ret = append(ret, q)
+	}
+	return ret, nil
+}
+
+// checkQuotas returns gfs.QuotaExceeded if adding files and bytes exceeds
+// one of quotas.
+func checkQuotas(quotas []dirQuota, files, bytes int64) error {
+	for _, q := range quotas {
+		if files > 0 && q.MaxFiles > 0 && q.Files+files > q.MaxFiles {
+			return gfs.Error{gfs.QuotaExceeded, fmt.Sprintf("directory %v has %v files of a quota of %v", q.path, q.Files, q.MaxFiles)}
+		}
+		if bytes > 0 && q.MaxBytes > 0 && q.Bytes+bytes > q.MaxBytes {
+			return gfs.Error{gfs.QuotaExceeded, fmt.Sprintf("directory %v has %v bytes of a quota of %v", q.path, q.Bytes, q.MaxBytes)}
+		}
+	}
+	return nil
+}
+
+// checkQuota returns gfs.QuotaExceeded if adding files and bytes under the
+// directory p exceeds the quota of p or of one of its parents. p and its
+// parents should be locked in advance, as well as the nodes in held.
+func (nm *namespaceManager) checkQuota(p gfs.Path, files, bytes int64, size sizeFunc, held ...*nsTree) error {
+	quotas, err := nm.quotas(p, size, held...)
+	if err != nil {
+		return err
+	}
+	return checkQuotas(quotas, files, bytes)
+}
+
+// CheckWriteQuota returns gfs.QuotaExceeded if a parent of the file p has
+// used up its quota of bytes.
+func (nm *namespaceManager) CheckWriteQuota(p gfs.Path, size sizeFunc) error {
+	ps, _, err := nm.lockParents(p, false, nil)
+	defer nm.unlockParents(ps)
+	if err != nil {
+		return err
+	}
+	dir, _ := nm.PartionLastName(p)
+	return nm.checkQuota(dir, 0, 1, size)
+}
+
+// SetQuota sets the quotas of the directory p, 0 is unlimited. Only
+// SuperUser may.
+func (nm *namespaceManager) SetQuota(p gfs.Path, maxFiles, maxBytes int64, cred gfs.Credentials, wait *time.Duration) error {
+	if maxFiles < 0 || maxBytes < 0 {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("negative quota of %v files and %v bytes", maxFiles, maxBytes)}
+	}
+	return nm.lockNode(p, cred, wait, func(node *nsTree) error {
+		if !cred.IsSuperUser() {
+			return gfs.Error{gfs.PermissionDenied, fmt.Sprintf("user %v may not set the quota of %v", cred.Owner(), p)}
+		}
+		if !node.isDir {
+			return gfs.Error{gfs.NotDirectory, fmt.Sprintf("path %v is not a directory", p)}
+		}
+		node.maxFiles, node.maxBytes = maxFiles, maxBytes
+		node.ctime = time.Now()
+		return nil
+	})
+}
+
+// QuotaUsage returns the quotas of the directory p with its usage.
+func (nm *namespaceManager) QuotaUsage(p gfs.Path, cred gfs.Credentials, size sizeFunc, wait *time.Duration) (gfs.QuotaUsage, error) {
+	var u gfs.QuotaUsage
+	err := nm.lockNode(p, cred, wait, func(node *nsTree) error {
+		if !node.isDir {
+			return gfs.Error{gfs.NotDirectory, fmt.Sprintf("path %v is not a directory", p)}
+		}
+		u.MaxFiles, u.MaxBytes = node.maxFiles, node.maxBytes
+		node.usage(p, []*nsTree{node}, size, &u.Files, &u.Bytes)
+		return nil
+	})
+	return u, err
+}
+
+// RPCSetQuota is called by client to set the quotas of a directory
+func (m *Master) RPCSetQuota(args gfs.SetQuotaArg, reply *gfs.SetQuotaReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	return m.nm.SetQuota(args.Path, args.MaxFiles, args.MaxBytes, args.Cred, &wait)
+}
+
+// RPCGetQuotaUsage is called by client to get the quotas of a directory with its usage
+func (m *Master) RPCGetQuotaUsage(args gfs.GetQuotaUsageArg, reply *gfs.GetQuotaUsageReply) (err error) {
+	done, err := m.admit(false)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	reply.Usage, err = m.nm.QuotaUsage(args.Path, args.Cred, m.fileSize, &wait)
+	return err
+}
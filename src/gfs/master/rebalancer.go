@@ -0,0 +1,213 @@
+package master
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"gfs"
+	"gfs/util"
+	log "github.com/Sirupsen/logrus"
+)
+
+// utilization of a chunkserver is the sum of its chunk count and disk usage,
+// each relative to the mean of the cluster. 2 means average. Disk usage is
+// ignored until servers store a full chunk on average, the sizes of moved
+// chunks are estimated by the mean and mostly empty ones would skew it.
+func utilization(chunks int, diskUsed int64, meanChunks, meanDisk float64) float64 {
+	var u float64
+	if meanChunks > 0 {
+		u += float64(chunks) / meanChunks
+	}
+	if meanDisk >= gfs.MaxChunkSize {
+		u += float64(diskUsed) / meanDisk
+	} else {
+		u++
+	}
+	return u
+}
+
+// rebalance migrates chunks from the most to the least utilized chunkservers
+// until their utilization differs by less than gfs.RebalanceThreshold, moving
+// at most the configured number of chunks per round. Chunks are copied one at
+// a time and leased chunks are skipped, not to impact foreground traffic.
+// It returns the number of chunks moved.
+func (m *Master) rebalance() int {
+	if !atomic.CompareAndSwapInt32(&m.rebalancing, 0, 1) {
+		return 0 // a round is running
+	}
+	defer atomic.StoreInt32(&m.rebalancing, 0)
+
+	moves := m.rebalanceRound(func(handle gfs.ChunkHandle, from, to gfs.ServerAddress) error {
+		err := m.migrateChunk(handle, from, to)
+		if err != nil {
+			log.Infof("rebalance: cannot move chunk %v from %v to %v: %v", handle, from, to, err)
+			m.metrics.rebalanceMoves.Inc("error")
+		} else {
+			m.metrics.rebalanceMoves.Inc("success")
+		}
+		return err
+	})
+	if len(moves) > 0 {
+		log.Infof("rebalance: moved %v chunks", len(moves))
+	}
+	return len(moves)
+}
+
+// planRebalance returns the moves a rebalancing round would make now, as if
+// they all succeeded, without making them.
+func (m *Master) planRebalance() []gfs.ChunkMove {
+	return m.rebalanceRound(m.checkMigration)
+}
+
+// rebalanceRound picks the chunks to move and calls move for each of them,
+// the ones move fails for are skipped. It returns the moves done.
+func (m *Master) rebalanceRound(move func(handle gfs.ChunkHandle, from, to gfs.ServerAddress) error) []gfs.ChunkMove {
+	loads := m.csm.Loads()
+	if len(loads) < 2 {
+		return nil
+	}
+	var totalChunks, totalDisk float64
+	for _, l := range loads {
+		totalChunks += float64(len(l.chunks))
+		totalDisk += float64(l.diskUsed)
+	}
+	meanChunks, meanDisk := totalChunks/float64(len(loads)), totalDisk/float64(len(loads))
+	u := func(l *serverLoad) float64 { return utilization(len(l.chunks), l.diskUsed, meanChunks, meanDisk) }
+
+	maxMoves := int(atomic.LoadInt64(&m.rebalanceMoves))
+	var moves []gfs.ChunkMove
+	tried := make(map[gfs.ChunkHandle]bool)
+	for len(moves) < maxMoves {
+		select {
+		case <-m.shutdown:
+			return moves
+		default:
+		}
+
+		sort.Slice(loads, func(i, j int) bool { return u(loads[i]) < u(loads[j]) })
+		src, dst := loads[len(loads)-1], loads[0]
+		if u(src)-u(dst) < gfs.RebalanceThreshold || len(src.chunks) == 0 {
+			break
+		}
+
+		// the move should not make dst busier than src
+		size := src.diskUsed / int64(len(src.chunks))
+		if utilization(len(src.chunks)-1, src.diskUsed-size, meanChunks, meanDisk) <
+			utilization(len(dst.chunks)+1, dst.diskUsed+size, meanChunks, meanDisk) {
+			break
+		}
+
+		var handle gfs.ChunkHandle = -1
+		for h := range src.chunks {
+			if !dst.chunks[h] && !tried[h] {
+				handle = h
+				break
+			}
+		}
+		if handle < 0 {
+			break
+		}
+		tried[handle] = true
+
+		if err := move(handle, src.addr, dst.addr); err != nil {
+			continue
+		}
+		delete(src.chunks, handle)
+		dst.chunks[handle] = true
+		src.diskUsed -= size
+		dst.diskUsed += size
+		moves = append(moves, gfs.ChunkMove{handle, src.addr, dst.addr})
+	}
+	return moves
+}
+
+// migratable returns the chunk to be moved from a server to another, if to
+// satisfies its placement.
+func (m *Master) migratable(handle gfs.ChunkHandle, to gfs.ServerAddress) (*chunkInfo, error) {
+	// don't hold cm while locking ck, GetLeaseHolder locks them in the reverse order
+	m.cm.RLock()
+	ck, ok := m.cm.chunk[handle]
+	m.cm.RUnlock()
+	if !ok {
+		return nil, gfs.Error{gfs.ChunkNotFound, fmt.Sprintf("cannot find chunk %v", handle)}
+	}
+
+	// namespace is locked before chunks elsewhere
+	ck.RLock()
+	p := ck.path
+	ck.RUnlock()
+	constraints, _ := m.nm.Placement(p)
+	if !m.csm.Accepts(to, constraints) {
+		return nil, gfs.Error{gfs.NotAvailableForCopy, fmt.Sprintf("%v is full or does not satisfy the placement of %v", to, p)}
+	}
+	return ck, nil
+}
+
+// migrationIndex returns the index of from in the locations of a chunk that
+// can be moved to another server, i.e. not leased nor already there.
+// ck should be locked.
+func migrationIndex(handle gfs.ChunkHandle, ck *chunkInfo, from, to gfs.ServerAddress) (int, error) {
+	if ck.expire.After(time.Now()) {
+		return -1, gfs.Error{gfs.NotAvailableForCopy, fmt.Sprintf("chunk %v is leased", handle)}
+	}
+	i := -1
+	for j, v := range ck.location {
+		if v == to {
+			return -1, gfs.Error{gfs.NotAvailableForCopy, fmt.Sprintf("chunk %v is already on %v", handle, to)}
+		}
+		if v == from {
+			i = j
+		}
+	}
+	if i < 0 {
+		return -1, gfs.Error{gfs.NotAvailableForCopy, fmt.Sprintf("chunk %v is not on %v", handle, from)}
+	}
+	return i, nil
+}
+
+// checkMigration returns the error migrateChunk would fail with now, without moving the chunk.
+func (m *Master) checkMigration(handle gfs.ChunkHandle, from, to gfs.ServerAddress) error {
+	ck, err := m.migratable(handle, to)
+	if err != nil {
+		return err
+	}
+	ck.RLock()
+	defer ck.RUnlock()
+	_, err = migrationIndex(handle, ck, from, to)
+	return err
+}
+
+// migrateChunk copies a chunk from a server to another and drops the replica
+// on the former. The chunk should not be leased. If the copy fails, the chunk
+// created for it is collected as garbage.
+func (m *Master) migrateChunk(handle gfs.ChunkHandle, from, to gfs.ServerAddress) error {
+	ck, err := m.migratable(handle, to)
+	if err != nil {
+		return err
+	}
+
+	ck.Lock() // don't grant lease during copy
+	defer ck.Unlock()
+	i, err := migrationIndex(handle, ck, from, to)
+	if err != nil {
+		return err
+	}
+
+	var cr gfs.CreateChunkReply
+	// the chunk size comes with the copy
+	if err := util.Call(m.ctx, to, "ChunkServer.RPCCreateChunk", gfs.CreateChunkArg{Handle: handle, Token: m.secret.ServerToken()}, &cr); err != nil {
+		return err
+	}
+	var sr gfs.SendCopyReply
+	if err := util.CallTimeout(m.ctx, gfs.CopyTimeout, from, "ChunkServer.RPCSendCopy", gfs.SendCopyArg{handle, to, false, m.secret.ServerToken()}, &sr); err != nil {
+		m.csm.AddGarbage(to, handle) // the chunk created for the copy
+		return err
+	}
+
+	log.Infof("rebalance: moved chunk %v from %v to %v", handle, from, to)
+	ck.location[i] = to
+	m.csm.MoveChunk(handle, from, to)
+	return nil
+}
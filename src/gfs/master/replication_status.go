@@ -0,0 +1,95 @@
+package master
+
+import (
+	"sort"
+	"time"
+
+	"gfs"
+)
+
+// ReplicationStatus counts the chunks of the files under p by their live
+// replicas against the replication of their file, and lists those short of
+// it, with the backlog of re-replication of the cluster. As in Fsck, nothing
+// is locked across the counts.
+func (m *Master) ReplicationStatus(p gfs.Path) (*gfs.GetReplicationStatusReply, error) {
+	if p == "" {
+		p = "/"
+	}
+	var files []fsckFile
+	var wait time.Duration
+	err := m.nm.Walk(p, "", gfs.Credentials{}, &wait, func(path gfs.Path, node *nsTree) bool {
+		if !node.isDir {
+			files = append(files, fsckFile{path, node.chunks, node.replication()})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	reply := &gfs.GetReplicationStatusReply{Files: len(files)}
+	reply.Queued, reply.Running = m.rq.stats()
+
+	// the chunks are taken with cm locked and counted without, as in Fsck
+	chunks := make([][]fsckChunk, len(files))
+	m.cm.RLock()
+	for i, f := range files {
+		fileinfo, ok := m.cm.file[f.path]
+		if !ok {
+			continue
+		}
+		for _, handle := range fileinfo.handles {
+			chunks[i] = append(chunks[i], fsckChunk{handle, m.cm.chunk[handle]})
+		}
+	}
+	m.cm.RUnlock()
+
+	var under []gfs.UnderReplicatedChunk
+	for i, f := range files {
+		for _, c := range chunks[i] {
+			reply.Chunks++
+			var location []gfs.ServerAddress
+			if c.ck != nil {
+				c.ck.RLock()
+				location = append(location, c.ck.location...)
+				c.ck.RUnlock()
+			}
+			live := len(m.csm.Live(location))
+			switch {
+			case len(location) == 0:
+				reply.Missing++
+			case live < f.replication:
+				reply.UnderReplicated++
+			default:
+				reply.Replicated++
+				continue
+			}
+			under = append(under, gfs.UnderReplicatedChunk{c.handle, f.path, len(location), live, f.replication})
+		}
+	}
+	sort.Slice(under, func(i, j int) bool {
+		if under[i].Live != under[j].Live {
+			return under[i].Live < under[j].Live
+		}
+		return under[i].Handle < under[j].Handle
+	})
+	if len(under) > gfs.StatusMaxChunks {
+		under = under[:gfs.StatusMaxChunks]
+	}
+	reply.Under = under
+	return reply, nil
+}
+
+// RPCGetReplicationStatus is called by an admin to see how safe the data of
+// a file, a subtree or the cluster is: its chunks fully replicated, short of
+// replicas or missing, and the chunks waiting for re-replication.
+func (m *Master) RPCGetReplicationStatus(args gfs.GetReplicationStatusArg, reply *gfs.GetReplicationStatusReply) error {
+	if err := m.checkAdmin(args.Cred, "get the replication status"); err != nil {
+		return err
+	}
+	ret, err := m.ReplicationStatus(args.Path)
+	if err != nil {
+		return err
+	}
+	*reply = *ret
+	return nil
+}
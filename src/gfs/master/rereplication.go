@@ -0,0 +1,153 @@
+package master
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"gfs"
+)
+
+// reReplicationQueue schedules re-replications. Chunks with the fewest live
+// replicas go first, chunks a client is waiting for are boosted. At most max
+// copies run at a time, failed ones are retried with exponential backoff.
+type reReplicationQueue struct {
+	sync.Mutex
+	max     int
+	running int
+	tasks   map[gfs.ChunkHandle]*reReplicationTask
+}
+
+type reReplicationTask struct {
+	handle   gfs.ChunkHandle
+	replicas int  // live replicas
+	boosted  bool // a client is waiting for the chunk
+	running  bool
+	failures int
+	next     time.Time // not started before
+}
+
+func newReReplicationQueue(max int) *reReplicationQueue {
+	return &reReplicationQueue{
+		max:   max,
+		tasks: make(map[gfs.ChunkHandle]*reReplicationTask),
+	}
+}
+
+// priority is lower for more urgent tasks.
+func (t *reReplicationTask) priority() int {
+	if t.boosted {
+		return t.replicas - 1
+	}
+	return t.replicas
+}
+
+// add queues a chunk with the given number of live replicas, or updates it.
+func (q *reReplicationQueue) add(handle gfs.ChunkHandle, replicas int) {
+	q.Lock()
+	defer q.Unlock()
+	t, ok := q.tasks[handle]
+	if !ok {
+		t = &reReplicationTask{handle: handle}
+		q.tasks[handle] = t
+	}
+	t.replicas = replicas
+}
+
+// boost raises the priority of a queued chunk, it does nothing if the chunk is not queued.
+func (q *reReplicationQueue) boost(handle gfs.ChunkHandle) {
+	q.Lock()
+	defer q.Unlock()
+	if t, ok := q.tasks[handle]; ok {
+		t.boosted = true
+	}
+}
+
+// resetBackoff lets failed tasks be retried right away, e.g. when a new server joins.
+func (q *reReplicationQueue) resetBackoff() {
+	q.Lock()
+	defer q.Unlock()
+	for _, t := range q.tasks {
+		t.next = time.Time{}
+	}
+}
+
+func (q *reReplicationQueue) setMax(max int) {
+	q.Lock()
+	defer q.Unlock()
+	q.max = max
+}
+
+// len returns the number of queued chunks, including running ones.
+func (q *reReplicationQueue) len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.tasks)
+}
+
+// stats returns how many chunks are queued, running included, and how many
+// are running.
+func (q *reReplicationQueue) stats() (queued, running int) {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.tasks), q.running
+}
+
+// pop returns the chunks to be re-replicated now, the most urgent first,
+// without exceeding the concurrency cap. They are marked as running until done.
+func (q *reReplicationQueue) pop(now time.Time) []gfs.ChunkHandle {
+	q.Lock()
+	defer q.Unlock()
+
+	var ready []*reReplicationTask
+	for _, t := range q.tasks {
+		if !t.running && !t.next.After(now) {
+			ready = append(ready, t)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool {
+		if ready[i].priority() != ready[j].priority() {
+			return ready[i].priority() < ready[j].priority()
+		}
+		return ready[i].handle < ready[j].handle
+	})
+
+	var ret []gfs.ChunkHandle
+	for _, t := range ready {
+		if q.running >= q.max {
+			break
+		}
+		t.running = true
+		q.running++
+		ret = append(ret, t.handle)
+	}
+	return ret
+}
+
+// done finishes a popped chunk. If err is nil, the chunk leaves the queue.
+// Otherwise it is retried after a backoff, which is retryAt if given instead
+// of a failure, e.g. when the lease of the chunk is not expired yet.
+func (q *reReplicationQueue) done(handle gfs.ChunkHandle, err error, retryAt time.Time) {
+	q.Lock()
+	defer q.Unlock()
+	t, ok := q.tasks[handle]
+	if !ok {
+		return
+	}
+	t.running = false
+	q.running--
+
+	switch {
+	case !retryAt.IsZero():
+		t.next = retryAt
+	case err != nil:
+		t.failures++
+		backoff := gfs.ReReplicationMaxBackoff
+		if t.failures < 20 && gfs.ReReplicationBackoff<<uint(t.failures-1) < backoff {
+			backoff = gfs.ReReplicationBackoff << uint(t.failures-1)
+		}
+		t.next = time.Now().Add(backoff)
+	default:
+		delete(q.tasks, handle)
+	}
+}
@@ -0,0 +1,98 @@
+package master
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"gfs"
+)
+
+// the chunks missing the most replicas go first, boosted ones before their peers
+func TestReReplicationQueuePriority(t *testing.T) {
+	q := newReReplicationQueue(10)
+	q.add(1, 2)
+	q.add(2, 1)
+	q.add(3, 2)
+	q.add(4, 0)
+	q.add(5, 2)
+	q.boost(5)
+	q.boost(6) // not queued
+
+	now := time.Now()
+	if got, want := q.pop(now), []gfs.ChunkHandle{4, 2, 5, 1, 3}; !reflect.DeepEqual(got, want) {
+		t.Error("expect", want, "got", got)
+	}
+	if got := q.pop(now); len(got) != 0 {
+		t.Error("expect the running chunks not popped again, got", got)
+	}
+	if queued, running := q.stats(); queued != 5 || running != 5 {
+		t.Error("expect 5 queued and running, got", queued, running)
+	}
+}
+
+// no more than max copies run at a time, the others wait for one to be done
+func TestReReplicationQueueCap(t *testing.T) {
+	q := newReReplicationQueue(2)
+	for h := gfs.ChunkHandle(1); h <= 4; h++ {
+		q.add(h, int(h))
+	}
+
+	now := time.Now()
+	if got, want := q.pop(now), []gfs.ChunkHandle{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Error("expect", want, "got", got)
+	}
+	if got := q.pop(now); len(got) != 0 {
+		t.Error("expect the chunks held back by the cap, got", got)
+	}
+
+	q.done(1, nil, time.Time{})
+	if got, want := q.pop(now), []gfs.ChunkHandle{3}; !reflect.DeepEqual(got, want) {
+		t.Error("expect", want, "after a copy is done, got", got)
+	}
+	if q.len() != 3 {
+		t.Error("expect the chunk done to leave the queue, got", q.len())
+	}
+
+	q.setMax(3)
+	if got, want := q.pop(now), []gfs.ChunkHandle{4}; !reflect.DeepEqual(got, want) {
+		t.Error("expect", want, "after the cap is raised, got", got)
+	}
+}
+
+// a failed copy is retried after a backoff growing with the failures
+func TestReReplicationQueueBackoff(t *testing.T) {
+	q := newReReplicationQueue(1)
+	q.add(1, 1)
+
+	now := time.Now()
+	q.pop(now)
+	q.done(1, errors.New("copy failed"), time.Time{})
+	if got := q.pop(time.Now()); len(got) != 0 {
+		t.Error("expect the failed chunk held back, got", got)
+	}
+	retry := time.Now().Add(gfs.ReReplicationBackoff)
+	if got := q.pop(retry); !reflect.DeepEqual(got, []gfs.ChunkHandle{1}) {
+		t.Error("expect the chunk retried after the backoff, got", got)
+	}
+
+	q.done(1, errors.New("copy failed"), time.Time{})
+	if got := q.pop(retry); len(got) != 0 {
+		t.Error("expect the backoff doubled after a second failure, got", got)
+	}
+	if got := q.pop(time.Now().Add(2 * gfs.ReReplicationBackoff)); !reflect.DeepEqual(got, []gfs.ChunkHandle{1}) {
+		t.Error("expect the chunk retried after the doubled backoff, got", got)
+	}
+
+	// a retry time given wins over the backoff, a new server resets it
+	at := time.Now().Add(time.Hour)
+	q.done(1, errors.New("lease not expired"), at)
+	if got := q.pop(at.Add(-time.Second)); len(got) != 0 {
+		t.Error("expect the chunk held back until the retry time, got", got)
+	}
+	q.resetBackoff()
+	if got := q.pop(now); !reflect.DeepEqual(got, []gfs.ChunkHandle{1}) {
+		t.Error("expect the chunk retried once the backoff is reset, got", got)
+	}
+}
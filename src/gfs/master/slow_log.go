@@ -0,0 +1,214 @@
+package master
+
+import (
+	"fmt"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"time"
+
+	"gfs"
+	log "github.com/Sirupsen/logrus"
+)
+
+// slowLog records master rpcs whose latency exceeds a threshold.
+// Latency, arguments and caller are taken from the server codec of each
+// connection, lock wait times are reported by the handlers of the calls
+// answered with a reply, failed ones are logged without them.
+type slowLog struct {
+	sync.Mutex
+	threshold time.Duration
+	entries   []gfs.SlowQuery // ring buffer of the latest entries
+	next      int
+	size      int
+
+	// lock wait of handlers whose reply has not been written yet, by reply.
+	// The reply of a failed call is not written, its handler adds no entry.
+	// Handlers called without a codec leave entries here, so it is bounded.
+	lockWaits map[interface{}]time.Duration
+}
+
+const maxPendingLockWaits = 1024
+
+// unlogged are the rpcs never recorded: reading the log, and the heartbeats
+// of chunkservers, which would crowd out the queries of clients.
+var unlogged = map[string]bool{
+	"Master.RPCGetSlowQueries": true,
+	"Master.RPCHeartbeat":      true,
+}
+
+func newSlowLog(threshold time.Duration, size int) *slowLog {
+	return &slowLog{
+		threshold: threshold,
+		size:      size,
+		lockWaits: make(map[interface{}]time.Duration),
+	}
+}
+
+func (sl *slowLog) setThreshold(threshold time.Duration) {
+	sl.Lock()
+	defer sl.Unlock()
+	sl.threshold = threshold
+}
+
+// addLockWait is called by a handler before it returns its error err, reply
+// identifies the call.
+func (sl *slowLog) addLockWait(reply interface{}, wait *time.Duration, err *error) {
+	if *err != nil {
+		return // net/rpc writes no reply, nothing would take the entry
+	}
+	sl.Lock()
+	defer sl.Unlock()
+	if len(sl.lockWaits) >= maxPendingLockWaits {
+		sl.lockWaits = make(map[interface{}]time.Duration)
+	}
+	sl.lockWaits[reply] = *wait
+}
+
+// finish records a finished call if it is slow.
+func (sl *slowLog) finish(call *slowLogCall, caller string, resp *rpc.Response, reply interface{}) {
+	latency := time.Since(call.start)
+
+	sl.Lock()
+	defer sl.Unlock()
+
+	wait := sl.lockWaits[reply]
+	delete(sl.lockWaits, reply)
+	if latency < sl.threshold || unlogged[resp.ServiceMethod] {
+		return
+	}
+
+	q := gfs.SlowQuery{
+		Method:   resp.ServiceMethod,
+		Caller:   caller,
+		Start:    call.start,
+		Latency:  latency,
+		LockWait: wait,
+		Error:    resp.Error,
+	}
+	if call.args != nil {
+		q.Args = fmt.Sprintf("%+v", redact(reflect.Indirect(reflect.ValueOf(call.args))).Interface())
+	}
+	log.Warningf("slow query %v from %v took %v (lock wait %v) args: %v", q.Method, q.Caller, q.Latency, q.LockWait, q.Args)
+
+	if len(sl.entries) < sl.size {
+		sl.entries = append(sl.entries, q)
+	} else {
+		sl.entries[sl.next] = q
+	}
+	sl.next = (sl.next + 1) % sl.size
+}
+
+// redact returns a copy of v with its Token fields blanked, the ones of its
+// credentials and of its elements included, so that the log gives away no
+// token of a client or a server.
+func redact(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < c.NumField(); i++ {
+			f := c.Field(i)
+			if !f.CanSet() {
+				continue
+			}
+			if c.Type().Field(i).Name == "Token" && f.Kind() == reflect.String {
+				f.SetString("")
+			} else {
+				f.Set(redact(f))
+			}
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		if k := v.Type().Elem().Kind(); k != reflect.Struct && k != reflect.Slice {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(redact(v.Index(i)))
+		}
+		return c
+	}
+	return v
+}
+
+// get returns at most limit latest entries, newest first. 0 means all.
+func (sl *slowLog) get(limit int) []gfs.SlowQuery {
+	sl.Lock()
+	defer sl.Unlock()
+
+	n := len(sl.entries)
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+	ret := make([]gfs.SlowQuery, 0, limit)
+	for i := 1; i <= limit; i++ {
+		ret = append(ret, sl.entries[(sl.next-i+n)%n])
+	}
+	return ret
+}
+
+// wrap returns a codec that times every call served on the connection from caller.
+func (sl *slowLog) wrap(codec rpc.ServerCodec, caller string) rpc.ServerCodec {
+	return &slowLogCodec{
+		ServerCodec: codec,
+		sl:          sl,
+		caller:      caller,
+		calls:       make(map[uint64]*slowLogCall),
+	}
+}
+
+type slowLogCall struct {
+	start time.Time
+	args  interface{}
+}
+
+// slowLogCodec is a server codec that times calls. Requests are read by
+// a single goroutine, responses are written by the handlers.
+type slowLogCodec struct {
+	rpc.ServerCodec
+	sl     *slowLog
+	caller string
+
+	sync.Mutex
+	calls map[uint64]*slowLogCall // by sequence number
+	last  *slowLogCall            // whose body is to be read
+}
+
+func (c *slowLogCodec) ReadRequestHeader(r *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(r)
+	if err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.last = &slowLogCall{start: time.Now()}
+	c.calls[r.Seq] = c.last
+	return nil
+}
+
+func (c *slowLogCodec) ReadRequestBody(body interface{}) error {
+	err := c.ServerCodec.ReadRequestBody(body)
+	c.Lock()
+	defer c.Unlock()
+	if c.last != nil {
+		c.last.args = body
+		c.last = nil
+	}
+	return err
+}
+
+func (c *slowLogCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.Lock()
+	call, ok := c.calls[r.Seq]
+	delete(c.calls, r.Seq)
+	c.Unlock()
+
+	if ok {
+		c.sl.finish(call, c.caller, r, body)
+	}
+	return c.ServerCodec.WriteResponse(r, body)
+}
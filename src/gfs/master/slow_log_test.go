@@ -0,0 +1,35 @@
+package master
+
+import (
+	"net/rpc"
+	"testing"
+	"time"
+
+	"gfs"
+)
+
+// the lock wait of a call is taken by its response, failed calls leave none behind
+func TestSlowLogLockWaits(t *testing.T) {
+	sl := newSlowLog(0, 10)
+
+	for i := 0; i < 2*maxPendingLockWaits; i++ {
+		var err error = gfs.Error{gfs.PathNotFound, "not found"}
+		wait := time.Second
+		sl.addLockWait(&gfs.GetFileInfoReply{}, &wait, &err)
+	}
+	if len(sl.lockWaits) != 0 {
+		t.Error("expect no lock wait kept for failed calls, got", len(sl.lockWaits))
+	}
+
+	reply := &gfs.GetFileInfoReply{}
+	var err error
+	wait := time.Second
+	sl.addLockWait(reply, &wait, &err)
+	sl.finish(&slowLogCall{start: time.Now()}, "client", &rpc.Response{ServiceMethod: "Master.RPCGetFileInfo"}, reply)
+	if len(sl.lockWaits) != 0 {
+		t.Error("expect the lock wait taken by the response, got", len(sl.lockWaits))
+	}
+	if q := sl.get(1); len(q) != 1 || q[0].LockWait != wait {
+		t.Error("expect the call logged with its lock wait, got", q)
+	}
+}
@@ -0,0 +1,104 @@
+package master
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"time"
+
+	"gfs"
+	log "github.com/Sirupsen/logrus"
+)
+
+// masterStatus is shown on the /status page of master.
+type masterStatus struct {
+	Address         gfs.ServerAddress
+	Build           gfs.BuildInfo
+	Time            time.Time
+	Mode            gfs.MasterMode
+	Servers         []serverStatus
+	Chunks          int
+	Files           int
+	NeedReplicas    int // chunks in the re-replication queue
+	UnderReplicated []underReplicatedChunk
+	Namespace       namespaceStats
+}
+
+type serverStatus struct {
+	gfs.ServerInfo
+	Lag time.Duration // since the last heartbeat
+}
+
+var masterStatusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head><title>gfs master {{.Address}}</title></head>
+<body>
+<h1>gfs master {{.Address}}</h1>
+<p>{{.Build}}, features: {{range .Build.Features}}{{.}} {{end}}</p>
+<p>{{.Time.Format "2006-01-02 15:04:05"}}, {{.Mode}} mode</p>
+
+<h2>Chunkservers ({{len .Servers}})</h2>
+<table border="1">
+<tr><th>Address</th><th>Version</th><th>Domain</th><th>Labels</th><th>Topology</th><th>Chunks</th><th>Capacity</th><th>Disk Used</th><th>Disk Free</th><th>IO Load (B/s)</th><th>Leases</th><th>Draining</th><th>Last Heartbeat</th></tr>
+{{range .Servers}}<tr><td>{{.Address}}</td><td>{{.Version}}</td><td>{{.Domain}}</td><td>{{.LabelString}}</td><td>{{.Topology}}</td><td>{{.Chunks}}</td><td>{{.Capacity}}</td><td>{{.DiskUsed}}</td><td>{{.DiskFree}}</td><td>{{.IOLoad}}</td><td>{{.Leases}}</td><td>{{.Draining}}</td><td>{{.Lag}} ago</td></tr>
+{{end}}</table>
+
+<h2>Chunks</h2>
+<p>{{.Chunks}} chunks of {{.Files}} files, {{.NeedReplicas}} waiting for re-replication.</p>
+<h3>Under-replicated ({{len .UnderReplicated}})</h3>
+<table border="1">
+<tr><th>Handle</th><th>Path</th><th>Replicas</th></tr>
+{{range .UnderReplicated}}<tr><td>{{.Handle}}</td><td>{{.Path}}</td><td>{{range .Replicas}}{{.}} {{end}}</td></tr>
+{{end}}</table>
+
+<h2>Namespace</h2>
+<table border="1">
+<tr><td>Directories</td><td>{{.Namespace.Dirs}}</td></tr>
+<tr><td>Files</td><td>{{.Namespace.Files}}</td></tr>
+<tr><td>Deleted</td><td>{{.Namespace.Deleted}}</td></tr>
+<tr><td>Bytes</td><td>{{.Namespace.Bytes}}</td></tr>
+</table>
+</body>
+</html>
+`))
+
+// status collects the state shown on the status page.
+func (m *Master) status() masterStatus {
+	now := time.Now()
+	st := masterStatus{
+		Address:         m.address,
+		Build:           gfs.Build(features...),
+		Time:            now,
+		Mode:            m.Mode(),
+		UnderReplicated: m.cm.UnderReplicated(),
+		Namespace:       m.nm.Stats(),
+	}
+
+	for _, v := range m.csm.List() {
+		st.Servers = append(st.Servers, serverStatus{v, now.Sub(v.LastHeartbeat).Round(time.Millisecond)})
+	}
+
+	m.cm.RLock()
+	st.Chunks = len(m.cm.chunk)
+	st.Files = len(m.cm.file)
+	m.cm.RUnlock()
+	st.NeedReplicas = m.rq.len()
+	return st
+}
+
+func (m *Master) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := masterStatusTemplate.Execute(w, m.status()); err != nil {
+		log.Warning("error in status page: ", err)
+	}
+}
+
+// serveTopology serves the topology of the cluster as returned by RPCGetTopology.
+func (m *Master) serveTopology(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m.topology()); err != nil {
+		log.Warning("error in topology document: ", err)
+	}
+}
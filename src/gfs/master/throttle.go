@@ -0,0 +1,115 @@
+package master
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"gfs"
+	"gfs/util"
+)
+
+// throttler holds the throttle policies of directories. The policy of the
+// nearest directory of a path applies to it, overriding the ones above.
+// Creates are limited here, appends by the primaries, which get the policy
+// of a chunk along with its lease.
+type throttler struct {
+	sync.Mutex
+	rules map[gfs.Path]*throttleRule // by directory, "" for the root
+}
+
+type throttleRule struct {
+	gfs.ThrottlePolicy
+	creates *util.RateLimiter
+}
+
+func newThrottler() *throttler {
+	return &throttler{rules: make(map[gfs.Path]*throttleRule)}
+}
+
+// throttleKey returns the key of the directory p in rules.
+func throttleKey(p gfs.Path) gfs.Path {
+	return gfs.Path(strings.TrimSuffix(path.Clean(string(p)), "/"))
+}
+
+// Set sets the policy of its directory, an unlimited policy removes it.
+// The directory does not need to exist.
+func (t *throttler) Set(policy gfs.ThrottlePolicy) error {
+	if !strings.HasPrefix(string(policy.Path), "/") {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("throttle path %v is not absolute", policy.Path)}
+	}
+	if policy.CreatesPerSec < 0 || policy.AppendsPerSec < 0 {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("negative rate in throttle policy of %v", policy.Path)}
+	}
+	policy.Path = gfs.Path(path.Clean(string(policy.Path)))
+
+	t.Lock()
+	defer t.Unlock()
+	key := throttleKey(policy.Path)
+	if policy.Unlimited() {
+		delete(t.rules, key)
+		return nil
+	}
+	if r, ok := t.rules[key]; ok {
+		r.ThrottlePolicy = policy
+		r.creates.SetRate(policy.CreatesPerSec)
+	} else {
+		t.rules[key] = &throttleRule{policy, util.NewRateLimiter(policy.CreatesPerSec)}
+	}
+	return nil
+}
+
+// List returns the policies sorted by path.
+func (t *throttler) List() []gfs.ThrottlePolicy {
+	t.Lock()
+	defer t.Unlock()
+	ret := make([]gfs.ThrottlePolicy, 0, len(t.rules))
+	for _, r := range t.rules {
+		ret = append(ret, r.ThrottlePolicy)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Path < ret[j].Path })
+	return ret
+}
+
+// rule returns the rule of the nearest directory of p, t must be locked.
+func (t *throttler) rule(p gfs.Path) *throttleRule {
+	for q := throttleKey(p); ; {
+		if r, ok := t.rules[q]; ok {
+			return r
+		}
+		i := strings.LastIndex(string(q), "/")
+		if i < 0 {
+			return nil
+		}
+		q = q[:i]
+	}
+}
+
+// Policy returns the policy applying to p, the zero value if there is none.
+func (t *throttler) Policy(p gfs.Path) gfs.ThrottlePolicy {
+	t.Lock()
+	defer t.Unlock()
+	if r := t.rule(p); r != nil {
+		return r.ThrottlePolicy
+	}
+	return gfs.ThrottlePolicy{}
+}
+
+// AllowCreate takes a create of p from the policy applying to it. It fails
+// with gfs.Throttled if the policy allows no more for now.
+func (t *throttler) AllowCreate(p gfs.Path) error {
+	t.Lock()
+	r := t.rule(p)
+	if r == nil {
+		t.Unlock()
+		return nil
+	}
+	policy := r.ThrottlePolicy
+	t.Unlock()
+	if r.creates.Allow() {
+		return nil
+	}
+	return gfs.Error{gfs.Throttled, fmt.Sprintf("creates under %v are limited to %v per second", policy.Path, policy.CreatesPerSec)}
+}
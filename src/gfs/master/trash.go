@@ -0,0 +1,294 @@
+package master
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gfs"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Deleted paths are moved into gfs.TrashDir, under a directory named by the
+// time of the deletion which holds the parents of the path, e.g. /a/b is
+// kept as /.trash/20060102T150405.000000000Z/a/b, from where it can be
+// undeleted. The directories of the trash are removed for good once they are
+// older than the retention, and so are the paths deleted inside the trash.
+// The chunks of the files removed for good are collected as garbage.
+
+// trashTimeFormat names the directories of the trash, sorted by time.
+const trashTimeFormat = "20060102T150405.000000000Z"
+
+// trashName is the name of gfs.TrashDir in the root.
+var trashName = string(gfs.TrashDir[1:])
+
+// moveFunc is called on each file moved in the namespace, with its path
+// before and after, after being empty if the file is removed for good. It is
+// called with the namespace locked.
+type moveFunc func(from, to gfs.Path)
+
+// inTrash returns whether p is inside the trash.
+func inTrash(p gfs.Path) bool {
+	return strings.HasPrefix(string(p), string(gfs.TrashDir)+"/")
+}
+
+// ensureTrash creates the trash if it is missing, e.g. in a namespace stored
+// before, a file in its way is deleted lazily. nm.root should be locked in
+// advance.
+func (nm *namespaceManager) ensureTrash() {
+	trash, ok := nm.root.children[trashName]
+	if !ok || !trash.isDir {
+		if ok {
+			log.Warningf("file %v is in the way of the trash, deleted", gfs.TrashDir)
+			nm.root.children[gfs.DeletedFilePrefix+trashName] = trash
+		}
+		now := time.Now()
+		trash = &nsTree{isDir: true,
+			children: make(map[string]*nsTree),
+			btime:    now,
+			mtime:    now,
+			ctime:    now,
+			owner:    gfs.SuperUser,
+			group:    gfs.SuperUser,
+			mode:     gfs.DefaultDirMode}
+		nm.root.children[trashName] = trash
+	}
+	trash.trash = true
+	trash.protected = true
+}
+
+// files calls fn on the path of each file in the subtree of node at p,
+// node included, deleted ones as well. node should be locked in advance, or
+// unreachable.
+func (node *nsTree) files(p gfs.Path, fn func(p gfs.Path)) {
+	if !node.isDir {
+		fn(p)
+		return
+	}
+	for name, child := range node.children {
+		child.RLock()
+		child.files(p+"/"+gfs.Path(name), fn)
+		child.RUnlock()
+	}
+}
+
+// toTrash puts node, deleted from p, into a new directory of the trash and
+// returns its path there. The directories made for the parents of p are
+// owned by cred and closed to others. The parents of p should be locked in
+// advance, the trash is locked after them.
+func (nm *namespaceManager) toTrash(p gfs.Path, node *nsTree, cred gfs.Credentials, now time.Time, moved moveFunc, wait *time.Duration) gfs.Path {
+	trash := nm.root.children[trashName]
+	trash.lock(wait)
+	defer trash.Unlock()
+
+	stamp := now.UTC()
+	for trash.children[stamp.Format(trashTimeFormat)] != nil {
+		stamp = stamp.Add(time.Nanosecond)
+	}
+	mkdir := func(dir *nsTree, name string) *nsTree {
+		child := (&nsTree{isDir: true, children: make(map[string]*nsTree), btime: now, mtime: now, ctime: now}).own(trash, cred)
+		child.mode = 0700
+		dir.children[name] = child
+		return child
+	}
+	to := gfs.TrashDir + "/" + gfs.Path(stamp.Format(trashTimeFormat))
+	dir := mkdir(trash, stamp.Format(trashTimeFormat))
+	names := splitPath(p)
+	for _, name := range names[:len(names)-1] {
+		dir = mkdir(dir, name)
+		to += "/" + gfs.Path(name)
+	}
+	to += "/" + gfs.Path(names[len(names)-1])
+	dir.children[names[len(names)-1]] = node
+	trash.modified(now)
+
+	node.files(p, func(f gfs.Path) { moved(f, to+f[len(p):]) })
+	return to
+}
+
+// Undelete moves p, inside a directory of the trash, back to where it was
+// deleted from and returns that path. The parent of that path should exist,
+// cred should be allowed to create in it and to remove p from the trash, and
+// the quotas of the parents should leave room for p, sized by size.
+func (nm *namespaceManager) Undelete(p gfs.Path, cred gfs.Credentials, moved moveFunc, size sizeFunc, wait *time.Duration) (gfs.Path, error) {
+	names := splitPath(p)
+	if !inTrash(p) || len(names) < 3 {
+		return "", gfs.Error{gfs.InvalidArgument, fmt.Sprintf("path %v is not deleted in %v", p, gfs.TrashDir)}
+	}
+	to := gfs.Path("/" + strings.Join(names[2:], "/"))
+	if to == gfs.TrashDir || inTrash(to) {
+		return "", gfs.Error{gfs.InvalidArgument, fmt.Sprintf("path %v cannot be restored into the trash", p)}
+	}
+	toDir, filename := nm.PartionLastName(to)
+
+	ps, cwd, err := nm.lockParents(toDir, true, wait)
+	defer nm.unlockParents(ps)
+	if err != nil {
+		return "", err
+	}
+	if err := nm.searchParents(ps, cred); err != nil {
+		return "", err
+	}
+	cwd.lock(wait)
+	defer cwd.Unlock()
+	if _, ok := cwd.children[filename]; ok {
+		return "", gfs.Error{gfs.PathExists, fmt.Sprintf("path %v already exists", to)}
+	}
+	if err := cwd.checkAccess(toDir, cred, permWrite|permExec); err != nil {
+		return "", err
+	}
+	// the usage under the quotas is taken before the trash is locked
+	quotas, err := nm.quotas(toDir, size)
+	if err != nil {
+		return "", err
+	}
+
+	// the trash is locked after the path restored to, as in Delete
+	trash := nm.root.children[trashName]
+	trash.rlock(wait)
+	defer trash.RUnlock()
+	dir, dirPath := trash, gfs.TrashDir
+	for i, name := range names[1 : len(names)-1] {
+		child, ok := dir.children[name]
+		if !ok || !child.isDir {
+			return "", gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %v not found", p)}
+		}
+		dir, dirPath = child, dirPath+"/"+gfs.Path(name)
+		if i == len(names)-3 { // the parent of p
+			dir.lock(wait)
+			defer dir.Unlock()
+		} else {
+			dir.rlock(wait)
+			defer dir.RUnlock()
+		}
+		if err := dir.checkAccess(dirPath, cred, permExec); err != nil {
+			return "", err
+		}
+	}
+	name := names[len(names)-1]
+	node, ok := dir.children[name]
+	if !ok {
+		return "", gfs.Error{gfs.PathNotFound, fmt.Sprintf("path %v not found", p)}
+	}
+	if err := dir.checkAccess(dirPath, cred, permWrite|permExec); err != nil {
+		return "", err
+	}
+	files, bytes := int64(1), size(p, node)
+	if node.isDir {
+		files, bytes = 0, 0
+		node.usage(p, nil, size, &files, &bytes)
+	}
+	if err := checkQuotas(quotas, files, bytes); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	delete(dir.children, name)
+	dir.modified(now)
+	if dir.isEmpty() {
+		dir.emptySince = now
+	}
+	cwd.children[filename] = node
+	cwd.modified(now)
+	node.files(p, func(f gfs.Path) { moved(f, to+f[len(p):]) })
+	log.Infof("undelete %v to %v", p, to)
+	return to, nil
+}
+
+// ReclaimTrash removes for good the directories of the trash for deletions
+// at least retention old, and returns them with the chunks of their files
+// by chunks. Entries of the trash not named by a time are kept. With dryRun
+// nothing is removed.
+func (nm *namespaceManager) ReclaimTrash(retention time.Duration, dryRun bool, chunks func(gfs.Path) int, moved moveFunc) []gfs.TrashChunks {
+	nm.root.RLock()
+	defer nm.root.RUnlock()
+	trash := nm.root.children[trashName]
+	trash.Lock()
+	defer trash.Unlock()
+
+	now := time.Now()
+	var ret []gfs.TrashChunks
+	for name, dir := range trash.children {
+		stamp, err := time.Parse(trashTimeFormat, name)
+		if err != nil || now.Sub(stamp) < retention {
+			continue
+		}
+		p := gfs.TrashDir + "/" + gfs.Path(name)
+		reclaimed := gfs.TrashChunks{p, 0}
+		dir.files(p, func(f gfs.Path) { reclaimed.Chunks += chunks(f) })
+		ret = append(ret, reclaimed)
+		if !dryRun {
+			delete(trash.children, name)
+			dir.files(p, func(f gfs.Path) { moved(f, "") })
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Path < ret[j].Path })
+	if len(ret) > 0 && !dryRun {
+		trash.modified(now)
+		log.Infof("reclaimed %v directories of %v", len(ret), gfs.TrashDir)
+	}
+	return ret
+}
+
+// fileChunks returns the number of chunks of a file.
+func (m *Master) fileChunks(path gfs.Path) int {
+	return len(m.cm.FileChunks(path))
+}
+
+// SetTrashRetention sets how long deleted paths are kept in gfs.TrashDir
+// before they are removed for good in the background. 0 removes them right
+// away.
+func (m *Master) SetTrashRetention(retention time.Duration) {
+	atomic.StoreInt64(&m.trashRetention, int64(retention))
+}
+
+// moveFile follows a file moved in the namespace with its chunks. If it is
+// removed for good, its chunks are released and their replicas collected as
+// garbage.
+func (m *Master) moveFile(from, to gfs.Path) {
+	if to != "" {
+		m.cm.RenameFile(from, to)
+		return
+	}
+	for handle, locations := range m.cm.RemoveFile(from) {
+		for _, addr := range locations {
+			m.csm.DropChunk(handle, addr)
+		}
+	}
+}
+
+// RPCUndelete is called by client to move a path out of the trash, back to where it was deleted from
+func (m *Master) RPCUndelete(args gfs.UndeleteArg, reply *gfs.UndeleteReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	reply.Path, err = m.nm.Undelete(args.Path, args.Cred, m.moveFile, m.fileSize, &wait)
+	return err
+}
+
+// RPCReclaimTrash removes for good the paths deleted at least args.MinAge
+// ago, or returns them only with args.DryRun.
+func (m *Master) RPCReclaimTrash(args gfs.ReclaimTrashArg, reply *gfs.ReclaimTrashReply) error {
+	done, err := m.admit(!args.DryRun)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := m.checkAdmin(args.Cred, "reclaim the trash"); err != nil {
+		return err
+	}
+	reply.Dirs = m.nm.ReclaimTrash(args.MinAge, args.DryRun, m.fileChunks, m.moveFile)
+	if !args.DryRun {
+		reply.Removed = len(reply.Dirs)
+	}
+	return nil
+}
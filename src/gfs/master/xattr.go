@@ -0,0 +1,180 @@
+package master
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gfs"
+)
+
+// Extended attributes are small names and values applications set on files
+// and directories, e.g. schema versions, checksums or provenance, stored by
+// master with the rest of the metadata of the path. Setting and removing
+// them takes write permission on the path, reading them read permission.
+// The map of a node is replaced rather than changed, so that the metadata
+// being stored can share it.
+
+// checkXattrName returns gfs.InvalidArgument unless name may name an
+// extended attribute.
+func checkXattrName(name string) error {
+	if name == "" || len(name) > gfs.XattrMaxName {
+		return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("xattr name %q is empty or longer than %v bytes", name, gfs.XattrMaxName)}
+	}
+	return nil
+}
+
+// xattrBytes returns the bytes of the names and values of the extended
+// attributes of node. node should be locked in advance.
+func (node *nsTree) xattrBytes() int {
+	n := 0
+	for name, value := range node.xattrs {
+		n += len(name) + len(value)
+	}
+	return n
+}
+
+// SetXattr sets the extended attribute name of p to value.
+func (nm *namespaceManager) SetXattr(p gfs.Path, name string, value []byte, cred gfs.Credentials, wait *time.Duration) error {
+	if err := checkXattrName(name); err != nil {
+		return err
+	}
+	return nm.lockNode(p, cred, wait, func(node *nsTree) error {
+		if err := node.checkAccess(p, cred, permWrite); err != nil {
+			return err
+		}
+		size := node.xattrBytes() + len(name) + len(value)
+		if old, ok := node.xattrs[name]; ok {
+			size -= len(name) + len(old)
+		}
+		if size > gfs.XattrMaxBytes {
+			return gfs.Error{gfs.InvalidArgument, fmt.Sprintf("xattrs of %v would take %v bytes > %v", p, size, gfs.XattrMaxBytes)}
+		}
+		xattrs := make(map[string][]byte, len(node.xattrs)+1)
+		for k, v := range node.xattrs {
+			xattrs[k] = v
+		}
+		xattrs[name] = append([]byte{}, value...)
+		node.xattrs = xattrs
+		node.ctime = time.Now()
+		return nil
+	})
+}
+
+// GetXattr returns the value of the extended attribute name of p.
+func (nm *namespaceManager) GetXattr(p gfs.Path, name string, cred gfs.Credentials, wait *time.Duration) ([]byte, error) {
+	var value []byte
+	err := nm.rlockNode(p, cred, wait, func(node *nsTree) error {
+		if err := node.checkAccess(p, cred, permRead); err != nil {
+			return err
+		}
+		var ok bool
+		if value, ok = node.xattrs[name]; !ok {
+			return gfs.Error{gfs.XattrNotFound, fmt.Sprintf("xattr %q of %v not set", name, p)}
+		}
+		return nil
+	})
+	return value, err
+}
+
+// ListXattrs returns the names of the extended attributes of p, sorted.
+func (nm *namespaceManager) ListXattrs(p gfs.Path, cred gfs.Credentials, wait *time.Duration) ([]string, error) {
+	var names []string
+	err := nm.rlockNode(p, cred, wait, func(node *nsTree) error {
+		if err := node.checkAccess(p, cred, permRead); err != nil {
+			return err
+		}
+		for name := range node.xattrs {
+			names = append(names, name)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+// RemoveXattr removes the extended attribute name of p.
+func (nm *namespaceManager) RemoveXattr(p gfs.Path, name string, cred gfs.Credentials, wait *time.Duration) error {
+	return nm.lockNode(p, cred, wait, func(node *nsTree) error {
+		if err := node.checkAccess(p, cred, permWrite); err != nil {
+			return err
+		}
+		if _, ok := node.xattrs[name]; !ok {
+			return gfs.Error{gfs.XattrNotFound, fmt.Sprintf("xattr %q of %v not set", name, p)}
+		}
+		var xattrs map[string][]byte
+		if len(node.xattrs) > 1 {
+			xattrs = make(map[string][]byte, len(node.xattrs)-1)
+			for k, v := range node.xattrs {
+				if k != name {
+					xattrs[k] = v
+				}
+			}
+		}
+		node.xattrs = xattrs
+		node.ctime = time.Now()
+		return nil
+	})
+}
+
+// RPCSetXattr is called by client to set an extended attribute of a file or a directory
+func (m *Master) RPCSetXattr(args gfs.SetXattrArg, reply *gfs.SetXattrReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	return m.nm.SetXattr(args.Path, args.Name, args.Value, args.Cred, &wait)
+}
+
+// RPCGetXattr is called by client to get an extended attribute of a file or a directory
+func (m *Master) RPCGetXattr(args gfs.GetXattrArg, reply *gfs.GetXattrReply) (err error) {
+	done, err := m.admit(false)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	reply.Value, err = m.nm.GetXattr(args.Path, args.Name, args.Cred, &wait)
+	return err
+}
+
+// RPCListXattrs is called by client to list the extended attributes of a file or a directory
+func (m *Master) RPCListXattrs(args gfs.ListXattrsArg, reply *gfs.ListXattrsReply) (err error) {
+	done, err := m.admit(false)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	reply.Names, err = m.nm.ListXattrs(args.Path, args.Cred, &wait)
+	return err
+}
+
+// RPCRemoveXattr is called by client to remove an extended attribute of a file or a directory
+func (m *Master) RPCRemoveXattr(args gfs.RemoveXattrArg, reply *gfs.RemoveXattrReply) (err error) {
+	done, err := m.admit(true)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var wait time.Duration
+	defer m.slowLog.addLockWait(reply, &wait, &err)
+	if err := m.authenticate(&args.Cred); err != nil {
+		return err
+	}
+	return m.nm.RemoveXattr(args.Path, args.Name, args.Cred, &wait)
+}
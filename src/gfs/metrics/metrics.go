@@ -0,0 +1,242 @@
+// Package metrics keeps counters, histograms and gauges of a server and
+// exposes them over HTTP in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are histogram buckets in seconds, suitable for rpc latencies.
+var DefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry is a set of metrics. It is an http.Handler serving them.
+type Registry struct {
+	sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.Lock()
+	defer r.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes all metrics in the order they are registered.
+func (r *Registry) Write(w io.Writer) {
+	r.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.Write(w)
+}
+
+// series is a set of values by label values.
+type series struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (s *series) header(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, kind)
+}
+
+func key(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelString formats names and values as {a="x",b="y"}, extra is appended as is.
+func labelString(names, values []string, extra string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escaper.Replace(values[i])))
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprint(v)
+}
+
+// Counter is a monotonically increasing value, partitioned by labels.
+type Counter struct {
+	series
+	sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		series: series{name, help, labels},
+		values: make(map[string]float64),
+		keys:   make(map[string][]string),
+	}
+	r.register(c)
+	return c
+}
+
+// Add adds v to the counter of the label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	k := key(labelValues)
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.keys[k]; !ok {
+		c.keys[k] = append([]string(nil), labelValues...)
+	}
+	c.values[k] += v
+}
+
+// Inc adds 1 to the counter of the label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.Lock()
+	defer c.Unlock()
+	c.header(w, "counter")
+	for _, k := range sortedKeys(c.keys) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelString(c.labels, c.keys[k], ""), formatFloat(c.values[k]))
+	}
+}
+
+// Histogram counts observations in buckets, partitioned by labels.
+type Histogram struct {
+	series
+	sync.Mutex
+	buckets []float64
+	values  map[string]*histogramValue
+	keys    map[string][]string
+}
+
+type histogramValue struct {
+	counts []uint64 // by bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the given upper bounds of buckets,
+// which should be sorted, and label names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		series:  series{name, help, labels},
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+		keys:    make(map[string][]string),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe adds an observation of the label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	k := key(labelValues)
+	h.Lock()
+	defer h.Unlock()
+	hv, ok := h.values[k]
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[k] = hv
+		h.keys[k] = append([]string(nil), labelValues...)
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		hv.counts[i]++
+	}
+	hv.count++
+	hv.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+	h.header(w, "histogram")
+	for _, k := range sortedKeys(h.keys) {
+		hv, values := h.values[k], h.keys[k]
+		var n uint64
+		for i, le := range h.buckets {
+			n += hv.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(h.labels, values, `le="`+formatFloat(le)+`"`), n)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(h.labels, values, `le="+Inf"`), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelString(h.labels, values, ""), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelString(h.labels, values, ""), hv.count)
+	}
+}
+
+// gaugeFunc is a gauge whose values are read when metrics are written.
+type gaugeFunc struct {
+	series
+	f func() map[string]float64
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by f.
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.register(&gaugeFunc{series{name, help, nil}, func() map[string]float64 {
+		return map[string]float64{"": f()}
+	}})
+}
+
+// NewGaugeVecFunc registers a gauge with a single label, f returns the values by label value.
+func (r *Registry) NewGaugeVecFunc(name, help, label string, f func() map[string]float64) {
+	r.register(&gaugeFunc{series{name, help, []string{label}}, f})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	g.header(w, "gauge")
+	values := g.f()
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var labels string
+		if len(g.labels) > 0 {
+			labels = labelString(g.labels, []string{k}, "")
+		}
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labels, formatFloat(values[k]))
+	}
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"net/rpc"
+	"sync"
+	"time"
+
+	"gfs"
+)
+
+// RPC counts served rpcs by method and result, and their latencies by method.
+type RPC struct {
+	requests *Counter
+	duration *Histogram
+}
+
+// NewRPC registers the rpc metrics.
+func (r *Registry) NewRPC() *RPC {
+	return &RPC{
+		requests: r.NewCounter("gfs_rpc_requests_total", "RPCs served, by method and result.", "method", "code"),
+		duration: r.NewHistogram("gfs_rpc_duration_seconds", "Latency of served RPCs.", DefBuckets, "method"),
+	}
+}
+
+// Wrap returns a codec that records every call served on the connection.
+func (m *RPC) Wrap(codec rpc.ServerCodec) rpc.ServerCodec {
+	return &rpcCodec{ServerCodec: codec, m: m, start: make(map[uint64]time.Time)}
+}
+
+// rpcCodec is a server codec that times calls. Requests are read by
+// a single goroutine, responses are written by the handlers.
+type rpcCodec struct {
+	rpc.ServerCodec
+	m *RPC
+
+	sync.Mutex
+	start map[uint64]time.Time // by sequence number
+}
+
+func (c *rpcCodec) ReadRequestHeader(r *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(r)
+	if err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.start[r.Seq] = time.Now()
+	return nil
+}
+
+func (c *rpcCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.Lock()
+	start, ok := c.start[r.Seq]
+	delete(c.start, r.Seq)
+	c.Unlock()
+
+	if ok {
+		c.m.record(r.ServiceMethod, start, r.Error)
+	}
+	return c.ServerCodec.WriteResponse(r, body)
+}
+
+// Record records a call of method served from start on by other means
+// than a wrapped codec, e.g. on a data stream, which failed if err is set.
+func (m *RPC) Record(method string, start time.Time, err error) {
+	var msg string
+	if err != nil {
+		msg = err.Error()
+	}
+	m.record(method, start, msg)
+}
+
+// record records a call with the error message of its response.
+func (m *RPC) record(method string, start time.Time, msg string) {
+	code := gfs.Success.String()
+	if msg != "" {
+		code = gfs.UnknownError.String()
+		if e, ok := gfs.ParseError(msg); ok {
+			code = e.Code.String()
+		}
+	}
+	m.requests.Inc(method, code)
+	m.duration.Observe(time.Since(start).Seconds(), method)
+}
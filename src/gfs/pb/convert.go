@@ -0,0 +1,128 @@
+package pb
+
+import (
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"gfs"
+)
+
+// Value returns the credentials of c, the zero ones if c is nil.
+func (c *Credentials) Value() gfs.Credentials {
+	return gfs.Credentials{c.GetUser(), c.GetGroups(), c.GetToken()}
+}
+
+// Value returns the id of the data buffer d.
+func (d *DataBufferID) Value() gfs.DataBufferID {
+	return gfs.DataBufferID{gfs.ChunkHandle(d.GetHandle()), int(d.GetTimeStamp())}
+}
+
+// Value returns the id of the mutation r.
+func (r *RequestID) Value() gfs.RequestID {
+	return gfs.RequestID{r.GetClient(), r.GetSeq()}
+}
+
+// Addresses returns the server addresses of a message.
+func Addresses(addrs []string) []gfs.ServerAddress {
+	ret := make([]gfs.ServerAddress, len(addrs))
+	for i, v := range addrs {
+		ret[i] = gfs.ServerAddress(v)
+	}
+	return ret
+}
+
+// Strings returns the server addresses of args or a reply, for a message.
+func Strings(addrs []gfs.ServerAddress) []string {
+	ret := make([]string, len(addrs))
+	for i, v := range addrs {
+		ret[i] = string(v)
+	}
+	return ret
+}
+
+// Timestamp returns the timestamp of t, nil for the zero time.
+func Timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// statusCodes are the gRPC codes of the errors of gfs, the others are Unknown.
+var statusCodes = map[gfs.ErrorCode]codes.Code{
+	gfs.Timeout:               codes.DeadlineExceeded,
+	gfs.AppendExceedChunkSize: codes.InvalidArgument,
+	gfs.WriteExceedChunkSize:  codes.InvalidArgument,
+	gfs.ReadEOF:               codes.OutOfRange,
+	gfs.ChunkNotFound:         codes.NotFound,
+	gfs.StaleVersion:          codes.FailedPrecondition,
+	gfs.LeaseExpired:          codes.FailedPrecondition,
+	gfs.NotPrimary:            codes.FailedPrecondition,
+	gfs.ChecksumMismatch:      codes.DataLoss,
+	gfs.DataNotFound:          codes.NotFound,
+	gfs.NoReplica:             codes.Unavailable,
+	gfs.NotEnoughServers:      codes.Unavailable,
+	gfs.ServerNotFound:        codes.NotFound,
+	gfs.PathNotFound:          codes.NotFound,
+	gfs.PathExists:            codes.AlreadyExists,
+	gfs.NotDirectory:          codes.FailedPrecondition,
+	gfs.IsDirectory:           codes.FailedPrecondition,
+	gfs.InvalidArgument:       codes.InvalidArgument,
+	gfs.PhysicalEOF:           codes.DataLoss,
+	gfs.NotRegistered:         codes.FailedPrecondition,
+	gfs.ServerBusy:            codes.ResourceExhausted,
+	gfs.Throttled:             codes.ResourceExhausted,
+	gfs.GenerationMismatch:    codes.Aborted,
+	gfs.DirectoryNotEmpty:     codes.FailedPrecondition,
+	gfs.PermissionDenied:      codes.PermissionDenied,
+	gfs.Unauthenticated:       codes.Unauthenticated,
+	gfs.QuotaExceeded:         codes.ResourceExhausted,
+	gfs.ReadOnly:              codes.Unavailable,
+	gfs.InMaintenance:         codes.Unavailable,
+	gfs.ChunkExists:           codes.AlreadyExists,
+	gfs.XattrNotFound:         codes.NotFound,
+}
+
+// Status returns the status of an rpc failed with err, with the Error of
+// err in its details, nil if err is nil. An error which is not a gfs.Error,
+// nor the message of one, is an UnknownError.
+func Status(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e gfs.Error
+	if !errors.As(err, &e) {
+		var ok bool
+		if e, ok = gfs.ParseError(err.Error()); !ok {
+			e = gfs.Error{gfs.UnknownError, err.Error()}
+		}
+	}
+	code, ok := statusCodes[e.Code]
+	if !ok {
+		code = codes.Unknown
+	}
+	st, derr := status.New(code, e.Err).WithDetails(&Error{Code: ErrorCode(e.Code), Message: e.Err})
+	if derr != nil {
+		return status.Error(code, e.Err)
+	}
+	return st.Err()
+}
+
+// ToError returns the gfs.Error in the details of the status of err, for
+// clients, or err itself if it has none.
+func ToError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	for _, d := range st.Details() {
+		if e, ok := d.(*Error); ok {
+			return gfs.Error{gfs.ErrorCode(e.Code), e.Message}
+		}
+	}
+	return err
+}
@@ -0,0 +1,12 @@
+// Package pb holds the protobuf definitions of the rpcs clients make to
+// master and the chunkservers, gfs.proto, for clients in other languages.
+// The messages mirror the args and the replies of gfs/rpc_structs.go, with
+// the numbers of their fields fixed, so new fields are appended and old
+// clients keep working. The daemons serve these rpcs by gRPC as well, on
+// the port of the net/rpc ones, with an Error in the details of the
+// status of a failed rpc, see Status and ToError. gfs.pb.go and
+// gfs_grpc.pb.go are generated from gfs.proto by protoc-gen-go and
+// protoc-gen-go-grpc.
+package pb
+
+//go:generate protoc --go_out=paths=source_relative:. --go-grpc_out=paths=source_relative:. gfs.proto
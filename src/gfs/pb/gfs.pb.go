@@ -0,0 +1,2485 @@
+// The rpcs clients make to master and the chunkservers, see doc.go. Each
+// rpc is the net/rpc method of the same name, e.g. Master.RPCCreateFile,
+// and carries the fields of its args and its reply, by the same names. A
+// failed rpc has an Error in the details of its status.
+// Fields are only ever appended, numbers are never reused.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: gfs.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// ErrorCode is gfs.ErrorCode, new codes are appended at the end.
+type ErrorCode int32
+
+const (
+	ErrorCode_SUCCESS                  ErrorCode = 0
+	ErrorCode_UNKNOWN_ERROR            ErrorCode = 1
+	ErrorCode_TIMEOUT                  ErrorCode = 2
+	ErrorCode_APPEND_EXCEED_CHUNK_SIZE ErrorCode = 3
+	ErrorCode_WRITE_EXCEED_CHUNK_SIZE  ErrorCode = 4
+	ErrorCode_READ_EOF                 ErrorCode = 5 // read past the committed length of a chunk
+	ErrorCode_NOT_AVAILABLE_FOR_COPY   ErrorCode = 6
+	ErrorCode_CHUNK_NOT_FOUND          ErrorCode = 7
+	ErrorCode_STALE_VERSION            ErrorCode = 8
+	ErrorCode_LEASE_EXPIRED            ErrorCode = 9
+	ErrorCode_NOT_PRIMARY              ErrorCode = 10
+	ErrorCode_CHECKSUM_MISMATCH        ErrorCode = 11
+	ErrorCode_DATA_NOT_FOUND           ErrorCode = 12
+	ErrorCode_NO_REPLICA               ErrorCode = 13
+	ErrorCode_NOT_ENOUGH_SERVERS       ErrorCode = 14
+	ErrorCode_SERVER_NOT_FOUND         ErrorCode = 15
+	ErrorCode_PATH_NOT_FOUND           ErrorCode = 16
+	ErrorCode_PATH_EXISTS              ErrorCode = 17
+	ErrorCode_NOT_DIRECTORY            ErrorCode = 18
+	ErrorCode_IS_DIRECTORY             ErrorCode = 19
+	ErrorCode_INVALID_ARGUMENT         ErrorCode = 20
+	ErrorCode_PHYSICAL_EOF             ErrorCode = 21 // chunk file ends before the committed length
+	ErrorCode_NOT_REGISTERED           ErrorCode = 22
+	ErrorCode_SERVER_BUSY              ErrorCode = 23 // retry later
+	ErrorCode_THROTTLED                ErrorCode = 24
+	ErrorCode_GENERATION_MISMATCH      ErrorCode = 25
+	ErrorCode_DIRECTORY_NOT_EMPTY      ErrorCode = 26
+	ErrorCode_PERMISSION_DENIED        ErrorCode = 27
+	ErrorCode_UNAUTHENTICATED          ErrorCode = 28
+	ErrorCode_QUOTA_EXCEEDED           ErrorCode = 29
+	ErrorCode_READ_ONLY                ErrorCode = 30
+	ErrorCode_IN_MAINTENANCE           ErrorCode = 31
+	ErrorCode_CHUNK_EXISTS             ErrorCode = 32
+	ErrorCode_XATTR_NOT_FOUND          ErrorCode = 33
+)
+
+// Enum value maps for ErrorCode.
+var (
+	ErrorCode_name = map[int32]string{
+		0:  "SUCCESS",
+		1:  "UNKNOWN_ERROR",
+		2:  "TIMEOUT",
+		3:  "APPEND_EXCEED_CHUNK_SIZE",
+		4:  "WRITE_EXCEED_CHUNK_SIZE",
+		5:  "READ_EOF",
+		6:  "NOT_AVAILABLE_FOR_COPY",
+		7:  "CHUNK_NOT_FOUND",
+		8:  "STALE_VERSION",
+		9:  "LEASE_EXPIRED",
+		10: "NOT_PRIMARY",
+		11: "CHECKSUM_MISMATCH",
+		12: "DATA_NOT_FOUND",
+		13: "NO_REPLICA",
+		14: "NOT_ENOUGH_SERVERS",
+		15: "SERVER_NOT_FOUND",
+		16: "PATH_NOT_FOUND",
+		17: "PATH_EXISTS",
+		18: "NOT_DIRECTORY",
+		19: "IS_DIRECTORY",
+		20: "INVALID_ARGUMENT",
+		21: "PHYSICAL_EOF",
+		22: "NOT_REGISTERED",
+		23: "SERVER_BUSY",
+		24: "THROTTLED",
+		25: "GENERATION_MISMATCH",
+		26: "DIRECTORY_NOT_EMPTY",
+		27: "PERMISSION_DENIED",
+		28: "UNAUTHENTICATED",
+		29: "QUOTA_EXCEEDED",
+		30: "READ_ONLY",
+		31: "IN_MAINTENANCE",
+		32: "CHUNK_EXISTS",
+		33: "XATTR_NOT_FOUND",
+	}
+	ErrorCode_value = map[string]int32{
+		"SUCCESS":                  0,
+		"UNKNOWN_ERROR":            1,
+		"TIMEOUT":                  2,
+		"APPEND_EXCEED_CHUNK_SIZE": 3,
+		"WRITE_EXCEED_CHUNK_SIZE":  4,
+		"READ_EOF":                 5,
+		"NOT_AVAILABLE_FOR_COPY":   6,
+		"CHUNK_NOT_FOUND":          7,
+		"STALE_VERSION":            8,
+		"LEASE_EXPIRED":            9,
+		"NOT_PRIMARY":              10,
+		"CHECKSUM_MISMATCH":        11,
+		"DATA_NOT_FOUND":           12,
+		"NO_REPLICA":               13,
+		"NOT_ENOUGH_SERVERS":       14,
+		"SERVER_NOT_FOUND":         15,
+		"PATH_NOT_FOUND":           16,
+		"PATH_EXISTS":              17,
+		"NOT_DIRECTORY":            18,
+		"IS_DIRECTORY":             19,
+		"INVALID_ARGUMENT":         20,
+		"PHYSICAL_EOF":             21,
+		"NOT_REGISTERED":           22,
+		"SERVER_BUSY":              23,
+		"THROTTLED":                24,
+		"GENERATION_MISMATCH":      25,
+		"DIRECTORY_NOT_EMPTY":      26,
+		"PERMISSION_DENIED":        27,
+		"UNAUTHENTICATED":          28,
+		"QUOTA_EXCEEDED":           29,
+		"READ_ONLY":                30,
+		"IN_MAINTENANCE":           31,
+		"CHUNK_EXISTS":             32,
+		"XATTR_NOT_FOUND":          33,
+	}
+)
+
+func (x ErrorCode) Enum() *ErrorCode {
+	p := new(ErrorCode)
+	*p = x
+	return p
+}
+
+func (x ErrorCode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ErrorCode) Descriptor() protoreflect.EnumDescriptor {
+	return file_gfs_proto_enumTypes[0].Descriptor()
+}
+
+func (ErrorCode) Type() protoreflect.EnumType {
+	return &file_gfs_proto_enumTypes[0]
+}
+
+func (x ErrorCode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ErrorCode.Descriptor instead.
+func (ErrorCode) EnumDescriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{0}
+}
+
+// Error is gfs.Error.
+type Error struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Code          ErrorCode              `protobuf:"varint,1,opt,name=code,proto3,enum=gfs.ErrorCode" json:"code,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Error) Reset() {
+	*x = Error{}
+	mi := &file_gfs_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Error) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Error) ProtoMessage() {}
+
+func (x *Error) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Error.ProtoReflect.Descriptor instead.
+func (*Error) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Error) GetCode() ErrorCode {
+	if x != nil {
+		return x.Code
+	}
+	return ErrorCode_SUCCESS
+}
+
+func (x *Error) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type Credentials struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	User          string                 `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	Groups        []string               `protobuf:"bytes,2,rep,name=groups,proto3" json:"groups,omitempty"`
+	Token         string                 `protobuf:"bytes,3,opt,name=token,proto3" json:"token,omitempty"` // signed by gfs/auth
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Credentials) Reset() {
+	*x = Credentials{}
+	mi := &file_gfs_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Credentials) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Credentials) ProtoMessage() {}
+
+func (x *Credentials) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Credentials.ProtoReflect.Descriptor instead.
+func (*Credentials) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Credentials) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+func (x *Credentials) GetGroups() []string {
+	if x != nil {
+		return x.Groups
+	}
+	return nil
+}
+
+func (x *Credentials) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+// RequestID identifies a mutation across its retries.
+type RequestID struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Client        string                 `protobuf:"bytes,1,opt,name=client,proto3" json:"client,omitempty"`
+	Seq           uint64                 `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RequestID) Reset() {
+	*x = RequestID{}
+	mi := &file_gfs_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RequestID) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequestID) ProtoMessage() {}
+
+func (x *RequestID) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequestID.ProtoReflect.Descriptor instead.
+func (*RequestID) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RequestID) GetClient() string {
+	if x != nil {
+		return x.Client
+	}
+	return ""
+}
+
+func (x *RequestID) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+type DataBufferID struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Handle        int64                  `protobuf:"varint,1,opt,name=handle,proto3" json:"handle,omitempty"`
+	TimeStamp     int64                  `protobuf:"varint,2,opt,name=time_stamp,json=timeStamp,proto3" json:"time_stamp,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DataBufferID) Reset() {
+	*x = DataBufferID{}
+	mi := &file_gfs_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DataBufferID) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DataBufferID) ProtoMessage() {}
+
+func (x *DataBufferID) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DataBufferID.ProtoReflect.Descriptor instead.
+func (*DataBufferID) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *DataBufferID) GetHandle() int64 {
+	if x != nil {
+		return x.Handle
+	}
+	return 0
+}
+
+func (x *DataBufferID) GetTimeStamp() int64 {
+	if x != nil {
+		return x.TimeStamp
+	}
+	return 0
+}
+
+type PathInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	IsDir         bool                   `protobuf:"varint,2,opt,name=is_dir,json=isDir,proto3" json:"is_dir,omitempty"`
+	Length        int64                  `protobuf:"varint,3,opt,name=length,proto3" json:"length,omitempty"`
+	Chunks        int64                  `protobuf:"varint,4,opt,name=chunks,proto3" json:"chunks,omitempty"`
+	Owner         string                 `protobuf:"bytes,5,opt,name=owner,proto3" json:"owner,omitempty"`
+	Group         string                 `protobuf:"bytes,6,opt,name=group,proto3" json:"group,omitempty"`
+	Mode          uint32                 `protobuf:"varint,7,opt,name=mode,proto3" json:"mode,omitempty"` // permission bits
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PathInfo) Reset() {
+	*x = PathInfo{}
+	mi := &file_gfs_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PathInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PathInfo) ProtoMessage() {}
+
+func (x *PathInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PathInfo.ProtoReflect.Descriptor instead.
+func (*PathInfo) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *PathInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *PathInfo) GetIsDir() bool {
+	if x != nil {
+		return x.IsDir
+	}
+	return false
+}
+
+func (x *PathInfo) GetLength() int64 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+func (x *PathInfo) GetChunks() int64 {
+	if x != nil {
+		return x.Chunks
+	}
+	return 0
+}
+
+func (x *PathInfo) GetOwner() string {
+	if x != nil {
+		return x.Owner
+	}
+	return ""
+}
+
+func (x *PathInfo) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *PathInfo) GetMode() uint32 {
+	if x != nil {
+		return x.Mode
+	}
+	return 0
+}
+
+type CreateFileArg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	ChunkSize     int64                  `protobuf:"varint,2,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"` // one of gfs.ChunkSizes, 0 for gfs.MaxChunkSize
+	Cred          *Credentials           `protobuf:"bytes,3,opt,name=cred,proto3" json:"cred,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateFileArg) Reset() {
+	*x = CreateFileArg{}
+	mi := &file_gfs_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateFileArg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateFileArg) ProtoMessage() {}
+
+func (x *CreateFileArg) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateFileArg.ProtoReflect.Descriptor instead.
+func (*CreateFileArg) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *CreateFileArg) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *CreateFileArg) GetChunkSize() int64 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
+}
+
+func (x *CreateFileArg) GetCred() *Credentials {
+	if x != nil {
+		return x.Cred
+	}
+	return nil
+}
+
+type CreateFileReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateFileReply) Reset() {
+	*x = CreateFileReply{}
+	mi := &file_gfs_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateFileReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateFileReply) ProtoMessage() {}
+
+func (x *CreateFileReply) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateFileReply.ProtoReflect.Descriptor instead.
+func (*CreateFileReply) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{6}
+}
+
+type MkdirArg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Cred          *Credentials           `protobuf:"bytes,2,opt,name=cred,proto3" json:"cred,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MkdirArg) Reset() {
+	*x = MkdirArg{}
+	mi := &file_gfs_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MkdirArg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MkdirArg) ProtoMessage() {}
+
+func (x *MkdirArg) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MkdirArg.ProtoReflect.Descriptor instead.
+func (*MkdirArg) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *MkdirArg) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *MkdirArg) GetCred() *Credentials {
+	if x != nil {
+		return x.Cred
+	}
+	return nil
+}
+
+type MkdirReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MkdirReply) Reset() {
+	*x = MkdirReply{}
+	mi := &file_gfs_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MkdirReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MkdirReply) ProtoMessage() {}
+
+func (x *MkdirReply) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MkdirReply.ProtoReflect.Descriptor instead.
+func (*MkdirReply) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{8}
+}
+
+type DeleteFileArg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Recursive     bool                   `protobuf:"varint,2,opt,name=recursive,proto3" json:"recursive,omitempty"`
+	Cred          *Credentials           `protobuf:"bytes,3,opt,name=cred,proto3" json:"cred,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteFileArg) Reset() {
+	*x = DeleteFileArg{}
+	mi := &file_gfs_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteFileArg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteFileArg) ProtoMessage() {}
+
+func (x *DeleteFileArg) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteFileArg.ProtoReflect.Descriptor instead.
+func (*DeleteFileArg) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *DeleteFileArg) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *DeleteFileArg) GetRecursive() bool {
+	if x != nil {
+		return x.Recursive
+	}
+	return false
+}
+
+func (x *DeleteFileArg) GetCred() *Credentials {
+	if x != nil {
+		return x.Cred
+	}
+	return nil
+}
+
+type DeleteFileReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Files         int32                  `protobuf:"varint,1,opt,name=files,proto3" json:"files,omitempty"` // deleted, counting the subtree of a directory
+	Dirs          int32                  `protobuf:"varint,2,opt,name=dirs,proto3" json:"dirs,omitempty"`
+	Trash         string                 `protobuf:"bytes,3,opt,name=trash,proto3" json:"trash,omitempty"` // the path was moved to, empty if it was removed for good
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteFileReply) Reset() {
+	*x = DeleteFileReply{}
+	mi := &file_gfs_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteFileReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteFileReply) ProtoMessage() {}
+
+func (x *DeleteFileReply) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteFileReply.ProtoReflect.Descriptor instead.
+func (*DeleteFileReply) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *DeleteFileReply) GetFiles() int32 {
+	if x != nil {
+		return x.Files
+	}
+	return 0
+}
+
+func (x *DeleteFileReply) GetDirs() int32 {
+	if x != nil {
+		return x.Dirs
+	}
+	return 0
+}
+
+func (x *DeleteFileReply) GetTrash() string {
+	if x != nil {
+		return x.Trash
+	}
+	return ""
+}
+
+type RenameFileArg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Source        string                 `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	Target        string                 `protobuf:"bytes,2,opt,name=target,proto3" json:"target,omitempty"`
+	Cred          *Credentials           `protobuf:"bytes,3,opt,name=cred,proto3" json:"cred,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenameFileArg) Reset() {
+	*x = RenameFileArg{}
+	mi := &file_gfs_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenameFileArg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenameFileArg) ProtoMessage() {}
+
+func (x *RenameFileArg) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenameFileArg.ProtoReflect.Descriptor instead.
+func (*RenameFileArg) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *RenameFileArg) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *RenameFileArg) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *RenameFileArg) GetCred() *Credentials {
+	if x != nil {
+		return x.Cred
+	}
+	return nil
+}
+
+type RenameFileReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenameFileReply) Reset() {
+	*x = RenameFileReply{}
+	mi := &file_gfs_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenameFileReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenameFileReply) ProtoMessage() {}
+
+func (x *RenameFileReply) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenameFileReply.ProtoReflect.Descriptor instead.
+func (*RenameFileReply) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{12}
+}
+
+type ListArg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	After         string                 `protobuf:"bytes,2,opt,name=after,proto3" json:"after,omitempty"` // continuation token, next of the previous page
+	Limit         int32                  `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`
+	Prefix        string                 `protobuf:"bytes,4,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	Pattern       string                 `protobuf:"bytes,5,opt,name=pattern,proto3" json:"pattern,omitempty"` // glob, as path.Match
+	Cred          *Credentials           `protobuf:"bytes,6,opt,name=cred,proto3" json:"cred,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListArg) Reset() {
+	*x = ListArg{}
+	mi := &file_gfs_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListArg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListArg) ProtoMessage() {}
+
+func (x *ListArg) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListArg.ProtoReflect.Descriptor instead.
+func (*ListArg) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *ListArg) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *ListArg) GetAfter() string {
+	if x != nil {
+		return x.After
+	}
+	return ""
+}
+
+func (x *ListArg) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListArg) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *ListArg) GetPattern() string {
+	if x != nil {
+		return x.Pattern
+	}
+	return ""
+}
+
+func (x *ListArg) GetCred() *Credentials {
+	if x != nil {
+		return x.Cred
+	}
+	return nil
+}
+
+type ListReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Files         []*PathInfo            `protobuf:"bytes,1,rep,name=files,proto3" json:"files,omitempty"` // sorted by name
+	Next          string                 `protobuf:"bytes,2,opt,name=next,proto3" json:"next,omitempty"`   // empty when done
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListReply) Reset() {
+	*x = ListReply{}
+	mi := &file_gfs_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListReply) ProtoMessage() {}
+
+func (x *ListReply) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListReply.ProtoReflect.Descriptor instead.
+func (*ListReply) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ListReply) GetFiles() []*PathInfo {
+	if x != nil {
+		return x.Files
+	}
+	return nil
+}
+
+func (x *ListReply) GetNext() string {
+	if x != nil {
+		return x.Next
+	}
+	return ""
+}
+
+type GetFileInfoArg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Cred          *Credentials           `protobuf:"bytes,2,opt,name=cred,proto3" json:"cred,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetFileInfoArg) Reset() {
+	*x = GetFileInfoArg{}
+	mi := &file_gfs_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetFileInfoArg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetFileInfoArg) ProtoMessage() {}
+
+func (x *GetFileInfoArg) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetFileInfoArg.ProtoReflect.Descriptor instead.
+func (*GetFileInfoArg) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *GetFileInfoArg) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *GetFileInfoArg) GetCred() *Credentials {
+	if x != nil {
+		return x.Cred
+	}
+	return nil
+}
+
+type GetFileInfoReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	IsDir         bool                   `protobuf:"varint,1,opt,name=is_dir,json=isDir,proto3" json:"is_dir,omitempty"`
+	Length        int64                  `protobuf:"varint,2,opt,name=length,proto3" json:"length,omitempty"`
+	Chunks        int64                  `protobuf:"varint,3,opt,name=chunks,proto3" json:"chunks,omitempty"`
+	ChunkSize     int64                  `protobuf:"varint,4,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"`
+	Replicas      int32                  `protobuf:"varint,5,opt,name=replicas,proto3" json:"replicas,omitempty"`
+	Generation    int64                  `protobuf:"varint,6,opt,name=generation,proto3" json:"generation,omitempty"`
+	ModTime       *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=mod_time,json=modTime,proto3" json:"mod_time,omitempty"`
+	ChangeTime    *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=change_time,json=changeTime,proto3" json:"change_time,omitempty"`
+	Owner         string                 `protobuf:"bytes,9,opt,name=owner,proto3" json:"owner,omitempty"`
+	Group         string                 `protobuf:"bytes,10,opt,name=group,proto3" json:"group,omitempty"`
+	Mode          uint32                 `protobuf:"varint,11,opt,name=mode,proto3" json:"mode,omitempty"`
+	CreateTime    *timestamppb.Timestamp `protobuf:"bytes,12,opt,name=create_time,json=createTime,proto3" json:"create_time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetFileInfoReply) Reset() {
+	*x = GetFileInfoReply{}
+	mi := &file_gfs_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetFileInfoReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetFileInfoReply) ProtoMessage() {}
+
+func (x *GetFileInfoReply) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetFileInfoReply.ProtoReflect.Descriptor instead.
+func (*GetFileInfoReply) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *GetFileInfoReply) GetIsDir() bool {
+	if x != nil {
+		return x.IsDir
+	}
+	return false
+}
+
+func (x *GetFileInfoReply) GetLength() int64 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+func (x *GetFileInfoReply) GetChunks() int64 {
+	if x != nil {
+		return x.Chunks
+	}
+	return 0
+}
+
+func (x *GetFileInfoReply) GetChunkSize() int64 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
+}
+
+func (x *GetFileInfoReply) GetReplicas() int32 {
+	if x != nil {
+		return x.Replicas
+	}
+	return 0
+}
+
+func (x *GetFileInfoReply) GetGeneration() int64 {
+	if x != nil {
+		return x.Generation
+	}
+	return 0
+}
+
+func (x *GetFileInfoReply) GetModTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ModTime
+	}
+	return nil
+}
+
+func (x *GetFileInfoReply) GetChangeTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ChangeTime
+	}
+	return nil
+}
+
+func (x *GetFileInfoReply) GetOwner() string {
+	if x != nil {
+		return x.Owner
+	}
+	return ""
+}
+
+func (x *GetFileInfoReply) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *GetFileInfoReply) GetMode() uint32 {
+	if x != nil {
+		return x.Mode
+	}
+	return 0
+}
+
+func (x *GetFileInfoReply) GetCreateTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreateTime
+	}
+	return nil
+}
+
+type GetChunkHandleArg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Index         int64                  `protobuf:"varint,2,opt,name=index,proto3" json:"index,omitempty"`
+	Write         bool                   `protobuf:"varint,3,opt,name=write,proto3" json:"write,omitempty"`
+	Cred          *Credentials           `protobuf:"bytes,4,opt,name=cred,proto3" json:"cred,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetChunkHandleArg) Reset() {
+	*x = GetChunkHandleArg{}
+	mi := &file_gfs_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetChunkHandleArg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetChunkHandleArg) ProtoMessage() {}
+
+func (x *GetChunkHandleArg) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetChunkHandleArg.ProtoReflect.Descriptor instead.
+func (*GetChunkHandleArg) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *GetChunkHandleArg) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *GetChunkHandleArg) GetIndex() int64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *GetChunkHandleArg) GetWrite() bool {
+	if x != nil {
+		return x.Write
+	}
+	return false
+}
+
+func (x *GetChunkHandleArg) GetCred() *Credentials {
+	if x != nil {
+		return x.Cred
+	}
+	return nil
+}
+
+type GetChunkHandleReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Handle        int64                  `protobuf:"varint,1,opt,name=handle,proto3" json:"handle,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetChunkHandleReply) Reset() {
+	*x = GetChunkHandleReply{}
+	mi := &file_gfs_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetChunkHandleReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetChunkHandleReply) ProtoMessage() {}
+
+func (x *GetChunkHandleReply) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetChunkHandleReply.ProtoReflect.Descriptor instead.
+func (*GetChunkHandleReply) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *GetChunkHandleReply) GetHandle() int64 {
+	if x != nil {
+		return x.Handle
+	}
+	return 0
+}
+
+type GetReplicasArg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Handle        int64                  `protobuf:"varint,1,opt,name=handle,proto3" json:"handle,omitempty"`
+	Cred          *Credentials           `protobuf:"bytes,2,opt,name=cred,proto3" json:"cred,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetReplicasArg) Reset() {
+	*x = GetReplicasArg{}
+	mi := &file_gfs_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetReplicasArg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetReplicasArg) ProtoMessage() {}
+
+func (x *GetReplicasArg) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetReplicasArg.ProtoReflect.Descriptor instead.
+func (*GetReplicasArg) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *GetReplicasArg) GetHandle() int64 {
+	if x != nil {
+		return x.Handle
+	}
+	return 0
+}
+
+func (x *GetReplicasArg) GetCred() *Credentials {
+	if x != nil {
+		return x.Cred
+	}
+	return nil
+}
+
+type GetReplicasReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Locations     []string               `protobuf:"bytes,1,rep,name=locations,proto3" json:"locations,omitempty"`
+	Version       int64                  `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	Token         string                 `protobuf:"bytes,3,opt,name=token,proto3" json:"token,omitempty"` // passed in reads
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetReplicasReply) Reset() {
+	*x = GetReplicasReply{}
+	mi := &file_gfs_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetReplicasReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetReplicasReply) ProtoMessage() {}
+
+func (x *GetReplicasReply) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetReplicasReply.ProtoReflect.Descriptor instead.
+func (*GetReplicasReply) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *GetReplicasReply) GetLocations() []string {
+	if x != nil {
+		return x.Locations
+	}
+	return nil
+}
+
+func (x *GetReplicasReply) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *GetReplicasReply) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+type GetPrimaryAndSecondariesArg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Handle        int64                  `protobuf:"varint,1,opt,name=handle,proto3" json:"handle,omitempty"`
+	WriterDomain  string                 `protobuf:"bytes,2,opt,name=writer_domain,json=writerDomain,proto3" json:"writer_domain,omitempty"`
+	Cred          *Credentials           `protobuf:"bytes,3,opt,name=cred,proto3" json:"cred,omitempty"`
+	Trace         string                 `protobuf:"bytes,4,opt,name=trace,proto3" json:"trace,omitempty"` // see util.TraceID
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPrimaryAndSecondariesArg) Reset() {
+	*x = GetPrimaryAndSecondariesArg{}
+	mi := &file_gfs_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPrimaryAndSecondariesArg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPrimaryAndSecondariesArg) ProtoMessage() {}
+
+func (x *GetPrimaryAndSecondariesArg) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPrimaryAndSecondariesArg.ProtoReflect.Descriptor instead.
+func (*GetPrimaryAndSecondariesArg) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *GetPrimaryAndSecondariesArg) GetHandle() int64 {
+	if x != nil {
+		return x.Handle
+	}
+	return 0
+}
+
+func (x *GetPrimaryAndSecondariesArg) GetWriterDomain() string {
+	if x != nil {
+		return x.WriterDomain
+	}
+	return ""
+}
+
+func (x *GetPrimaryAndSecondariesArg) GetCred() *Credentials {
+	if x != nil {
+		return x.Cred
+	}
+	return nil
+}
+
+func (x *GetPrimaryAndSecondariesArg) GetTrace() string {
+	if x != nil {
+		return x.Trace
+	}
+	return ""
+}
+
+type GetPrimaryAndSecondariesReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Primary       string                 `protobuf:"bytes,1,opt,name=primary,proto3" json:"primary,omitempty"`
+	Expire        *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=expire,proto3" json:"expire,omitempty"`
+	Secondaries   []string               `protobuf:"bytes,3,rep,name=secondaries,proto3" json:"secondaries,omitempty"`
+	Epoch         int64                  `protobuf:"varint,4,opt,name=epoch,proto3" json:"epoch,omitempty"` // passed in mutations
+	Token         string                 `protobuf:"bytes,5,opt,name=token,proto3" json:"token,omitempty"`  // passed in mutations
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPrimaryAndSecondariesReply) Reset() {
+	*x = GetPrimaryAndSecondariesReply{}
+	mi := &file_gfs_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPrimaryAndSecondariesReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPrimaryAndSecondariesReply) ProtoMessage() {}
+
+func (x *GetPrimaryAndSecondariesReply) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPrimaryAndSecondariesReply.ProtoReflect.Descriptor instead.
+func (*GetPrimaryAndSecondariesReply) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *GetPrimaryAndSecondariesReply) GetPrimary() string {
+	if x != nil {
+		return x.Primary
+	}
+	return ""
+}
+
+func (x *GetPrimaryAndSecondariesReply) GetExpire() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Expire
+	}
+	return nil
+}
+
+func (x *GetPrimaryAndSecondariesReply) GetSecondaries() []string {
+	if x != nil {
+		return x.Secondaries
+	}
+	return nil
+}
+
+func (x *GetPrimaryAndSecondariesReply) GetEpoch() int64 {
+	if x != nil {
+		return x.Epoch
+	}
+	return 0
+}
+
+func (x *GetPrimaryAndSecondariesReply) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+type ForwardDataArg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DataId        *DataBufferID          `protobuf:"bytes,1,opt,name=data_id,json=dataId,proto3" json:"data_id,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	ChainOrder    []string               `protobuf:"bytes,3,rep,name=chain_order,json=chainOrder,proto3" json:"chain_order,omitempty"`
+	Client        string                 `protobuf:"bytes,4,opt,name=client,proto3" json:"client,omitempty"`
+	Trace         string                 `protobuf:"bytes,5,opt,name=trace,proto3" json:"trace,omitempty"`
+	Token         string                 `protobuf:"bytes,6,opt,name=token,proto3" json:"token,omitempty"` // of the lease
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ForwardDataArg) Reset() {
+	*x = ForwardDataArg{}
+	mi := &file_gfs_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ForwardDataArg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ForwardDataArg) ProtoMessage() {}
+
+func (x *ForwardDataArg) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ForwardDataArg.ProtoReflect.Descriptor instead.
+func (*ForwardDataArg) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *ForwardDataArg) GetDataId() *DataBufferID {
+	if x != nil {
+		return x.DataId
+	}
+	return nil
+}
+
+func (x *ForwardDataArg) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *ForwardDataArg) GetChainOrder() []string {
+	if x != nil {
+		return x.ChainOrder
+	}
+	return nil
+}
+
+func (x *ForwardDataArg) GetClient() string {
+	if x != nil {
+		return x.Client
+	}
+	return ""
+}
+
+func (x *ForwardDataArg) GetTrace() string {
+	if x != nil {
+		return x.Trace
+	}
+	return ""
+}
+
+func (x *ForwardDataArg) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+type ForwardDataReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ErrorCode     ErrorCode              `protobuf:"varint,1,opt,name=error_code,json=errorCode,proto3,enum=gfs.ErrorCode" json:"error_code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ForwardDataReply) Reset() {
+	*x = ForwardDataReply{}
+	mi := &file_gfs_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ForwardDataReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ForwardDataReply) ProtoMessage() {}
+
+func (x *ForwardDataReply) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ForwardDataReply.ProtoReflect.Descriptor instead.
+func (*ForwardDataReply) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *ForwardDataReply) GetErrorCode() ErrorCode {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ErrorCode_SUCCESS
+}
+
+type WriteChunkArg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DataId        *DataBufferID          `protobuf:"bytes,1,opt,name=data_id,json=dataId,proto3" json:"data_id,omitempty"`
+	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	Secondaries   []string               `protobuf:"bytes,3,rep,name=secondaries,proto3" json:"secondaries,omitempty"`
+	Epoch         int64                  `protobuf:"varint,4,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	RequestId     *RequestID             `protobuf:"bytes,5,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	Token         string                 `protobuf:"bytes,6,opt,name=token,proto3" json:"token,omitempty"`
+	Trace         string                 `protobuf:"bytes,7,opt,name=trace,proto3" json:"trace,omitempty"`
+	Durable       bool                   `protobuf:"varint,8,opt,name=durable,proto3" json:"durable,omitempty"` // synced to disk on the replicas before the reply
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WriteChunkArg) Reset() {
+	*x = WriteChunkArg{}
+	mi := &file_gfs_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WriteChunkArg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WriteChunkArg) ProtoMessage() {}
+
+func (x *WriteChunkArg) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WriteChunkArg.ProtoReflect.Descriptor instead.
+func (*WriteChunkArg) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *WriteChunkArg) GetDataId() *DataBufferID {
+	if x != nil {
+		return x.DataId
+	}
+	return nil
+}
+
+func (x *WriteChunkArg) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *WriteChunkArg) GetSecondaries() []string {
+	if x != nil {
+		return x.Secondaries
+	}
+	return nil
+}
+
+func (x *WriteChunkArg) GetEpoch() int64 {
+	if x != nil {
+		return x.Epoch
+	}
+	return 0
+}
+
+func (x *WriteChunkArg) GetRequestId() *RequestID {
+	if x != nil {
+		return x.RequestId
+	}
+	return nil
+}
+
+func (x *WriteChunkArg) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *WriteChunkArg) GetTrace() string {
+	if x != nil {
+		return x.Trace
+	}
+	return ""
+}
+
+func (x *WriteChunkArg) GetDurable() bool {
+	if x != nil {
+		return x.Durable
+	}
+	return false
+}
+
+type WriteChunkReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ErrorCode     ErrorCode              `protobuf:"varint,1,opt,name=error_code,json=errorCode,proto3,enum=gfs.ErrorCode" json:"error_code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WriteChunkReply) Reset() {
+	*x = WriteChunkReply{}
+	mi := &file_gfs_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WriteChunkReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WriteChunkReply) ProtoMessage() {}
+
+func (x *WriteChunkReply) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WriteChunkReply.ProtoReflect.Descriptor instead.
+func (*WriteChunkReply) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *WriteChunkReply) GetErrorCode() ErrorCode {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ErrorCode_SUCCESS
+}
+
+type AppendChunkArg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DataId        *DataBufferID          `protobuf:"bytes,1,opt,name=data_id,json=dataId,proto3" json:"data_id,omitempty"`
+	Secondaries   []string               `protobuf:"bytes,2,rep,name=secondaries,proto3" json:"secondaries,omitempty"`
+	Epoch         int64                  `protobuf:"varint,3,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	RequestId     *RequestID             `protobuf:"bytes,4,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	Token         string                 `protobuf:"bytes,5,opt,name=token,proto3" json:"token,omitempty"`
+	Trace         string                 `protobuf:"bytes,6,opt,name=trace,proto3" json:"trace,omitempty"`
+	Durable       bool                   `protobuf:"varint,7,opt,name=durable,proto3" json:"durable,omitempty"` // synced to disk on the replicas before the reply
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AppendChunkArg) Reset() {
+	*x = AppendChunkArg{}
+	mi := &file_gfs_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AppendChunkArg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AppendChunkArg) ProtoMessage() {}
+
+func (x *AppendChunkArg) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AppendChunkArg.ProtoReflect.Descriptor instead.
+func (*AppendChunkArg) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *AppendChunkArg) GetDataId() *DataBufferID {
+	if x != nil {
+		return x.DataId
+	}
+	return nil
+}
+
+func (x *AppendChunkArg) GetSecondaries() []string {
+	if x != nil {
+		return x.Secondaries
+	}
+	return nil
+}
+
+func (x *AppendChunkArg) GetEpoch() int64 {
+	if x != nil {
+		return x.Epoch
+	}
+	return 0
+}
+
+func (x *AppendChunkArg) GetRequestId() *RequestID {
+	if x != nil {
+		return x.RequestId
+	}
+	return nil
+}
+
+func (x *AppendChunkArg) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *AppendChunkArg) GetTrace() string {
+	if x != nil {
+		return x.Trace
+	}
+	return ""
+}
+
+func (x *AppendChunkArg) GetDurable() bool {
+	if x != nil {
+		return x.Durable
+	}
+	return false
+}
+
+type AppendChunkReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Offset        int64                  `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"`
+	ErrorCode     ErrorCode              `protobuf:"varint,2,opt,name=error_code,json=errorCode,proto3,enum=gfs.ErrorCode" json:"error_code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AppendChunkReply) Reset() {
+	*x = AppendChunkReply{}
+	mi := &file_gfs_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AppendChunkReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AppendChunkReply) ProtoMessage() {}
+
+func (x *AppendChunkReply) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AppendChunkReply.ProtoReflect.Descriptor instead.
+func (*AppendChunkReply) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{28}
+}
+
+func (x *AppendChunkReply) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *AppendChunkReply) GetErrorCode() ErrorCode {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ErrorCode_SUCCESS
+}
+
+type ReadChunkArg struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Handle        int64                  `protobuf:"varint,1,opt,name=handle,proto3" json:"handle,omitempty"`
+	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	Length        int32                  `protobuf:"varint,3,opt,name=length,proto3" json:"length,omitempty"`
+	Token         string                 `protobuf:"bytes,4,opt,name=token,proto3" json:"token,omitempty"`
+	Client        string                 `protobuf:"bytes,5,opt,name=client,proto3" json:"client,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReadChunkArg) Reset() {
+	*x = ReadChunkArg{}
+	mi := &file_gfs_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadChunkArg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadChunkArg) ProtoMessage() {}
+
+func (x *ReadChunkArg) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadChunkArg.ProtoReflect.Descriptor instead.
+func (*ReadChunkArg) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *ReadChunkArg) GetHandle() int64 {
+	if x != nil {
+		return x.Handle
+	}
+	return 0
+}
+
+func (x *ReadChunkArg) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *ReadChunkArg) GetLength() int32 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+func (x *ReadChunkArg) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *ReadChunkArg) GetClient() string {
+	if x != nil {
+		return x.Client
+	}
+	return ""
+}
+
+type ReadChunkReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	Length        int32                  `protobuf:"varint,2,opt,name=length,proto3" json:"length,omitempty"`
+	ChunkLength   int64                  `protobuf:"varint,3,opt,name=chunk_length,json=chunkLength,proto3" json:"chunk_length,omitempty"`              // committed length of the chunk
+	Version       int64                  `protobuf:"varint,4,opt,name=version,proto3" json:"version,omitempty"`                                         // of the replica read
+	ErrorCode     ErrorCode              `protobuf:"varint,5,opt,name=error_code,json=errorCode,proto3,enum=gfs.ErrorCode" json:"error_code,omitempty"` // READ_EOF if the read is clamped
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReadChunkReply) Reset() {
+	*x = ReadChunkReply{}
+	mi := &file_gfs_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadChunkReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadChunkReply) ProtoMessage() {}
+
+func (x *ReadChunkReply) ProtoReflect() protoreflect.Message {
+	mi := &file_gfs_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadChunkReply.ProtoReflect.Descriptor instead.
+func (*ReadChunkReply) Descriptor() ([]byte, []int) {
+	return file_gfs_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *ReadChunkReply) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *ReadChunkReply) GetLength() int32 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+func (x *ReadChunkReply) GetChunkLength() int64 {
+	if x != nil {
+		return x.ChunkLength
+	}
+	return 0
+}
+
+func (x *ReadChunkReply) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *ReadChunkReply) GetErrorCode() ErrorCode {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ErrorCode_SUCCESS
+}
+
+var File_gfs_proto protoreflect.FileDescriptor
+
+const file_gfs_proto_rawDesc = "" +
+	"\n" +
+	"\tgfs.proto\x12\x03gfs\x1a\x1fgoogle/protobuf/timestamp.proto\"E\n" +
+	"\x05Error\x12\"\n" +
+	"\x04code\x18\x01 \x01(\x0e2\x0e.gfs.ErrorCodeR\x04code\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\"O\n" +
+	"\vCredentials\x12\x12\n" +
+	"\x04user\x18\x01 \x01(\tR\x04user\x12\x16\n" +
+	"\x06groups\x18\x02 \x03(\tR\x06groups\x12\x14\n" +
+	"\x05token\x18\x03 \x01(\tR\x05token\"5\n" +
+	"\tRequestID\x12\x16\n" +
+	"\x06client\x18\x01 \x01(\tR\x06client\x12\x10\n" +
+	"\x03seq\x18\x02 \x01(\x04R\x03seq\"E\n" +
+	"\fDataBufferID\x12\x16\n" +
+	"\x06handle\x18\x01 \x01(\x03R\x06handle\x12\x1d\n" +
+	"\n" +
+	"time_stamp\x18\x02 \x01(\x03R\ttimeStamp\"\xa5\x01\n" +
+	"\bPathInfo\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x15\n" +
+	"\x06is_dir\x18\x02 \x01(\bR\x05isDir\x12\x16\n" +
+	"\x06length\x18\x03 \x01(\x03R\x06length\x12\x16\n" +
+	"\x06chunks\x18\x04 \x01(\x03R\x06chunks\x12\x14\n" +
+	"\x05owner\x18\x05 \x01(\tR\x05owner\x12\x14\n" +
+	"\x05group\x18\x06 \x01(\tR\x05group\x12\x12\n" +
+	"\x04mode\x18\a \x01(\rR\x04mode\"h\n" +
+	"\rCreateFileArg\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x1d\n" +
+	"\n" +
+	"chunk_size\x18\x02 \x01(\x03R\tchunkSize\x12$\n" +
+	"\x04cred\x18\x03 \x01(\v2\x10.gfs.CredentialsR\x04cred\"\x11\n" +
+	"\x0fCreateFileReply\"D\n" +
+	"\bMkdirArg\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12$\n" +
+	"\x04cred\x18\x02 \x01(\v2\x10.gfs.CredentialsR\x04cred\"\f\n" +
+	"\n" +
+	"MkdirReply\"g\n" +
+	"\rDeleteFileArg\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x1c\n" +
+	"\trecursive\x18\x02 \x01(\bR\trecursive\x12$\n" +
+	"\x04cred\x18\x03 \x01(\v2\x10.gfs.CredentialsR\x04cred\"Q\n" +
+	"\x0fDeleteFileReply\x12\x14\n" +
+	"\x05files\x18\x01 \x01(\x05R\x05files\x12\x12\n" +
+	"\x04dirs\x18\x02 \x01(\x05R\x04dirs\x12\x14\n" +
+	"\x05trash\x18\x03 \x01(\tR\x05trash\"e\n" +
+	"\rRenameFileArg\x12\x16\n" +
+	"\x06source\x18\x01 \x01(\tR\x06source\x12\x16\n" +
+	"\x06target\x18\x02 \x01(\tR\x06target\x12$\n" +
+	"\x04cred\x18\x03 \x01(\v2\x10.gfs.CredentialsR\x04cred\"\x11\n" +
+	"\x0fRenameFileReply\"\xa1\x01\n" +
+	"\aListArg\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x14\n" +
+	"\x05after\x18\x02 \x01(\tR\x05after\x12\x14\n" +
+	"\x05limit\x18\x03 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06prefix\x18\x04 \x01(\tR\x06prefix\x12\x18\n" +
+	"\apattern\x18\x05 \x01(\tR\apattern\x12$\n" +
+	"\x04cred\x18\x06 \x01(\v2\x10.gfs.CredentialsR\x04cred\"D\n" +
+	"\tListReply\x12#\n" +
+	"\x05files\x18\x01 \x03(\v2\r.gfs.PathInfoR\x05files\x12\x12\n" +
+	"\x04next\x18\x02 \x01(\tR\x04next\"J\n" +
+	"\x0eGetFileInfoArg\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12$\n" +
+	"\x04cred\x18\x02 \x01(\v2\x10.gfs.CredentialsR\x04cred\"\xa5\x03\n" +
+	"\x10GetFileInfoReply\x12\x15\n" +
+	"\x06is_dir\x18\x01 \x01(\bR\x05isDir\x12\x16\n" +
+	"\x06length\x18\x02 \x01(\x03R\x06length\x12\x16\n" +
+	"\x06chunks\x18\x03 \x01(\x03R\x06chunks\x12\x1d\n" +
+	"\n" +
+	"chunk_size\x18\x04 \x01(\x03R\tchunkSize\x12\x1a\n" +
+	"\breplicas\x18\x05 \x01(\x05R\breplicas\x12\x1e\n" +
+	"\n" +
+	"generation\x18\x06 \x01(\x03R\n" +
+	"generation\x125\n" +
+	"\bmod_time\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\amodTime\x12;\n" +
+	"\vchange_time\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"changeTime\x12\x14\n" +
+	"\x05owner\x18\t \x01(\tR\x05owner\x12\x14\n" +
+	"\x05group\x18\n" +
+	" \x01(\tR\x05group\x12\x12\n" +
+	"\x04mode\x18\v \x01(\rR\x04mode\x12;\n" +
+	"\vcreate_time\x18\f \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"createTime\"y\n" +
+	"\x11GetChunkHandleArg\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x14\n" +
+	"\x05index\x18\x02 \x01(\x03R\x05index\x12\x14\n" +
+	"\x05write\x18\x03 \x01(\bR\x05write\x12$\n" +
+	"\x04cred\x18\x04 \x01(\v2\x10.gfs.CredentialsR\x04cred\"-\n" +
+	"\x13GetChunkHandleReply\x12\x16\n" +
+	"\x06handle\x18\x01 \x01(\x03R\x06handle\"N\n" +
+	"\x0eGetReplicasArg\x12\x16\n" +
+	"\x06handle\x18\x01 \x01(\x03R\x06handle\x12$\n" +
+	"\x04cred\x18\x02 \x01(\v2\x10.gfs.CredentialsR\x04cred\"`\n" +
+	"\x10GetReplicasReply\x12\x1c\n" +
+	"\tlocations\x18\x01 \x03(\tR\tlocations\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\x03R\aversion\x12\x14\n" +
+	"\x05token\x18\x03 \x01(\tR\x05token\"\x96\x01\n" +
+	"\x1bGetPrimaryAndSecondariesArg\x12\x16\n" +
+	"\x06handle\x18\x01 \x01(\x03R\x06handle\x12#\n" +
+	"\rwriter_domain\x18\x02 \x01(\tR\fwriterDomain\x12$\n" +
+	"\x04cred\x18\x03 \x01(\v2\x10.gfs.CredentialsR\x04cred\x12\x14\n" +
+	"\x05trace\x18\x04 \x01(\tR\x05trace\"\xbb\x01\n" +
+	"\x1dGetPrimaryAndSecondariesReply\x12\x18\n" +
+	"\aprimary\x18\x01 \x01(\tR\aprimary\x122\n" +
+	"\x06expire\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x06expire\x12 \n" +
+	"\vsecondaries\x18\x03 \x03(\tR\vsecondaries\x12\x14\n" +
+	"\x05epoch\x18\x04 \x01(\x03R\x05epoch\x12\x14\n" +
+	"\x05token\x18\x05 \x01(\tR\x05token\"\xb5\x01\n" +
+	"\x0eForwardDataArg\x12*\n" +
+	"\adata_id\x18\x01 \x01(\v2\x11.gfs.DataBufferIDR\x06dataId\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\x12\x1f\n" +
+	"\vchain_order\x18\x03 \x03(\tR\n" +
+	"chainOrder\x12\x16\n" +
+	"\x06client\x18\x04 \x01(\tR\x06client\x12\x14\n" +
+	"\x05trace\x18\x05 \x01(\tR\x05trace\x12\x14\n" +
+	"\x05token\x18\x06 \x01(\tR\x05token\"A\n" +
+	"\x10ForwardDataReply\x12-\n" +
+	"\n" +
+	"error_code\x18\x01 \x01(\x0e2\x0e.gfs.ErrorCodeR\terrorCode\"\x80\x02\n" +
+	"\rWriteChunkArg\x12*\n" +
+	"\adata_id\x18\x01 \x01(\v2\x11.gfs.DataBufferIDR\x06dataId\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12 \n" +
+	"\vsecondaries\x18\x03 \x03(\tR\vsecondaries\x12\x14\n" +
+	"\x05epoch\x18\x04 \x01(\x03R\x05epoch\x12-\n" +
+	"\n" +
+	"request_id\x18\x05 \x01(\v2\x0e.gfs.RequestIDR\trequestId\x12\x14\n" +
+	"\x05token\x18\x06 \x01(\tR\x05token\x12\x14\n" +
+	"\x05trace\x18\a \x01(\tR\x05trace\x12\x18\n" +
+	"\adurable\x18\b \x01(\bR\adurable\"@\n" +
+	"\x0fWriteChunkReply\x12-\n" +
+	"\n" +
+	"error_code\x18\x01 \x01(\x0e2\x0e.gfs.ErrorCodeR\terrorCode\"\xe9\x01\n" +
+	"\x0eAppendChunkArg\x12*\n" +
+	"\adata_id\x18\x01 \x01(\v2\x11.gfs.DataBufferIDR\x06dataId\x12 \n" +
+	"\vsecondaries\x18\x02 \x03(\tR\vsecondaries\x12\x14\n" +
+	"\x05epoch\x18\x03 \x01(\x03R\x05epoch\x12-\n" +
+	"\n" +
+	"request_id\x18\x04 \x01(\v2\x0e.gfs.RequestIDR\trequestId\x12\x14\n" +
+	"\x05token\x18\x05 \x01(\tR\x05token\x12\x14\n" +
+	"\x05trace\x18\x06 \x01(\tR\x05trace\x12\x18\n" +
+	"\adurable\x18\a \x01(\bR\adurable\"Y\n" +
+	"\x10AppendChunkReply\x12\x16\n" +
+	"\x06offset\x18\x01 \x01(\x03R\x06offset\x12-\n" +
+	"\n" +
+	"error_code\x18\x02 \x01(\x0e2\x0e.gfs.ErrorCodeR\terrorCode\"\x84\x01\n" +
+	"\fReadChunkArg\x12\x16\n" +
+	"\x06handle\x18\x01 \x01(\x03R\x06handle\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x16\n" +
+	"\x06length\x18\x03 \x01(\x05R\x06length\x12\x14\n" +
+	"\x05token\x18\x04 \x01(\tR\x05token\x12\x16\n" +
+	"\x06client\x18\x05 \x01(\tR\x06client\"\xa8\x01\n" +
+	"\x0eReadChunkReply\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\x12\x16\n" +
+	"\x06length\x18\x02 \x01(\x05R\x06length\x12!\n" +
+	"\fchunk_length\x18\x03 \x01(\x03R\vchunkLength\x12\x18\n" +
+	"\aversion\x18\x04 \x01(\x03R\aversion\x12-\n" +
+	"\n" +
+	"error_code\x18\x05 \x01(\x0e2\x0e.gfs.ErrorCodeR\terrorCode*\xb4\x05\n" +
+	"\tErrorCode\x12\v\n" +
+	"\aSUCCESS\x10\x00\x12\x11\n" +
+	"\rUNKNOWN_ERROR\x10\x01\x12\v\n" +
+	"\aTIMEOUT\x10\x02\x12\x1c\n" +
+	"\x18APPEND_EXCEED_CHUNK_SIZE\x10\x03\x12\x1b\n" +
+	"\x17WRITE_EXCEED_CHUNK_SIZE\x10\x04\x12\f\n" +
+	"\bREAD_EOF\x10\x05\x12\x1a\n" +
+	"\x16NOT_AVAILABLE_FOR_COPY\x10\x06\x12\x13\n" +
+	"\x0fCHUNK_NOT_FOUND\x10\a\x12\x11\n" +
+	"\rSTALE_VERSION\x10\b\x12\x11\n" +
+	"\rLEASE_EXPIRED\x10\t\x12\x0f\n" +
+	"\vNOT_PRIMARY\x10\n" +
+	"\x12\x15\n" +
+	"\x11CHECKSUM_MISMATCH\x10\v\x12\x12\n" +
+	"\x0eDATA_NOT_FOUND\x10\f\x12\x0e\n" +
+	"\n" +
+	"NO_REPLICA\x10\r\x12\x16\n" +
+	"\x12NOT_ENOUGH_SERVERS\x10\x0e\x12\x14\n" +
+	"\x10SERVER_NOT_FOUND\x10\x0f\x12\x12\n" +
+	"\x0ePATH_NOT_FOUND\x10\x10\x12\x0f\n" +
+	"\vPATH_EXISTS\x10\x11\x12\x11\n" +
+	"\rNOT_DIRECTORY\x10\x12\x12\x10\n" +
+	"\fIS_DIRECTORY\x10\x13\x12\x14\n" +
+	"\x10INVALID_ARGUMENT\x10\x14\x12\x10\n" +
+	"\fPHYSICAL_EOF\x10\x15\x12\x12\n" +
+	"\x0eNOT_REGISTERED\x10\x16\x12\x0f\n" +
+	"\vSERVER_BUSY\x10\x17\x12\r\n" +
+	"\tTHROTTLED\x10\x18\x12\x17\n" +
+	"\x13GENERATION_MISMATCH\x10\x19\x12\x17\n" +
+	"\x13DIRECTORY_NOT_EMPTY\x10\x1a\x12\x15\n" +
+	"\x11PERMISSION_DENIED\x10\x1b\x12\x13\n" +
+	"\x0fUNAUTHENTICATED\x10\x1c\x12\x12\n" +
+	"\x0eQUOTA_EXCEEDED\x10\x1d\x12\r\n" +
+	"\tREAD_ONLY\x10\x1e\x12\x12\n" +
+	"\x0eIN_MAINTENANCE\x10\x1f\x12\x10\n" +
+	"\fCHUNK_EXISTS\x10 \x12\x13\n" +
+	"\x0fXATTR_NOT_FOUND\x10!2\x9b\x04\n" +
+	"\x06Master\x126\n" +
+	"\n" +
+	"CreateFile\x12\x12.gfs.CreateFileArg\x1a\x14.gfs.CreateFileReply\x12'\n" +
+	"\x05Mkdir\x12\r.gfs.MkdirArg\x1a\x0f.gfs.MkdirReply\x126\n" +
+	"\n" +
+	"DeleteFile\x12\x12.gfs.DeleteFileArg\x1a\x14.gfs.DeleteFileReply\x126\n" +
+	"\n" +
+	"RenameFile\x12\x12.gfs.RenameFileArg\x1a\x14.gfs.RenameFileReply\x12$\n" +
+	"\x04List\x12\f.gfs.ListArg\x1a\x0e.gfs.ListReply\x129\n" +
+	"\vGetFileInfo\x12\x13.gfs.GetFileInfoArg\x1a\x15.gfs.GetFileInfoReply\x12B\n" +
+	"\x0eGetChunkHandle\x12\x16.gfs.GetChunkHandleArg\x1a\x18.gfs.GetChunkHandleReply\x129\n" +
+	"\vGetReplicas\x12\x13.gfs.GetReplicasArg\x1a\x15.gfs.GetReplicasReply\x12`\n" +
+	"\x18GetPrimaryAndSecondaries\x12 .gfs.GetPrimaryAndSecondariesArg\x1a\".gfs.GetPrimaryAndSecondariesReply2\xf0\x01\n" +
+	"\vChunkServer\x129\n" +
+	"\vForwardData\x12\x13.gfs.ForwardDataArg\x1a\x15.gfs.ForwardDataReply\x126\n" +
+	"\n" +
+	"WriteChunk\x12\x12.gfs.WriteChunkArg\x1a\x14.gfs.WriteChunkReply\x129\n" +
+	"\vAppendChunk\x12\x13.gfs.AppendChunkArg\x1a\x15.gfs.AppendChunkReply\x123\n" +
+	"\tReadChunk\x12\x11.gfs.ReadChunkArg\x1a\x13.gfs.ReadChunkReplyB\bZ\x06gfs/pbb\x06proto3"
+
+var (
+	file_gfs_proto_rawDescOnce sync.Once
+	file_gfs_proto_rawDescData []byte
+)
+
+func file_gfs_proto_rawDescGZIP() []byte {
+	file_gfs_proto_rawDescOnce.Do(func() {
+		file_gfs_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_gfs_proto_rawDesc), len(file_gfs_proto_rawDesc)))
+	})
+	return file_gfs_proto_rawDescData
+}
+
+var file_gfs_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_gfs_proto_msgTypes = make([]protoimpl.MessageInfo, 31)
+var file_gfs_proto_goTypes = []any{
+	(ErrorCode)(0),                        // 0: gfs.ErrorCode
+	(*Error)(nil),                         // 1: gfs.Error
+	(*Credentials)(nil),                   // 2: gfs.Credentials
+	(*RequestID)(nil),                     // 3: gfs.RequestID
+	(*DataBufferID)(nil),                  // 4: gfs.DataBufferID
+	(*PathInfo)(nil),                      // 5: gfs.PathInfo
+	(*CreateFileArg)(nil),                 // 6: gfs.CreateFileArg
+	(*CreateFileReply)(nil),               // 7: gfs.CreateFileReply
+	(*MkdirArg)(nil),                      // 8: gfs.MkdirArg
+	(*MkdirReply)(nil),                    // 9: gfs.MkdirReply
+	(*DeleteFileArg)(nil),                 // 10: gfs.DeleteFileArg
+	(*DeleteFileReply)(nil),               // 11: gfs.DeleteFileReply
+	(*RenameFileArg)(nil),                 // 12: gfs.RenameFileArg
+	(*RenameFileReply)(nil),               // 13: gfs.RenameFileReply
+	(*ListArg)(nil),                       // 14: gfs.ListArg
+	(*ListReply)(nil),                     // 15: gfs.ListReply
+	(*GetFileInfoArg)(nil),                // 16: gfs.GetFileInfoArg
+	(*GetFileInfoReply)(nil),              // 17: gfs.GetFileInfoReply
+	(*GetChunkHandleArg)(nil),             // 18: gfs.GetChunkHandleArg
+	(*GetChunkHandleReply)(nil),           // 19: gfs.GetChunkHandleReply
+	(*GetReplicasArg)(nil),                // 20: gfs.GetReplicasArg
+	(*GetReplicasReply)(nil),              // 21: gfs.GetReplicasReply
+	(*GetPrimaryAndSecondariesArg)(nil),   // 22: gfs.GetPrimaryAndSecondariesArg
+	(*GetPrimaryAndSecondariesReply)(nil), // 23: gfs.GetPrimaryAndSecondariesReply
+	(*ForwardDataArg)(nil),                // 24: gfs.ForwardDataArg
+	(*ForwardDataReply)(nil),              // 25: gfs.ForwardDataReply
+	(*WriteChunkArg)(nil),                 // 26: gfs.WriteChunkArg
+	(*WriteChunkReply)(nil),               // 27: gfs.WriteChunkReply
+	(*AppendChunkArg)(nil),                // 28: gfs.AppendChunkArg
+	(*AppendChunkReply)(nil),              // 29: gfs.AppendChunkReply
+	(*ReadChunkArg)(nil),                  // 30: gfs.ReadChunkArg
+	(*ReadChunkReply)(nil),                // 31: gfs.ReadChunkReply
+	(*timestamppb.Timestamp)(nil),         // 32: google.protobuf.Timestamp
+}
+var file_gfs_proto_depIdxs = []int32{
+	0,  // 0: gfs.Error.code:type_name -> gfs.ErrorCode
+	2,  // 1: gfs.CreateFileArg.cred:type_name -> gfs.Credentials
+	2,  // 2: gfs.MkdirArg.cred:type_name -> gfs.Credentials
+	2,  // 3: gfs.DeleteFileArg.cred:type_name -> gfs.Credentials
+	2,  // 4: gfs.RenameFileArg.cred:type_name -> gfs.Credentials
+	2,  // 5: gfs.ListArg.cred:type_name -> gfs.Credentials
+	5,  // 6: gfs.ListReply.files:type_name -> gfs.PathInfo
+	2,  // 7: gfs.GetFileInfoArg.cred:type_name -> gfs.Credentials
+	32, // 8: gfs.GetFileInfoReply.mod_time:type_name -> google.protobuf.Timestamp
+	32, // 9: gfs.GetFileInfoReply.change_time:type_name -> google.protobuf.Timestamp
+	32, // 10: gfs.GetFileInfoReply.create_time:type_name -> google.protobuf.Timestamp
+	2,  // 11: gfs.GetChunkHandleArg.cred:type_name -> gfs.Credentials
+	2,  // 12: gfs.GetReplicasArg.cred:type_name -> gfs.Credentials
+	2,  // 13: gfs.GetPrimaryAndSecondariesArg.cred:type_name -> gfs.Credentials
+	32, // 14: gfs.GetPrimaryAndSecondariesReply.expire:type_name -> google.protobuf.Timestamp
+	4,  // 15: gfs.ForwardDataArg.data_id:type_name -> gfs.DataBufferID
+	0,  // 16: gfs.ForwardDataReply.error_code:type_name -> gfs.ErrorCode
+	4,  // 17: gfs.WriteChunkArg.data_id:type_name -> gfs.DataBufferID
+	3,  // 18: gfs.WriteChunkArg.request_id:type_name -> gfs.RequestID
+	0,  // 19: gfs.WriteChunkReply.error_code:type_name -> gfs.ErrorCode
+	4,  // 20: gfs.AppendChunkArg.data_id:type_name -> gfs.DataBufferID
+	3,  // 21: gfs.AppendChunkArg.request_id:type_name -> gfs.RequestID
+	0,  // 22: gfs.AppendChunkReply.error_code:type_name -> gfs.ErrorCode
+	0,  // 23: gfs.ReadChunkReply.error_code:type_name -> gfs.ErrorCode
+	6,  // 24: gfs.Master.CreateFile:input_type -> gfs.CreateFileArg
+	8,  // 25: gfs.Master.Mkdir:input_type -> gfs.MkdirArg
+	10, // 26: gfs.Master.DeleteFile:input_type -> gfs.DeleteFileArg
+	12, // 27: gfs.Master.RenameFile:input_type -> gfs.RenameFileArg
+	14, // 28: gfs.Master.List:input_type -> gfs.ListArg
+	16, // 29: gfs.Master.GetFileInfo:input_type -> gfs.GetFileInfoArg
+	18, // 30: gfs.Master.GetChunkHandle:input_type -> gfs.GetChunkHandleArg
+	20, // 31: gfs.Master.GetReplicas:input_type -> gfs.GetReplicasArg
+	22, // 32: gfs.Master.GetPrimaryAndSecondaries:input_type -> gfs.GetPrimaryAndSecondariesArg
+	24, // 33: gfs.ChunkServer.ForwardData:input_type -> gfs.ForwardDataArg
+	26, // 34: gfs.ChunkServer.WriteChunk:input_type -> gfs.WriteChunkArg
+	28, // 35: gfs.ChunkServer.AppendChunk:input_type -> gfs.AppendChunkArg
+	30, // 36: gfs.ChunkServer.ReadChunk:input_type -> gfs.ReadChunkArg
+	7,  // 37: gfs.Master.CreateFile:output_type -> gfs.CreateFileReply
+	9,  // 38: gfs.Master.Mkdir:output_type -> gfs.MkdirReply
+	11, // 39: gfs.Master.DeleteFile:output_type -> gfs.DeleteFileReply
+	13, // 40: gfs.Master.RenameFile:output_type -> gfs.RenameFileReply
+	15, // 41: gfs.Master.List:output_type -> gfs.ListReply
+	17, // 42: gfs.Master.GetFileInfo:output_type -> gfs.GetFileInfoReply
+	19, // 43: gfs.Master.GetChunkHandle:output_type -> gfs.GetChunkHandleReply
+	21, // 44: gfs.Master.GetReplicas:output_type -> gfs.GetReplicasReply
+	23, // 45: gfs.Master.GetPrimaryAndSecondaries:output_type -> gfs.GetPrimaryAndSecondariesReply
+	25, // 46: gfs.ChunkServer.ForwardData:output_type -> gfs.ForwardDataReply
+	27, // 47: gfs.ChunkServer.WriteChunk:output_type -> gfs.WriteChunkReply
+	29, // 48: gfs.ChunkServer.AppendChunk:output_type -> gfs.AppendChunkReply
+	31, // 49: gfs.ChunkServer.ReadChunk:output_type -> gfs.ReadChunkReply
+	37, // [37:50] is the sub-list for method output_type
+	24, // [24:37] is the sub-list for method input_type
+	24, // [24:24] is the sub-list for extension type_name
+	24, // [24:24] is the sub-list for extension extendee
+	0,  // [0:24] is the sub-list for field type_name
+}
+
+func init() { file_gfs_proto_init() }
+func file_gfs_proto_init() {
+	if File_gfs_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_gfs_proto_rawDesc), len(file_gfs_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   31,
+			NumExtensions: 0,
+			NumServices:   2,
+		},
+		GoTypes:           file_gfs_proto_goTypes,
+		DependencyIndexes: file_gfs_proto_depIdxs,
+		EnumInfos:         file_gfs_proto_enumTypes,
+		MessageInfos:      file_gfs_proto_msgTypes,
+	}.Build()
+	File_gfs_proto = out.File
+	file_gfs_proto_goTypes = nil
+	file_gfs_proto_depIdxs = nil
+}
@@ -0,0 +1,657 @@
+// The rpcs clients make to master and the chunkservers, see doc.go. Each
+// rpc is the net/rpc method of the same name, e.g. Master.RPCCreateFile,
+// and carries the fields of its args and its reply, by the same names. A
+// failed rpc has an Error in the details of its status.
+// Fields are only ever appended, numbers are never reused.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: gfs.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Master_CreateFile_FullMethodName               = "/gfs.Master/CreateFile"
+	Master_Mkdir_FullMethodName                    = "/gfs.Master/Mkdir"
+	Master_DeleteFile_FullMethodName               = "/gfs.Master/DeleteFile"
+	Master_RenameFile_FullMethodName               = "/gfs.Master/RenameFile"
+	Master_List_FullMethodName                     = "/gfs.Master/List"
+	Master_GetFileInfo_FullMethodName              = "/gfs.Master/GetFileInfo"
+	Master_GetChunkHandle_FullMethodName           = "/gfs.Master/GetChunkHandle"
+	Master_GetReplicas_FullMethodName              = "/gfs.Master/GetReplicas"
+	Master_GetPrimaryAndSecondaries_FullMethodName = "/gfs.Master/GetPrimaryAndSecondaries"
+)
+
+// MasterClient is the client API for Master service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Master serves the namespace and the locations of the chunks.
+type MasterClient interface {
+	CreateFile(ctx context.Context, in *CreateFileArg, opts ...grpc.CallOption) (*CreateFileReply, error)
+	Mkdir(ctx context.Context, in *MkdirArg, opts ...grpc.CallOption) (*MkdirReply, error)
+	DeleteFile(ctx context.Context, in *DeleteFileArg, opts ...grpc.CallOption) (*DeleteFileReply, error)
+	RenameFile(ctx context.Context, in *RenameFileArg, opts ...grpc.CallOption) (*RenameFileReply, error)
+	List(ctx context.Context, in *ListArg, opts ...grpc.CallOption) (*ListReply, error)
+	GetFileInfo(ctx context.Context, in *GetFileInfoArg, opts ...grpc.CallOption) (*GetFileInfoReply, error)
+	GetChunkHandle(ctx context.Context, in *GetChunkHandleArg, opts ...grpc.CallOption) (*GetChunkHandleReply, error)
+	GetReplicas(ctx context.Context, in *GetReplicasArg, opts ...grpc.CallOption) (*GetReplicasReply, error)
+	GetPrimaryAndSecondaries(ctx context.Context, in *GetPrimaryAndSecondariesArg, opts ...grpc.CallOption) (*GetPrimaryAndSecondariesReply, error)
+}
+
+type masterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMasterClient(cc grpc.ClientConnInterface) MasterClient {
+	return &masterClient{cc}
+}
+
+func (c *masterClient) CreateFile(ctx context.Context, in *CreateFileArg, opts ...grpc.CallOption) (*CreateFileReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateFileReply)
+	err := c.cc.Invoke(ctx, Master_CreateFile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) Mkdir(ctx context.Context, in *MkdirArg, opts ...grpc.CallOption) (*MkdirReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MkdirReply)
+	err := c.cc.Invoke(ctx, Master_Mkdir_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) DeleteFile(ctx context.Context, in *DeleteFileArg, opts ...grpc.CallOption) (*DeleteFileReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteFileReply)
+	err := c.cc.Invoke(ctx, Master_DeleteFile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) RenameFile(ctx context.Context, in *RenameFileArg, opts ...grpc.CallOption) (*RenameFileReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RenameFileReply)
+	err := c.cc.Invoke(ctx, Master_RenameFile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) List(ctx context.Context, in *ListArg, opts ...grpc.CallOption) (*ListReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListReply)
+	err := c.cc.Invoke(ctx, Master_List_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) GetFileInfo(ctx context.Context, in *GetFileInfoArg, opts ...grpc.CallOption) (*GetFileInfoReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetFileInfoReply)
+	err := c.cc.Invoke(ctx, Master_GetFileInfo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) GetChunkHandle(ctx context.Context, in *GetChunkHandleArg, opts ...grpc.CallOption) (*GetChunkHandleReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetChunkHandleReply)
+	err := c.cc.Invoke(ctx, Master_GetChunkHandle_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) GetReplicas(ctx context.Context, in *GetReplicasArg, opts ...grpc.CallOption) (*GetReplicasReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetReplicasReply)
+	err := c.cc.Invoke(ctx, Master_GetReplicas_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) GetPrimaryAndSecondaries(ctx context.Context, in *GetPrimaryAndSecondariesArg, opts ...grpc.CallOption) (*GetPrimaryAndSecondariesReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetPrimaryAndSecondariesReply)
+	err := c.cc.Invoke(ctx, Master_GetPrimaryAndSecondaries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MasterServer is the server API for Master service.
+// All implementations must embed UnimplementedMasterServer
+// for forward compatibility.
+//
+// Master serves the namespace and the locations of the chunks.
+type MasterServer interface {
+	CreateFile(context.Context, *CreateFileArg) (*CreateFileReply, error)
+	Mkdir(context.Context, *MkdirArg) (*MkdirReply, error)
+	DeleteFile(context.Context, *DeleteFileArg) (*DeleteFileReply, error)
+	RenameFile(context.Context, *RenameFileArg) (*RenameFileReply, error)
+	List(context.Context, *ListArg) (*ListReply, error)
+	GetFileInfo(context.Context, *GetFileInfoArg) (*GetFileInfoReply, error)
+	GetChunkHandle(context.Context, *GetChunkHandleArg) (*GetChunkHandleReply, error)
+	GetReplicas(context.Context, *GetReplicasArg) (*GetReplicasReply, error)
+	GetPrimaryAndSecondaries(context.Context, *GetPrimaryAndSecondariesArg) (*GetPrimaryAndSecondariesReply, error)
+	mustEmbedUnimplementedMasterServer()
+}
+
+// UnimplementedMasterServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMasterServer struct{}
+
+func (UnimplementedMasterServer) CreateFile(context.Context, *CreateFileArg) (*CreateFileReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method CreateFile not implemented")
+}
+func (UnimplementedMasterServer) Mkdir(context.Context, *MkdirArg) (*MkdirReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method Mkdir not implemented")
+}
+func (UnimplementedMasterServer) DeleteFile(context.Context, *DeleteFileArg) (*DeleteFileReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method DeleteFile not implemented")
+}
+func (UnimplementedMasterServer) RenameFile(context.Context, *RenameFileArg) (*RenameFileReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method RenameFile not implemented")
+}
+func (UnimplementedMasterServer) List(context.Context, *ListArg) (*ListReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedMasterServer) GetFileInfo(context.Context, *GetFileInfoArg) (*GetFileInfoReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetFileInfo not implemented")
+}
+func (UnimplementedMasterServer) GetChunkHandle(context.Context, *GetChunkHandleArg) (*GetChunkHandleReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetChunkHandle not implemented")
+}
+func (UnimplementedMasterServer) GetReplicas(context.Context, *GetReplicasArg) (*GetReplicasReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetReplicas not implemented")
+}
+func (UnimplementedMasterServer) GetPrimaryAndSecondaries(context.Context, *GetPrimaryAndSecondariesArg) (*GetPrimaryAndSecondariesReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetPrimaryAndSecondaries not implemented")
+}
+func (UnimplementedMasterServer) mustEmbedUnimplementedMasterServer() {}
+func (UnimplementedMasterServer) testEmbeddedByValue()                {}
+
+// UnsafeMasterServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MasterServer will
+// result in compilation errors.
+type UnsafeMasterServer interface {
+	mustEmbedUnimplementedMasterServer()
+}
+
+func RegisterMasterServer(s grpc.ServiceRegistrar, srv MasterServer) {
+	// If the following call panics, it indicates UnimplementedMasterServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Master_ServiceDesc, srv)
+}
+
+func _Master_CreateFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateFileArg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).CreateFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Master_CreateFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).CreateFile(ctx, req.(*CreateFileArg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_Mkdir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MkdirArg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).Mkdir(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Master_Mkdir_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).Mkdir(ctx, req.(*MkdirArg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_DeleteFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteFileArg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).DeleteFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Master_DeleteFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).DeleteFile(ctx, req.(*DeleteFileArg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_RenameFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenameFileArg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).RenameFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Master_RenameFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).RenameFile(ctx, req.(*RenameFileArg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListArg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Master_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).List(ctx, req.(*ListArg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_GetFileInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetFileInfoArg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).GetFileInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Master_GetFileInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).GetFileInfo(ctx, req.(*GetFileInfoArg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_GetChunkHandle_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetChunkHandleArg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).GetChunkHandle(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Master_GetChunkHandle_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).GetChunkHandle(ctx, req.(*GetChunkHandleArg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_GetReplicas_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetReplicasArg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).GetReplicas(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Master_GetReplicas_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).GetReplicas(ctx, req.(*GetReplicasArg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_GetPrimaryAndSecondaries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPrimaryAndSecondariesArg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).GetPrimaryAndSecondaries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Master_GetPrimaryAndSecondaries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).GetPrimaryAndSecondaries(ctx, req.(*GetPrimaryAndSecondariesArg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Master_ServiceDesc is the grpc.ServiceDesc for Master service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Master_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gfs.Master",
+	HandlerType: (*MasterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateFile",
+			Handler:    _Master_CreateFile_Handler,
+		},
+		{
+			MethodName: "Mkdir",
+			Handler:    _Master_Mkdir_Handler,
+		},
+		{
+			MethodName: "DeleteFile",
+			Handler:    _Master_DeleteFile_Handler,
+		},
+		{
+			MethodName: "RenameFile",
+			Handler:    _Master_RenameFile_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _Master_List_Handler,
+		},
+		{
+			MethodName: "GetFileInfo",
+			Handler:    _Master_GetFileInfo_Handler,
+		},
+		{
+			MethodName: "GetChunkHandle",
+			Handler:    _Master_GetChunkHandle_Handler,
+		},
+		{
+			MethodName: "GetReplicas",
+			Handler:    _Master_GetReplicas_Handler,
+		},
+		{
+			MethodName: "GetPrimaryAndSecondaries",
+			Handler:    _Master_GetPrimaryAndSecondaries_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "gfs.proto",
+}
+
+const (
+	ChunkServer_ForwardData_FullMethodName = "/gfs.ChunkServer/ForwardData"
+	ChunkServer_WriteChunk_FullMethodName  = "/gfs.ChunkServer/WriteChunk"
+	ChunkServer_AppendChunk_FullMethodName = "/gfs.ChunkServer/AppendChunk"
+	ChunkServer_ReadChunk_FullMethodName   = "/gfs.ChunkServer/ReadChunk"
+)
+
+// ChunkServerClient is the client API for ChunkServer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ChunkServer serves the data of chunks. Data is pushed along the chain of
+// the replicas first, then written or appended by the primary.
+type ChunkServerClient interface {
+	ForwardData(ctx context.Context, in *ForwardDataArg, opts ...grpc.CallOption) (*ForwardDataReply, error)
+	WriteChunk(ctx context.Context, in *WriteChunkArg, opts ...grpc.CallOption) (*WriteChunkReply, error)
+	AppendChunk(ctx context.Context, in *AppendChunkArg, opts ...grpc.CallOption) (*AppendChunkReply, error)
+	ReadChunk(ctx context.Context, in *ReadChunkArg, opts ...grpc.CallOption) (*ReadChunkReply, error)
+}
+
+type chunkServerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewChunkServerClient(cc grpc.ClientConnInterface) ChunkServerClient {
+	return &chunkServerClient{cc}
+}
+
+func (c *chunkServerClient) ForwardData(ctx context.Context, in *ForwardDataArg, opts ...grpc.CallOption) (*ForwardDataReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ForwardDataReply)
+	err := c.cc.Invoke(ctx, ChunkServer_ForwardData_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chunkServerClient) WriteChunk(ctx context.Context, in *WriteChunkArg, opts ...grpc.CallOption) (*WriteChunkReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WriteChunkReply)
+	err := c.cc.Invoke(ctx, ChunkServer_WriteChunk_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chunkServerClient) AppendChunk(ctx context.Context, in *AppendChunkArg, opts ...grpc.CallOption) (*AppendChunkReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AppendChunkReply)
+	err := c.cc.Invoke(ctx, ChunkServer_AppendChunk_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chunkServerClient) ReadChunk(ctx context.Context, in *ReadChunkArg, opts ...grpc.CallOption) (*ReadChunkReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReadChunkReply)
+	err := c.cc.Invoke(ctx, ChunkServer_ReadChunk_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ChunkServerServer is the server API for ChunkServer service.
+// All implementations must embed UnimplementedChunkServerServer
+// for forward compatibility.
+//
+// ChunkServer serves the data of chunks. Data is pushed along the chain of
+// the replicas first, then written or appended by the primary.
+type ChunkServerServer interface {
+	ForwardData(context.Context, *ForwardDataArg) (*ForwardDataReply, error)
+	WriteChunk(context.Context, *WriteChunkArg) (*WriteChunkReply, error)
+	AppendChunk(context.Context, *AppendChunkArg) (*AppendChunkReply, error)
+	ReadChunk(context.Context, *ReadChunkArg) (*ReadChunkReply, error)
+	mustEmbedUnimplementedChunkServerServer()
+}
+
+// UnimplementedChunkServerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedChunkServerServer struct{}
+
+func (UnimplementedChunkServerServer) ForwardData(context.Context, *ForwardDataArg) (*ForwardDataReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method ForwardData not implemented")
+}
+func (UnimplementedChunkServerServer) WriteChunk(context.Context, *WriteChunkArg) (*WriteChunkReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method WriteChunk not implemented")
+}
+func (UnimplementedChunkServerServer) AppendChunk(context.Context, *AppendChunkArg) (*AppendChunkReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method AppendChunk not implemented")
+}
+func (UnimplementedChunkServerServer) ReadChunk(context.Context, *ReadChunkArg) (*ReadChunkReply, error) {
+	return nil, status.Error(codes.Unimplemented, "method ReadChunk not implemented")
+}
+func (UnimplementedChunkServerServer) mustEmbedUnimplementedChunkServerServer() {}
+func (UnimplementedChunkServerServer) testEmbeddedByValue()                     {}
+
+// UnsafeChunkServerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ChunkServerServer will
+// result in compilation errors.
+type UnsafeChunkServerServer interface {
+	mustEmbedUnimplementedChunkServerServer()
+}
+
+func RegisterChunkServerServer(s grpc.ServiceRegistrar, srv ChunkServerServer) {
+	// If the following call panics, it indicates UnimplementedChunkServerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ChunkServer_ServiceDesc, srv)
+}
+
+func _ChunkServer_ForwardData_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ForwardDataArg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChunkServerServer).ForwardData(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChunkServer_ForwardData_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChunkServerServer).ForwardData(ctx, req.(*ForwardDataArg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChunkServer_WriteChunk_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WriteChunkArg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChunkServerServer).WriteChunk(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChunkServer_WriteChunk_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChunkServerServer).WriteChunk(ctx, req.(*WriteChunkArg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChunkServer_AppendChunk_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AppendChunkArg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChunkServerServer).AppendChunk(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChunkServer_AppendChunk_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChunkServerServer).AppendChunk(ctx, req.(*AppendChunkArg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChunkServer_ReadChunk_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadChunkArg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChunkServerServer).ReadChunk(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChunkServer_ReadChunk_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChunkServerServer).ReadChunk(ctx, req.(*ReadChunkArg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ChunkServer_ServiceDesc is the grpc.ServiceDesc for ChunkServer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ChunkServer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gfs.ChunkServer",
+	HandlerType: (*ChunkServerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ForwardData",
+			Handler:    _ChunkServer_ForwardData_Handler,
+		},
+		{
+			MethodName: "WriteChunk",
+			Handler:    _ChunkServer_WriteChunk_Handler,
+		},
+		{
+			MethodName: "AppendChunk",
+			Handler:    _ChunkServer_AppendChunk_Handler,
+		},
+		{
+			MethodName: "ReadChunk",
+			Handler:    _ChunkServer_ReadChunk_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "gfs.proto",
+}
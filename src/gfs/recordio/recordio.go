@@ -0,0 +1,287 @@
+// Package recordio frames records appended to a gfs file, and reads them
+// back the way applications of record append are supposed to.
+//
+// Record append is at least once: a record may be duplicated by a retry of
+// the client, regions of a chunk may be padded with zeros, and an append
+// failed on some replicas leaves torn data. Each record is framed with a
+// magic, its length, the id of its writer, a sequence number and a checksum.
+// The reader skips padding and torn data, drops the duplicates by the writer
+// and sequence number, and yields every record appended once.
+package recordio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"sync/atomic"
+
+	"gfs"
+)
+
+// HeaderSize is the bytes framing a record: the magic, the length of the
+// data, the writer, the sequence number and the checksum.
+const HeaderSize = 28
+
+// MaxRecordSize is the largest data of a record.
+const MaxRecordSize = gfs.MaxAppendSize - HeaderSize
+
+const readSize = 1 << 20 // bytes read from the file at a time
+
+var magic = []byte{'g', 'f', 's', 'r'}
+
+// Appender appends data to a file, as gfs/client.ClientAPI does.
+type Appender interface {
+	Append(ctx context.Context, path gfs.Path, data []byte) (offset gfs.Offset, err error)
+}
+
+// ReaderAt reads a file at an offset, as gfs/client.ClientAPI does.
+type ReaderAt interface {
+	Read(ctx context.Context, path gfs.Path, offset gfs.Offset, data []byte) (n int, err error)
+}
+
+// Record is a record read from a file.
+type Record struct {
+	Offset gfs.Offset // of the header in the file
+	Writer uint64
+	Seq    uint64
+	Data   []byte
+}
+
+// Writer appends records to a file. It is safe for concurrent use.
+type Writer struct {
+	c    Appender
+	path gfs.Path
+	id   uint64
+	seq  uint64 // of the last record, accessed atomically
+}
+
+// NewWriter returns a writer of records to path, with a random writer id.
+func NewWriter(c Appender, path gfs.Path) *Writer {
+	return &Writer{c: c, path: path, id: rand.Uint64()}
+}
+
+// ID returns the writer id put in the records.
+func (w *Writer) ID() uint64 {
+	return w.id
+}
+
+// Append appends data as a record, and returns the offset of the record.
+// A record is appended at least once, the reader drops the duplicates.
+func (w *Writer) Append(ctx context.Context, data []byte) (gfs.Offset, error) {
+	if len(data) > MaxRecordSize {
+		return 0, gfs.Error{gfs.InvalidArgument, fmt.Sprintf("len(data) = %v > max record size %v", len(data), MaxRecordSize)}
+	}
+	return w.c.Append(ctx, w.path, encode(w.id, atomic.AddUint64(&w.seq, 1), data))
+}
+
+func encode(writer, seq uint64, data []byte) []byte {
+	buf := make([]byte, HeaderSize+len(data))
+	copy(buf, magic)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(data)))
+	binary.LittleEndian.PutUint64(buf[8:], writer)
+	binary.LittleEndian.PutUint64(buf[16:], seq)
+	copy(buf[HeaderSize:], data)
+	binary.LittleEndian.PutUint32(buf[24:], checksum(buf))
+	return buf
+}
+
+// checksum returns the crc32 of a framed record but the magic and the checksum.
+func checksum(buf []byte) uint32 {
+	h := crc32.NewIEEE()
+	h.Write(buf[4:24])
+	h.Write(buf[HeaderSize:])
+	return h.Sum32()
+}
+
+// Stats counts what a reader has read and skipped.
+type Stats struct {
+	Records    int64 // returned
+	Duplicates int64 // dropped, returned before
+	Corrupt    int64 // framed records failing the checksum, torn or overwritten
+	Skipped    int64 // bytes of padding and torn data, the corrupt records included
+}
+
+// Reader reads the records of a file in order. Padding and torn data are
+// skipped, and a record appended more than once is returned the first time.
+type Reader struct {
+	ctx    context.Context
+	c      ReaderAt
+	path   gfs.Path
+	buf    []byte
+	start  int        // of the unread data in buf
+	offset gfs.Offset // in the file of buf[start]
+	seen   map[uint64]*seqSet
+	stats  Stats
+}
+
+// NewReader returns a reader of the records of path from its beginning.
+func NewReader(ctx context.Context, c ReaderAt, path gfs.Path) *Reader {
+	return &Reader{
+		ctx:  ctx,
+		c:    c,
+		path: path,
+		buf:  make([]byte, 0, readSize),
+		seen: make(map[uint64]*seqSet),
+	}
+}
+
+// Stats returns the counts of the reader so far.
+func (r *Reader) Stats() Stats {
+	return r.stats
+}
+
+// Offset returns the offset in the file the next record is looked for at.
+func (r *Reader) Offset() gfs.Offset {
+	return r.offset
+}
+
+// Next returns the next record. io.EOF is returned at the end of the file,
+// where a partial record is left as it may still be appended, so Next can
+// be called again as the file grows.
+func (r *Reader) Next() (Record, error) {
+	for {
+		if err := r.fill(HeaderSize); err != nil {
+			return Record{}, err
+		}
+		data := r.buf[r.start:]
+		if len(data) < HeaderSize {
+			r.skipPadding()
+			return Record{}, io.EOF
+		}
+
+		if !bytes.HasPrefix(data, magic) {
+			r.resync()
+			continue
+		}
+		length := int(binary.LittleEndian.Uint32(data[4:]))
+		if length > MaxRecordSize {
+			r.corrupt()
+			continue
+		}
+		if err := r.fill(HeaderSize + length); err != nil {
+			return Record{}, err
+		}
+		data = r.buf[r.start:]
+		if len(data) < HeaderSize+length {
+			return Record{}, io.EOF
+		}
+		framed := data[:HeaderSize+length]
+		if checksum(framed) != binary.LittleEndian.Uint32(framed[24:]) {
+			r.corrupt()
+			continue
+		}
+
+		rec := Record{
+			Offset: r.offset,
+			Writer: binary.LittleEndian.Uint64(framed[8:]),
+			Seq:    binary.LittleEndian.Uint64(framed[16:]),
+			Data:   append([]byte(nil), framed[HeaderSize:]...),
+		}
+		r.advance(len(framed))
+
+		s, ok := r.seen[rec.Writer]
+		if !ok {
+			s = &seqSet{above: make(map[uint64]bool)}
+			r.seen[rec.Writer] = s
+		}
+		if !s.add(rec.Seq) {
+			r.stats.Duplicates++
+			continue
+		}
+		r.stats.Records++
+		return rec, nil
+	}
+}
+
+// fill reads the file until n bytes are unread in buf, or the file ends.
+func (r *Reader) fill(n int) error {
+	for len(r.buf)-r.start < n {
+		if r.start > 0 {
+			r.buf = r.buf[:copy(r.buf, r.buf[r.start:])]
+			r.start = 0
+		}
+		if want := n + readSize; cap(r.buf) < want {
+			buf := make([]byte, len(r.buf), want)
+			copy(buf, r.buf)
+			r.buf = buf
+		}
+
+		m := len(r.buf)
+		k, err := r.c.Read(r.ctx, r.path, r.offset+gfs.Offset(m), r.buf[m:cap(r.buf)])
+		if k > 0 {
+			r.buf = r.buf[:m+k]
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Reader) advance(n int) {
+	r.start += n
+	r.offset += gfs.Offset(n)
+}
+
+// resync skips to the next magic. If there is none in buf, all but the
+// bytes which may begin one are skipped.
+func (r *Reader) resync() {
+	data := r.buf[r.start:]
+	i := bytes.Index(data[1:], magic)
+	if i < 0 {
+		i = len(data) - len(magic)
+	}
+	r.skip(i + 1)
+}
+
+// corrupt skips a framed record failing the checksum, by its magic.
+func (r *Reader) corrupt() {
+	r.stats.Corrupt++
+	r.resync()
+}
+
+// skipPadding skips the bytes left at the end of the file, if they are zeros.
+func (r *Reader) skipPadding() {
+	data := r.buf[r.start:]
+	for _, b := range data {
+		if b != 0 {
+			return
+		}
+	}
+	r.skip(len(data))
+}
+
+func (r *Reader) skip(n int) {
+	if n <= 0 {
+		return
+	}
+	r.stats.Skipped += int64(n)
+	r.advance(n)
+}
+
+// seqSet is the sequence numbers of a writer read. The numbers up to low are
+// all read, so that a writer appending in order is kept in constant space.
+type seqSet struct {
+	low   uint64
+	above map[uint64]bool
+}
+
+// add adds seq, and returns whether it was not read before.
+func (s *seqSet) add(seq uint64) bool {
+	if seq <= s.low || s.above[seq] {
+		return false
+	}
+	s.above[seq] = true
+	for s.above[s.low+1] {
+		delete(s.above, s.low+1)
+		s.low++
+	}
+	return true
+}
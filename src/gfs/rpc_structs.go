@@ -1,6 +1,7 @@
 package gfs
 
 import (
+	"os"
 	"time"
 )
 
@@ -10,34 +11,74 @@ import (
 
 // handshake
 type CheckVersionArg struct {
-	Handle  ChunkHandle
-	Version ChunkVersion
+	Handle   ChunkHandle
+	Version  ChunkVersion
+	Throttle ThrottlePolicy // of the file when the lease is granted, enforced by the primary
+	Token    string         // server token of master
 }
 type CheckVersionReply struct {
-	Stale bool
+	Stale  bool
+	Length Offset // of the replica, if not stale
+}
+
+type RevokeLeaseArg struct {
+	Handle ChunkHandle
+	Expire time.Time // of the lease revoked
+	Token  string    // server token of master
+}
+type RevokeLeaseReply struct{}
+
+type TruncateChunkArg struct {
+	Handle      ChunkHandle
+	Length      Offset
+	Secondaries []ServerAddress
+	Epoch       ChunkVersion // version of the chunk the lease was granted at
+	Token       string       // server token of master
 }
+type TruncateChunkReply struct{}
 
 // chunk IO
 type ForwardDataArg struct {
 	DataID     DataBufferID
 	Data       []byte
 	ChainOrder []ServerAddress
+	Client     string // the data is accounted to in download buffers
+	Trace      string // of the operation pushing the data, see util.TraceID
+	Token      string // chunk token of the lease
 }
 type ForwardDataReply struct {
 	ErrorCode ErrorCode
 }
 
 type CreateChunkArg struct {
-	Handle ChunkHandle
+	Handle    ChunkHandle
+	ChunkSize Offset // 0 for gfs.MaxChunkSize
+	Fresh     bool   // of a new handle, refused with ChunkExists if the chunk exists
+	Token     string // server token of master
 }
 type CreateChunkReply struct {
 	ErrorCode ErrorCode
 }
 
+type CloneChunkArg struct {
+	Handle  ChunkHandle
+	Clone   ChunkHandle  // of the new chunk
+	Version ChunkVersion // of the chunk on master, a replica at another one is not cloned
+	Token   string       // server token of master
+}
+type CloneChunkReply struct {
+	Length Offset
+}
+
 type WriteChunkArg struct {
 	DataID      DataBufferID
 	Offset      Offset
 	Secondaries []ServerAddress
+	Epoch       ChunkVersion // of the lease the client holds
+	RequestID   RequestID    // the same across retries
+	Token       string       // chunk token of the lease
+	Trace       string       // of the write
+	Durable     bool         // synced to disk on the replicas before the reply
 }
 type WriteChunkReply struct {
 	ErrorCode ErrorCode
@@ -46,6 +87,11 @@ type WriteChunkReply struct {
 type AppendChunkArg struct {
 	DataID      DataBufferID
 	Secondaries []ServerAddress
+	Epoch       ChunkVersion // of the lease the client holds
+	RequestID   RequestID    // the same across retries
+	Token       string       // chunk token of the lease
+	Trace       string       // of the append
+	Durable     bool         // synced to disk on the replicas before the reply
 }
 type AppendChunkReply struct {
 	Offset    Offset
@@ -53,14 +99,35 @@ type AppendChunkReply struct {
 }
 
 type ApplyMutationArg struct {
-	Mtype  MutationType
-	DataID DataBufferID
-	Offset Offset
+	Mtype   MutationType
+	DataID  DataBufferID
+	Offset  Offset
+	Trace   string // of the mutation applied by the primary
+	Durable bool   // synced to disk before the reply
+	Token   string // server token of the primary
 }
 type ApplyMutationReply struct {
 	ErrorCode ErrorCode
 }
 
+type SyncChunkArg struct {
+	Handle ChunkHandle
+	Token  string // chunk token of the locations
+}
+type SyncChunkReply struct{}
+
+type ChunkHashArg struct {
+	Handle    ChunkHandle
+	Token     string // chunk token of the locations
+	Client    string // the data hashed is accounted to in rate limits
+	Recompute bool   // read the data again rather than the hash cached, to check the disk
+}
+type ChunkHashReply struct {
+	Version ChunkVersion
+	Length  Offset // of the data hashed
+	Sum     []byte // SHA-256 of the data of the chunk up to Length
+}
+
 type PadChunkArg struct {
 	Handle ChunkHandle
 }
@@ -72,31 +139,81 @@ type ReadChunkArg struct {
 	Handle ChunkHandle
 	Offset Offset
 	Length int
+	Token  string // chunk token of the replica locations
+	Client string // the read is accounted to in rate limits
 }
 type ReadChunkReply struct {
-	Data      []byte
-	Length    int
-	ErrorCode ErrorCode
+	Data        []byte
+	Length      int
+	ChunkLength Offset       // committed length of the chunk
+	Version     ChunkVersion // version of the replica read
+	ErrorCode   ErrorCode    // ReadEOF if the read is clamped by ChunkLength
+}
+
+// a short-circuit read, asked with a ReadChunkArg on the socket of util.LocalReadSocket
+type LocalReadReply struct {
+	ChunkLength Offset       // committed length of the chunk
+	Version     ChunkVersion // version of the replica
+	Error       string       // or the chunk file is passed along
 }
 
 // re-replication
 type SendCopyArg struct {
 	Handle  ChunkHandle
 	Address ServerAddress
+	Repair  bool   // send the ranges a stale replica at Address lacks, if it can
+	Token   string // server token of master
 }
 type SendCopyReply struct {
 	ErrorCode ErrorCode
+	Delta     bool // the replica was repaired, not copied
 }
 
 type ApplyCopyArg struct {
-	Handle  ChunkHandle
-	Data    []byte
-	Version ChunkVersion
+	Handle    ChunkHandle
+	Data      []byte
+	Offset    Offset // of the piece, 0 starts the copy over
+	Version   ChunkVersion
+	ChunkSize Offset
+	Last      bool   // the piece completes the copy
+	Token     string // server token of the sender
 }
 type ApplyCopyReply struct {
 	ErrorCode ErrorCode
 }
 
+type RepairStateArg struct {
+	Handle ChunkHandle
+	Token  string // server token of the repairing replica
+}
+type RepairStateReply struct {
+	Version ChunkVersion
+	Length  Offset
+}
+
+type ChunkRange struct {
+	Offset Offset
+	Data   []byte
+}
+type ApplyRepairArg struct {
+	Handle    ChunkHandle
+	From      ChunkVersion // of the stale replica
+	Ranges    []ChunkRange
+	Length    Offset
+	Version   ChunkVersion
+	ChunkSize Offset
+	Digest    []byte // sha256 of the repaired chunk
+	Token     string // server token of the repairing replica
+}
+type ApplyRepairReply struct{}
+
+type SetCopyLimitsArg struct {
+	PieceBytes  int         // 0 for gfs.CopyPieceBytes
+	BytesPerSec float64     // 0 means unlimited
+	Cred        Credentials // of SuperUser
+}
+type SetCopyLimitsReply struct{}
+
 // no use argument
 type Nouse struct{}
 
@@ -107,14 +224,63 @@ type Nouse struct{}
 // handshake
 type HeartbeatArg struct {
 	Address          ServerAddress // chunkserver address
-	LeaseExtensions  []ChunkHandle // leases to be extended
+	Domain           string        // failure domain (rack/zone) of the chunkserver
+	Labels           map[string]string
+	Topology         Topology      // zone and rack of the chunkserver
+	DiskUsed         int64         // bytes of chunk files
+	DiskFree         int64         // bytes available on the disk, -1 if unknown
+	Chunks           int           // chunks stored
+	IOLoad           int64         // bytes read and written per second since the last heartbeat
+	MutationLoad     int64         // mutations applied per second since the last heartbeat
+	LeaseExtensions  []ChunkHandle // leases used recently as primary, to be extended
+	LeaseReleases    []ChunkHandle // leases idle as primary, to be released
 	AbandondedChunks []ChunkHandle // unrecoverable chunks
+	ChunkLengths     []ChunkLength // of chunks mutated as primary since the last heartbeat
+	Token            string        // server token of the chunkserver
 }
 type HeartbeatReply struct {
-	Garbage []ChunkHandle
+	Garbage  []ChunkHandle     // replicas to delete
+	Revoked  []RevokeLeaseArg  // leases taken back, refused as primary until they would have expired
+	Stale    []ChunkHandle     // replicas left behind by a new version, abandoned until repaired
+	Versions []RequiredVersion // of replicas master discarded, abandoned if older
+}
+
+// RequiredVersion is the version master knows of a chunk, the replicas of an
+// older one are stale.
+type RequiredVersion struct {
+	Handle  ChunkHandle
+	Version ChunkVersion
+}
+
+type ReportDeadServerArg struct {
+	Address  ServerAddress // suspected dead server
+	Reporter ServerAddress
+	Token    string // server token of the reporter
+}
+type ReportDeadServerReply struct {
+	Removed bool
+}
+
+type DeregisterServerArg struct {
+	Address ServerAddress
+	Token   string // server token of the chunkserver
 }
+type DeregisterServerReply struct{}
+
+type RegisterServerArg struct {
+	Address  ServerAddress
+	Capacity int64 // bytes of the disk of the server root, -1 if unknown
+	Version  string
+	Domain   string
+	Labels   map[string]string
+	Topology Topology
+	Chunks   []PersistentChunkInfo // initial chunk inventory
+	Token    string                // server token of the chunkserver
+}
+type RegisterServerReply struct{}
 
 type ReportSelfArg struct {
+	Token string // server token of master
 }
 type ReportSelfReply struct {
 	Chunks []PersistentChunkInfo
@@ -122,17 +288,23 @@ type ReportSelfReply struct {
 
 // chunk info
 type GetPrimaryAndSecondariesArg struct {
-	Handle ChunkHandle
+	Handle       ChunkHandle
+	WriterDomain string // failure domain of the writer, a hint for primary placement
+	Cred         Credentials
+	Trace        string // of the mutation the lease is asked for
 }
 type GetPrimaryAndSecondariesReply struct {
 	Primary     ServerAddress
 	Expire      time.Time
 	Secondaries []ServerAddress
+	Epoch       ChunkVersion // passed in mutations, checked by the primary
+	Token       string       // passed in mutations, checked by the chunkservers
 }
 
 type ExtendLeaseArg struct {
 	Handle  ChunkHandle
 	Address ServerAddress
+	Token   string // server token of the primary
 }
 type ExtendLeaseReply struct {
 	Expire time.Time
@@ -140,53 +312,474 @@ type ExtendLeaseReply struct {
 
 type GetReplicasArg struct {
 	Handle ChunkHandle
+	Cred   Credentials
 }
 type GetReplicasReply struct {
 	Locations []ServerAddress
+	Version   ChunkVersion // of the chunk on master
+	Token     string       // passed in reads, checked by the chunkservers
+}
+
+type ReportDivergenceArg struct {
+	Handle     ChunkHandle
+	Location   ServerAddress // of the replica read
+	Version    ChunkVersion  // of the replica read
+	Length     Offset        // committed length of the replica read
+	Divergence Divergence
+	Token      string // chunk token of the replicas read
+}
+type ReportDivergenceReply struct {
+	Dropped []ServerAddress // replicas found divergent by master
+}
+
+type ReportBadReplicaArg struct {
+	Handle   ChunkHandle
+	Location ServerAddress // of the replica failing its checksums
+	Reason   string        // error of the read
+	Token    string        // chunk token of the replicas read
+}
+type ReportBadReplicaReply struct {
+	Dropped bool // the replica was found bad by master and dropped
 }
 
 type GetFileInfoArg struct {
 	Path Path
+	Cred Credentials
 }
 type GetFileInfoReply struct {
-	IsDir  bool
-	Length int64
-	Chunks int64
+	IsDir      bool
+	Length     int64
+	Chunks     int64
+	ChunkSize  int64
+	Replicas   int   // target number of replicas
+	Generation int64 // changed whenever clients change the length
+	CreateTime time.Time
+	ModTime    time.Time
+	ChangeTime time.Time
+	Owner      string
+	Group      string
+	Mode       os.FileMode
+}
+
+type OpenFileArg struct {
+	Path   Path
+	Create bool // create the file if it does not exist
+	Cred   Credentials
+}
+type OpenFileReply struct {
+	Length    int64
+	Chunks    int64
+	ChunkSize int64
+	Created   bool
 }
 
 type GetChunkHandleArg struct {
 	Path  Path
 	Index ChunkIndex
+	Write bool // the chunk is to be written, chunks allocated always are
+	Cred  Credentials
 }
 type GetChunkHandleReply struct {
 	Handle ChunkHandle
 }
 
+// batches of namespace operations, answered path by path in one call, the
+// errors of each in their string form, restored by gfs.ParseError, empty if
+// it succeeded
+type BatchCreateArg struct {
+	Paths     []Path
+	ChunkSize int64 // of every file, as in CreateFileArg
+	Cred      Credentials
+}
+type BatchCreateReply struct {
+	Errors []string
+}
+
+type BatchGetFileInfoArg struct {
+	Paths []Path
+	Cred  Credentials
+}
+type BatchGetFileInfoReply struct {
+	Files  []GetFileInfoReply
+	Errors []string
+}
+
+type BatchGetChunkHandleArg struct {
+	Paths   []Path
+	Indexes []ChunkIndex // of the chunk of each path
+	Write   bool
+	Cred    Credentials
+}
+type BatchGetChunkHandleReply struct {
+	Handles []ChunkHandle
+	Errors  []string
+}
+
+type ExtendFileArg struct {
+	Path       Path
+	Length     int64
+	Generation int64 // if not 0, fails with GenerationMismatch unless the file is at this generation
+	Cred       Credentials
+}
+type ExtendFileReply struct {
+	Length     int64
+	Chunks     int64
+	Generation int64
+}
+
+type CopyFileArg struct {
+	Source Path
+	Target Path // created, with the chunk size, replication and placement of Source
+	Cred   Credentials
+}
+type CopyFileReply struct {
+	Length int64
+	Chunks int64
+}
+
+type ConcatArg struct {
+	Target  Path
+	Sources []Path // removed, their chunks moved to the end of Target in order
+	Cred    Credentials
+}
+type ConcatReply struct {
+	Length     int64
+	Chunks     int64
+	Generation int64
+}
+
+type TruncateArg struct {
+	Path   Path
+	Length int64
+	Cred   Credentials
+}
+type TruncateReply struct {
+	Chunks     int64
+	Released   int // trailing chunks collected as garbage
+	Generation int64
+}
+
 // namespace operation
 type CreateFileArg struct {
-	Path Path
+	Path      Path
+	ChunkSize int64 // one of gfs.ChunkSizes, 0 for gfs.MaxChunkSize
+	Cred      Credentials
 }
 type CreateFileReply struct{}
 
 type DeleteFileArg struct {
-	Path Path
+	Path      Path
+	Recursive bool // delete a directory with its children
+	Cred      Credentials
+}
+type DeleteFileReply struct {
+	Files int // deleted, counting the subtree of a directory
+	Dirs  int
+	Trash Path // the path was moved to in TrashDir, empty if it was removed for good
+}
+
+type UndeleteArg struct {
+	Path Path // in TrashDir
+	Cred Credentials
+}
+type UndeleteReply struct {
+	Path Path // restored
+}
+
+type ReclaimTrashArg struct {
+	MinAge time.Duration // deletions older are removed for good
+	DryRun bool          // return the directories to be removed without removing them
+	Cred   Credentials   // of SuperUser
+}
+type ReclaimTrashReply struct {
+	Removed int           // directories of TrashDir, 0 with DryRun
+	Dirs    []TrashChunks // removed, or to be removed with DryRun
+}
+
+// TrashChunks is a directory of TrashDir and the chunks of the files in it.
+type TrashChunks struct {
+	Path   Path
+	Chunks int
 }
-type DeleteFileReply struct{}
 
 type RenameFileArg struct {
 	Source Path
 	Target Path
+	Cred   Credentials
 }
 type RenameFileReply struct{}
 
 type MkdirArg struct {
 	Path Path
+	Cred Credentials
 }
 type MkdirReply struct{}
 
 type ListArg struct {
-	Path Path
+	Path    Path
+	After   string // continuation token, Next of the previous page
+	Limit   int    // entries at most, 0 or above gfs.ListPageSize means gfs.ListPageSize
+	Prefix  string // of the names listed, empty lists all
+	Pattern string // glob the names listed match, as path.Match, empty lists all
+	Cred    Credentials
 }
 type ListReply struct {
-	Files []PathInfo
+	Files []PathInfo // sorted by name
+	Next  string     // continuation token, empty when done
+}
+
+type WalkArg struct {
+	Path  Path
+	After Path // resume after this entry, empty starts from Path
+	Limit int  // entries at most, 0 or above gfs.WalkPageSize means gfs.WalkPageSize
+	Cred  Credentials
+}
+type WalkReply struct {
+	Entries []FileInfo // Path and its subtree in depth-first order, children sorted by name
+	Next    Path       // After of the next page, empty when done
+}
+
+type DiskUsageArg struct {
+	Path Path
+	Cred Credentials
+}
+type DiskUsageReply struct {
+	Usage DiskUsage
+}
+
+type SetQuotaArg struct {
+	Path     Path
+	MaxFiles int64 // 0 is unlimited
+	MaxBytes int64 // 0 is unlimited
+	Cred     Credentials
+}
+type SetQuotaReply struct{}
+
+type GetQuotaUsageArg struct {
+	Path Path
+	Cred Credentials
+}
+type GetQuotaUsageReply struct {
+	Usage QuotaUsage
+}
+
+type ChmodArg struct {
+	Path Path
+	Mode os.FileMode // permission bits
+	Cred Credentials
+}
+type ChmodReply struct{}
+
+type ChownArg struct {
+	Path  Path
+	Owner string // empty keeps the owner
+	Group string // empty keeps the group
+	Cred  Credentials
+}
+type ChownReply struct{}
+
+type SetXattrArg struct {
+	Path  Path
+	Name  string
+	Value []byte // replaces the one set
+	Cred  Credentials
+}
+type SetXattrReply struct{}
+
+type GetXattrArg struct {
+	Path Path
+	Name string
+	Cred Credentials
+}
+type GetXattrReply struct {
+	Value []byte
+}
+
+type ListXattrsArg struct {
+	Path Path
+	Cred Credentials
+}
+type ListXattrsReply struct {
+	Names []string // sorted
+}
+
+type RemoveXattrArg struct {
+	Path Path
+	Name string
+	Cred Credentials
+}
+type RemoveXattrReply struct{}
+
+// admin
+type GetSlowQueriesArg struct {
+	Limit int         // 0 means all
+	Cred  Credentials // of SuperUser
+}
+type GetSlowQueriesReply struct {
+	Queries []SlowQuery
+}
+
+type BuildInfoReply struct {
+	BuildInfo
+}
+
+type ListServersArg struct {
+	Cred Credentials // of SuperUser
+}
+type ListServersReply struct {
+	Servers []ServerInfo
+}
+
+type GetTopologyArg struct {
+	Cred Credentials // of SuperUser
+}
+type GetTopologyReply struct {
+	Cluster ClusterTopology
+}
+
+type CollectEmptyDirsArg struct {
+	MinAge time.Duration // only directories empty for at least MinAge are removed
+	DryRun bool          // return the directories to be removed without removing them
+	Cred   Credentials   // of SuperUser
+}
+type CollectEmptyDirsReply struct {
+	Removed []Path
+}
+
+type SetDirProtectedArg struct {
+	Path      Path
+	Protected bool
+	Cred      Credentials // of SuperUser
+}
+type SetDirProtectedReply struct{}
+
+type SetPlacementArg struct {
+	Path        Path
+	Constraints []PlacementConstraint // replace the ones of the file, nil clears them
+	Cred        Credentials           // of SuperUser
+}
+type SetPlacementReply struct{}
+
+type SetThrottleArg struct {
+	Policy ThrottlePolicy // replaces the one of Policy.Path, an unlimited policy removes it
+	Cred   Credentials    // of SuperUser
+}
+type SetThrottleReply struct{}
+
+type ListThrottlesArg struct {
+	Cred Credentials // of SuperUser
+}
+type ListThrottlesReply struct {
+	Policies []ThrottlePolicy
+}
+
+type ImportNamespaceArg struct {
+	Entries []ImportEntry
+	Cred    Credentials // of SuperUser
+}
+type ImportNamespaceReply struct {
+	Files  int
+	Dirs   int // directories created
+	Chunks int // chunks allocated
+}
+
+type SetReplicationArg struct {
+	Path     Path
+	Replicas int
+	Cred     Credentials // of SuperUser
+}
+type SetReplicationReply struct{}
+
+type DecommissionServerArg struct {
+	Address ServerAddress
+	Cancel  bool        // put the server back in service
+	DryRun  bool        // return the plan without draining the server
+	Cred    Credentials // of SuperUser
+}
+type DecommissionServerReply struct {
+	Chunks int         // chunks to be copied elsewhere
+	Leases int         // leases of the server revoked
+	Plan   []ChunkMove // with DryRun, the copies re-replication would make now
+}
+
+type RebalanceArg struct {
+	DryRun bool        // return the plan of a round without moving chunks
+	Cred   Credentials // of SuperUser
+}
+type RebalanceReply struct {
+	Moves []ChunkMove // with DryRun, the moves a round would make now
+}
+
+// ChunkMove is a copy of a chunk from a server to another. To is empty if
+// no server can take the chunk.
+type ChunkMove struct {
+	Handle ChunkHandle
+	From   ServerAddress
+	To     ServerAddress
+}
+
+type FsckArg struct {
+	Path        Path        // of the subtree checked, "" for the root
+	Inventories bool        // cross-check the chunks reported by the chunkservers
+	Cred        Credentials // of SuperUser
+}
+type FsckReply struct {
+	Files       int
+	Chunks      int
+	Servers     int // whose inventory was checked
+	Unreachable []ServerAddress
+	Problems    []FsckProblem
+	More        int // problems past gfs.FsckMaxProblems
+}
+
+type GetReplicationStatusArg struct {
+	Path Path        // of a file or a subtree, "" for the cluster
+	Cred Credentials // of SuperUser
+}
+type GetReplicationStatusReply struct {
+	Files           int
+	Chunks          int
+	Replicated      int                    // chunks with as many live replicas as the replication of their file
+	UnderReplicated int                    // chunks with fewer, but some replicas
+	Missing         int                    // chunks without replicas
+	Under           []UnderReplicatedChunk // the under-replicated and missing, fewest live first, up to gfs.StatusMaxChunks
+	Queued          int                    // chunks of the cluster waiting for re-replication
+	Running         int                    // re-replications running
+}
+
+type DumpNamespaceArg struct {
+	Cred Credentials // of SuperUser
+}
+type DumpNamespaceReply struct {
+	Dump NamespaceDump
+}
+
+type SetModeArg struct {
+	Mode MasterMode
+	Cred Credentials // of SuperUser
+}
+type SetModeReply struct {
+	Previous MasterMode
+}
+
+type GetModeReply struct {
+	Mode MasterMode
+}
+
+type DecommissionStatusArg struct {
+	Address ServerAddress
+	Cred    Credentials // of SuperUser
+}
+type DecommissionStatusReply struct {
+	Draining  bool
+	Chunks    int // chunks on the server
+	Remaining int // chunks without enough replicas on other servers
+	Done      bool
+}
+
+type SetTopologyArg struct {
+	Address  ServerAddress
+	Topology Topology    // overrides the one reported by the chunkserver, the zero value removes the override
+	Cred     Credentials // of SuperUser
 }
+type SetTopologyReply struct{}
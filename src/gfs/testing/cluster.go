@@ -0,0 +1,198 @@
+// Package testing runs gfs clusters in the process of a test: a master and
+// chunkservers serving on unix domain sockets in a temporary directory, to
+// be killed and restarted, partitioned, delayed, dropped and corrupted.
+// It is imported under another name next to the standard testing package,
+// e.g. gfstesting.
+package testing
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"gfs"
+	"gfs/chunkserver"
+	"gfs/client"
+	"gfs/master"
+	"gfs/util"
+)
+
+// Options configures a cluster.
+type Options struct {
+	Servers int                                      // chunkservers, gfs.DefaultNumReplicas if 0
+	Master  func(m *master.Master)                   // configures master, when it starts and restarts
+	Server  func(i int, cs *chunkserver.ChunkServer) // configures chunkserver i, when it starts and restarts
+}
+
+// Cluster is a master and chunkservers in the process. The faults of its
+// rpcs are injected by Faults. A single cluster should run at a time.
+type Cluster struct {
+	Faults  *Faults
+	Master  *master.Master             // nil while killed
+	Servers []*chunkserver.ChunkServer // nil while killed
+
+	dir  string
+	opts Options
+}
+
+// NewCluster starts a cluster and waits for its chunkservers to register.
+func NewCluster(opts Options) (*Cluster, error) {
+	if opts.Servers == 0 {
+		opts.Servers = gfs.DefaultNumReplicas
+	}
+	dir, err := ioutil.TempDir("", "gfs-cluster")
+	if err != nil {
+		return nil, err
+	}
+	c := &Cluster{Faults: newFaults(), Servers: make([]*chunkserver.ChunkServer, opts.Servers), dir: dir, opts: opts}
+	util.SetFaultInjector(c.Faults)
+	if err := os.Mkdir(path.Join(dir, "m"), 0755); err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.RestartMaster()
+	for i := range c.Servers {
+		c.Restart(i)
+	}
+	if err := c.WaitForServers(10 * time.Second); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Cluster) address(name string) gfs.ServerAddress {
+	return gfs.ServerAddress(gfs.UnixPrefix + path.Join(c.dir, name+".sock"))
+}
+
+// MasterAddress returns the address of master.
+func (c *Cluster) MasterAddress() gfs.ServerAddress {
+	return c.address("m")
+}
+
+// Address returns the address of chunkserver i.
+func (c *Cluster) Address(i int) gfs.ServerAddress {
+	return c.address(fmt.Sprintf("cs%v", i))
+}
+
+// Addresses returns the addresses of the chunkservers.
+func (c *Cluster) Addresses() []gfs.ServerAddress {
+	addrs := make([]gfs.ServerAddress, len(c.Servers))
+	for i := range addrs {
+		addrs[i] = c.Address(i)
+	}
+	return addrs
+}
+
+// NewClient returns a client of the cluster.
+func (c *Cluster) NewClient(opts ...client.Option) *client.Client {
+	return client.NewClient(c.MasterAddress(), opts...)
+}
+
+// KillMaster shuts master down abruptly, as if it crashed.
+func (c *Cluster) KillMaster() {
+	if c.Master != nil {
+		c.Master.Shutdown()
+		c.Master = nil
+	}
+}
+
+// RestartMaster starts master again over its metadata, killing it first if
+// it runs.
+func (c *Cluster) RestartMaster() {
+	c.KillMaster()
+	c.Master = master.NewAndServe(c.MasterAddress(), path.Join(c.dir, "m"))
+	if c.opts.Master != nil {
+		c.opts.Master(c.Master)
+	}
+}
+
+// Kill shuts chunkserver i down abruptly, as if it crashed. Master takes it
+// for dead once its heartbeats time out.
+func (c *Cluster) Kill(i int) {
+	if c.Servers[i] != nil {
+		c.Servers[i].Shutdown()
+		c.Servers[i] = nil
+	}
+}
+
+// Restart starts chunkserver i again over its chunks, killing it first if it
+// runs.
+func (c *Cluster) Restart(i int) {
+	c.Kill(i)
+	root := path.Join(c.dir, fmt.Sprintf("cs%v", i))
+	os.MkdirAll(root, 0755)
+	c.Servers[i] = chunkserver.NewAndServe(c.Address(i), c.MasterAddress(), root)
+	if c.opts.Server != nil {
+		c.opts.Server(i, c.Servers[i])
+	}
+}
+
+// WaitForServers waits up to timeout for master to list the chunkservers
+// running.
+func (c *Cluster) WaitForServers(timeout time.Duration) error {
+	want := make(map[gfs.ServerAddress]bool)
+	for i, cs := range c.Servers {
+		if cs != nil {
+			want[c.Address(i)] = true
+		}
+	}
+	for start := time.Now(); ; time.Sleep(20 * time.Millisecond) {
+		var r gfs.ListServersReply
+		err := util.Call(context.Background(), c.MasterAddress(), "Master.RPCListServers", gfs.ListServersArg{}, &r)
+		listed := 0
+		for _, s := range r.Servers {
+			if want[s.Address] {
+				listed++
+			}
+		}
+		if err == nil && listed == len(want) {
+			return nil
+		}
+		if time.Since(start) > timeout {
+			return fmt.Errorf("%v of %v chunkservers registered after %v: %v", listed, len(want), timeout, err)
+		}
+	}
+}
+
+// CorruptChunk flips the bytes of the file of a chunk on chunkserver i from
+// offset on, length bytes at most, as a failing disk would.
+func (c *Cluster) CorruptChunk(i int, handle gfs.ChunkHandle, offset, length int64) error {
+	if c.Servers[i] == nil {
+		return fmt.Errorf("chunkserver %v is killed", i)
+	}
+	filename, ok := c.Servers[i].ChunkFile(handle)
+	if !ok {
+		return fmt.Errorf("chunkserver %v has no chunk %v", i, handle)
+	}
+	f, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buf := make([]byte, length)
+	n, err := f.ReadAt(buf, offset)
+	if n == 0 {
+		return fmt.Errorf("nothing to corrupt at %v of chunk %v: %v", offset, handle, err)
+	}
+	for j := range buf[:n] {
+		buf[j] ^= 0xff
+	}
+	_, err = f.WriteAt(buf[:n], offset)
+	return err
+}
+
+// Close shuts the servers down, stops injecting faults and removes the
+// files of the cluster.
+func (c *Cluster) Close() {
+	for i := range c.Servers {
+		c.Kill(i)
+	}
+	c.KillMaster()
+	util.SetFaultInjector(nil)
+	util.CloseIdleConnections()
+	os.RemoveAll(c.dir)
+}
@@ -0,0 +1,119 @@
+package testing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"gfs"
+)
+
+// Any matches every server, and the clients, in the rules of Faults.
+const Any gfs.ServerAddress = "*"
+
+// Client is the sender of the rpcs of the clients in the rules of Faults.
+const Client gfs.ServerAddress = ""
+
+// Faults is the util.FaultInjector of a cluster. Links between servers are
+// cut by partitions, and rpcs are delayed or dropped by rules, matched in
+// the order they were added. It is deterministic: rules apply to the first
+// rpcs that match them, and then expire.
+type Faults struct {
+	mu    sync.Mutex
+	cut   map[[2]gfs.ServerAddress]bool // from, to
+	rules []*rule
+}
+
+type rule struct {
+	from, to gfs.ServerAddress
+	method   string // of every rpc if empty
+	delay    time.Duration
+	drop     bool
+	times    int // rpcs left to match, forever if negative
+}
+
+func (r *rule) match(from, to gfs.ServerAddress, method string) bool {
+	return (r.from == Any || r.from == from) && (r.to == Any || r.to == to) && (r.method == "" || r.method == method) && r.times != 0
+}
+
+func newFaults() *Faults {
+	return &Faults{cut: make(map[[2]gfs.ServerAddress]bool)}
+}
+
+// Partition cuts the links between the servers of a and those of b, both
+// ways. Client stands for the clients.
+func (f *Faults) Partition(a, b []gfs.ServerAddress) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, x := range a {
+		for _, y := range b {
+			f.cut[[2]gfs.ServerAddress{x, y}] = true
+			f.cut[[2]gfs.ServerAddress{y, x}] = true
+		}
+	}
+}
+
+// Heal restores the links cut by partitions.
+func (f *Faults) Heal() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cut = make(map[[2]gfs.ServerAddress]bool)
+}
+
+// Delay delays the next times rpcs of method from a server to another by d,
+// all of them if times is negative. An empty method matches every rpc.
+func (f *Faults) Delay(from, to gfs.ServerAddress, method string, d time.Duration, times int) {
+	f.add(&rule{from, to, method, d, false, times})
+}
+
+// Drop fails the next times rpcs of method from a server to another without
+// sending them, as if the network lost them, all of them if times is
+// negative. An empty method matches every rpc.
+func (f *Faults) Drop(from, to gfs.ServerAddress, method string, times int) {
+	f.add(&rule{from, to, method, 0, true, times})
+}
+
+func (f *Faults) add(r *rule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, r)
+}
+
+// Clear removes the rules of Delay and Drop.
+func (f *Faults) Clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = nil
+}
+
+// Fault implements util.FaultInjector.
+func (f *Faults) Fault(ctx context.Context, from, to gfs.ServerAddress, method string) error {
+	f.mu.Lock()
+	if f.cut[[2]gfs.ServerAddress{from, to}] {
+		f.mu.Unlock()
+		return fmt.Errorf("%v to %v: network partitioned", method, to)
+	}
+	var matched *rule
+	for _, r := range f.rules {
+		if r.match(from, to, method) {
+			matched = r
+			r.times--
+			break
+		}
+	}
+	f.mu.Unlock()
+
+	switch {
+	case matched == nil:
+		return nil
+	case matched.drop:
+		return fmt.Errorf("%v to %v: dropped", method, to)
+	}
+	select {
+	case <-time.After(matched.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,28 @@
+package util
+
+import (
+	"net"
+	"time"
+)
+
+// DrainConns stops reading rpcs from the connections accepted by a server,
+// which answer the calls in flight and are then removed from conns by their
+// serving goroutines, and waits up to timeout for conns to be empty. It
+// returns whether it is. The listener should be closed first, connections
+// accepted meanwhile are stopped as well.
+func DrainConns(conns *ArraySet, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		all := conns.GetAll()
+		if len(all) == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		for _, v := range all {
+			v.(net.Conn).SetReadDeadline(time.Now())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
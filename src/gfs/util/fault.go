@@ -0,0 +1,58 @@
+package util
+
+import (
+	"context"
+	"sync"
+
+	"gfs"
+)
+
+// Faults are injected into the rpcs and the streams dialed by the process
+// in tests of failures, e.g. by the clusters of package gfs/testing. An rpc
+// is from the server set on its context by WithCaller, or from a client.
+
+// FaultInjector decides the fate of the rpcs dialed.
+type FaultInjector interface {
+	// Fault is called before an rpc from a server, empty for a client, to
+	// another, e.g. of method "Master.RPCHeartbeat". The rpc fails with the
+	// error returned, if any, without being sent. Fault may block to delay
+	// the rpc, until ctx is done.
+	Fault(ctx context.Context, from, to gfs.ServerAddress, method string) error
+}
+
+var faults struct {
+	sync.RWMutex
+	injector FaultInjector
+}
+
+// SetFaultInjector sets the injector of the faults of the rpcs, nil for none.
+func SetFaultInjector(f FaultInjector) {
+	faults.Lock()
+	defer faults.Unlock()
+	faults.injector = f
+}
+
+// injectFault returns the fault of an rpc, if any.
+func injectFault(ctx context.Context, to gfs.ServerAddress, method string) error {
+	faults.RLock()
+	f := faults.injector
+	faults.RUnlock()
+	if f == nil {
+		return nil
+	}
+	return f.Fault(ctx, Caller(ctx), to, method)
+}
+
+type callerKey struct{}
+
+// WithCaller returns ctx carrying the address of the server dialing rpcs
+// with it.
+func WithCaller(ctx context.Context, addr gfs.ServerAddress) context.Context {
+	return context.WithValue(ctx, callerKey{}, addr)
+}
+
+// Caller returns the server dialing rpcs with ctx, empty for a client.
+func Caller(ctx context.Context) gfs.ServerAddress {
+	addr, _ := ctx.Value(callerKey{}).(gfs.ServerAddress)
+	return addr
+}
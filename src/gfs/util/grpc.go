@@ -0,0 +1,105 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"gfs"
+)
+
+// gRPC connections, HTTP/2 ones, begin with the preface of the client, which
+// begins neither a gob message nor a stream, so gRPC is served on the port of
+// the rpcs of net/rpc as well.
+const grpcPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// IsGRPC returns conn, as accepted and wrapped by ServerTLS or returned by
+// IsStream, and whether it carries gRPC calls. It blocks reading the preface
+// of the peer, so it should be called by the goroutine serving conn.
+func IsGRPC(conn net.Conn) (net.Conn, bool) {
+	peeked, ok := conn.(*peekedConn)
+	if !ok {
+		peeked = &peekedConn{conn, bufio.NewReader(conn)}
+	}
+	if b, err := peeked.r.Peek(1); err != nil || b[0] != grpcPreface[0] {
+		return peeked, false
+	}
+	b, err := peeked.r.Peek(len(grpcPreface))
+	return peeked, err == nil && string(b) == grpcPreface
+}
+
+// HandListener is a net.Listener accepting the connections handed to it, the
+// gRPC ones told from the others by a server accepting them all.
+type HandListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewHandListener returns a HandListener whose address is addr.
+func NewHandListener(addr net.Addr) *HandListener {
+	return &HandListener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+// Hand hands conn to the server accepting from l. It closes conn if l is
+// closed.
+func (l *HandListener) Hand(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Accept returns the next connection handed to l.
+func (l *HandListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errors.New("listener closed")
+	}
+}
+
+// Close closes l, the connections handed to it are left to their server.
+func (l *HandListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr returns the address of l.
+func (l *HandListener) Addr() net.Addr {
+	return l.addr
+}
+
+// StopGRPC stops s gracefully, letting the calls in flight be answered, and
+// waits up to timeout for it before stopping s anyway. It returns whether s
+// stopped gracefully.
+func StopGRPC(s *grpc.Server, timeout time.Duration) bool {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return true
+	case <-time.After(timeout):
+		s.Stop()
+		return false
+	}
+}
+
+// GRPCMaxMsgSize is the largest gRPC message, a chunk and the fields around
+// it.
+const GRPCMaxMsgSize = gfs.MaxChunkSize + 1<<20
+
+// NewGRPCServer returns a gRPC server taking messages up to GRPCMaxMsgSize.
+func NewGRPCServer() *grpc.Server {
+	return grpc.NewServer(grpc.MaxRecvMsgSize(GRPCMaxMsgSize), grpc.MaxSendMsgSize(GRPCMaxMsgSize))
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"gfs"
+)
+
+// A client on the host of a chunkserver reads its replicas from their chunk
+// files, the chunkserver passing them on a unix socket, rather than by rpc.
+
+// LocalReadSocket returns the unix socket in dir of the chunkserver at addr
+// for short-circuit reads.
+func LocalReadSocket(dir string, addr gfs.ServerAddress) string {
+	return filepath.Join(dir, "gfs-"+strings.NewReplacer(":", "_", "/", "_").Replace(string(addr))+".sock")
+}
+
+// SendFile sends reply on conn, with f if it is not nil.
+func SendFile(conn *net.UnixConn, reply interface{}, f *os.File) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(reply); err != nil {
+		return err
+	}
+	var oob []byte
+	if f != nil {
+		oob = syscall.UnixRights(int(f.Fd()))
+	}
+	_, _, err := conn.WriteMsgUnix(buf.Bytes(), oob, nil)
+	return err
+}
+
+// RecvFile receives a reply sent by SendFile into reply, and the file sent
+// with it, nil if none is.
+func RecvFile(conn *net.UnixConn, reply interface{}) (*os.File, error) {
+	buf, oob := make([]byte, 4096), make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+	var f *os.File
+	if oobn > 0 {
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil || len(msgs) != 1 {
+			return nil, fmt.Errorf("invalid control message of %v bytes: %v", oobn, err)
+		}
+		fds, err := syscall.ParseUnixRights(&msgs[0])
+		if err != nil || len(fds) != 1 {
+			return nil, fmt.Errorf("invalid rights passed: %v", err)
+		}
+		f = os.NewFile(uintptr(fds[0]), "chunk")
+	}
+	if err := gob.NewDecoder(bytes.NewReader(buf[:n])).Decode(reply); err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,284 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+
+	"gfs"
+)
+
+// Logs are written by the standard logger of logrus, and by a logger per
+// subsystem, e.g. the heartbeats or the replication of chunks, whose level is
+// set apart so that a busy subsystem can be quieted and another one made
+// verbose. They share the format and the output set by SetupLogging.
+
+// subsystems of the servers
+const (
+	LogHeartbeat   = "heartbeat"   // registrations and heartbeats of chunkservers
+	LogReplication = "replication" // re-replication, repairs and copies of chunks
+)
+
+// LogConfig is the configuration of the logs of a process.
+type LogConfig struct {
+	Level      log.Level            // of the logs of no subsystem
+	Levels     map[string]log.Level // by subsystem, Level for the others
+	Format     string               // "text" or "json"
+	File       string               // rotated past MaxBytes, stderr if empty
+	MaxBytes   int64
+	MaxBackups int // rotated files kept, the older ones are removed
+}
+
+// DefaultLogConfig is the configuration of the logs of the daemons if the
+// environment sets none.
+var DefaultLogConfig = LogConfig{
+	Level:      log.DebugLevel,
+	Format:     "text",
+	MaxBytes:   gfs.LogMaxBytes,
+	MaxBackups: gfs.LogMaxBackups,
+}
+
+// LogConfigFromEnv returns DefaultLogConfig overridden by $GFS_LOG_LEVEL,
+// $GFS_LOG_LEVELS (e.g. heartbeat=warning,replication=debug),
+// $GFS_LOG_FORMAT, $GFS_LOG_FILE, $GFS_LOG_MAX_BYTES and
+// $GFS_LOG_MAX_BACKUPS.
+func LogConfigFromEnv() (LogConfig, error) {
+	c := DefaultLogConfig
+	var err error
+	if s := os.Getenv("GFS_LOG_LEVEL"); s != "" {
+		if c.Level, err = log.ParseLevel(s); err != nil {
+			return c, err
+		}
+	}
+	if s := os.Getenv("GFS_LOG_LEVELS"); s != "" {
+		if c.Levels, err = ParseLogLevels(s); err != nil {
+			return c, err
+		}
+	}
+	if s := os.Getenv("GFS_LOG_FORMAT"); s != "" {
+		c.Format = s
+	}
+	c.File = os.Getenv("GFS_LOG_FILE")
+	if s := os.Getenv("GFS_LOG_MAX_BYTES"); s != "" {
+		if c.MaxBytes, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return c, fmt.Errorf("bad $GFS_LOG_MAX_BYTES: %v", err)
+		}
+	}
+	if s := os.Getenv("GFS_LOG_MAX_BACKUPS"); s != "" {
+		if c.MaxBackups, err = strconv.Atoi(s); err != nil {
+			return c, fmt.Errorf("bad $GFS_LOG_MAX_BACKUPS: %v", err)
+		}
+	}
+	return c, nil
+}
+
+// ParseLogLevels parses levels by subsystem as subsystem=level, separated
+// by commas.
+func ParseLogLevels(s string) (map[string]log.Level, error) {
+	levels := make(map[string]log.Level)
+	for _, kv := range strings.Split(s, ",") {
+		name, level, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("bad log level %q, subsystem=level expected", kv)
+		}
+		l, err := log.ParseLevel(level)
+		if err != nil {
+			return nil, err
+		}
+		levels[name] = l
+	}
+	return levels, nil
+}
+
+var logging struct {
+	sync.Mutex
+	config     *LogConfig // nil until SetupLogging is called
+	out        io.Writer
+	formatter  log.Formatter
+	subsystems map[string]*log.Logger
+}
+
+// SetupLogging configures the standard logger and the ones of the
+// subsystems. The file of the logs, if any, is returned to be closed once
+// the process is done logging.
+func SetupLogging(c LogConfig) (io.Closer, error) {
+	var formatter log.Formatter
+	switch c.Format {
+	case "", "text":
+		formatter = &log.TextFormatter{FullTimestamp: true}
+	case "json":
+		formatter = &log.JSONFormatter{}
+	default:
+		return nil, fmt.Errorf("unknown log format %q, text or json expected", c.Format)
+	}
+	var out io.Writer = os.Stderr
+	var f *RotatingFile
+	if c.File != "" {
+		var err error
+		if f, err = OpenRotatingFile(c.File, c.MaxBytes, c.MaxBackups); err != nil {
+			return nil, err
+		}
+		out = f
+	}
+
+	logging.Lock()
+	defer logging.Unlock()
+	logging.config, logging.out, logging.formatter = &c, out, formatter
+	log.SetLevel(c.Level)
+	log.SetFormatter(formatter)
+	log.SetOutput(out)
+	for name, l := range logging.subsystems {
+		configure(name, l)
+	}
+	if f == nil {
+		return nopCloser{}, nil
+	}
+	return f, nil
+}
+
+// SetLogLevels changes the levels of the standard logger and of the
+// subsystems, keeping the format and the output set up by SetupLogging, e.g.
+// once the configuration is reloaded.
+func SetLogLevels(level log.Level, levels map[string]log.Level) {
+	logging.Lock()
+	defer logging.Unlock()
+	log.SetLevel(level)
+	if logging.config == nil {
+		return
+	}
+	c := *logging.config
+	c.Level, c.Levels = level, levels
+	logging.config = &c
+	for name, l := range logging.subsystems {
+		configure(name, l)
+	}
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// configure sets up the logger of a subsystem from logging, which is locked.
+func configure(name string, l *log.Logger) {
+	level, ok := logging.config.Levels[name]
+	if !ok {
+		level = logging.config.Level
+	}
+	l.SetLevel(level)
+	l.SetFormatter(logging.formatter)
+	l.SetOutput(logging.out)
+}
+
+// Subsystem returns the logger of a subsystem, adding its name as the
+// subsystem field. Until SetupLogging is called, it logs at the level of
+// the standard logger.
+func Subsystem(name string) *log.Entry {
+	logging.Lock()
+	defer logging.Unlock()
+	if logging.subsystems == nil {
+		logging.subsystems = make(map[string]*log.Logger)
+	}
+	l, ok := logging.subsystems[name]
+	if !ok {
+		l = log.New()
+		logging.subsystems[name] = l
+		if logging.config != nil {
+			configure(name, l)
+		}
+	}
+	if logging.config == nil {
+		l.SetLevel(log.GetLevel())
+	}
+	return l.WithField("subsystem", name)
+}
+
+// RotatingFile is a log file renamed to <name>.1 once it is maxBytes long,
+// shifting the older ones up to <name>.<maxBackups>, and opened anew. It is
+// safe for concurrent use.
+type RotatingFile struct {
+	mu         sync.Mutex
+	name       string
+	maxBytes   int64
+	maxBackups int
+	f          *os.File
+	size       int64
+}
+
+// OpenRotatingFile opens name to append to it, with no rotation if maxBytes
+// is not positive.
+func OpenRotatingFile(name string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{name: name, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// Write appends b to the file, rotating the file first if b would take it
+// past its size cap. A line is never split across files.
+func (r *RotatingFile) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.maxBytes > 0 && r.size > 0 && r.size+int64(len(b)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups, removing the oldest one, and opens a new file.
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+	if r.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%v.%v", r.name, r.maxBackups))
+		for i := r.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%v.%v", r.name, i), fmt.Sprintf("%v.%v", r.name, i+1))
+		}
+		if err := os.Rename(r.name, r.name+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.name); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// Close closes the file, following writes fail.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
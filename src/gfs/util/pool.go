@@ -0,0 +1,252 @@
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/rpc"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gfs"
+)
+
+// connPool keeps idle rpc connections keyed by server address so that
+// heartbeats, client operations and replication reuse persistent TCP
+// connections instead of dialing for every call.
+type connPool struct {
+	sync.Mutex
+	idle        map[gfs.ServerAddress][]*pooledConn
+	maxIdle     int           // max idle connections per server
+	idleTimeout time.Duration // idle connections older than this are closed
+	tls         *tls.Config   // connections are dialed with, plaintext if nil
+}
+
+// pooledConn is an rpc client together with the health of its underlying
+// connection. The rpc client reads its connection all the time, so a
+// connection closed by the peer is detected without sending anything.
+type pooledConn struct {
+	*rpc.Client
+	conn     *healthConn
+	lastUsed time.Time
+}
+
+// healthConn marks itself broken on the first read or write error.
+type healthConn struct {
+	net.Conn
+	broken int32
+}
+
+func (c *healthConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		atomic.StoreInt32(&c.broken, 1)
+	}
+	return n, err
+}
+
+func (c *healthConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if err != nil {
+		atomic.StoreInt32(&c.broken, 1)
+	}
+	return n, err
+}
+
+func (c *healthConn) healthy() bool {
+	return atomic.LoadInt32(&c.broken) == 0
+}
+
+// call invokes the rpc and waits for it to complete or ctx to be done.
+// On cancellation the connection is closed, which aborts the in-flight request.
+func (pc *pooledConn) call(ctx context.Context, rpcname string, args interface{}, reply interface{}) error {
+	c := pc.Go(rpcname, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-c.Done:
+		return c.Error
+	case <-ctx.Done():
+		atomic.StoreInt32(&pc.conn.broken, 1)
+		pc.Close()
+		<-c.Done // reply is no longer touched afterwards
+		return ctx.Err()
+	}
+}
+
+var pool = newConnPool(gfs.RPCMaxIdleConns, gfs.RPCIdleConnTimeout, gfs.RPCPoolCheckInterval)
+
+// newConnPool returns a connPool. Idle connections are health checked every tick.
+func newConnPool(maxIdle int, idleTimeout, tick time.Duration) *connPool {
+	p := &connPool{
+		idle:        make(map[gfs.ServerAddress][]*pooledConn),
+		maxIdle:     maxIdle,
+		idleTimeout: idleTimeout,
+	}
+
+	// health check
+	go func() {
+		ticker := time.Tick(tick)
+		for {
+			<-ticker
+			p.sweep()
+		}
+	}()
+
+	return p
+}
+
+// dial opens a new rpc connection to srv.
+func (p *connPool) dial(ctx context.Context, srv gfs.ServerAddress) (*pooledConn, error) {
+	conn, err := p.dialConn(ctx, srv)
+	if err != nil {
+		return nil, err
+	}
+	hc := &healthConn{Conn: conn}
+	return &pooledConn{Client: rpc.NewClient(hc), conn: hc}, nil
+}
+
+// dialConn opens a new connection to srv, with keepalive enabled if it is a
+// tcp one, over TLS if the pool has a config.
+func (p *connPool) dialConn(ctx context.Context, srv gfs.ServerAddress) (net.Conn, error) {
+	d := net.Dialer{Timeout: gfs.RPCDialTimeout, KeepAlive: gfs.RPCKeepAlive}
+	network, address := srv.Network()
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	p.Lock()
+	config := p.tls
+	p.Unlock()
+	if config != nil {
+		tc := tls.Client(conn, clientTLS(config, srv))
+		hctx, cancel := context.WithTimeout(ctx, gfs.RPCDialTimeout)
+		err := tc.HandshakeContext(hctx)
+		cancel()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+	return conn, nil
+}
+
+// get returns a healthy idle connection to srv, or dials a new one.
+// reused is set if the connection comes from the pool.
+func (p *connPool) get(ctx context.Context, srv gfs.ServerAddress) (pc *pooledConn, reused bool, err error) {
+	now := time.Now()
+	p.Lock()
+	for list := p.idle[srv]; len(list) > 0; list = p.idle[srv] {
+		pc = list[len(list)-1]
+		p.idle[srv] = list[:len(list)-1]
+		if pc.conn.healthy() && pc.lastUsed.Add(p.idleTimeout).After(now) {
+			p.Unlock()
+			return pc, true, nil
+		}
+		pc.Close()
+	}
+	p.Unlock()
+
+	pc, err = p.dial(ctx, srv)
+	return pc, false, err
+}
+
+// put returns a connection to the pool, or closes it if it is broken
+// or there are already enough idle connections to srv.
+func (p *connPool) put(srv gfs.ServerAddress, pc *pooledConn) {
+	if !pc.conn.healthy() {
+		pc.Close()
+		return
+	}
+	pc.lastUsed = time.Now()
+
+	p.Lock()
+	defer p.Unlock()
+	if len(p.idle[srv]) >= p.maxIdle {
+		pc.Close()
+		return
+	}
+	p.idle[srv] = append(p.idle[srv], pc)
+}
+
+// sweep closes idle connections that are broken or expired.
+func (p *connPool) sweep() {
+	now := time.Now()
+	p.Lock()
+	defer p.Unlock()
+	for srv, list := range p.idle {
+		var alive []*pooledConn
+		for _, pc := range list {
+			if pc.conn.healthy() && pc.lastUsed.Add(p.idleTimeout).After(now) {
+				alive = append(alive, pc)
+			} else {
+				pc.Close()
+			}
+		}
+		if len(alive) == 0 {
+			delete(p.idle, srv)
+		} else {
+			p.idle[srv] = alive
+		}
+	}
+}
+
+// closeIdle closes all idle connections.
+func (p *connPool) closeIdle() {
+	p.Lock()
+	defer p.Unlock()
+	for srv, list := range p.idle {
+		for _, pc := range list {
+			pc.Close()
+		}
+		delete(p.idle, srv)
+	}
+}
+
+// call performs an rpc on a pooled connection. If a reused connection turns
+// out to be shut down before the request is sent, it re-dials once.
+func (p *connPool) call(ctx context.Context, srv gfs.ServerAddress, rpcname string, args interface{}, reply interface{}) error {
+	pc, reused, err := p.get(ctx, srv)
+	if err != nil {
+		return err
+	}
+
+	err = pc.call(ctx, rpcname, args, reply)
+	if err == rpc.ErrShutdown && reused {
+		pc.Close()
+		pc, err = p.dial(ctx, srv)
+		if err != nil {
+			return err
+		}
+		err = pc.call(ctx, rpcname, args, reply)
+	}
+
+	if _, ok := err.(rpc.ServerError); err == nil || ok {
+		p.put(srv, pc)
+	} else {
+		pc.Close()
+	}
+	return err
+}
+
+// CloseIdleConnections closes all idle pooled rpc and stream connections.
+func CloseIdleConnections() {
+	pool.closeIdle()
+	streams.closeIdle()
+}
+
+// Listen listens on addr, a tcp or a unix one. The socket file of a unix
+// address is removed if no server is serving it any more, and again once the
+// listener is closed.
+func Listen(addr gfs.ServerAddress) (net.Listener, error) {
+	network, address := addr.Network()
+	if network == "unix" {
+		if conn, err := net.DialTimeout(network, address, gfs.RPCDialTimeout); err == nil {
+			conn.Close()
+		} else {
+			os.Remove(address)
+		}
+	}
+	return net.Listen(network, address)
+}
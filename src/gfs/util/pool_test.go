@@ -0,0 +1,144 @@
+package util
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"gfs"
+)
+
+type echo int
+
+func (echo) Echo(arg string, reply *string) error {
+	*reply = arg
+	return nil
+}
+
+// serveEcho serves echo rpcs on a local port, handing each accepted
+// connection to conns.
+func serveEcho(t *testing.T) (gfs.ServerAddress, chan net.Conn) {
+	s := rpc.NewServer()
+	s.RegisterName("Echo", echo(0))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	conns := make(chan net.Conn, 8)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go s.ServeConn(conn)
+		}
+	}()
+	return gfs.ServerAddress(l.Addr().String()), conns
+}
+
+func callEcho(t *testing.T, p *connPool, srv gfs.ServerAddress) {
+	var reply string
+	if err := p.call(context.Background(), srv, "Echo.Echo", "hi", &reply); err != nil || reply != "hi" {
+		t.Error("expect hi, got", reply, err)
+	}
+}
+
+// calls to a server reuse its idle connection
+func TestConnPoolReuse(t *testing.T) {
+	srv, conns := serveEcho(t)
+	p := newConnPool(2, time.Minute, time.Hour)
+	defer p.closeIdle()
+
+	callEcho(t, p, srv)
+	callEcho(t, p, srv)
+	if len(conns) != 1 {
+		t.Error("expect a single connection dialed, got", len(conns))
+	}
+	if len(p.idle[srv]) != 1 {
+		t.Error("expect the connection back in the pool, got", len(p.idle[srv]))
+	}
+
+	pc, reused, err := p.get(context.Background(), srv)
+	if err != nil || !reused {
+		t.Error("expect the idle connection reused, got", reused, err)
+	}
+	p.put(srv, pc)
+}
+
+// a connection closed by the server is dropped from the pool and a new one
+// dialed, an expired one as well
+func TestConnPoolBroken(t *testing.T) {
+	srv, conns := serveEcho(t)
+	p := newConnPool(2, time.Minute, time.Hour)
+	defer p.closeIdle()
+
+	callEcho(t, p, srv)
+	pc := p.idle[srv][0]
+	(<-conns).Close()
+	for deadline := time.Now().Add(time.Second); pc.conn.healthy() && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pc.conn.healthy() {
+		t.Fatal("expect the connection closed by the server marked broken")
+	}
+
+	callEcho(t, p, srv)
+	if len(conns) != 1 {
+		t.Error("expect a new connection dialed, got", len(conns))
+	}
+	if list := p.idle[srv]; len(list) != 1 || list[0] == pc {
+		t.Error("expect the broken connection replaced in the pool, got", list)
+	}
+
+	p.idle[srv][0].lastUsed = time.Now().Add(-time.Hour)
+	p.sweep()
+	if _, ok := p.idle[srv]; ok {
+		t.Error("expect the expired connection swept, got", p.idle[srv])
+	}
+	callEcho(t, p, srv)
+	if len(conns) != 2 {
+		t.Error("expect a new connection dialed after the sweep, got", len(conns))
+	}
+}
+
+// no more than maxIdle connections to a server are kept idle
+func TestConnPoolMaxIdle(t *testing.T) {
+	srv, conns := serveEcho(t)
+	other, _ := serveEcho(t)
+	p := newConnPool(2, time.Minute, time.Hour)
+	defer p.closeIdle()
+
+	var pcs []*pooledConn
+	for i := 0; i < 3; i++ {
+		pc, reused, err := p.get(context.Background(), srv)
+		if err != nil || reused {
+			t.Fatal("expect a new connection dialed, got", reused, err)
+		}
+		pcs = append(pcs, pc)
+	}
+	for _, pc := range pcs {
+		p.put(srv, pc)
+	}
+	if len(p.idle[srv]) != 2 {
+		t.Error("expect 2 idle connections kept, got", len(p.idle[srv]))
+	}
+	if len(conns) != 3 {
+		t.Error("expect 3 connections dialed, got", len(conns))
+	}
+	var reply string
+	if err := pcs[2].call(context.Background(), "Echo.Echo", "hi", &reply); err != rpc.ErrShutdown {
+		t.Error("expect the connection over the limit closed, got", err)
+	}
+
+	// the limit is per server
+	callEcho(t, p, other)
+	if len(p.idle[other]) != 1 || len(p.idle[srv]) != 2 {
+		t.Error("expect 1 and 2 idle connections, got", len(p.idle[other]), len(p.idle[srv]))
+	}
+}
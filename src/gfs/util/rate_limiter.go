@@ -0,0 +1,104 @@
+package util
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket allowing rate events per second on average,
+// in bursts of up to max(rate, 1) events. A rate of 0 allows everything.
+type RateLimiter struct {
+	sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter with a full bucket.
+func NewRateLimiter(rate float64) *RateLimiter {
+	return &RateLimiter{rate: rate, tokens: math.Max(rate, 1), last: time.Now()}
+}
+
+// Allow takes a token, it returns false if there is none left.
+func (l *RateLimiter) Allow() bool {
+	l.Lock()
+	defer l.Unlock()
+	if l.rate <= 0 {
+		return true
+	}
+	l.refill()
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// AllowN takes n tokens, it returns false if the bucket is in debt. The
+// tokens left may go below 0, so that events larger than a burst are allowed
+// and the following ones wait for the debt to be paid back.
+func (l *RateLimiter) AllowN(n float64) bool {
+	l.Lock()
+	defer l.Unlock()
+	if l.rate <= 0 {
+		return true
+	}
+	l.refill()
+	if l.tokens < 0 {
+		return false
+	}
+	l.tokens -= n
+	return true
+}
+
+// WaitN takes n tokens, going into debt if there are fewer left, and waits
+// until the debt is paid back or ctx is done.
+func (l *RateLimiter) WaitN(ctx context.Context, n float64) error {
+	l.Lock()
+	var wait time.Duration
+	if l.rate > 0 {
+		l.refill()
+		l.tokens -= n
+		if l.tokens < 0 {
+			wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+		}
+	}
+	l.Unlock()
+	if wait == 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Full reports whether the bucket is full, i.e. the limiter is as good as
+// a new one.
+func (l *RateLimiter) Full() bool {
+	l.Lock()
+	defer l.Unlock()
+	l.refill()
+	return l.rate <= 0 || l.tokens >= math.Max(l.rate, 1)
+}
+
+// refill adds the tokens due since the last refill. l should be locked.
+func (l *RateLimiter) refill() {
+	now := time.Now()
+	l.tokens = math.Min(l.tokens+now.Sub(l.last).Seconds()*l.rate, math.Max(l.rate, 1))
+	l.last = now
+}
+
+// SetRate changes the rate, the tokens left are kept up to the new burst.
+func (l *RateLimiter) SetRate(rate float64) {
+	l.Lock()
+	defer l.Unlock()
+	l.rate = rate
+	l.tokens = math.Min(l.tokens, math.Max(rate, 1))
+}
@@ -0,0 +1,301 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"time"
+
+	"gfs"
+)
+
+// A data stream carries the payload of a data call, the data pushed to
+// chunkservers, read from them or copied between them, as raw bytes rather
+// than inside a gob message of net/rpc, which is buffered and copied whole on
+// both ends. Stream connections are told from rpc ones by their first byte,
+// 0, which never begins a gob message, so both are served on the same port.
+// A call sends a header with the method and the length of its body, the
+// body, then the args, and gets a header with an error or the length of the
+// body of the reply, the reply, then its body. The calls of a connection are
+// made one after the other, and connections are reused as rpc ones are.
+
+const streamMagic = "\x00gfs-stream"
+
+// streamHeader begins a call, with Method, or its reply, with Error.
+type streamHeader struct {
+	Method string
+	Error  string
+	Length int64 // of the body
+}
+
+// StreamHandler serves a stream method. decode decodes the args of the call,
+// body is its body. It returns the reply and the body of the reply.
+type StreamHandler func(decode func(args interface{}) error, body []byte) (reply interface{}, replyBody []byte, err error)
+
+// StreamServer serves the stream methods registered with Handle.
+type StreamServer struct {
+	mu       sync.RWMutex
+	handlers map[string]StreamHandler
+}
+
+// NewStreamServer returns a StreamServer with no method.
+func NewStreamServer() *StreamServer {
+	return &StreamServer{handlers: make(map[string]StreamHandler)}
+}
+
+// Handle registers the handler of a stream method.
+func (s *StreamServer) Handle(method string, h StreamHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method] = h
+}
+
+// IsStream returns conn, as accepted and wrapped by ServerTLS, and whether it
+// carries streams rather than rpcs. It blocks reading the first byte of the
+// peer, so it should be called by the goroutine serving conn.
+func IsStream(conn net.Conn) (net.Conn, bool) {
+	r := bufio.NewReader(conn)
+	b, err := r.Peek(1)
+	return &peekedConn{conn, r}, err == nil && b[0] == streamMagic[0]
+}
+
+// ServeConn serves the calls of a stream connection until the peer closes it
+// or breaks the protocol.
+func (s *StreamServer) ServeConn(conn io.ReadWriter) {
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	magic := make([]byte, len(streamMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != streamMagic {
+		return
+	}
+	dec, enc := gob.NewDecoder(r), gob.NewEncoder(w)
+	for {
+		var h streamHeader
+		if err := dec.Decode(&h); err != nil {
+			return
+		}
+		if h.Length < 0 || h.Length > gfs.MaxChunkSize {
+			return
+		}
+		body := make([]byte, h.Length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+
+		s.mu.RLock()
+		handler, ok := s.handlers[h.Method]
+		s.mu.RUnlock()
+		decoded := false
+		decode := func(args interface{}) error {
+			decoded = true
+			return dec.Decode(args)
+		}
+		var reply interface{}
+		var replyBody []byte
+		err := fmt.Errorf("stream method %v not found", h.Method)
+		if ok {
+			reply, replyBody, err = handler(decode, body)
+		}
+		if !decoded && dec.DecodeValue(reflect.Value{}) != nil { // the args are discarded
+			return
+		}
+
+		if err != nil {
+			err = enc.Encode(streamHeader{Error: err.Error()})
+		} else if err = enc.Encode(streamHeader{Length: int64(len(replyBody))}); err == nil {
+			if err = enc.Encode(reply); err == nil {
+				_, err = w.Write(replyBody)
+			}
+		}
+		if err != nil || w.Flush() != nil {
+			return
+		}
+	}
+}
+
+// streamConn is a stream connection of a client.
+type streamConn struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	w        *bufio.Writer
+	dec      *gob.Decoder
+	enc      *gob.Encoder
+	lastUsed time.Time
+}
+
+// call makes a call on sc, which is broken if it returns an error other
+// than an rpc.ServerError. answered is set once the reply header was read.
+func (sc *streamConn) call(ctx context.Context, method string, args interface{}, body []byte, reply interface{}, recv []byte) (n int, answered bool, err error) {
+	// a done ctx aborts the reads and writes in flight
+	deadline, _ := ctx.Deadline()
+	sc.conn.SetDeadline(deadline)
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			sc.conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	if err = sc.enc.Encode(streamHeader{Method: method, Length: int64(len(body))}); err != nil {
+		return
+	}
+	if _, err = sc.w.Write(body); err != nil {
+		return
+	}
+	if err = sc.enc.Encode(args); err != nil {
+		return
+	}
+	if err = sc.w.Flush(); err != nil {
+		return
+	}
+
+	var h streamHeader
+	if err = sc.dec.Decode(&h); err != nil {
+		return
+	}
+	answered = true
+	if h.Error != "" {
+		return 0, answered, rpc.ServerError(h.Error)
+	}
+	if err = sc.dec.Decode(reply); err != nil {
+		return
+	}
+	if h.Length > int64(len(recv)) {
+		return 0, answered, fmt.Errorf("reply of %v has %v bytes, %v expected at most", method, h.Length, len(recv))
+	}
+	if _, err = io.ReadFull(sc.r, recv[:h.Length]); err != nil {
+		return
+	}
+	return int(h.Length), answered, nil
+}
+
+// streamPool keeps idle stream connections by server address.
+type streamPool struct {
+	sync.Mutex
+	idle map[gfs.ServerAddress][]*streamConn
+}
+
+var streams = &streamPool{idle: make(map[gfs.ServerAddress][]*streamConn)}
+
+// get returns an idle stream connection to srv, or dials a new one. reused
+// is set if the connection comes from the pool.
+func (p *streamPool) get(ctx context.Context, srv gfs.ServerAddress) (sc *streamConn, reused bool, err error) {
+	now := time.Now()
+	p.Lock()
+	for list := p.idle[srv]; len(list) > 0; list = p.idle[srv] {
+		sc = list[len(list)-1]
+		p.idle[srv] = list[:len(list)-1]
+		if sc.lastUsed.Add(gfs.RPCIdleConnTimeout).After(now) {
+			p.Unlock()
+			return sc, true, nil
+		}
+		sc.conn.Close()
+	}
+	p.Unlock()
+
+	conn, err := pool.dialConn(ctx, srv)
+	if err != nil {
+		return nil, false, err
+	}
+	sc = &streamConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	sc.dec, sc.enc = gob.NewDecoder(sc.r), gob.NewEncoder(sc.w)
+	if _, err := sc.w.WriteString(streamMagic); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	return sc, false, nil
+}
+
+// put returns a connection to the pool, or closes it if there are already
+// enough idle connections to srv.
+func (p *streamPool) put(srv gfs.ServerAddress, sc *streamConn) {
+	sc.lastUsed = time.Now()
+	p.Lock()
+	defer p.Unlock()
+	if len(p.idle[srv]) >= gfs.RPCMaxIdleConns {
+		sc.conn.Close()
+		return
+	}
+	p.idle[srv] = append(p.idle[srv], sc)
+}
+
+// drop closes the idle stream connections to srv.
+func (p *streamPool) drop(srv gfs.ServerAddress) {
+	p.Lock()
+	defer p.Unlock()
+	for _, sc := range p.idle[srv] {
+		sc.conn.Close()
+	}
+	delete(p.idle, srv)
+}
+
+// closeIdle closes all idle stream connections.
+func (p *streamPool) closeIdle() {
+	p.Lock()
+	defer p.Unlock()
+	for srv, list := range p.idle {
+		for _, sc := range list {
+			sc.conn.Close()
+		}
+		delete(p.idle, srv)
+	}
+}
+
+// CallStream calls the stream method of srv with args and body, and decodes
+// the reply into reply and its body into recv, returning the bytes of the
+// body. It is bounded by gfs.RPCTimeout and returns errors as Call does. If
+// a reused connection turns out to be closed before the reply, the idle
+// connections to srv are dropped as well, as the server may have restarted,
+// and a call of ReadChunk is made again on a new one. Other calls are not
+// idempotent, the data they pushed may have been taken, so they fail.
+func CallStream(ctx context.Context, srv gfs.ServerAddress, method string, args interface{}, body []byte, reply interface{}, recv []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, gfs.RPCTimeout)
+	defer cancel()
+	if err := injectFault(ctx, srv, method); err != nil {
+		return 0, err
+	}
+	sc, reused, err := streams.get(ctx, srv)
+	if err != nil {
+		return 0, err
+	}
+
+	n, answered, err := sc.call(ctx, method, args, body, reply, recv)
+	if err != nil && !answered && reused && ctx.Err() == nil {
+		sc.conn.Close()
+		streams.drop(srv)
+		if method != "ChunkServer.RPCReadChunk" {
+			return 0, err
+		}
+		if sc, _, err = streams.get(ctx, srv); err != nil {
+			return 0, err
+		}
+		n, _, err = sc.call(ctx, method, args, body, reply, recv)
+	}
+
+	if se, ok := err.(rpc.ServerError); err == nil || ok {
+		streams.put(srv, sc)
+		if ok {
+			if e, ok := gfs.ParseError(string(se)); ok {
+				return 0, e
+			}
+		}
+	} else {
+		sc.conn.Close()
+	}
+	return n, err
+}
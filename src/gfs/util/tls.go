@@ -0,0 +1,130 @@
+package util
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"gfs"
+)
+
+// LoadTLSConfig returns the config of mutual TLS with the certificate and
+// key in PEM files. Peers are verified against the CAs in caFile, both as
+// servers and as clients.
+func LoadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	cas := x509.NewCertPool()
+	if !cas.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate in CA file %v", caFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      cas,
+		ClientCAs:    cas,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// TLSConfigFromEnv loads the config of mutual TLS from the files named by
+// $GFS_TLS_CERT, $GFS_TLS_KEY and $GFS_TLS_CA. It returns nil if they are
+// unset, and whether $GFS_TLS_PLAINTEXT=1 asks servers to accept plaintext
+// too.
+func TLSConfigFromEnv() (config *tls.Config, plaintext bool, err error) {
+	plaintext = os.Getenv("GFS_TLS_PLAINTEXT") == "1"
+	cert, key, ca := os.Getenv("GFS_TLS_CERT"), os.Getenv("GFS_TLS_KEY"), os.Getenv("GFS_TLS_CA")
+	if cert == "" && key == "" && ca == "" {
+		return nil, plaintext, nil
+	}
+	config, err = LoadTLSConfig(cert, key, ca)
+	return config, plaintext, err
+}
+
+// SetTLSConfig sets the TLS config rpcs are dialed with, nil dials them in
+// plaintext. Idle connections are closed, so that the following rpcs dial
+// again.
+func SetTLSConfig(config *tls.Config) {
+	pool.Lock()
+	pool.tls = config
+	pool.Unlock()
+	pool.closeIdle()
+	streams.closeIdle()
+}
+
+// clientTLS returns config to dial srv with, its server name set to the host
+// of srv, or to localhost if srv has no host.
+func clientTLS(config *tls.Config, srv gfs.ServerAddress) *tls.Config {
+	if config.ServerName != "" {
+		return config
+	}
+	host, _, err := net.SplitHostPort(string(srv))
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	config = config.Clone()
+	config.ServerName = host
+	return config
+}
+
+// ServerTLS is the TLS config of the connections a server accepts. The zero
+// value accepts plaintext. It is safe for concurrent use.
+type ServerTLS struct {
+	mu        sync.RWMutex
+	config    *tls.Config
+	plaintext bool // accepted besides TLS
+}
+
+// Set sets the TLS config connections are accepted with, nil accepts
+// plaintext. If plaintext is set, peers not beginning a TLS handshake are
+// accepted in plaintext too, e.g. in dev clusters or while TLS is rolled out.
+func (s *ServerTLS) Set(config *tls.Config, plaintext bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+	s.plaintext = plaintext
+}
+
+// Wrap returns conn, as accepted, as the server side of TLS if a config is
+// set. It may block reading the first byte of the peer, so it should be
+// called by the goroutine serving conn.
+func (s *ServerTLS) Wrap(conn net.Conn) net.Conn {
+	s.mu.RLock()
+	config, plaintext := s.config, s.plaintext
+	s.mu.RUnlock()
+	if config == nil {
+		return conn
+	}
+	if !plaintext {
+		return tls.Server(conn, config)
+	}
+
+	// a TLS connection begins with a handshake record
+	r := bufio.NewReader(conn)
+	b, err := r.Peek(1)
+	peeked := &peekedConn{conn, r}
+	if err == nil && b[0] == 0x16 {
+		return tls.Server(peeked, config)
+	}
+	return peeked
+}
+
+// peekedConn is a connection whose first bytes were read into r.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,142 @@
+package util
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"gfs"
+)
+
+// A trace follows an operation of a client across the rpcs it makes and the
+// ones the servers make for it, e.g. an append through the push of its data
+// along the chain, the mutation of the primary applied by the secondaries and
+// the lease asked to master. The client picks the id of the trace, carried by
+// its ctx and passed in the Trace field of the args. Every hop logs its work
+// with the id and, if a SpanExporter is set, exports it as a span.
+
+type traceKey struct{}
+
+// NewTraceID returns a random id of 16 bytes in hex, the size of the trace
+// ids of W3C trace context and OpenTelemetry.
+func NewTraceID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithTrace returns ctx carrying the trace id.
+func WithTrace(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceKey{}, id)
+}
+
+// TraceID returns the trace id carried by ctx, or "" if there is none.
+func TraceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceKey{}).(string)
+	return id
+}
+
+// TraceLog returns the logger of the work of a trace, with its id as the
+// trace field.
+func TraceLog(trace string) *log.Entry {
+	if trace == "" {
+		return log.WithFields(log.Fields{})
+	}
+	return log.WithField("trace", trace)
+}
+
+// Span is the work of a hop of a trace.
+type Span struct {
+	Trace    string
+	Name     string            // of the operation or the rpc
+	Server   gfs.ServerAddress // it ran at, empty on a client
+	Start    time.Time
+	Duration time.Duration
+	Error    string `json:",omitempty"`
+}
+
+// SpanExporter receives the spans ended in the process, e.g. to send them
+// to a tracing backend. ExportSpan is called by the goroutine ending the
+// span and should not block.
+type SpanExporter interface {
+	ExportSpan(Span)
+}
+
+var exporter struct {
+	sync.RWMutex
+	e SpanExporter
+}
+
+// SetSpanExporter sets the exporter of the spans of the process, nil stops
+// exporting them.
+func SetSpanExporter(e SpanExporter) {
+	exporter.Lock()
+	exporter.e = e
+	exporter.Unlock()
+}
+
+// StartSpan starts a span of the work name of trace at server. It is ended
+// by calling the returned func with the error of the work. Ended spans are
+// logged at debug level, failed ones at info level and the ones slower than
+// gfs.TraceSlowSpan as warnings. Work of no trace is not recorded.
+func StartSpan(trace string, server gfs.ServerAddress, name string) func(err error) {
+	if trace == "" {
+		return func(error) {}
+	}
+	start := time.Now()
+	return func(err error) {
+		s := Span{Trace: trace, Name: name, Server: server, Start: start, Duration: time.Since(start)}
+		if err != nil {
+			s.Error = err.Error()
+		}
+		l := TraceLog(trace).WithField("span", name).WithField("duration", s.Duration)
+		if server != "" {
+			l = l.WithField("server", server)
+		}
+		if err != nil {
+			l = l.WithError(err)
+		}
+		switch {
+		case s.Duration > gfs.TraceSlowSpan:
+			l.Warning("slow span")
+		case err != nil:
+			l.Info("failed span")
+		default:
+			l.Debug("span")
+		}
+
+		exporter.RLock()
+		e := exporter.e
+		exporter.RUnlock()
+		if e != nil {
+			e.ExportSpan(s)
+		}
+	}
+}
+
+// JSONSpanExporter writes spans as JSON lines, e.g. to a file tailed by an
+// OpenTelemetry collector.
+type JSONSpanExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONSpanExporter returns an exporter writing spans to w.
+func NewJSONSpanExporter(w io.Writer) *JSONSpanExporter {
+	return &JSONSpanExporter{enc: json.NewEncoder(w)}
+}
+
+// ExportSpan writes s as a line of JSON.
+func (e *JSONSpanExporter) ExportSpan(s Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.enc.Encode(s); err != nil {
+		log.Warning("cannot export span: ", err)
+	}
+}
@@ -1,31 +1,47 @@
 package util
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net/rpc"
+	"time"
 
 	"gfs"
 )
 
-// Call is RPC call helper
-func Call(srv gfs.ServerAddress, rpcname string, args interface{}, reply interface{}) error {
-	c, errx := rpc.Dial("tcp", string(srv))
-	if errx != nil {
-		return errx
-	}
-	defer c.Close()
+// Call is RPC call helper, connections are reused through a pool.
+// Every call is bounded by gfs.RPCTimeout in addition to the deadline of ctx.
+// If ctx is done before the reply arrives, the in-flight dial or read is aborted
+// and ctx.Err() is returned. A gfs.Error returned by the handler is returned
+// with its code, other handler errors are returned as rpc.ServerError.
+func Call(ctx context.Context, srv gfs.ServerAddress, rpcname string, args interface{}, reply interface{}) error {
+	return CallTimeout(ctx, gfs.RPCTimeout, srv, rpcname, args, reply)
+}
 
-	err := c.Call(rpcname, args, reply)
+// CallTimeout is Call bounded by timeout instead of gfs.RPCTimeout, for rpcs
+// known to take longer, e.g. the copies of chunks.
+func CallTimeout(ctx context.Context, timeout time.Duration, srv gfs.ServerAddress, rpcname string, args interface{}, reply interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := injectFault(ctx, srv, rpcname); err != nil {
+		return err
+	}
+	err := pool.call(ctx, srv, rpcname, args, reply)
+	if se, ok := err.(rpc.ServerError); ok {
+		if e, ok := gfs.ParseError(string(se)); ok {
+			return e
+		}
+	}
 	return err
 }
 
 // CallAll applies the rpc call to all destinations.
-func CallAll(dst []gfs.ServerAddress, rpcname string, args interface{}) error {
+func CallAll(ctx context.Context, dst []gfs.ServerAddress, rpcname string, args interface{}) error {
 	ch := make(chan error)
 	for _, d := range dst {
 		go func(addr gfs.ServerAddress) {
-			ch <- Call(addr, rpcname, args, nil)
+			ch <- Call(ctx, addr, rpcname, args, nil)
 		}(d)
 	}
 	errList := ""